@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os/signal"
+	"syscall"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/privsep"
+	"github.com/spf13/cobra"
+)
+
+func newAgentCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "agent",
+		Short: "Run the WireGuard interfaces for an unprivileged kubewg on this node",
+		Long: "Runs the privileged half of a split deployment: it holds CAP_NET_ADMIN and\n" +
+			"programs the WireGuard interfaces for a kubewg running with\n" +
+			"privilege_separation.agent_socket set, which needs no capabilities at all.\n" +
+			"The controller connects on a Unix socket, only UIDs passed with\n" +
+			"--allowed-uid may, the agent's own by default. The interfaces stay up when\n" +
+			"the controller restarts.",
+		Args:          cobra.NoArgs,
+		RunE:          runAgent,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	cmd.Flags().String("socket", config.DefaultAgentSocket, "Unix socket to listen on")
+	cmd.Flags().UintSlice("allowed-uid", nil, "UIDs allowed to connect, defaults to the agent's own")
+	return cmd
+}
+
+func runAgent(cmd *cobra.Command, _ []string) error {
+	socket, err := cmd.Flags().GetString("socket")
+	if err != nil {
+		return fmt.Errorf("failed to get socket: %w", err)
+	}
+	uids, err := cmd.Flags().GetUintSlice("allowed-uid")
+	if err != nil {
+		return fmt.Errorf("failed to get allowed-uid: %w", err)
+	}
+	allowed := make([]uint32, 0, len(uids))
+	for _, uid := range uids {
+		allowed = append(allowed, uint32(uid))
+	}
+
+	agent, err := privsep.Listen(socket, allowed)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	slog.Info("Agent listening", "socket", socket)
+	return agent.Serve(ctx)
+}
@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	apiURLFlag      = "api-url"
+	apiTokenFlag    = "api-token"
+	apiCAFileFlag   = "api-ca-file"
+	apiCertFileFlag = "api-cert-file"
+	apiKeyFileFlag  = "api-key-file"
+	apiTokenEnv     = "KUBEWG_API_TOKEN"
+	defaultAPIURL   = "http://127.0.0.1:8080"
+	apiCallTimeout  = 60 * time.Second
+)
+
+var (
+	ErrAPIResponse = errors.New("API request failed")
+	ErrNoCACerts   = errors.New("no certificates found in CA file")
+)
+
+// addAPIFlags registers the flags shared by subcommands that talk to a
+// running instance through the admin API.
+func addAPIFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().String(apiURLFlag, defaultAPIURL, "Base URL of the admin API")
+	cmd.PersistentFlags().String(apiTokenFlag, "", "Bearer token for the admin API, defaults to $"+apiTokenEnv)
+	cmd.PersistentFlags().String(apiCAFileFlag, "", "CA file for verifying the admin API certificate")
+	cmd.PersistentFlags().String(apiCertFileFlag, "", "Client certificate file for mutual TLS")
+	cmd.PersistentFlags().String(apiKeyFileFlag, "", "Client key file for mutual TLS")
+}
+
+func apiHTTPClient(cmd *cobra.Command) (*http.Client, error) {
+	caFile, _ := cmd.Flags().GetString(apiCAFileFlag)
+	certFile, _ := cmd.Flags().GetString(apiCertFileFlag)
+	keyFile, _ := cmd.Flags().GetString(apiKeyFileFlag)
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, ErrNoCACerts
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Client{
+		Timeout: apiCallTimeout,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}, nil
+}
+
+// callAPI sends a request to the admin API and decodes the JSON response
+// into out, if out is not nil.
+func callAPI(cmd *cobra.Command, method, path string, body io.Reader, out interface{}) error {
+	data, err := callAPIRaw(cmd, method, path, body)
+	if err != nil {
+		return err
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to decode API response: %w", err)
+	}
+	return nil
+}
+
+// callAPIRaw sends a request to the admin API and returns the response body
+// as-is.
+func callAPIRaw(cmd *cobra.Command, method, path string, body io.Reader) ([]byte, error) {
+	baseURL, err := cmd.Flags().GetString(apiURLFlag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API URL: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(cmd.Context(), method, strings.TrimSuffix(baseURL, "/")+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	token, _ := cmd.Flags().GetString(apiTokenFlag)
+	if token == "" {
+		token = os.Getenv(apiTokenEnv)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client, err := apiHTTPClient(cmd)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API response: %w", err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(data, &apiErr); err != nil || apiErr.Error == "" {
+			return nil, fmt.Errorf("%w: %s", ErrAPIResponse, resp.Status)
+		}
+		return nil, fmt.Errorf("%w: %s: %s", ErrAPIResponse, resp.Status, apiErr.Error)
+	}
+
+	return data, nil
+}
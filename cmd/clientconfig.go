@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/kubewg-net/container/internal/clientconfig"
+	"github.com/kubewg-net/container/internal/wgquick"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ErrPNGNeedsOutput = errors.New("--output is required for the png format")
+	ErrQRNeedsKey     = errors.New("--private-key-file is required for QR codes, the config would be useless without the key")
+	ErrUnknownFormat  = errors.New("--format must be one of conf, png or ascii")
+)
+
+func newClientConfigCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "client-config <public-key>",
+		Short: "Render the wg-quick config a registered peer uses to connect",
+		Long: "Fetches the client configuration of a registered peer from a running instance.\n" +
+			"The private key is filled in locally from --private-key-file, so it never\n" +
+			"has to be sent to the server, and QR codes are rendered locally after it.\n" +
+			"It requires the admin role, as the config includes the peer's preshared key.",
+		Args:          cobra.ExactArgs(1),
+		RunE:          runClientConfig,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	addAPIFlags(cmd)
+	cmd.Flags().String("format", "conf", "Output format: conf, or a QR code as png or ascii, which needs --private-key-file")
+	cmd.Flags().String("private-key-file", "", "File holding the client's private key")
+	cmd.Flags().StringP("output", "o", "", "Write to this file instead of stdout")
+	return cmd
+}
+
+func runClientConfig(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+	keyFile, _ := cmd.Flags().GetString("private-key-file")
+	output, _ := cmd.Flags().GetString("output")
+
+	switch format {
+	case "conf":
+	case "png", "ascii":
+		if keyFile == "" {
+			return ErrQRNeedsKey
+		}
+		if format == "png" && output == "" {
+			return ErrPNGNeedsOutput
+		}
+	default:
+		return fmt.Errorf("%w, got %q", ErrUnknownFormat, format)
+	}
+
+	data, err := callAPIRaw(cmd, http.MethodGet, "/api/v1/client-config?public_key="+url.QueryEscape(args[0]), nil)
+	if err != nil {
+		return err
+	}
+
+	if keyFile != "" {
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read private key: %w", err)
+		}
+		data = bytes.Replace(data, []byte(wgquick.PrivateKeyPlaceholder), []byte(strings.TrimSpace(string(key))), 1)
+	}
+
+	switch format {
+	case "conf":
+	case "png":
+		data, err = clientconfig.PNG(data, clientconfig.DefaultPNGSize)
+		if err != nil {
+			return err
+		}
+	case "ascii":
+		code, err := clientconfig.ASCII(data)
+		if err != nil {
+			return err
+		}
+		data = []byte(code)
+	}
+
+	if output != "" {
+		if err := os.WriteFile(output, data, 0o600); err != nil {
+			return fmt.Errorf("failed to write %s: %w", output, err)
+		}
+		return nil
+	}
+
+	_, err = cmd.OutOrStdout().Write(data)
+	return err
+}
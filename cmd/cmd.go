@@ -20,19 +20,67 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"log/slog"
+	"maps"
+	"net/netip"
 	"os"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/kubewg-net/container/internal/api"
+	"github.com/kubewg-net/container/internal/audit"
 	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/cryptopolicy"
+	"github.com/kubewg-net/container/internal/dnsproxy"
+	"github.com/kubewg-net/container/internal/endpoint"
+	"github.com/kubewg-net/container/internal/enroll"
+	"github.com/kubewg-net/container/internal/exporter"
+	"github.com/kubewg-net/container/internal/features"
+	"github.com/kubewg-net/container/internal/federation"
+	"github.com/kubewg-net/container/internal/health"
+	"github.com/kubewg-net/container/internal/keystore"
+	"github.com/kubewg-net/container/internal/kube"
+	"github.com/kubewg-net/container/internal/logbuffer"
 	"github.com/kubewg-net/container/internal/metrics"
+	"github.com/kubewg-net/container/internal/oidc"
+	"github.com/kubewg-net/container/internal/peers"
 	"github.com/kubewg-net/container/internal/pprof"
+	"github.com/kubewg-net/container/internal/preflight"
+	"github.com/kubewg-net/container/internal/privsep"
+	"github.com/kubewg-net/container/internal/prober"
+	"github.com/kubewg-net/container/internal/profiling"
+	"github.com/kubewg-net/container/internal/punch"
+	"github.com/kubewg-net/container/internal/reconciler"
+	"github.com/kubewg-net/container/internal/remotewrite"
+	"github.com/kubewg-net/container/internal/sandbox"
+	"github.com/kubewg-net/container/internal/spiffe"
+	"github.com/kubewg-net/container/internal/state"
+	"github.com/kubewg-net/container/internal/stun"
+	"github.com/kubewg-net/container/internal/tracing"
+	"github.com/kubewg-net/container/internal/webhook"
+	"github.com/kubewg-net/container/internal/wireguard"
+	"github.com/kubewg-net/container/pkg/apis/kubewg/v1alpha1"
+	"github.com/kubewg-net/container/pkg/kubewg"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/cobra"
 	"github.com/ztrue/shutdown"
 	"golang.org/x/sync/errgroup"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
 )
 
+// shutdownTimeout bounds how long shutdown waits for the background
+// components and the reconciler to finish once the servers are stopped
+const shutdownTimeout = 5 * time.Second
+
 func NewCommand(version, commit string) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:     "container",
@@ -46,53 +94,687 @@ func NewCommand(version, commit string) *cobra.Command {
 		SilenceErrors: true,
 	}
 	config.RegisterFlags(cmd)
+	cmd.AddCommand(newImportCommand())
+	cmd.AddCommand(newReconcileCommand())
+	cmd.AddCommand(newClientConfigCommand())
+	cmd.AddCommand(newTokensCommand())
+	cmd.AddCommand(newPeersCommand())
+	cmd.AddCommand(newStateCommand())
+	cmd.AddCommand(newDoctorCommand())
+	cmd.AddCommand(newDebugBundleCommand())
+	cmd.AddCommand(newOperatorCommand())
+	cmd.AddCommand(newInitCommand())
+	cmd.AddCommand(newConfigCommand())
+	cmd.AddCommand(newConnectCommand())
+	cmd.AddCommand(newAgentCommand())
+	cmd.AddCommand(newPrivsepHelperCommand())
 	return cmd
 }
 
 func run(cmd *cobra.Command, _ []string) error {
+	// Keep the recent log lines around for debug-bundle
+	logs := logbuffer.New(logbuffer.DefaultSize)
+	log.SetOutput(io.MultiWriter(os.Stderr, logs))
+
 	slog.Info("kubewg container", "version", cmd.Annotations["version"], "commit", cmd.Annotations["commit"])
 
 	config, err := config.LoadConfig(cmd)
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
+	metrics.ConfigReloadSuccessful.Set(1)
+	metrics.ConfigReloadSuccess.SetToCurrentTime()
+	if config.Kubernetes.PodName != "" {
+		slog.Info("Running in pod", "pod", config.Kubernetes.PodNamespace+"/"+config.Kubernetes.PodName, "node", config.Kubernetes.NodeName)
+	}
+
+	// Open the audit log first so the loaded config is its first record
+	var auditLog *audit.Logger
+	if config.Audit.Enabled {
+		auditLog, err = audit.Open(config.Audit.Path)
+		if err != nil {
+			return err
+		}
+		auditLog.Record(audit.Record{
+			Action:  audit.ActionConfigLoaded,
+			Actor:   audit.ActorSystem,
+			Target:  config.Kubernetes.NodeName,
+			Details: map[string]string{"version": cmd.Annotations["version"]},
+		})
+	}
+
+	// Refuse to start without the privileges the config needs instead of
+	// failing halfway through bringing up the interface
+	if err := checkPrivileges(config); err != nil {
+		return err
+	}
+	if err := cryptopolicy.Validate(config); err != nil {
+		return err
+	}
+	if config.CryptoPolicy.FIPS() {
+		slog.Info("Restricting the admin API and outgoing TLS to FIPS approved cryptography, WireGuard's own is not covered", "module", cryptopolicy.Module())
+	}
+	for _, feature := range features.Known() {
+		spec, _ := features.Lookup(feature)
+		enabled := config.FeatureGates.Enabled(feature)
+		if enabled != spec.Default {
+			slog.Info("Feature gate changed from its default", "feature", feature, "enabled", enabled, "stage", spec.Stage)
+		}
+		gauge := 0.0
+		if enabled {
+			gauge = 1
+		}
+		metrics.FeatureEnabled.WithLabelValues(string(feature), string(spec.Stage)).Set(gauge)
+	}
+
+	// ctx lives until shutdown and bounds everything running in the
+	// background
+	ctx, cancel := context.WithCancel(cmd.Context())
+	defer cancel()
+
+	stopTracing, err := tracing.Start(ctx, &config.Tracing, &config.CryptoPolicy, cmd.Annotations["version"])
+	if err != nil {
+		return err
+	}
 
 	var metricsServer *metrics.Server
 	var pprofServer *pprof.Server
+	var profiler *profiling.Pusher
+	var remoteWriter *remotewrite.Pusher
+	var dnsProxy *dnsproxy.Proxy
+	var apiServer *api.Server
+	var engine *kubewg.Engine
+	var interfaces []*kubewg.Engine
+	var helper *privsep.Helper
+	var eventRecorder *kube.EventRecorder
+	var networkWatcher *kube.NetworkWatcher
+	var peerWatcher *kube.PeerWatcher
+	var nodePeerWatcher *kube.NodePeerWatcher
+	var federator *federation.Federator
+	var puncher *punch.Puncher
+	var peerProber *prober.Prober
+	var kubeMonitor *kube.Monitor
+	var annotationPublisher *kube.AnnotationPublisher
+	var peerStatus *kube.PeerStatusWriter
+	var serviceWatcher *kube.ServiceWatcher
+	var serviceResolver *kube.ServiceResolver
+	var stateStore state.Store
+	status := health.NewStatus()
+	backend := &api.Backend{Audit: auditLog, Health: status, Logs: logs, Platform: detectPlatform(config)}
+
+	// One client serves every Kubernetes integration
+	if needsKubernetes(config) {
+		backend.Kube, err = kube.NewClient(&config.Kubernetes)
+		if err != nil {
+			return fmt.Errorf("failed to create Kubernetes client: %w", err)
+		}
+		kubeMonitor = kube.NewMonitor(backend.Kube, status)
+
+		// Name missing RBAC rules up front rather than as 403s later
+		backend.Permissions = kube.NewPermissionChecker(backend.Kube, kube.RequiredPermissions(config), status)
+		if err := backend.Permissions.Check(ctx); err != nil {
+			slog.Warn("Failed to check Kubernetes permissions", "error", err.Error())
+		}
+		go backend.Permissions.Start(ctx)
+
+		// Hold back the deletion of the Node until shutdown cleaned up
+		if config.Kubernetes.NodeFinalizer {
+			if err := kube.AddNodeFinalizer(ctx, backend.Kube, config.Kubernetes.NodeName); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Record peer lifecycle events on the node
+	if config.Kubernetes.Events {
+		eventRecorder = kube.NewEventRecorder(backend.Kube, config.Kubernetes.NodeName)
+		kubeMonitor.OnChange(func(reachable bool) {
+			eventRecorder.SetBuffered(!reachable)
+		})
+	}
+
+	// Settings specific to this node go over the base config
+	if err := applyNodeOverrides(ctx, config, backend.Kube); err != nil {
+		return err
+	}
+
+	// Join the WireGuardNetwork, which takes precedence over the network
+	// settings in the config file
+	var kubeDynamic dynamic.Interface
+	if config.Kubernetes.Network != "" {
+		kubeDynamic, err = joinNetwork(ctx, config, backend.Kube)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Advertise the address of the Service in front of the node, which
+	// takes precedence over STUN
+	if namespace, name, ok := config.Kubernetes.EndpointServiceRef(); ok {
+		serviceWatcher = kube.NewServiceWatcher(backend.Kube, namespace, name, config.Kubernetes.NodeName)
+		if err := serviceWatcher.Start(ctx); err != nil {
+			return err
+		}
+		if endpoint := serviceWatcher.Endpoint(); endpoint != "" {
+			config.WireGuard.Endpoint = endpoint
+		}
+	}
+
+	// Bring up the WireGuard interface
+	if config.WireGuard.Enabled {
+		// The interfaces run in the helper or the agent, which keep
+		// CAP_NET_ADMIN once this process dropped it
+		if config.PrivilegeSeparation.UsesAgent() {
+			helper, err = privsep.Dial(config.PrivilegeSeparation.AgentSocket)
+		} else if config.PrivilegeSeparation.Enabled {
+			helper, err = privsep.Start()
+		}
+		if err != nil {
+			return err
+		}
+		if err := prepareHost(ctx, config, backend.Kube, serviceWatcher, helper); err != nil {
+			return err
+		}
+		if config.DNSProxy.Enabled {
+			if err := dnsproxy.Advertise(&config.DNSProxy, &config.WireGuard); err != nil {
+				return err
+			}
+		}
+
+		keys, keyName, err := newKeyStore(config, backend.Kube)
+		if err != nil {
+			return err
+		}
+		opts := []kubewg.Option{kubewg.WithKeyStore(keys, keyName)}
+		if helper != nil {
+			dataplane, err := helper.Dataplane(&config.WireGuard, keys, keyName)
+			if err != nil {
+				return fmt.Errorf("failed to open WireGuard interface in the helper: %w", err)
+			}
+			opts = []kubewg.Option{kubewg.WithDataplane(dataplane)}
+		}
+		if backend.Kube != nil {
+			serviceResolver = kube.NewServiceResolver(ctx, backend.Kube)
+			opts = append(opts, kubewg.WithServiceSource(serviceResolver))
+		}
+		engine, err = kubewg.New(config, opts...)
+		if err != nil {
+			return fmt.Errorf("failed to create WireGuard engine: %w", err)
+		}
+		if err := usePresharedKeys(config, engine, keys, backend.Kube); err != nil {
+			return err
+		}
+		if serviceResolver != nil {
+			serviceResolver.OnChange(func() {
+				engine.Reconciler().Trigger(reconciler.PriorityNormal)
+			})
+		}
+		if config.Kubernetes.Peers {
+			peerWatcher = kube.NewPeerWatcher(kubeDynamic, &config.WireGuard, engine.Registry())
+		}
+		if eventRecorder != nil {
+			if peerWatcher != nil {
+				eventRecorder.AttachPeers(peerWatcher)
+			}
+			engine.Subscribe(eventRecorder)
+		}
+		if auditLog != nil {
+			engine.Subscribe(auditLog)
+		}
+		if config.Webhooks.Enabled {
+			notifier, err := webhook.New(&config.Webhooks, &config.CryptoPolicy, config.Kubernetes.NodeName)
+			if err != nil {
+				return fmt.Errorf("failed to set up webhooks: %w", err)
+			}
+			engine.Subscribe(notifier)
+			go notifier.Start(ctx)
+		}
+		// Vouch for the node's key with its SVID and require the same of
+		// the other nodes
+		var svids *spiffe.Source
+		var attestor *spiffe.Attestor
+		if config.SPIFFE.Enabled {
+			svids = spiffe.NewSource(config.SPIFFE.SocketPath)
+			attestor = spiffe.NewAttestor(svids, &config.SPIFFE)
+		}
+		if config.Kubernetes.Annotate {
+			annotationPublisher = kube.NewAnnotationPublisher(backend.Kube, config.Kubernetes.NodeName,
+				config.Kubernetes.AnnotationPrefix, nodeAnnotations(config, engine.Dataplane(), serviceWatcher, attestor))
+			engine.Subscribe(annotationPublisher)
+			if serviceWatcher != nil {
+				serviceWatcher.OnChange(func(_ string) {
+					annotationPublisher.Trigger()
+				})
+			}
+		}
+		if err := engine.Start(ctx); err != nil {
+			return err
+		}
+		if annotationPublisher != nil {
+			go annotationPublisher.Start(ctx)
+		}
+		if config.Kubernetes.NodePeers.Enabled {
+			nodePeerWatcher = kube.NewNodePeerWatcher(backend.Kube, &config.Kubernetes, engine.Registry())
+			if attestor != nil {
+				nodePeerWatcher.RequireAttestation(attestor.Verify)
+			}
+			if err := nodePeerWatcher.Start(ctx); err != nil {
+				return err
+			}
+		}
+		if svids != nil {
+			// A new SVID changes this node's attestation, and a new bundle
+			// which other nodes verify
+			svids.OnChange(func() {
+				if annotationPublisher != nil {
+					annotationPublisher.Trigger()
+				}
+				if nodePeerWatcher != nil {
+					nodePeerWatcher.Resync()
+				}
+			})
+			go svids.Start(ctx)
+		}
+		// The proxy binds to the interface's addresses, which exist now
+		if config.DNSProxy.Enabled {
+			dnsProxy, err = dnsproxy.NewProxy(&config.DNSProxy, &config.WireGuard)
+			if err != nil {
+				return fmt.Errorf("failed to set up DNS proxy: %w", err)
+			}
+			if err := dnsProxy.Start(); err != nil {
+				return err
+			}
+		}
+
+		// Run the additional interfaces next to the main one
+		interfaces, err = upInterfaces(config, helper, func(engine *kubewg.Engine) error {
+			return engine.Start(ctx)
+		})
+		if err != nil {
+			return err
+		}
+
+		if kubeDynamic != nil {
+			networkWatcher = kube.NewNetworkWatcher(kubeDynamic, config.Kubernetes.Network, func(network *v1alpha1.WireGuardNetwork) {
+				networkConfig, err := kube.NetworkConfig(network)
+				if err != nil {
+					metrics.ConfigReloadSuccessful.Set(0)
+					slog.Error("Ignoring invalid WireGuardNetwork", "error", err.Error())
+					return
+				}
+				if err := engine.Reconciler().UpdateNetwork(ctx, networkConfig); err != nil {
+					metrics.ConfigReloadSuccessful.Set(0)
+					slog.Error("Failed to apply WireGuardNetwork change", "error", err.Error())
+					auditLog.Record(audit.Record{
+						Action:  audit.ActionNetworkUpdated,
+						Actor:   audit.ActorSystem,
+						Target:  network.Name,
+						Result:  audit.ResultFailure,
+						Details: map[string]string{"error": err.Error()},
+					})
+					return
+				}
+				auditLog.Record(audit.Record{
+					Action:  audit.ActionNetworkUpdated,
+					Actor:   audit.ActorSystem,
+					Target:  network.Name,
+					Details: map[string]string{"generation": strconv.FormatInt(network.Generation, 10)},
+				})
+				metrics.ConfigReloadSuccessful.Set(1)
+				metrics.ConfigReloadSuccess.SetToCurrentTime()
+				engine.Reconciler().Trigger(reconciler.PriorityNormal)
+			})
+			if err := networkWatcher.Start(ctx); err != nil {
+				return err
+			}
+
+			if peerWatcher != nil {
+				if err := peerWatcher.Start(ctx); err != nil {
+					return err
+				}
+				if config.Kubernetes.PeerStatus.Enabled {
+					peerStatus = kube.NewPeerStatusWriter(kubeDynamic, config.Kubernetes.NodeName,
+						config.Kubernetes.PeerStatus.Interval.Std(), peerWatcher.Names, engine.Dataplane().Peers)
+					go peerStatus.Start(ctx)
+				}
+			}
+		}
+
+		backend.Device = engine.Dataplane()
+		backend.Registry = engine.Registry()
+		backend.Reconciler = engine.Reconciler()
+
+		if config.Federation.Enabled {
+			federator, err = federation.NewFederator(&config.Federation, &config.CryptoPolicy, engine.Registry())
+			if err != nil {
+				return fmt.Errorf("failed to set up federation: %w", err)
+			}
+			go federator.Start(ctx)
+		}
+
+		if config.WireGuard.HolePunching.Enabled {
+			puncher, err = punch.NewPuncher(&config.WireGuard, engine.Dataplane(), engine.Reconciler())
+			if err != nil {
+				return fmt.Errorf("failed to set up hole punching: %w", err)
+			}
+			go puncher.Start(ctx)
+		}
+
+		if config.Prober.Enabled {
+			peerProber = prober.New(&config.Prober, &config.WireGuard, engine.Registry().List, status)
+			if err := peerProber.Start(ctx); err != nil {
+				return fmt.Errorf("failed to start the prober: %w", err)
+			}
+			backend.Prober = peerProber
+		}
+
+		// Everything kept across restarts goes through one store
+		stateStore, err = openState(config, backend.Kube, kubeDynamic)
+		if err != nil {
+			return err
+		}
+		if err := engine.Registry().UseStore(ctx, stateStore); err != nil {
+			return fmt.Errorf("failed to restore imported peers: %w", err)
+		}
+		backend.State = stateStore
+
+		if config.Enrollment.Enabled {
+			backend.Enroller, err = enroll.NewEnroller(&config.Enrollment, &config.WireGuard, engine.Registry())
+			if err != nil {
+				return fmt.Errorf("failed to set up enrollment: %w", err)
+			}
+			if err := backend.Enroller.UseStore(ctx, stateStore); err != nil {
+				return fmt.Errorf("failed to restore enrollment state: %w", err)
+			}
+			if config.Enrollment.Lease.Enabled {
+				backend.Enroller.OnEvent(engine.Publish)
+				go backend.Enroller.Start(ctx)
+			}
+			if oidcConfig := config.Enrollment.OIDC; oidcConfig.Enabled {
+				backend.OIDC = oidc.NewVerifier(oidcConfig.Issuer, oidcConfig.ClientID, nil)
+			}
+		}
+
+		// Catch the peers whose removal their source missed
+		if gc := config.WireGuard.PeerGC; gc.Enabled {
+			collector := peers.NewCollector(engine.Registry(), gc.TTL.Std(), gc.Interval.Std())
+			if peerWatcher != nil {
+				collector.Watch(peerWatcher.Backing)
+			}
+			if nodePeerWatcher != nil {
+				collector.Watch(nodePeerWatcher.Backing)
+			}
+			if federator != nil {
+				collector.Watch(federator.Backing)
+			}
+			go collector.Start(ctx)
+		}
+	}
+
+	// Nodes behind NAT meet through the admin API of a node they can all
+	// reach
+	if config.WireGuard.HolePunching.Serve {
+		backend.Rendezvous = punch.NewRendezvous(config.WireGuard.HolePunching.Interval.Std())
+	}
+
+	// Keep running from the last known state while the Kubernetes API is
+	// unreachable, and resync once it is back
+	if kubeMonitor != nil {
+		if engine != nil {
+			kubeMonitor.OnChange(func(reachable bool) {
+				if reachable {
+					engine.Reconciler().Trigger(reconciler.PriorityNormal)
+				}
+			})
+		}
+		go kubeMonitor.Start(ctx)
+	}
+
+	// Report on the managed interface, or in exporter mode on the one the
+	// config names without touching it
+	var wgExporter *exporter.Exporter
+	if config.Metrics.Enabled && (engine != nil || config.Exporter.Enabled) {
+		wgExporter, err = newExporter(config, engine, interfaces, helper)
+		if err != nil {
+			return err
+		}
+		go wgExporter.SampleRates(ctx, config.Metrics.RateInterval.Std())
+	}
 
 	// Start the metrics server
 	if config.Metrics.Enabled {
 		slog.Info("Starting metrics server")
-		metricsServer = metrics.NewServer(&config.Metrics)
-		go metricsServer.Start()
+		backendName := "none"
+		if wgExporter != nil {
+			backendName = wgExporter.Backend()
+		}
+		if err := metrics.RegisterRuntime(cmd.Annotations["version"], cmd.Annotations["commit"], backendName, config.CryptoPolicy.Mode); err != nil {
+			return fmt.Errorf("failed to register runtime metrics: %w", err)
+		}
+		metricsServer = metrics.NewServer(&config.Metrics, status, nil)
+		if wgExporter != nil {
+			metricsServer.Handle("GET /status", wgExporter)
+		}
+		go metricsServer.Start(ctx)
 	}
 
 	// Start the pprof server
 	if config.PProf.Enabled {
 		slog.Info("Starting pprof server")
 		pprofServer = pprof.NewServer(&config.PProf)
-		go pprofServer.Start()
+		go pprofServer.Start(ctx)
+	}
+
+	// Capture profiles to disk on demand
+	if config.PProf.Capture.Enabled {
+		backend.Capturer = pprof.NewCapturer(&config.PProf.Capture)
+		if config.PProf.Capture.Signal {
+			go backend.Capturer.Listen(ctx)
+		}
+	}
+
+	// Push profiles continuously
+	if config.Profiling.Enabled {
+		profiler, err = profiling.NewPusher(&config.Profiling, config.Kubernetes.NodeName)
+		if err != nil {
+			return fmt.Errorf("failed to set up profiling: %w", err)
+		}
+		go profiler.Start(ctx)
+	}
+
+	// Push metrics for when the metrics listener can't be scraped
+	if config.Metrics.RemoteWrite.Enabled {
+		remoteWriter, err = remotewrite.NewPusher(&config.Metrics.RemoteWrite, config.Kubernetes.NodeName,
+			metrics.WrapGatherer(prometheus.DefaultGatherer, config.Metrics.Namespace, config.Metrics.ConstLabels))
+		if err != nil {
+			return fmt.Errorf("failed to set up metrics remote write: %w", err)
+		}
+		go remoteWriter.Start(ctx)
+	}
+
+	// Start the admin API server
+	if config.API.Enabled {
+		slog.Info("Starting API server")
+		apiServer = api.NewServer(config, backend)
+		go apiServer.Start(ctx)
+	}
+
+	// Everything that needed the privileges is up
+	if helper != nil {
+		if err := privsep.DropCapabilities(preflight.RuntimeCapabilities(config)...); err != nil {
+			return fmt.Errorf("failed to drop capabilities: %w", err)
+		}
+		slog.Info("Dropped capabilities, the privilege separation helper runs the interfaces")
+		go func() {
+			select {
+			case <-helper.Done():
+				// Nothing can program the interfaces anymore
+				slog.Error("Privilege separation helper exited, shutting down")
+				process, err := os.FindProcess(os.Getpid())
+				if err == nil {
+					err = process.Signal(syscall.SIGTERM)
+				}
+				if err != nil {
+					slog.Error("Failed to signal shutdown", "error", err.Error())
+				}
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	// Nothing is set up past this point, restrict the process to what it
+	// keeps doing
+	if !config.Sandbox.Disabled {
+		err := sandbox.Apply(sandbox.PathsFor(config))
+		if errors.Is(err, sandbox.ErrCgo) || errors.Is(err, sandbox.ErrUnsupported) {
+			slog.Warn("Running without the sandbox", "error", err.Error())
+		} else if err != nil {
+			return fmt.Errorf("failed to sandbox the process, set sandbox.disabled to run without: %w", err)
+		}
 	}
 
 	stop := func(sig os.Signal) {
 		slog.Info("Shutting down", "signal", sig.String())
+		// Fail readiness first so traffic moves elsewhere while the
+		// servers drain, each within its own shutdown timeout
+		status.SetDraining()
+
 		errGrp := errgroup.Group{}
 
 		if metricsServer != nil {
 			errGrp.Go(func() error {
-				return metricsServer.Stop()
+				return metricsServer.Stop(context.Background())
 			})
 		}
 
 		if pprofServer != nil {
 			errGrp.Go(func() error {
-				return pprofServer.Stop()
+				return pprofServer.Stop(context.Background())
 			})
 		}
 
+		if apiServer != nil {
+			errGrp.Go(func() error {
+				return apiServer.Stop(context.Background())
+			})
+		}
+
+		// Keep tearing down even if a server didn't drain in time, the
+		// interface must not outlive the process
 		if err := errGrp.Wait(); err != nil {
 			slog.Error("Error shutting down", "error", err.Error())
-			os.Exit(1)
+		}
+
+		// The servers are stopped, cancel whatever is still running in the
+		// background and tear down the dataplane last
+		cancel()
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer shutdownCancel()
+
+		// Stop advertising the node before its interface goes away
+		if backend.Kube != nil {
+			unpublish(shutdownCtx, config, backend.Kube, annotationPublisher, peerStatus)
+		}
+
+		if networkWatcher != nil {
+			networkWatcher.Stop()
+		}
+
+		if peerWatcher != nil {
+			peerWatcher.Stop()
+		}
+
+		if nodePeerWatcher != nil {
+			nodePeerWatcher.Stop()
+		}
+
+		if serviceWatcher != nil {
+			serviceWatcher.Stop()
+		}
+
+		if serviceResolver != nil {
+			serviceResolver.Stop()
+		}
+
+		if profiler != nil {
+			if err := profiler.Stop(shutdownCtx); err != nil {
+				slog.Error("Error stopping profiling", "error", err.Error())
+			}
+		}
+
+		if dnsProxy != nil {
+			if err := dnsProxy.Stop(shutdownCtx); err != nil {
+				slog.Error("Error stopping DNS proxy", "error", err.Error())
+			}
+		}
+
+		if remoteWriter != nil {
+			if err := remoteWriter.Stop(shutdownCtx); err != nil {
+				slog.Error("Error stopping metrics remote write", "error", err.Error())
+			}
+		}
+
+		if federator != nil {
+			if err := federator.Stop(shutdownCtx); err != nil {
+				slog.Error("Error stopping federation", "error", err.Error())
+			}
+		}
+
+		if puncher != nil {
+			if err := puncher.Stop(shutdownCtx); err != nil {
+				slog.Error("Error stopping hole punching", "error", err.Error())
+			}
+		}
+
+		if peerProber != nil {
+			if err := peerProber.Stop(); err != nil {
+				slog.Error("Error stopping the prober", "error", err.Error())
+			}
+		}
+
+		for _, extra := range interfaces {
+			if err := extra.Stop(shutdownCtx); err != nil {
+				slog.Error("Error stopping WireGuard", "interface", extra.Dataplane().Name(), "error", err.Error())
+			}
+		}
+
+		if engine != nil {
+			if err := engine.Stop(shutdownCtx); err != nil {
+				slog.Error("Error stopping WireGuard", "error", err.Error())
+				os.Exit(1)
+			}
+		}
+
+		// The interfaces are down, nothing needs the helper anymore
+		if helper != nil {
+			if err := helper.Close(); err != nil {
+				slog.Error("Error stopping privilege separation helper", "error", err.Error())
+			}
+		}
+
+		if eventRecorder != nil {
+			eventRecorder.Stop()
+		}
+
+		if stateStore != nil {
+			if err := stateStore.Close(); err != nil {
+				slog.Error("Error closing state", "error", err.Error())
+			}
+		}
+
+		if wgExporter != nil {
+			if err := wgExporter.Close(); err != nil {
+				slog.Error("Error closing exporter", "error", err.Error())
+			}
+		}
+
+		// Flush the spans of the teardown too
+		if err := stopTracing(shutdownCtx); err != nil {
+			slog.Error("Error flushing traces", "error", err.Error())
+		}
+
+		if err := auditLog.Close(); err != nil {
+			slog.Error("Error closing audit log", "error", err.Error())
 		}
 
 		slog.Info("Shutdown complete")
@@ -103,3 +785,388 @@ func run(cmd *cobra.Command, _ []string) error {
 
 	return nil
 }
+
+// applyNodeOverrides merges the node overrides matching this node over the
+// config.
+func applyNodeOverrides(ctx context.Context, c *config.Config, kubeClient kubernetes.Interface) error {
+	if len(c.NodeOverrides) == 0 {
+		return nil
+	}
+	if c.Kubernetes.NodeName == "" {
+		slog.Warn("Ignoring node overrides, the node name is unknown")
+		return nil
+	}
+
+	var nodeLabels map[string]string
+	if c.NeedsNodeLabels() {
+		var err error
+		nodeLabels, err = kube.NodeLabels(ctx, kubeClient, c.Kubernetes.NodeName)
+		if err != nil {
+			return err
+		}
+	}
+	applied, err := c.ApplyNodeOverrides(c.Kubernetes.NodeName, nodeLabels)
+	if err != nil {
+		return err
+	}
+	if len(applied) > 0 {
+		slog.Info("Applied node overrides", "node", c.Kubernetes.NodeName, "overrides", applied)
+	}
+	return nil
+}
+
+// newKeyStore connects to the configured key store and returns it along
+// with the name of the node's private key in it.
+func newKeyStore(c *config.Config, kubeClient kubernetes.Interface) (keystore.KeyStore, string, error) {
+	name := keystore.PrivateKeyName(c.Kubernetes.NodeName)
+	switch c.KeyStore.Type {
+	case config.KeyStoreSecret:
+		return keystore.NewSecrets(kubeClient, c.KeyStore.Secret.Namespace), name, nil
+	case config.KeyStoreVault:
+		vault, err := keystore.NewVault(&c.KeyStore.Vault, &c.CryptoPolicy)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to set up the vault keystore: %w", err)
+		}
+		return vault, name, nil
+	case config.KeyStoreMemory:
+		return keystore.NewMemory(), name, nil
+	default:
+		return keystore.NewFile(""), c.WireGuard.PrivateKeyFile, nil
+	}
+}
+
+// openState opens the configured state store, with a client for the
+// WireGuardState of the crd type even if no WireGuardNetwork is joined.
+func openState(c *config.Config, kubeClient kubernetes.Interface, kubeDynamic dynamic.Interface) (state.Store, error) {
+	if c.State.Type == config.StateCRD && kubeDynamic == nil {
+		var err error
+		kubeDynamic, err = kube.NewDynamicClient(&c.Kubernetes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return state.Open(c, kubeClient, kubeDynamic)
+}
+
+// usePresharedKeys has the engine share a generated preshared key with
+// every other node, kept in the key store if the nodes share it and in
+// Secrets otherwise.
+func usePresharedKeys(c *config.Config, engine *kubewg.Engine, keys keystore.KeyStore, kubeClient kubernetes.Interface) error {
+	if !c.Kubernetes.PresharedKeys.Enabled {
+		return nil
+	}
+	if !c.KeyStore.Shared() {
+		keys = keystore.NewSecrets(kubeClient, c.Kubernetes.PresharedKeys.Namespace)
+	}
+	presharedKeys, err := keystore.NewPresharedKeys(keys, c.Kubernetes.PresharedKeys.PeerSelector, c.Kubernetes.PresharedKeys.CacheTTL.Std())
+	if err != nil {
+		return err
+	}
+	engine.Reconciler().SetPresharedKeys(presharedKeys)
+	return nil
+}
+
+// needsKubernetes reports whether any enabled feature talks to the API
+// server.
+func needsKubernetes(c *config.Config) bool {
+	return c.Kubernetes.Events || c.Kubernetes.Network != "" || c.Kubernetes.Annotate ||
+		c.Kubernetes.EndpointService != "" || c.NeedsNodeLabels() || c.NeedsNodeAddresses() ||
+		c.NeedsSecrets() || c.API.Auth.TokenReview.Enabled || c.Kubernetes.NodeFinalizer ||
+		c.StateInKubernetes() || c.WireGuard.ClusterCIDRs.Discover ||
+		len(kube.ServiceEndpointNamespaces(c)) > 0
+}
+
+// unpublish removes the node annotations and this node's WireGuardPeer
+// status entries, unless teardown keeps them for a pod that is merely being
+// replaced. A Node that is being deleted is cleaned up regardless and then
+// released from its finalizer; if the cleanup fails the finalizer stays for
+// the operator to finish.
+func unpublish(ctx context.Context, c *config.Config, kubeClient kubernetes.Interface, publisher *kube.AnnotationPublisher, peerStatus *kube.PeerStatusWriter) {
+	deleting := false
+	if c.Kubernetes.NodeFinalizer {
+		var err error
+		deleting, err = kube.NodeDeleting(ctx, kubeClient, c.Kubernetes.NodeName)
+		if err != nil {
+			slog.Error("Failed to check whether the node is being deleted", "error", err.Error())
+		}
+	}
+	if c.WireGuard.Teardown.KeepPublished && !deleting {
+		return
+	}
+
+	clean := true
+	// The annotations go with a deleted Node
+	if publisher != nil && !deleting {
+		if err := publisher.Remove(ctx); err != nil {
+			slog.Error("Failed to remove node annotations", "error", err.Error())
+		}
+	}
+	if peerStatus != nil {
+		if err := peerStatus.Remove(ctx); err != nil {
+			slog.Error("Failed to remove WireGuardPeer status entries", "error", err.Error())
+			clean = false
+		}
+	}
+	if deleting && clean {
+		if err := kube.RemoveNodeFinalizer(ctx, kubeClient, c.Kubernetes.NodeName); err != nil {
+			slog.Error("Failed to remove node finalizer", "error", err.Error())
+		}
+	}
+}
+
+// joinNetwork applies the WireGuardNetwork named in the config, along with
+// the node labels hub selectors are matched against, and returns the client
+// used to fetch it.
+func joinNetwork(ctx context.Context, c *config.Config, kubeClient kubernetes.Interface) (dynamic.Interface, error) {
+	kubeDynamic, err := kube.NewDynamicClient(&c.Kubernetes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client for WireGuardNetworks: %w", err)
+	}
+	network, err := kube.GetNetwork(ctx, kubeDynamic, c.Kubernetes.Network)
+	if err != nil {
+		return nil, err
+	}
+	if err := kube.ApplyNetwork(&c.WireGuard, network); err != nil {
+		return nil, err
+	}
+
+	// Hubs of a hub-and-spoke network are picked by their node labels
+	if c.Kubernetes.NodeName != "" {
+		nodeLabels, err := kube.NodeLabels(ctx, kubeClient, c.Kubernetes.NodeName)
+		if err != nil {
+			return nil, err
+		}
+		c.WireGuard.Labels = mergeLabels(nodeLabels, c.WireGuard.Labels)
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid settings from WireGuardNetwork %s: %w", network.Name, err)
+	}
+	slog.Info("Joined WireGuardNetwork", "network", network.Name, "tunnel_cidrs", network.Spec.CIDRs(), "topology", c.WireGuard.Network.Topology, "hub", c.WireGuard.IsHub())
+	return kubeDynamic, nil
+}
+
+// newExporter registers the interface metrics. Peers are named after the
+// registry when kubewg manages the interface, after the config otherwise.
+func newExporter(c *config.Config, engine *kubewg.Engine, interfaces []*kubewg.Engine, helper *privsep.Helper) (*exporter.Exporter, error) {
+	name := c.Exporter.Interface
+	names := func(publicKey string) string {
+		for _, peer := range c.WireGuard.Peers {
+			if peer.PublicKey == publicKey {
+				return peer.Name
+			}
+		}
+		return ""
+	}
+	if engine != nil {
+		name = engine.Dataplane().Name()
+		names = func(publicKey string) string {
+			peer, _ := engine.Registry().Get(publicKey)
+			return peer.Name
+		}
+	} else {
+		slog.Info("Exporting an existing WireGuard interface", "interface", name)
+	}
+
+	// Reading the interfaces takes CAP_NET_ADMIN, which only the helper
+	// keeps
+	var wgExporter *exporter.Exporter
+	if helper != nil {
+		wgExporter = exporter.NewWithClient(helper.Devices(), name, names)
+	} else {
+		var err error
+		wgExporter, err = exporter.New(name, names)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create exporter: %w", err)
+		}
+	}
+	for _, extra := range interfaces {
+		wgExporter.Add(extra.Dataplane().Name(), func(publicKey string) string {
+			peer, _ := extra.Registry().Get(publicKey)
+			return peer.Name
+		})
+	}
+	if err := prometheus.Register(wgExporter); err != nil {
+		return nil, fmt.Errorf("failed to register exporter: %w", err)
+	}
+	return wgExporter, nil
+}
+
+// checkPrivileges runs the preflight checks and fails if any did.
+func checkPrivileges(c *config.Config) error {
+	process, err := preflight.CurrentProcess()
+	if err != nil {
+		return fmt.Errorf("failed to read process privileges: %w", err)
+	}
+	results := preflight.Run(c, process)
+	for _, result := range results {
+		slog.Debug("Preflight check", "check", result.Name, "ok", result.OK, "message", result.Message)
+	}
+	return preflight.Err(results)
+}
+
+// detectPlatform logs what the node offers and warns about every feature
+// the config needs that it lacks, which would otherwise only show as a
+// failure further in.
+func detectPlatform(c *config.Config) *preflight.Platform {
+	platform := preflight.Host{}.Detect(c)
+	slog.Info("Detected platform", "os", platform.OS, "arch", platform.Arch, "kernel", platform.Kernel,
+		"libc", platform.Libc, "cgroup", platform.Cgroup, "iptables", platform.IPTables, "nft", platform.NFT)
+	for _, feature := range platform.Unsupported() {
+		slog.Warn("The node lacks a feature the config needs", "feature", feature.Name, "message", feature.Message)
+	}
+	return platform
+}
+
+// prepareHost does what has to happen before the interface comes up:
+// picking the listen port, detecting the endpoint, as the listen port is
+// only free to query STUN from until then, discovering the cluster CIDRs
+// and enabling forwarding for relays, exit nodes, clients routed into the
+// cluster and pods routed between nodes. With helper set the interfaces and
+// the sysctls are the helper's to touch.
+func prepareHost(ctx context.Context, c *config.Config, kubeClient kubernetes.Interface, service *kube.ServiceWatcher, helper *privsep.Helper) error {
+	wg := &c.WireGuard
+	if wg.ClusterCIDRs.Discover && kubeClient != nil {
+		discoverClusterCIDRs(ctx, &wg.ClusterCIDRs, kubeClient)
+	}
+	if wg.ListenPort == 0 {
+		if err := selectListenPort(ctx, c, kubeClient, helper); err != nil {
+			return err
+		}
+	}
+	if service != nil && service.Endpoint() != "" {
+		metrics.EndpointSource.WithLabelValues("service").Set(1)
+	} else {
+		detectEndpoint(ctx, c, kubeClient)
+	}
+	if wg.Relay.Serve || wg.ExitNode.Enabled || len(wg.ClusterCIDRs.All()) > 0 || c.Kubernetes.NodePeers.PodCIDRs {
+		enableForwarding := wireguard.EnableForwarding
+		if helper != nil {
+			enableForwarding = helper.EnableForwarding
+		}
+		if err := enableForwarding(); err != nil {
+			return fmt.Errorf("failed to enable forwarding: %w", err)
+		}
+	}
+	return nil
+}
+
+// discoverClusterCIDRs fills in the Pod and Service CIDRs cidrs leaves
+// empty. Clients only miss the routes when nothing is found, so it doesn't
+// stop the startup.
+func discoverClusterCIDRs(ctx context.Context, cidrs *config.ClusterCIDRs, kubeClient kubernetes.Interface) {
+	discovered, err := kube.DiscoverClusterCIDRs(ctx, kubeClient)
+	if err != nil {
+		slog.Warn("Failed to discover the cluster CIDRs, set wireguard.cluster_cidrs instead", "error", err.Error())
+		return
+	}
+	if len(cidrs.Pods) == 0 && len(discovered.Pods) > 0 {
+		cidrs.Pods = discovered.Pods
+		slog.Info("Discovered the Pod CIDRs", "cidrs", discovered.Pods, "source", discovered.PodSource)
+	}
+	if len(cidrs.Services) == 0 && len(discovered.Services) > 0 {
+		cidrs.Services = discovered.Services
+		slog.Info("Discovered the Service CIDRs", "cidrs", discovered.Services, "source", discovered.ServiceSource)
+	}
+}
+
+// selectListenPort picks a free port from the listen port range, keeping
+// the one this node advertised before it restarted where possible.
+func selectListenPort(ctx context.Context, c *config.Config, kubeClient kubernetes.Interface, helper *privsep.Helper) error {
+	wg := &c.WireGuard
+	if port, ok := helperListenPort(helper, wg); ok {
+		wg.ListenPort = port
+		return nil
+	}
+	var previous uint16
+	if c.Kubernetes.Annotate && kubeClient != nil {
+		node, err := kube.GetNode(ctx, kubeClient, c.Kubernetes.NodeName)
+		if err != nil {
+			slog.Warn("Failed to read the previously advertised listen port", "error", err.Error())
+		} else {
+			previous = kube.PublishedListenPort(node, c.Kubernetes.AnnotationPrefix)
+		}
+	}
+
+	port, err := wireguard.SelectListenPort(wg.InterfaceName, wg.ListenPortRange, previous)
+	if err != nil {
+		return fmt.Errorf("failed to pick a listen port: %w", err)
+	}
+	wg.ListenPort = port
+	if previous != 0 && previous != port {
+		slog.Warn("Listen port changed since the last start, re-advertising", "from", previous, "to", port)
+	} else {
+		slog.Info("Picked listen port", "port", port, "min", wg.ListenPortRange.Min, "max", wg.ListenPortRange.Max)
+	}
+	return nil
+}
+
+// detectEndpoint advertises the endpoint of the first source that yields
+// one. Not finding any isn't fatal, this node can still dial out to its
+// peers, it just can't hand its endpoint to anyone.
+func detectEndpoint(ctx context.Context, c *config.Config, kubeClient kubernetes.Interface) {
+	wg := &c.WireGuard
+	var node func(ctx context.Context) (*corev1.Node, error)
+	if kubeClient != nil && c.Kubernetes.NodeName != "" {
+		node = func(ctx context.Context) (*corev1.Node, error) {
+			return kube.GetNode(ctx, kubeClient, c.Kubernetes.NodeName)
+		}
+	}
+	servers := wg.STUN.Servers
+	if len(servers) == 0 {
+		servers = config.DefaultSTUNServers
+	}
+	discover := func(ctx context.Context) (netip.AddrPort, error) {
+		return stun.Endpoint(ctx, servers, wg.ListenPort)
+	}
+
+	sources := wg.EndpointSources()
+	result, err := endpoint.NewDetector(c, node, discover).Detect(ctx, sources)
+	if err != nil {
+		slog.Info("No endpoint to advertise, peers have to dial in", "sources", sources)
+		return
+	}
+	wg.Endpoint = result.Endpoint
+	metrics.EndpointSource.WithLabelValues(result.Source).Set(1)
+	slog.Info("Advertising endpoint", "endpoint", result.Endpoint, "source", result.Source)
+}
+
+// nodeAnnotations reports what the node annotations advertise about the
+// interface. The endpoint follows the Service when there is one, and the
+// key is attested when there is an attestor.
+func nodeAnnotations(c *config.Config, device kubewg.Dataplane, service *kube.ServiceWatcher, attestor *spiffe.Attestor) func() kube.NodeAnnotations {
+	wg := &c.WireGuard
+	return func() kube.NodeAnnotations {
+		endpoint := wg.Endpoint
+		if service != nil && service.Endpoint() != "" {
+			endpoint = service.Endpoint()
+		}
+
+		ips := make([]string, 0, len(wg.Addresses))
+		for _, address := range wg.Addresses {
+			prefix, err := netip.ParsePrefix(address)
+			if err != nil {
+				continue
+			}
+			ips = append(ips, prefix.Addr().String())
+		}
+		annotations := kube.NodeAnnotations{
+			PublicKey:  device.PublicKey().String(),
+			TunnelIPs:  strings.Join(ips, ","),
+			Endpoint:   endpoint,
+			ListenPort: wg.ListenPort,
+		}
+		if attestor != nil {
+			annotations.Attestation = attestor.Attest(c.Kubernetes.NodeName, annotations.PublicKey)
+		}
+		return annotations
+	}
+}
+
+// mergeLabels returns the union of base and overrides, overrides winning.
+func mergeLabels(base, overrides map[string]string) map[string]string {
+	merged := make(map[string]string, len(base)+len(overrides))
+	maps.Copy(merged, base)
+	maps.Copy(merged, overrides)
+	return merged
+}
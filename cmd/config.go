@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ErrInPlaceStdin = errors.New("--in-place can't rewrite stdin")
+)
+
+func newConfigCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Work with config files",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "schema",
+		Short: "Print the JSON Schema of the config file",
+		Long: "Prints a JSON Schema of the config file for editors and CI linting, e.g.\n" +
+			"with a yaml-language-server modeline. It lists every option with its type\n" +
+			"and default and rejects unknown keys.",
+		Args:          cobra.NoArgs,
+		RunE:          runConfigSchema,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	})
+
+	migrate := &cobra.Command{
+		Use:   "migrate <config.yaml>",
+		Short: "Rewrite the deprecated keys of a config file, - reads stdin",
+		Long: "Moves the values of renamed keys to their new names and drops removed\n" +
+			"ones, keeping comments, and lists every change on stderr. The result goes\n" +
+			"to stdout unless --in-place or --output is set. Encrypted files have to be\n" +
+			"decrypted first.",
+		Args:          cobra.ExactArgs(1),
+		RunE:          runConfigMigrate,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	migrate.Flags().StringP("output", "o", "", "Write to this file instead of stdout")
+	migrate.Flags().BoolP("in-place", "i", false, "Rewrite the file itself")
+	migrate.MarkFlagsMutuallyExclusive("output", "in-place")
+	cmd.AddCommand(migrate)
+
+	return cmd
+}
+
+func runConfigSchema(cmd *cobra.Command, _ []string) error {
+	encoder := json.NewEncoder(cmd.OutOrStdout())
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(config.Schema())
+}
+
+func runConfigMigrate(cmd *cobra.Command, args []string) error {
+	output, _ := cmd.Flags().GetString("output")
+	inPlace, _ := cmd.Flags().GetBool("in-place")
+
+	var data []byte
+	var err error
+	mode := os.FileMode(0o600)
+	if args[0] == "-" {
+		if inPlace {
+			return ErrInPlaceStdin
+		}
+		data, err = io.ReadAll(cmd.InOrStdin())
+	} else {
+		var info os.FileInfo
+		if info, err = os.Stat(args[0]); err == nil {
+			mode = info.Mode().Perm()
+			data, err = os.ReadFile(args[0])
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	migrated, migrations, err := config.MigrateFile(data)
+	if err != nil {
+		return err
+	}
+	for _, migration := range migrations {
+		fmt.Fprintf(cmd.ErrOrStderr(), "  ~ %s\n", migration)
+	}
+	if len(migrations) == 0 {
+		fmt.Fprintln(cmd.ErrOrStderr(), "No deprecated keys")
+		if inPlace {
+			return nil
+		}
+	}
+
+	if inPlace {
+		output = args[0]
+	}
+	if output != "" {
+		// Configs may hold keys and tokens
+		if err := os.WriteFile(output, migrated, mode); err != nil {
+			return fmt.Errorf("failed to write %s: %w", output, err)
+		}
+		return nil
+	}
+	_, err = cmd.OutOrStdout().Write(migrated)
+	return err
+}
@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/connect"
+	"github.com/kubewg-net/container/internal/oidc"
+	"github.com/spf13/cobra"
+)
+
+const enrollTokenEnv = "KUBEWG_ENROLL_TOKEN"
+
+func newConnectCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "connect",
+		Short: "Connect this machine to a cluster as a client",
+		Long: "Runs outside Kubernetes as a client of a cluster. The first run enrolls\n" +
+			"through the cluster's API with a token from `tokens create`, or with\n" +
+			"--oidc by logging in at the cluster's identity provider, and saves the\n" +
+			"key and config in the session file, later runs reuse it. It brings up\n" +
+			"the interface, in userspace when the kernel has no WireGuard, routes the\n" +
+			"cluster's networks and any --route through it, points the interface's DNS\n" +
+			"at the cluster and renews the lease until it is stopped.\n\n" +
+			"On macOS and Windows the interface always runs in userspace, as a utun\n" +
+			"interface named by the system or as a Wintun adapter, which needs\n" +
+			"wintun.dll next to the binary. Either way it has to run as root or as\n" +
+			"an administrator.",
+		Args:          cobra.NoArgs,
+		RunE:          runConnect,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	addAPIFlags(cmd)
+	cmd.Flags().String("token", "", "Enrollment token for the first run, defaults to $"+enrollTokenEnv)
+	cmd.Flags().Bool("oidc", false, "Enroll by logging in at the cluster's identity provider instead of with a token")
+	cmd.Flags().String("interface", config.DefaultInterfaceName, "Name of the local WireGuard interface, macOS picks a utun name unless it is one")
+	cmd.Flags().String("userspace", config.UserspaceAuto, "Run the interface in wireguard-go: off, auto without the kernel module, or always")
+	cmd.Flags().StringSlice("route", nil, "CIDR to route through the cluster on top of the ones the server sends")
+	cmd.Flags().String("session", connect.DefaultSessionPath, "File keeping the key, config and lease between runs")
+	return cmd
+}
+
+func runConnect(cmd *cobra.Command, _ []string) error {
+	flags := cmd.Flags()
+	var opts connect.Options
+	var err error
+	if opts.Token, err = flags.GetString("token"); err != nil {
+		return fmt.Errorf("failed to get token: %w", err)
+	}
+	if opts.Token == "" {
+		opts.Token = os.Getenv(enrollTokenEnv)
+	}
+	if opts.OIDC, err = flags.GetBool("oidc"); err != nil {
+		return fmt.Errorf("failed to get oidc: %w", err)
+	}
+	opts.Prompt = func(code *oidc.DeviceCode) {
+		if code.VerificationURIComplete != "" {
+			fmt.Fprintf(cmd.ErrOrStderr(), "To enroll, open %s and confirm the code %s\n", code.VerificationURIComplete, code.UserCode)
+			return
+		}
+		fmt.Fprintf(cmd.ErrOrStderr(), "To enroll, open %s and enter the code %s\n", code.VerificationURI, code.UserCode)
+	}
+	if opts.InterfaceName, err = flags.GetString("interface"); err != nil {
+		return fmt.Errorf("failed to get interface: %w", err)
+	}
+	if opts.Userspace, err = flags.GetString("userspace"); err != nil {
+		return fmt.Errorf("failed to get userspace: %w", err)
+	}
+	if opts.Routes, err = flags.GetStringSlice("route"); err != nil {
+		return fmt.Errorf("failed to get routes: %w", err)
+	}
+	if opts.SessionPath, err = flags.GetString("session"); err != nil {
+		return fmt.Errorf("failed to get session: %w", err)
+	}
+	switch opts.Userspace {
+	case config.UserspaceOff, config.UserspaceAuto, config.UserspaceAlways:
+	default:
+		return fmt.Errorf("%w: %q", config.ErrUserspace, opts.Userspace)
+	}
+
+	baseURL, err := flags.GetString(apiURLFlag)
+	if err != nil {
+		return fmt.Errorf("failed to get API URL: %w", err)
+	}
+	httpClient, err := apiHTTPClient(cmd)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	return connect.Run(ctx, connect.NewClient(baseURL, httpClient), &opts)
+}
@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/kubewg-net/container/internal/bundle"
+	"github.com/kubewg-net/container/internal/firewall"
+	"github.com/spf13/cobra"
+)
+
+func newDebugBundleCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "debug-bundle",
+		Short: "Collect a support bundle to attach to bug reports",
+		Long: "Gathers the status, redacted config, recent logs and goroutines of the\n" +
+			"running instance through the admin API, and the interfaces, routes, rules\n" +
+			"and firewall rules of this host, into a single tar.gz. Run it inside the\n" +
+			"container so it sees the same network namespace. Parts that can't be\n" +
+			"collected are listed in " + bundle.ErrorsFile + " instead of failing the bundle.",
+		Args:          cobra.NoArgs,
+		RunE:          runDebugBundle,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	addAPIFlags(cmd)
+	cmd.Flags().StringP("output", "o", "", "File to write the bundle to, - for stdout, defaults to kubewg-debug-<time>.tar.gz")
+	return cmd
+}
+
+func runDebugBundle(cmd *cobra.Command, _ []string) error {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return fmt.Errorf("failed to get output: %w", err)
+	}
+
+	now := time.Now().UTC()
+	dir := "kubewg-debug-" + now.Format("20060102T150405Z")
+	if output == "" {
+		output = dir + ".tar.gz"
+	}
+
+	fromAPI := func(name, path string) bundle.Collector {
+		return bundle.Collector{
+			Name: name,
+			Collect: func(context.Context) ([]byte, error) {
+				return callAPIRaw(cmd, http.MethodGet, path, nil)
+			},
+		}
+	}
+	collectors := []bundle.Collector{
+		{Name: "version.txt", Collect: func(context.Context) ([]byte, error) {
+			return []byte(cmd.Root().Version + "\n"), nil
+		}},
+		fromAPI("status.json", "/debug/status"),
+		fromAPI("config.json", "/debug/config"),
+		fromAPI("logs.txt", "/debug/logs"),
+		fromAPI("goroutines.txt", "/debug/goroutines"),
+		{Name: "links.txt", Collect: bundle.Links},
+		{Name: "routes.txt", Collect: bundle.Routes},
+		{Name: "rules.txt", Collect: bundle.Rules},
+		bundle.Firewall(firewall.Exec, firewall.IPv4),
+		bundle.Firewall(firewall.Exec, firewall.IPv6),
+	}
+
+	var w io.Writer = cmd.OutOrStdout()
+	if output != "-" {
+		file, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to create bundle: %w", err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	failed, err := bundle.Write(cmd.Context(), w, dir, now, collectors)
+	if err != nil {
+		return err
+	}
+
+	status := cmd.ErrOrStderr()
+	if output != "-" {
+		fmt.Fprintf(status, "Wrote %s\n", output)
+	}
+	if failed > 0 {
+		fmt.Fprintf(status, "%d of %d parts could not be collected, see %s in the bundle\n", failed, len(collectors), bundle.ErrorsFile)
+	}
+	return nil
+}
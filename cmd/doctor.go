@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/kube"
+	"github.com/kubewg-net/container/internal/preflight"
+	"github.com/spf13/cobra"
+)
+
+func newDoctorCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check that this host and config can run kubewg",
+		Long: "Runs the same preflight checks as startup against the given config, then\n" +
+			"checks the host for the WireGuard module, /dev/net/tun, forwarding sysctls\n" +
+			"and the listen port, and the Kubernetes RBAC permissions the enabled\n" +
+			"features need, printing the result of each. With --manifest it prints an\n" +
+			"example DaemonSet with the minimal securityContext instead, with --agent\n" +
+			"one that leaves the interfaces to an agent container.",
+		Args:          cobra.NoArgs,
+		RunE:          runDoctor,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	config.RegisterFlags(cmd)
+	cmd.Flags().Bool("manifest", false, "Print an example DaemonSet running with minimal privileges")
+	cmd.Flags().String("image", preflight.DefaultImage, "Image used in the example manifest")
+	cmd.Flags().Bool("agent", false, "Split the example manifest into a privileged agent and an unprivileged controller")
+	return cmd
+}
+
+func runDoctor(cmd *cobra.Command, _ []string) error {
+	manifest, err := cmd.Flags().GetBool("manifest")
+	if err != nil {
+		return fmt.Errorf("failed to get manifest: %w", err)
+	}
+	if manifest {
+		image, err := cmd.Flags().GetString("image")
+		if err != nil {
+			return fmt.Errorf("failed to get image: %w", err)
+		}
+		agent, err := cmd.Flags().GetBool("agent")
+		if err != nil {
+			return fmt.Errorf("failed to get agent: %w", err)
+		}
+		render := preflight.Manifest
+		if agent {
+			render = preflight.AgentManifest
+		}
+		data, err := render(image)
+		if err != nil {
+			return err
+		}
+		_, err = cmd.OutOrStdout().Write(data)
+		return err
+	}
+
+	config, err := config.LoadConfig(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	process, err := preflight.CurrentProcess()
+	if err != nil {
+		return fmt.Errorf("failed to read process privileges: %w", err)
+	}
+
+	results := preflight.Run(config, process)
+	results = append(results, preflight.Host{}.Run(config)...)
+	if needsKubernetes(config) {
+		results = append(results, checkRBAC(cmd.Context(), config)...)
+	}
+	out := cmd.OutOrStdout()
+	for _, result := range results {
+		mark := "ok  "
+		if !result.OK {
+			mark = "FAIL"
+		}
+		fmt.Fprintf(out, "[%s] %s: %s\n", mark, result.Name, result.Message)
+	}
+	return preflight.Err(results)
+}
+
+// checkRBAC reviews every permission the enabled Kubernetes features need.
+func checkRBAC(ctx context.Context, c *config.Config) []preflight.Result {
+	client, err := kube.NewClient(&c.Kubernetes)
+	if err != nil {
+		return []preflight.Result{{Name: "kubernetes", OK: false, Message: err.Error()}}
+	}
+	permissions, err := kube.CheckPermissions(ctx, client, kube.RequiredPermissions(c))
+	if err != nil {
+		return []preflight.Result{{Name: "kubernetes", OK: false, Message: err.Error()}}
+	}
+
+	results := make([]preflight.Result, 0, len(permissions))
+	for _, permission := range permissions {
+		result := preflight.Result{Name: "rbac", OK: permission.Allowed}
+		if permission.Allowed {
+			result.Message = fmt.Sprintf("may %s for %s", permission.String(), permission.Feature)
+		} else {
+			result.Message = fmt.Sprintf("may not %s, which %s needs", permission.String(), permission.Feature)
+			if permission.Reason != "" {
+				result.Message += ": " + permission.Reason
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/ghodss/yaml"
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/spf13/cobra"
+)
+
+func newImportCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "import <wg-quick.conf>",
+		Short: "Convert a wg-quick config file into kubewg config",
+		Long: "Reads an [Interface]/[Peer] config file as used by wg-quick and prints the\n" +
+			"equivalent wireguard section of the kubewg config file.",
+		Args:          cobra.ExactArgs(1),
+		RunE:          runImport,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+}
+
+func runImport(cmd *cobra.Command, args []string) error {
+	var imported config.WireGuard
+	if err := imported.ImportWGQuick(args[0]); err != nil {
+		return err
+	}
+
+	section := map[string]interface{}{
+		"enabled": true,
+	}
+	if imported.PrivateKey != "" {
+		section["private_key"] = imported.PrivateKey
+	}
+	if imported.ListenPort != 0 {
+		section["listen_port"] = imported.ListenPort
+	}
+	if imported.MTU != 0 {
+		section["mtu"] = imported.MTU
+	}
+	if len(imported.Addresses) != 0 {
+		section["addresses"] = imported.Addresses
+	}
+	if len(imported.Peers) != 0 {
+		section["peers"] = imported.Peers
+	}
+
+	out, err := yaml.Marshal(map[string]interface{}{"wireguard": section})
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	_, err = cmd.OutOrStdout().Write(out)
+	return err
+}
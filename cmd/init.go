@@ -0,0 +1,136 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/kube"
+	"github.com/kubewg-net/container/internal/preflight"
+	"github.com/kubewg-net/container/pkg/kubewg"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	ErrInitUserspace = errors.New("init needs a kernel WireGuard interface, a userspace one would stop when init exits")
+)
+
+func newInitCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Configure the WireGuard interface once and exit",
+		Long: "Brings up the interface with its addresses, routes and peers, then exits\n" +
+			"leaving it in place. Meant for an init container in front of an app that\n" +
+			"only needs the tunnel, without a long-running sidecar. Peers are not kept\n" +
+			"up to date afterwards. It needs the kernel's WireGuard, userspace interfaces\n" +
+			"are refused.",
+		Args:          cobra.NoArgs,
+		RunE:          runInit,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	config.RegisterFlags(cmd)
+	return cmd
+}
+
+// checkKernelDevices refuses interfaces that would run in wireguard-go,
+// which lives in this process and would go away with it once init exits.
+// With auto that is the case when the kernel has no WireGuard module.
+func checkKernelDevices(c *config.Config) error {
+	module := preflight.ModuleMissing
+	for _, wg := range append([]config.WireGuard{c.WireGuard}, c.Interfaces...) {
+		switch wg.Userspace {
+		case config.UserspaceAlways:
+			return fmt.Errorf("%w: %s has userspace set to always", ErrInitUserspace, wg.InterfaceName)
+		case config.UserspaceAuto:
+			if module == preflight.ModuleMissing {
+				module = preflight.Host{}.WireGuardModule()
+			}
+			if module == preflight.ModuleMissing {
+				return fmt.Errorf("%w: the kernel has no WireGuard module for %s", ErrInitUserspace, wg.InterfaceName)
+			}
+		}
+	}
+	return nil
+}
+
+func runInit(cmd *cobra.Command, _ []string) error {
+	config, err := config.LoadConfig(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if !config.WireGuard.Enabled {
+		return kubewg.ErrWireGuardDisabled
+	}
+	if err := checkKernelDevices(config); err != nil {
+		return err
+	}
+	if err := checkPrivileges(config); err != nil {
+		return err
+	}
+
+	ctx := cmd.Context()
+	var kubeClient kubernetes.Interface
+	if config.Kubernetes.Network != "" || config.NeedsNodeLabels() || config.NeedsNodeAddresses() || config.NeedsSecrets() {
+		kubeClient, err = kube.NewClient(&config.Kubernetes)
+		if err != nil {
+			return fmt.Errorf("failed to create Kubernetes client: %w", err)
+		}
+	}
+	if err := applyNodeOverrides(ctx, config, kubeClient); err != nil {
+		return err
+	}
+	if config.Kubernetes.Network != "" {
+		if _, err := joinNetwork(ctx, config, kubeClient); err != nil {
+			return err
+		}
+	}
+	if err := prepareHost(ctx, config, kubeClient, nil, nil); err != nil {
+		return err
+	}
+
+	keys, keyName, err := newKeyStore(config, kubeClient)
+	if err != nil {
+		return err
+	}
+	engine, err := kubewg.New(config, kubewg.WithKeyStore(keys, keyName))
+	if err != nil {
+		return fmt.Errorf("failed to create WireGuard engine: %w", err)
+	}
+	if err := usePresharedKeys(config, engine, keys, kubeClient); err != nil {
+		return err
+	}
+	summary, err := engine.Apply(ctx)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("WireGuard interface configured", "interface", engine.Dataplane().Name(), "peers", len(summary.Added)+len(summary.Updated)+summary.Unchanged, "public_key", engine.Dataplane().PublicKey().String())
+
+	_, err = upInterfaces(config, nil, func(engine *kubewg.Engine) error {
+		_, err := engine.Apply(ctx)
+		return err
+	})
+	return err
+}
@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+package cmd_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kubewg-net/container/cmd"
+	"github.com/kubewg-net/container/pkg/kubewg"
+)
+
+func runInit(t *testing.T, configYAML string, args ...string) error {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(configYAML), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	command := cmd.NewCommand("test", "test")
+	command.SetArgs(append([]string{"init", "--config", path}, args...))
+	return command.ExecuteContext(context.Background())
+}
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		config string
+		err    error
+	}{
+		{
+			name:   "wireguard disabled",
+			config: "wireguard:\n  enabled: false\n",
+			err:    kubewg.ErrWireGuardDisabled,
+		},
+		{
+			name:   "userspace interface",
+			config: "wireguard:\n  enabled: true\n  userspace: always\n  addresses: ['10.0.0.1/24']\n",
+			err:    cmd.ErrInitUserspace,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := runInit(t, test.config); !errors.Is(err, test.err) {
+				t.Errorf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestInitTakesNoArgs(t *testing.T) {
+	t.Parallel()
+
+	if err := runInit(t, "wireguard:\n  enabled: false\n", "extra"); err == nil {
+		t.Error("expected an argument to be refused")
+	}
+}
@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package cmd
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/keystore"
+	"github.com/kubewg-net/container/internal/privsep"
+	"github.com/kubewg-net/container/internal/wireguard"
+	"github.com/kubewg-net/container/pkg/kubewg"
+)
+
+// upInterfaces brings up the additional interfaces with up, each by an
+// engine of its own, once the main one is up. Their ports are picked one
+// after the other, so none picks a port another one just took. With helper
+// set the interfaces run in the privilege separation helper.
+func upInterfaces(c *config.Config, helper *privsep.Helper, up func(*kubewg.Engine) error) ([]*kubewg.Engine, error) {
+	engines := make([]*kubewg.Engine, 0, len(c.Interfaces))
+	for i := range c.Interfaces {
+		wg := &c.Interfaces[i]
+		if port, ok := helperListenPort(helper, wg); ok && wg.ListenPort == 0 {
+			wg.ListenPort = port
+		}
+		if wg.ListenPort == 0 {
+			port, err := wireguard.SelectListenPort(wg.InterfaceName, wg.ListenPortRange, 0)
+			if err != nil {
+				return engines, fmt.Errorf("failed to pick a listen port for %s: %w", wg.InterfaceName, err)
+			}
+			wg.ListenPort = port
+		}
+
+		sub := c.ForInterface(i)
+		var opts []kubewg.Option
+		if helper != nil {
+			// The private key file is this process's, an agent doesn't
+			// share its filesystem
+			dataplane, err := helper.Dataplane(&sub.WireGuard, keystore.NewFile(""), sub.WireGuard.PrivateKeyFile)
+			if err != nil {
+				return engines, fmt.Errorf("failed to open %s in the helper: %w", wg.InterfaceName, err)
+			}
+			opts = append(opts, kubewg.WithDataplane(dataplane))
+		}
+		engine, err := kubewg.New(sub, opts...)
+		if err != nil {
+			return engines, fmt.Errorf("failed to create WireGuard engine for %s: %w", wg.InterfaceName, err)
+		}
+		if err := up(engine); err != nil {
+			return engines, err
+		}
+		engines = append(engines, engine)
+		slog.Info("Brought up additional WireGuard interface", "interface", wg.InterfaceName,
+			"listen_port", wg.ListenPort, "peers", len(wg.Peers))
+	}
+	return engines, nil
+}
+
+// helperListenPort keeps the listen port of an interface the helper runs
+// already, which SelectListenPort can't read without CAP_NET_ADMIN.
+func helperListenPort(helper *privsep.Helper, wg *config.WireGuard) (uint16, bool) {
+	if helper == nil {
+		return 0, false
+	}
+	port, ok := helper.ListenPort(cmp.Or(wg.InterfaceName, config.DefaultInterfaceName))
+	return port, ok && wg.ListenPortRange.Contains(port)
+}
@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os/signal"
+	"syscall"
+
+	"github.com/go-logr/logr"
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/kube"
+	"github.com/kubewg-net/container/internal/operator"
+	"github.com/spf13/cobra"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func newOperatorCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "operator",
+		Short: "Run the cluster-wide controllers",
+		Long: "Runs one controller-runtime manager for the cluster next to the per-node\n" +
+			"agents. It removes the status entries and private key Secrets of deleted\n" +
+			"nodes, sets the Accepted condition of WireGuardPeers and serves validating\n" +
+			"webhooks for WireGuardPeers and WireGuardNetworks. Run it with leader\n" +
+			"election when there is more than one replica.",
+		Args:          cobra.NoArgs,
+		RunE:          runOperator,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	cmd.Flags().String("kubeconfig", "", "Path to a kubeconfig, defaults to the in-cluster config")
+	cmd.Flags().String("key-namespace", "", "Namespace of the secret keystore, empty to leave key Secrets alone")
+	cmd.Flags().Bool("leader-elect", false, "Elect a leader to run the controllers, for more than one replica")
+	cmd.Flags().String("leader-election-id", operator.DefaultLeaderElectionID, "Name of the leader election Lease")
+	cmd.Flags().String("leader-election-namespace", "", "Namespace of the leader election Lease, defaults to the pod's namespace")
+	cmd.Flags().String("metrics-bind-address", operator.DefaultMetricsAddress, "Address to serve metrics on, 0 to disable")
+	cmd.Flags().String("health-probe-bind-address", operator.DefaultProbeAddress, "Address to serve /healthz and /readyz on, 0 to disable")
+	cmd.Flags().Int("webhook-port", operator.DefaultWebhookPort, "Port to serve the validating webhooks on, 0 to disable")
+	cmd.Flags().String("webhook-cert-dir", "", "Directory with tls.crt and tls.key for the webhooks, defaults to the controller-runtime one")
+	return cmd
+}
+
+func runOperator(cmd *cobra.Command, _ []string) error {
+	flags := cmd.Flags()
+	kubeconfig, err := flags.GetString("kubeconfig")
+	if err != nil {
+		return fmt.Errorf("failed to get kubeconfig: %w", err)
+	}
+	var opts operator.Options
+	if opts.KeyNamespace, err = flags.GetString("key-namespace"); err != nil {
+		return fmt.Errorf("failed to get key namespace: %w", err)
+	}
+	if opts.LeaderElection, err = flags.GetBool("leader-elect"); err != nil {
+		return fmt.Errorf("failed to get leader election: %w", err)
+	}
+	if opts.LeaderElectionID, err = flags.GetString("leader-election-id"); err != nil {
+		return fmt.Errorf("failed to get leader election ID: %w", err)
+	}
+	if opts.LeaderElectionNamespace, err = flags.GetString("leader-election-namespace"); err != nil {
+		return fmt.Errorf("failed to get leader election namespace: %w", err)
+	}
+	if opts.MetricsAddress, err = flags.GetString("metrics-bind-address"); err != nil {
+		return fmt.Errorf("failed to get metrics bind address: %w", err)
+	}
+	if opts.ProbeAddress, err = flags.GetString("health-probe-bind-address"); err != nil {
+		return fmt.Errorf("failed to get health probe bind address: %w", err)
+	}
+	if opts.WebhookPort, err = flags.GetInt("webhook-port"); err != nil {
+		return fmt.Errorf("failed to get webhook port: %w", err)
+	}
+	if opts.WebhookCertDir, err = flags.GetString("webhook-cert-dir"); err != nil {
+		return fmt.Errorf("failed to get webhook cert dir: %w", err)
+	}
+
+	restConfig, err := kube.RESTConfig(&config.Kubernetes{Kubeconfig: kubeconfig})
+	if err != nil {
+		return err
+	}
+	ctrl.SetLogger(logr.FromSlogHandler(slog.Default().Handler()))
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	slog.Info("Starting operator", "leaderElection", opts.LeaderElection, "webhookPort", opts.WebhookPort)
+	return operator.Run(ctx, restConfig, &opts)
+}
@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/kubewg-net/container/internal/peers"
+	"github.com/spf13/cobra"
+)
+
+func newPeersCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "peers",
+		Short: "Export and import peers as portable JSON bundles",
+	}
+	addAPIFlags(cmd)
+
+	export := &cobra.Command{
+		Use:           "export",
+		Short:         "Write all peers of a running instance as a JSON bundle",
+		Args:          cobra.NoArgs,
+		RunE:          runPeersExport,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	export.Flags().Bool("include-preshared-keys", false, "Include preshared keys in the bundle, requires the admin role")
+	export.Flags().StringP("output", "o", "", "Write to this file instead of stdout")
+	cmd.AddCommand(export)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:           "import <bundle.json>",
+		Short:         "Add the peers of a JSON bundle to a running instance, - reads stdin",
+		Args:          cobra.ExactArgs(1),
+		RunE:          runPeersImport,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	})
+
+	return cmd
+}
+
+func runPeersExport(cmd *cobra.Command, _ []string) error {
+	includePresharedKeys, _ := cmd.Flags().GetBool("include-preshared-keys")
+	output, _ := cmd.Flags().GetString("output")
+
+	path := "/api/v1/peers"
+	if includePresharedKeys {
+		path += "?include_preshared_keys=true"
+	}
+	data, err := callAPIRaw(cmd, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+
+	if output != "" {
+		if err := os.WriteFile(output, data, 0o600); err != nil {
+			return fmt.Errorf("failed to write %s: %w", output, err)
+		}
+		return nil
+	}
+
+	_, err = cmd.OutOrStdout().Write(data)
+	return err
+}
+
+func runPeersImport(cmd *cobra.Command, args []string) error {
+	var data []byte
+	var err error
+	if args[0] == "-" {
+		data, err = io.ReadAll(cmd.InOrStdin())
+	} else {
+		data, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read bundle: %w", err)
+	}
+
+	var result peers.ImportResult
+	if err := callAPI(cmd, http.MethodPost, "/api/v1/peers/import", bytes.NewReader(data), &result); err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Imported %d peers, skipped %d already present\n", len(result.Imported), len(result.Skipped))
+	for _, key := range result.Imported {
+		fmt.Fprintf(out, "  + %s\n", key)
+	}
+	return nil
+}
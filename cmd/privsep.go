@@ -0,0 +1,41 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package cmd
+
+import (
+	"github.com/kubewg-net/container/internal/privsep"
+	"github.com/spf13/cobra"
+)
+
+// newPrivsepHelperCommand is how the process re-executes itself as the
+// privilege separation helper, it isn't meant to be run by hand.
+func newPrivsepHelperCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:    privsep.HelperCommand,
+		Short:  "Run the WireGuard interfaces for a parent that dropped its capabilities",
+		Args:   cobra.NoArgs,
+		Hidden: true,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			return privsep.Serve()
+		},
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+}
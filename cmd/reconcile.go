@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kubewg-net/container/internal/reconciler"
+	"github.com/spf13/cobra"
+)
+
+func newReconcileCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "reconcile",
+		Short: "Control reconciliation of a running instance",
+	}
+	addAPIFlags(cmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:           "now",
+		Short:         "Force a full resync of the WireGuard device",
+		Args:          cobra.NoArgs,
+		RunE:          runReconcileNow,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	})
+
+	return cmd
+}
+
+func runReconcileNow(cmd *cobra.Command, _ []string) error {
+	var summary reconciler.Summary
+	if err := callAPI(cmd, http.MethodPost, "/api/v1/reconcile", nil, &summary); err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Reconciled in %s\n", summary.Duration)
+	fmt.Fprintf(out, "  added:     %d\n", len(summary.Added))
+	for _, key := range summary.Added {
+		fmt.Fprintf(out, "    + %s\n", key)
+	}
+	fmt.Fprintf(out, "  removed:   %d\n", len(summary.Removed))
+	for _, key := range summary.Removed {
+		fmt.Fprintf(out, "    - %s\n", key)
+	}
+	fmt.Fprintf(out, "  updated:   %d\n", len(summary.Updated))
+	for _, key := range summary.Updated {
+		fmt.Fprintf(out, "    ~ %s\n", key)
+	}
+	fmt.Fprintf(out, "  unchanged: %d\n", summary.Unchanged)
+	fmt.Fprintf(out, "  drift:     %d\n", len(summary.Drift))
+	for _, drift := range summary.Drift {
+		fmt.Fprintf(out, "    ! %s %s: %s (since %s)\n", drift.Kind, drift.Object, drift.Detail, drift.Since.Format(time.RFC3339))
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/kubewg-net/container/internal/api"
+	"github.com/spf13/cobra"
+)
+
+func newStateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "state",
+		Short: "Back up and restore enrollment state, address allocations and imported peers",
+	}
+	addAPIFlags(cmd)
+
+	export := &cobra.Command{
+		Use:           "export",
+		Short:         "Write the state of a running instance as a versioned JSON document",
+		Args:          cobra.NoArgs,
+		RunE:          runStateExport,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	export.Flags().StringP("output", "o", "", "Write to this file instead of stdout")
+	cmd.AddCommand(export)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:           "import <state.json>",
+		Short:         "Restore an exported state into a running instance, whatever its state type, - reads stdin",
+		Args:          cobra.ExactArgs(1),
+		RunE:          runStateImport,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	})
+
+	return cmd
+}
+
+func runStateExport(cmd *cobra.Command, _ []string) error {
+	output, _ := cmd.Flags().GetString("output")
+
+	data, err := callAPIRaw(cmd, http.MethodGet, "/api/v1/state", nil)
+	if err != nil {
+		return err
+	}
+
+	if output != "" {
+		// Token and lease hashes are in there
+		if err := os.WriteFile(output, data, 0o600); err != nil {
+			return fmt.Errorf("failed to write %s: %w", output, err)
+		}
+		return nil
+	}
+
+	_, err = cmd.OutOrStdout().Write(data)
+	return err
+}
+
+func runStateImport(cmd *cobra.Command, args []string) error {
+	var data []byte
+	var err error
+	if args[0] == "-" {
+		data, err = io.ReadAll(cmd.InOrStdin())
+	} else {
+		data, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read state: %w", err)
+	}
+
+	var result api.StateImportResult
+	if err := callAPI(cmd, http.MethodPost, "/api/v1/state/import", bytes.NewReader(data), &result); err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Imported %d state documents\n", len(result.Documents))
+	for _, name := range result.Documents {
+		fmt.Fprintf(out, "  + %s\n", name)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kubewg-net/container/internal/enroll"
+	"github.com/spf13/cobra"
+)
+
+func newTokensCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tokens",
+		Short: "Manage peer enrollment tokens",
+	}
+	addAPIFlags(cmd)
+
+	create := &cobra.Command{
+		Use:           "create",
+		Short:         "Issue a one-time enrollment token",
+		Args:          cobra.NoArgs,
+		RunE:          runTokensCreate,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+	create.Flags().Duration("ttl", 0, "How long the token stays valid, defaults to enrollment.token_ttl")
+	cmd.AddCommand(create)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:           "list",
+		Short:         "List outstanding enrollment tokens",
+		Args:          cobra.NoArgs,
+		RunE:          runTokensList,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:           "revoke <id>",
+		Short:         "Revoke an enrollment token before it is used",
+		Args:          cobra.ExactArgs(1),
+		RunE:          runTokensRevoke,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:           "audit",
+		Short:         "Show who issued, used, revoked or tried enrollment tokens",
+		Args:          cobra.NoArgs,
+		RunE:          runTokensAudit,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:           "leases",
+		Short:         "List the leases of enrolled peers",
+		Args:          cobra.NoArgs,
+		RunE:          runTokensLeases,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	})
+
+	return cmd
+}
+
+func runTokensCreate(cmd *cobra.Command, _ []string) error {
+	ttl, err := cmd.Flags().GetDuration("ttl")
+	if err != nil {
+		return fmt.Errorf("failed to get ttl: %w", err)
+	}
+
+	body, err := json.Marshal(map[string]uint32{"ttl": uint32(ttl / time.Second)})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	var resp struct {
+		ID        string    `json:"id"`
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := callAPI(cmd, http.MethodPost, "/api/v1/enroll/tokens", bytes.NewReader(body), &resp); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(cmd.OutOrStdout(), resp.Token)
+	fmt.Fprintf(cmd.ErrOrStderr(), "Token %s expires at %s\n", resp.ID, resp.ExpiresAt.Format(time.RFC3339))
+	return nil
+}
+
+func runTokensList(cmd *cobra.Command, _ []string) error {
+	var tokens []enroll.Token
+	if err := callAPI(cmd, http.MethodGet, "/api/v1/enroll/tokens", nil, &tokens); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tISSUED BY\tISSUED AT\tEXPIRES AT")
+	for _, token := range tokens {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", token.ID, token.IssuedBy, token.IssuedAt.Format(time.RFC3339), token.ExpiresAt.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+func runTokensRevoke(cmd *cobra.Command, args []string) error {
+	if err := callAPI(cmd, http.MethodDelete, "/api/v1/enroll/tokens/"+url.PathEscape(args[0]), nil, nil); err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.ErrOrStderr(), "Revoked token %s\n", args[0])
+	return nil
+}
+
+func runTokensAudit(cmd *cobra.Command, _ []string) error {
+	var entries []enroll.AuditEntry
+	if err := callAPI(cmd, http.MethodGet, "/api/v1/enroll/audit", nil, &entries); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tACTION\tTOKEN\tACTOR\tPUBLIC KEY\tREMOTE\tERROR")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", entry.Time.Format(time.RFC3339), entry.Action, entry.TokenID, entry.Actor, entry.PublicKey, entry.Remote, entry.Error)
+	}
+	return w.Flush()
+}
+
+func runTokensLeases(cmd *cobra.Command, _ []string) error {
+	var leases []enroll.Lease
+	if err := callAPI(cmd, http.MethodGet, "/api/v1/enroll/leases", nil, &leases); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PUBLIC KEY\tADDRESSES\tRENEWED AT\tEXPIRES AT\tEXPIRED")
+	for _, lease := range leases {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\n", lease.PublicKey, strings.Join(lease.Addresses, ","),
+			lease.RenewedAt.Format(time.RFC3339), lease.ExpiresAt.Format(time.RFC3339), lease.Expired)
+	}
+	return w.Flush()
+}
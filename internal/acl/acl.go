@@ -0,0 +1,309 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package acl enforces the WireGuard ACLs with an nftables table per
+// interface, replaced as a whole whenever the rules or the peers they name
+// change.
+package acl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+// TablePrefix starts the name of every table, followed by the interface
+const TablePrefix = "kubewg_acl_"
+
+const commandTimeout = 10 * time.Second
+
+// profilePrefix keys the members of an access profile. A rule entry with
+// a colon is an address, so no rule can name a peer called like that
+const profilePrefix = "profile:"
+
+var ErrNFT = errors.New("nft command failed")
+
+// Command runs nft with args, feeding it stdin, and returns its combined
+// output.
+type Command func(ctx context.Context, stdin string, args ...string) ([]byte, error)
+
+// Exec runs the nft found on PATH.
+func Exec(ctx context.Context, stdin string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "nft", args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	return cmd.CombinedOutput()
+}
+
+// Enforcer keeps the ACL of one interface in place.
+type Enforcer struct {
+	config   *config.ACL
+	profiles []config.AccessProfile
+	iface    string
+	table    string
+	command  Command
+	mu       sync.Mutex
+	applied  string
+}
+
+// New enforces acl and the access profiles of enrolled peers on traffic
+// entering from iface, running nft through command, Exec when nil.
+func New(acl *config.ACL, profiles []config.AccessProfile, iface string, command Command) *Enforcer {
+	if command == nil {
+		command = Exec
+	}
+	return &Enforcer{config: acl, profiles: profiles, iface: iface, table: TableName(iface), command: command}
+}
+
+// TableName returns the name of the table holding the ACL of iface.
+func TableName(iface string) string {
+	return TablePrefix + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, iface)
+}
+
+// Sync applies the ACL with the peer names resolved against peers. The
+// table is only replaced when the ruleset changed or it went missing.
+func (e *Enforcer) Sync(ctx context.Context, peers []config.WireGuardPeer) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	ruleset := e.Ruleset(peers)
+	if ruleset == e.applied {
+		exists, err := e.exists(ctx)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+	}
+
+	if _, err := e.run(ctx, ruleset, "-f", "-"); err != nil {
+		return err
+	}
+	e.applied = ruleset
+	return nil
+}
+
+// Flush deletes the table.
+func (e *Enforcer) Flush(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.applied = ""
+	// Declaring the table first makes deleting it succeed either way
+	_, err := e.run(ctx, fmt.Sprintf("table inet %s\ndelete table inet %s\n", e.table, e.table), "-f", "-")
+	return err
+}
+
+// Ruleset renders the nftables script replacing the table. Every packet
+// entering from the interface, whether for this host or forwarded, goes
+// through the acl chain. The rules of the access profiles come before the
+// configured ones, a profile's peers get nothing beyond it.
+func (e *Enforcer) Ruleset(peers []config.WireGuardPeer) string {
+	allowedIPs := make(map[string][]string, len(peers))
+	for _, peer := range peers {
+		if peer.Name != "" && !strings.Contains(peer.Name, ":") {
+			allowedIPs[peer.Name] = peer.AllowedIPs
+		}
+		if profile, ok := peer.Metadata[config.MetadataProfile]; ok {
+			allowedIPs[profilePrefix+profile] = append(allowedIPs[profilePrefix+profile], peer.AllowedIPs...)
+		}
+	}
+	rules := make([]config.ACLRule, 0, 2*len(e.profiles)+len(e.config.Rules))
+	for _, profile := range e.profiles {
+		members := []string{profilePrefix + profile.Name}
+		rules = append(rules,
+			config.ACLRule{From: members, To: profile.AllowedIPs, Ports: profile.Ports, Action: config.ACLAllow},
+			config.ACLRule{From: members, Action: config.ACLDeny},
+		)
+	}
+	rules = append(rules, e.config.Rules...)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "table inet %s\ndelete table inet %s\n", e.table, e.table)
+	fmt.Fprintf(&b, "table inet %s {\n", e.table)
+	for _, hook := range []string{"input", "forward"} {
+		fmt.Fprintf(&b, "\tchain %s {\n\t\ttype filter hook %s priority filter; policy accept;\n\t\tiifname %q jump acl\n\t}\n", hook, hook, e.iface)
+	}
+	b.WriteString("\tchain acl {\n\t\tct state established,related accept\n")
+	for _, rule := range rules {
+		for _, line := range ruleLines(rule, allowedIPs) {
+			fmt.Fprintf(&b, "\t\t%s\n", line)
+		}
+	}
+	fmt.Fprintf(&b, "\t\t%s\n\t}\n}\n", verdict(e.config.Default))
+	return b.String()
+}
+
+// ruleLines renders rule as one nftables rule per address family and
+// port. A side naming only peers that aren't known, or only addresses of
+// the other family, can't match and leaves the family out instead of
+// matching everything.
+func ruleLines(rule config.ACLRule, allowedIPs map[string][]string) []string {
+	from := prefixes(rule.From, allowedIPs)
+	to := prefixes(rule.To, allowedIPs)
+
+	var matches []string
+	if len(rule.From) == 0 && len(rule.To) == 0 {
+		matches = append(matches, "")
+	} else {
+		for _, family := range []string{"ip", "ip6"} {
+			fromFamily := ofFamily(from, family)
+			toFamily := ofFamily(to, family)
+			if (len(rule.From) > 0 && len(fromFamily) == 0) || (len(rule.To) > 0 && len(toFamily) == 0) {
+				continue
+			}
+			var match []string
+			if len(fromFamily) > 0 {
+				match = append(match, family+" saddr "+set(fromFamily))
+			}
+			if len(toFamily) > 0 {
+				match = append(match, family+" daddr "+set(toFamily))
+			}
+			matches = append(matches, strings.Join(match, " "))
+		}
+	}
+
+	ports := []string{""}
+	if len(rule.Ports) > 0 {
+		ports = ports[:0]
+		for _, port := range rule.Ports {
+			// Validated with the config
+			parsed, _ := config.ParseACLPort(port)
+			switch {
+			case parsed.First == 0:
+				ports = append(ports, "meta l4proto "+parsed.Protocol)
+			case parsed.First == parsed.Last:
+				ports = append(ports, fmt.Sprintf("%s dport %d", parsed.Protocol, parsed.First))
+			default:
+				ports = append(ports, fmt.Sprintf("%s dport %d-%d", parsed.Protocol, parsed.First, parsed.Last))
+			}
+		}
+	}
+
+	var lines []string
+	for _, match := range matches {
+		for _, port := range ports {
+			parts := slices.DeleteFunc([]string{match, port, verdict(rule.Action)}, func(part string) bool {
+				return part == ""
+			})
+			lines = append(lines, strings.Join(parts, " "))
+		}
+	}
+	return lines
+}
+
+// prefixes resolves entries to prefixes, peer names to their AllowedIPs.
+// Prefixes covered by another are dropped, nftables rejects overlapping
+// elements in a set.
+func prefixes(entries []string, allowedIPs map[string][]string) []netip.Prefix {
+	var resolved []netip.Prefix
+	for _, entry := range entries {
+		addresses, ok := allowedIPs[entry]
+		if !ok {
+			addresses = []string{entry}
+		}
+		for _, address := range addresses {
+			if prefix, err := netip.ParsePrefix(address); err == nil {
+				resolved = append(resolved, prefix.Masked())
+			}
+		}
+	}
+
+	slices.SortFunc(resolved, func(a, b netip.Prefix) int {
+		if a.Bits() != b.Bits() {
+			return a.Bits() - b.Bits()
+		}
+		return a.Addr().Compare(b.Addr())
+	})
+	var merged []netip.Prefix
+	for _, prefix := range resolved {
+		covered := slices.ContainsFunc(merged, func(other netip.Prefix) bool {
+			return other.Bits() <= prefix.Bits() && other.Contains(prefix.Addr())
+		})
+		if !covered {
+			merged = append(merged, prefix)
+		}
+	}
+	return merged
+}
+
+func ofFamily(prefixes []netip.Prefix, family string) []netip.Prefix {
+	var matching []netip.Prefix
+	for _, prefix := range prefixes {
+		if prefix.Addr().Is4() == (family == "ip") {
+			matching = append(matching, prefix)
+		}
+	}
+	return matching
+}
+
+func set(prefixes []netip.Prefix) string {
+	elements := make([]string, len(prefixes))
+	for i, prefix := range prefixes {
+		elements[i] = prefix.String()
+	}
+	return "{ " + strings.Join(elements, ", ") + " }"
+}
+
+func verdict(action string) string {
+	if action == config.ACLDeny {
+		return "drop"
+	}
+	return "accept"
+}
+
+func (e *Enforcer) exists(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	output, err := e.command(ctx, "", "list", "table", "inet", e.table)
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		return false, nil
+	default:
+		return false, fmt.Errorf("%w: list table %s: %w: %s", ErrNFT, e.table, err, strings.TrimSpace(string(output)))
+	}
+}
+
+func (e *Enforcer) run(ctx context.Context, stdin string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	output, err := e.command(ctx, stdin, args...)
+	if err != nil {
+		return output, fmt.Errorf("%w: %s: %w: %s", ErrNFT, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return output, nil
+}
@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package acl_test
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/acl"
+	"github.com/kubewg-net/container/internal/config"
+)
+
+// fakeNFT records the scripts it is fed and answers "list table" with exit
+// status 1 until a script was applied.
+type fakeNFT struct {
+	mu      sync.Mutex
+	scripts []string
+	exists  bool
+}
+
+func (f *fakeNFT) run(ctx context.Context, stdin string, args ...string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if args[0] == "list" {
+		if !f.exists {
+			return nil, exec.CommandContext(ctx, "sh", "-c", "exit 1").Run()
+		}
+		return nil, nil
+	}
+	f.scripts = append(f.scripts, stdin)
+	// Flushing only declares and deletes the table
+	f.exists = strings.Contains(stdin, "{")
+	return nil, nil
+}
+
+func TestRuleset(t *testing.T) {
+	t.Parallel()
+
+	enforcer := acl.New(&config.ACL{
+		Enabled: true,
+		Default: config.ACLDeny,
+		Rules: []config.ACLRule{
+			{From: []string{"laptop"}, To: []string{"10.0.0.0/24", "10.0.0.1/32"}, Ports: []string{"tcp/22", "udp/60000-61000"}, Action: config.ACLAllow},
+			{From: []string{"phone", "192.168.0.0/16"}, Action: config.ACLDeny},
+			{From: []string{"unknown"}, Action: config.ACLAllow},
+			{Ports: []string{"udp"}, Action: config.ACLAllow},
+		},
+	}, nil, "wg0", nil)
+	peers := []config.WireGuardPeer{
+		{Name: "laptop", AllowedIPs: []string{"10.0.0.2/32", "fd00::2/128"}},
+		{Name: "phone", AllowedIPs: []string{"10.0.0.3/32"}},
+	}
+
+	ruleset := enforcer.Ruleset(peers)
+	for _, want := range []string{
+		"table inet kubewg_acl_wg0 {",
+		"type filter hook forward priority filter; policy accept;",
+		`iifname "wg0" jump acl`,
+		"ct state established,related accept",
+		"ip saddr { 10.0.0.2/32 } ip daddr { 10.0.0.0/24 } tcp dport 22 accept",
+		"ip saddr { 10.0.0.2/32 } ip daddr { 10.0.0.0/24 } udp dport 60000-61000 accept",
+		"ip saddr { 192.168.0.0/16, 10.0.0.3/32 } drop",
+		"meta l4proto udp accept",
+		"\t\tdrop\n\t}",
+	} {
+		if !strings.Contains(ruleset, want) {
+			t.Errorf("expected the ruleset to contain %q, got:\n%s", want, ruleset)
+		}
+	}
+	// The laptop's IPv6 address has nowhere to go, and an unknown peer
+	// must not turn into a rule matching everything
+	for _, unwanted := range []string{"ip6", "\t\taccept\n"} {
+		if strings.Contains(ruleset, unwanted) {
+			t.Errorf("expected the ruleset not to contain %q, got:\n%s", unwanted, ruleset)
+		}
+	}
+}
+
+func TestRulesetProfiles(t *testing.T) {
+	t.Parallel()
+
+	profiles := []config.AccessProfile{
+		{Name: "devs", AllowedIPs: []string{"10.96.0.0/12"}, Ports: []string{"tcp/443"}},
+		{Name: "ops", AllowedIPs: []string{"10.96.0.0/12", "192.168.1.0/24"}},
+	}
+	enforcer := acl.New(&config.ACL{Enabled: true, Default: config.ACLAllow}, profiles, "wg0", nil)
+	ruleset := enforcer.Ruleset([]config.WireGuardPeer{
+		{AllowedIPs: []string{"10.0.0.2/32"}, Metadata: map[string]string{config.MetadataProfile: "devs"}},
+		{AllowedIPs: []string{"10.0.0.3/32"}, Metadata: map[string]string{config.MetadataProfile: "devs"}},
+	})
+
+	for _, want := range []string{
+		"ip saddr { 10.0.0.2/32, 10.0.0.3/32 } ip daddr { 10.96.0.0/12 } tcp dport 443 accept\n\t\tip saddr { 10.0.0.2/32, 10.0.0.3/32 } drop",
+	} {
+		if !strings.Contains(ruleset, want) {
+			t.Errorf("expected the ruleset to contain %q, got:\n%s", want, ruleset)
+		}
+	}
+	// Nobody enrolled with ops, so it has no rules
+	if strings.Contains(ruleset, "192.168.1.0/24") {
+		t.Errorf("expected no rules for a profile without peers, got:\n%s", ruleset)
+	}
+}
+
+func TestEnforcerSync(t *testing.T) {
+	t.Parallel()
+
+	nft := &fakeNFT{}
+	enforcer := acl.New(&config.ACL{Enabled: true, Default: config.ACLAllow}, nil, "wg0", nft.run)
+	ctx := context.Background()
+
+	if err := enforcer.Sync(ctx, nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := enforcer.Sync(ctx, nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(nft.scripts) != 1 {
+		t.Fatalf("expected an unchanged ruleset to be applied once, got %d", len(nft.scripts))
+	}
+
+	// A table removed behind our back is put back
+	nft.exists = false
+	if err := enforcer.Sync(ctx, nil); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(nft.scripts) != 2 {
+		t.Fatalf("expected the missing table to be applied again, got %d scripts", len(nft.scripts))
+	}
+
+	if err := enforcer.Flush(ctx); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if nft.exists {
+		t.Error("expected the table to be deleted")
+	}
+}
+
+func TestTableName(t *testing.T) {
+	t.Parallel()
+
+	if name := acl.TableName("wg-site.1"); name != "kubewg_acl_wg_site_1" {
+		t.Errorf("expected kubewg_acl_wg_site_1, got %s", name)
+	}
+}
@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+// Package age decrypts files encrypted with age (https://age-encryption.org)
+// to X25519 recipients, binary or armored, with filippo.io/age. Encrypting
+// is left to the age and sops tools.
+package age
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+const intro = "age-encryption.org/v1\n"
+
+var ErrNoIdentity = errors.New("no identity matches a recipient of the age file")
+
+// Identity decrypts the file keys wrapped for it.
+type Identity = age.Identity
+
+// Encrypted reports whether data looks like an age file, binary or armored.
+func Encrypted(data []byte) bool {
+	data = bytes.TrimLeft(data, " \t\r\n")
+	return bytes.HasPrefix(data, []byte(intro)) || bytes.HasPrefix(data, []byte(armor.Header))
+}
+
+// Decrypt decrypts an age file with the first of identities that it was
+// encrypted to.
+func Decrypt(data []byte, identities []Identity) ([]byte, error) {
+	var reader io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte(armor.Header)) {
+		reader = armor.NewReader(bytes.NewReader(bytes.TrimLeft(data, " \t\r\n")))
+	}
+
+	decrypted, err := age.Decrypt(reader, identities...)
+	var noIdentity *age.NoIdentityMatchError
+	if errors.As(err, &noIdentity) {
+		return nil, ErrNoIdentity
+	} else if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(decrypted)
+}
+
+// ParseIdentities parses an identity file as written by age-keygen: one
+// X25519 identity per line, blank lines and lines starting with # ignored.
+func ParseIdentities(data []byte) ([]Identity, error) {
+	var identities []Identity
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		identity, err := age.ParseX25519Identity(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		identities = append(identities, identity)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
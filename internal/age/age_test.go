@@ -0,0 +1,91 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+package age_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/age"
+)
+
+// The files in testdata were written by the age 1.2.1 CLI to the identity
+// age-keygen wrote to key.txt, other.age to an unrelated one.
+const plaintext = "wireguard:\n  private_key: secret\n"
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return data
+}
+
+func TestDecrypt(t *testing.T) {
+	t.Parallel()
+
+	identities, err := age.ParseIdentities(readTestdata(t, "key.txt"))
+	if err != nil || len(identities) != 1 {
+		t.Fatalf("expected one identity, got %d and %v", len(identities), err)
+	}
+
+	for _, name := range []string{"config.yaml.age", "config.yaml.age.asc"} {
+		encrypted := readTestdata(t, name)
+		if !age.Encrypted(encrypted) {
+			t.Errorf("%s: expected the file to be recognized as encrypted", name)
+		}
+		decrypted, err := age.Decrypt(encrypted, identities)
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", name, err)
+		}
+		if string(decrypted) != plaintext {
+			t.Errorf("%s: expected the plaintext back, got %q", name, decrypted)
+		}
+	}
+
+	if _, err := age.Decrypt(readTestdata(t, "other.age"), identities); !errors.Is(err, age.ErrNoIdentity) {
+		t.Errorf("expected %v, got %v", age.ErrNoIdentity, err)
+	}
+	tampered := bytes.Clone(readTestdata(t, "config.yaml.age"))
+	tampered[len(tampered)-1] ^= 1
+	if _, err := age.Decrypt(tampered, identities); err == nil {
+		t.Error("expected a tampered payload to fail")
+	}
+	if age.Encrypted([]byte(plaintext)) {
+		t.Error("expected a plain config not to be recognized as encrypted")
+	}
+}
+
+func TestParseIdentities(t *testing.T) {
+	t.Parallel()
+
+	// From the age testkit, the secret is 32 bytes of 0x42
+	identities, err := age.ParseIdentities([]byte("# created: today\n\nAGE-SECRET-KEY-1GFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPYYSJZGFPQ4EGAEX\n"))
+	if err != nil || len(identities) != 1 {
+		t.Errorf("expected the testkit identity to parse, got %d and %v", len(identities), err)
+	}
+	if _, err := age.ParseIdentities([]byte("age1qyqszqgpqyqszqgpqyqszqgpqyqszqgpqyqszqgpqyqszqgpqyqs3290gq\n")); err == nil {
+		t.Error("expected a recipient to be refused as an identity")
+	}
+}
@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/kubewg-net/container/internal/audit"
+)
+
+// statusRecorder remembers the status a handler responded with.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// audited records calls that can change state once handler has answered
+// them. Reads are left out, they would drown the log in polling.
+func (s *Server) audited(handler http.HandlerFunc) http.HandlerFunc {
+	if s.backend.Audit == nil {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			handler(w, r)
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler(recorder, r)
+
+		result := audit.ResultSuccess
+		if recorder.status >= http.StatusBadRequest {
+			result = audit.ResultFailure
+		}
+		s.auditCall(r, callerName(r), result, recorder.status)
+	}
+}
+
+func (s *Server) auditCall(r *http.Request, caller, result string, status int) {
+	s.backend.Audit.Record(audit.Record{
+		Action: audit.ActionAPICall,
+		Actor:  caller,
+		Target: r.Method + " " + r.URL.Path,
+		Result: result,
+		Details: map[string]string{
+			"remote": r.RemoteAddr,
+			"status": strconv.Itoa(status),
+		},
+	})
+}
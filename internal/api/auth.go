@@ -0,0 +1,266 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubewg-net/container/internal/audit"
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/cryptopolicy"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	roleNone     = ""
+	roleAdmin    = config.RoleAdmin
+	roleReadOnly = config.RoleReadOnly
+
+	// tokenReviewCacheTTL bounds how long a TokenReview verdict is reused,
+	// so revoked ServiceAccount tokens stop working quickly
+	tokenReviewCacheTTL = time.Minute
+
+	serviceAccountPrefix = "system:serviceaccount:"
+)
+
+var (
+	ErrUnauthenticated = errors.New("authentication required")
+	ErrForbidden       = errors.New("insufficient permissions")
+	ErrInvalidToken    = errors.New("invalid token")
+	ErrInsecureToken   = errors.New("bearer tokens are only accepted over TLS or on a loopback listener")
+)
+
+type identity struct {
+	Name string
+	Role string
+}
+
+type contextKey struct{}
+
+// identityFromContext returns the caller authenticated by the middleware,
+// if any.
+func identityFromContext(ctx context.Context) (*identity, bool) {
+	id, ok := ctx.Value(contextKey{}).(*identity)
+	return id, ok
+}
+
+// callerName names the authenticated caller for audit trails, or
+// "anonymous" when the API runs without authentication.
+func callerName(r *http.Request) string {
+	if id, ok := identityFromContext(r.Context()); ok {
+		return id.Name
+	}
+	return "anonymous"
+}
+
+type cachedReview struct {
+	identity *identity
+	expires  time.Time
+}
+
+// authenticator resolves the caller of a request from a verified client
+// certificate, a static bearer token or a Kubernetes ServiceAccount token.
+type authenticator struct {
+	config  *config.API
+	tokens  map[[sha256.Size]byte]identity
+	kube    kubernetes.Interface
+	mu      sync.Mutex
+	reviews map[[sha256.Size]byte]cachedReview
+}
+
+func newAuthenticator(config *config.API, kube kubernetes.Interface) *authenticator {
+	a := &authenticator{
+		config:  config,
+		tokens:  make(map[[sha256.Size]byte]identity, len(config.Auth.Tokens)),
+		kube:    kube,
+		reviews: make(map[[sha256.Size]byte]cachedReview),
+	}
+	for _, token := range config.Auth.Tokens {
+		a.tokens[cryptopolicy.HashToken(token.Token)] = identity{
+			Name: "token:" + token.Name,
+			Role: token.Role,
+		}
+	}
+	return a
+}
+
+// enabled reports whether any authentication method is configured. Without
+// one the API is open, which is only sensible on a loopback listener.
+func (a *authenticator) enabled() bool {
+	return len(a.config.Auth.Tokens) > 0 ||
+		a.config.Auth.TokenReview.Enabled ||
+		a.config.TLS.ClientCAFile != ""
+}
+
+func (a *authenticator) authenticate(r *http.Request) (*identity, error) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		cert := r.TLS.VerifiedChains[0][0]
+		role := roleReadOnly
+		if slices.Contains(a.config.TLS.AdminCommonNames, cert.Subject.CommonName) {
+			role = roleAdmin
+		}
+		return &identity{Name: "cert:" + cert.Subject.CommonName, Role: role}, nil
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, ErrUnauthenticated
+	}
+	// A token sent in the clear can be replayed by anyone on the path
+	if r.TLS == nil && !loopbackListener(r) {
+		return nil, ErrInsecureToken
+	}
+
+	hash := cryptopolicy.HashToken(token)
+	if id, ok := a.tokens[hash]; ok {
+		return &id, nil
+	}
+
+	if a.config.Auth.TokenReview.Enabled && a.kube != nil {
+		return a.review(r.Context(), token, hash)
+	}
+
+	return nil, ErrInvalidToken
+}
+
+func (a *authenticator) review(ctx context.Context, token string, hash [sha256.Size]byte) (*identity, error) {
+	a.mu.Lock()
+	cached, ok := a.reviews[hash]
+	a.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		if cached.identity == nil {
+			return nil, ErrInvalidToken
+		}
+		return cached.identity, nil
+	}
+
+	review, err := a.kube.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token:     token,
+			Audiences: a.config.Auth.TokenReview.Audiences,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to review token: %w", err)
+	}
+
+	var id *identity
+	if review.Status.Authenticated {
+		if role := a.serviceAccountRole(review.Status.User.Username); role != roleNone {
+			id = &identity{Name: review.Status.User.Username, Role: role}
+		}
+	}
+
+	a.mu.Lock()
+	now := time.Now()
+	for key, entry := range a.reviews {
+		if now.After(entry.expires) {
+			delete(a.reviews, key)
+		}
+	}
+	a.reviews[hash] = cachedReview{identity: id, expires: now.Add(tokenReviewCacheTTL)}
+	a.mu.Unlock()
+
+	if id == nil {
+		return nil, ErrInvalidToken
+	}
+	return id, nil
+}
+
+// loopbackListener reports whether r came in on a listener bound to a
+// loopback address.
+func loopbackListener(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok {
+		return false
+	}
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	return err == nil && addrPort.Addr().Unmap().IsLoopback()
+}
+
+// serviceAccountRole maps a "system:serviceaccount:<ns>:<name>" username to
+// the role granted through the namespace/name lists in the config.
+func (a *authenticator) serviceAccountRole(username string) string {
+	name, ok := strings.CutPrefix(username, serviceAccountPrefix)
+	if !ok {
+		return roleNone
+	}
+	name = strings.Replace(name, ":", "/", 1)
+
+	switch {
+	case slices.Contains(a.config.Auth.TokenReview.AdminServiceAccounts, name):
+		return roleAdmin
+	case slices.Contains(a.config.Auth.TokenReview.ReadOnlyServiceAccounts, name):
+		return roleReadOnly
+	default:
+		return roleNone
+	}
+}
+
+// require wraps handler so it only runs for callers holding role. Admins
+// may call read-only endpoints too. Rejected calls are audited whatever
+// their method.
+func (s *Server) require(role string, handler http.HandlerFunc) http.HandlerFunc {
+	return s.requireIf(func(*http.Request) string { return role }, handler)
+}
+
+// requireIf is require for endpoints whose role depends on the request,
+// such as a read-only listing that turns admin when it includes secrets.
+func (s *Server) requireIf(roleFor func(r *http.Request) string, handler http.HandlerFunc) http.HandlerFunc {
+	handler = s.audited(handler)
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Without an auth method only local callers are trusted
+		if !s.auth.enabled() && loopbackListener(r) {
+			handler(w, r)
+			return
+		}
+
+		id, err := s.auth.authenticate(r)
+		if err != nil {
+			slog.Warn("API authentication failed", "path", r.URL.Path, "remote", r.RemoteAddr, "error", err.Error())
+			w.Header().Set("WWW-Authenticate", `Bearer realm="kubewg"`)
+			writeError(w, http.StatusUnauthorized, ErrUnauthenticated)
+			s.auditCall(r, "unknown", audit.ResultDenied, http.StatusUnauthorized)
+			return
+		}
+
+		if role := roleFor(r); id.Role != role && id.Role != roleAdmin {
+			slog.Warn("API authorization failed", "path", r.URL.Path, "caller", id.Name, "role", id.Role)
+			writeError(w, http.StatusForbidden, ErrForbidden)
+			s.auditCall(r, id.Name, audit.ResultDenied, http.StatusForbidden)
+			return
+		}
+
+		handler(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, id)))
+	}
+}
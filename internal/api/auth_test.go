@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package api_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/api"
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/peers"
+	"github.com/kubewg-net/container/internal/punch"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testServer(t *testing.T) http.Handler {
+	t.Helper()
+
+	c := &config.Config{}
+	c.API.Auth.Tokens = []config.APIToken{
+		{Name: "admin", Token: "admin-token", Role: config.RoleAdmin},
+		{Name: "viewer", Token: "viewer-token", Role: config.RoleReadOnly},
+	}
+	return api.NewServer(c, &api.Backend{Registry: peers.NewRegistry(nil)}).Handler()
+}
+
+func call(handler http.Handler, path, token string, local net.IP) int {
+	return callMethod(handler, http.MethodGet, path, token, local)
+}
+
+func callMethod(handler http.Handler, method, path, token string, local net.IP) int {
+	req := httptest.NewRequest(method, path, nil)
+	req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, &net.TCPAddr{IP: local, Port: 8080}))
+	req.Header.Set("Authorization", "Bearer "+token)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder.Code
+}
+
+func TestRoles(t *testing.T) {
+	t.Parallel()
+
+	handler := testServer(t)
+	loopback := net.IPv4(127, 0, 0, 1)
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		token    string
+		expected int
+	}{
+		{"read-only lists peers", http.MethodGet, "/api/v1/peers", "viewer-token", http.StatusOK},
+		{"read-only can't export preshared keys", http.MethodGet, "/api/v1/peers?include_preshared_keys=true", "viewer-token", http.StatusForbidden},
+		{"admin exports preshared keys", http.MethodGet, "/api/v1/peers?include_preshared_keys=true", "admin-token", http.StatusOK},
+		{"read-only can't fetch client configs", http.MethodGet, "/api/v1/client-config?public_key=key", "viewer-token", http.StatusForbidden},
+		{"admin fetches client configs", http.MethodGet, "/api/v1/client-config?public_key=key", "admin-token", http.StatusServiceUnavailable},
+		{"read-only can't announce to the rendezvous", http.MethodPost, punch.RendezvousPath, "viewer-token", http.StatusForbidden},
+		{"unknown token", http.MethodGet, "/api/v1/peers", "other", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := callMethod(handler, test.method, test.path, test.token, loopback); got != test.expected {
+				t.Errorf("expected %d, got %d", test.expected, got)
+			}
+		})
+	}
+}
+
+func TestTokensNeedTLSOffLoopback(t *testing.T) {
+	t.Parallel()
+
+	handler := testServer(t)
+	if got := call(handler, "/api/v1/peers", "admin-token", net.IPv4(192, 0, 2, 1)); got != http.StatusUnauthorized {
+		t.Errorf("expected a token over plain HTTP to be refused, got %d", got)
+	}
+	if got := call(handler, "/api/v1/peers", "admin-token", net.IPv6loopback); got != http.StatusOK {
+		t.Errorf("expected a token on the IPv6 loopback to be accepted, got %d", got)
+	}
+}
+
+func TestNoAuthOnlyOnLoopback(t *testing.T) {
+	t.Parallel()
+
+	handler := api.NewServer(&config.Config{}, &api.Backend{Registry: peers.NewRegistry(nil)}).Handler()
+	if got := call(handler, "/api/v1/peers", "", net.IPv4(127, 0, 0, 1)); got != http.StatusOK {
+		t.Errorf("expected an unauthenticated call on loopback to be accepted, got %d", got)
+	}
+	if got := call(handler, "/api/v1/peers", "", net.IPv4(192, 0, 2, 1)); got != http.StatusUnauthorized {
+		t.Errorf("expected an unauthenticated call off loopback to be refused, got %d", got)
+	}
+}
+
+func TestNoTokenReviewWhenDisabled(t *testing.T) {
+	t.Parallel()
+
+	c := &config.Config{}
+	c.API.Auth.Tokens = []config.APIToken{{Name: "admin", Token: "admin-token", Role: config.RoleAdmin}}
+	client := fake.NewSimpleClientset()
+	handler := api.NewServer(c, &api.Backend{Registry: peers.NewRegistry(nil), Kube: client}).Handler()
+
+	if got := call(handler, "/api/v1/peers", "service-account-token", net.IPv4(127, 0, 0, 1)); got != http.StatusUnauthorized {
+		t.Errorf("expected an unknown token to be refused, got %d", got)
+	}
+	if actions := client.Actions(); len(actions) != 0 {
+		t.Errorf("expected no token review, got %v", actions)
+	}
+}
@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/kubewg-net/container/internal/clientconfig"
+	"github.com/kubewg-net/container/internal/peers"
+)
+
+var (
+	ErrMissingPublicKey = errors.New("public_key query parameter is required")
+	ErrUnknownFormat    = errors.New("format must be conf, QR codes are rendered by the client once it filled in its private key")
+)
+
+// handleClientConfig renders the wg-quick config for a registered peer. It
+// carries a placeholder for the private key the server never sees, so it is
+// only served as text: a QR code of it would be of no use to scan.
+func (s *Server) handleClientConfig(w http.ResponseWriter, r *http.Request) {
+	if s.backend.Device == nil {
+		writeError(w, http.StatusServiceUnavailable, ErrWireGuardDisabled)
+		return
+	}
+
+	if format := r.URL.Query().Get("format"); format != "" && format != "conf" {
+		writeError(w, http.StatusBadRequest, ErrUnknownFormat)
+		return
+	}
+
+	publicKey := r.URL.Query().Get("public_key")
+	if publicKey == "" {
+		writeError(w, http.StatusBadRequest, ErrMissingPublicKey)
+		return
+	}
+
+	peer, ok := s.backend.Registry.Get(publicKey)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%w: %s", peers.ErrPeerNotFound, publicKey))
+		return
+	}
+
+	file, err := clientconfig.Build(&s.config.WireGuard, s.backend.Device.PublicKey(), &peer)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write(file.Render())
+}
@@ -0,0 +1,211 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"runtime/pprof"
+	"time"
+
+	"github.com/kubewg-net/container/internal/health"
+	"github.com/kubewg-net/container/internal/ipam"
+	"github.com/kubewg-net/container/internal/preflight"
+	"github.com/kubewg-net/container/internal/prober"
+)
+
+var (
+	ErrLogsDisabled = errors.New("log buffering is not enabled")
+)
+
+type debugInterface struct {
+	Name       string   `json:"name"`
+	PublicKey  string   `json:"public_key"`
+	ListenPort uint16   `json:"listen_port"`
+	MTU        int      `json:"mtu"`
+	Addresses  []string `json:"addresses"`
+	Endpoint   string   `json:"endpoint,omitempty"`
+	FwMark     uint32   `json:"fwmark,omitempty"`
+	Network    string   `json:"network,omitempty"`
+	Topology   string   `json:"topology"`
+	Hub        bool     `json:"hub"`
+}
+
+type debugPeer struct {
+	Name       string   `json:"name,omitempty"`
+	PublicKey  string   `json:"public_key"`
+	Endpoint   string   `json:"endpoint,omitempty"`
+	AllowedIPs []string `json:"allowed_ips"`
+	// Configured is false for peers found on the interface that no
+	// source knows about, Programmed is false for peers not on it yet
+	Configured          bool       `json:"configured"`
+	Programmed          bool       `json:"programmed"`
+	LastHandshake       *time.Time `json:"last_handshake,omitempty"`
+	HandshakeAge        *float64   `json:"handshake_age_seconds,omitempty"`
+	ReceiveBytes        int64      `json:"receive_bytes"`
+	TransmitBytes       int64      `json:"transmit_bytes"`
+	PersistentKeepalive float64    `json:"persistent_keepalive_seconds,omitempty"`
+}
+
+type debugHealth struct {
+	Degraded   bool               `json:"degraded"`
+	Conditions []health.Condition `json:"conditions"`
+}
+
+type debugStatus struct {
+	Time        time.Time        `json:"time"`
+	Interface   *debugInterface  `json:"interface,omitempty"`
+	Peers       []debugPeer      `json:"peers"`
+	Allocations []ipam.PoolUsage `json:"allocations"`
+	Probes      []prober.Result  `json:"probes"`
+	Health      *debugHealth     `json:"health,omitempty"`
+	// Platform is what the node offered at startup
+	Platform *preflight.Platform `json:"platform,omitempty"`
+	Errors   []string            `json:"errors,omitempty"`
+}
+
+// handleDebugStatus returns a snapshot of the node for dashboards and
+// support bundles. Keys are never included, and a component that fails to
+// report is listed under errors instead of failing the whole document.
+func (s *Server) handleDebugStatus(w http.ResponseWriter, _ *http.Request) {
+	now := time.Now()
+	status := debugStatus{
+		Time:        now,
+		Peers:       []debugPeer{},
+		Allocations: []ipam.PoolUsage{},
+		Probes:      []prober.Result{},
+	}
+
+	if s.backend.Device != nil && s.backend.Registry != nil {
+		wg := &s.config.WireGuard
+		status.Interface = &debugInterface{
+			Name:       s.backend.Device.Name(),
+			PublicKey:  s.backend.Device.PublicKey().String(),
+			ListenPort: wg.ListenPort,
+			MTU:        s.backend.Device.MTU(),
+			Addresses:  wg.Addresses,
+			Endpoint:   wg.Endpoint,
+			FwMark:     wg.FwMark,
+			Network:    wg.Network.Name,
+			Topology:   wg.EffectiveTopology(),
+			Hub:        wg.IsHub(),
+		}
+		peers, err := s.debugPeers(now)
+		if err != nil {
+			slog.Warn("Failed to read interface peers for status", "error", err.Error())
+			status.Errors = append(status.Errors, "peers: "+err.Error())
+		}
+		status.Peers = peers
+	}
+
+	if s.backend.Enroller != nil {
+		status.Allocations = s.backend.Enroller.Allocations()
+	}
+	if s.backend.Prober != nil {
+		status.Probes = s.backend.Prober.Results()
+	}
+	if s.backend.Health != nil {
+		status.Health = &debugHealth{
+			Degraded:   s.backend.Health.Degraded(),
+			Conditions: s.backend.Health.Conditions(),
+		}
+	}
+	status.Platform = s.backend.Platform
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// debugPeers merges the configured peers with the ones programmed on the
+// interface. The configured peers are returned even if the interface can't
+// be read.
+func (s *Server) debugPeers(now time.Time) ([]debugPeer, error) {
+	configured := s.config.WireGuard.TopologyPeers(s.backend.Registry.List())
+	peers := make([]debugPeer, 0, len(configured))
+	index := make(map[string]int, len(configured))
+	for i := range configured {
+		index[configured[i].PublicKey] = len(peers)
+		peers = append(peers, debugPeer{
+			Name:       configured[i].Name,
+			PublicKey:  configured[i].PublicKey,
+			Endpoint:   configured[i].Endpoint,
+			AllowedIPs: configured[i].AllowedIPs,
+			Configured: true,
+		})
+	}
+
+	live, err := s.backend.Device.Peers()
+	if err != nil {
+		return peers, err
+	}
+	for i := range live {
+		key := live[i].PublicKey.String()
+		j, ok := index[key]
+		if !ok {
+			j = len(peers)
+			peers = append(peers, debugPeer{PublicKey: key})
+		}
+		peer := &peers[j]
+		peer.Programmed = true
+		if live[i].Endpoint != nil {
+			peer.Endpoint = live[i].Endpoint.String()
+		}
+		peer.AllowedIPs = make([]string, 0, len(live[i].AllowedIPs))
+		for _, allowedIP := range live[i].AllowedIPs {
+			peer.AllowedIPs = append(peer.AllowedIPs, allowedIP.String())
+		}
+		if !live[i].LastHandshakeTime.IsZero() {
+			handshake := live[i].LastHandshakeTime
+			age := now.Sub(handshake).Seconds()
+			peer.LastHandshake = &handshake
+			peer.HandshakeAge = &age
+		}
+		peer.ReceiveBytes = live[i].ReceiveBytes
+		peer.TransmitBytes = live[i].TransmitBytes
+		peer.PersistentKeepalive = live[i].PersistentKeepaliveInterval.Seconds()
+	}
+	return peers, nil
+}
+
+// handleDebugLogs returns the most recent log lines as plain text.
+func (s *Server) handleDebugLogs(w http.ResponseWriter, _ *http.Request) {
+	if s.backend.Logs == nil {
+		writeError(w, http.StatusServiceUnavailable, ErrLogsDisabled)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if _, err := s.backend.Logs.WriteTo(w); err != nil {
+		slog.Warn("Failed to write logs", "error", err.Error())
+	}
+}
+
+// handleDebugGoroutines dumps the stacks of every goroutine, in the same
+// format as an unrecovered panic.
+func (s *Server) handleDebugGoroutines(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := pprof.Lookup("goroutine").WriteTo(w, 2); err != nil {
+		slog.Warn("Failed to write goroutines", "error", err.Error())
+	}
+}
+
+// handleDebugConfig returns the loaded config with its secrets redacted.
+func (s *Server) handleDebugConfig(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.config.Redact())
+}
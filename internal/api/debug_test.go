@@ -0,0 +1,167 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/api"
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/peers"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// fakeDevice reports a fixed set of live peers, or err.
+type fakeDevice struct {
+	key   wgtypes.Key
+	peers []wgtypes.Peer
+	err   error
+}
+
+func (d *fakeDevice) Name() string                                                { return "wg-test" }
+func (d *fakeDevice) Up(_ context.Context) error                                  { return nil }
+func (d *fakeDevice) Down() error                                                 { return nil }
+func (d *fakeDevice) Inspect() ([]string, error)                                  { return nil, nil }
+func (d *fakeDevice) Peers() ([]wgtypes.Peer, error)                              { return d.peers, d.err }
+func (d *fakeDevice) ConfigurePeers(_ []wgtypes.PeerConfig) error                 { return nil }
+func (d *fakeDevice) PublicKey() wgtypes.Key                                      { return d.key }
+func (d *fakeDevice) MTU() int                                                    { return 1420 }
+func (d *fakeDevice) RotateKeyIfDue(_ context.Context, _ time.Time) (bool, error) { return false, nil }
+
+type statusPeer struct {
+	Name          string     `json:"name"`
+	PublicKey     string     `json:"public_key"`
+	AllowedIPs    []string   `json:"allowed_ips"`
+	Configured    bool       `json:"configured"`
+	Programmed    bool       `json:"programmed"`
+	LastHandshake *time.Time `json:"last_handshake"`
+}
+
+type status struct {
+	Interface struct {
+		Name      string `json:"name"`
+		PublicKey string `json:"public_key"`
+		MTU       int    `json:"mtu"`
+	} `json:"interface"`
+	Peers  []statusPeer `json:"peers"`
+	Errors []string     `json:"errors"`
+}
+
+func debugStatus(t *testing.T, device *fakeDevice, configured ...config.WireGuardPeer) (status, string) {
+	t.Helper()
+
+	c := &config.Config{}
+	c.WireGuard.Enabled = true
+	server := api.NewServer(c, &api.Backend{Device: device, Registry: peers.NewRegistry(configured)})
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/status", nil)
+	req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}))
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d: %s", http.StatusOK, recorder.Code, recorder.Body)
+	}
+
+	var got status
+	if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return got, recorder.Body.String()
+}
+
+func TestDebugStatusMergesPeers(t *testing.T) {
+	t.Parallel()
+
+	private, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	programmed, pending, unknown := testPublicKey(t), testPublicKey(t), testPublicKey(t)
+	_, allowed, _ := net.ParseCIDR("10.0.0.2/32")
+	handshake := time.Now().Add(-time.Minute)
+	device := &fakeDevice{key: private.PublicKey(), peers: []wgtypes.Peer{
+		{PublicKey: programmed, AllowedIPs: []net.IPNet{*allowed}, LastHandshakeTime: handshake},
+		{PublicKey: unknown},
+	}}
+
+	got, body := debugStatus(t, device,
+		config.WireGuardPeer{Name: "programmed", PublicKey: programmed.String(), AllowedIPs: []string{"10.0.0.2/32"}},
+		config.WireGuardPeer{Name: "pending", PublicKey: pending.String(), AllowedIPs: []string{"10.0.0.3/32"}},
+	)
+
+	if got.Interface.Name != "wg-test" || got.Interface.PublicKey != private.PublicKey().String() || got.Interface.MTU != 1420 {
+		t.Errorf("expected the interface to be described, got %+v", got.Interface)
+	}
+	if strings.Contains(body, private.String()) {
+		t.Error("expected the private key to be left out")
+	}
+	if len(got.Errors) != 0 {
+		t.Errorf("expected no errors, got %v", got.Errors)
+	}
+
+	byKey := make(map[string]statusPeer, len(got.Peers))
+	for _, peer := range got.Peers {
+		byKey[peer.PublicKey] = peer
+	}
+	if len(byKey) != 3 {
+		t.Fatalf("expected 3 peers, got %+v", got.Peers)
+	}
+	if peer := byKey[programmed.String()]; !peer.Configured || !peer.Programmed || peer.Name != "programmed" || peer.LastHandshake == nil {
+		t.Errorf("expected the programmed peer to be configured, programmed and have a handshake, got %+v", peer)
+	}
+	if peer := byKey[pending.String()]; !peer.Configured || peer.Programmed {
+		t.Errorf("expected the pending peer to be configured only, got %+v", peer)
+	}
+	if peer := byKey[unknown.String()]; peer.Configured || !peer.Programmed {
+		t.Errorf("expected the unknown peer to be programmed only, got %+v", peer)
+	}
+}
+
+func TestDebugStatusReportsDeviceErrors(t *testing.T) {
+	t.Parallel()
+
+	device := &fakeDevice{key: testPublicKey(t), err: errors.New("netlink: operation not permitted")}
+	configured := config.WireGuardPeer{PublicKey: testPublicKey(t).String(), AllowedIPs: []string{"10.0.0.2/32"}}
+
+	got, _ := debugStatus(t, device, configured)
+	if len(got.Errors) != 1 || !strings.HasPrefix(got.Errors[0], "peers: ") {
+		t.Errorf("expected the peer error to be listed, got %v", got.Errors)
+	}
+	if len(got.Peers) != 1 || !got.Peers[0].Configured || got.Peers[0].Programmed {
+		t.Errorf("expected the configured peer to still be listed, got %+v", got.Peers)
+	}
+}
+
+func testPublicKey(t *testing.T) wgtypes.Key {
+	t.Helper()
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return key.PublicKey()
+}
@@ -0,0 +1,330 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/kubewg-net/container/internal/clientconfig"
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/enroll"
+	"github.com/kubewg-net/container/internal/ipam"
+	"github.com/kubewg-net/container/internal/oidc"
+	"github.com/kubewg-net/container/internal/peers"
+)
+
+var (
+	ErrEnrollmentDisabled = errors.New("enrollment is not enabled")
+	ErrOIDCDisabled       = errors.New("OIDC enrollment is not enabled")
+	ErrNoIdentity         = errors.New("the ID token lacks the identity claim")
+)
+
+type issueTokenRequest struct {
+	TTL     uint32 `json:"ttl"`
+	Profile string `json:"profile"`
+}
+
+type issueTokenResponse struct {
+	ID        string    `json:"id"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Profile   string    `json:"profile,omitempty"`
+}
+
+// EnrollRequest enrolls PublicKey with a token issued by an admin.
+type EnrollRequest struct {
+	Token     string `json:"token"`
+	PublicKey string `json:"public_key"`
+}
+
+// EnrollResponse carries the client's addresses and its wg-quick config,
+// with a placeholder for the private key only the client knows.
+type EnrollResponse struct {
+	Addresses []string `json:"addresses"`
+	Config    string   `json:"config"`
+	// LeaseToken renews the lease, which is only set with leases enabled
+	LeaseToken     string     `json:"lease_token,omitempty"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at,omitempty"`
+	// SessionExpiresAt is when the client has to re-enroll by, only set
+	// with a lease max age
+	SessionExpiresAt *time.Time `json:"session_expires_at,omitempty"`
+}
+
+// OIDCConfig tells clients where to log in before enrolling with OIDC.
+type OIDCConfig struct {
+	Issuer   string   `json:"issuer"`
+	ClientID string   `json:"client_id"`
+	Scopes   []string `json:"scopes"`
+}
+
+// OIDCEnrollRequest enrolls PublicKey with an ID token of the configured
+// issuer instead of an enrollment token.
+type OIDCEnrollRequest struct {
+	IDToken   string `json:"id_token"`
+	PublicKey string `json:"public_key"`
+}
+
+// RenewRequest extends the lease of an enrolled peer, answered with its
+// enroll.Lease.
+type RenewRequest struct {
+	PublicKey  string `json:"public_key"`
+	LeaseToken string `json:"lease_token"`
+}
+
+// ReenrollRequest swaps the key of an enrolled peer for NewPublicKey before
+// its session ends, answered like an enrollment.
+type ReenrollRequest struct {
+	PublicKey    string `json:"public_key"`
+	LeaseToken   string `json:"lease_token"`
+	NewPublicKey string `json:"new_public_key"`
+}
+
+func (s *Server) handleIssueToken(w http.ResponseWriter, r *http.Request) {
+	if s.backend.Enroller == nil {
+		writeError(w, http.StatusServiceUnavailable, ErrEnrollmentDisabled)
+		return
+	}
+
+	var req issueTokenRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+			return
+		}
+	}
+
+	secret, token, err := s.backend.Enroller.IssueProfileToken(time.Duration(req.TTL)*time.Second, req.Profile, callerName(r))
+	switch {
+	case errors.Is(err, enroll.ErrUnknownProfile):
+		writeError(w, http.StatusBadRequest, err)
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, issueTokenResponse{
+		ID:        token.ID,
+		Token:     secret,
+		ExpiresAt: token.ExpiresAt,
+		Profile:   token.Profile,
+	})
+}
+
+func (s *Server) handleListTokens(w http.ResponseWriter, _ *http.Request) {
+	if s.backend.Enroller == nil {
+		writeError(w, http.StatusServiceUnavailable, ErrEnrollmentDisabled)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.backend.Enroller.Tokens())
+}
+
+func (s *Server) handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	if s.backend.Enroller == nil {
+		writeError(w, http.StatusServiceUnavailable, ErrEnrollmentDisabled)
+		return
+	}
+
+	err := s.backend.Enroller.Revoke(r.PathValue("id"), callerName(r))
+	switch {
+	case errors.Is(err, enroll.ErrTokenNotFound):
+		writeError(w, http.StatusNotFound, err)
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleTokenAudit(w http.ResponseWriter, _ *http.Request) {
+	if s.backend.Enroller == nil {
+		writeError(w, http.StatusServiceUnavailable, ErrEnrollmentDisabled)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.backend.Enroller.Audit())
+}
+
+func (s *Server) handleEnroll(w http.ResponseWriter, r *http.Request) {
+	if s.backend.Enroller == nil {
+		writeError(w, http.StatusServiceUnavailable, ErrEnrollmentDisabled)
+		return
+	}
+
+	var req EnrollRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	peer, leaseToken, err := s.backend.Enroller.Enroll(req.Token, req.PublicKey, r.RemoteAddr)
+	if errors.Is(err, enroll.ErrInvalidToken) {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	s.writeEnrolled(w, r, peer, leaseToken, err)
+}
+
+func (s *Server) handleOIDCConfig(w http.ResponseWriter, _ *http.Request) {
+	oidcConfig := &s.config.Enrollment.OIDC
+	if s.backend.Enroller == nil || s.backend.OIDC == nil {
+		writeError(w, http.StatusServiceUnavailable, ErrOIDCDisabled)
+		return
+	}
+	writeJSON(w, http.StatusOK, OIDCConfig{
+		Issuer:   oidcConfig.Issuer,
+		ClientID: oidcConfig.ClientID,
+		Scopes:   oidcConfig.Scopes,
+	})
+}
+
+func (s *Server) handleOIDCEnroll(w http.ResponseWriter, r *http.Request) {
+	oidcConfig := &s.config.Enrollment.OIDC
+	if s.backend.Enroller == nil || s.backend.OIDC == nil {
+		writeError(w, http.StatusServiceUnavailable, ErrOIDCDisabled)
+		return
+	}
+
+	var req OIDCEnrollRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	claims, err := s.backend.OIDC.Verify(r.Context(), req.IDToken)
+	switch {
+	case errors.Is(err, oidc.ErrInvalidIDToken):
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	case err != nil:
+		// The provider couldn't be reached, which is no fault of the client
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	identity := claims.String(oidcConfig.IdentityClaim)
+	if identity == "" {
+		writeError(w, http.StatusUnauthorized, fmt.Errorf("%w: %s", ErrNoIdentity, oidcConfig.IdentityClaim))
+		return
+	}
+
+	profile := oidcConfig.Profile(claims.Strings(oidcConfig.GroupsClaim), s.config.Enrollment.DefaultProfile)
+	peer, leaseToken, err := s.backend.Enroller.EnrollIdentity("oidc:"+identity, profile, req.PublicKey, r.RemoteAddr)
+	s.writeEnrolled(w, r, peer, leaseToken, err)
+}
+
+// writeEnrolled answers an enrollment with the client's config, once the
+// new peer is programmed.
+func (s *Server) writeEnrolled(w http.ResponseWriter, r *http.Request, peer config.WireGuardPeer, leaseToken string, err error) {
+	switch {
+	case errors.Is(err, enroll.ErrInvalidPublicKey):
+		writeError(w, http.StatusBadRequest, err)
+		return
+	case errors.Is(err, peers.ErrPeerExists):
+		writeError(w, http.StatusConflict, err)
+		return
+	case errors.Is(err, ipam.ErrPoolExhausted):
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Program the new peer right away instead of waiting for the next resync
+	if _, err := s.backend.Reconciler.Reconcile(r.Context()); err != nil {
+		slog.Error("Reconcile after enrollment failed", "error", err.Error())
+	}
+
+	file, err := clientconfig.Build(&s.config.WireGuard, s.backend.Device.PublicKey(), &peer)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resp := EnrollResponse{
+		Addresses:  peer.AllowedIPs,
+		Config:     string(file.Render()),
+		LeaseToken: leaseToken,
+	}
+	if lease, ok := s.backend.Enroller.Lease(peer.PublicKey); ok {
+		resp.LeaseExpiresAt = &lease.ExpiresAt
+		if !lease.SessionExpiresAt.IsZero() {
+			resp.SessionExpiresAt = &lease.SessionExpiresAt
+		}
+	}
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+func (s *Server) handleRenewLease(w http.ResponseWriter, r *http.Request) {
+	if s.backend.Enroller == nil {
+		writeError(w, http.StatusServiceUnavailable, ErrEnrollmentDisabled)
+		return
+	}
+
+	var req RenewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	lease, err := s.backend.Enroller.Renew(req.PublicKey, req.LeaseToken)
+	switch {
+	case errors.Is(err, enroll.ErrInvalidLease), errors.Is(err, enroll.ErrSessionExpired):
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, lease)
+}
+
+func (s *Server) handleReenroll(w http.ResponseWriter, r *http.Request) {
+	if s.backend.Enroller == nil {
+		writeError(w, http.StatusServiceUnavailable, ErrEnrollmentDisabled)
+		return
+	}
+
+	var req ReenrollRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	peer, leaseToken, err := s.backend.Enroller.Reenroll(req.PublicKey, req.LeaseToken, req.NewPublicKey, r.RemoteAddr)
+	if errors.Is(err, enroll.ErrInvalidLease) {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	s.writeEnrolled(w, r, peer, leaseToken, err)
+}
+
+func (s *Server) handleListLeases(w http.ResponseWriter, _ *http.Request) {
+	if s.backend.Enroller == nil {
+		writeError(w, http.StatusServiceUnavailable, ErrEnrollmentDisabled)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.backend.Enroller.Leases())
+}
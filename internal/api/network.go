@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+const sourceDetected = "detected"
+
+type effectiveInterface struct {
+	MTU         config.Setting[int]      `json:"mtu"`
+	DNS         config.Setting[[]string] `json:"dns"`
+	KeyRotation config.Setting[uint32]   `json:"key_rotation"`
+}
+
+type effectivePeer struct {
+	Name                string                 `json:"name"`
+	PublicKey           string                 `json:"public_key"`
+	PersistentKeepalive config.Setting[uint32] `json:"persistent_keepalive"`
+}
+
+type networkResponse struct {
+	Network   string             `json:"network"`
+	Topology  string             `json:"topology"`
+	Hub       bool               `json:"hub"`
+	Interface effectiveInterface `json:"interface"`
+	Peers     []effectivePeer    `json:"peers"`
+}
+
+// handleNetwork reports the effective interface and peer settings after
+// network defaults and local overrides are applied.
+func (s *Server) handleNetwork(w http.ResponseWriter, _ *http.Request) {
+	if s.backend.Device == nil || s.backend.Registry == nil {
+		writeError(w, http.StatusServiceUnavailable, ErrWireGuardDisabled)
+		return
+	}
+
+	wg := &s.config.WireGuard
+	mtu := wg.EffectiveMTU()
+	if mtu.Value == 0 {
+		mtu = config.Setting[int]{Value: s.backend.Device.MTU(), Source: sourceDetected}
+	}
+
+	desired := wg.TopologyPeers(s.backend.Registry.List())
+	peers := make([]effectivePeer, 0, len(desired))
+	for i := range desired {
+		peers = append(peers, effectivePeer{
+			Name:                desired[i].Name,
+			PublicKey:           desired[i].PublicKey,
+			PersistentKeepalive: wg.EffectiveKeepalive(&desired[i]),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, networkResponse{
+		Network:  wg.Network.Name,
+		Topology: wg.EffectiveTopology(),
+		Hub:      wg.IsHub(),
+		Interface: effectiveInterface{
+			MTU:         mtu,
+			DNS:         wg.EffectiveDNS(),
+			KeyRotation: wg.EffectiveKeyRotation(),
+		},
+		Peers: peers,
+	})
+}
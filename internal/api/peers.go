@@ -0,0 +1,91 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/kubewg-net/container/internal/peers"
+)
+
+// exportPeersRole lets read-only callers list the peers, the preshared
+// keys are for admins only.
+func exportPeersRole(r *http.Request) string {
+	if includePresharedKeys(r) {
+		return roleAdmin
+	}
+	return roleReadOnly
+}
+
+func includePresharedKeys(r *http.Request) bool {
+	include, _ := strconv.ParseBool(r.URL.Query().Get("include_preshared_keys"))
+	return include
+}
+
+func (s *Server) handleExportPeers(w http.ResponseWriter, r *http.Request) {
+	if s.backend.Registry == nil {
+		writeError(w, http.StatusServiceUnavailable, ErrWireGuardDisabled)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.backend.Registry.Export(includePresharedKeys(r)))
+}
+
+func (s *Server) handleImportPeers(w http.ResponseWriter, r *http.Request) {
+	if s.backend.Registry == nil {
+		writeError(w, http.StatusServiceUnavailable, ErrWireGuardDisabled)
+		return
+	}
+
+	var bundle peers.Bundle
+	if err := json.NewDecoder(r.Body).Decode(&bundle); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid bundle: %w", err))
+		return
+	}
+
+	result, err := s.backend.Registry.Import(&bundle)
+	if errors.Is(err, peers.ErrBundleVersion) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if s.backend.Enroller != nil {
+		for _, peer := range bundle.Peers {
+			if err := s.backend.Enroller.Reserve(peer); err != nil {
+				slog.Warn("Failed to reserve imported peer addresses", "public_key", peer.PublicKey, "error", err.Error())
+			}
+		}
+	}
+
+	if _, err := s.backend.Reconciler.Reconcile(r.Context()); err != nil {
+		slog.Error("Reconcile after peer import failed", "error", err.Error())
+	}
+
+	slog.Info("Imported peer bundle", "imported", len(result.Imported), "skipped", len(result.Skipped))
+	writeJSON(w, http.StatusOK, result)
+}
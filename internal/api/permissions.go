@@ -0,0 +1,38 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package api
+
+import (
+	"errors"
+	"net/http"
+)
+
+var (
+	ErrKubernetesDisabled = errors.New("no Kubernetes integration is enabled")
+)
+
+// handlePermissions reports the outcome of the last RBAC self-check.
+func (s *Server) handlePermissions(w http.ResponseWriter, _ *http.Request) {
+	if s.backend.Permissions == nil {
+		writeError(w, http.StatusServiceUnavailable, ErrKubernetesDisabled)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.backend.Permissions.Results())
+}
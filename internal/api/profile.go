@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kubewg-net/container/internal/pprof"
+)
+
+// maxCaptureSeconds bounds how long a capture request holds its connection
+const maxCaptureSeconds = 300
+
+var (
+	ErrCaptureDisabled = errors.New("profile capture is not enabled")
+	ErrCaptureSeconds  = fmt.Errorf("seconds must be between 1 and %d", maxCaptureSeconds)
+)
+
+// ProfileCaptureResult lists the files a capture wrote on the node.
+type ProfileCaptureResult struct {
+	Files []string `json:"files"`
+}
+
+// handleCaptureProfile captures a CPU profile over ?seconds=N, the
+// configured seconds by default, and a goroutine dump into the capture
+// directory, answering once they are written.
+func (s *Server) handleCaptureProfile(w http.ResponseWriter, r *http.Request) {
+	if s.backend.Capturer == nil {
+		writeError(w, http.StatusServiceUnavailable, ErrCaptureDisabled)
+		return
+	}
+
+	var duration time.Duration
+	if raw := r.URL.Query().Get("seconds"); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil || seconds < 1 || seconds > maxCaptureSeconds {
+			writeError(w, http.StatusBadRequest, ErrCaptureSeconds)
+			return
+		}
+		duration = time.Duration(seconds) * time.Second
+	}
+
+	files, err := s.backend.Capturer.Capture(r.Context(), duration)
+	switch {
+	case errors.Is(err, pprof.ErrCaptureRunning):
+		writeError(w, http.StatusConflict, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		writeJSON(w, http.StatusOK, ProfileCaptureResult{Files: files})
+	}
+}
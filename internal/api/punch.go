@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kubewg-net/container/internal/punch"
+)
+
+var (
+	ErrRendezvousDisabled = errors.New("hole punching rendezvous is not enabled")
+)
+
+// handleRendezvous pairs the announcing peer up with the peers it should
+// punch through to. Announcing doesn't touch this node's device, so nodes
+// only need a read-only token for it.
+func (s *Server) handleRendezvous(w http.ResponseWriter, r *http.Request) {
+	if s.backend.Rendezvous == nil {
+		writeError(w, http.StatusServiceUnavailable, ErrRendezvousDisabled)
+		return
+	}
+
+	var announcement punch.Announcement
+	if err := json.NewDecoder(r.Body).Decode(&announcement); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	punches, err := s.backend.Rendezvous.Announce(announcement, time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, punch.AnnounceResponse{Punches: punches})
+}
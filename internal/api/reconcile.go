@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/kubewg-net/container/internal/reconciler"
+)
+
+var (
+	ErrWireGuardDisabled = errors.New("wireguard is not enabled")
+)
+
+func (s *Server) handleReconcile(w http.ResponseWriter, r *http.Request) {
+	if s.backend.Reconciler == nil {
+		writeError(w, http.StatusServiceUnavailable, ErrWireGuardDisabled)
+		return
+	}
+
+	summary, err := s.backend.Reconciler.Reconcile(r.Context())
+	if err != nil {
+		slog.Error("Reconcile requested through the API failed", "error", err.Error())
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	slog.Info("Reconciled peers on request", "caller", callerName(r), "added", len(summary.Added), "removed", len(summary.Removed), "updated", len(summary.Updated))
+	writeJSON(w, http.StatusOK, summary)
+}
+
+type driftResponse struct {
+	DetectOnly bool               `json:"detect_only"`
+	Drift      []reconciler.Drift `json:"drift"`
+}
+
+func (s *Server) handleDrift(w http.ResponseWriter, _ *http.Request) {
+	if s.backend.Reconciler == nil {
+		writeError(w, http.StatusServiceUnavailable, ErrWireGuardDisabled)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, driftResponse{
+		DetectOnly: s.config.WireGuard.DetectOnly,
+		Drift:      s.backend.Reconciler.Drift(),
+	})
+}
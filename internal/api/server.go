@@ -0,0 +1,285 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kubewg-net/container/internal/audit"
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/cryptopolicy"
+	"github.com/kubewg-net/container/internal/enroll"
+	"github.com/kubewg-net/container/internal/health"
+	"github.com/kubewg-net/container/internal/httplimit"
+	"github.com/kubewg-net/container/internal/kube"
+	"github.com/kubewg-net/container/internal/logbuffer"
+	"github.com/kubewg-net/container/internal/metrics"
+	"github.com/kubewg-net/container/internal/oidc"
+	"github.com/kubewg-net/container/internal/peers"
+	"github.com/kubewg-net/container/internal/pprof"
+	"github.com/kubewg-net/container/internal/preflight"
+	"github.com/kubewg-net/container/internal/prober"
+	"github.com/kubewg-net/container/internal/punch"
+	"github.com/kubewg-net/container/internal/reconciler"
+	"github.com/kubewg-net/container/internal/state"
+	"github.com/kubewg-net/container/internal/wireguard"
+	"golang.org/x/sync/errgroup"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	ErrClientCA = errors.New("no certificates found in client CA file")
+)
+
+// Backend bundles the components exposed through the API. Fields are nil
+// when the component is disabled, in which case the endpoints that need it
+// return 503.
+type Backend struct {
+	Device      wireguard.Dataplane
+	Registry    *peers.Registry
+	Reconciler  *reconciler.Reconciler
+	Enroller    *enroll.Enroller
+	OIDC        *oidc.Verifier
+	Rendezvous  *punch.Rendezvous
+	Kube        kubernetes.Interface
+	Audit       *audit.Logger
+	Health      *health.Status
+	Prober      *prober.Prober
+	Logs        *logbuffer.Buffer
+	Permissions *kube.PermissionChecker
+	State       state.Store
+	Capturer    *pprof.Capturer
+	Platform    *preflight.Platform
+}
+
+type Server struct {
+	ipv4Server *http.Server
+	ipv6Server *http.Server
+	stopped    bool
+	config     *config.Config
+	backend    *Backend
+	auth       *authenticator
+}
+
+func NewServer(config *config.Config, backend *Backend) *Server {
+	s := &Server{
+		config:  config,
+		backend: backend,
+		auth:    newAuthenticator(&config.API, backend.Kube),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/reconcile", s.require(roleAdmin, s.handleReconcile))
+	mux.HandleFunc("GET /api/v1/drift", s.require(roleReadOnly, s.handleDrift))
+	mux.HandleFunc("GET /api/v1/network", s.require(roleReadOnly, s.handleNetwork))
+	mux.HandleFunc("GET /api/v1/client-config", s.require(roleAdmin, s.handleClientConfig))
+	mux.HandleFunc("POST /api/v1/enroll/tokens", s.require(roleAdmin, s.handleIssueToken))
+	mux.HandleFunc("GET /api/v1/enroll/tokens", s.require(roleAdmin, s.handleListTokens))
+	mux.HandleFunc("DELETE /api/v1/enroll/tokens/{id}", s.require(roleAdmin, s.handleRevokeToken))
+	mux.HandleFunc("GET /api/v1/enroll/audit", s.require(roleAdmin, s.handleTokenAudit))
+	mux.HandleFunc("GET /api/v1/enroll/leases", s.require(roleAdmin, s.handleListLeases))
+	mux.HandleFunc("GET /api/v1/peers", s.requireIf(exportPeersRole, s.handleExportPeers))
+	mux.HandleFunc("POST /api/v1/peers/import", s.require(roleAdmin, s.handleImportPeers))
+	mux.HandleFunc("GET /api/v1/state", s.require(roleAdmin, s.handleExportState))
+	mux.HandleFunc("POST /api/v1/state/import", s.require(roleAdmin, s.handleImportState))
+	mux.HandleFunc("GET /api/v1/permissions", s.require(roleReadOnly, s.handlePermissions))
+	mux.HandleFunc("GET /api/v1/federation/peers", s.require(roleReadOnly, s.handleFederationPeers))
+	mux.HandleFunc("GET /debug/status", s.require(roleReadOnly, s.handleDebugStatus))
+	mux.HandleFunc("GET /debug/logs", s.require(roleAdmin, s.handleDebugLogs))
+	mux.HandleFunc("GET /debug/goroutines", s.require(roleAdmin, s.handleDebugGoroutines))
+	mux.HandleFunc("GET /debug/config", s.require(roleAdmin, s.handleDebugConfig))
+	mux.HandleFunc("POST /debug/profile", s.require(roleAdmin, s.handleCaptureProfile))
+	mux.HandleFunc("POST "+punch.RendezvousPath, s.require(roleAdmin, s.handleRendezvous))
+	// Enrollment authenticates with its one-time token instead, and
+	// renewal and re-enrollment with the lease token
+	mux.HandleFunc("POST /api/v1/enroll", s.audited(s.handleEnroll))
+	mux.HandleFunc("POST /api/v1/enroll/renew", s.audited(s.handleRenewLease))
+	mux.HandleFunc("POST /api/v1/enroll/reenroll", s.audited(s.handleReenroll))
+	// OIDC enrollment authenticates with the ID token
+	mux.HandleFunc("GET /api/v1/enroll/oidc", s.handleOIDCConfig)
+	mux.HandleFunc("POST /api/v1/enroll/oidc", s.audited(s.handleOIDCEnroll))
+	handler := metrics.Instrument("api", httplimit.New(&config.API.Limits).Handler(mux))
+
+	s.ipv4Server = &http.Server{
+		Addr:              fmt.Sprintf("%s:%d", config.API.IPV4Host, config.API.Port),
+		ReadHeaderTimeout: 5 * time.Second,
+		Handler:           handler,
+	}
+	s.ipv6Server = &http.Server{
+		Addr:              fmt.Sprintf("[%s]:%d", config.API.IPV6Host, config.API.Port),
+		ReadHeaderTimeout: 5 * time.Second,
+		Handler:           handler,
+	}
+
+	return s
+}
+
+// Handler returns the handler both listeners serve.
+func (s *Server) Handler() http.Handler {
+	return s.ipv4Server.Handler
+}
+
+// tlsConfig builds the listener TLS config, requesting client certificates
+// when a client CA is configured. Certificates are optional so bearer
+// tokens keep working alongside mTLS.
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	tlsConfig := cryptopolicy.TLSConfig(&s.config.CryptoPolicy)
+
+	if s.config.API.TLS.ClientCAFile != "" {
+		pem, err := os.ReadFile(s.config.API.TLS.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, ErrClientCA
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsConfig, nil
+}
+
+func (s *Server) listenAndServe(server *http.Server) error {
+	if s.config.API.TLS.CertFile == "" {
+		return server.ListenAndServe()
+	}
+
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		return err
+	}
+	server.TLSConfig = tlsConfig
+	return server.ListenAndServeTLS(s.config.API.TLS.CertFile, s.config.API.TLS.KeyFile)
+}
+
+// Start serves until Stop is called. Handlers see ctx as the parent of their
+// request context, so cancelling it aborts in-flight TokenReviews and
+// reconciles.
+func (s *Server) Start(ctx context.Context) {
+	baseContext := func(net.Listener) context.Context {
+		return ctx
+	}
+	s.ipv4Server.BaseContext = baseContext
+	s.ipv6Server.BaseContext = baseContext
+
+	waitGrp := sync.WaitGroup{}
+	waitGrp.Add(1)
+	go func() {
+		defer waitGrp.Done()
+		if err := s.listenAndServe(s.ipv4Server); err != nil && !s.stopped {
+			slog.Error("API server error", "error", err.Error())
+		}
+	}()
+
+	waitGrp.Add(1)
+	go func() {
+		defer waitGrp.Done()
+		if err := s.listenAndServe(s.ipv6Server); err != nil && !s.stopped {
+			slog.Error("API server error", "error", err.Error())
+		}
+	}()
+
+	if !s.auth.enabled() {
+		slog.Warn("API authentication is disabled, only requests to a loopback address are served")
+	}
+
+	slog.Info("API server started", "ipv4", s.config.API.IPV4Host, "ipv6", s.config.API.IPV6Host, "port", s.config.API.Port, "tls", s.config.API.TLS.CertFile != "")
+
+	waitGrp.Wait()
+}
+
+// Stop drains the server: keep-alives are turned off so clients reconnect
+// elsewhere, new requests are still served for the drain delay while
+// readiness fails, then in-flight requests get the shutdown timeout to
+// finish before their connections are closed.
+func (s *Server) Stop(ctx context.Context) error {
+	s.stopped = true
+	for _, server := range []*http.Server{s.ipv4Server, s.ipv6Server} {
+		if server != nil {
+			server.SetKeepAlivesEnabled(false)
+		}
+	}
+	if drain := s.config.API.DrainDelay.Std(); drain > 0 {
+		slog.Info("Draining API server", "delay", drain)
+		select {
+		case <-time.After(drain):
+		case <-ctx.Done():
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.config.API.ShutdownTimeout.Std())
+	defer cancel()
+
+	errGrp := errgroup.Group{}
+	if s.ipv4Server != nil {
+		errGrp.Go(func() error {
+			return s.ipv4Server.Shutdown(ctx)
+		})
+	}
+	if s.ipv6Server != nil {
+		errGrp.Go(func() error {
+			return s.ipv6Server.Shutdown(ctx)
+		})
+	}
+
+	if err := errGrp.Wait(); err != nil {
+		for _, server := range []*http.Server{s.ipv4Server, s.ipv6Server} {
+			if server != nil {
+				_ = server.Close()
+			}
+		}
+		return fmt.Errorf("closed connections still open after the shutdown timeout: %w", err)
+	}
+	return nil
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Error("Failed to write API response", "error", err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	// Bodies over the listener's size limit fail to decode
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		status = http.StatusRequestEntityTooLarge
+	}
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/kubewg-net/container/internal/state"
+)
+
+// StateImportResult lists the documents a state import replaced.
+type StateImportResult struct {
+	Documents []string `json:"documents"`
+}
+
+func (s *Server) handleExportState(w http.ResponseWriter, r *http.Request) {
+	if s.backend.State == nil {
+		writeError(w, http.StatusServiceUnavailable, ErrWireGuardDisabled)
+		return
+	}
+
+	dump, err := state.Export(r.Context(), s.backend.State)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, dump)
+}
+
+func (s *Server) handleImportState(w http.ResponseWriter, r *http.Request) {
+	if s.backend.State == nil {
+		writeError(w, http.StatusServiceUnavailable, ErrWireGuardDisabled)
+		return
+	}
+
+	var dump state.Dump
+	if err := json.NewDecoder(r.Body).Decode(&dump); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid state dump: %w", err))
+		return
+	}
+
+	err := state.Import(r.Context(), s.backend.State, &dump)
+	if errors.Is(err, state.ErrDumpVersion) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Merge the imported documents into what is running
+	if err := s.backend.Registry.UseStore(r.Context(), s.backend.State); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	if s.backend.Enroller != nil {
+		if err := s.backend.Enroller.UseStore(r.Context(), s.backend.State); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+	}
+
+	if _, err := s.backend.Reconciler.Reconcile(r.Context()); err != nil {
+		slog.Error("Reconcile after state import failed", "error", err.Error())
+	}
+
+	result := &StateImportResult{Documents: make([]string, 0, len(dump.Documents))}
+	for name := range dump.Documents {
+		result.Documents = append(result.Documents, name)
+	}
+	slices.Sort(result.Documents)
+	slog.Info("Imported state", "documents", result.Documents)
+	writeJSON(w, http.StatusOK, result)
+}
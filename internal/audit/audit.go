@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package audit records administrative actions, who took them and how they
+// ended, as JSON lines appended to a file or written to stdout.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kubewg-net/container/internal/events"
+)
+
+type Action string
+
+const (
+	ActionConfigLoaded   Action = "config.loaded"
+	ActionNetworkUpdated Action = "config.network_updated"
+	ActionPeerAdded      Action = "peer.added"
+	ActionPeerRemoved    Action = "peer.removed"
+	ActionKeyRotated     Action = "key.rotated"
+	ActionAPICall        Action = "api.call"
+)
+
+// Results of an action
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+	ResultDenied  = "denied"
+)
+
+// ActorSystem takes the actions nobody asked for, e.g. scheduled key
+// rotations
+const ActorSystem = "system"
+
+type Record struct {
+	Time    time.Time         `json:"time"`
+	Action  Action            `json:"action"`
+	Actor   string            `json:"actor"`
+	Target  string            `json:"target,omitempty"`
+	Result  string            `json:"result"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// Logger appends records to its output. A nil Logger discards them, so
+// callers don't have to check whether auditing is enabled.
+type Logger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	closer io.Closer
+}
+
+// Open appends to the file at path, or writes to stdout if path is empty
+// or "-". The file is only ever appended to.
+func Open(path string) (*Logger, error) {
+	if path == "" || path == "-" {
+		return New(os.Stdout), nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &Logger{out: file, closer: file}, nil
+}
+
+// New writes records to out.
+func New(out io.Writer) *Logger {
+	return &Logger{out: out}
+}
+
+// Record writes record, stamping it with the current time if it has none.
+func (l *Logger) Record(record Record) {
+	if l == nil {
+		return
+	}
+	if record.Time.IsZero() {
+		record.Time = time.Now().UTC()
+	}
+	if record.Result == "" {
+		record.Result = ResultSuccess
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		slog.Error("Failed to encode audit record", "action", record.Action, "error", err.Error())
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.out.Write(append(data, '\n')); err != nil {
+		slog.Error("Failed to write audit record", "action", record.Action, "error", err.Error())
+	}
+}
+
+// Publish records the peer lifecycle events that change who can connect.
+func (l *Logger) Publish(event events.Event) {
+	var action Action
+	switch event.Type {
+	case events.PeerAdded:
+		action = ActionPeerAdded
+	case events.PeerRemoved:
+		action = ActionPeerRemoved
+	case events.KeyRotated:
+		action = ActionKeyRotated
+	case events.HandshakeFailed, events.HandshakeRestored, events.LeaseExpired:
+		return
+	}
+	l.Record(Record{
+		Time:    event.Time.UTC(),
+		Action:  action,
+		Actor:   ActorSystem,
+		Target:  event.PublicKey,
+		Details: map[string]string{"message": event.Message},
+	})
+}
+
+func (l *Logger) Close() error {
+	if l == nil || l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package audit_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/audit"
+	"github.com/kubewg-net/container/internal/events"
+)
+
+func readRecords(t *testing.T, path string) []audit.Record {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open audit log: %v", err)
+	}
+	defer file.Close()
+
+	var records []audit.Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record audit.Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("failed to decode audit record %q: %v", scanner.Text(), err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestLoggerAppends(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	logger, err := audit.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open audit log: %v", err)
+	}
+	logger.Record(audit.Record{Action: audit.ActionConfigLoaded, Actor: audit.ActorSystem})
+	if err := logger.Close(); err != nil {
+		t.Fatalf("failed to close audit log: %v", err)
+	}
+
+	// Reopening must not truncate what was already recorded
+	logger, err = audit.Open(path)
+	if err != nil {
+		t.Fatalf("failed to reopen audit log: %v", err)
+	}
+	logger.Record(audit.Record{Action: audit.ActionAPICall, Actor: "admin", Result: audit.ResultDenied})
+	if err := logger.Close(); err != nil {
+		t.Fatalf("failed to close audit log: %v", err)
+	}
+
+	records := readRecords(t, path)
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	if records[0].Action != audit.ActionConfigLoaded || records[0].Result != audit.ResultSuccess {
+		t.Errorf("expected a successful config.loaded record, got %+v", records[0])
+	}
+	if records[1].Actor != "admin" || records[1].Result != audit.ResultDenied {
+		t.Errorf("expected a denied call by admin, got %+v", records[1])
+	}
+	if records[0].Time.IsZero() {
+		t.Errorf("expected the record to be timestamped")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat audit log: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("expected mode 0600, got %v", info.Mode().Perm())
+	}
+}
+
+func TestLoggerPublish(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	logger, err := audit.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open audit log: %v", err)
+	}
+	bus := events.NewBus()
+	bus.Subscribe(logger)
+	bus.Publish(events.PeerAdded, "peer-key", "Peer added")
+	bus.Publish(events.HandshakeFailed, "peer-key", "No handshake")
+	bus.Publish(events.KeyRotated, "new-key", "Private key rotated")
+	if err := logger.Close(); err != nil {
+		t.Fatalf("failed to close audit log: %v", err)
+	}
+
+	records := readRecords(t, path)
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	if records[0].Action != audit.ActionPeerAdded || records[0].Target != "peer-key" {
+		t.Errorf("expected peer.added for peer-key, got %+v", records[0])
+	}
+	if records[1].Action != audit.ActionKeyRotated {
+		t.Errorf("expected key.rotated, got %+v", records[1])
+	}
+}
+
+func TestNilLogger(t *testing.T) {
+	t.Parallel()
+	var logger *audit.Logger
+	logger.Record(audit.Record{Action: audit.ActionAPICall})
+	if err := logger.Close(); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
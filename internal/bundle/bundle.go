@@ -0,0 +1,99 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package bundle writes support bundles: a tar.gz of the status, logs and
+// host networking state of a node, collected without relying on tools
+// being present in the image.
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrorsFile lists the collectors that failed. A bundle is still written
+// when some of them fail, since a partial bundle beats none.
+const ErrorsFile = "errors.txt"
+
+// Collector produces one file of the bundle.
+type Collector struct {
+	// Name is the file name inside the bundle
+	Name    string
+	Collect func(ctx context.Context) ([]byte, error)
+}
+
+// Write runs the collectors and writes their output to w as a gzipped tar
+// with every file under dir. It returns the number of collectors that
+// failed, which are listed in ErrorsFile.
+func Write(ctx context.Context, w io.Writer, dir string, now time.Time, collectors []Collector) (int, error) {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	var failures strings.Builder
+	failed := 0
+	for _, collector := range collectors {
+		data, err := collector.Collect(ctx)
+		if err != nil {
+			failed++
+			fmt.Fprintf(&failures, "%s: %v\n", collector.Name, err)
+		}
+		if len(data) == 0 && err != nil {
+			continue
+		}
+		if err := writeFile(tw, path.Join(dir, collector.Name), now, data); err != nil {
+			return failed, err
+		}
+	}
+	if failed > 0 {
+		if err := writeFile(tw, path.Join(dir, ErrorsFile), now, []byte(failures.String())); err != nil {
+			return failed, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return failed, fmt.Errorf("failed to close bundle: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return failed, fmt.Errorf("failed to close bundle: %w", err)
+	}
+	return failed, nil
+}
+
+func writeFile(tw *tar.Writer, name string, now time.Time, data []byte) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0o600,
+		Size:     int64(len(data)),
+		ModTime:  now,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
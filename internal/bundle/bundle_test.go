@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package bundle_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/bundle"
+)
+
+var errCollect = errors.New("collect failed")
+
+func readBundle(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	files := map[string]string{}
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files
+		} else if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		files[header.Name] = string(content)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	t.Parallel()
+
+	collectors := []bundle.Collector{
+		{Name: "status.json", Collect: func(context.Context) ([]byte, error) {
+			return []byte(`{"ok":true}`), nil
+		}},
+		{Name: "logs.txt", Collect: func(context.Context) ([]byte, error) {
+			return nil, errCollect
+		}},
+		{Name: "iptables.txt", Collect: func(context.Context) ([]byte, error) {
+			return []byte("partial"), errCollect
+		}},
+	}
+
+	var out bytes.Buffer
+	failed, err := bundle.Write(context.Background(), &out, "kubewg-debug", time.Now(), collectors)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if failed != 2 {
+		t.Errorf("expected 2 failed collectors, got %d", failed)
+	}
+
+	files := readBundle(t, out.Bytes())
+	if files["kubewg-debug/status.json"] != `{"ok":true}` {
+		t.Errorf("expected status.json in the bundle, got %v", files)
+	}
+	if _, ok := files["kubewg-debug/logs.txt"]; ok {
+		t.Errorf("expected no logs.txt for a collector without output")
+	}
+	if files["kubewg-debug/iptables.txt"] != "partial" {
+		t.Errorf("expected the partial output to be kept, got %q", files["kubewg-debug/iptables.txt"])
+	}
+	expected := "logs.txt: collect failed\niptables.txt: collect failed\n"
+	if files["kubewg-debug/"+bundle.ErrorsFile] != expected {
+		t.Errorf("expected %q, got %q", expected, files["kubewg-debug/"+bundle.ErrorsFile])
+	}
+}
+
+func TestWriteWithoutErrors(t *testing.T) {
+	t.Parallel()
+
+	collectors := []bundle.Collector{
+		{Name: "version.txt", Collect: func(context.Context) ([]byte, error) {
+			return []byte("dev"), nil
+		}},
+	}
+
+	var out bytes.Buffer
+	if _, err := bundle.Write(context.Background(), &out, "kubewg-debug", time.Now(), collectors); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	files := readBundle(t, out.Bytes())
+	if len(files) != 1 {
+		t.Errorf("expected only version.txt, got %v", files)
+	}
+}
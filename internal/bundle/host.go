@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package bundle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/kubewg-net/container/internal/firewall"
+)
+
+var ErrUnsupported = errors.New("host networking state is only collected on Linux")
+
+// Firewall dumps the rules of the given iptables family with the
+// iptables-save binary. Unlike the netlink collectors this needs the
+// binary on PATH, the same one exit nodes and MSS clamping need.
+func Firewall(command firewall.Command, family int) Collector {
+	binary := "iptables-save"
+	name := "iptables.txt"
+	if family == firewall.IPv6 {
+		binary = "ip6tables-save"
+		name = "ip6tables.txt"
+	}
+	return Collector{
+		Name: name,
+		Collect: func(ctx context.Context) ([]byte, error) {
+			out, err := command(ctx, binary)
+			if err != nil {
+				return out, fmt.Errorf("%s failed: %w", binary, err)
+			}
+			return out, nil
+		},
+	}
+}
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package bundle
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Links lists the network interfaces with their addresses, like
+// "ip address".
+func Links(_ context.Context) ([]byte, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list links: %w", err)
+	}
+
+	var out bytes.Buffer
+	for _, link := range links {
+		attrs := link.Attrs()
+		fmt.Fprintf(&out, "%d: %s type %s mtu %d state %s flags %s\n",
+			attrs.Index, attrs.Name, link.Type(), attrs.MTU, attrs.OperState, attrs.Flags)
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+		if err != nil {
+			fmt.Fprintf(&out, "    failed to list addresses: %v\n", err)
+			continue
+		}
+		for _, addr := range addrs {
+			fmt.Fprintf(&out, "    %s\n", addr.IPNet)
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// Routes lists the routes of every table, like "ip route show table all".
+func Routes(_ context.Context) ([]byte, error) {
+	routes, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{Table: unix.RT_TABLE_UNSPEC}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list routes: %w", err)
+	}
+
+	var out bytes.Buffer
+	for i := range routes {
+		fmt.Fprintf(&out, "table %d %s\n", routes[i].Table, routes[i].String())
+	}
+	return out.Bytes(), nil
+}
+
+// Rules lists the routing policy rules, like "ip rule".
+func Rules(_ context.Context) ([]byte, error) {
+	rules, err := netlink.RuleList(netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rules: %w", err)
+	}
+
+	var out bytes.Buffer
+	for i := range rules {
+		fmt.Fprintln(&out, rules[i].String())
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package clientconfig renders wg-quick configurations that let a
+// registered peer connect to this node.
+package clientconfig
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/ipam"
+	"github.com/kubewg-net/container/internal/wgquick"
+	"github.com/skip2/go-qrcode"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const (
+	// DefaultKeepalive keeps NAT mappings of roaming clients open
+	DefaultKeepalive = 25
+
+	DefaultPNGSize = 512
+)
+
+var (
+	ErrNoEndpoint = errors.New("wireguard.endpoint must be set to generate client configs")
+)
+
+// Build returns the wg-quick config peer needs to connect to this node.
+// The peer's private key is never known here, so the result carries a
+// placeholder the client has to fill in.
+func Build(config *config.WireGuard, serverKey wgtypes.Key, peer *config.WireGuardPeer) (*wgquick.File, error) {
+	if config.Endpoint == "" {
+		return nil, ErrNoEndpoint
+	}
+
+	// Split clients route the tunnel networks and the cluster's, unless
+	// their access profile narrows that down
+	routed := slices.Concat(config.Addresses, config.ClusterCIDRs.All())
+	profile, profiled := config.PeerProfile(peer)
+	if profiled {
+		routed = profile.AllowedIPs
+	}
+	allowedIPs := make([]string, 0, len(routed))
+	for _, address := range routed {
+		prefix, err := netip.ParsePrefix(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %w", address, err)
+		}
+		if masked := prefix.Masked().String(); !slices.Contains(allowedIPs, masked) {
+			allowedIPs = append(allowedIPs, masked)
+		}
+	}
+
+	// Exit clients send everything through this node, except for the
+	// excluded ranges, and resolve names through it too so lookups don't
+	// leak to the network they are on. A profile is never widened that way
+	dns := config.EffectiveDNS().Value
+	if !profiled && config.ExitNode.Selects(peer) {
+		excluded := slices.Concat(config.ExitNode.ExcludedIPs, peer.ExcludedIPs)
+		var err error
+		allowedIPs, err = ipam.ExcludeStrings([]string{"0.0.0.0/0", "::/0"}, excluded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid excluded_ips: %w", err)
+		}
+		if len(config.ExitNode.DNS) > 0 {
+			dns = config.ExitNode.DNS
+		}
+	}
+
+	keepalive := config.EffectiveKeepalive(peer).Value
+	if keepalive == 0 {
+		keepalive = DefaultKeepalive
+	}
+
+	return &wgquick.File{
+		Interface: &wgquick.Interface{
+			Addresses: peer.AllowedIPs,
+			DNS:       dns,
+			MTU:       config.EffectiveMTU().Value,
+		},
+		Peers: []wgquick.Peer{
+			{
+				PublicKey:           serverKey.String(),
+				PresharedKey:        peer.PresharedKey,
+				Endpoint:            config.Endpoint,
+				AllowedIPs:          allowedIPs,
+				PersistentKeepalive: keepalive,
+			},
+		},
+	}, nil
+}
+
+// PNG encodes data as a QR code image.
+func PNG(data []byte, size int) ([]byte, error) {
+	png, err := qrcode.Encode(string(data), qrcode.Medium, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode QR code: %w", err)
+	}
+	return png, nil
+}
+
+// ASCII encodes data as a QR code that can be printed to a terminal.
+func ASCII(data []byte) (string, error) {
+	code, err := qrcode.New(string(data), qrcode.Medium)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode QR code: %w", err)
+	}
+	return code.ToSmallString(false), nil
+}
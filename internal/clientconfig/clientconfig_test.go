@@ -0,0 +1,148 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package clientconfig_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/clientconfig"
+	"github.com/kubewg-net/container/internal/config"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestBuildExitClient(t *testing.T) {
+	t.Parallel()
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	wg := &config.WireGuard{
+		Endpoint:  "vpn.example.com:51820",
+		Addresses: []string{"10.0.0.1/24"},
+		DNS:       []string{"10.96.0.10"},
+		ExitNode: config.ExitNode{
+			Enabled:        true,
+			ClientSelector: "exit=true",
+			DNS:            []string{"1.1.1.1"},
+		},
+	}
+
+	exitPeer := &config.WireGuardPeer{AllowedIPs: []string{"10.0.0.2/32"}, Metadata: map[string]string{"exit": "true"}}
+	file, err := clientconfig.Build(wg, key.PublicKey(), exitPeer)
+	if err != nil {
+		t.Fatalf("failed to build client config: %v", err)
+	}
+	if got := file.Peers[0].AllowedIPs; !slices.Equal(got, []string{"0.0.0.0/0", "::/0"}) {
+		t.Errorf("expected default routes for an exit client, got %v", got)
+	}
+	if got := file.Interface.DNS; !slices.Equal(got, []string{"1.1.1.1"}) {
+		t.Errorf("expected the exit node DNS, got %v", got)
+	}
+
+	splitPeer := &config.WireGuardPeer{AllowedIPs: []string{"10.0.0.3/32"}}
+	file, err = clientconfig.Build(wg, key.PublicKey(), splitPeer)
+	if err != nil {
+		t.Fatalf("failed to build client config: %v", err)
+	}
+	if got := file.Peers[0].AllowedIPs; !slices.Equal(got, []string{"10.0.0.0/24"}) {
+		t.Errorf("expected only the tunnel network for other clients, got %v", got)
+	}
+	if got := file.Interface.DNS; !slices.Equal(got, []string{"10.96.0.10"}) {
+		t.Errorf("expected the tunnel DNS, got %v", got)
+	}
+}
+
+func TestBuildClusterCIDRs(t *testing.T) {
+	t.Parallel()
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	wg := &config.WireGuard{
+		Endpoint:  "vpn.example.com:51820",
+		Addresses: []string{"10.0.0.1/24"},
+		ClusterCIDRs: config.ClusterCIDRs{
+			Pods:     []string{"10.244.0.0/16"},
+			Services: []string{"10.96.0.1/12"},
+		},
+	}
+
+	file, err := clientconfig.Build(wg, key.PublicKey(), &config.WireGuardPeer{AllowedIPs: []string{"10.0.0.2/32"}})
+	if err != nil {
+		t.Fatalf("failed to build client config: %v", err)
+	}
+	if got := file.Peers[0].AllowedIPs; !slices.Equal(got, []string{"10.0.0.0/24", "10.244.0.0/16", "10.96.0.0/12"}) {
+		t.Errorf("expected the tunnel network and the cluster CIDRs, got %v", got)
+	}
+}
+
+func TestBuildExitClientExcludedIPs(t *testing.T) {
+	t.Parallel()
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	wg := &config.WireGuard{
+		Endpoint:  "vpn.example.com:51820",
+		Addresses: []string{"10.0.0.1/24"},
+		ExitNode: config.ExitNode{
+			Enabled:     true,
+			ExcludedIPs: []string{"0.0.0.0/1"},
+		},
+	}
+
+	peer := &config.WireGuardPeer{AllowedIPs: []string{"10.0.0.2/32"}, ExcludedIPs: []string{"::/1"}}
+	file, err := clientconfig.Build(wg, key.PublicKey(), peer)
+	if err != nil {
+		t.Fatalf("failed to build client config: %v", err)
+	}
+	if got := file.Peers[0].AllowedIPs; !slices.Equal(got, []string{"128.0.0.0/1", "8000::/1"}) {
+		t.Errorf("expected the default routes minus both exclusions, got %v", got)
+	}
+}
+
+func TestBuildProfile(t *testing.T) {
+	t.Parallel()
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	wg := &config.WireGuard{
+		Endpoint:     "vpn.example.com:51820",
+		Addresses:    []string{"10.0.0.1/24"},
+		ClusterCIDRs: config.ClusterCIDRs{Pods: []string{"10.244.0.0/16"}, Services: []string{"10.96.0.0/12"}},
+		ExitNode:     config.ExitNode{Enabled: true},
+		Profiles:     []config.AccessProfile{{Name: "devs", AllowedIPs: []string{"10.96.0.0/12"}}},
+	}
+
+	peer := &config.WireGuardPeer{AllowedIPs: []string{"10.0.0.2/32"}, Metadata: map[string]string{config.MetadataProfile: "devs"}}
+	file, err := clientconfig.Build(wg, key.PublicKey(), peer)
+	if err != nil {
+		t.Fatalf("failed to build client config: %v", err)
+	}
+	if got := file.Peers[0].AllowedIPs; !slices.Equal(got, []string{"10.96.0.0/12"}) {
+		t.Errorf("expected only the profile's networks, not the exit node's default routes, got %v", got)
+	}
+}
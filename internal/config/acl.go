@@ -0,0 +1,166 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// ACL actions
+const (
+	ACLAllow = "allow"
+	ACLDeny  = "deny"
+)
+
+// ACL protocols
+const (
+	ACLProtocolTCP = "tcp"
+	ACLProtocolUDP = "udp"
+)
+
+var (
+	ErrACLAction = errors.New("wireguard.acl action must be allow or deny")
+	ErrACLPort   = errors.New("wireguard.acl ports must be tcp or udp, optionally followed by /port or /first-last")
+	ErrACLPeer   = errors.New("wireguard.acl entries must be a CIDR or a peer name")
+)
+
+// ACL restricts what peers may reach through the interface, on top of
+// AllowedIPs deciding which source addresses a peer may use at all. Rules
+// are matched in order against traffic entering from the tunnel, the first
+// match decides and Default applies to the rest. Replies to allowed
+// connections always pass.
+type ACL struct {
+	Enabled bool      `json:"enabled"`
+	Default string    `json:"default" jsonschema:"enum=allow|deny"`
+	Rules   []ACLRule `json:"rules"`
+}
+
+// ACLRule matches traffic from any of From to any of To on any of Ports.
+// From and To hold CIDRs or peer names, which stand for the peer's
+// AllowedIPs. An empty list matches everything.
+type ACLRule struct {
+	Name   string   `json:"name"`
+	From   []string `json:"from"`
+	To     []string `json:"to"`
+	Ports  []string `json:"ports"`
+	Action string   `json:"action" jsonschema:"enum=allow|deny"`
+}
+
+// ACLPort is a parsed entry of ACLRule.Ports. First and Last are 0 when
+// the protocol is matched on any port.
+type ACLPort struct {
+	Protocol string
+	First    uint16
+	Last     uint16
+}
+
+// ParseACLPort parses tcp, udp/53 or tcp/8000-8100.
+func ParseACLPort(port string) (ACLPort, error) {
+	protocol, ports, ranged := strings.Cut(port, "/")
+	if protocol != ACLProtocolTCP && protocol != ACLProtocolUDP {
+		return ACLPort{}, fmt.Errorf("%w: %q", ErrACLPort, port)
+	}
+	parsed := ACLPort{Protocol: protocol}
+	if !ranged {
+		return parsed, nil
+	}
+
+	first, last, isRange := strings.Cut(ports, "-")
+	if !isRange {
+		last = first
+	}
+	firstPort, err := strconv.ParseUint(first, 10, 16)
+	if err != nil || firstPort == 0 {
+		return ACLPort{}, fmt.Errorf("%w: %q", ErrACLPort, port)
+	}
+	lastPort, err := strconv.ParseUint(last, 10, 16)
+	if err != nil || lastPort < firstPort {
+		return ACLPort{}, fmt.Errorf("%w: %q", ErrACLPort, port)
+	}
+	parsed.First, parsed.Last = uint16(firstPort), uint16(lastPort)
+	return parsed, nil
+}
+
+func (a *ACL) applyDefaults() {
+	if a.Default == "" {
+		a.Default = ACLDeny
+	}
+	for i := range a.Rules {
+		if a.Rules[i].Action == "" {
+			a.Rules[i].Action = ACLAllow
+		}
+	}
+}
+
+func (a *ACL) validate() error {
+	if !a.Enabled {
+		return nil
+	}
+	if a.Default != ACLAllow && a.Default != ACLDeny {
+		return fmt.Errorf("%w: default %q", ErrACLAction, a.Default)
+	}
+	for _, rule := range a.Rules {
+		if rule.Action != ACLAllow && rule.Action != ACLDeny {
+			return fmt.Errorf("%w: rule %q has %q", ErrACLAction, rule.Name, rule.Action)
+		}
+		for _, entry := range append(rule.From, rule.To...) {
+			// Anything that looks like an address has to be a valid
+			// prefix, a typo must not silently turn into a peer name
+			if strings.ContainsAny(entry, "/:") || isAddress(entry) {
+				if _, err := netip.ParsePrefix(entry); err != nil {
+					return fmt.Errorf("%w: rule %q has %q", ErrACLPeer, rule.Name, entry)
+				}
+			} else if entry == "" {
+				return fmt.Errorf("%w: rule %q has an empty entry", ErrACLPeer, rule.Name)
+			}
+		}
+		for _, port := range rule.Ports {
+			if _, err := ParseACLPort(port); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func isAddress(entry string) bool {
+	_, err := netip.ParseAddr(entry)
+	return err == nil
+}
+
+// NeedsNFTables reports whether an interface enforces an ACL.
+func (c *Config) NeedsNFTables() bool {
+	if !c.WireGuard.Enabled {
+		return false
+	}
+	if c.WireGuard.ACL.Enabled {
+		return true
+	}
+	for _, wg := range c.interfaces() {
+		if wg.ACL.Enabled {
+			return true
+		}
+	}
+	return false
+}
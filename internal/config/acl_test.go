@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+func TestACLValidation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		acl  config.ACL
+		err  error
+	}{
+		{name: "disabled", acl: config.ACL{Default: "maybe"}},
+		{
+			name: "peers and prefixes",
+			acl: config.ACL{Enabled: true, Rules: []config.ACLRule{
+				{From: []string{"laptop", "10.0.0.0/24"}, To: []string{"fd00::1/128"}, Ports: []string{"tcp/22", "udp", "tcp/8000-8100"}},
+			}},
+		},
+		{
+			name: "bad default",
+			acl:  config.ACL{Enabled: true, Default: "reject"},
+			err:  config.ErrACLAction,
+		},
+		{
+			name: "bad action",
+			acl:  config.ACL{Enabled: true, Rules: []config.ACLRule{{Action: "reject"}}},
+			err:  config.ErrACLAction,
+		},
+		{
+			name: "bare address",
+			acl:  config.ACL{Enabled: true, Rules: []config.ACLRule{{To: []string{"10.0.0.1"}}}},
+			err:  config.ErrACLPeer,
+		},
+		{
+			name: "bad prefix",
+			acl:  config.ACL{Enabled: true, Rules: []config.ACLRule{{From: []string{"10.0.0.0/33"}}}},
+			err:  config.ErrACLPeer,
+		},
+		{
+			name: "bad protocol",
+			acl:  config.ACL{Enabled: true, Rules: []config.ACLRule{{Ports: []string{"icmp"}}}},
+			err:  config.ErrACLPort,
+		},
+		{
+			name: "reversed range",
+			acl:  config.ACL{Enabled: true, Rules: []config.ACLRule{{Ports: []string{"tcp/90-80"}}}},
+			err:  config.ErrACLPort,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			c := &config.Config{WireGuard: config.WireGuard{ACL: tt.acl}}
+			err := c.Complete()
+			if tt.err == nil && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+			if err == nil && tt.acl.Enabled {
+				if c.WireGuard.ACL.Default != config.ACLDeny {
+					t.Errorf("expected default %q, got %q", config.ACLDeny, c.WireGuard.ACL.Default)
+				}
+				if c.WireGuard.ACL.Rules[0].Action != config.ACLAllow {
+					t.Errorf("expected action %q, got %q", config.ACLAllow, c.WireGuard.ACL.Rules[0].Action)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+const (
+	DefaultCaptureDirectory = "/var/lib/kubewg/profiles"
+	DefaultCaptureSeconds   = 30
+	DefaultCaptureMaxBytes  = 100 << 20
+)
+
+// Capture writes a CPU profile and a goroutine dump to Directory on SIGUSR1
+// or an admin API call, for when the pprof port can't be reached. It works
+// without the pprof server.
+type Capture struct {
+	Enabled   bool   `json:"enabled"`
+	Directory string `json:"directory"`
+	// Seconds the CPU profile covers unless the API call asks otherwise
+	Seconds uint32 `json:"seconds"`
+	// MaxBytes bounds the size of the directory, the oldest captures are
+	// removed to stay under it
+	MaxBytes ByteSize `json:"max_bytes"`
+	// Signal captures on SIGUSR1
+	Signal bool `json:"signal"`
+}
+
+func (c *Capture) applyDefaults() {
+	if c.Directory == "" {
+		c.Directory = DefaultCaptureDirectory
+	}
+	if c.Seconds == 0 {
+		c.Seconds = DefaultCaptureSeconds
+	}
+	if c.MaxBytes == 0 {
+		c.MaxBytes = DefaultCaptureMaxBytes
+	}
+}
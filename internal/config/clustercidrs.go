@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"errors"
+	"slices"
+)
+
+var ErrClusterCIDR = errors.New("invalid wireguard.cluster_cidrs prefix")
+
+// ClusterCIDRs are the cluster's networks clients reach through the
+// tunnel, added to the AllowedIPs of the configs they are given.
+type ClusterCIDRs struct {
+	// Discover looks up what Pods and Services leave empty at startup,
+	// from the kubeadm ConfigMap, the control plane's flags or the nodes'
+	// Pod CIDRs
+	Discover bool     `json:"discover"`
+	Pods     []string `json:"pods"`
+	Services []string `json:"services"`
+}
+
+// All returns the Pod and Service CIDRs together.
+func (c *ClusterCIDRs) All() []string {
+	return slices.Concat(c.Pods, c.Services)
+}
+
+func (c *ClusterCIDRs) validate() error {
+	return validatePrefixes(c.All(), ErrClusterCIDR)
+}
@@ -21,57 +21,690 @@ package config
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"net/netip"
 	"os"
+	"reflect"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/ghodss/yaml"
+	"github.com/kubewg-net/container/internal/features"
+	"github.com/kubewg-net/container/internal/wgquick"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 type HTTPListener struct {
-	IPV4Host string `json:"ipv4_host"`
-	IPV6Host string `json:"ipv6_host"`
-	Port     uint16 `json:"port"`
+	IPV4Host string     `json:"ipv4_host"`
+	IPV6Host string     `json:"ipv6_host"`
+	Port     uint16     `json:"port"`
+	Limits   HTTPLimits `json:"limits"`
+	// ShutdownTimeout is how long in-flight requests get to finish on
+	// shutdown before their connections are closed
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
 }
 
-type Tracing struct {
-	Enabled      bool   `json:"enabled"`
-	OTLPEndpoint string `json:"otlp_endpoint"`
+// HTTPLimits protects a listener from misbehaving clients. Clients are told
+// apart by their address; a zero RateLimit or MaxConcurrent is unlimited.
+type HTTPLimits struct {
+	// RateLimit is the sustained requests per second allowed per client,
+	// Burst how many more it may send at once
+	RateLimit     float64  `json:"rate_limit"`
+	Burst         int      `json:"burst"`
+	MaxConcurrent int      `json:"max_concurrent"`
+	MaxBodyBytes  ByteSize `json:"max_body_bytes"`
 }
 
 type PProf struct {
 	HTTPListener
 	Enabled bool `json:"enabled"`
+	// BlockProfileRate samples one blocking event per that many
+	// nanoseconds spent blocked, 1 records all of them and 0 none, and
+	// MutexProfileFraction one in that many contended mutexes, 0 none.
+	// Both only apply while the server runs, they cost CPU
+	BlockProfileRate     int `json:"block_profile_rate"`
+	MutexProfileFraction int `json:"mutex_profile_fraction"`
+	// MaxDelta caps the seconds parameter of the delta profiles, e.g.
+	// /debug/pprof/heap?seconds=30
+	MaxDelta Duration `json:"max_delta"`
+	Capture  Capture  `json:"capture"`
+}
+
+// Profiling pushes CPU and heap profiles to a Pyroscope server every
+// Interval, complementing the pprof server which has to be pulled
+// from.
+type Profiling struct {
+	Enabled         bool     `json:"enabled"`
+	ServerAddress   string   `json:"server_address"`
+	ApplicationName string   `json:"application_name"`
+	Interval        Duration `json:"interval"`
+	// Token is sent as a bearer token, BasicAuthUser and
+	// BasicAuthPassword as basic auth, e.g. for Grafana Cloud
+	Token             string            `json:"token"`
+	BasicAuthUser     string            `json:"basic_auth_user"`
+	BasicAuthPassword string            `json:"basic_auth_password"`
+	TenantID          string            `json:"tenant_id"`
+	CAFile            string            `json:"ca_file"`
+	Tags              map[string]string `json:"tags"`
 }
 
 type Metrics struct {
 	HTTPListener
 	Enabled bool `json:"enabled"`
+	// RateInterval between the samples peer throughput is computed from
+	RateInterval Duration `json:"rate_interval"`
+	// Namespace prefixes every metric name, followed by an underscore, and
+	// ConstLabels are added to every metric, such as the cluster name, so
+	// several installations can share a Prometheus without collisions
+	Namespace   string            `json:"namespace"`
+	ConstLabels map[string]string `json:"const_labels"`
+	RemoteWrite RemoteWrite       `json:"remote_write"`
+}
+
+type APITLS struct {
+	CertFile         string   `json:"cert_file"`
+	KeyFile          string   `json:"key_file"`
+	ClientCAFile     string   `json:"client_ca_file"`
+	AdminCommonNames []string `json:"admin_common_names"`
+}
+
+type APIToken struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	Role  string `json:"role" jsonschema:"enum=admin|read-only"`
+}
+
+type APITokenReview struct {
+	Enabled                 bool     `json:"enabled"`
+	Audiences               []string `json:"audiences"`
+	AdminServiceAccounts    []string `json:"admin_service_accounts"`
+	ReadOnlyServiceAccounts []string `json:"read_only_service_accounts"`
+}
+
+type APIAuth struct {
+	Tokens      []APIToken     `json:"tokens"`
+	TokenReview APITokenReview `json:"token_review"`
+}
+
+type API struct {
+	HTTPListener
+	Enabled bool    `json:"enabled"`
+	TLS     APITLS  `json:"tls"`
+	Auth    APIAuth `json:"auth"`
+	// DrainDelay is how long the API keeps accepting requests after
+	// readiness starts failing on shutdown, giving load balancers time to
+	// stop sending new ones
+	DrainDelay Duration `json:"drain_delay"`
+}
+
+type Kubernetes struct {
+	Kubeconfig string `json:"kubeconfig"`
+	// NodeName, PodName, PodNamespace and HostIP default to the Downward
+	// API variables, see Env*
+	NodeName     string `json:"node_name" jsonschema:"nodefault"`
+	PodName      string `json:"pod_name" jsonschema:"nodefault"`
+	PodNamespace string `json:"pod_namespace" jsonschema:"nodefault"`
+	HostIP       string `json:"host_ip" jsonschema:"nodefault"`
+	Events       bool   `json:"events"`
+	Network      string `json:"network"`
+	Peers        bool   `json:"peers"`
+	// Annotate publishes the node's WireGuard public key, tunnel IPs and
+	// endpoint as annotations on its Node object
+	Annotate         bool   `json:"annotate"`
+	AnnotationPrefix string `json:"annotation_prefix"`
+	// EndpointService is the namespace/name of a LoadBalancer or NodePort
+	// Service whose address is advertised as the endpoint
+	EndpointService string        `json:"endpoint_service"`
+	PresharedKeys   PresharedKeys `json:"preshared_keys"`
+	PeerStatus      PeerStatus    `json:"peer_status"`
+	NodePeers       NodePeers     `json:"node_peers"`
+	// NodeFinalizer keeps the Node from being deleted until this node's
+	// WireGuardPeer status entries are gone. Whoever sees the deletion
+	// first cleans up, the terminating pod or the operator, so the
+	// operator must run or a Node deleted with its pod gone is stuck
+	NodeFinalizer bool `json:"node_finalizer"`
+}
+
+// PresharedKeys generates a preshared key for every pair of nodes and keeps
+// it in a Secret, adding a layer of post-quantum resistance to the tunnel.
+// Only peers whose metadata matches PeerSelector get one: a peer outside the
+// cluster can't read the Secret and would fail the handshake.
+//
+// The Secrets are named after a hash of the pair, which RBAC can't narrow
+// get down to, so every node can read the keys of all pairs. They belong in
+// a namespace of their own that nothing else may read.
+type PresharedKeys struct {
+	Enabled bool `json:"enabled"`
+	// Namespace of the Secrets, defaults to the pod's namespace
+	Namespace    string `json:"namespace" jsonschema:"nodefault"`
+	PeerSelector string `json:"peer_selector"`
+	// CacheTTL is how long a key is used before it is read again, so an
+	// updated or deleted Secret takes effect
+	CacheTTL Duration `json:"cache_ttl"`
+}
+
+// PeerStatus writes what this node sees of the WireGuardPeers it is a
+// gateway for to their status subresource, every Interval.
+type PeerStatus struct {
+	Enabled  bool     `json:"enabled"`
+	Interval Duration `json:"interval"`
+}
+
+// NodePeers configures the other nodes as peers from the annotations they
+// publish, forming the mesh between the nodes.
+type NodePeers struct {
+	Enabled bool `json:"enabled"`
+	// PodCIDRs adds each node's Pod CIDRs to its peer, so pods on
+	// different nodes reach each other through the mesh without another
+	// overlay
+	PodCIDRs bool `json:"pod_cidrs"`
+}
+
+// applyDownwardAPI fills in the identity the config leaves out from the
+// Downward API environment variables.
+func (k *Kubernetes) applyDownwardAPI() {
+	for field, env := range map[*string]string{
+		&k.NodeName:     EnvNodeName,
+		&k.PodName:      EnvPodName,
+		&k.PodNamespace: EnvPodNamespace,
+		&k.HostIP:       EnvHostIP,
+	} {
+		if *field == "" {
+			*field = os.Getenv(env)
+		}
+	}
+}
+
+// EndpointServiceRef splits EndpointService into its namespace and name. A
+// bare name refers to a Service in the pod's own namespace.
+func (k *Kubernetes) EndpointServiceRef() (string, string, bool) {
+	namespace, name, ok := strings.Cut(k.EndpointService, "/")
+	if !ok {
+		namespace, name = k.PodNamespace, k.EndpointService
+	}
+	return namespace, name, namespace != "" && name != ""
+}
+
+type Enrollment struct {
+	Enabled  bool            `json:"enabled"`
+	TokenTTL Duration        `json:"token_ttl"`
+	Pools    []string        `json:"pools"`
+	Lease    EnrollmentLease `json:"lease"`
+	// DefaultProfile is the access profile of tokens issued without one,
+	// empty gives those clients the whole network
+	DefaultProfile string         `json:"default_profile"`
+	OIDC           EnrollmentOIDC `json:"oidc"`
+}
+
+// EnrollmentLease makes enrolled peers renew through the API within TTL.
+// A peer that doesn't is removed Grace after its lease expired, and its
+// addresses go back to the pools. With MaxAge set, renewals stop MaxAge
+// after enrollment and the peer has to re-enroll with a
+// fresh key pair to stay connected.
+type EnrollmentLease struct {
+	Enabled bool     `json:"enabled"`
+	TTL     Duration `json:"ttl"`
+	Grace   Duration `json:"grace"`
+	MaxAge  Duration `json:"max_age"`
+}
+
+type FederationRemote struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Token  string `json:"token"`
+	CAFile string `json:"ca_file"`
+	// AllowedCIDRs bounds what the remote's peers may route, allowed IPs
+	// outside of them are dropped so a remote can't claim local traffic
+	AllowedCIDRs []string `json:"allowed_cidrs"`
+}
+
+type Federation struct {
+	Enabled        bool               `json:"enabled"`
+	ClusterName    string             `json:"cluster_name"`
+	Interval       Duration           `json:"interval"`
+	AdvertiseCIDRs []string           `json:"advertise_cidrs"`
+	Remotes        []FederationRemote `json:"remotes"`
+}
+
+type Exporter struct {
+	Enabled   bool   `json:"enabled"`
+	Interface string `json:"interface"`
+}
+
+// Audit appends a JSON line for every administrative action to Path, or to
+// stdout when Path is empty or "-".
+type Audit struct {
+	Enabled bool   `json:"enabled"`
+	Path    string `json:"path"`
+}
+
+type Resolver struct {
+	Server      string   `json:"server"`
+	MinTTL      Duration `json:"min_ttl"`
+	MaxTTL      Duration `json:"max_ttl"`
+	NegativeTTL Duration `json:"negative_ttl"`
+	StaleTTL    Duration `json:"stale_ttl"`
+	Timeout     Duration `json:"timeout"`
+}
+
+// HoldDown keeps a changed peer endpoint from being applied until Duration
+// has passed since the last change, doubled for every flap past
+// FlapThreshold within FlapWindow and capped at FlapWindow. Zero values
+// take the defaults, so the hold-down can't be turned off.
+type HoldDown struct {
+	Duration      Duration `json:"duration"`
+	FlapThreshold uint32   `json:"flap_threshold"`
+	FlapWindow    Duration `json:"flap_window"`
+}
+
+// PeerGC removes runtime peers whose WireGuardPeer or federated cluster
+// has been gone for TTL, for when the removal itself was missed.
+// Peers nothing else backs, such as the ones added through the API, are
+// left alone.
+type PeerGC struct {
+	Enabled  bool     `json:"enabled"`
+	TTL      Duration `json:"ttl"`
+	Interval Duration `json:"interval"`
+}
+
+type STUN struct {
+	Enabled bool     `json:"enabled"`
+	Servers []string `json:"servers"`
+}
+
+type Rendezvous struct {
+	URL    string `json:"url"`
+	Token  string `json:"token"`
+	CAFile string `json:"ca_file"`
+}
+
+type HolePunching struct {
+	Enabled    bool       `json:"enabled"`
+	Serve      bool       `json:"serve"`
+	Interval   Duration   `json:"interval"`
+	Rendezvous Rendezvous `json:"rendezvous"`
+}
+
+type Relay struct {
+	Peers         []string `json:"peers"`
+	FailoverAfter Duration `json:"failover_after"`
+	Serve         bool     `json:"serve"`
+}
+
+// ExitNode makes this node the default gateway of the clients matching
+// ClientSelector: their client configs route 0.0.0.0/0 and ::/0 through
+// the tunnel, and their traffic leaves masqueraded behind this node.
+type ExitNode struct {
+	Enabled bool `json:"enabled"`
+	// ClientSelector selects clients by their metadata, e.g. the labels of
+	// their WireGuardPeer. Empty selects every client
+	ClientSelector string `json:"client_selector"`
+	// EgressInterface restricts masquerading to traffic leaving through
+	// it, empty masquerades everything that doesn't go back into the
+	// tunnel, including traffic to cluster Services
+	EgressInterface string `json:"egress_interface"`
+	// DNS is handed to exit clients instead of wireguard.dns, so their
+	// lookups don't leak to the network they are on
+	DNS []string `json:"dns"`
+	// ExcludedIPs stay off the tunnel for every exit client, on top of
+	// the client's own excluded_ips, e.g. 169.254.169.254/32 so cloud VMs
+	// keep reaching their metadata service
+	ExcludedIPs []string `json:"excluded_ips"`
+}
+
+// Selects reports whether peer sends all its traffic through this node.
+func (e *ExitNode) Selects(peer *WireGuardPeer) bool {
+	if !e.Enabled {
+		return false
+	}
+	selector, err := labels.Parse(e.ClientSelector)
+	return err == nil && selector.Matches(labels.Set(peer.Metadata))
+}
+
+type WireGuardPeer struct {
+	Name                string            `json:"name"`
+	PublicKey           string            `json:"public_key"`
+	PresharedKey        string            `json:"preshared_key"`
+	Endpoint            string            `json:"endpoint"`
+	AllowedIPs          []string          `json:"allowed_ips"`
+	PersistentKeepalive uint32            `json:"persistent_keepalive"`
+	Metadata            map[string]string `json:"metadata"`
+	// ExcludedIPs are left out of the routes handed to the peer when it
+	// uses this node as its exit node, such as its local LAN
+	ExcludedIPs []string `json:"excluded_ips"`
+}
+
+type WireGuard struct {
+	Enabled bool `json:"enabled"`
+	// InterfaceName names the interface, kubewg0 by default
+	InterfaceName string `json:"interface_name"`
+	// Adopt takes over a WireGuard interface of that name kubewg didn't
+	// create instead of refusing to start. It keeps the interface's
+	// private key unless another one is configured or stored, and leaves
+	// the interface in place on shutdown
+	Adopt bool `json:"adopt"`
+	// Userspace runs the interface in wireguard-go instead of the kernel:
+	// off, auto when the kernel module is missing, or always
+	Userspace string  `json:"userspace" jsonschema:"enum=off|auto|always"`
+	Network   Network `json:"network"`
+	MTU       int     `json:"mtu"`
+	// ListenPort 0 picks a free port from ListenPortRange at startup
+	ListenPort      uint16            `json:"listen_port"`
+	ListenPortRange PortRange         `json:"listen_port_range"`
+	Endpoint        string            `json:"endpoint"`
+	STUN            STUN              `json:"stun"`
+	HolePunching    HolePunching      `json:"hole_punching"`
+	Relay           Relay             `json:"relay"`
+	Addresses       []string          `json:"addresses"`
+	DNS             []string          `json:"dns"`
+	KeyRotation     uint32            `json:"key_rotation"`
+	Labels          map[string]string `json:"labels"`
+	PrivateKey      string            `json:"private_key"`
+	PrivateKeyFile  string            `json:"private_key_file"`
+	ImportFile      string            `json:"import_file"`
+	ResyncInterval  Duration          `json:"resync_interval"`
+	DetectOnly      bool              `json:"detect_only"`
+	HoldDown        HoldDown          `json:"hold_down"`
+	PeerGC          PeerGC            `json:"peer_gc"`
+	// FwMark marks the interface's own encrypted packets. When set, peers
+	// may route 0.0.0.0/0 and ::/0 through the tunnel, see Routing
+	FwMark   uint32   `json:"fwmark"`
+	Routing  Routing  `json:"routing"`
+	ExitNode ExitNode `json:"exit_node"`
+	// ClampMSS rewrites the MSS of forwarded TCP handshakes to the path
+	// MTU, for routes whose MTU is smaller than the tunnel's
+	ClampMSS bool `json:"clamp_mss"`
+	// EndpointDetection decides where the advertised endpoint comes from
+	EndpointDetection EndpointDetection `json:"endpoint_detection"`
+	ClusterCIDRs      ClusterCIDRs      `json:"cluster_cidrs"`
+	ACL               ACL               `json:"acl"`
+	Profiles          []AccessProfile   `json:"profiles"`
+	Teardown          Teardown          `json:"teardown"`
+	Peers             []WireGuardPeer   `json:"peers"`
+}
+
+// Teardown decides what is left behind on shutdown. The peers' routes,
+// the policy rules and the firewall rules are always removed.
+type Teardown struct {
+	// KeepInterface leaves the interface and its peers in place, e.g. for
+	// a host that must keep its address while the pod is replaced
+	KeepInterface bool `json:"keep_interface"`
+	// KeepPublished leaves the node annotations and this node's entries in
+	// the WireGuardPeer status in place. By default they are removed, so
+	// the rest of the cluster doesn't keep dialing a node that is gone
+	KeepPublished bool `json:"keep_published"`
+}
+
+// PortRange is an inclusive range of ports.
+type PortRange struct {
+	Min uint16 `json:"min"`
+	Max uint16 `json:"max"`
+}
+
+// Contains reports whether port lies within the range.
+func (r PortRange) Contains(port uint16) bool {
+	return port >= r.Min && port <= r.Max
+}
+
+// Key stores
+const (
+	// KeyStoreFile keeps the private key in wireguard.private_key_file
+	KeyStoreFile = "file"
+	// KeyStoreSecret keeps keys in Kubernetes Secrets
+	KeyStoreSecret = "secret"
+	// KeyStoreVault keeps keys in HashiCorp Vault
+	KeyStoreVault = "vault"
+	// KeyStoreMemory generates a new private key on every start
+	KeyStoreMemory = "memory"
+)
+
+const (
+	// StateMemory forgets the state on restart
+	StateMemory = "memory"
+	// StateFile keeps the state in a bbolt database on the node
+	StateFile = "file"
+	// StateKubernetes keeps the state in a Secret per node
+	StateKubernetes = "kubernetes"
+	// StateCRD keeps the state in a WireGuardState per node
+	StateCRD = "crd"
+)
+
+// State selects where node-local state survives restarts: enrollment
+// tokens, enrolled peers, their leases and the addresses allocated to them,
+// and the peers imported through the API. The memory and file types need no
+// cluster, for a standalone server.
+type State struct {
+	Type string `json:"type" jsonschema:"enum=memory|file|kubernetes|crd"`
+	// Path of the database of the file type
+	Path string `json:"path"`
+	// Namespace of the Secret of the kubernetes type, defaults to the
+	// pod's namespace
+	Namespace string `json:"namespace" jsonschema:"nodefault"`
+}
+
+// KeyStore selects where the private key, and preshared keys when they are
+// generated, live. Preshared keys have to be shared between nodes, so with
+// the file and memory stores they are kept in Secrets regardless.
+type KeyStore struct {
+	Type   string         `json:"type" jsonschema:"enum=file|secret|vault|memory"`
+	Secret SecretKeyStore `json:"secret"`
+	Vault  Vault          `json:"vault"`
+}
+
+// Shared reports whether every node reaches the same keys in the store.
+func (k *KeyStore) Shared() bool {
+	return k.Type == KeyStoreSecret || k.Type == KeyStoreVault
+}
+
+// NeedsSecrets reports whether keys are kept in Kubernetes Secrets.
+func (c *Config) NeedsSecrets() bool {
+	return c.KeyStore.Type == KeyStoreSecret || (c.Kubernetes.PresharedKeys.Enabled && !c.KeyStore.Shared())
+}
+
+// StateInKubernetes reports whether state is kept in the cluster.
+func (c *Config) StateInKubernetes() bool {
+	return c.WireGuard.Enabled && (c.State.Type == StateKubernetes || c.State.Type == StateCRD)
+}
+
+type SecretKeyStore struct {
+	// Namespace of the Secrets, defaults to the pod's namespace
+	Namespace string `json:"namespace" jsonschema:"nodefault"`
+}
+
+// Vault keeps keys in a KV v2 secrets engine, optionally encrypted with a
+// Transit key, logging in with the Kubernetes auth method.
+type Vault struct {
+	Address   string       `json:"address"`
+	CAFile    string       `json:"ca_file"`
+	Namespace string       `json:"namespace"`
+	Auth      VaultAuth    `json:"auth"`
+	KV        VaultKV      `json:"kv"`
+	Transit   VaultTransit `json:"transit"`
+}
+
+type VaultAuth struct {
+	Mount     string `json:"mount"`
+	Role      string `json:"role"`
+	TokenFile string `json:"token_file"`
+}
+
+type VaultKV struct {
+	Mount string `json:"mount"`
+	Path  string `json:"path"`
+}
+
+// VaultTransit encrypts keys before they are written to KV when Key is set,
+// so reading KV alone reveals nothing.
+type VaultTransit struct {
+	Mount string `json:"mount"`
+	Key   string `json:"key"`
 }
 
 // Config is the main configuration for the application
 type Config struct {
-	Tracing
-	PProf   PProf   `json:"pprof"`
-	Metrics Metrics `json:"metrics"`
+	Tracing    Tracing    `json:"tracing"`
+	PProf      PProf      `json:"pprof"`
+	Profiling  Profiling  `json:"profiling"`
+	Metrics    Metrics    `json:"metrics"`
+	API        API        `json:"api"`
+	Enrollment Enrollment `json:"enrollment"`
+	Kubernetes Kubernetes `json:"kubernetes"`
+	Federation Federation `json:"federation"`
+	Exporter   Exporter   `json:"exporter"`
+	Resolver   Resolver   `json:"resolver"`
+	DNSProxy   DNSProxy   `json:"dns_proxy"`
+	KeyStore   KeyStore   `json:"keystore"`
+	State      State      `json:"state"`
+	Audit      Audit      `json:"audit"`
+	Prober     Prober     `json:"prober"`
+	Webhooks   Webhooks   `json:"webhooks"`
+	SPIFFE     SPIFFE     `json:"spiffe"`
+	// PrivilegeSeparation moves the interfaces into a helper process
+	PrivilegeSeparation PrivilegeSeparation `json:"privilege_separation"`
+	// Sandbox restricts the process once it is up
+	Sandbox Sandbox `json:"sandbox"`
+	// CryptoPolicy restricts the admin API's cryptography
+	CryptoPolicy CryptoPolicy `json:"crypto_policy"`
+	// FeatureGates switch experimental subsystems on and off
+	FeatureGates FeatureGates `json:"feature_gates"`
+	// NodeOverrides are applied once the node is known, see
+	// ApplyNodeOverrides
+	NodeOverrides []NodeOverride `json:"node_overrides"`
+	WireGuard     WireGuard      `json:"wireguard"`
+	// Interfaces are run next to WireGuard, see validateInterfaces for
+	// what they support
+	Interfaces []WireGuard `json:"interfaces"`
 }
 
 //nolint:golint,gochecknoglobals
 var (
-	ConfigFileKey      = "config"
-	TracingEnabledKey  = "tracing.enabled"
-	TracingOTLPEndKey  = "tracing.otlp_endpoint"
-	PProfEnabledKey    = "pprof.enabled"
-	PProfIPV4HostKey   = "pprof.ipv4_host"
-	PProfIPV6HostKey   = "pprof.ipv6_host"
-	PProfPortKey       = "pprof.port"
-	MetricsEnabledKey  = "metrics.enabled"
-	MetricsIPV4HostKey = "metrics.ipv4_host"
-	MetricsIPV6HostKey = "metrics.ipv6_host"
-	MetricsPortKey     = "metrics.port"
+	ConfigFileKey       = "config"
+	ConfigKeyFileKey    = "config_key_file"
+	ConfigDirKey        = "config-dir"
+	EnvPrefixKey        = "env-prefix"
+	EnvUnprefixedKey    = "env-unprefixed"
+	TracingEnabledKey   = "tracing.enabled"
+	TracingOTLPEndKey   = "tracing.otlp_endpoint"
+	TracingRedactionKey = "tracing.redaction"
+	TracingSamplerKey   = "tracing.sampler.type"
+	TracingRatioKey     = "tracing.sampler.ratio"
+	TracingPropagateKey = "tracing.propagators"
+	PProfEnabledKey     = "pprof.enabled"
+	PProfIPV4HostKey    = "pprof.ipv4_host"
+	PProfIPV6HostKey    = "pprof.ipv6_host"
+	PProfPortKey        = "pprof.port"
+	PProfBlockRateKey   = "pprof.block_profile_rate"
+	PProfMutexKey       = "pprof.mutex_profile_fraction"
+	ProfilingKey        = "profiling.enabled"
+	ProfilingServerKey  = "profiling.server_address"
+	ProfilingIntKey     = "profiling.interval"
+	ProberKey           = "prober.enabled"
+	ProberModeKey       = "prober.mode"
+	PrivSepKey          = "privilege_separation.enabled"
+	PrivSepAgentKey     = "privilege_separation.agent_socket"
+	SandboxKey          = "sandbox.disabled"
+	CryptoModeKey       = "crypto_policy.mode"
+	FeatureGatesKey     = "feature-gates"
+	WebhooksKey         = "webhooks.enabled"
+	WebhookURLsKey      = "webhooks.urls"
+	MetricsEnabledKey   = "metrics.enabled"
+	MetricsNamespaceKey = "metrics.namespace"
+	RemoteWriteKey      = "metrics.remote_write.enabled"
+	RemoteWriteURLKey   = "metrics.remote_write.url"
+	MetricsIPV4HostKey  = "metrics.ipv4_host"
+	MetricsIPV6HostKey  = "metrics.ipv6_host"
+	MetricsPortKey      = "metrics.port"
+	APIEnabledKey       = "api.enabled"
+	APIIPV4HostKey      = "api.ipv4_host"
+	APIIPV6HostKey      = "api.ipv6_host"
+	APIPortKey          = "api.port"
+	APITLSCertKey       = "api.tls.cert_file"
+	APITLSKeyKey        = "api.tls.key_file"
+	APITLSClientCAKey   = "api.tls.client_ca_file"
+	APIRateLimitKey     = "api.limits.rate_limit"
+	APIBurstKey         = "api.limits.burst"
+	APIMaxConcKey       = "api.limits.max_concurrent"
+	APIMaxBodyKey       = "api.limits.max_body_bytes"
+	APIShutdownKey      = "api.shutdown_timeout"
+	APIDrainKey         = "api.drain_delay"
+	KubeconfigKey       = "kubernetes.kubeconfig"
+	KubeNodeNameKey     = "kubernetes.node_name"
+	KubePodNameKey      = "kubernetes.pod_name"
+	KubePodNSKey        = "kubernetes.pod_namespace"
+	KubeHostIPKey       = "kubernetes.host_ip"
+	KubeEventsKey       = "kubernetes.events"
+	KubeNetworkKey      = "kubernetes.network"
+	KubePeersKey        = "kubernetes.peers"
+	KubeAnnotateKey     = "kubernetes.annotate"
+	KubeAnnotPrefixKey  = "kubernetes.annotation_prefix"
+	KubeEndpointSvcKey  = "kubernetes.endpoint_service"
+	KubePSKKey          = "kubernetes.preshared_keys.enabled"
+	KubePeerStatusKey   = "kubernetes.peer_status.enabled"
+	KubeNodePeersKey    = "kubernetes.node_peers.enabled"
+	KubePodCIDRsKey     = "kubernetes.node_peers.pod_cidrs"
+	EnrollEnabledKey    = "enrollment.enabled"
+	EnrollTokenTTLKey   = "enrollment.token_ttl"
+	EnrollLeaseKey      = "enrollment.lease.enabled"
+	EnrollLeaseTTLKey   = "enrollment.lease.ttl"
+	EnrollLeaseGraceKey = "enrollment.lease.grace"
+	EnrollLeaseMaxKey   = "enrollment.lease.max_age"
+	FederationKey       = "federation.enabled"
+	FederationNameKey   = "federation.cluster_name"
+	FederationIntKey    = "federation.interval"
+	ExporterEnabledKey  = "exporter.enabled"
+	ExporterIfaceKey    = "exporter.interface"
+	ResolverServerKey   = "resolver.server"
+	DNSProxyKey         = "dns_proxy.enabled"
+	KeyStoreTypeKey     = "keystore.type"
+	StateTypeKey        = "state.type"
+	StatePathKey        = "state.path"
+	AuditEnabledKey     = "audit.enabled"
+	AuditPathKey        = "audit.path"
+	ResolverMinTTLKey   = "resolver.min_ttl"
+	ResolverMaxTTLKey   = "resolver.max_ttl"
+	ResolverNegTTLKey   = "resolver.negative_ttl"
+	ResolverStaleKey    = "resolver.stale_ttl"
+	ResolverTimeoutKey  = "resolver.timeout"
+	WireGuardEnabledKey = "wireguard.enabled"
+	WireGuardMTUKey     = "wireguard.mtu"
+	WireGuardPortKey    = "wireguard.listen_port"
+	WireGuardIfaceKey   = "wireguard.interface_name"
+	WireGuardAdoptKey   = "wireguard.adopt"
+	WireGuardUserKey    = "wireguard.userspace"
+	WireGuardEndKey     = "wireguard.endpoint"
+	WireGuardKeyFileKey = "wireguard.private_key_file"
+	WireGuardResyncKey  = "wireguard.resync_interval"
+	WireGuardImportKey  = "wireguard.import_file"
+	WireGuardDetectKey  = "wireguard.detect_only"
+	WireGuardRotateKey  = "wireguard.key_rotation"
+	WireGuardTableKey   = "wireguard.routing.table"
+	WireGuardFwMarkKey  = "wireguard.fwmark"
+	ExitNodeKey         = "wireguard.exit_node.enabled"
+	ClampMSSKey         = "wireguard.clamp_mss"
+	EndpointSourcesKey  = "wireguard.endpoint_detection.sources"
+	WireGuardSTUNKey    = "wireguard.stun.enabled"
+	PunchEnabledKey     = "wireguard.hole_punching.enabled"
+	PunchServeKey       = "wireguard.hole_punching.serve"
+	PunchIntervalKey    = "wireguard.hole_punching.interval"
+	RelayFailoverKey    = "wireguard.relay.failover_after"
+	RelayServeKey       = "wireguard.relay.serve"
+	HoldDownKey         = "wireguard.hold_down.duration"
+	HoldDownFlapsKey    = "wireguard.hold_down.flap_threshold"
+	HoldDownWindowKey   = "wireguard.hold_down.flap_window"
+	PeerGCKey           = "wireguard.peer_gc.enabled"
+	PeerGCTTLKey        = "wireguard.peer_gc.ttl"
+)
+
+const (
+	RoleAdmin    = "admin"
+	RoleReadOnly = "read-only"
 )
 
 const (
@@ -79,43 +712,597 @@ const (
 	DefaultMetricsIPV4Host = "127.0.0.1"
 	DefaultMetricsIPV6Host = "::1"
 	DefaultMetricsPort     = 8081
+	DefaultRateInterval    = 10 * time.Second
 	DefaultPprofIPV4Host   = "127.0.0.1"
 	DefaultPprofIPV6Host   = "::1"
 	DefaultPprofPort       = 6060
+	DefaultPprofMaxDelta   = 5 * time.Minute
+	DefaultAPIIPV4Host     = "127.0.0.1"
+	DefaultAPIIPV6Host     = "::1"
+	DefaultAPIPort         = 8080
+	DefaultProfilingApp    = "kubewg"
+	DefaultProfilingInt    = 15 * time.Second
+	DefaultMaxBodyBytes    = 1 << 20
+	DefaultShutdownTimeout = 5 * time.Second
+	DefaultEnrollTokenTTL  = time.Hour
+	DefaultLeaseTTL        = time.Hour
+	DefaultLeaseGrace      = 5 * time.Minute
+	MinLeaseMaxAge         = 10 * time.Minute
+	DefaultFederationInt   = 30 * time.Second
+	DefaultPeerStatusInt   = 30 * time.Second
+	DefaultExporterIface   = "wg0"
+	DefaultResolverMinTTL  = 5 * time.Second
+	DefaultResolverMaxTTL  = time.Hour
+	DefaultResolverNegTTL  = 30 * time.Second
+	DefaultResolverStale   = 5 * time.Minute
+	DefaultResolverTimeout = 5 * time.Second
+	DefaultWireGuardPort   = 51820
+	DefaultListenPortMax   = 51899
+	DefaultWireGuardKey    = "/var/lib/kubewg/private.key"
+	DefaultStatePath       = "/var/lib/kubewg/state.db"
+	DefaultInterfaceName   = "kubewg0"
+	DefaultWireGuardResync = 30 * time.Second
+	DefaultPSKCacheTTL     = 5 * time.Minute
+	DefaultHoldDown        = 30 * time.Second
+	DefaultHoldDownFlaps   = 3
+	DefaultHoldDownWindow  = 5 * time.Minute
+	DefaultPeerGCTTL       = 10 * time.Minute
+	DefaultPeerGCInterval  = time.Minute
+	DefaultPunchInterval   = 10 * time.Second
+	DefaultRelayFailover   = 5 * time.Minute
+	DefaultAnnotPrefix     = "kubewg.net/"
+	DefaultVaultAuthMount  = "kubernetes"
+	DefaultVaultTokenFile  = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	DefaultVaultKVMount    = "secret"
+	DefaultVaultKVPath     = "kubewg"
+	DefaultVaultTransit    = "transit"
+	MinWireGuardMTU        = 1280
+	MaxWireGuardMTU        = 9000
+)
+
+// Where the WireGuard interface runs
+const (
+	// UserspaceOff only uses the kernel module
+	UserspaceOff = "off"
+	// UserspaceAuto falls back to wireguard-go when the kernel has no
+	// WireGuard support, which needs /dev/net/tun
+	UserspaceAuto = "auto"
+	// UserspaceAlways runs wireguard-go even when the kernel module is
+	// there
+	UserspaceAlways = "always"
+)
+
+// Environment variables a DaemonSet sets from the Downward API, so the same
+// manifest works on every node without naming it in the config
+const (
+	EnvNodeName     = "NODE_NAME"
+	EnvPodName      = "POD_NAME"
+	EnvPodNamespace = "POD_NAMESPACE"
+	EnvHostIP       = "HOST_IP"
+)
+
+// metricName matches metric and label names, without the colons only
+// recording rules should use
+//
+//nolint:golint,gochecknoglobals
+var metricName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// DefaultSTUNServers are queried when STUN is enabled without servers
+//
+//nolint:golint,gochecknoglobals
+var DefaultSTUNServers = []string{"stun.l.google.com:19302", "stun.cloudflare.com:3478"}
+
+var (
+	ErrResolverTTLRange   = errors.New("resolver min_ttl must not be greater than max_ttl")
+	ErrProfilingServer    = errors.New("profiling requires a server_address")
+	ErrPProfRates         = errors.New("pprof profile rates must not be negative")
+	ErrMetricsNamespace   = errors.New("metrics.namespace must be a valid metric name prefix")
+	ErrMetricsLabel       = errors.New("metrics.const_labels must have valid label names not starting with __")
+	ErrExitNodeSelector   = errors.New("invalid wireguard.exit_node.client_selector")
+	ErrExcludedIPs        = errors.New("invalid excluded_ips prefix")
+	ErrHTTPLimits         = errors.New("listener limits must not be negative")
+	ErrAPITLSPair         = errors.New("api.tls.cert_file and api.tls.key_file must be set together")
+	ErrAPIClientCA        = errors.New("api.tls.client_ca_file requires api.tls.cert_file")
+	ErrAPITokenEmpty      = errors.New("api token is empty")
+	ErrAPIRole            = fmt.Errorf("api role must be %q or %q", RoleAdmin, RoleReadOnly)
+	ErrEnrollmentDeps     = errors.New("enrollment requires wireguard and the API to be enabled")
+	ErrEnrollmentPools    = errors.New("enrollment requires at least one address pool")
+	ErrEnrollmentEndpoint = errors.New("enrollment requires wireguard.endpoint, wireguard.stun, kubernetes.endpoint_service or kubernetes.host_ip to be set")
+	ErrLeaseMaxAge        = errors.New("enrollment.lease.max_age requires leases to be enabled and must be at least 10m")
+	ErrPeerPublicKey      = errors.New("wireguard peer is missing a public key")
+	ErrKubeEventsNodeName = errors.New("kubernetes.events requires kubernetes.node_name to be set")
+	ErrKubeNetworkDeps    = errors.New("kubernetes.network requires wireguard to be enabled")
+	ErrKubePeersNetwork   = errors.New("kubernetes.peers requires kubernetes.network to be set")
+	ErrKubePeerStatusDeps = errors.New("kubernetes.peer_status requires kubernetes.peers and a node_name")
+	ErrKubeNodePeersDeps  = errors.New("kubernetes.node_peers requires kubernetes.annotate")
+	ErrKubePodCIDRsDeps   = errors.New("kubernetes.node_peers.pod_cidrs requires kubernetes.node_peers.enabled")
+	ErrKubeFinalizerDeps  = errors.New("kubernetes.node_finalizer requires kubernetes.node_name to be set")
+	ErrKubeAnnotateDeps   = errors.New("kubernetes.annotate requires wireguard and kubernetes.node_name to be set")
+	ErrKubeAnnotPrefix    = errors.New("kubernetes.annotation_prefix must be a DNS subdomain followed by a slash")
+	ErrKubeEndpointSvc    = errors.New("kubernetes.endpoint_service must be [namespace/]name, with a pod namespace for a bare name, and requires wireguard to be enabled")
+	ErrKubeHostIP         = errors.New("kubernetes.host_ip is not an IP address")
+	ErrKubePSKDeps        = errors.New("kubernetes.preshared_keys requires wireguard, a namespace and a peer_selector")
+	ErrKeyStoreType       = fmt.Errorf("keystore type must be one of %q, %q, %q or %q", KeyStoreFile, KeyStoreSecret, KeyStoreVault, KeyStoreMemory)
+	ErrKeyStoreSecret     = errors.New("the secret keystore requires a namespace and kubernetes.node_name")
+	ErrKeyStoreVault      = errors.New("the vault keystore requires an address, an auth role and kubernetes.node_name")
+	ErrStateType          = fmt.Errorf("state type must be one of %q, %q, %q or %q", StateMemory, StateFile, StateKubernetes, StateCRD)
+	ErrStateKubernetes    = errors.New("the kubernetes state requires a namespace and kubernetes.node_name")
+	ErrStateCRD           = errors.New("the crd state requires kubernetes.node_name")
+	ErrFederationDeps     = errors.New("federation requires wireguard and the API to be enabled")
+	ErrFederationName     = errors.New("federation requires a cluster_name")
+	ErrFederationRemote   = errors.New("federation remotes need a name and a URL")
+	ErrFederationCIDRs    = errors.New("federation remotes need allowed_cidrs of valid CIDRs")
+	ErrUserspace          = fmt.Errorf("wireguard.userspace must be %q, %q or %q", UserspaceOff, UserspaceAuto, UserspaceAlways)
+	ErrTopology           = fmt.Errorf("wireguard.network.topology must be %q or %q", TopologyFullMesh, TopologyHubSpoke)
+	ErrHubSelector        = errors.New("the HubSpoke topology requires a valid wireguard.network.hub_selector")
+	ErrPunchRendezvous    = errors.New("wireguard.hole_punching requires a rendezvous url")
+	ErrPunchServe         = errors.New("serving as the hole punching rendezvous requires the API to be enabled")
+	ErrExporterDeps       = errors.New("exporter mode requires metrics to be enabled and wireguard to be disabled")
+	ErrWireGuardMTU       = fmt.Errorf("wireguard mtu must be 0 (auto) or between %d and %d", MinWireGuardMTU, MaxWireGuardMTU)
+	ErrListenPortRange    = errors.New("wireguard.listen_port_range needs 0 < min <= max")
 )
 
 func RegisterFlags(cmd *cobra.Command) {
 	cmd.Flags().StringP(ConfigFileKey, "c", DefaultConfigName, "Config file path")
+	cmd.Flags().String(ConfigKeyFileKey, "", "age identity file to decrypt an age or sops encrypted config with")
+	cmd.Flags().String(ConfigDirKey, "", "Directory of YAML files merged over the config file in lexical order")
+	cmd.Flags().String(EnvPrefixKey, DefaultEnvPrefix, "Prefix of the variables options are read from, e.g. KUBEWG_METRICS__PORT")
+	cmd.Flags().Bool(EnvUnprefixedKey, false, "Also read variables without the prefix, e.g. METRICS__PORT, as before the prefix existed")
 	cmd.Flags().Bool(TracingEnabledKey, false, "Enable Open Telemetry tracing")
 	cmd.Flags().String(TracingOTLPEndKey, "", "Open Telemetry endpoint")
+	cmd.Flags().String(TracingRedactionKey, RedactHash, "How peer keys and node names appear in spans: hash, none or omit")
+	cmd.Flags().String(TracingSamplerKey, DefaultTracingSampler, "Trace sampler, as in OTEL_TRACES_SAMPLER")
+	cmd.Flags().Float64(TracingRatioKey, DefaultTracingSamplingRatio, "Share of traces the traceidratio samplers record")
+	cmd.Flags().StringSlice(TracingPropagateKey, nil, "Trace context header formats: tracecontext, baggage, b3 or b3multi")
 	cmd.Flags().Bool(PProfEnabledKey, false, "Enable PProf")
 	cmd.Flags().String(PProfIPV4HostKey, DefaultMetricsIPV4Host, "PProf server IPv4 host")
 	cmd.Flags().String(PProfIPV6HostKey, DefaultMetricsIPV6Host, "PProf server IPv6 host")
 	cmd.Flags().Uint16(PProfPortKey, DefaultMetricsPort, "PProf server port")
+	cmd.Flags().Int(PProfBlockRateKey, 0, "Nanoseconds blocked per sampled blocking event, 0 disables the block profile")
+	cmd.Flags().Int(PProfMutexKey, 0, "Sample one in that many mutex contention events, 0 disables the mutex profile")
+	cmd.Flags().Bool(ProfilingKey, false, "Push CPU and heap profiles to a Pyroscope server")
+	cmd.Flags().String(ProfilingServerKey, "", "Pyroscope server URL")
+	durationFlag(cmd.Flags(), ProfilingIntKey, DefaultProfilingInt, "Time covered by each pushed profile, e.g. 15s")
+	cmd.Flags().Bool(ProberKey, false, "Probe the tunnel address of every peer for round trip times and loss")
+	cmd.Flags().String(ProberModeKey, ProbeICMP, "How peers are probed: icmp or udp")
+	cmd.Flags().Bool(PrivSepKey, false, "Run the interfaces in a privileged helper and drop capabilities everywhere else")
+	cmd.Flags().String(PrivSepAgentKey, "", "Unix socket of a kubewg agent to run the interfaces in instead of a helper")
+	cmd.Flags().Bool(SandboxKey, false, "Run without the seccomp filter and Landlock ruleset applied once up")
+	cmd.Flags().String(CryptoModeKey, CryptoDefault, "Crypto policy of the admin API: default or fips")
+	var gates FeatureGates
+	gateUsage := "Feature gates set over the config's, as Name=true,Other=false:"
+	for _, feature := range features.Known() {
+		gateUsage += "\n" + features.Describe(feature)
+	}
+	cmd.Flags().Var(&gates, FeatureGatesKey, gateUsage)
+	cmd.Flags().Bool(WebhooksKey, false, "Post peer events to webhooks")
+	cmd.Flags().StringSlice(WebhookURLsKey, nil, "URLs peer events are posted to")
 	cmd.Flags().Bool(MetricsEnabledKey, false, "Enable metrics server")
+	cmd.Flags().String(MetricsNamespaceKey, "", "Prefix of every metric name")
+	cmd.Flags().Bool(RemoteWriteKey, false, "Push metrics to a Prometheus remote-write endpoint")
+	cmd.Flags().String(RemoteWriteURLKey, "", "Prometheus remote-write URL")
 	cmd.Flags().String(MetricsIPV4HostKey, DefaultMetricsIPV4Host, "Metrics server IPv4 host")
 	cmd.Flags().String(MetricsIPV6HostKey, DefaultMetricsIPV6Host, "Metrics server IPv6 host")
 	cmd.Flags().Uint16(MetricsPortKey, DefaultMetricsPort, "Metrics server port")
+	cmd.Flags().Bool(APIEnabledKey, false, "Enable the admin API server")
+	cmd.Flags().String(APIIPV4HostKey, DefaultAPIIPV4Host, "Admin API server IPv4 host")
+	cmd.Flags().String(APIIPV6HostKey, DefaultAPIIPV6Host, "Admin API server IPv6 host")
+	cmd.Flags().Uint16(APIPortKey, DefaultAPIPort, "Admin API server port")
+	cmd.Flags().String(APITLSCertKey, "", "Admin API TLS certificate file")
+	cmd.Flags().String(APITLSKeyKey, "", "Admin API TLS key file")
+	cmd.Flags().String(APITLSClientCAKey, "", "CA file for verifying admin API client certificates")
+	cmd.Flags().Float64(APIRateLimitKey, 0, "Admin API requests per second allowed per client, 0 is unlimited")
+	cmd.Flags().Int(APIBurstKey, 0, "Admin API requests a client may send at once above the rate limit, 0 derives it from the rate")
+	cmd.Flags().Int(APIMaxConcKey, 0, "Admin API requests served at the same time, 0 is unlimited")
+	maxBody, shutdownTimeout, drainDelay := ByteSize(DefaultMaxBodyBytes), Duration(DefaultShutdownTimeout), Duration(0)
+	cmd.Flags().Var(&maxBody, APIMaxBodyKey, "Largest admin API request body, e.g. 1Mi")
+	cmd.Flags().Var(&shutdownTimeout, APIShutdownKey, "How long in-flight admin API requests get to finish on shutdown, e.g. 5s")
+	cmd.Flags().Var(&drainDelay, APIDrainKey, "How long the admin API keeps serving after readiness fails on shutdown, e.g. 10s")
+	cmd.Flags().String(KubeconfigKey, "", "Kubeconfig file, defaults to the in-cluster config")
+	cmd.Flags().String(KubeNodeNameKey, "", "Name of the node this container runs on, defaults to $"+EnvNodeName)
+	cmd.Flags().String(KubePodNameKey, "", "Name of this pod, defaults to $"+EnvPodName)
+	cmd.Flags().String(KubePodNSKey, "", "Namespace of this pod, defaults to $"+EnvPodNamespace)
+	cmd.Flags().String(KubeHostIPKey, "", "IP of the node advertised when no endpoint is set, defaults to $"+EnvHostIP)
+	cmd.Flags().Bool(KubeEventsKey, false, "Record peer lifecycle changes as Kubernetes Events on the node")
+	cmd.Flags().String(KubeNetworkKey, "", "WireGuardNetwork to join, its settings become the network defaults")
+	cmd.Flags().Bool(KubePeersKey, false, "Configure the network's WireGuardPeers this node is a gateway for")
+	cmd.Flags().Bool(KubePeerStatusKey, false, "Write what this node sees of its WireGuardPeers to their status")
+	cmd.Flags().Bool(KubeNodePeersKey, false, "Configure the other annotated nodes as peers")
+	cmd.Flags().Bool(KubePodCIDRsKey, false, "Route each node's Pod CIDRs to its peer")
+	cmd.Flags().Bool(KubeAnnotateKey, false, "Publish the node's WireGuard public key, tunnel IPs and endpoint as node annotations")
+	cmd.Flags().String(KubeAnnotPrefixKey, DefaultAnnotPrefix, "Prefix of the node annotations")
+	cmd.Flags().String(KeyStoreTypeKey, KeyStoreFile, "Where keys are kept: file, secret, vault or memory")
+	cmd.Flags().String(StateTypeKey, StateMemory, "Where state is kept: memory, file, kubernetes or crd")
+	cmd.Flags().String(StatePathKey, DefaultStatePath, "Database of the file state")
+	cmd.Flags().Bool(AuditEnabledKey, false, "Record administrative actions in an audit log")
+	cmd.Flags().String(AuditPathKey, "", "Audit log file to append to, stdout if empty or -")
+	cmd.Flags().Bool(KubePSKKey, false, "Generate a preshared key per pair of nodes, shared through Secrets")
+	cmd.Flags().String(KubeEndpointSvcKey, "", "namespace/name of a LoadBalancer or NodePort Service to advertise as the endpoint")
+	cmd.Flags().Bool(EnrollEnabledKey, false, "Enable peer self-registration with one-time tokens")
+	durationFlag(cmd.Flags(), EnrollTokenTTLKey, DefaultEnrollTokenTTL, "Default time an enrollment token stays valid, e.g. 1h")
+	cmd.Flags().Bool(EnrollLeaseKey, false, "Remove enrolled peers that stop renewing their lease")
+	durationFlag(cmd.Flags(), EnrollLeaseTTLKey, DefaultLeaseTTL, "How long an enrolled peer's lease lasts without renewal, e.g. 1h")
+	durationFlag(cmd.Flags(), EnrollLeaseGraceKey, DefaultLeaseGrace, "How long after a lease expired its peer is removed, e.g. 5m")
+	durationFlag(cmd.Flags(), EnrollLeaseMaxKey, 0, "How long after enrollment a peer has to re-enroll with a new key, e.g. 720h, 0 disables")
+	cmd.Flags().Bool(FederationKey, false, "Exchange node peers with the configured remote clusters")
+	cmd.Flags().String(FederationNameKey, "", "Name of this cluster in the federation")
+	durationFlag(cmd.Flags(), FederationIntKey, DefaultFederationInt, "Time between federation syncs, e.g. 30s")
+	cmd.Flags().Bool(ExporterEnabledKey, false, "Only export metrics and status for an existing WireGuard interface")
+	cmd.Flags().String(ExporterIfaceKey, DefaultExporterIface, "WireGuard interface to export in exporter mode")
+	cmd.Flags().String(ResolverServerKey, "", "DNS server (host:port) for endpoint resolution, defaults to the system resolver")
+	cmd.Flags().Bool(DNSProxyKey, false, "Forward the DNS queries of clients to the cluster DNS")
+	durationFlag(cmd.Flags(), ResolverMinTTLKey, DefaultResolverMinTTL, "Minimum time to cache a DNS answer")
+	durationFlag(cmd.Flags(), ResolverMaxTTLKey, DefaultResolverMaxTTL, "Maximum time to cache a DNS answer")
+	durationFlag(cmd.Flags(), ResolverNegTTLKey, DefaultResolverNegTTL, "How long to cache a failed DNS lookup")
+	durationFlag(cmd.Flags(), ResolverStaleKey, DefaultResolverStale, "How long an expired DNS answer may be served when refreshing fails")
+	durationFlag(cmd.Flags(), ResolverTimeoutKey, DefaultResolverTimeout, "DNS query timeout")
+	cmd.Flags().Bool(WireGuardEnabledKey, false, "Enable the WireGuard interface")
+	cmd.Flags().Int(WireGuardMTUKey, 0, "WireGuard interface MTU, 0 detects it from the underlay interface")
+	cmd.Flags().Uint16(WireGuardPortKey, 0, "WireGuard listen port, 0 picks a free one from wireguard.listen_port_range")
+	cmd.Flags().String(WireGuardIfaceKey, DefaultInterfaceName, "WireGuard interface name")
+	cmd.Flags().Bool(WireGuardAdoptKey, false, "Take over an existing WireGuard interface kubewg didn't create")
+	cmd.Flags().String(WireGuardUserKey, UserspaceOff, "Run the interface in wireguard-go: off, auto without the kernel module, or always")
+	cmd.Flags().String(WireGuardEndKey, "", "Public host:port clients use to reach this node")
+	cmd.Flags().String(WireGuardKeyFileKey, DefaultWireGuardKey, "WireGuard private key file, generated if missing")
+	cmd.Flags().String(WireGuardImportKey, "", "wg-quick config file to import interface settings and peers from")
+	durationFlag(cmd.Flags(), WireGuardResyncKey, DefaultWireGuardResync, "Time between WireGuard peer resyncs, e.g. 30s")
+	cmd.Flags().Bool(WireGuardDetectKey, false, "Only report drift from the desired state instead of repairing it")
+	cmd.Flags().Uint32(WireGuardRotateKey, 0, "Rotate the private key after this many seconds, 0 inherits the network policy")
+	cmd.Flags().Int(WireGuardTableKey, 0, "Routing table for peer routes, 0 uses the main table")
+	cmd.Flags().Uint32(WireGuardFwMarkKey, 0, "Firewall mark of the tunnel's own packets, lets peers route default routes through it")
+	cmd.Flags().Bool(ExitNodeKey, false, "Route all traffic of the selected clients through this node")
+	cmd.Flags().Bool(ClampMSSKey, false, "Clamp the MSS of TCP connections forwarded through the tunnel to the path MTU")
+	cmd.Flags().StringSlice(EndpointSourcesKey, nil, "Where to take the advertised endpoint from, in order: static, external_ip, internal_ip, host_ip, interface or stun")
+	cmd.Flags().Bool(WireGuardSTUNKey, false, "Discover the public endpoint through STUN when wireguard.endpoint is unset")
+	cmd.Flags().Bool(PunchEnabledKey, false, "Punch through NAT to peers without a handshake, coordinated by a rendezvous")
+	cmd.Flags().Bool(PunchServeKey, false, "Serve as the hole punching rendezvous through the admin API")
+	durationFlag(cmd.Flags(), PunchIntervalKey, DefaultPunchInterval, "Time between rendezvous announcements, e.g. 10s")
+	durationFlag(cmd.Flags(), RelayFailoverKey, DefaultRelayFailover, "Time without a handshake before a peer is reached through a relay, e.g. 5m")
+	cmd.Flags().Bool(RelayServeKey, false, "Forward traffic between peers so this node can serve as their relay")
+	durationFlag(cmd.Flags(), HoldDownKey, DefaultHoldDown, "Time a changed peer endpoint must be stable before it is applied, e.g. 30s")
+	cmd.Flags().Uint32(HoldDownFlapsKey, DefaultHoldDownFlaps, "Endpoint changes within the flap window before the hold-down is extended")
+	durationFlag(cmd.Flags(), HoldDownWindowKey, DefaultHoldDownWindow, "Time over which endpoint changes are counted as flaps, e.g. 5m")
+	cmd.Flags().Bool(PeerGCKey, false, "Remove runtime peers whose WireGuardPeer or federated cluster is gone")
+	durationFlag(cmd.Flags(), PeerGCTTLKey, DefaultPeerGCTTL, "Time a peer's backing object must be gone before the peer is removed, e.g. 10m")
 }
 
 func (c *Config) Validate() error {
+	if c.Resolver.MinTTL > c.Resolver.MaxTTL {
+		return ErrResolverTTLRange
+	}
+	if c.Profiling.Enabled && c.Profiling.ServerAddress == "" {
+		return ErrProfilingServer
+	}
+	if err := c.Tracing.validate(); err != nil {
+		return err
+	}
+	if err := c.Prober.validate(c.WireGuard.Enabled); err != nil {
+		return err
+	}
+	if err := c.SPIFFE.validate(c.Kubernetes.NodePeers.Enabled); err != nil {
+		return err
+	}
+	if err := c.PrivilegeSeparation.validate(c); err != nil {
+		return err
+	}
+	if err := c.Sandbox.validate(); err != nil {
+		return err
+	}
+	if err := c.CryptoPolicy.validate(); err != nil {
+		return err
+	}
+	if err := c.validateFeatures(); err != nil {
+		return err
+	}
+	if err := c.Webhooks.validate(c.WireGuard.Enabled); err != nil {
+		return err
+	}
+	if err := c.DNSProxy.validate(&c.WireGuard); err != nil {
+		return err
+	}
+	if c.PProf.BlockProfileRate < 0 || c.PProf.MutexProfileFraction < 0 {
+		return ErrPProfRates
+	}
+	if c.Metrics.Namespace != "" && !metricName.MatchString(c.Metrics.Namespace) {
+		return fmt.Errorf("%w: %q", ErrMetricsNamespace, c.Metrics.Namespace)
+	}
+	for name := range c.Metrics.ConstLabels {
+		if !metricName.MatchString(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("%w: %q", ErrMetricsLabel, name)
+		}
+	}
+	if err := c.Metrics.RemoteWrite.validate(); err != nil {
+		return err
+	}
+	for _, limits := range []HTTPLimits{c.Metrics.Limits, c.PProf.Limits, c.API.Limits} {
+		if limits.RateLimit < 0 || limits.Burst < 0 || limits.MaxConcurrent < 0 || limits.MaxBodyBytes < 0 {
+			return ErrHTTPLimits
+		}
+	}
+	for _, wg := range append([]*WireGuard{&c.WireGuard}, c.interfaces()...) {
+		switch wg.Userspace {
+		case "", UserspaceOff, UserspaceAuto, UserspaceAlways:
+		default:
+			return fmt.Errorf("%w: %q", ErrUserspace, wg.Userspace)
+		}
+	}
+	switch c.WireGuard.EffectiveTopology() {
+	case TopologyFullMesh:
+	case TopologyHubSpoke:
+		if _, err := labels.Parse(c.WireGuard.Network.HubSelector); err != nil || c.WireGuard.Network.HubSelector == "" {
+			return ErrHubSelector
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrTopology, c.WireGuard.Network.Topology)
+	}
+	for _, mtu := range []int{c.WireGuard.MTU, c.WireGuard.Network.MTU} {
+		if mtu != 0 && (mtu < MinWireGuardMTU || mtu > MaxWireGuardMTU) {
+			return ErrWireGuardMTU
+		}
+	}
+	if ports := c.WireGuard.ListenPortRange; ports != (PortRange{}) && (ports.Min == 0 || ports.Min > ports.Max) {
+		return ErrListenPortRange
+	}
+	if err := c.WireGuard.Routing.validate(c.WireGuard.FwMark); err != nil {
+		return err
+	}
+	if err := c.validateInterfaces(); err != nil {
+		return err
+	}
+	if err := c.WireGuard.EndpointDetection.validate(c.Kubernetes.NodeName); err != nil {
+		return err
+	}
+	if err := c.WireGuard.ClusterCIDRs.validate(); err != nil {
+		return err
+	}
+	if err := c.WireGuard.ACL.validate(); err != nil {
+		return err
+	}
+	if err := c.WireGuard.validateProfiles(c.Enrollment.DefaultProfile); err != nil {
+		return err
+	}
+	if err := c.Enrollment.OIDC.validate(c.Enrollment.Enabled, &c.WireGuard); err != nil {
+		return err
+	}
+	if _, err := labels.Parse(c.WireGuard.ExitNode.ClientSelector); err != nil {
+		return fmt.Errorf("%w: %w", ErrExitNodeSelector, err)
+	}
+	if err := validatePrefixes(c.WireGuard.ExitNode.ExcludedIPs, ErrExcludedIPs); err != nil {
+		return err
+	}
+	if (c.API.TLS.CertFile == "") != (c.API.TLS.KeyFile == "") {
+		return ErrAPITLSPair
+	}
+	if c.API.TLS.ClientCAFile != "" && c.API.TLS.CertFile == "" {
+		return ErrAPIClientCA
+	}
+	for _, token := range c.API.Auth.Tokens {
+		if token.Token == "" {
+			return fmt.Errorf("%w: %s", ErrAPITokenEmpty, token.Name)
+		}
+		if token.Role != RoleAdmin && token.Role != RoleReadOnly {
+			return fmt.Errorf("%w: %q", ErrAPIRole, token.Role)
+		}
+	}
+	if c.Enrollment.Enabled {
+		if !c.WireGuard.Enabled || !c.API.Enabled {
+			return ErrEnrollmentDeps
+		}
+		if len(c.Enrollment.Pools) == 0 {
+			return ErrEnrollmentPools
+		}
+		if c.WireGuard.Endpoint == "" && len(c.WireGuard.EndpointDetection.Sources) == 0 && !c.WireGuard.STUN.Enabled &&
+			c.Kubernetes.EndpointService == "" && c.Kubernetes.HostIP == "" {
+			return ErrEnrollmentEndpoint
+		}
+		if c.Enrollment.Lease.MaxAge != 0 && (!c.Enrollment.Lease.Enabled || c.Enrollment.Lease.MaxAge.Std() < MinLeaseMaxAge) {
+			return ErrLeaseMaxAge
+		}
+	}
+	if c.Federation.Enabled {
+		if !c.WireGuard.Enabled || !c.API.Enabled {
+			return ErrFederationDeps
+		}
+		if c.Federation.ClusterName == "" {
+			return ErrFederationName
+		}
+		for _, remote := range c.Federation.Remotes {
+			if remote.Name == "" || remote.URL == "" {
+				return ErrFederationRemote
+			}
+			if len(remote.AllowedCIDRs) == 0 {
+				return ErrFederationCIDRs
+			}
+			for _, cidr := range remote.AllowedCIDRs {
+				if _, err := netip.ParsePrefix(cidr); err != nil {
+					return fmt.Errorf("%w: %q", ErrFederationCIDRs, cidr)
+				}
+			}
+		}
+	}
+	if c.WireGuard.HolePunching.Enabled && c.WireGuard.HolePunching.Rendezvous.URL == "" {
+		return ErrPunchRendezvous
+	}
+	if c.WireGuard.HolePunching.Serve && !c.API.Enabled {
+		return ErrPunchServe
+	}
+	if c.Exporter.Enabled && (!c.Metrics.Enabled || c.WireGuard.Enabled) {
+		return ErrExporterDeps
+	}
+	if c.Kubernetes.Events && c.Kubernetes.NodeName == "" {
+		return ErrKubeEventsNodeName
+	}
+	if c.Kubernetes.Network != "" && !c.WireGuard.Enabled {
+		return ErrKubeNetworkDeps
+	}
+	if c.Kubernetes.Peers && c.Kubernetes.Network == "" {
+		return ErrKubePeersNetwork
+	}
+	if c.Kubernetes.PeerStatus.Enabled && (!c.Kubernetes.Peers || c.Kubernetes.NodeName == "") {
+		return ErrKubePeerStatusDeps
+	}
+	if c.Kubernetes.NodePeers.Enabled && !c.Kubernetes.Annotate {
+		return ErrKubeNodePeersDeps
+	}
+	if c.Kubernetes.NodePeers.PodCIDRs && !c.Kubernetes.NodePeers.Enabled {
+		return ErrKubePodCIDRsDeps
+	}
+	if c.Kubernetes.NodeFinalizer && c.Kubernetes.NodeName == "" {
+		return ErrKubeFinalizerDeps
+	}
+	if c.Kubernetes.Annotate {
+		if !c.WireGuard.Enabled || c.Kubernetes.NodeName == "" {
+			return ErrKubeAnnotateDeps
+		}
+		prefix, ok := strings.CutSuffix(c.Kubernetes.AnnotationPrefix, "/")
+		if !ok || len(validation.IsDNS1123Subdomain(prefix)) > 0 {
+			return fmt.Errorf("%w: %q", ErrKubeAnnotPrefix, c.Kubernetes.AnnotationPrefix)
+		}
+	}
+	switch c.KeyStore.Type {
+	case "", KeyStoreFile, KeyStoreMemory:
+	case KeyStoreSecret:
+		if c.KeyStore.Secret.Namespace == "" || c.Kubernetes.NodeName == "" {
+			return ErrKeyStoreSecret
+		}
+	case KeyStoreVault:
+		vault := c.KeyStore.Vault
+		if vault.Address == "" || vault.Auth.Role == "" || c.Kubernetes.NodeName == "" {
+			return ErrKeyStoreVault
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrKeyStoreType, c.KeyStore.Type)
+	}
+	switch c.State.Type {
+	case "", StateMemory, StateFile:
+	case StateKubernetes:
+		if c.State.Namespace == "" || c.Kubernetes.NodeName == "" {
+			return ErrStateKubernetes
+		}
+	case StateCRD:
+		if c.Kubernetes.NodeName == "" {
+			return ErrStateCRD
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrStateType, c.State.Type)
+	}
+	if psk := c.Kubernetes.PresharedKeys; psk.Enabled {
+		// Secrets are only needed when the keystore doesn't keep the keys
+		needsNamespace := !c.KeyStore.Shared()
+		if !c.WireGuard.Enabled || (needsNamespace && psk.Namespace == "") || psk.PeerSelector == "" {
+			return ErrKubePSKDeps
+		}
+		if _, err := labels.Parse(psk.PeerSelector); err != nil {
+			return fmt.Errorf("%w: %w", ErrKubePSKDeps, err)
+		}
+	}
+	if c.Kubernetes.HostIP != "" {
+		if _, err := netip.ParseAddr(c.Kubernetes.HostIP); err != nil {
+			return fmt.Errorf("%w: %q", ErrKubeHostIP, c.Kubernetes.HostIP)
+		}
+	}
+	if c.Kubernetes.EndpointService != "" {
+		if _, _, ok := c.Kubernetes.EndpointServiceRef(); !ok || !c.WireGuard.Enabled {
+			return fmt.Errorf("%w: %q", ErrKubeEndpointSvc, c.Kubernetes.EndpointService)
+		}
+	}
+	for i := range c.NodeOverrides {
+		if err := c.NodeOverrides[i].validate(); err != nil {
+			return err
+		}
+	}
+	for i, peer := range c.WireGuard.Peers {
+		if peer.PublicKey == "" {
+			return fmt.Errorf("%w: peer %d", ErrPeerPublicKey, i)
+		}
+		if err := validatePrefixes(peer.ExcludedIPs, ErrExcludedIPs); err != nil {
+			return fmt.Errorf("peer %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func validatePrefixes(prefixes []string, sentinel error) error {
+	for _, prefix := range prefixes {
+		if _, err := netip.ParsePrefix(prefix); err != nil {
+			return fmt.Errorf("%w: %q", sentinel, prefix)
+		}
+	}
+	return nil
+}
+
+// ImportWGQuick merges a wg-quick config file into the WireGuard config.
+// Values already set win over the imported ones, and imported peers are
+// appended unless a peer with the same public key is already configured.
+func (w *WireGuard) ImportWGQuick(path string) error {
+	file, err := wgquick.Load(path)
+	if err != nil {
+		return fmt.Errorf("failed to import wg-quick config: %w", err)
+	}
+	w.ApplyWGQuick(file)
 	return nil
 }
 
+// ApplyWGQuick fills in what w leaves unset from a parsed wg-quick config
+// and adds its peers that w doesn't have yet.
+func (w *WireGuard) ApplyWGQuick(file *wgquick.File) {
+	if w.PrivateKey == "" {
+		w.PrivateKey = file.Interface.PrivateKey
+	}
+	if w.ListenPort == 0 {
+		w.ListenPort = file.Interface.ListenPort
+	}
+	if w.MTU == 0 {
+		w.MTU = file.Interface.MTU
+	}
+	if len(w.Addresses) == 0 {
+		w.Addresses = file.Interface.Addresses
+	}
+	if len(w.DNS) == 0 {
+		w.DNS = file.Interface.DNS
+	}
+
+	existing := make(map[string]struct{}, len(w.Peers))
+	for _, peer := range w.Peers {
+		existing[peer.PublicKey] = struct{}{}
+	}
+	for _, peer := range file.Peers {
+		if _, ok := existing[peer.PublicKey]; ok {
+			continue
+		}
+		w.Peers = append(w.Peers, WireGuardPeer{
+			PublicKey:           peer.PublicKey,
+			PresharedKey:        peer.PresharedKey,
+			Endpoint:            peer.Endpoint,
+			AllowedIPs:          peer.AllowedIPs,
+			PersistentKeepalive: peer.PersistentKeepalive,
+		})
+	}
+}
+
 //nolint:golint,gocyclo
 func LoadConfig(cmd *cobra.Command) (*Config, error) {
 	var config Config
 
-	// Load flags from envs
+	// Load the flags that aren't config keys from envs, the keys are set
+	// over the config files below
+	envPrefix, envUnprefixed, err := envSettings(cmd.Flags())
+	if err != nil {
+		return &config, err
+	}
+	keyNames := map[string]bool{}
+	for _, key := range envKeys(reflect.TypeFor[Config](), nil) {
+		keyNames[key.name()] = true
+	}
 	ctx, cancel := context.WithCancelCause(cmd.Context())
 	cmd.Flags().VisitAll(func(f *pflag.Flag) {
-		if ctx.Err() != nil {
+		optName := envName.Replace(strings.ToUpper(f.Name))
+		if ctx.Err() != nil || f.Changed || f.Name == EnvPrefixKey || f.Name == EnvUnprefixedKey || keyNames[optName] {
 			return
 		}
-		optName := strings.ReplaceAll(strings.ToUpper(f.Name), ".", "__")
-		if val, ok := os.LookupEnv(optName); !f.Changed && ok {
+		if variable, val, ok := lookupVariable(envPrefix, optName, envUnprefixed); ok {
 			if err := f.Value.Set(val); err != nil {
-				cancel(err)
+				cancel(fmt.Errorf("%s: %w", variable, err))
 			}
 			f.Changed = true
 		}
@@ -128,18 +1315,28 @@ func LoadConfig(cmd *cobra.Command) (*Config, error) {
 	if err != nil {
 		return &config, fmt.Errorf("failed to get config path: %w", err)
 	}
-	if configPath != "" {
-		data, err := os.ReadFile(configPath)
-		if errors.Is(err, os.ErrNotExist) && configPath == DefaultConfigName {
-			// We can ignore this error if the default config file is not found
-			return &config, nil
-		} else if err != nil {
-			return &config, fmt.Errorf("failed to read config: %w", err)
-		}
-
-		if err := yaml.Unmarshal(data, &config); err != nil {
-			return &config, fmt.Errorf("failed to unmarshal config: %w", err)
-		}
+	configDir, err := cmd.Flags().GetString(ConfigDirKey)
+	if err != nil {
+		return &config, fmt.Errorf("failed to get config directory: %w", err)
+	}
+	keyFile, err := cmd.Flags().GetString(ConfigKeyFileKey)
+	if err != nil {
+		return &config, fmt.Errorf("failed to get config key file: %w", err)
+	}
+	document, err := readConfig(cmd.Context(), configPath, configDir, keyFile)
+	if err != nil {
+		return &config, err
+	}
+	if document, err = applyEnv(document, lookupEnv(envPrefix, envUnprefixed)); err != nil {
+		return &config, fmt.Errorf("failed to load env: %w", err)
+	}
+	data, err := json.Marshal(document)
+	if err != nil {
+		return &config, fmt.Errorf("failed to load config: %w", err)
+	}
+	defer clear(data)
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return &config, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
 	// Flag overrides here
@@ -171,72 +1368,989 @@ func LoadConfig(cmd *cobra.Command) (*Config, error) {
 		}
 	}
 
-	if cmd.Flags().Changed(MetricsEnabledKey) {
-		config.Metrics.Enabled, err = cmd.Flags().GetBool(MetricsEnabledKey)
+	if cmd.Flags().Changed(PProfBlockRateKey) {
+		config.PProf.BlockProfileRate, err = cmd.Flags().GetInt(PProfBlockRateKey)
 		if err != nil {
-			return &config, fmt.Errorf("failed to get metrics enabled: %w", err)
+			return &config, fmt.Errorf("failed to get pprof block profile rate: %w", err)
 		}
 	}
 
-	if cmd.Flags().Changed(MetricsIPV4HostKey) {
-		config.Metrics.IPV4Host, err = cmd.Flags().GetString(MetricsIPV4HostKey)
+	if cmd.Flags().Changed(PProfMutexKey) {
+		config.PProf.MutexProfileFraction, err = cmd.Flags().GetInt(PProfMutexKey)
 		if err != nil {
-			return &config, fmt.Errorf("failed to get metrics IPv4 host: %w", err)
+			return &config, fmt.Errorf("failed to get pprof mutex profile fraction: %w", err)
 		}
 	}
 
-	if cmd.Flags().Changed(MetricsIPV6HostKey) {
-		config.Metrics.IPV6Host, err = cmd.Flags().GetString(MetricsIPV6HostKey)
+	if cmd.Flags().Changed(ProfilingKey) {
+		config.Profiling.Enabled, err = cmd.Flags().GetBool(ProfilingKey)
 		if err != nil {
-			return &config, fmt.Errorf("failed to get metrics IPv6 host: %w", err)
+			return &config, fmt.Errorf("failed to get profiling enabled: %w", err)
 		}
 	}
 
-	if cmd.Flags().Changed(MetricsPortKey) {
-		config.Metrics.Port, err = cmd.Flags().GetUint16(MetricsPortKey)
+	if cmd.Flags().Changed(ProfilingServerKey) {
+		config.Profiling.ServerAddress, err = cmd.Flags().GetString(ProfilingServerKey)
 		if err != nil {
-			return &config, fmt.Errorf("failed to get metrics port: %w", err)
+			return &config, fmt.Errorf("failed to get profiling server address: %w", err)
 		}
 	}
 
-	if cmd.Flags().Changed(TracingEnabledKey) {
-		config.Tracing.Enabled, err = cmd.Flags().GetBool(TracingEnabledKey)
+	if cmd.Flags().Changed(ProfilingIntKey) {
+		config.Profiling.Interval, err = getDuration(cmd.Flags(), ProfilingIntKey)
 		if err != nil {
-			return &config, fmt.Errorf("failed to get tracing enabled: %w", err)
+			return &config, fmt.Errorf("failed to get profiling interval: %w", err)
 		}
 	}
 
-	if cmd.Flags().Changed(TracingOTLPEndKey) {
-		config.Tracing.OTLPEndpoint, err = cmd.Flags().GetString(TracingOTLPEndKey)
+	if cmd.Flags().Changed(ProberKey) {
+		config.Prober.Enabled, err = cmd.Flags().GetBool(ProberKey)
 		if err != nil {
-			return &config, fmt.Errorf("failed to get tracing OTLP endpoint: %w", err)
+			return &config, fmt.Errorf("failed to get prober enabled: %w", err)
 		}
 	}
 
-	// Defaults
-	if config.Metrics.IPV4Host == "" {
-		config.Metrics.IPV4Host = DefaultMetricsIPV4Host
-	}
-	if config.Metrics.IPV6Host == "" {
-		config.Metrics.IPV6Host = DefaultMetricsIPV6Host
+	if cmd.Flags().Changed(ProberModeKey) {
+		config.Prober.Mode, err = cmd.Flags().GetString(ProberModeKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get prober mode: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(PrivSepKey) {
+		config.PrivilegeSeparation.Enabled, err = cmd.Flags().GetBool(PrivSepKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get privilege separation enabled: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(PrivSepAgentKey) {
+		config.PrivilegeSeparation.AgentSocket, err = cmd.Flags().GetString(PrivSepAgentKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get privilege separation agent socket: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(SandboxKey) {
+		config.Sandbox.Disabled, err = cmd.Flags().GetBool(SandboxKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get sandbox disabled: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(CryptoModeKey) {
+		config.CryptoPolicy.Mode, err = cmd.Flags().GetString(CryptoModeKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get crypto policy mode: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(FeatureGatesKey) {
+		gates, ok := cmd.Flags().Lookup(FeatureGatesKey).Value.(*FeatureGates)
+		if !ok {
+			return &config, fmt.Errorf("flag %s is not a set of feature gates", FeatureGatesKey)
+		}
+		if config.FeatureGates == nil {
+			config.FeatureGates = FeatureGates{}
+		}
+		for name, enabled := range *gates {
+			config.FeatureGates[name] = enabled
+		}
+	}
+
+	if cmd.Flags().Changed(WebhooksKey) {
+		config.Webhooks.Enabled, err = cmd.Flags().GetBool(WebhooksKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get webhooks enabled: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(WebhookURLsKey) {
+		config.Webhooks.URLs, err = cmd.Flags().GetStringSlice(WebhookURLsKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get webhook URLs: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(MetricsEnabledKey) {
+		config.Metrics.Enabled, err = cmd.Flags().GetBool(MetricsEnabledKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get metrics enabled: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(MetricsNamespaceKey) {
+		config.Metrics.Namespace, err = cmd.Flags().GetString(MetricsNamespaceKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get metrics namespace: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(RemoteWriteKey) {
+		config.Metrics.RemoteWrite.Enabled, err = cmd.Flags().GetBool(RemoteWriteKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get remote write enabled: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(RemoteWriteURLKey) {
+		config.Metrics.RemoteWrite.URL, err = cmd.Flags().GetString(RemoteWriteURLKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get remote write URL: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(MetricsIPV4HostKey) {
+		config.Metrics.IPV4Host, err = cmd.Flags().GetString(MetricsIPV4HostKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get metrics IPv4 host: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(MetricsIPV6HostKey) {
+		config.Metrics.IPV6Host, err = cmd.Flags().GetString(MetricsIPV6HostKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get metrics IPv6 host: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(MetricsPortKey) {
+		config.Metrics.Port, err = cmd.Flags().GetUint16(MetricsPortKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get metrics port: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(APIEnabledKey) {
+		config.API.Enabled, err = cmd.Flags().GetBool(APIEnabledKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get API enabled: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(APIIPV4HostKey) {
+		config.API.IPV4Host, err = cmd.Flags().GetString(APIIPV4HostKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get API IPv4 host: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(APIIPV6HostKey) {
+		config.API.IPV6Host, err = cmd.Flags().GetString(APIIPV6HostKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get API IPv6 host: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(APIPortKey) {
+		config.API.Port, err = cmd.Flags().GetUint16(APIPortKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get API port: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(APITLSCertKey) {
+		config.API.TLS.CertFile, err = cmd.Flags().GetString(APITLSCertKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get API TLS cert file: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(APITLSKeyKey) {
+		config.API.TLS.KeyFile, err = cmd.Flags().GetString(APITLSKeyKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get API TLS key file: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(APITLSClientCAKey) {
+		config.API.TLS.ClientCAFile, err = cmd.Flags().GetString(APITLSClientCAKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get API TLS client CA file: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(APIRateLimitKey) {
+		config.API.Limits.RateLimit, err = cmd.Flags().GetFloat64(APIRateLimitKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get API rate limit: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(APIBurstKey) {
+		config.API.Limits.Burst, err = cmd.Flags().GetInt(APIBurstKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get API burst: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(APIMaxConcKey) {
+		config.API.Limits.MaxConcurrent, err = cmd.Flags().GetInt(APIMaxConcKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get API max concurrent requests: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(APIMaxBodyKey) {
+		config.API.Limits.MaxBodyBytes, err = getByteSize(cmd.Flags(), APIMaxBodyKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get API max body size: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(APIShutdownKey) {
+		config.API.ShutdownTimeout, err = getDuration(cmd.Flags(), APIShutdownKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get API shutdown timeout: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(APIDrainKey) {
+		config.API.DrainDelay, err = getDuration(cmd.Flags(), APIDrainKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get API drain delay: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(KubeconfigKey) {
+		config.Kubernetes.Kubeconfig, err = cmd.Flags().GetString(KubeconfigKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get kubeconfig: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(KubeNodeNameKey) {
+		config.Kubernetes.NodeName, err = cmd.Flags().GetString(KubeNodeNameKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get kubernetes node name: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(KubePodNameKey) {
+		config.Kubernetes.PodName, err = cmd.Flags().GetString(KubePodNameKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get kubernetes pod name: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(KubePodNSKey) {
+		config.Kubernetes.PodNamespace, err = cmd.Flags().GetString(KubePodNSKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get kubernetes pod namespace: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(KubeHostIPKey) {
+		config.Kubernetes.HostIP, err = cmd.Flags().GetString(KubeHostIPKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get kubernetes host IP: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(KubeEventsKey) {
+		config.Kubernetes.Events, err = cmd.Flags().GetBool(KubeEventsKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get kubernetes events: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(KubeNetworkKey) {
+		config.Kubernetes.Network, err = cmd.Flags().GetString(KubeNetworkKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get kubernetes network: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(KubePeersKey) {
+		config.Kubernetes.Peers, err = cmd.Flags().GetBool(KubePeersKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get kubernetes peers: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(KubePeerStatusKey) {
+		config.Kubernetes.PeerStatus.Enabled, err = cmd.Flags().GetBool(KubePeerStatusKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get kubernetes peer status: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(KubeNodePeersKey) {
+		config.Kubernetes.NodePeers.Enabled, err = cmd.Flags().GetBool(KubeNodePeersKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get kubernetes node peers: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(KubePodCIDRsKey) {
+		config.Kubernetes.NodePeers.PodCIDRs, err = cmd.Flags().GetBool(KubePodCIDRsKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get kubernetes node peers pod CIDRs: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(KubeAnnotateKey) {
+		config.Kubernetes.Annotate, err = cmd.Flags().GetBool(KubeAnnotateKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get kubernetes annotate: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(KubeAnnotPrefixKey) {
+		config.Kubernetes.AnnotationPrefix, err = cmd.Flags().GetString(KubeAnnotPrefixKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get kubernetes annotation prefix: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(KubeEndpointSvcKey) {
+		config.Kubernetes.EndpointService, err = cmd.Flags().GetString(KubeEndpointSvcKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get kubernetes endpoint service: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(KubePSKKey) {
+		config.Kubernetes.PresharedKeys.Enabled, err = cmd.Flags().GetBool(KubePSKKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get kubernetes preshared keys: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(KeyStoreTypeKey) {
+		config.KeyStore.Type, err = cmd.Flags().GetString(KeyStoreTypeKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get keystore type: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(StateTypeKey) {
+		config.State.Type, err = cmd.Flags().GetString(StateTypeKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get state type: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(StatePathKey) {
+		config.State.Path, err = cmd.Flags().GetString(StatePathKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get state path: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(AuditEnabledKey) {
+		config.Audit.Enabled, err = cmd.Flags().GetBool(AuditEnabledKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get audit enabled: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(AuditPathKey) {
+		config.Audit.Path, err = cmd.Flags().GetString(AuditPathKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get audit path: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(FederationKey) {
+		config.Federation.Enabled, err = cmd.Flags().GetBool(FederationKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get federation enabled: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(FederationNameKey) {
+		config.Federation.ClusterName, err = cmd.Flags().GetString(FederationNameKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get federation cluster name: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(FederationIntKey) {
+		config.Federation.Interval, err = getDuration(cmd.Flags(), FederationIntKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get federation interval: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(EnrollEnabledKey) {
+		config.Enrollment.Enabled, err = cmd.Flags().GetBool(EnrollEnabledKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get enrollment enabled: %w", err)
+		}
 	}
-	if config.Metrics.Port == 0 {
-		config.Metrics.Port = DefaultMetricsPort
+
+	if cmd.Flags().Changed(EnrollTokenTTLKey) {
+		config.Enrollment.TokenTTL, err = getDuration(cmd.Flags(), EnrollTokenTTLKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get enrollment token TTL: %w", err)
+		}
 	}
-	if config.PProf.IPV4Host == "" {
-		config.PProf.IPV4Host = DefaultPprofIPV4Host
+
+	if cmd.Flags().Changed(EnrollLeaseKey) {
+		config.Enrollment.Lease.Enabled, err = cmd.Flags().GetBool(EnrollLeaseKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get enrollment lease enabled: %w", err)
+		}
 	}
-	if config.PProf.IPV6Host == "" {
-		config.PProf.IPV6Host = DefaultPprofIPV6Host
+
+	if cmd.Flags().Changed(EnrollLeaseTTLKey) {
+		config.Enrollment.Lease.TTL, err = getDuration(cmd.Flags(), EnrollLeaseTTLKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get enrollment lease TTL: %w", err)
+		}
 	}
-	if config.PProf.Port == 0 {
-		config.PProf.Port = DefaultPprofPort
+
+	if cmd.Flags().Changed(EnrollLeaseGraceKey) {
+		config.Enrollment.Lease.Grace, err = getDuration(cmd.Flags(), EnrollLeaseGraceKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get enrollment lease grace: %w", err)
+		}
 	}
 
-	err = config.Validate()
+	if cmd.Flags().Changed(EnrollLeaseMaxKey) {
+		config.Enrollment.Lease.MaxAge, err = getDuration(cmd.Flags(), EnrollLeaseMaxKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get enrollment lease max age: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(TracingEnabledKey) {
+		config.Tracing.Enabled, err = cmd.Flags().GetBool(TracingEnabledKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get tracing enabled: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(TracingOTLPEndKey) {
+		config.Tracing.OTLPEndpoint, err = cmd.Flags().GetString(TracingOTLPEndKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get tracing OTLP endpoint: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(TracingRedactionKey) {
+		config.Tracing.Redaction, err = cmd.Flags().GetString(TracingRedactionKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get tracing redaction: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(TracingSamplerKey) {
+		config.Tracing.Sampler.Type, err = cmd.Flags().GetString(TracingSamplerKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get tracing sampler: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(TracingRatioKey) {
+		config.Tracing.Sampler.Ratio, err = cmd.Flags().GetFloat64(TracingRatioKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get tracing sampling ratio: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(TracingPropagateKey) {
+		config.Tracing.Propagators, err = cmd.Flags().GetStringSlice(TracingPropagateKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get tracing propagators: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(ExporterEnabledKey) {
+		config.Exporter.Enabled, err = cmd.Flags().GetBool(ExporterEnabledKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get exporter enabled: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(ExporterIfaceKey) {
+		config.Exporter.Interface, err = cmd.Flags().GetString(ExporterIfaceKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get exporter interface: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(DNSProxyKey) {
+		config.DNSProxy.Enabled, err = cmd.Flags().GetBool(DNSProxyKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get dns proxy: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(ResolverServerKey) {
+		config.Resolver.Server, err = cmd.Flags().GetString(ResolverServerKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get resolver server: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(ResolverMinTTLKey) {
+		config.Resolver.MinTTL, err = getDuration(cmd.Flags(), ResolverMinTTLKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get resolver min TTL: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(ResolverMaxTTLKey) {
+		config.Resolver.MaxTTL, err = getDuration(cmd.Flags(), ResolverMaxTTLKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get resolver max TTL: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(ResolverNegTTLKey) {
+		config.Resolver.NegativeTTL, err = getDuration(cmd.Flags(), ResolverNegTTLKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get resolver negative TTL: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(ResolverStaleKey) {
+		config.Resolver.StaleTTL, err = getDuration(cmd.Flags(), ResolverStaleKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get resolver stale TTL: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(ResolverTimeoutKey) {
+		config.Resolver.Timeout, err = getDuration(cmd.Flags(), ResolverTimeoutKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get resolver timeout: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(WireGuardEnabledKey) {
+		config.WireGuard.Enabled, err = cmd.Flags().GetBool(WireGuardEnabledKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get wireguard enabled: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(WireGuardMTUKey) {
+		config.WireGuard.MTU, err = cmd.Flags().GetInt(WireGuardMTUKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get wireguard MTU: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(WireGuardPortKey) {
+		config.WireGuard.ListenPort, err = cmd.Flags().GetUint16(WireGuardPortKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get wireguard listen port: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(WireGuardIfaceKey) {
+		config.WireGuard.InterfaceName, err = cmd.Flags().GetString(WireGuardIfaceKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get wireguard interface name: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(WireGuardAdoptKey) {
+		config.WireGuard.Adopt, err = cmd.Flags().GetBool(WireGuardAdoptKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get wireguard adopt: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(WireGuardUserKey) {
+		config.WireGuard.Userspace, err = cmd.Flags().GetString(WireGuardUserKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get wireguard userspace: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(WireGuardEndKey) {
+		config.WireGuard.Endpoint, err = cmd.Flags().GetString(WireGuardEndKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get wireguard endpoint: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(WireGuardKeyFileKey) {
+		config.WireGuard.PrivateKeyFile, err = cmd.Flags().GetString(WireGuardKeyFileKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get wireguard private key file: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(WireGuardImportKey) {
+		config.WireGuard.ImportFile, err = cmd.Flags().GetString(WireGuardImportKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get wireguard import file: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(WireGuardResyncKey) {
+		config.WireGuard.ResyncInterval, err = getDuration(cmd.Flags(), WireGuardResyncKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get wireguard resync interval: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(WireGuardDetectKey) {
+		config.WireGuard.DetectOnly, err = cmd.Flags().GetBool(WireGuardDetectKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get wireguard detect only: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(WireGuardRotateKey) {
+		config.WireGuard.KeyRotation, err = cmd.Flags().GetUint32(WireGuardRotateKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get wireguard key rotation: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(WireGuardTableKey) {
+		config.WireGuard.Routing.Table, err = cmd.Flags().GetInt(WireGuardTableKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get wireguard routing table: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(WireGuardFwMarkKey) {
+		config.WireGuard.FwMark, err = cmd.Flags().GetUint32(WireGuardFwMarkKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get wireguard fwmark: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(ExitNodeKey) {
+		config.WireGuard.ExitNode.Enabled, err = cmd.Flags().GetBool(ExitNodeKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get wireguard exit node: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(ClampMSSKey) {
+		config.WireGuard.ClampMSS, err = cmd.Flags().GetBool(ClampMSSKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get wireguard clamp mss: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(EndpointSourcesKey) {
+		config.WireGuard.EndpointDetection.Sources, err = cmd.Flags().GetStringSlice(EndpointSourcesKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get wireguard endpoint sources: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(WireGuardSTUNKey) {
+		config.WireGuard.STUN.Enabled, err = cmd.Flags().GetBool(WireGuardSTUNKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get wireguard STUN enabled: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(PunchEnabledKey) {
+		config.WireGuard.HolePunching.Enabled, err = cmd.Flags().GetBool(PunchEnabledKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get hole punching enabled: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(PunchServeKey) {
+		config.WireGuard.HolePunching.Serve, err = cmd.Flags().GetBool(PunchServeKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get hole punching serve: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(PunchIntervalKey) {
+		config.WireGuard.HolePunching.Interval, err = getDuration(cmd.Flags(), PunchIntervalKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get hole punching interval: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(RelayFailoverKey) {
+		config.WireGuard.Relay.FailoverAfter, err = getDuration(cmd.Flags(), RelayFailoverKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get relay failover: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(RelayServeKey) {
+		config.WireGuard.Relay.Serve, err = cmd.Flags().GetBool(RelayServeKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get relay serve: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(HoldDownKey) {
+		config.WireGuard.HoldDown.Duration, err = getDuration(cmd.Flags(), HoldDownKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get hold-down duration: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(HoldDownFlapsKey) {
+		config.WireGuard.HoldDown.FlapThreshold, err = cmd.Flags().GetUint32(HoldDownFlapsKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get hold-down flap threshold: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(HoldDownWindowKey) {
+		config.WireGuard.HoldDown.FlapWindow, err = getDuration(cmd.Flags(), HoldDownWindowKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get hold-down flap window: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(PeerGCKey) {
+		config.WireGuard.PeerGC.Enabled, err = cmd.Flags().GetBool(PeerGCKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get peer GC: %w", err)
+		}
+	}
+
+	if cmd.Flags().Changed(PeerGCTTLKey) {
+		config.WireGuard.PeerGC.TTL, err = getDuration(cmd.Flags(), PeerGCTTLKey)
+		if err != nil {
+			return &config, fmt.Errorf("failed to get peer GC TTL: %w", err)
+		}
+	}
+
+	if err := config.Complete(); err != nil {
+		return &config, err
+	}
+
+	return &config, nil
+}
+
+// LoadFile reads a YAML config file and completes it with defaults, without
+// consulting flags or the environment.
+func LoadFile(path string) (*Config, error) {
+	var config Config
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return &config, fmt.Errorf("failed to read config: %w", err)
+	}
+	data, err = migrateDocument(data, path)
 	if err != nil {
-		return &config, fmt.Errorf("failed to validate config: %w", err)
+		return &config, fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return &config, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
+	if err := config.Complete(); err != nil {
+		return &config, err
+	}
 	return &config, nil
 }
+
+// Complete merges any imported wg-quick config, fills in defaults for unset
+// values and validates the result.
+//
+//nolint:golint,gocyclo
+func (c *Config) Complete() error {
+	for _, wg := range append([]*WireGuard{&c.WireGuard}, c.interfaces()...) {
+		if wg.ImportFile != "" {
+			if err := wg.ImportWGQuick(wg.ImportFile); err != nil {
+				return err
+			}
+		}
+	}
+
+	c.applyDefaults()
+
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("failed to validate config: %w", err)
+	}
+	return nil
+}
+
+// applyDefaults caps request bodies and lets a rate limited client send
+// at least one second's worth of requests at once.
+func (l *HTTPLimits) applyDefaults() {
+	if l.MaxBodyBytes == 0 {
+		l.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+	if l.RateLimit > 0 && l.Burst == 0 {
+		l.Burst = int(math.Ceil(l.RateLimit))
+	}
+}
+
+// applyDefaults fills in unset values.
+func (c *Config) applyDefaults() {
+	if c.Metrics.IPV4Host == "" {
+		c.Metrics.IPV4Host = DefaultMetricsIPV4Host
+	}
+	if c.Metrics.IPV6Host == "" {
+		c.Metrics.IPV6Host = DefaultMetricsIPV6Host
+	}
+	if c.Metrics.Port == 0 {
+		c.Metrics.Port = DefaultMetricsPort
+	}
+	if c.Metrics.RateInterval == 0 {
+		c.Metrics.RateInterval = Duration(DefaultRateInterval)
+	}
+	if c.PProf.IPV4Host == "" {
+		c.PProf.IPV4Host = DefaultPprofIPV4Host
+	}
+	if c.PProf.IPV6Host == "" {
+		c.PProf.IPV6Host = DefaultPprofIPV6Host
+	}
+	if c.PProf.Port == 0 {
+		c.PProf.Port = DefaultPprofPort
+	}
+	if c.PProf.MaxDelta == 0 {
+		c.PProf.MaxDelta = Duration(DefaultPprofMaxDelta)
+	}
+	if c.API.IPV4Host == "" {
+		c.API.IPV4Host = DefaultAPIIPV4Host
+	}
+	if c.API.IPV6Host == "" {
+		c.API.IPV6Host = DefaultAPIIPV6Host
+	}
+	if c.API.Port == 0 {
+		c.API.Port = DefaultAPIPort
+	}
+	if c.Profiling.ApplicationName == "" {
+		c.Profiling.ApplicationName = DefaultProfilingApp
+	}
+	if c.Profiling.Interval == 0 {
+		c.Profiling.Interval = Duration(DefaultProfilingInt)
+	}
+	c.Tracing.applyDefaults()
+	c.Prober.applyDefaults()
+	c.CryptoPolicy.applyDefaults()
+	c.Webhooks.applyDefaults()
+	c.SPIFFE.applyDefaults()
+	c.DNSProxy.applyDefaults()
+	c.Enrollment.OIDC.applyDefaults()
+	c.Metrics.RemoteWrite.applyDefaults()
+	c.PProf.Capture.applyDefaults()
+	for _, listener := range []*HTTPListener{&c.Metrics.HTTPListener, &c.PProf.HTTPListener, &c.API.HTTPListener} {
+		listener.Limits.applyDefaults()
+		if listener.ShutdownTimeout == 0 {
+			listener.ShutdownTimeout = Duration(DefaultShutdownTimeout)
+		}
+	}
+	if c.Enrollment.TokenTTL == 0 {
+		c.Enrollment.TokenTTL = Duration(DefaultEnrollTokenTTL)
+	}
+	if c.Enrollment.Lease.TTL == 0 {
+		c.Enrollment.Lease.TTL = Duration(DefaultLeaseTTL)
+	}
+	if c.Enrollment.Lease.Grace == 0 {
+		c.Enrollment.Lease.Grace = Duration(DefaultLeaseGrace)
+	}
+	if c.Federation.Interval == 0 {
+		c.Federation.Interval = Duration(DefaultFederationInt)
+	}
+	if c.Kubernetes.PeerStatus.Interval == 0 {
+		c.Kubernetes.PeerStatus.Interval = Duration(DefaultPeerStatusInt)
+	}
+	c.Kubernetes.applyDownwardAPI()
+	if c.KeyStore.Type == "" {
+		c.KeyStore.Type = KeyStoreFile
+	}
+	if c.State.Type == "" {
+		c.State.Type = StateMemory
+	}
+	if c.State.Path == "" {
+		c.State.Path = DefaultStatePath
+	}
+	if c.State.Namespace == "" {
+		c.State.Namespace = c.Kubernetes.PodNamespace
+	}
+	if c.KeyStore.Secret.Namespace == "" {
+		c.KeyStore.Secret.Namespace = c.Kubernetes.PodNamespace
+	}
+	if c.KeyStore.Vault.Auth.Mount == "" {
+		c.KeyStore.Vault.Auth.Mount = DefaultVaultAuthMount
+	}
+	if c.KeyStore.Vault.Auth.TokenFile == "" {
+		c.KeyStore.Vault.Auth.TokenFile = DefaultVaultTokenFile
+	}
+	if c.KeyStore.Vault.KV.Mount == "" {
+		c.KeyStore.Vault.KV.Mount = DefaultVaultKVMount
+	}
+	if c.KeyStore.Vault.KV.Path == "" {
+		c.KeyStore.Vault.KV.Path = DefaultVaultKVPath
+	}
+	if c.KeyStore.Vault.Transit.Mount == "" {
+		c.KeyStore.Vault.Transit.Mount = DefaultVaultTransit
+	}
+	if c.Kubernetes.PresharedKeys.Namespace == "" {
+		c.Kubernetes.PresharedKeys.Namespace = c.Kubernetes.PodNamespace
+	}
+	if c.Kubernetes.PresharedKeys.CacheTTL == 0 {
+		c.Kubernetes.PresharedKeys.CacheTTL = Duration(DefaultPSKCacheTTL)
+	}
+	if c.Kubernetes.AnnotationPrefix == "" {
+		c.Kubernetes.AnnotationPrefix = DefaultAnnotPrefix
+	}
+	if c.Exporter.Interface == "" {
+		c.Exporter.Interface = DefaultExporterIface
+	}
+	if c.Resolver.MinTTL == 0 {
+		c.Resolver.MinTTL = Duration(DefaultResolverMinTTL)
+	}
+	if c.Resolver.MaxTTL == 0 {
+		c.Resolver.MaxTTL = Duration(DefaultResolverMaxTTL)
+	}
+	if c.Resolver.NegativeTTL == 0 {
+		c.Resolver.NegativeTTL = Duration(DefaultResolverNegTTL)
+	}
+	if c.Resolver.StaleTTL == 0 {
+		c.Resolver.StaleTTL = Duration(DefaultResolverStale)
+	}
+	if c.Resolver.Timeout == 0 {
+		c.Resolver.Timeout = Duration(DefaultResolverTimeout)
+	}
+	if c.WireGuard.InterfaceName == "" {
+		c.WireGuard.InterfaceName = DefaultInterfaceName
+	}
+	if c.WireGuard.PrivateKeyFile == "" {
+		c.WireGuard.PrivateKeyFile = DefaultWireGuardKey
+	}
+	c.WireGuard.applyDefaults()
+	c.applyInterfaceDefaults()
+}
+
+// applyDefaults fills in the unset values every interface shares.
+func (w *WireGuard) applyDefaults() {
+	if w.ListenPortRange == (PortRange{}) {
+		w.ListenPortRange = PortRange{Min: DefaultWireGuardPort, Max: DefaultListenPortMax}
+	}
+	if w.ResyncInterval == 0 {
+		w.ResyncInterval = Duration(DefaultWireGuardResync)
+	}
+	if w.STUN.Enabled && len(w.STUN.Servers) == 0 {
+		w.STUN.Servers = DefaultSTUNServers
+	}
+	if w.HolePunching.Interval == 0 {
+		w.HolePunching.Interval = Duration(DefaultPunchInterval)
+	}
+	if w.Relay.FailoverAfter == 0 {
+		w.Relay.FailoverAfter = Duration(DefaultRelayFailover)
+	}
+	if w.HoldDown.Duration == 0 {
+		w.HoldDown.Duration = Duration(DefaultHoldDown)
+	}
+	if w.HoldDown.FlapThreshold == 0 {
+		w.HoldDown.FlapThreshold = DefaultHoldDownFlaps
+	}
+	if w.HoldDown.FlapWindow == 0 {
+		w.HoldDown.FlapWindow = Duration(DefaultHoldDownWindow)
+	}
+	if w.PeerGC.TTL == 0 {
+		w.PeerGC.TTL = Duration(DefaultPeerGCTTL)
+	}
+	if w.PeerGC.Interval == 0 {
+		w.PeerGC.Interval = Duration(DefaultPeerGCInterval)
+	}
+	if w.Routing.RulePriority == 0 {
+		w.Routing.RulePriority = DefaultRulePriority
+	}
+	w.ACL.applyDefaults()
+}
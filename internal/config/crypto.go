@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Crypto policy modes
+const (
+	// CryptoDefault leaves the choice of algorithms to Go
+	CryptoDefault = "default"
+	// CryptoFIPS limits the admin API to FIPS approved TLS versions, cipher
+	// suites and curves, and requires a BoringCrypto build
+	CryptoFIPS = "fips"
+)
+
+var ErrCryptoMode = errors.New("crypto_policy.mode must be default or fips")
+
+// CryptoPolicy selects the algorithms the admin API's TLS and token hashing
+// may use. WireGuard's own cryptography is fixed by the protocol and not
+// covered.
+type CryptoPolicy struct {
+	Mode string `json:"mode" jsonschema:"enum=default|fips"`
+}
+
+// FIPS reports whether the FIPS policy is in effect.
+func (p *CryptoPolicy) FIPS() bool {
+	return p.Mode == CryptoFIPS
+}
+
+func (p *CryptoPolicy) applyDefaults() {
+	if p.Mode == "" {
+		p.Mode = CryptoDefault
+	}
+}
+
+func (p *CryptoPolicy) validate() error {
+	switch p.Mode {
+	case "", CryptoDefault, CryptoFIPS:
+	default:
+		return fmt.Errorf("%w: %q", ErrCryptoMode, p.Mode)
+	}
+	return nil
+}
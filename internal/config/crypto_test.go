@@ -0,0 +1,43 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+func TestCryptoPolicyMode(t *testing.T) {
+	t.Parallel()
+
+	c := &config.Config{}
+	if err := c.Complete(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if c.CryptoPolicy.Mode != config.CryptoDefault {
+		t.Errorf("expected mode %q by default, got %q", config.CryptoDefault, c.CryptoPolicy.Mode)
+	}
+	c = &config.Config{CryptoPolicy: config.CryptoPolicy{Mode: "fips-140"}}
+	if err := c.Complete(); !errors.Is(err, config.ErrCryptoMode) {
+		t.Fatalf("expected %v, got %v", config.ErrCryptoMode, err)
+	}
+}
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	DefaultDNSProxyPort    = 53
+	DefaultDNSProxyTimeout = 5 * time.Second
+)
+
+var (
+	ErrDNSProxyDeps     = errors.New("dns_proxy requires wireguard and an interface address")
+	ErrDNSProxyUpstream = errors.New("dns_proxy.upstreams must be host or host:port")
+)
+
+// DNSProxy answers DNS queries on the interface's addresses by forwarding
+// them to the cluster DNS, so clients resolve names such as
+// *.svc.cluster.local. Client configs name the proxy as their DNS server
+// unless the interface or network sets one.
+type DNSProxy struct {
+	Enabled bool   `json:"enabled"`
+	Port    uint16 `json:"port"`
+	// Upstreams are the servers queries go to, the nameservers of
+	// /etc/resolv.conf when empty, which is the cluster DNS for pods with
+	// the ClusterFirstWithHostNet DNS policy
+	Upstreams []string `json:"upstreams"`
+	// Search domains handed to clients, e.g. svc.cluster.local
+	Search []string `json:"search"`
+	// Timeout of a forwarded query
+	Timeout Duration `json:"timeout"`
+}
+
+func (d *DNSProxy) applyDefaults() {
+	if d.Port == 0 {
+		d.Port = DefaultDNSProxyPort
+	}
+	if d.Timeout == 0 {
+		d.Timeout = Duration(DefaultDNSProxyTimeout)
+	}
+}
+
+func (d *DNSProxy) validate(wg *WireGuard) error {
+	if !d.Enabled {
+		return nil
+	}
+	if !wg.Enabled || len(wg.Addresses) == 0 {
+		return ErrDNSProxyDeps
+	}
+	for _, upstream := range d.Upstreams {
+		host := upstream
+		if h, _, err := net.SplitHostPort(upstream); err == nil {
+			host = h
+		}
+		if host == "" {
+			return fmt.Errorf("%w: %q", ErrDNSProxyUpstream, upstream)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/kubewg-net/container/internal/age"
+	"github.com/kubewg-net/container/internal/sops"
+)
+
+// The variables sops reads its keys from are read too, so one secret
+// serves both
+const (
+	SOPSAgeKeyEnv     = "SOPS_AGE_KEY"
+	SOPSAgeKeyFileEnv = "SOPS_AGE_KEY_FILE"
+	VaultTokenEnv     = "VAULT_TOKEN"
+)
+
+// decryptTimeout bounds asking Vault for the data key of a sops config
+const decryptTimeout = 30 * time.Second
+
+var ErrNoConfigKey = errors.New("the config is age encrypted, but no identity is set in config_key_file, SOPS_AGE_KEY_FILE or SOPS_AGE_KEY")
+
+// decryptConfig decrypts an age encrypted or sops config in memory, the
+// plaintext never touches the disk. Anything else is returned as it is.
+func decryptConfig(ctx context.Context, data []byte, keyFile string) ([]byte, error) {
+	encryptedAge := age.Encrypted(data)
+	if !encryptedAge && !sops.Encrypted(data) {
+		return data, nil
+	}
+
+	identities, err := ageIdentities(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if encryptedAge {
+		if len(identities) == 0 {
+			return nil, ErrNoConfigKey
+		}
+		return age.Decrypt(data, identities)
+	}
+	ctx, cancel := context.WithTimeout(ctx, decryptTimeout)
+	defer cancel()
+	return sops.Decrypt(ctx, data, sops.Keys{
+		Age:        identities,
+		VaultToken: os.Getenv(VaultTokenEnv),
+		Client:     &http.Client{Timeout: decryptTimeout},
+	})
+}
+
+// ageIdentities reads the identities from keyFile, SOPS_AGE_KEY_FILE and
+// SOPS_AGE_KEY, all of them that are set.
+func ageIdentities(keyFile string) ([]age.Identity, error) {
+	var identities []age.Identity
+	for _, path := range []string{keyFile, os.Getenv(SOPSAgeKeyFileEnv)} {
+		if path == "" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read age identities: %w", err)
+		}
+		parsed, err := age.ParseIdentities(data)
+		clear(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid age identities in %s: %w", path, err)
+		}
+		identities = append(identities, parsed...)
+	}
+	if inline := os.Getenv(SOPSAgeKeyEnv); inline != "" {
+		parsed, err := age.ParseIdentities([]byte(inline))
+		if err != nil {
+			return nil, fmt.Errorf("invalid age identities in %s: %w", SOPSAgeKeyEnv, err)
+		}
+		identities = append(identities, parsed...)
+	}
+	return identities, nil
+}
@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/spf13/cobra"
+)
+
+func TestLoadEncryptedConfigNeedsKey(t *testing.T) {
+	t.Parallel()
+	if os.Getenv(config.SOPSAgeKeyEnv) != "" || os.Getenv(config.SOPSAgeKeyFileEnv) != "" {
+		t.Skip("age identities are set in the environment")
+	}
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("age-encryption.org/v1\n-> X25519 share\nbody\n--- mac\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cmd := &cobra.Command{}
+	cmd.SetContext(context.Background())
+	config.RegisterFlags(cmd)
+	if err := cmd.Flags().Set(config.ConfigFileKey, path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.LoadConfig(cmd); !errors.Is(err, config.ErrNoConfigKey) {
+		t.Errorf("expected %v, got %v", config.ErrNoConfigKey, err)
+	}
+}
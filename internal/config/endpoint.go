@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Sources of the advertised endpoint
+const (
+	// EndpointSourceStatic is wireguard.endpoint as configured
+	EndpointSourceStatic = "static"
+	// EndpointSourceExternalIP is the node's ExternalIP
+	EndpointSourceExternalIP = "external_ip"
+	// EndpointSourceInternalIP is the node's InternalIP
+	EndpointSourceInternalIP = "internal_ip"
+	// EndpointSourceHostIP is kubernetes.host_ip, from the Downward API
+	EndpointSourceHostIP = "host_ip"
+	// EndpointSourceInterface is the first global address of
+	// endpoint_detection.interface
+	EndpointSourceInterface = "interface"
+	// EndpointSourceSTUN is the address STUN servers see
+	EndpointSourceSTUN = "stun"
+)
+
+var (
+	ErrEndpointSource    = errors.New("unknown wireguard.endpoint_detection.sources entry")
+	ErrEndpointInterface = errors.New("the interface endpoint source requires wireguard.endpoint_detection.interface")
+	ErrEndpointNode      = errors.New("the external_ip and internal_ip endpoint sources require kubernetes.node_name")
+)
+
+// EndpointDetection picks the endpoint advertised to peers and written
+// into client configs. A Service set by kubernetes.endpoint_service still
+// takes precedence.
+type EndpointDetection struct {
+	// Sources are tried in order until one yields an address. Empty keeps
+	// the static endpoint, falling back to STUN when enabled and then to
+	// the host IP
+	Sources []string `json:"sources"`
+	// Interface is read by the interface source, e.g. eth0
+	Interface string `json:"interface"`
+}
+
+// EndpointSources returns the endpoint sources in order of priority.
+func (w *WireGuard) EndpointSources() []string {
+	if len(w.EndpointDetection.Sources) > 0 {
+		return w.EndpointDetection.Sources
+	}
+	sources := []string{EndpointSourceStatic}
+	if w.STUN.Enabled {
+		sources = append(sources, EndpointSourceSTUN)
+	}
+	return append(sources, EndpointSourceHostIP)
+}
+
+// NeedsNodeAddresses reports whether the endpoint may come from the
+// node's addresses.
+func (c *Config) NeedsNodeAddresses() bool {
+	for _, source := range c.WireGuard.EndpointSources() {
+		if source == EndpointSourceExternalIP || source == EndpointSourceInternalIP {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *EndpointDetection) validate(nodeName string) error {
+	for _, source := range e.Sources {
+		switch source {
+		case EndpointSourceStatic, EndpointSourceHostIP, EndpointSourceSTUN:
+		case EndpointSourceExternalIP, EndpointSourceInternalIP:
+			if nodeName == "" {
+				return ErrEndpointNode
+			}
+		case EndpointSourceInterface:
+			if e.Interface == "" {
+				return ErrEndpointInterface
+			}
+		default:
+			return fmt.Errorf("%w: %q", ErrEndpointSource, source)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+func TestEndpointDetectionValidation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		detection config.EndpointDetection
+		nodeName  string
+		err       error
+	}{
+		{name: "defaults"},
+		{
+			name:      "node addresses",
+			detection: config.EndpointDetection{Sources: []string{"external_ip", "internal_ip"}},
+			nodeName:  "node-a",
+		},
+		{
+			name:      "node addresses without node name",
+			detection: config.EndpointDetection{Sources: []string{"external_ip"}},
+			err:       config.ErrEndpointNode,
+		},
+		{
+			name:      "interface without name",
+			detection: config.EndpointDetection{Sources: []string{"interface"}},
+			err:       config.ErrEndpointInterface,
+		},
+		{
+			name:      "unknown source",
+			detection: config.EndpointDetection{Sources: []string{"dns"}},
+			err:       config.ErrEndpointSource,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			c := &config.Config{
+				WireGuard:  config.WireGuard{EndpointDetection: tt.detection},
+				Kubernetes: config.Kubernetes{NodeName: tt.nodeName},
+			}
+			err := c.Complete()
+			if tt.err == nil && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestDefaultEndpointSources(t *testing.T) {
+	t.Parallel()
+
+	wg := &config.WireGuard{STUN: config.STUN{Enabled: true}}
+	want := []string{config.EndpointSourceStatic, config.EndpointSourceSTUN, config.EndpointSourceHostIP}
+	if got := wg.EndpointSources(); !slices.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
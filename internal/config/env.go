@@ -0,0 +1,263 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// DefaultEnvPrefix scopes the variables options are read from
+const DefaultEnvPrefix = "KUBEWG_"
+
+var ErrEnvValue = errors.New("invalid environment variable")
+
+// envName maps a flag or key to the variable it is read from after the
+// prefix, e.g. metrics.port to METRICS__PORT and config-dir to CONFIG_DIR
+//
+//nolint:golint,gochecknoglobals
+var envName = strings.NewReplacer(".", "__", "-", "_")
+
+// envKey is a config key that can be set from the environment.
+type envKey struct {
+	path []string
+	t    reflect.Type
+}
+
+// name returns the variable of the key after the prefix.
+func (k envKey) name() string {
+	return envName.Replace(strings.ToUpper(strings.Join(k.path, ".")))
+}
+
+// envKeys lists the keys of t that can be set from the environment:
+// scalars, and lists and maps of them. Lists of sections, such as
+// interfaces and peers, are left to the config file.
+func envKeys(t reflect.Type, path []string) []envKey {
+	var keys []envKey
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			keys = append(keys, envKeys(field.Type, path)...)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fieldPath := append(path[:len(path):len(path)], name)
+		switch kind := field.Type.Kind(); {
+		case field.Type == rawMessageType:
+		case envScalar(field.Type):
+			keys = append(keys, envKey{path: fieldPath, t: field.Type})
+		case kind == reflect.Struct:
+			keys = append(keys, envKeys(field.Type, fieldPath)...)
+		case kind == reflect.Slice && envScalar(field.Type.Elem()),
+			kind == reflect.Map && field.Type.Key().Kind() == reflect.String && envScalar(field.Type.Elem()):
+			keys = append(keys, envKey{path: fieldPath, t: field.Type})
+		}
+	}
+	return keys
+}
+
+func envScalar(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return t != rawMessageType
+	}
+	return false
+}
+
+// applyEnv sets the keys whose variables are set over the document, with
+// lookup returning the value of a key's variable. It returns the
+// document, created when it was nil.
+func applyEnv(document map[string]any, lookup func(envKey) (string, string, bool)) (map[string]any, error) {
+	if document == nil {
+		document = map[string]any{}
+	}
+	for _, key := range envKeys(reflect.TypeFor[Config](), nil) {
+		variable, raw, ok := lookup(key)
+		if !ok {
+			continue
+		}
+		value, err := envValue(key.t, raw)
+		if err != nil {
+			return nil, fmt.Errorf("%w %s: %w", ErrEnvValue, variable, err)
+		}
+		parent := document
+		for _, name := range key.path[:len(key.path)-1] {
+			child, ok := parent[name].(map[string]any)
+			if !ok {
+				child = map[string]any{}
+				parent[name] = child
+			}
+			parent = child
+		}
+		parent[key.path[len(key.path)-1]] = value
+	}
+	return document, nil
+}
+
+// envValue converts a variable to the value of a key of type t. Lists are
+// comma separated, maps are key=value pairs separated by commas, and both
+// may be written as JSON instead.
+func envValue(t reflect.Type, raw string) (any, error) {
+	trimmed := strings.TrimSpace(raw)
+	switch t.Kind() {
+	case reflect.Slice:
+		if strings.HasPrefix(trimmed, "[") {
+			return decodeEnvJSON(trimmed)
+		}
+		items := []any{}
+		if trimmed == "" {
+			return items, nil
+		}
+		for _, item := range strings.Split(raw, ",") {
+			value, err := envScalarValue(t.Elem(), strings.TrimSpace(item))
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+		}
+		return items, nil
+	case reflect.Map:
+		if strings.HasPrefix(trimmed, "{") {
+			return decodeEnvJSON(trimmed)
+		}
+		entries := map[string]any{}
+		if trimmed == "" {
+			return entries, nil
+		}
+		for _, pair := range strings.Split(raw, ",") {
+			name, item, ok := strings.Cut(pair, "=")
+			if !ok {
+				return nil, fmt.Errorf("expected key=value, got %q", pair)
+			}
+			value, err := envScalarValue(t.Elem(), strings.TrimSpace(item))
+			if err != nil {
+				return nil, err
+			}
+			entries[strings.TrimSpace(name)] = value
+		}
+		return entries, nil
+	}
+	return envScalarValue(t, raw)
+}
+
+func envScalarValue(t reflect.Type, raw string) (any, error) {
+	if t == durationType || t == byteSizeType {
+		// Both parse their own strings, bare numbers included
+		return raw, nil
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return raw, nil
+	case reflect.Bool:
+		return strconv.ParseBool(raw)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if _, err := strconv.ParseInt(raw, 10, t.Bits()); err != nil || !json.Valid([]byte(raw)) {
+			return nil, fmt.Errorf("invalid integer %q", raw)
+		}
+		return json.Number(raw), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if _, err := strconv.ParseUint(raw, 10, t.Bits()); err != nil || !json.Valid([]byte(raw)) {
+			return nil, fmt.Errorf("invalid integer %q", raw)
+		}
+		return json.Number(raw), nil
+	case reflect.Float32, reflect.Float64:
+		if _, err := strconv.ParseFloat(raw, t.Bits()); err != nil || !json.Valid([]byte(raw)) {
+			return nil, fmt.Errorf("invalid number %q", raw)
+		}
+		return json.Number(raw), nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
+func decodeEnvJSON(raw string) (any, error) {
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader([]byte(raw)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// lookupEnv returns a lookup reading the variables under prefix, and
+// their unprefixed names if unprefixed is set.
+func lookupEnv(prefix string, unprefixed bool) func(envKey) (string, string, bool) {
+	return func(key envKey) (string, string, bool) {
+		return lookupVariable(prefix, key.name(), unprefixed)
+	}
+}
+
+// lookupVariable reads prefix+name, falling back to the bare name when
+// unprefixed is set. The fallback keeps deployments written before the
+// prefix working, at the risk of picking up generic names like CONFIG
+// meant for other tools.
+func lookupVariable(prefix, name string, unprefixed bool) (string, string, bool) {
+	if value, ok := os.LookupEnv(prefix + name); ok {
+		return prefix + name, value, true
+	}
+	if !unprefixed || prefix == "" {
+		return "", "", false
+	}
+	value, ok := os.LookupEnv(name)
+	if ok {
+		slog.Warn("Reading an unprefixed environment variable, rename it", "variable", name, "replacement", prefix+name)
+	}
+	return name, value, ok
+}
+
+// envSettings returns the prefix and whether unprefixed names are read.
+// The prefix is only set by its flag, the compatibility switch may also
+// come from the environment under the prefix.
+func envSettings(flags *pflag.FlagSet) (string, bool, error) {
+	prefix, err := flags.GetString(EnvPrefixKey)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get env prefix: %w", err)
+	}
+	unprefixed, err := flags.GetBool(EnvUnprefixedKey)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get env compatibility: %w", err)
+	}
+	if flag := flags.Lookup(EnvUnprefixedKey); !flag.Changed {
+		variable := prefix + envName.Replace(strings.ToUpper(EnvUnprefixedKey))
+		if value, ok := os.LookupEnv(variable); ok {
+			if unprefixed, err = strconv.ParseBool(value); err != nil {
+				return "", false, fmt.Errorf("%w %s: %w", ErrEnvValue, variable, err)
+			}
+		}
+	}
+	return prefix, unprefixed, nil
+}
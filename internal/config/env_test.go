@@ -0,0 +1,211 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config_test
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/features"
+	"github.com/spf13/cobra"
+)
+
+//nolint:paralleltest // t.Setenv
+func TestEnv(t *testing.T) {
+	t.Setenv("KUBEWG_METRICS__PORT", "9100")
+	t.Setenv("KUBEWG_METRICS__ENABLED", "true")
+	t.Setenv("KUBEWG_METRICS__CONST_LABELS", "cluster=prod, zone=a")
+	t.Setenv("KUBEWG_WIREGUARD__ADDRESSES", "10.0.0.1/24, fd00::1/64")
+	t.Setenv("KUBEWG_WIREGUARD__HOLE_PUNCHING__RENDEZVOUS__URL", "https://rendezvous.example")
+	t.Setenv("KUBEWG_TRACING__PROPAGATORS", `["b3"]`)
+	t.Setenv("KUBEWG_API__SHUTDOWN_TIMEOUT", "1m")
+	t.Setenv("KUBEWG_FEATURE_GATES", "ACL=false")
+
+	c, err := config.LoadConfig(newLoadCommand(t, "", ""))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !c.Metrics.Enabled || c.Metrics.Port != 9100 {
+		t.Errorf("expected the metrics scalars from the env, got %+v", c.Metrics.HTTPListener)
+	}
+	if expected := map[string]string{"cluster": "prod", "zone": "a"}; !maps.Equal(c.Metrics.ConstLabels, expected) {
+		t.Errorf("expected %v, got %v", expected, c.Metrics.ConstLabels)
+	}
+	if expected := []string{"10.0.0.1/24", "fd00::1/64"}; !slices.Equal(c.WireGuard.Addresses, expected) {
+		t.Errorf("expected %v, got %v", expected, c.WireGuard.Addresses)
+	}
+	if c.WireGuard.HolePunching.Rendezvous.URL != "https://rendezvous.example" {
+		t.Errorf("expected the nested key from the env, got %q", c.WireGuard.HolePunching.Rendezvous.URL)
+	}
+	if !slices.Equal(c.Tracing.Propagators, []string{"b3"}) {
+		t.Errorf("expected the JSON list from the env, got %v", c.Tracing.Propagators)
+	}
+	if c.API.ShutdownTimeout.Std() != time.Minute {
+		t.Errorf("expected 1m, got %s", c.API.ShutdownTimeout)
+	}
+	if c.FeatureGates.Enabled(features.ACL) {
+		t.Error("expected the env to turn ACL off")
+	}
+}
+
+//nolint:paralleltest // t.Setenv
+func TestEnvPrefix(t *testing.T) {
+	t.Setenv("KUBEWG_METRICS__PORT", "9100")
+	t.Setenv("ACME_METRICS__PORT", "9200")
+	t.Setenv("ACME_CONFIG_DIR", t.TempDir())
+
+	cmd := &cobra.Command{}
+	cmd.SetContext(context.Background())
+	config.RegisterFlags(cmd)
+	for key, value := range map[string]string{config.ConfigFileKey: "", config.EnvPrefixKey: "ACME_"} {
+		if err := cmd.Flags().Set(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := config.LoadConfig(cmd)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if c.Metrics.Port != 9200 {
+		t.Errorf("expected the port under the configured prefix, got %d", c.Metrics.Port)
+	}
+	if dir, _ := cmd.Flags().GetString(config.ConfigDirKey); dir == "" {
+		t.Error("expected the flag to be read under the configured prefix")
+	}
+}
+
+//nolint:paralleltest // t.Setenv
+func TestEnvInvalid(t *testing.T) {
+	for name, value := range map[string]string{
+		"KUBEWG_METRICS__PORT":         "nine",
+		"KUBEWG_METRICS__ENABLED":      "maybe",
+		"KUBEWG_METRICS__CONST_LABELS": "cluster",
+		"KUBEWG_WIREGUARD__MTU":        "+1420",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			_, err := config.LoadConfig(newLoadCommand(t, "", ""))
+			if !errors.Is(err, config.ErrEnvValue) {
+				t.Errorf("expected %v, got %v", config.ErrEnvValue, err)
+			}
+		})
+	}
+}
+
+// TestPrecedence sets every layer on its own key: flags win over the env,
+// which wins over the file, which wins over the defaults.
+//
+//nolint:paralleltest // t.Setenv
+func TestPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "metrics:\n  namespace: file\n  port: 9000\napi:\n  port: 8000\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("KUBEWG_METRICS__PORT", "9100")
+	t.Setenv("KUBEWG_API__PORT", "8100")
+
+	cmd := newLoadCommand(t, path, "")
+	if err := cmd.Flags().Set(config.APIPortKey, "8200"); err != nil {
+		t.Fatal(err)
+	}
+	c, err := config.LoadConfig(cmd)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if c.API.Port != 8200 {
+		t.Errorf("expected the flag to win, got %d", c.API.Port)
+	}
+	if c.Metrics.Port != 9100 {
+		t.Errorf("expected the env to win over the file, got %d", c.Metrics.Port)
+	}
+	if c.Metrics.Namespace != "file" {
+		t.Errorf("expected the file to win over the default, got %q", c.Metrics.Namespace)
+	}
+	if c.Metrics.RateInterval.Std() != config.DefaultRateInterval {
+		t.Errorf("expected the default, got %s", c.Metrics.RateInterval)
+	}
+}
+
+//nolint:paralleltest // t.Setenv
+func TestEnvUnprefixed(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("METRICS__PORT", "9100")
+	t.Setenv("CONFIG", filepath.Join(dir, "elsewhere.yaml"))
+
+	newCommand := func(args ...string) *cobra.Command {
+		cmd := &cobra.Command{}
+		cmd.SetContext(context.Background())
+		config.RegisterFlags(cmd)
+		if err := cmd.ParseFlags(args); err != nil {
+			t.Fatal(err)
+		}
+		return cmd
+	}
+
+	cmd := newCommand()
+	c, err := config.LoadConfig(cmd)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if c.Metrics.Port != config.DefaultMetricsPort {
+		t.Errorf("expected METRICS__PORT to be ignored, got %d", c.Metrics.Port)
+	}
+	if path, _ := cmd.Flags().GetString(config.ConfigFileKey); path != config.DefaultConfigName {
+		t.Errorf("expected CONFIG to be ignored, got %q", path)
+	}
+
+	_, err = config.LoadConfig(newCommand("--" + config.EnvUnprefixedKey))
+	if err == nil {
+		t.Error("expected the config named by CONFIG to be read and missing")
+	}
+	t.Setenv("CONFIG", "")
+	c, err = config.LoadConfig(newCommand("--" + config.EnvUnprefixedKey))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if c.Metrics.Port != 9100 {
+		t.Errorf("expected METRICS__PORT to be read for compatibility, got %d", c.Metrics.Port)
+	}
+
+	t.Setenv("KUBEWG_ENV_UNPREFIXED", "true")
+	c, err = config.LoadConfig(newCommand())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if c.Metrics.Port != 9100 {
+		t.Errorf("expected the compatibility switch to be read from the env, got %d", c.Metrics.Port)
+	}
+	t.Setenv("KUBEWG_METRICS__PORT", "9200")
+	c, err = config.LoadConfig(newCommand())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if c.Metrics.Port != 9200 {
+		t.Errorf("expected the prefixed variable to win, got %d", c.Metrics.Port)
+	}
+}
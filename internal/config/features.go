@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kubewg-net/container/internal/features"
+)
+
+var ErrFeatureDisabled = errors.New("feature gate is disabled")
+
+// FeatureGates turns the features of the features registry on and off by
+// name, e.g. ACL: false. Unset gates keep the feature's default.
+type FeatureGates map[string]bool
+
+// Enabled reports whether the feature is on.
+func (g FeatureGates) Enabled(feature features.Feature) bool {
+	return features.Enabled(g, feature)
+}
+
+func (g FeatureGates) String() string {
+	return features.Format(g)
+}
+
+// Set merges gates written as Name=true,Other=false, so the flag may be
+// repeated.
+func (g *FeatureGates) Set(s string) error {
+	gates, err := features.Parse(s)
+	if err != nil {
+		return err
+	}
+	if *g == nil {
+		*g = FeatureGates{}
+	}
+	for name, enabled := range gates {
+		(*g)[name] = enabled
+	}
+	return nil
+}
+
+// Type names the flag value in usage.
+func (g *FeatureGates) Type() string {
+	return "mapStringBool"
+}
+
+// validateFeatures checks the gates and that the sections of disabled
+// features are off.
+func (c *Config) validateFeatures() error {
+	if err := features.Validate(c.FeatureGates); err != nil {
+		return err
+	}
+	for _, wg := range append([]*WireGuard{&c.WireGuard}, c.interfaces()...) {
+		if wg.ACL.Enabled && !c.FeatureGates.Enabled(features.ACL) {
+			return fmt.Errorf("%w: wireguard.acl needs %s", ErrFeatureDisabled, features.ACL)
+		}
+		if wg.HolePunching.Enabled && !c.FeatureGates.Enabled(features.NATTraversal) {
+			return fmt.Errorf("%w: wireguard.hole_punching needs %s", ErrFeatureDisabled, features.NATTraversal)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/features"
+)
+
+func TestFeatureGates(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("feature_gates:\n  ACL: false\n  NATTraversal: false\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cmd := newLoadCommand(t, path, "")
+	if err := cmd.Flags().Set(config.FeatureGatesKey, "NATTraversal=true"); err != nil {
+		t.Fatal(err)
+	}
+	c, err := config.LoadConfig(cmd)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if c.FeatureGates.Enabled(features.ACL) {
+		t.Error("expected the config to turn ACL off")
+	}
+	if !c.FeatureGates.Enabled(features.NATTraversal) {
+		t.Error("expected the flag to override the config")
+	}
+
+	c.WireGuard.ACL.Enabled = true
+	if err := c.Validate(); !errors.Is(err, config.ErrFeatureDisabled) {
+		t.Errorf("expected %v, got %v", config.ErrFeatureDisabled, err)
+	}
+
+	c.WireGuard.ACL.Enabled = false
+	c.FeatureGates["Teleport"] = true
+	if err := c.Validate(); !errors.Is(err, features.ErrUnknown) {
+		t.Errorf("expected %v, got %v", features.ErrUnknown, err)
+	}
+}
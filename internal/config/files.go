@@ -0,0 +1,185 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+var ErrConfigDocument = errors.New("config documents must be mappings")
+
+// readConfig reads the config file, then the YAML files in dir in lexical
+// order, and merges every document they hold into one, later documents
+// winning: maps are merged key by key, anything else is replaced. Hidden
+// files are skipped, which leaves out the bookkeeping of mounted
+// ConfigMaps. Environment references are expanded in each document
+// before it is merged. It returns nil when there is nothing to read, a
+// missing default config file included.
+func readConfig(ctx context.Context, path, dir, keyFile string) (map[string]any, error) {
+	var files []string
+	if path != "" {
+		_, err := os.Stat(path)
+		if err == nil || !errors.Is(err, os.ErrNotExist) || path != DefaultConfigName {
+			files = append(files, path)
+		}
+	}
+	if dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config directory: %w", err)
+		}
+		// ReadDir sorts by name already
+		for _, entry := range entries {
+			name := entry.Name()
+			ext := filepath.Ext(name)
+			if strings.HasPrefix(name, ".") || (ext != ".yaml" && ext != ".yml") {
+				continue
+			}
+			// Mounted ConfigMaps link their files, so follow links
+			info, err := os.Stat(filepath.Join(dir, name))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read config: %w", err)
+			}
+			if info.Mode().IsRegular() {
+				files = append(files, filepath.Join(dir, name))
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	var merged map[string]any
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config: %w", err)
+		}
+		plaintext, err := decryptConfig(ctx, data, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt config %s: %w", file, err)
+		}
+		var migrations []Migration
+		merged, migrations, err = mergeDocuments(merged, plaintext)
+		clear(data)
+		clear(plaintext)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal config %s: %w", file, err)
+		}
+		warnDeprecated(file, migrations)
+	}
+	return merged, nil
+}
+
+// mergeDocuments merges the YAML documents in data over merged in order,
+// moving their deprecated keys first. Numbers are kept as they were
+// written, so converting the result back doesn't turn large integers into
+// floats.
+func mergeDocuments(merged map[string]any, data []byte) (map[string]any, []Migration, error) {
+	var migrations []Migration
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+	for {
+		document, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return merged, migrations, nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+		converted, err := yaml.YAMLToJSON(document)
+		if err != nil {
+			return nil, nil, err
+		}
+		var value any
+		decoder := json.NewDecoder(bytes.NewReader(converted))
+		decoder.UseNumber()
+		if err := decoder.Decode(&value); err != nil {
+			return nil, nil, err
+		}
+		if value == nil {
+			continue
+		}
+		if value, err = substitute(value); err != nil {
+			return nil, nil, err
+		}
+		values, ok := value.(map[string]any)
+		if !ok {
+			return nil, nil, ErrConfigDocument
+		}
+		migrations = append(migrations, migrateValues(values)...)
+		if merged == nil {
+			merged = values
+			continue
+		}
+		mergeValues(merged, values)
+	}
+}
+
+// mergeValues merges src into dst, recursing into maps both have.
+func mergeValues(dst, src map[string]any) {
+	for key, value := range src {
+		srcMap, srcIsMap := value.(map[string]any)
+		dstMap, dstIsMap := dst[key].(map[string]any)
+		if srcIsMap && dstIsMap {
+			mergeValues(dstMap, srcMap)
+			continue
+		}
+		dst[key] = value
+	}
+}
+
+// migrateDocument moves the deprecated keys of a single YAML document and
+// returns it as JSON.
+func migrateDocument(data []byte, file string) ([]byte, error) {
+	converted, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(converted))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		return nil, err
+	}
+	migrations := migrateValues(values)
+	if len(migrations) == 0 {
+		return converted, nil
+	}
+	warnDeprecated(file, migrations)
+	return json.Marshal(values)
+}
+
+func warnDeprecated(file string, migrations []Migration) {
+	for _, migration := range migrations {
+		slog.Warn("Deprecated config key, run config migrate to update the file", "file", file, "key", migration.Key, "change", migration.String())
+	}
+}
@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/spf13/cobra"
+)
+
+func TestConfigDir(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.yaml": "wireguard:\n  interface_name: wg-base\n",
+		"conf.d/00-base.yaml": "metrics:\n  enabled: true\n  port: 9000\n" +
+			"wireguard:\n  addresses: [10.0.0.1/24, fd00::1/64]\n  listen_port: 51820\n",
+		"conf.d/10-metrics.yml":        "metrics:\n  port: 9100\n",
+		"conf.d/90-site-override.yaml": "wireguard:\n  addresses: [10.1.0.1/24]\n---\n# empty\n---\nmetrics:\n  namespace: site\n",
+		"conf.d/README.md":             "metrics:\n  port: 1\n",
+		"conf.d/..data.yaml":           "metrics:\n  port: 2\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := config.LoadConfig(newLoadCommand(t, filepath.Join(dir, "config.yaml"), filepath.Join(dir, "conf.d")))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !c.Metrics.Enabled || c.Metrics.Port != 9100 || c.Metrics.Namespace != "site" {
+		t.Errorf("expected the metrics sections to be merged, got %+v", c.Metrics)
+	}
+	if c.WireGuard.InterfaceName != "wg-base" || c.WireGuard.ListenPort != 51820 {
+		t.Errorf("expected the config file to be merged with the directory, got %+v", c.WireGuard)
+	}
+	if !slices.Equal(c.WireGuard.Addresses, []string{"10.1.0.1/24"}) {
+		t.Errorf("expected the later list to replace the earlier one, got %v", c.WireGuard.Addresses)
+	}
+}
+
+func newLoadCommand(t *testing.T, path, dir string) *cobra.Command {
+	t.Helper()
+	cmd := &cobra.Command{}
+	cmd.SetContext(context.Background())
+	config.RegisterFlags(cmd)
+	if err := cmd.Flags().Set(config.ConfigFileKey, path); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Flags().Set(config.ConfigDirKey, dir); err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// maxInterfaceName is IFNAMSIZ without the terminating NUL
+const maxInterfaceName = 15
+
+var (
+	ErrInterfaceName     = errors.New("every interface needs a unique interface_name")
+	ErrInterfaceNameForm = fmt.Errorf("interface_name must be at most %d characters without slashes or whitespace", maxInterfaceName)
+	ErrInterfacePort     = errors.New("interfaces can't share a listen port")
+	ErrInterfaceFeatures = errors.New("stun, endpoint detection, hole punching, relays and exit nodes are only supported on the main interface")
+)
+
+// interfaces returns pointers to the additional interfaces.
+func (c *Config) interfaces() []*WireGuard {
+	interfaces := make([]*WireGuard, len(c.Interfaces))
+	for i := range c.Interfaces {
+		interfaces[i] = &c.Interfaces[i]
+	}
+	return interfaces
+}
+
+// ForInterface returns a copy of c managing the additional interface i in
+// place of the main one, sharing everything else.
+func (c *Config) ForInterface(i int) *Config {
+	sub := *c
+	sub.WireGuard = c.Interfaces[i]
+	sub.Interfaces = nil
+	return &sub
+}
+
+// applyInterfaceDefaults fills in the additional interfaces. Listing one
+// enables it, and its private key file is named after it so it doesn't
+// share the main interface's key.
+func (c *Config) applyInterfaceDefaults() {
+	for _, wg := range c.interfaces() {
+		wg.Enabled = true
+		if wg.PrivateKeyFile == "" && wg.InterfaceName != "" {
+			wg.PrivateKeyFile = filepath.Join(filepath.Dir(DefaultWireGuardKey), wg.InterfaceName+".key")
+		}
+		wg.applyDefaults()
+	}
+}
+
+// validInterfaceName reports whether the kernel accepts name for a link.
+func validInterfaceName(name string) bool {
+	return len(name) <= maxInterfaceName && name != "." && name != ".." &&
+		!strings.ContainsAny(name, "/: \t\n")
+}
+
+// validateInterfaces checks the interface names and the additional
+// interfaces. Each has its own key, port, addresses, network and peers,
+// managed by its own reconciler. Finding and advertising an endpoint, and
+// everything else that involves the rest of the cluster, stays with the
+// main interface.
+func (c *Config) validateInterfaces() error {
+	if !validInterfaceName(c.WireGuard.InterfaceName) {
+		return fmt.Errorf("%w: %q", ErrInterfaceNameForm, c.WireGuard.InterfaceName)
+	}
+	names := map[string]bool{c.WireGuard.InterfaceName: true}
+	ports := map[uint16]bool{c.WireGuard.ListenPort: true}
+	for _, wg := range c.interfaces() {
+		if wg.InterfaceName == "" || names[wg.InterfaceName] {
+			return fmt.Errorf("%w: %q", ErrInterfaceName, wg.InterfaceName)
+		}
+		if !validInterfaceName(wg.InterfaceName) {
+			return fmt.Errorf("%w: %q", ErrInterfaceNameForm, wg.InterfaceName)
+		}
+		names[wg.InterfaceName] = true
+		if wg.ListenPort != 0 {
+			if ports[wg.ListenPort] {
+				return fmt.Errorf("%w: %s on %d", ErrInterfacePort, wg.InterfaceName, wg.ListenPort)
+			}
+			ports[wg.ListenPort] = true
+		}
+
+		if wg.STUN.Enabled || len(wg.EndpointDetection.Sources) > 0 || wg.HolePunching.Enabled || wg.HolePunching.Serve ||
+			wg.Relay.Serve || len(wg.Relay.Peers) > 0 || wg.ExitNode.Enabled {
+			return fmt.Errorf("%w: %s", ErrInterfaceFeatures, wg.InterfaceName)
+		}
+		if ports := wg.ListenPortRange; ports != (PortRange{}) && (ports.Min == 0 || ports.Min > ports.Max) {
+			return fmt.Errorf("%w: %s", ErrListenPortRange, wg.InterfaceName)
+		}
+		for _, mtu := range []int{wg.MTU, wg.Network.MTU} {
+			if mtu != 0 && (mtu < MinWireGuardMTU || mtu > MaxWireGuardMTU) {
+				return fmt.Errorf("%w: %s", ErrWireGuardMTU, wg.InterfaceName)
+			}
+		}
+		if err := wg.Routing.validate(wg.FwMark); err != nil {
+			return fmt.Errorf("interface %s: %w", wg.InterfaceName, err)
+		}
+		if err := wg.ACL.validate(); err != nil {
+			return fmt.Errorf("interface %s: %w", wg.InterfaceName, err)
+		}
+	}
+	return nil
+}
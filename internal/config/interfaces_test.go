@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/kubewg-net/container/internal/config"
+)
+
+const interfacesConfig = `
+wireguard:
+  enabled: true
+  listen_port: 51820
+interfaces:
+  - interface_name: wg-clients
+    listen_port: 51830
+    addresses: ['10.20.0.1/24']
+    peers:
+      - name: laptop
+        public_key: 'key'
+        allowed_ips: ['10.20.0.2/32']
+`
+
+func TestInterfaces(t *testing.T) {
+	t.Parallel()
+
+	var c config.Config
+	if err := yaml.Unmarshal([]byte(interfacesConfig), &c); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := c.Complete(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	wg := &c.Interfaces[0]
+	if !wg.Enabled || wg.PrivateKeyFile != "/var/lib/kubewg/wg-clients.key" || wg.ResyncInterval.Std() != config.DefaultWireGuardResync {
+		t.Errorf("expected the defaults of an enabled interface, got %+v", wg)
+	}
+
+	sub := c.ForInterface(0)
+	if sub.WireGuard.InterfaceName != "wg-clients" || len(sub.WireGuard.Peers) != 1 || len(sub.Interfaces) != 0 {
+		t.Errorf("expected wg-clients alone, got %+v", sub.WireGuard)
+	}
+	if c.WireGuard.InterfaceName != config.DefaultInterfaceName {
+		t.Errorf("expected the main interface to stay %s, got %s", config.DefaultInterfaceName, c.WireGuard.InterfaceName)
+	}
+}
+
+func TestInterfacesValidation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		extra config.WireGuard
+		err   error
+	}{
+		{name: "valid", extra: config.WireGuard{InterfaceName: "wg-clients", ListenPort: 51830}},
+		{name: "no name", extra: config.WireGuard{ListenPort: 51830}, err: config.ErrInterfaceName},
+		{name: "main name", extra: config.WireGuard{InterfaceName: "kubewg0"}, err: config.ErrInterfaceName},
+		{name: "long name", extra: config.WireGuard{InterfaceName: "wg-clients-overflow"}, err: config.ErrInterfaceNameForm},
+		{name: "slash", extra: config.WireGuard{InterfaceName: "wg/clients"}, err: config.ErrInterfaceNameForm},
+		{name: "main port", extra: config.WireGuard{InterfaceName: "wg-clients", ListenPort: 51820}, err: config.ErrInterfacePort},
+		{
+			name:  "exit node",
+			extra: config.WireGuard{InterfaceName: "wg-clients", ExitNode: config.ExitNode{Enabled: true}},
+			err:   config.ErrInterfaceFeatures,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			c := &config.Config{
+				WireGuard:  config.WireGuard{InterfaceName: "kubewg0", ListenPort: 51820},
+				Interfaces: []config.WireGuard{tt.extra},
+			}
+			if err := c.Validate(); !errors.Is(err, tt.err) {
+				t.Errorf("expected error %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config_test
+
+import (
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+//nolint:paralleltest // t.Setenv
+func TestDownwardAPI(t *testing.T) {
+	t.Setenv(config.EnvNodeName, "node-a")
+	t.Setenv(config.EnvPodName, "kubewg-x7k2p")
+	t.Setenv(config.EnvPodNamespace, "kube-system")
+	t.Setenv(config.EnvHostIP, "10.1.0.5")
+
+	c := &config.Config{
+		Kubernetes: config.Kubernetes{
+			PodName:         "from-config",
+			EndpointService: "kubewg-lb",
+		},
+		WireGuard: config.WireGuard{Enabled: true},
+	}
+	if err := c.Complete(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if c.Kubernetes.NodeName != "node-a" {
+		t.Errorf("expected node name node-a, got %q", c.Kubernetes.NodeName)
+	}
+	if c.Kubernetes.PodName != "from-config" {
+		t.Errorf("expected the configured pod name to win, got %q", c.Kubernetes.PodName)
+	}
+	if c.Kubernetes.HostIP != "10.1.0.5" {
+		t.Errorf("expected host IP 10.1.0.5, got %q", c.Kubernetes.HostIP)
+	}
+	namespace, name, ok := c.Kubernetes.EndpointServiceRef()
+	if !ok || namespace != "kube-system" || name != "kubewg-lb" {
+		t.Errorf("expected kube-system/kubewg-lb, got %s/%s (%v)", namespace, name, ok)
+	}
+}
@@ -0,0 +1,343 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kubewg-net/container/internal/age"
+	"github.com/kubewg-net/container/internal/sops"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+var ErrMigrateEncrypted = errors.New("encrypted configs can't be migrated, decrypt the file first")
+
+// Deprecation is a config key that was renamed or removed. Configs still
+// using it load with a warning, and config migrate rewrites them.
+type Deprecation struct {
+	// Key is the dotted path of the old key, * standing for any list item
+	// or map value, e.g. interfaces.*.mtu
+	Key string
+	// Replacement is the path the value moves to. It shares the
+	// wildcards of Key, which may only appear before the two part ways.
+	// Empty when the key was removed
+	Replacement string
+	// Note tells what to do instead when there is more to it than a
+	// rename
+	Note string
+}
+
+// deprecations are applied in order, so a key renamed twice needs only its
+// latest entry to have a chain ending in the current name.
+//
+//nolint:golint,gochecknoglobals
+var deprecations = []Deprecation{
+	{Key: "pprof.max_delta_seconds", Replacement: "pprof.max_delta"},
+}
+
+// Deprecations returns the registered deprecated keys.
+func Deprecations() []Deprecation {
+	return slices.Clone(deprecations)
+}
+
+// paths splits the keys into the part they share and what follows.
+func (d *Deprecation) paths() ([]string, []string, []string) {
+	from := strings.Split(d.Key, ".")
+	var to []string
+	if d.Replacement != "" {
+		to = strings.Split(d.Replacement, ".")
+	}
+	shared := 0
+	for shared < len(from)-1 && shared < len(to)-1 && from[shared] == to[shared] {
+		shared++
+	}
+	if to == nil {
+		shared = len(from) - 1
+	}
+	return from[:shared], from[shared:], to[min(shared, len(to)):]
+}
+
+// Migration is a deprecated key found in a config.
+type Migration struct {
+	// Key and Replacement are the paths in this config, list items
+	// numbered from 0
+	Key         string
+	Replacement string
+	Note        string
+	// Dropped is set when the value was discarded, because the key was
+	// removed or the config sets the replacement too
+	Dropped bool
+}
+
+func (m Migration) String() string {
+	var s string
+	switch {
+	case m.Replacement == "":
+		s = m.Key + " was removed"
+	case m.Dropped:
+		s = fmt.Sprintf("%s is replaced by %s, which is set too", m.Key, m.Replacement)
+	default:
+		s = fmt.Sprintf("%s is renamed to %s", m.Key, m.Replacement)
+	}
+	if m.Note != "" {
+		s += ": " + m.Note
+	}
+	return s
+}
+
+// migrateValues moves the deprecated keys of a decoded document in place.
+func migrateValues(document map[string]any) []Migration {
+	var migrations []Migration
+	for _, deprecation := range deprecations {
+		prefix, from, to := deprecation.paths()
+		walkValues(document, prefix, nil, func(parent map[string]any, path []string) {
+			value, ok := takeValue(parent, from)
+			if !ok {
+				return
+			}
+			migration := Migration{Key: joinPath(path, from), Note: deprecation.Note, Dropped: true}
+			if to != nil {
+				migration.Replacement = joinPath(path, to)
+				migration.Dropped = !putValue(parent, to, value)
+			}
+			migrations = append(migrations, migration)
+		})
+	}
+	return migrations
+}
+
+func walkValues(value any, prefix, path []string, fn func(map[string]any, []string)) {
+	if len(prefix) == 0 {
+		if parent, ok := value.(map[string]any); ok {
+			fn(parent, path)
+		}
+		return
+	}
+	switch value := value.(type) {
+	case map[string]any:
+		if prefix[0] != "*" {
+			if child, ok := value[prefix[0]]; ok {
+				walkValues(child, prefix[1:], append(path, prefix[0]), fn)
+			}
+			return
+		}
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			walkValues(value[key], prefix[1:], append(path, key), fn)
+		}
+	case []any:
+		if prefix[0] != "*" {
+			return
+		}
+		for i, item := range value {
+			walkValues(item, prefix[1:], append(path, strconv.Itoa(i)), fn)
+		}
+	}
+}
+
+func takeValue(parent map[string]any, path []string) (any, bool) {
+	for _, key := range path[:len(path)-1] {
+		child, ok := parent[key].(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		parent = child
+	}
+	value, ok := parent[path[len(path)-1]]
+	delete(parent, path[len(path)-1])
+	return value, ok
+}
+
+// putValue sets the value unless the path is set already.
+func putValue(parent map[string]any, path []string, value any) bool {
+	for _, key := range path[:len(path)-1] {
+		child, ok := parent[key]
+		if !ok {
+			child = map[string]any{}
+			parent[key] = child
+		}
+		if parent, ok = child.(map[string]any); !ok {
+			return false
+		}
+	}
+	if _, ok := parent[path[len(path)-1]]; ok {
+		return false
+	}
+	parent[path[len(path)-1]] = value
+	return true
+}
+
+// MigrateFile rewrites the deprecated keys of a YAML config file, keeping
+// its comments and the order of its keys. Every document of the file is
+// migrated. It returns the data unchanged when nothing was deprecated.
+func MigrateFile(data []byte) ([]byte, []Migration, error) {
+	if age.Encrypted(data) || sops.Encrypted(data) {
+		return nil, nil, ErrMigrateEncrypted
+	}
+
+	var documents []*yamlv3.Node
+	var migrations []Migration
+	decoder := yamlv3.NewDecoder(bytes.NewReader(data))
+	for {
+		var document yamlv3.Node
+		if err := decoder.Decode(&document); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse config: %w", err)
+		}
+		documents = append(documents, &document)
+		if len(document.Content) > 0 {
+			migrations = append(migrations, migrateNodes(document.Content[0])...)
+		}
+	}
+	if len(migrations) == 0 {
+		return data, nil, nil
+	}
+
+	var buf bytes.Buffer
+	encoder := yamlv3.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	for _, document := range documents {
+		if err := encoder.Encode(document); err != nil {
+			return nil, nil, fmt.Errorf("failed to write config: %w", err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, nil, fmt.Errorf("failed to write config: %w", err)
+	}
+	return buf.Bytes(), migrations, nil
+}
+
+// migrateNodes is migrateValues for a YAML node tree. A renamed key keeps
+// its place when it stays in the same mapping.
+func migrateNodes(root *yamlv3.Node) []Migration {
+	var migrations []Migration
+	for _, deprecation := range deprecations {
+		prefix, from, to := deprecation.paths()
+		walkNodes(root, prefix, nil, func(parent *yamlv3.Node, path []string) {
+			key, value, index, ok := takeNode(parent, from)
+			if !ok {
+				return
+			}
+			migration := Migration{Key: joinPath(path, from), Note: deprecation.Note, Dropped: true}
+			if to != nil {
+				if !slices.Equal(from[:len(from)-1], to[:len(to)-1]) {
+					index = -1
+				}
+				migration.Replacement = joinPath(path, to)
+				migration.Dropped = !putNode(parent, to, key, value, index)
+			}
+			migrations = append(migrations, migration)
+		})
+	}
+	return migrations
+}
+
+func walkNodes(node *yamlv3.Node, prefix, path []string, fn func(*yamlv3.Node, []string)) {
+	if len(prefix) == 0 {
+		if node.Kind == yamlv3.MappingNode {
+			fn(node, path)
+		}
+		return
+	}
+	switch node.Kind {
+	case yamlv3.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if key := node.Content[i].Value; prefix[0] == "*" || key == prefix[0] {
+				walkNodes(node.Content[i+1], prefix[1:], append(path, key), fn)
+			}
+		}
+	case yamlv3.SequenceNode:
+		if prefix[0] != "*" {
+			return
+		}
+		for i, item := range node.Content {
+			walkNodes(item, prefix[1:], append(path, strconv.Itoa(i)), fn)
+		}
+	}
+}
+
+// mappingIndex returns the index of the key in a mapping node, or -1.
+func mappingIndex(node *yamlv3.Node, key string) int {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func takeNode(parent *yamlv3.Node, path []string) (*yamlv3.Node, *yamlv3.Node, int, bool) {
+	for _, key := range path[:len(path)-1] {
+		i := mappingIndex(parent, key)
+		if i < 0 || parent.Content[i+1].Kind != yamlv3.MappingNode {
+			return nil, nil, 0, false
+		}
+		parent = parent.Content[i+1]
+	}
+	i := mappingIndex(parent, path[len(path)-1])
+	if i < 0 {
+		return nil, nil, 0, false
+	}
+	key, value := parent.Content[i], parent.Content[i+1]
+	parent.Content = slices.Delete(parent.Content, i, i+2)
+	return key, value, i, true
+}
+
+// putNode sets the value unless the path is set already, at index of its
+// mapping or at its end when index is -1.
+func putNode(parent *yamlv3.Node, path []string, key, value *yamlv3.Node, index int) bool {
+	for _, name := range path[:len(path)-1] {
+		i := mappingIndex(parent, name)
+		if i < 0 {
+			parent.Content = append(parent.Content,
+				&yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: name},
+				&yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"})
+			i = len(parent.Content) - 2
+		}
+		if parent = parent.Content[i+1]; parent.Kind != yamlv3.MappingNode {
+			return false
+		}
+	}
+	if mappingIndex(parent, path[len(path)-1]) >= 0 {
+		return false
+	}
+	key.Value = path[len(path)-1]
+	if index < 0 || index > len(parent.Content) {
+		index = len(parent.Content)
+	}
+	parent.Content = slices.Insert(parent.Content, index, key, value)
+	return true
+}
+
+func joinPath(path, key []string) string {
+	return strings.Join(append(slices.Clone(path), key...), ".")
+}
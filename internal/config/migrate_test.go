@@ -0,0 +1,124 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+func TestMigrateFile(t *testing.T) {
+	t.Parallel()
+
+	data := "# pprof server\npprof:\n  enabled: true\n  max_delta_seconds: 120 # two minutes\n  port: 6060\n" +
+		"---\npprof:\n  max_delta_seconds: 60\n  max_delta: 30s\n"
+	migrated, migrations, err := config.MigrateFile([]byte(data))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := "# pprof server\npprof:\n  enabled: true\n  max_delta: 120 # two minutes\n  port: 6060\n" +
+		"---\npprof:\n  max_delta: 30s\n"
+	if string(migrated) != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, migrated)
+	}
+	if len(migrations) != 2 || migrations[0].Dropped || !migrations[1].Dropped {
+		t.Errorf("expected a rename and a dropped key, got %v", migrations)
+	}
+
+	current := []byte("pprof:\n  max_delta: 5m\n")
+	migrated, migrations, err = config.MigrateFile(current)
+	if err != nil || len(migrations) != 0 || string(migrated) != string(current) {
+		t.Errorf("expected a current config to stay as it is, got %q, %v, %v", migrated, migrations, err)
+	}
+
+	_, _, err = config.MigrateFile([]byte("age-encryption.org/v1\n-> X25519 abc\n"))
+	if !errors.Is(err, config.ErrMigrateEncrypted) {
+		t.Errorf("expected %v, got %v", config.ErrMigrateEncrypted, err)
+	}
+}
+
+func TestLoadDeprecated(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("pprof:\n  max_delta_seconds: 120\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := config.LoadConfig(newLoadCommand(t, path, ""))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if c.PProf.MaxDelta.Std() != 2*time.Minute {
+		t.Errorf("expected the deprecated key to be read, got %s", c.PProf.MaxDelta)
+	}
+
+	c, err = config.LoadFile(path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if c.PProf.MaxDelta.Std() != 2*time.Minute {
+		t.Errorf("expected LoadFile to read the deprecated key, got %s", c.PProf.MaxDelta)
+	}
+}
+
+// TestDeprecations checks the registry against the schema: replacements
+// must exist and deprecated keys must be gone.
+func TestDeprecations(t *testing.T) {
+	t.Parallel()
+
+	schema := roundTrip(t, config.Schema())
+	for _, deprecation := range config.Deprecations() {
+		if lookup(schema, deprecation.Key) {
+			t.Errorf("expected %s to be gone from the config", deprecation.Key)
+		}
+		if deprecation.Replacement != "" && !lookup(schema, deprecation.Replacement) {
+			t.Errorf("expected %s to be a config key", deprecation.Replacement)
+		}
+	}
+}
+
+func lookup(schema map[string]any, key string) bool {
+	for _, name := range strings.Split(key, ".") {
+		if name == "*" {
+			items, ok := schema["items"].(map[string]any)
+			if !ok {
+				items, ok = schema["additionalProperties"].(map[string]any)
+			}
+			if !ok {
+				return false
+			}
+			schema = items
+			continue
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		child, ok := properties[name].(map[string]any)
+		if !ok {
+			return false
+		}
+		schema = child
+	}
+	return true
+}
@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Network holds the defaults shared by every member of a WireGuard network.
+// Interface and peer settings override them when set.
+type Network struct {
+	Name                string   `json:"name"`
+	Topology            string   `json:"topology" jsonschema:"enum=FullMesh|HubSpoke"`
+	HubSelector         string   `json:"hub_selector"`
+	TunnelCIDR          string   `json:"tunnel_cidr"`
+	TunnelCIDRs         []string `json:"tunnel_cidrs"`
+	MTU                 int      `json:"mtu"`
+	PersistentKeepalive uint32   `json:"persistent_keepalive"`
+	DNS                 []string `json:"dns"`
+	KeyRotation         uint32   `json:"key_rotation"`
+}
+
+// Network topologies
+const (
+	// TopologyFullMesh peers every member with every other member
+	TopologyFullMesh = "FullMesh"
+	// TopologyHubSpoke peers spokes only with the hubs picked by the hub
+	// selector, hubs still peer with everyone
+	TopologyHubSpoke = "HubSpoke"
+)
+
+// Sources of an effective setting
+const (
+	SourceDefault   = "default"
+	SourceNetwork   = "network"
+	SourceInterface = "interface"
+	SourcePeer      = "peer"
+)
+
+// Setting is the effective value of an inherited setting and where it came
+// from.
+type Setting[T any] struct {
+	Value  T      `json:"value"`
+	Source string `json:"source"`
+}
+
+// EffectiveMTU returns the interface MTU, 0 meaning it is detected from the
+// underlay.
+func (w *WireGuard) EffectiveMTU() Setting[int] {
+	switch {
+	case w.MTU != 0:
+		return Setting[int]{Value: w.MTU, Source: SourceInterface}
+	case w.Network.MTU != 0:
+		return Setting[int]{Value: w.Network.MTU, Source: SourceNetwork}
+	}
+	return Setting[int]{Source: SourceDefault}
+}
+
+// EffectiveDNS returns the DNS servers handed to clients of this interface.
+func (w *WireGuard) EffectiveDNS() Setting[[]string] {
+	switch {
+	case len(w.DNS) > 0:
+		return Setting[[]string]{Value: w.DNS, Source: SourceInterface}
+	case len(w.Network.DNS) > 0:
+		return Setting[[]string]{Value: w.Network.DNS, Source: SourceNetwork}
+	}
+	return Setting[[]string]{Value: []string{}, Source: SourceDefault}
+}
+
+// EffectiveKeyRotation returns the maximum age in seconds of the interface
+// private key, 0 meaning it is never rotated.
+func (w *WireGuard) EffectiveKeyRotation() Setting[uint32] {
+	switch {
+	case w.KeyRotation != 0:
+		return Setting[uint32]{Value: w.KeyRotation, Source: SourceInterface}
+	case w.Network.KeyRotation != 0:
+		return Setting[uint32]{Value: w.Network.KeyRotation, Source: SourceNetwork}
+	}
+	return Setting[uint32]{Source: SourceDefault}
+}
+
+// EffectiveKeepalive returns the persistent keepalive in seconds used for
+// peer, 0 meaning keepalives are disabled.
+func (w *WireGuard) EffectiveKeepalive(peer *WireGuardPeer) Setting[uint32] {
+	switch {
+	case peer.PersistentKeepalive != 0:
+		return Setting[uint32]{Value: peer.PersistentKeepalive, Source: SourcePeer}
+	case w.Network.PersistentKeepalive != 0:
+		return Setting[uint32]{Value: w.Network.PersistentKeepalive, Source: SourceNetwork}
+	}
+	return Setting[uint32]{Source: SourceDefault}
+}
+
+// EffectiveTopology returns the network topology, full mesh unless set.
+func (w *WireGuard) EffectiveTopology() string {
+	if w.Network.Topology == "" {
+		return TopologyFullMesh
+	}
+	return w.Network.Topology
+}
+
+// IsHub reports whether this interface is a hub of a hub-and-spoke network,
+// i.e. its labels match the hub selector. In a full mesh every member is
+// treated as a hub.
+func (w *WireGuard) IsHub() bool {
+	if w.EffectiveTopology() != TopologyHubSpoke {
+		return true
+	}
+	selector, err := labels.Parse(w.Network.HubSelector)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(w.Labels))
+}
+
+// TopologyPeers returns the peers this interface should be configured with
+// under the network topology. Spokes only keep the peers whose metadata
+// matches the hub selector.
+func (w *WireGuard) TopologyPeers(peers []WireGuardPeer) []WireGuardPeer {
+	if w.IsHub() {
+		return peers
+	}
+	selector, err := labels.Parse(w.Network.HubSelector)
+	if err != nil {
+		return nil
+	}
+
+	hubs := make([]WireGuardPeer, 0, len(peers))
+	for _, peer := range peers {
+		if selector.Matches(labels.Set(peer.Metadata)) {
+			hubs = append(hubs, peer)
+		}
+	}
+	return hubs
+}
@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config_test
+
+import (
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+func TestNetworkInheritance(t *testing.T) {
+	t.Parallel()
+
+	wg := &config.WireGuard{
+		Network: config.Network{
+			MTU:                 1380,
+			PersistentKeepalive: 25,
+			DNS:                 []string{"10.0.0.53"},
+			KeyRotation:         86400,
+		},
+		KeyRotation: 3600,
+	}
+
+	if mtu := wg.EffectiveMTU(); mtu.Value != 1380 || mtu.Source != config.SourceNetwork {
+		t.Errorf("expected network MTU 1380, got %d from %s", mtu.Value, mtu.Source)
+	}
+	if rotation := wg.EffectiveKeyRotation(); rotation.Value != 3600 || rotation.Source != config.SourceInterface {
+		t.Errorf("expected interface key rotation 3600, got %d from %s", rotation.Value, rotation.Source)
+	}
+
+	inherits := &config.WireGuardPeer{}
+	if keepalive := wg.EffectiveKeepalive(inherits); keepalive.Value != 25 || keepalive.Source != config.SourceNetwork {
+		t.Errorf("expected network keepalive 25, got %d from %s", keepalive.Value, keepalive.Source)
+	}
+	overrides := &config.WireGuardPeer{PersistentKeepalive: 10}
+	if keepalive := wg.EffectiveKeepalive(overrides); keepalive.Value != 10 || keepalive.Source != config.SourcePeer {
+		t.Errorf("expected peer keepalive 10, got %d from %s", keepalive.Value, keepalive.Source)
+	}
+
+	empty := &config.WireGuard{}
+	if dns := empty.EffectiveDNS(); len(dns.Value) != 0 || dns.Source != config.SourceDefault {
+		t.Errorf("expected no DNS by default, got %v from %s", dns.Value, dns.Source)
+	}
+}
+
+func TestHubSpokeTopologyPeers(t *testing.T) {
+	t.Parallel()
+
+	peers := []config.WireGuardPeer{
+		{Name: "hub-a", Metadata: map[string]string{"kubewg.net/hub": "true"}},
+		{Name: "spoke-b"},
+		{Name: "laptop", Metadata: map[string]string{"owner": "ops"}},
+	}
+
+	spoke := &config.WireGuard{
+		Network: config.Network{Topology: config.TopologyHubSpoke, HubSelector: "kubewg.net/hub=true"},
+	}
+	if spoke.IsHub() {
+		t.Fatal("expected a node without hub labels to be a spoke")
+	}
+	got := spoke.TopologyPeers(peers)
+	if len(got) != 1 || got[0].Name != "hub-a" {
+		t.Errorf("expected spokes to only peer with hub-a, got %v", got)
+	}
+
+	hub := &config.WireGuard{
+		Network: spoke.Network,
+		Labels:  map[string]string{"kubewg.net/hub": "true"},
+	}
+	if !hub.IsHub() {
+		t.Fatal("expected a node with hub labels to be a hub")
+	}
+	if got := hub.TopologyPeers(peers); len(got) != len(peers) {
+		t.Errorf("expected hubs to peer with all %d peers, got %d", len(peers), len(got))
+	}
+
+	mesh := &config.WireGuard{}
+	if got := mesh.TopologyPeers(peers); len(got) != len(peers) {
+		t.Errorf("expected a full mesh to peer with all %d peers, got %d", len(peers), len(got))
+	}
+}
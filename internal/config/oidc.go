@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// MetadataIdentity records the verified identity of a peer, who enrolled it
+// through OIDC or the SPIFFE ID of a node peer
+const MetadataIdentity = "kubewg.net/identity"
+
+const (
+	DefaultOIDCIdentityClaim = "email"
+	DefaultOIDCGroupsClaim   = "groups"
+)
+
+var DefaultOIDCScopes = []string{"openid", "email", "profile"}
+
+var (
+	ErrOIDCDeps    = errors.New("enrollment.oidc requires enrollment to be enabled")
+	ErrOIDCIssuer  = errors.New("enrollment.oidc requires an issuer and a client ID")
+	ErrOIDCProfile = errors.New("enrollment.oidc profiles need a group and a profile")
+)
+
+// EnrollmentOIDC lets clients enroll with an ID token from the corporate
+// identity provider instead of a token an administrator handed out. The
+// public client ID is given to `container connect`, which logs in with the
+// device flow.
+type EnrollmentOIDC struct {
+	Enabled  bool     `json:"enabled"`
+	Issuer   string   `json:"issuer"`
+	ClientID string   `json:"client_id"`
+	Scopes   []string `json:"scopes"`
+	// IdentityClaim names the enrolled peer, e.g. email or sub
+	IdentityClaim string `json:"identity_claim"`
+	GroupsClaim   string `json:"groups_claim"`
+	// Profiles map groups to access profiles, the first one whose group
+	// the identity is in wins and enrollment.default_profile applies when
+	// none does
+	Profiles []OIDCProfile `json:"profiles"`
+}
+
+type OIDCProfile struct {
+	Group   string `json:"group"`
+	Profile string `json:"profile"`
+}
+
+// Profile returns the access profile of an identity in groups.
+func (o *EnrollmentOIDC) Profile(groups []string, defaultProfile string) string {
+	for _, mapping := range o.Profiles {
+		if slices.Contains(groups, mapping.Group) {
+			return mapping.Profile
+		}
+	}
+	return defaultProfile
+}
+
+func (o *EnrollmentOIDC) applyDefaults() {
+	if len(o.Scopes) == 0 {
+		o.Scopes = DefaultOIDCScopes
+	}
+	if o.IdentityClaim == "" {
+		o.IdentityClaim = DefaultOIDCIdentityClaim
+	}
+	if o.GroupsClaim == "" {
+		o.GroupsClaim = DefaultOIDCGroupsClaim
+	}
+}
+
+func (o *EnrollmentOIDC) validate(enrollment bool, wg *WireGuard) error {
+	if !o.Enabled {
+		return nil
+	}
+	if !enrollment {
+		return ErrOIDCDeps
+	}
+	if o.Issuer == "" || o.ClientID == "" {
+		return ErrOIDCIssuer
+	}
+	for _, mapping := range o.Profiles {
+		if mapping.Group == "" || mapping.Profile == "" {
+			return fmt.Errorf("%w: %+v", ErrOIDCProfile, mapping)
+		}
+		if _, ok := wg.Profile(mapping.Profile); !ok {
+			return fmt.Errorf("%w: enrollment.oidc group %s maps to %q", ErrProfileUnknown, mapping.Group, mapping.Profile)
+		}
+	}
+	return nil
+}
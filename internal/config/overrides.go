@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+var (
+	ErrNodeOverrideMatch = errors.New("node overrides need nodes or a selector")
+	ErrNodeOverride      = errors.New("invalid node override")
+)
+
+// NodeOverride changes the WireGuard settings on the nodes it matches, e.g.
+// a different listen port or endpoint on edge nodes than on cloud nodes. A
+// node matches when it is listed in Nodes or its labels match Selector.
+type NodeOverride struct {
+	Name     string   `json:"name"`
+	Nodes    []string `json:"nodes"`
+	Selector string   `json:"selector"`
+	// WireGuard is merged over the wireguard section: fields it sets
+	// replace the base values, maps are merged and lists replaced
+	WireGuard json.RawMessage `json:"wireguard"`
+}
+
+// Matches reports whether the override applies to the named node.
+func (o *NodeOverride) Matches(nodeName string, nodeLabels map[string]string) bool {
+	if slices.Contains(o.Nodes, nodeName) {
+		return true
+	}
+	if o.Selector == "" {
+		return false
+	}
+	selector, err := labels.Parse(o.Selector)
+	return err == nil && selector.Matches(labels.Set(nodeLabels))
+}
+
+func (o *NodeOverride) validate() error {
+	if len(o.Nodes) == 0 && o.Selector == "" {
+		return fmt.Errorf("%w: %s", ErrNodeOverrideMatch, o.Name)
+	}
+	if _, err := labels.Parse(o.Selector); err != nil {
+		return fmt.Errorf("%w %s: selector: %w", ErrNodeOverride, o.Name, err)
+	}
+	return o.merge(&WireGuard{})
+}
+
+// merge decodes the override over wg. Unknown fields are rejected so a typo
+// doesn't silently leave a node on the base settings.
+func (o *NodeOverride) merge(wg *WireGuard) error {
+	if len(o.WireGuard) == 0 {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(o.WireGuard))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(wg); err != nil {
+		return fmt.Errorf("%w %s: %w", ErrNodeOverride, o.Name, err)
+	}
+	return nil
+}
+
+// NeedsNodeLabels reports whether matching the node overrides requires the
+// node's labels from the Kubernetes API.
+func (c *Config) NeedsNodeLabels() bool {
+	for _, override := range c.NodeOverrides {
+		if override.Selector != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyNodeOverrides merges every override matching the node over the
+// WireGuard settings in order, later overrides winning, and returns the
+// names of those applied. Defaults for settings the overrides enabled are
+// filled in as Complete would.
+func (c *Config) ApplyNodeOverrides(nodeName string, nodeLabels map[string]string) ([]string, error) {
+	var applied []string
+	for i := range c.NodeOverrides {
+		override := &c.NodeOverrides[i]
+		if !override.Matches(nodeName, nodeLabels) {
+			continue
+		}
+		if err := override.merge(&c.WireGuard); err != nil {
+			return applied, err
+		}
+		applied = append(applied, override.Name)
+	}
+	if len(applied) == 0 {
+		return nil, nil
+	}
+	c.applyDefaults()
+	if err := c.Validate(); err != nil {
+		return applied, fmt.Errorf("invalid config after node overrides %v: %w", applied, err)
+	}
+	return applied, nil
+}
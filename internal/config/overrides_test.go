@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/kubewg-net/container/internal/config"
+)
+
+const overridesConfig = `
+wireguard:
+  listen_port: 51820
+  endpoint: 'cloud.example.com:51820'
+  labels:
+    tier: cloud
+node_overrides:
+  - name: edge
+    selector: 'node-role.kubernetes.io/edge'
+    wireguard:
+      listen_port: 443
+      endpoint: ''
+      labels:
+        tier: edge
+  - name: edge-1
+    nodes: ['edge-1']
+    wireguard:
+      endpoint: 'edge-1.example.com:443'
+`
+
+func TestApplyNodeOverrides(t *testing.T) {
+	t.Parallel()
+
+	load := func() *config.Config {
+		var c config.Config
+		if err := yaml.Unmarshal([]byte(overridesConfig), &c); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := c.Complete(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return &c
+	}
+
+	c := load()
+	if !c.NeedsNodeLabels() {
+		t.Errorf("expected a selector to need node labels")
+	}
+	applied, err := c.ApplyNodeOverrides("edge-1", map[string]string{"node-role.kubernetes.io/edge": ""})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(applied) != 2 {
+		t.Fatalf("expected both overrides to apply, got %v", applied)
+	}
+	if c.WireGuard.ListenPort != 443 {
+		t.Errorf("expected listen port 443, got %d", c.WireGuard.ListenPort)
+	}
+	if c.WireGuard.Endpoint != "edge-1.example.com:443" {
+		t.Errorf("expected the later override's endpoint, got %q", c.WireGuard.Endpoint)
+	}
+	if c.WireGuard.Labels["tier"] != "edge" {
+		t.Errorf("expected tier label edge, got %q", c.WireGuard.Labels["tier"])
+	}
+
+	c = load()
+	applied, err = c.ApplyNodeOverrides("cloud-1", map[string]string{"topology.kubernetes.io/zone": "a"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(applied) != 0 || c.WireGuard.ListenPort != 51820 || c.WireGuard.Endpoint != "cloud.example.com:51820" {
+		t.Errorf("expected the base config on an unmatched node, got %v on port %d", applied, c.WireGuard.ListenPort)
+	}
+}
+
+func TestNodeOverrideValidation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		override config.NodeOverride
+		err      error
+	}{
+		{
+			name:     "no match",
+			override: config.NodeOverride{Name: "nothing"},
+			err:      config.ErrNodeOverrideMatch,
+		},
+		{
+			name:     "bad selector",
+			override: config.NodeOverride{Name: "bad", Selector: "a in (b"},
+			err:      config.ErrNodeOverride,
+		},
+		{
+			name:     "unknown field",
+			override: config.NodeOverride{Name: "typo", Nodes: []string{"a"}, WireGuard: []byte(`{"listen_prot": 443}`)},
+			err:      config.ErrNodeOverride,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := &config.Config{NodeOverrides: []config.NodeOverride{tt.override}}
+			if err := c.Validate(); !errors.Is(err, tt.err) {
+				t.Errorf("expected error %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import "errors"
+
+// DefaultAgentSocket is where the agent listens unless told otherwise
+const DefaultAgentSocket = "/run/kubewg/agent.sock"
+
+var (
+	ErrPrivSepDeps = errors.New("privilege_separation requires wireguard")
+	ErrPrivSepACL  = errors.New("privilege_separation can't enforce wireguard.acl, which needs CAP_NET_ADMIN outside the helper")
+)
+
+// PrivilegeSeparation runs the WireGuard interfaces in a helper process
+// that keeps CAP_NET_ADMIN. The rest, the API, the Kubernetes watchers and
+// enrollment, drops its capabilities once it is up, so a compromise of
+// those can't reconfigure the host's network.
+//
+// With AgentSocket set the interfaces run in a kubewg agent instead, a
+// separate privileged container on the same node, and this process needs
+// no capabilities at all.
+type PrivilegeSeparation struct {
+	Enabled     bool   `json:"enabled"`
+	AgentSocket string `json:"agent_socket"`
+}
+
+// UsesAgent reports whether the interfaces run in a kubewg agent.
+func (p *PrivilegeSeparation) UsesAgent() bool {
+	return p.Enabled && p.AgentSocket != ""
+}
+
+func (p *PrivilegeSeparation) validate(c *Config) error {
+	if !p.Enabled {
+		return nil
+	}
+	if !c.WireGuard.Enabled {
+		return ErrPrivSepDeps
+	}
+	if c.NeedsNFTables() {
+		return ErrPrivSepACL
+	}
+	return nil
+}
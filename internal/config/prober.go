@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Probe modes
+const (
+	// ProbeICMP sends echo requests, which needs CAP_NET_RAW
+	ProbeICMP = "icmp"
+	// ProbeUDP sends datagrams to prober.port, which the peer's prober
+	// echoes back, so only peers running kubewg answer
+	ProbeUDP = "udp"
+)
+
+const (
+	DefaultProbeInterval = 30 * time.Second
+	DefaultProbeCount    = 3
+	DefaultProbeTimeout  = 2 * time.Second
+	DefaultProbePort     = 51821
+)
+
+var (
+	ErrProbeMode = errors.New("prober.mode must be icmp or udp")
+	ErrProbeDeps = errors.New("the prober requires wireguard")
+)
+
+// Prober measures round trips and loss to the tunnel address of every
+// peer, reported as metrics and as a health condition.
+type Prober struct {
+	Enabled bool   `json:"enabled"`
+	Mode    string `json:"mode" jsonschema:"enum=icmp|udp"`
+	// Interval between probe rounds
+	Interval Duration `json:"interval"`
+	// Count of probes sent to each peer per round
+	Count int `json:"count"`
+	// Timeout a probe waits for its reply
+	Timeout Duration `json:"timeout"`
+	// Port the UDP probes are sent to and echoed on
+	Port uint16 `json:"port"`
+}
+
+func (p *Prober) applyDefaults() {
+	if p.Mode == "" {
+		p.Mode = ProbeICMP
+	}
+	if p.Interval == 0 {
+		p.Interval = Duration(DefaultProbeInterval)
+	}
+	if p.Count == 0 {
+		p.Count = DefaultProbeCount
+	}
+	if p.Timeout == 0 {
+		p.Timeout = Duration(DefaultProbeTimeout)
+	}
+	if p.Port == 0 {
+		p.Port = DefaultProbePort
+	}
+}
+
+func (p *Prober) validate(wireguard bool) error {
+	switch p.Mode {
+	case "", ProbeICMP, ProbeUDP:
+	default:
+		return fmt.Errorf("%w: %q", ErrProbeMode, p.Mode)
+	}
+	if p.Enabled && !wireguard {
+		return ErrProbeDeps
+	}
+	return nil
+}
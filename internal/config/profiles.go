@@ -0,0 +1,91 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MetadataProfile names the access profile of an enrolled peer
+const MetadataProfile = "kubewg.net/profile"
+
+var (
+	ErrProfile        = errors.New("wireguard.profiles need a unique name and at least one allowed IP")
+	ErrProfileUnknown = errors.New("unknown access profile")
+	ErrProfileACL     = errors.New("wireguard.profiles require wireguard.acl to be enabled, which enforces them")
+)
+
+// AccessProfile bounds what a client enrolled with it may reach, e.g. the
+// Service CIDR only for developers and the nodes' SSH on top for
+// operators. Its AllowedIPs replace the tunnel networks and cluster CIDRs
+// in the client's config, and the ACL limits the client's traffic to them,
+// and to Ports when set, whatever the client routes.
+type AccessProfile struct {
+	Name       string   `json:"name"`
+	AllowedIPs []string `json:"allowed_ips"`
+	Ports      []string `json:"ports"`
+}
+
+// Profile returns the access profile called name.
+func (w *WireGuard) Profile(name string) (*AccessProfile, bool) {
+	for i := range w.Profiles {
+		if w.Profiles[i].Name == name {
+			return &w.Profiles[i], true
+		}
+	}
+	return nil, false
+}
+
+// PeerProfile returns the access profile peer was enrolled with.
+func (w *WireGuard) PeerProfile(peer *WireGuardPeer) (*AccessProfile, bool) {
+	name, ok := peer.Metadata[MetadataProfile]
+	if !ok {
+		return nil, false
+	}
+	return w.Profile(name)
+}
+
+func (w *WireGuard) validateProfiles(defaultProfile string) error {
+	// Without the ACL a profile would only shape the client's config, which
+	// the client is free to change
+	if len(w.Profiles) != 0 && !w.ACL.Enabled {
+		return ErrProfileACL
+	}
+	names := make(map[string]bool, len(w.Profiles))
+	for _, profile := range w.Profiles {
+		if profile.Name == "" || names[profile.Name] || len(profile.AllowedIPs) == 0 {
+			return fmt.Errorf("%w: %q", ErrProfile, profile.Name)
+		}
+		names[profile.Name] = true
+		if err := validatePrefixes(profile.AllowedIPs, ErrProfile); err != nil {
+			return err
+		}
+		for _, port := range profile.Ports {
+			if _, err := ParseACLPort(port); err != nil {
+				return fmt.Errorf("profile %s: %w", profile.Name, err)
+			}
+		}
+	}
+	if defaultProfile != "" && !names[defaultProfile] {
+		return fmt.Errorf("%w: enrollment.default_profile %q", ErrProfileUnknown, defaultProfile)
+	}
+	return nil
+}
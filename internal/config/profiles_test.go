@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+func TestProfileValidation(t *testing.T) {
+	t.Parallel()
+
+	devs := config.AccessProfile{Name: "devs", AllowedIPs: []string{"10.96.0.0/12"}, Ports: []string{"tcp/443"}}
+	tests := []struct {
+		name           string
+		profiles       []config.AccessProfile
+		defaultProfile string
+		noACL          bool
+		err            error
+	}{
+		{name: "valid", profiles: []config.AccessProfile{devs}, defaultProfile: "devs"},
+		{name: "duplicate", profiles: []config.AccessProfile{devs, devs}, err: config.ErrProfile},
+		{name: "no allowed IPs", profiles: []config.AccessProfile{{Name: "devs"}}, err: config.ErrProfile},
+		{name: "bad allowed IP", profiles: []config.AccessProfile{{Name: "devs", AllowedIPs: []string{"10.96.0.0"}}}, err: config.ErrProfile},
+		{name: "bad port", profiles: []config.AccessProfile{{Name: "devs", AllowedIPs: []string{"10.96.0.0/12"}, Ports: []string{"sctp/1"}}}, err: config.ErrACLPort},
+		{name: "unknown default", profiles: []config.AccessProfile{devs}, defaultProfile: "ops", err: config.ErrProfileUnknown},
+		{name: "no ACL", profiles: []config.AccessProfile{devs}, noACL: true, err: config.ErrProfileACL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			c := &config.Config{
+				WireGuard:  config.WireGuard{Profiles: tt.profiles, ACL: config.ACL{Enabled: !tt.noACL}},
+				Enrollment: config.Enrollment{DefaultProfile: tt.defaultProfile},
+			}
+			err := c.Complete()
+			if tt.err == nil && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestOIDCProfiles(t *testing.T) {
+	t.Parallel()
+
+	c := &config.Config{
+		API: config.API{Enabled: true},
+		WireGuard: config.WireGuard{
+			Enabled:  true,
+			Endpoint: "vpn.example.com:51820",
+			Profiles: []config.AccessProfile{{Name: "ops", AllowedIPs: []string{"10.0.0.0/8"}}},
+			ACL:      config.ACL{Enabled: true},
+		},
+		Enrollment: config.Enrollment{Enabled: true, Pools: []string{"10.1.0.0/24"}},
+	}
+	c.Enrollment.OIDC = config.EnrollmentOIDC{
+		Enabled:  true,
+		Issuer:   "https://login.example.com",
+		ClientID: "kubewg",
+		Profiles: []config.OIDCProfile{{Group: "sre", Profile: "ops"}},
+	}
+	if err := c.Complete(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if c.Enrollment.OIDC.IdentityClaim != config.DefaultOIDCIdentityClaim {
+		t.Errorf("expected identity claim %q, got %q", config.DefaultOIDCIdentityClaim, c.Enrollment.OIDC.IdentityClaim)
+	}
+	if profile := c.Enrollment.OIDC.Profile([]string{"devs", "sre"}, "fallback"); profile != "ops" {
+		t.Errorf("expected the sre group to map to ops, got %q", profile)
+	}
+	if profile := c.Enrollment.OIDC.Profile([]string{"devs"}, "fallback"); profile != "fallback" {
+		t.Errorf("expected the default profile without a matching group, got %q", profile)
+	}
+
+	c.Enrollment.OIDC.Profiles[0].Profile = "devs"
+	if err := c.Complete(); !errors.Is(err, config.ErrProfileUnknown) {
+		t.Errorf("expected ErrProfileUnknown, got %v", err)
+	}
+	c.Enrollment.Enabled = false
+	c.Enrollment.OIDC.Profiles = nil
+	if err := c.Complete(); !errors.Is(err, config.ErrOIDCDeps) {
+		t.Errorf("expected ErrOIDCDeps, got %v", err)
+	}
+}
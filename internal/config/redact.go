@@ -0,0 +1,151 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"encoding/json"
+	"net/url"
+	"slices"
+	"strconv"
+)
+
+// Redacted replaces every non-empty secret
+const Redacted = "REDACTED"
+
+// Redact returns a copy of the config with private keys, preshared keys,
+// tokens and passwords replaced by Redacted, safe to attach to a bug
+// report. Paths to secret files are kept.
+func (c *Config) Redact() *Config {
+	redacted := *c
+	redact(&redacted.Profiling.Token)
+	redact(&redacted.Profiling.BasicAuthPassword)
+	redact(&redacted.WireGuard.HolePunching.Rendezvous.Token)
+	redact(&redacted.Webhooks.Secret)
+	redacted.Tracing.Headers = redactValues(c.Tracing.Headers)
+	redact(&redacted.Metrics.RemoteWrite.Token)
+	redact(&redacted.Metrics.RemoteWrite.BasicAuthPassword)
+	redacted.Metrics.RemoteWrite.Headers = redactValues(c.Metrics.RemoteWrite.Headers)
+	redacted.Webhooks.URLs = slices.Clone(c.Webhooks.URLs)
+	for i := range redacted.Webhooks.URLs {
+		redactURL(&redacted.Webhooks.URLs[i])
+	}
+
+	redacted.API.Auth.Tokens = slices.Clone(c.API.Auth.Tokens)
+	for i := range redacted.API.Auth.Tokens {
+		redact(&redacted.API.Auth.Tokens[i].Token)
+	}
+	redacted.Federation.Remotes = slices.Clone(c.Federation.Remotes)
+	for i := range redacted.Federation.Remotes {
+		redact(&redacted.Federation.Remotes[i].Token)
+	}
+	redactWireGuard(&redacted.WireGuard)
+	redacted.Interfaces = slices.Clone(c.Interfaces)
+	for i := range redacted.Interfaces {
+		redactWireGuard(&redacted.Interfaces[i])
+	}
+	redacted.NodeOverrides = slices.Clone(c.NodeOverrides)
+	for i := range redacted.NodeOverrides {
+		redactRaw(&redacted.NodeOverrides[i].WireGuard)
+	}
+	return &redacted
+}
+
+// rawSecrets are the fields of the wireguard section holding secrets
+var rawSecrets = map[string]bool{"private_key": true, "preshared_key": true, "token": true}
+
+// redactRaw redacts the secrets of a node override's wireguard section,
+// which is kept as JSON until it is merged. JSON that doesn't decode is
+// redacted whole.
+func redactRaw(raw *json.RawMessage) {
+	if len(*raw) == 0 {
+		return
+	}
+	var value any
+	if err := json.Unmarshal(*raw, &value); err != nil {
+		*raw = json.RawMessage(strconv.Quote(Redacted))
+		return
+	}
+	redactTree(value)
+	redacted, err := json.Marshal(value)
+	if err != nil {
+		*raw = json.RawMessage(strconv.Quote(Redacted))
+		return
+	}
+	*raw = redacted
+}
+
+// redactTree redacts the secret fields of decoded JSON in place.
+func redactTree(value any) {
+	switch value := value.(type) {
+	case map[string]any:
+		for name, field := range value {
+			if secret, ok := field.(string); ok && rawSecrets[name] {
+				redact(&secret)
+				value[name] = secret
+				continue
+			}
+			redactTree(field)
+		}
+	case []any:
+		for _, item := range value {
+			redactTree(item)
+		}
+	}
+}
+
+// redactWireGuard redacts the keys of an interface, cloning its peers.
+func redactWireGuard(wg *WireGuard) {
+	redact(&wg.PrivateKey)
+	wg.Peers = slices.Clone(wg.Peers)
+	for i := range wg.Peers {
+		redact(&wg.Peers[i].PresharedKey)
+	}
+}
+
+// redactURL keeps only the scheme and host of a URL, as webhook URLs such
+// as Slack's carry their secret in the path.
+func redactURL(raw *string) {
+	u, err := url.Parse(*raw)
+	if err != nil || u.Host == "" {
+		redact(raw)
+		return
+	}
+	*raw = u.Scheme + "://" + u.Host + "/" + Redacted
+}
+
+// redactValues returns a copy of headers with their values redacted, they
+// typically carry API keys.
+func redactValues(headers map[string]string) map[string]string {
+	if headers == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(headers))
+	for name, value := range headers {
+		redact(&value)
+		redacted[name] = value
+	}
+	return redacted
+}
+
+func redact(secret *string) {
+	if *secret != "" {
+		*secret = Redacted
+	}
+}
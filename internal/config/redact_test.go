@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+func TestRedact(t *testing.T) {
+	t.Parallel()
+
+	c := &config.Config{}
+	c.WireGuard.PrivateKey = "private"
+	c.WireGuard.PrivateKeyFile = "/etc/kubewg/private.key"
+	c.WireGuard.Peers = []config.WireGuardPeer{{PublicKey: "peer", PresharedKey: "psk"}, {PublicKey: "other"}}
+	c.API.Auth.Tokens = []config.APIToken{{Name: "admin", Token: "secret", Role: "admin"}}
+	c.Federation.Remotes = []config.FederationRemote{{Name: "remote", Token: "remote-secret"}}
+	c.Profiling.BasicAuthPassword = "password"
+	c.Interfaces = []config.WireGuard{{InterfaceName: "wg-clients", PrivateKey: "extra"}}
+	c.Webhooks.Secret = "hmac"
+	c.Webhooks.URLs = []string{"https://hooks.slack.com/services/T0/B0/secret"}
+	c.Tracing.Headers = map[string]string{"x-honeycomb-team": "api-key"}
+	c.Metrics.RemoteWrite.Token = "push-token"
+	c.NodeOverrides = []config.NodeOverride{{Name: "edge", Nodes: []string{"edge-1"},
+		WireGuard: []byte(`{"listen_port":51821,"private_key":"override","peers":[{"public_key":"peer","preshared_key":"override-psk"}]}`)}}
+
+	redacted := c.Redact()
+	if redacted.WireGuard.PrivateKey != config.Redacted {
+		t.Errorf("expected the private key to be redacted, got %q", redacted.WireGuard.PrivateKey)
+	}
+	if redacted.WireGuard.PrivateKeyFile != c.WireGuard.PrivateKeyFile {
+		t.Errorf("expected the private key file to be kept, got %q", redacted.WireGuard.PrivateKeyFile)
+	}
+	if redacted.WireGuard.Peers[0].PresharedKey != config.Redacted {
+		t.Errorf("expected the preshared key to be redacted, got %q", redacted.WireGuard.Peers[0].PresharedKey)
+	}
+	if redacted.WireGuard.Peers[1].PresharedKey != "" {
+		t.Errorf("expected an empty preshared key to stay empty, got %q", redacted.WireGuard.Peers[1].PresharedKey)
+	}
+	if redacted.API.Auth.Tokens[0].Token != config.Redacted || redacted.API.Auth.Tokens[0].Name != "admin" {
+		t.Errorf("expected only the API token to be redacted, got %+v", redacted.API.Auth.Tokens[0])
+	}
+	if redacted.Federation.Remotes[0].Token != config.Redacted {
+		t.Errorf("expected the federation token to be redacted, got %q", redacted.Federation.Remotes[0].Token)
+	}
+	if redacted.Profiling.BasicAuthPassword != config.Redacted {
+		t.Errorf("expected the profiling password to be redacted, got %q", redacted.Profiling.BasicAuthPassword)
+	}
+	if redacted.Interfaces[0].PrivateKey != config.Redacted {
+		t.Errorf("expected the private key of wg-clients to be redacted, got %q", redacted.Interfaces[0].PrivateKey)
+	}
+	if redacted.Webhooks.Secret != config.Redacted {
+		t.Errorf("expected the webhook secret to be redacted, got %q", redacted.Webhooks.Secret)
+	}
+	if redacted.Webhooks.URLs[0] != "https://hooks.slack.com/"+config.Redacted {
+		t.Errorf("expected only the webhook host to be kept, got %q", redacted.Webhooks.URLs[0])
+	}
+	if redacted.Tracing.Headers["x-honeycomb-team"] != config.Redacted {
+		t.Errorf("expected the tracing headers to be redacted, got %v", redacted.Tracing.Headers)
+	}
+	if redacted.Metrics.RemoteWrite.Token != config.Redacted {
+		t.Errorf("expected the remote-write token to be redacted, got %q", redacted.Metrics.RemoteWrite.Token)
+	}
+	override := string(redacted.NodeOverrides[0].WireGuard)
+	if strings.Contains(override, "override") || !strings.Contains(override, `"listen_port":51821`) {
+		t.Errorf("expected only the keys of the node override to be redacted, got %s", override)
+	}
+
+	// The original is left alone
+	if c.WireGuard.PrivateKey != "private" || c.WireGuard.Peers[0].PresharedKey != "psk" ||
+		c.API.Auth.Tokens[0].Token != "secret" || c.Federation.Remotes[0].Token != "remote-secret" || c.Interfaces[0].PrivateKey != "extra" ||
+		c.Webhooks.URLs[0] != "https://hooks.slack.com/services/T0/B0/secret" || c.Tracing.Headers["x-honeycomb-team"] != "api-key" ||
+		!strings.Contains(string(c.NodeOverrides[0].WireGuard), `"private_key":"override"`) {
+		t.Errorf("expected the original config to be unchanged, got %+v", c)
+	}
+}
@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+const (
+	DefaultRemoteWriteInterval = time.Minute
+	DefaultRemoteWriteTimeout  = 10 * time.Second
+)
+
+var (
+	ErrRemoteWriteURL     = errors.New("metrics.remote_write requires an http or https url")
+	ErrRemoteWriteInclude = errors.New("metrics.remote_write.include must hold valid regular expressions")
+)
+
+// RemoteWrite pushes metrics to a Prometheus remote-write endpoint, such as
+// Mimir, Thanos Receive or Prometheus itself with the receiver enabled, for
+// nodes the monitoring cluster can't scrape.
+type RemoteWrite struct {
+	Enabled bool   `json:"enabled"`
+	URL     string `json:"url"`
+	// Interval between pushes
+	Interval Duration `json:"interval"`
+	// Timeout of a single push
+	Timeout Duration `json:"timeout"`
+	// Include selects the metrics pushed by name, each a regular
+	// expression matching the whole name. All metrics are pushed when empty
+	Include []string `json:"include"`
+	// Labels are added to every series, next to a node label naming the
+	// node unless set here
+	Labels map[string]string `json:"labels"`
+	// Token is sent as a bearer token, BasicAuthUser and
+	// BasicAuthPassword as basic auth
+	Token             string            `json:"token"`
+	BasicAuthUser     string            `json:"basic_auth_user"`
+	BasicAuthPassword string            `json:"basic_auth_password"`
+	TenantID          string            `json:"tenant_id"`
+	Headers           map[string]string `json:"headers"`
+	CAFile            string            `json:"ca_file"`
+}
+
+func (r *RemoteWrite) applyDefaults() {
+	if r.Interval == 0 {
+		r.Interval = Duration(DefaultRemoteWriteInterval)
+	}
+	if r.Timeout == 0 {
+		r.Timeout = Duration(DefaultRemoteWriteTimeout)
+	}
+}
+
+func (r *RemoteWrite) validate() error {
+	for _, pattern := range r.Include {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("%w: %q", ErrRemoteWriteInclude, pattern)
+		}
+	}
+	if !r.Enabled {
+		return nil
+	}
+	u, err := url.Parse(r.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: %q", ErrRemoteWriteURL, r.URL)
+	}
+	return nil
+}
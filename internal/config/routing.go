@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+// DefaultRulePriority puts the policy rules ahead of the main table's rule
+// at 32766.
+const DefaultRulePriority = 10000
+
+var (
+	ErrRoutingTable = errors.New("routing rules need a routing table other than local (255), main (254) and default (253)")
+	ErrRoutingRule  = errors.New("routing rules need a fwmark, from or to")
+)
+
+// Routing installs the peer routes into a dedicated table and selects it
+// with policy rules, so they don't conflict with the CNI's routes in the
+// main table.
+//
+// Default routes need WireGuard.FwMark: they go to the table, or to a
+// table numbered after the fwmark when there is none, and the packets
+// WireGuard sends itself are kept out of it, the way wg-quick does.
+type Routing struct {
+	// Table receives the peer routes, 0 keeps them in the main table
+	Table int `json:"table"`
+	// RulePriority is the priority of the first rule, the ones after it
+	// follow in order
+	RulePriority int           `json:"rule_priority"`
+	Rules        []RoutingRule `json:"rules"`
+}
+
+// RoutingRule looks up the routing table for packets matching all of its
+// selectors.
+type RoutingRule struct {
+	FwMark uint32 `json:"fwmark"`
+	// FwMask limits the bits of FwMark compared, 0 compares all of them
+	FwMask uint32 `json:"fwmask"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+}
+
+// Prefixes parses the rule's From and To, returning invalid prefixes for
+// the ones that are not set.
+func (r *RoutingRule) Prefixes() (netip.Prefix, netip.Prefix, error) {
+	var from, to netip.Prefix
+	var err error
+	if r.From != "" {
+		if from, err = netip.ParsePrefix(r.From); err != nil {
+			return from, to, fmt.Errorf("invalid routing rule source %q: %w", r.From, err)
+		}
+	}
+	if r.To != "" {
+		if to, err = netip.ParsePrefix(r.To); err != nil {
+			return from, to, fmt.Errorf("invalid routing rule destination %q: %w", r.To, err)
+		}
+	}
+	return from.Masked(), to.Masked(), nil
+}
+
+// ExclusionTable is the table default routes go to when fwmark is set.
+func (r *Routing) ExclusionTable(fwmark uint32) int {
+	if r.Table != 0 {
+		return r.Table
+	}
+	return int(fwmark)
+}
+
+func (r *Routing) validate(fwmark uint32) error {
+	if r.Table < 0 || (r.Table >= 253 && r.Table <= 255) {
+		return ErrRoutingTable
+	}
+	if table := r.ExclusionTable(fwmark); fwmark != 0 && (table < 0 || (table >= 253 && table <= 255)) {
+		return ErrRoutingTable
+	}
+	if len(r.Rules) > 0 && r.Table == 0 {
+		return ErrRoutingTable
+	}
+	for i := range r.Rules {
+		rule := &r.Rules[i]
+		if rule.FwMark == 0 && rule.From == "" && rule.To == "" {
+			return ErrRoutingRule
+		}
+		from, to, err := rule.Prefixes()
+		if err != nil {
+			return err
+		}
+		if from.IsValid() && to.IsValid() && from.Addr().Is4() != to.Addr().Is4() {
+			return fmt.Errorf("%w: from and to must be the same address family", ErrRoutingRule)
+		}
+	}
+	return nil
+}
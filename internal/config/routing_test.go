@@ -0,0 +1,111 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+func TestRoutingValidation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		routing config.Routing
+		err     error
+	}{
+		{name: "main table"},
+		{
+			name: "fwmark rule",
+			routing: config.Routing{
+				Table: 100,
+				Rules: []config.RoutingRule{{FwMark: 0x100, FwMask: 0xf00}},
+			},
+		},
+		{
+			name: "source rule",
+			routing: config.Routing{
+				Table: 100,
+				Rules: []config.RoutingRule{{From: "10.244.0.0/16", To: "10.100.0.0/16"}},
+			},
+		},
+		{
+			name:    "rules without table",
+			routing: config.Routing{Rules: []config.RoutingRule{{FwMark: 1}}},
+			err:     config.ErrRoutingTable,
+		},
+		{
+			name:    "reserved table",
+			routing: config.Routing{Table: 254},
+			err:     config.ErrRoutingTable,
+		},
+		{
+			name:    "rule selecting everything",
+			routing: config.Routing{Table: 100, Rules: []config.RoutingRule{{}}},
+			err:     config.ErrRoutingRule,
+		},
+		{
+			name: "mixed families",
+			routing: config.Routing{
+				Table: 100,
+				Rules: []config.RoutingRule{{From: "10.244.0.0/16", To: "fd00::/64"}},
+			},
+			err: config.ErrRoutingRule,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			c := &config.Config{WireGuard: config.WireGuard{Routing: tt.routing}}
+			err := c.Complete()
+			if tt.err == nil && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+			if err == nil && c.WireGuard.Routing.RulePriority != config.DefaultRulePriority {
+				t.Errorf("expected rule priority %d, got %d", config.DefaultRulePriority, c.WireGuard.Routing.RulePriority)
+			}
+		})
+	}
+}
+
+func TestExclusionTable(t *testing.T) {
+	t.Parallel()
+
+	routing := config.Routing{}
+	if table := routing.ExclusionTable(51820); table != 51820 {
+		t.Errorf("expected the fwmark to number the table, got %d", table)
+	}
+	routing.Table = 100
+	if table := routing.ExclusionTable(51820); table != 100 {
+		t.Errorf("expected the configured table, got %d", table)
+	}
+
+	c := &config.Config{WireGuard: config.WireGuard{FwMark: 254}}
+	if err := c.Complete(); !errors.Is(err, config.ErrRoutingTable) {
+		t.Errorf("expected a fwmark naming the main table to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+var ErrSandboxPath = errors.New("sandbox.writable_paths must be absolute")
+
+// Sandbox restricts the process once it is up: a seccomp filter to the
+// system calls kubewg makes, and a Landlock ruleset to writing the files
+// it keeps and running the firewall tools. Reading stays allowed anywhere.
+// It is on unless Disabled, the escape hatch for a kernel or a setup it
+// gets in the way of.
+type Sandbox struct {
+	Disabled bool `json:"disabled"`
+	// WritablePaths are written to on top of the ones kubewg knows of
+	WritablePaths []string `json:"writable_paths"`
+}
+
+func (s *Sandbox) validate() error {
+	for _, path := range s.WritablePaths {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("%w: %s", ErrSandboxPath, path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/kubewg-net/container/internal/features"
+)
+
+// SchemaDialect is the JSON Schema version Schema follows
+const SchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// variablePattern matches a value that is a single environment reference,
+// which stands in for a value of any type
+const variablePattern = `^\$\{[A-Za-z_][A-Za-z0-9_]*(:-[^}]*)?\}$`
+
+//nolint:golint,gochecknoglobals
+var (
+	rawMessageType = reflect.TypeFor[json.RawMessage]()
+	durationType   = reflect.TypeFor[Duration]()
+	byteSizeType   = reflect.TypeFor[ByteSize]()
+	gatesType      = reflect.TypeFor[FeatureGates]()
+)
+
+// Patterns of the string forms of Duration and ByteSize
+const (
+	durationPattern = `^([0-9]+(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+$|^[0-9]+(\.[0-9]*)?$`
+	byteSizePattern = `^[0-9]+(\.[0-9]+)?([eE][0-9]+|Ki|Mi|Gi|Ti|Pi|Ei|k|M|G|T|P|E)?$`
+)
+
+// Schema returns a JSON Schema of the config file, generated from the json
+// tags of Config. Unknown keys are rejected, so a misspelled option fails
+// the check instead of being ignored. The defaults are those applyDefaults
+// fills in, leaving out the ones read from the pod's environment.
+//
+// Fields may narrow their schema with a jsonschema tag holding comma
+// separated options: enum=a|b|c, minimum=n, maximum=n, and nodefault for
+// defaults that depend on where the config is loaded. The options of a
+// list apply to its items.
+func Schema() map[string]any {
+	var defaults Config
+	defaults.applyDefaults()
+
+	schema := schemaFor(reflect.TypeFor[Config](), reflect.ValueOf(defaults), "")
+	schema["$schema"] = SchemaDialect
+	schema["title"] = "kubewg container config"
+	return schema
+}
+
+// schemaFor returns the schema of type t. def holds the default, or is
+// invalid when there is none.
+//
+//nolint:golint,gocyclo
+func schemaFor(t reflect.Type, def reflect.Value, tag string) map[string]any {
+	schema := map[string]any{}
+	options := parseSchemaTag(tag)
+
+	switch {
+	case t == rawMessageType:
+		// Node overrides hold a partial wireguard section, checked once
+		// they are merged
+		schema["type"] = "object"
+		return schema
+	case t.Kind() == reflect.Pointer:
+		return schemaFor(t.Elem(), reflect.Value{}, tag)
+	case t == gatesType:
+		properties := map[string]any{}
+		for _, feature := range features.Known() {
+			spec, _ := features.Lookup(feature)
+			gate := schemaFor(reflect.TypeFor[bool](), reflect.Value{}, "")
+			gate["default"] = spec.Default
+			gate["description"] = features.Describe(feature)
+			properties[string(feature)] = gate
+		}
+		schema["type"] = "object"
+		schema["properties"] = properties
+		schema["additionalProperties"] = false
+		return schema
+	case t == durationType || t == byteSizeType:
+		pattern := durationPattern
+		if t == byteSizeType {
+			pattern = byteSizePattern
+		}
+		schema["anyOf"] = []any{
+			map[string]any{"type": "number", "minimum": 0},
+			map[string]any{"type": "string", "pattern": pattern},
+			map[string]any{"type": "string", "pattern": variablePattern},
+		}
+		if hasDefault(def) {
+			schema["default"] = def.Interface()
+		}
+		return schema
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		schema["type"] = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		schema["type"] = "integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		schema["type"] = "integer"
+		schema["minimum"] = 0
+		if t.Bits() < 64 {
+			schema["maximum"] = uint64(math.MaxUint64 >> (64 - t.Bits()))
+		}
+	case reflect.Float32, reflect.Float64:
+		schema["type"] = "number"
+	case reflect.String:
+		schema["type"] = "string"
+	case reflect.Slice:
+		schema["type"] = "array"
+		schema["items"] = schemaFor(t.Elem(), reflect.Value{}, tag)
+		options = nil
+	case reflect.Map:
+		schema["type"] = "object"
+		schema["additionalProperties"] = schemaFor(t.Elem(), reflect.Value{}, "")
+	case reflect.Struct:
+		schema["type"] = "object"
+		schema["additionalProperties"] = false
+		properties := map[string]any{}
+		addProperties(properties, t, def)
+		schema["properties"] = properties
+		return schema
+	}
+
+	for key, value := range options {
+		if key != "nodefault" {
+			schema[key] = value
+		}
+	}
+
+	// Numbers and booleans may still be written as ${VAR}
+	switch schema["type"] {
+	case "boolean", "integer", "number":
+		schema = map[string]any{
+			"anyOf": []any{schema, map[string]any{"type": "string", "pattern": variablePattern}},
+		}
+	}
+	if hasDefault(def) && options["nodefault"] == nil {
+		schema["default"] = def.Interface()
+	}
+	return schema
+}
+
+func hasDefault(def reflect.Value) bool {
+	if !def.IsValid() {
+		return false
+	}
+	switch def.Kind() {
+	case reflect.Slice, reflect.Map:
+		return def.Len() > 0
+	}
+	return !def.IsZero()
+}
+
+// addProperties adds the fields of struct t to properties, inlining
+// embedded structs the way encoding/json does.
+func addProperties(properties map[string]any, t reflect.Type, def reflect.Value) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		var fieldDef reflect.Value
+		if def.IsValid() {
+			fieldDef = def.Field(i)
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			addProperties(properties, field.Type, fieldDef)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = schemaFor(field.Type, fieldDef, field.Tag.Get("jsonschema"))
+	}
+}
+
+// parseSchemaTag reads the options of a jsonschema tag.
+func parseSchemaTag(tag string) map[string]any {
+	if tag == "" {
+		return nil
+	}
+	options := map[string]any{}
+	for _, option := range strings.Split(tag, ",") {
+		key, value, _ := strings.Cut(option, "=")
+		switch key {
+		case "enum":
+			var values []any
+			for _, v := range strings.Split(value, "|") {
+				values = append(values, v)
+			}
+			options[key] = values
+		case "minimum", "maximum":
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				panic("invalid jsonschema " + key + ": " + value)
+			}
+			options[key] = n
+		case "nodefault":
+			options[key] = true
+		default:
+			panic("unknown jsonschema option: " + key)
+		}
+	}
+	return options
+}
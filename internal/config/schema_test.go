@@ -0,0 +1,199 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/kubewg-net/container/internal/config"
+)
+
+func TestSchemaExample(t *testing.T) {
+	t.Parallel()
+
+	data, err := os.ReadFile("../../config.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document any
+	if err := json.Unmarshal(converted, &document); err != nil {
+		t.Fatal(err)
+	}
+
+	schema := roundTrip(t, config.Schema())
+	if err := validate(schema, document, ""); err != nil {
+		t.Errorf("expected the example config to match the schema, got %v", err)
+	}
+}
+
+func TestSchemaRejects(t *testing.T) {
+	t.Parallel()
+
+	schema := roundTrip(t, config.Schema())
+	tests := map[string]string{
+		"metrics:\n  prot: 9100\n":                     "unknown key",
+		"metrics:\n  port: 70000\n":                    "out of range",
+		"metrics:\n  port: nine\n":                     "wrong type",
+		"wireguard:\n  network:\n    topology: ring\n": "not in the enum",
+	}
+	for content, reason := range tests {
+		converted, err := yaml.YAMLToJSON([]byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var document any
+		if err := json.Unmarshal(converted, &document); err != nil {
+			t.Fatal(err)
+		}
+		if validate(schema, document, "") == nil {
+			t.Errorf("expected %q to be rejected as %s", content, reason)
+		}
+	}
+
+	converted, _ := yaml.YAMLToJSON([]byte("metrics:\n  port: ${METRICS_PORT:-9100}\n  enabled: ${METRICS}\n"))
+	var document any
+	if err := json.Unmarshal(converted, &document); err != nil {
+		t.Fatal(err)
+	}
+	if err := validate(schema, document, ""); err != nil {
+		t.Errorf("expected environment references to be accepted, got %v", err)
+	}
+}
+
+func TestSchemaDefaults(t *testing.T) {
+	t.Parallel()
+
+	schema := roundTrip(t, config.Schema())
+	if schema["$schema"] != config.SchemaDialect {
+		t.Errorf("expected the %s dialect, got %v", config.SchemaDialect, schema["$schema"])
+	}
+	port := property(schema, "metrics", "port")
+	if port["default"] != float64(config.DefaultMetricsPort) {
+		t.Errorf("expected the metrics port to default to %d, got %v", config.DefaultMetricsPort, port["default"])
+	}
+	if _, ok := property(schema, "kubernetes", "pod_namespace")["default"]; ok {
+		t.Error("expected no default for the pod's namespace")
+	}
+}
+
+func roundTrip(t *testing.T, schema map[string]any) map[string]any {
+	t.Helper()
+	data, err := json.Marshal(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func property(schema map[string]any, path ...string) map[string]any {
+	for _, name := range path {
+		schema = schema["properties"].(map[string]any)[name].(map[string]any)
+	}
+	return schema
+}
+
+// validate checks value against the parts of JSON Schema Schema uses.
+//
+//nolint:gocyclo
+func validate(schema map[string]any, value any, path string) error {
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		for _, option := range anyOf {
+			if validate(option.(map[string]any), value, path) == nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: %v matches none of the options", path, value)
+	}
+
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an object, got %v", path, value)
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		for key, item := range object {
+			itemSchema, ok := properties[key].(map[string]any)
+			if !ok {
+				itemSchema, ok = schema["additionalProperties"].(map[string]any)
+			}
+			if !ok {
+				if schema["additionalProperties"] == false {
+					return fmt.Errorf("%s: unknown key %s", path, key)
+				}
+				continue
+			}
+			if err := validate(itemSchema, item, path+"."+key); err != nil {
+				return err
+			}
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return fmt.Errorf("%s: expected an array, got %v", path, value)
+		}
+		for i, item := range items {
+			if err := validate(schema["items"].(map[string]any), item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s: expected a string, got %v", path, value)
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(s) {
+			return fmt.Errorf("%s: %q doesn't match %s", path, s, pattern)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected a boolean, got %v", path, value)
+		}
+	case "integer", "number":
+		n, ok := value.(float64)
+		if !ok || (schema["type"] == "integer" && n != float64(int64(n))) {
+			return fmt.Errorf("%s: expected a %s, got %v", path, schema["type"], value)
+		}
+		if minimum, ok := schema["minimum"].(float64); ok && n < minimum {
+			return fmt.Errorf("%s: %v is below %v", path, n, minimum)
+		}
+		if maximum, ok := schema["maximum"].(float64); ok && n > maximum {
+			return fmt.Errorf("%s: %v is above %v", path, n, maximum)
+		}
+	}
+	if enum, ok := schema["enum"].([]any); ok && !slices.Contains(enum, value) {
+		return fmt.Errorf("%s: %v is not one of %v", path, value, enum)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// DefaultSPIFFESocket is where the SPIRE agent's Helm chart mounts the
+// Workload API socket
+const DefaultSPIFFESocket = "/run/spire/agent-sockets/spire-agent.sock"
+
+// SPIFFENodePlaceholder stands for the node name in spiffe.allowed_ids
+const SPIFFENodePlaceholder = "{node}"
+
+var (
+	ErrSPIFFEDeps        = errors.New("spiffe requires kubernetes.node_peers to be enabled")
+	ErrSPIFFETrustDomain = errors.New("spiffe requires a trust domain")
+	ErrSPIFFEID          = errors.New("spiffe.allowed_ids patterns must be spiffe:// IDs naming the node with " + SPIFFENodePlaceholder)
+	ErrSPIFFEAllowedIDs  = errors.New("spiffe requires allowed_ids")
+)
+
+// SPIFFE binds the WireGuard key of each node to the X.509 SVID its SPIRE
+// agent issues. Nodes publish their key signed with their SVID and only
+// accept node peers whose signature verifies against the trust bundle and
+// whose SPIFFE ID is allowed.
+type SPIFFE struct {
+	Enabled bool `json:"enabled"`
+	// SocketPath of the SPIRE agent's Workload API
+	SocketPath  string `json:"socket_path"`
+	TrustDomain string `json:"trust_domain"`
+	// AllowedIDs are path.Match patterns of the SPIFFE IDs accepted as node
+	// peers, each naming the node with {node}, e.g.
+	// spiffe://example.org/kubewg/node/{node}. That ties an SVID to the one
+	// node it may publish a key for.
+	AllowedIDs []string `json:"allowed_ids"`
+}
+
+// Allowed reports whether the SPIFFE ID id may publish the key of the Node
+// nodeName.
+func (s *SPIFFE) Allowed(id, nodeName string) bool {
+	if !strings.HasPrefix(id, "spiffe://"+s.TrustDomain+"/") {
+		return false
+	}
+	// Node names are DNS subdomains, anything else could widen a pattern
+	if nodeName == "" || strings.ContainsAny(nodeName, "/*?[]\\") {
+		return false
+	}
+	for _, pattern := range s.AllowedIDs {
+		if ok, _ := path.Match(strings.ReplaceAll(pattern, SPIFFENodePlaceholder, nodeName), id); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SPIFFE) applyDefaults() {
+	if s.SocketPath == "" {
+		s.SocketPath = DefaultSPIFFESocket
+	}
+}
+
+func (s *SPIFFE) validate(nodePeers bool) error {
+	if !s.Enabled {
+		return nil
+	}
+	if !nodePeers {
+		return ErrSPIFFEDeps
+	}
+	if s.TrustDomain == "" || strings.ContainsAny(s.TrustDomain, "/:") {
+		return fmt.Errorf("%w: %q", ErrSPIFFETrustDomain, s.TrustDomain)
+	}
+	if len(s.AllowedIDs) == 0 {
+		return ErrSPIFFEAllowedIDs
+	}
+	for _, pattern := range s.AllowedIDs {
+		if _, err := path.Match(pattern, ""); err != nil || !strings.HasPrefix(pattern, "spiffe://") ||
+			!strings.Contains(pattern, SPIFFENodePlaceholder) {
+			return fmt.Errorf("%w: %q", ErrSPIFFEID, pattern)
+		}
+	}
+	return nil
+}
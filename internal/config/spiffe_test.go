@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+func TestAllowedIDs(t *testing.T) {
+	t.Parallel()
+
+	spiffeConfig := &config.SPIFFE{TrustDomain: "example.org", AllowedIDs: []string{"spiffe://example.org/kubewg/node/{node}"}}
+	if !spiffeConfig.Allowed("spiffe://example.org/kubewg/node/node-a", "node-a") {
+		t.Error("expected the SVID of node-a to be allowed for node-a")
+	}
+	if spiffeConfig.Allowed("spiffe://example.org/kubewg/node/node-b", "node-a") {
+		t.Error("expected the SVID of node-b to be rejected for node-a")
+	}
+	if spiffeConfig.Allowed("spiffe://example.org/ns/default/sa/kubewg", "node-a") {
+		t.Error("expected a workload outside the patterns to be rejected")
+	}
+	if spiffeConfig.Allowed("spiffe://other.org/kubewg/node/node-a", "node-a") {
+		t.Error("expected another trust domain to be rejected")
+	}
+	if spiffeConfig.Allowed("spiffe://example.org/kubewg/node/node-a", "*") {
+		t.Error("expected a node name with pattern characters to be rejected")
+	}
+}
+
+func TestSPIFFEValidation(t *testing.T) {
+	t.Parallel()
+
+	nodeIDs := []string{"spiffe://example.org/kubewg/node/{node}"}
+	tests := []struct {
+		name      string
+		nodePeers bool
+		spiffe    config.SPIFFE
+		err       error
+	}{
+		{name: "valid", nodePeers: true, spiffe: config.SPIFFE{Enabled: true, TrustDomain: "example.org", AllowedIDs: nodeIDs}},
+		{name: "no node peers", spiffe: config.SPIFFE{Enabled: true, TrustDomain: "example.org", AllowedIDs: nodeIDs}, err: config.ErrSPIFFEDeps},
+		{name: "no trust domain", nodePeers: true, spiffe: config.SPIFFE{Enabled: true, AllowedIDs: nodeIDs}, err: config.ErrSPIFFETrustDomain},
+		{name: "trust domain URI", nodePeers: true, spiffe: config.SPIFFE{Enabled: true, TrustDomain: "spiffe://example.org", AllowedIDs: nodeIDs}, err: config.ErrSPIFFETrustDomain},
+		{name: "no allowed IDs", nodePeers: true, spiffe: config.SPIFFE{Enabled: true, TrustDomain: "example.org"}, err: config.ErrSPIFFEAllowedIDs},
+		{name: "bad pattern", nodePeers: true, spiffe: config.SPIFFE{Enabled: true, TrustDomain: "example.org", AllowedIDs: []string{"example.org/{node}"}}, err: config.ErrSPIFFEID},
+		{name: "pattern without node", nodePeers: true, spiffe: config.SPIFFE{Enabled: true, TrustDomain: "example.org", AllowedIDs: []string{"spiffe://example.org/kubewg/*"}}, err: config.ErrSPIFFEID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			c := &config.Config{
+				WireGuard:  config.WireGuard{Enabled: true},
+				Kubernetes: config.Kubernetes{NodeName: "node-a", Annotate: true, NodePeers: config.NodePeers{Enabled: tt.nodePeers}},
+				SPIFFE:     tt.spiffe,
+			}
+			err := c.Complete()
+			if tt.err == nil && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrConfigVariableUnset = errors.New("config references an unset environment variable")
+	ErrConfigVariableName  = errors.New("config references an invalid environment variable")
+)
+
+// substitute expands the ${VAR} and ${VAR:-default} references in the
+// string values of a decoded document, in place. The default is used when
+// the variable is unset or empty, $${ is a literal ${. A value that is one
+// reference and nothing else takes the type its expansion reads as, so
+// "port: ${PORT}" still fills a number.
+func substitute(value any) (any, error) {
+	switch value := value.(type) {
+	case string:
+		expanded, err := expandVariables(value)
+		if err != nil {
+			return nil, err
+		}
+		if expanded != value && isReference(value) {
+			return scalar(expanded), nil
+		}
+		return expanded, nil
+	case map[string]any:
+		for key, item := range value {
+			expanded, err := substitute(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			value[key] = expanded
+		}
+	case []any:
+		for i, item := range value {
+			expanded, err := substitute(item)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			value[i] = expanded
+		}
+	}
+	return value, nil
+}
+
+// expandVariables expands the references in s.
+func expandVariables(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1])
+			b.WriteString("${")
+			s = s[i+2:]
+			continue
+		}
+		b.WriteString(s[:i])
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("%w: unterminated %q", ErrConfigVariableName, s[i:])
+		}
+		reference := s[i+2 : i+end]
+		s = s[i+end+1:]
+
+		name, fallback, hasDefault := strings.Cut(reference, ":-")
+		if !validVariable(name) {
+			return "", fmt.Errorf("%w: %q", ErrConfigVariableName, name)
+		}
+		value, ok := os.LookupEnv(name)
+		switch {
+		case value != "":
+		case hasDefault:
+			value = fallback
+		case !ok:
+			return "", fmt.Errorf("%w: %s", ErrConfigVariableUnset, name)
+		}
+		b.WriteString(value)
+	}
+}
+
+// isReference reports whether s is a single reference.
+func isReference(s string) bool {
+	return strings.HasPrefix(s, "${") && strings.IndexByte(s, '}') == len(s)-1
+}
+
+// scalar reads an expanded value the way YAML reads a plain scalar.
+func scalar(s string) any {
+	switch s {
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	case "null", "Null", "NULL", "~":
+		return nil
+	}
+	// Only what JSON reads as a number, hexadecimal and infinities stay strings
+	if strings.Trim(s, "0123456789+-.eE") != "" {
+		return s
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil && json.Valid([]byte(s)) {
+		return json.Number(s)
+	}
+	return s
+}
+
+func validVariable(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+//nolint:paralleltest // t.Setenv
+func TestSubstitute(t *testing.T) {
+	t.Setenv("KUBEWG_TEST_PORT", "9100")
+	t.Setenv("KUBEWG_TEST_SITE", "eu1")
+	t.Setenv("KUBEWG_TEST_EMPTY", "")
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "metrics:\n" +
+		"  enabled: ${KUBEWG_TEST_ENABLED:-true}\n" +
+		"  port: ${KUBEWG_TEST_PORT}\n" +
+		"  namespace: kubewg_${KUBEWG_TEST_SITE}\n" +
+		"  const_labels:\n    site: ${KUBEWG_TEST_SITE}\n    zone: ${KUBEWG_TEST_EMPTY:-default}\n" +
+		"    cost: $${KUBEWG_TEST_SITE}\n" +
+		"wireguard:\n  addresses: ['10.0.0.1/24', '${KUBEWG_TEST_ADDRESS:-fd00::1/64}']\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := config.LoadConfig(newLoadCommand(t, path, ""))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !c.Metrics.Enabled || c.Metrics.Port != 9100 {
+		t.Errorf("expected whole references to take their type, got %+v", c.Metrics.HTTPListener)
+	}
+	if c.Metrics.Namespace != "kubewg_eu1" {
+		t.Errorf("expected kubewg_eu1, got %q", c.Metrics.Namespace)
+	}
+	labels := c.Metrics.ConstLabels
+	if labels["site"] != "eu1" || labels["zone"] != "default" || labels["cost"] != "${KUBEWG_TEST_SITE}" {
+		t.Errorf("expected expanded, defaulted and escaped labels, got %v", labels)
+	}
+	if !slices.Equal(c.WireGuard.Addresses, []string{"10.0.0.1/24", "fd00::1/64"}) {
+		t.Errorf("expected the default address, got %v", c.WireGuard.Addresses)
+	}
+}
+
+//nolint:paralleltest // t.Setenv
+func TestSubstituteErrors(t *testing.T) {
+	t.Setenv("KUBEWG_TEST_EMPTY", "")
+
+	tests := map[string]error{
+		"metrics:\n  namespace: ${KUBEWG_TEST_UNSET}\n": config.ErrConfigVariableUnset,
+		"metrics:\n  namespace: ${1BAD}\n":              config.ErrConfigVariableName,
+		"metrics:\n  namespace: ${KUBEWG_TEST_EMPTY\n":  config.ErrConfigVariableName,
+	}
+	for content, expected := range tests {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := config.LoadConfig(newLoadCommand(t, path, ""))
+		if !errors.Is(err, expected) {
+			t.Errorf("expected %v for %q, got %v", expected, content, err)
+		}
+	}
+}
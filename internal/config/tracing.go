@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Redaction policies for the peer and node attributes of spans
+const (
+	// RedactHash replaces public keys by a short hash, enough to follow a
+	// peer across traces without handing its key to the tracing backend
+	RedactHash = "hash"
+	// RedactNone records public keys and node names as they are
+	RedactNone = "none"
+	// RedactOmit leaves peer keys and node names out of spans
+	RedactOmit = "omit"
+)
+
+// Samplers, named after the OTEL_TRACES_SAMPLER values. The parent based
+// ones follow the sampling decision of the caller when there is one.
+const (
+	SamplerAlwaysOn             = "always_on"
+	SamplerAlwaysOff            = "always_off"
+	SamplerRatio                = "traceidratio"
+	SamplerParentAlwaysOn       = "parentbased_always_on"
+	SamplerParentAlwaysOff      = "parentbased_always_off"
+	SamplerParentRatio          = "parentbased_traceidratio"
+	DefaultTracingSampler       = SamplerParentAlwaysOn
+	DefaultTracingSamplingRatio = 1.0
+)
+
+// Propagation formats of the trace context in HTTP headers
+const (
+	// PropagateTraceContext is the W3C traceparent header
+	PropagateTraceContext = "tracecontext"
+	// PropagateBaggage is the W3C baggage header
+	PropagateBaggage = "baggage"
+	// PropagateB3 is Zipkin's single b3 header
+	PropagateB3 = "b3"
+	// PropagateB3Multi is Zipkin's X-B3-* headers
+	PropagateB3Multi = "b3multi"
+)
+
+var (
+	ErrTracingRedaction = errors.New("tracing.redaction must be hash, none or omit")
+	ErrTracingSampler   = errors.New("tracing.sampler.type must be always_on, always_off, traceidratio, parentbased_always_on, parentbased_always_off or parentbased_traceidratio")
+	ErrTracingRatio     = errors.New("tracing.sampler.ratio must be between 0 and 1")
+	ErrTracingPropagate = errors.New("tracing.propagators must be tracecontext, baggage, b3 or b3multi")
+	ErrTracingTLS       = errors.New("tracing.tls.cert_file and tracing.tls.key_file must be set together")
+)
+
+// Tracing exports spans over OTLP/HTTP. Their trace IDs are attached to
+// the duration histograms as exemplars.
+type Tracing struct {
+	Enabled bool `json:"enabled"`
+	// OTLPEndpoint is host:port or a URL, http:// sends in plain text
+	OTLPEndpoint string `json:"otlp_endpoint"`
+	// Redaction is how peer keys and node names appear in span attributes
+	Redaction string       `json:"redaction" jsonschema:"enum=hash|none|omit"`
+	Sampler   TraceSampler `json:"sampler"`
+	// Propagators are the header formats trace context is read from and
+	// written to, tracecontext and baggage by default
+	Propagators []string `json:"propagators" jsonschema:"enum=tracecontext|baggage|b3|b3multi"`
+	// Headers are sent with every export, e.g. the API key of a hosted
+	// backend
+	Headers map[string]string `json:"headers"`
+	TLS     TracingTLS        `json:"tls"`
+}
+
+// TraceSampler decides which traces are recorded. Ratio applies to the
+// traceidratio samplers, always_off records nothing rather than a ratio
+// of 0, which is taken as unset.
+type TraceSampler struct {
+	Type  string  `json:"type" jsonschema:"enum=always_on|always_off|traceidratio|parentbased_always_on|parentbased_always_off|parentbased_traceidratio"`
+	Ratio float64 `json:"ratio" jsonschema:"minimum=0,maximum=1"`
+}
+
+// TracingTLS secures the connection to an https OTLP endpoint. CertFile and
+// KeyFile authenticate kubewg to collectors requiring client certificates.
+type TracingTLS struct {
+	CAFile             string `json:"ca_file"`
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+func (t *Tracing) applyDefaults() {
+	if t.Redaction == "" {
+		t.Redaction = RedactHash
+	}
+	if t.Sampler.Type == "" {
+		t.Sampler.Type = DefaultTracingSampler
+	}
+	if t.Sampler.Ratio == 0 {
+		t.Sampler.Ratio = DefaultTracingSamplingRatio
+	}
+	if len(t.Propagators) == 0 {
+		t.Propagators = []string{PropagateTraceContext, PropagateBaggage}
+	}
+}
+
+func (t *Tracing) validate() error {
+	switch t.Redaction {
+	case "", RedactHash, RedactNone, RedactOmit:
+	default:
+		return fmt.Errorf("%w: %q", ErrTracingRedaction, t.Redaction)
+	}
+	switch t.Sampler.Type {
+	case "", SamplerAlwaysOn, SamplerAlwaysOff, SamplerParentAlwaysOn, SamplerParentAlwaysOff, SamplerRatio, SamplerParentRatio:
+	default:
+		return fmt.Errorf("%w: %q", ErrTracingSampler, t.Sampler.Type)
+	}
+	if t.Sampler.Ratio < 0 || t.Sampler.Ratio > 1 {
+		return ErrTracingRatio
+	}
+	for _, propagator := range t.Propagators {
+		switch propagator {
+		case PropagateTraceContext, PropagateBaggage, PropagateB3, PropagateB3Multi:
+		default:
+			return fmt.Errorf("%w: %q", ErrTracingPropagate, propagator)
+		}
+	}
+	if (t.TLS.CertFile == "") != (t.TLS.KeyFile == "") {
+		return ErrTracingTLS
+	}
+	return nil
+}
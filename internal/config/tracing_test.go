@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+func TestTracingValidation(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		tracing config.Tracing
+		err     error
+	}{
+		{name: "defaults"},
+		{
+			name: "ratio",
+			tracing: config.Tracing{
+				Sampler:     config.TraceSampler{Type: config.SamplerParentRatio, Ratio: 0.1},
+				Propagators: []string{config.PropagateB3, config.PropagateTraceContext},
+			},
+		},
+		{
+			name:    "unknown sampler",
+			tracing: config.Tracing{Sampler: config.TraceSampler{Type: "sometimes"}},
+			err:     config.ErrTracingSampler,
+		},
+		{
+			name:    "ratio above 1",
+			tracing: config.Tracing{Sampler: config.TraceSampler{Type: config.SamplerRatio, Ratio: 2}},
+			err:     config.ErrTracingRatio,
+		},
+		{
+			name:    "unknown propagator",
+			tracing: config.Tracing{Propagators: []string{"jaeger"}},
+			err:     config.ErrTracingPropagate,
+		},
+		{
+			name:    "certificate without key",
+			tracing: config.Tracing{TLS: config.TracingTLS{CertFile: "/etc/kubewg/tracing.crt"}},
+			err:     config.ErrTracingTLS,
+		},
+		{
+			name:    "unknown redaction",
+			tracing: config.Tracing{Redaction: "partial"},
+			err:     config.ErrTracingRedaction,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			c := &config.Config{Tracing: tt.tracing}
+			err := c.Complete()
+			if tt.err == nil && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if tt.err != nil && !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestTracingDefaults(t *testing.T) {
+	t.Parallel()
+
+	c := &config.Config{}
+	if err := c.Complete(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if c.Tracing.Sampler.Type != config.DefaultTracingSampler || c.Tracing.Sampler.Ratio != 1 {
+		t.Errorf("expected the parent based sampler recording everything, got %+v", c.Tracing.Sampler)
+	}
+	if !slices.Equal(c.Tracing.Propagators, []string{config.PropagateTraceContext, config.PropagateBaggage}) {
+		t.Errorf("expected W3C propagation, got %v", c.Tracing.Propagators)
+	}
+	if c.Tracing.Redaction != config.RedactHash {
+		t.Errorf("expected hashed peer keys, got %q", c.Tracing.Redaction)
+	}
+}
@@ -0,0 +1,169 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var (
+	ErrDuration = errors.New("durations must be a number of seconds or a Go duration like 30s or 5m")
+	ErrByteSize = errors.New("sizes must be a number of bytes or a quantity like 64Mi or 1G")
+)
+
+// Duration is a config duration, written as a Go duration such as 30s,
+// 5m or 1h30m. A bare number is seconds, as older options count them.
+type Duration time.Duration
+
+// Std returns the duration as a time.Duration.
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Set parses a flag value.
+func (d *Duration) Set(s string) error {
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		return d.setSeconds(seconds)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil || parsed < 0 {
+		return fmt.Errorf("%w: %q", ErrDuration, s)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d *Duration) setSeconds(seconds float64) error {
+	if math.IsNaN(seconds) || math.IsInf(seconds, 0) || seconds < 0 || seconds > float64(1<<63-1)/float64(time.Second) {
+		return fmt.Errorf("%w: %v", ErrDuration, seconds)
+	}
+	*d = Duration(seconds * float64(time.Second))
+	return nil
+}
+
+// Type names the flag value in usage.
+func (d *Duration) Type() string {
+	return "duration"
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		return d.Set(s)
+	}
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err != nil {
+		return fmt.Errorf("%w: %s", ErrDuration, data)
+	}
+	return d.setSeconds(seconds)
+}
+
+// ByteSize is a config size in bytes, written as a Kubernetes quantity
+// such as 64Mi or 1G, or a bare number of bytes.
+type ByteSize int64
+
+func (b ByteSize) String() string {
+	return resource.NewQuantity(int64(b), resource.BinarySI).String()
+}
+
+// Set parses a flag value.
+func (b *ByteSize) Set(s string) error {
+	quantity, err := resource.ParseQuantity(s)
+	if err != nil || quantity.Sign() < 0 {
+		return fmt.Errorf("%w: %q", ErrByteSize, s)
+	}
+	// Value rounds up, fractions of a byte are refused instead
+	value := quantity.Value()
+	if resource.NewQuantity(value, resource.BinarySI).Cmp(quantity) != 0 {
+		return fmt.Errorf("%w: %q", ErrByteSize, s)
+	}
+	*b = ByteSize(value)
+	return nil
+}
+
+// Type names the flag value in usage.
+func (b *ByteSize) Type() string {
+	return "size"
+}
+
+func (b ByteSize) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.String())
+}
+
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		return b.Set(s)
+	}
+	return b.Set(string(data))
+}
+
+// durationFlag defines a Duration flag with the given default.
+func durationFlag(flags *pflag.FlagSet, name string, value time.Duration, usage string) {
+	d := Duration(value)
+	flags.Var(&d, name, usage)
+}
+
+func getDuration(flags *pflag.FlagSet, name string) (Duration, error) {
+	flag := flags.Lookup(name)
+	if flag == nil {
+		return 0, fmt.Errorf("flag accessed but not defined: %s", name)
+	}
+	value, ok := flag.Value.(*Duration)
+	if !ok {
+		return 0, fmt.Errorf("flag %s is not a duration", name)
+	}
+	return *value, nil
+}
+
+func getByteSize(flags *pflag.FlagSet, name string) (ByteSize, error) {
+	flag := flags.Lookup(name)
+	if flag == nil {
+		return 0, fmt.Errorf("flag accessed but not defined: %s", name)
+	}
+	value, ok := flag.Value.(*ByteSize)
+	if !ok {
+		return 0, fmt.Errorf("flag %s is not a size", name)
+	}
+	return *value, nil
+}
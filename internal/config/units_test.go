@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+func TestDuration(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]time.Duration{
+		`"30s"`:    30 * time.Second,
+		`"1h30m"`:  90 * time.Minute,
+		`"250ms"`:  250 * time.Millisecond,
+		`"10"`:     10 * time.Second,
+		`5`:        5 * time.Second,
+		`1.5`:      1500 * time.Millisecond,
+		`0`:        0,
+		`"0s"`:     0,
+		`"0.5"`:    500 * time.Millisecond,
+		`"2h"`:     2 * time.Hour,
+		`"1m0.5s"`: time.Minute + 500*time.Millisecond,
+	}
+	for input, expected := range tests {
+		var d config.Duration
+		if err := json.Unmarshal([]byte(input), &d); err != nil {
+			t.Errorf("expected %s to parse, got %v", input, err)
+			continue
+		}
+		if d.Std() != expected {
+			t.Errorf("expected %s to be %s, got %s", input, expected, d)
+		}
+	}
+
+	for _, input := range []string{`"soon"`, `"-5s"`, `-1`, `true`, `"5 minutes"`, `"NaN"`, `"Inf"`, `"-Inf"`, `"+Inf"`} {
+		var d config.Duration
+		if err := json.Unmarshal([]byte(input), &d); !errors.Is(err, config.ErrDuration) {
+			t.Errorf("expected %v for %s, got %v", config.ErrDuration, input, err)
+		}
+	}
+
+	data, err := json.Marshal(config.Duration(90 * time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `"1m30s"` {
+		t.Errorf(`expected "1m30s", got %s`, data)
+	}
+}
+
+func TestByteSize(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]config.ByteSize{
+		`"64Mi"`:  64 << 20,
+		`"1Gi"`:   1 << 30,
+		`"1G"`:    1_000_000_000,
+		`"512k"`:  512_000,
+		`"1.5Ki"`: 1536,
+		`"100"`:   100,
+		`1048576`: 1 << 20,
+		`0`:       0,
+	}
+	for input, expected := range tests {
+		var b config.ByteSize
+		if err := json.Unmarshal([]byte(input), &b); err != nil {
+			t.Errorf("expected %s to parse, got %v", input, err)
+			continue
+		}
+		if b != expected {
+			t.Errorf("expected %s to be %d, got %d", input, expected, b)
+		}
+	}
+
+	for _, input := range []string{`"lots"`, `"-1Mi"`, `"0.5"`, `"1MB"`, `true`} {
+		var b config.ByteSize
+		if err := json.Unmarshal([]byte(input), &b); !errors.Is(err, config.ErrByteSize) {
+			t.Errorf("expected %v for %s, got %v", config.ErrByteSize, input, err)
+		}
+	}
+
+	data, err := json.Marshal(config.ByteSize(64 << 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `"64Mi"` {
+		t.Errorf(`expected "64Mi", got %s`, data)
+	}
+}
+
+func TestUnitFlags(t *testing.T) {
+	t.Parallel()
+
+	cmd := newLoadCommand(t, "", "")
+	for key, value := range map[string]string{
+		config.APIShutdownKey: "1m",
+		config.APIDrainKey:    "15",
+		config.APIMaxBodyKey:  "64Mi",
+	} {
+		if err := cmd.Flags().Set(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := config.LoadConfig(cmd)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if c.API.ShutdownTimeout.Std() != time.Minute || c.API.DrainDelay.Std() != 15*time.Second {
+		t.Errorf("expected 1m and 15s, got %s and %s", c.API.ShutdownTimeout, c.API.DrainDelay)
+	}
+	if c.API.Limits.MaxBodyBytes != 64<<20 {
+		t.Errorf("expected 64Mi, got %s", c.API.Limits.MaxBodyBytes)
+	}
+	if c.Metrics.ShutdownTimeout.Std() != config.DefaultShutdownTimeout {
+		t.Errorf("expected the default shutdown timeout, got %s", c.Metrics.ShutdownTimeout)
+	}
+}
@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	DefaultWebhookTimeout = 10 * time.Second
+	DefaultWebhookRetries = 5
+	DefaultWebhookBackoff = time.Second
+)
+
+var (
+	ErrWebhookURLs = errors.New("webhooks require at least one http or https URL")
+	ErrWebhookDeps = errors.New("webhooks require wireguard")
+)
+
+// Webhooks posts peer events, such as peers joining and leaving, lost
+// handshakes and key rotations, as JSON to HTTP endpoints.
+type Webhooks struct {
+	Enabled bool     `json:"enabled"`
+	URLs    []string `json:"urls"`
+	// Secret signs every body with HMAC-SHA256, sent in the
+	// X-KubeWG-Signature header
+	Secret string `json:"secret"`
+	// Events limits the event types sent, all are sent when empty
+	Events []string `json:"events"`
+	// Timeout of a single delivery attempt
+	Timeout Duration `json:"timeout"`
+	// Retries after a failed attempt, with Backoff before the first retry,
+	// doubling for every further one
+	Retries int      `json:"retries"`
+	Backoff Duration `json:"backoff"`
+}
+
+func (w *Webhooks) applyDefaults() {
+	if w.Timeout == 0 {
+		w.Timeout = Duration(DefaultWebhookTimeout)
+	}
+	if w.Retries == 0 {
+		w.Retries = DefaultWebhookRetries
+	}
+	if w.Backoff == 0 {
+		w.Backoff = Duration(DefaultWebhookBackoff)
+	}
+}
+
+func (w *Webhooks) validate(wireguard bool) error {
+	if !w.Enabled {
+		return nil
+	}
+	if !wireguard {
+		return ErrWebhookDeps
+	}
+	if len(w.URLs) == 0 {
+		return ErrWebhookURLs
+	}
+	for _, raw := range w.URLs {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			// The URL itself may carry a secret, as with Slack
+			return fmt.Errorf("%w: invalid URL", ErrWebhookURLs)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package connect
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/kubewg-net/container/internal/api"
+	"github.com/kubewg-net/container/internal/enroll"
+)
+
+var (
+	ErrAPIResponse = errors.New("API request failed")
+	// ErrTokenRejected means the enrollment token is unknown, used or
+	// expired
+	ErrTokenRejected = errors.New("enrollment token rejected by the server")
+	// ErrLeaseRejected means the server no longer knows the lease, the
+	// client has to enroll again with a new token
+	ErrLeaseRejected = errors.New("lease rejected by the server")
+	// ErrIDTokenRejected means the server didn't accept the identity the
+	// client logged in with
+	ErrIDTokenRejected = errors.New("ID token rejected by the server")
+)
+
+// Client calls the enrollment endpoints of a cluster's API. They are
+// authenticated by the enrollment and lease tokens, not by an API token.
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		http:    httpClient,
+	}
+}
+
+// URL returns the base URL of the API.
+func (c *Client) URL() string {
+	return c.baseURL
+}
+
+// Enroll registers publicKey with a one-time enrollment token.
+func (c *Client) Enroll(ctx context.Context, token, publicKey string) (*api.EnrollResponse, error) {
+	var resp api.EnrollResponse
+	err := c.post(ctx, "/api/v1/enroll", api.EnrollRequest{Token: token, PublicKey: publicKey}, &resp, ErrTokenRejected)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// OIDCConfig asks the server where to log in for OIDC enrollment.
+func (c *Client) OIDCConfig(ctx context.Context) (*api.OIDCConfig, error) {
+	var oidcConfig api.OIDCConfig
+	if err := c.do(ctx, http.MethodGet, "/api/v1/enroll/oidc", nil, &oidcConfig, ErrAPIResponse); err != nil {
+		return nil, err
+	}
+	return &oidcConfig, nil
+}
+
+// EnrollOIDC registers publicKey with an ID token of the server's issuer.
+func (c *Client) EnrollOIDC(ctx context.Context, idToken, publicKey string) (*api.EnrollResponse, error) {
+	var resp api.EnrollResponse
+	err := c.post(ctx, "/api/v1/enroll/oidc", api.OIDCEnrollRequest{IDToken: idToken, PublicKey: publicKey}, &resp, ErrIDTokenRejected)
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Renew extends the lease of publicKey.
+func (c *Client) Renew(ctx context.Context, publicKey, leaseToken string) (*enroll.Lease, error) {
+	var lease enroll.Lease
+	err := c.post(ctx, "/api/v1/enroll/renew", api.RenewRequest{PublicKey: publicKey, LeaseToken: leaseToken}, &lease, ErrLeaseRejected)
+	if err != nil {
+		return nil, err
+	}
+	return &lease, nil
+}
+
+// Reenroll swaps publicKey for newPublicKey before the session ends.
+func (c *Client) Reenroll(ctx context.Context, publicKey, leaseToken, newPublicKey string) (*api.EnrollResponse, error) {
+	var resp api.EnrollResponse
+	req := api.ReenrollRequest{PublicKey: publicKey, LeaseToken: leaseToken, NewPublicKey: newPublicKey}
+	if err := c.post(ctx, "/api/v1/enroll/reenroll", req, &resp, ErrLeaseRejected); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// post sends body as JSON and decodes the response into out. A 401 is
+// reported as unauthorized, which tells the token apart from other failures.
+func (c *Client) post(ctx context.Context, path string, body, out interface{}, unauthorized error) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	return c.do(ctx, http.MethodPost, path, data, out, unauthorized)
+}
+
+// do sends body, when not nil, and decodes the response into out.
+func (c *Client) do(ctx context.Context, method, path string, body []byte, out interface{}, unauthorized error) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read API response: %w", err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		cause := ErrAPIResponse
+		if resp.StatusCode == http.StatusUnauthorized {
+			cause = unauthorized
+		}
+		if err := json.Unmarshal(data, &apiErr); err != nil || apiErr.Error == "" {
+			return fmt.Errorf("%w: %s", cause, resp.Status)
+		}
+		return fmt.Errorf("%w: %s: %s", cause, resp.Status, apiErr.Error)
+	}
+
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to decode API response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,372 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package connect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/kubewg-net/container/internal/api"
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/oidc"
+	"github.com/kubewg-net/container/internal/wgquick"
+	"github.com/kubewg-net/container/internal/wireguard"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const (
+	// DefaultKeepalive holds NAT mappings open on the way to the cluster
+	// when the config has no keepalive of its own
+	DefaultKeepalive = 25 * time.Second
+	// minRenewInterval keeps a lease that is about to run out from being
+	// renewed in a tight loop
+	minRenewInterval = 30 * time.Second
+	// retryInterval is how long to wait after a failed renewal
+	retryInterval = time.Minute
+	// reenrollBefore is how long before the end of its session the client
+	// re-enrolls with a new key
+	reenrollBefore = 5 * time.Minute
+)
+
+var (
+	ErrNoToken      = errors.New("an enrollment token or an OIDC login is required without a session")
+	ErrOtherCluster = errors.New("the session belongs to another API")
+	ErrNoServerPeer = errors.New("the enrolled config has no peer")
+	ErrInvalidRoute = errors.New("invalid route")
+
+	// errReenrolled ends a connection so it comes back up with the new key
+	errReenrolled = errors.New("re-enrolled with a new key")
+)
+
+type Options struct {
+	InterfaceName string
+	// Userspace is passed on to the device, see config.WireGuard
+	Userspace string
+	// SessionPath is where the key and the enrolled config are kept
+	SessionPath string
+	// Token enrolls the client when there is no session yet
+	Token string
+	// OIDC enrolls the client by logging in with the server's identity
+	// provider instead of with a token, showing the user what to do
+	// through Prompt
+	OIDC   bool
+	Prompt func(*oidc.DeviceCode)
+	// IdentityClient talks to the identity provider, http.DefaultClient
+	// when nil
+	IdentityClient *http.Client
+	// Routes are CIDRs reached through the cluster on top of the ones the
+	// server sent
+	Routes []string
+}
+
+// Run enrolls unless there is a session already, brings up the interface
+// and renews the lease until ctx is done, then takes the interface down.
+// Before a session ends, the interface is brought up again with the key it
+// re-enrolled with.
+func Run(ctx context.Context, client *Client, opts *Options) error {
+	session, err := LoadSession(opts.SessionPath)
+	if err != nil {
+		return err
+	}
+	if session != nil && session.APIURL != client.URL() {
+		return fmt.Errorf("%w: %s", ErrOtherCluster, session.APIURL)
+	}
+	if session == nil {
+		if session, err = enrollSession(ctx, client, opts); err != nil {
+			return err
+		}
+	} else if opts.Token != "" || opts.OIDC {
+		slog.Info("Reusing the saved session, not enrolling again", "session", opts.SessionPath)
+	}
+
+	for {
+		err := connect(ctx, client, session, opts)
+		if !errors.Is(err, errReenrolled) {
+			return err
+		}
+	}
+}
+
+// connect brings up the interface of session, points the interface's DNS
+// at the cluster when the config names servers and keeps it alive.
+func connect(ctx context.Context, client *Client, session *Session, opts *Options) error {
+	wg, err := interfaceConfig(session, opts)
+	if err != nil {
+		return err
+	}
+	peers, err := peerConfigs(wg.Peers)
+	if err != nil {
+		return err
+	}
+
+	device := wireguard.NewDevice(wg)
+	if err := device.Up(ctx); err != nil {
+		return err
+	}
+	defer func() {
+		if err := device.Down(); err != nil {
+			slog.Error("Failed to take down the interface", "name", device.Name(), "error", err.Error())
+		}
+	}()
+	if err := device.ConfigurePeers(peers); err != nil {
+		return err
+	}
+	if len(wg.DNS) != 0 {
+		servers, domains := wgquick.SplitDNS(wg.DNS)
+		if err := setDNS(device.Name(), servers, domains); err != nil {
+			slog.Warn("Failed to configure DNS, names in the cluster may not resolve", "interface", device.Name(), "error", err.Error())
+		} else {
+			defer func() {
+				if err := revertDNS(device.Name()); err != nil {
+					slog.Warn("Failed to revert DNS", "interface", device.Name(), "error", err.Error())
+				}
+			}()
+		}
+	}
+	slog.Info("Connected", "interface", device.Name(), "addresses", wg.Addresses, "routes", peers[0].AllowedIPs, "dns", wg.DNS)
+
+	return keepAlive(ctx, client, session, opts.SessionPath, device.PublicKey().String())
+}
+
+// reenroll swaps the session's key for a new one and saves it.
+func reenroll(ctx context.Context, client *Client, session *Session, path, publicKey string) error {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate private key: %w", err)
+	}
+	resp, err := client.Reenroll(ctx, publicKey, session.LeaseToken, key.PublicKey().String())
+	if err != nil {
+		return err
+	}
+
+	session.PrivateKey = key.String()
+	session.Config = resp.Config
+	session.LeaseToken = resp.LeaseToken
+	session.LeaseExpiresAt = resp.LeaseExpiresAt
+	session.SessionExpiresAt = resp.SessionExpiresAt
+	if err := session.Save(path); err != nil {
+		return err
+	}
+	slog.Info("Re-enrolled", "public_key", key.PublicKey().String(), "session_expires_at", resp.SessionExpiresAt)
+	return nil
+}
+
+func enrollSession(ctx context.Context, client *Client, opts *Options) (*Session, error) {
+	if opts.Token == "" && !opts.OIDC {
+		return nil, ErrNoToken
+	}
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key: %w", err)
+	}
+
+	var resp *api.EnrollResponse
+	if opts.OIDC {
+		resp, err = enrollOIDC(ctx, client, opts, key.PublicKey().String())
+	} else {
+		resp, err = client.Enroll(ctx, opts.Token, key.PublicKey().String())
+	}
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{
+		APIURL:           client.URL(),
+		PrivateKey:       key.String(),
+		Config:           resp.Config,
+		LeaseToken:       resp.LeaseToken,
+		LeaseExpiresAt:   resp.LeaseExpiresAt,
+		SessionExpiresAt: resp.SessionExpiresAt,
+	}
+	if err := session.Save(opts.SessionPath); err != nil {
+		return nil, err
+	}
+	slog.Info("Enrolled", "public_key", key.PublicKey().String(), "addresses", resp.Addresses)
+	return session, nil
+}
+
+// enrollOIDC logs in with the device flow at the identity provider the
+// server names and enrolls with the ID token.
+func enrollOIDC(ctx context.Context, client *Client, opts *Options, publicKey string) (*api.EnrollResponse, error) {
+	oidcConfig, err := client.OIDCConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := oidc.Discover(ctx, identityClient(opts), oidcConfig.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	idToken, err := oidc.DeviceLogin(ctx, identityClient(opts), provider, oidcConfig.ClientID, oidcConfig.Scopes, opts.Prompt)
+	if err != nil {
+		return nil, err
+	}
+	return client.EnrollOIDC(ctx, idToken, publicKey)
+}
+
+func identityClient(opts *Options) *http.Client {
+	if opts.IdentityClient != nil {
+		return opts.IdentityClient
+	}
+	return http.DefaultClient
+}
+
+// interfaceConfig builds the interface from the enrolled config, adding
+// the extra routes to the server peer.
+func interfaceConfig(session *Session, opts *Options) (*config.WireGuard, error) {
+	file, err := wgquick.Parse(strings.NewReader(session.Config))
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Peers) == 0 {
+		return nil, ErrNoServerPeer
+	}
+
+	wg := &config.WireGuard{
+		InterfaceName: opts.InterfaceName,
+		Userspace:     opts.Userspace,
+		PrivateKey:    session.PrivateKey,
+	}
+	wg.ApplyWGQuick(file)
+
+	// The server is the only peer of an enrolled client
+	server := &wg.Peers[0]
+	for _, route := range opts.Routes {
+		prefix, err := netip.ParsePrefix(route)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %w", ErrInvalidRoute, route, err)
+		}
+		server.AllowedIPs = append(server.AllowedIPs, prefix.Masked().String())
+	}
+	return wg, nil
+}
+
+func peerConfigs(peers []config.WireGuardPeer) ([]wgtypes.PeerConfig, error) {
+	configs := make([]wgtypes.PeerConfig, 0, len(peers))
+	for _, peer := range peers {
+		publicKey, err := wgtypes.ParseKey(peer.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid public key %q: %w", peer.PublicKey, err)
+		}
+
+		allowedIPs := make([]net.IPNet, 0, len(peer.AllowedIPs))
+		for _, cidr := range peer.AllowedIPs {
+			_, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid allowed IP %q for peer %s: %w", cidr, peer.PublicKey, err)
+			}
+			allowedIPs = append(allowedIPs, *ipNet)
+		}
+
+		keepalive := DefaultKeepalive
+		if peer.PersistentKeepalive != 0 {
+			keepalive = time.Duration(peer.PersistentKeepalive) * time.Second
+		}
+		peerConfig := wgtypes.PeerConfig{
+			PublicKey:                   publicKey,
+			ReplaceAllowedIPs:           true,
+			AllowedIPs:                  allowedIPs,
+			PersistentKeepaliveInterval: &keepalive,
+		}
+
+		if peer.PresharedKey != "" {
+			presharedKey, err := wgtypes.ParseKey(peer.PresharedKey)
+			if err != nil {
+				return nil, fmt.Errorf("invalid preshared key for peer %s: %w", peer.PublicKey, err)
+			}
+			peerConfig.PresharedKey = &presharedKey
+		}
+		if peer.Endpoint != "" {
+			peerConfig.Endpoint, err = net.ResolveUDPAddr("udp", peer.Endpoint)
+			if err != nil {
+				return nil, fmt.Errorf("invalid endpoint %q for peer %s: %w", peer.Endpoint, peer.PublicKey, err)
+			}
+		}
+		configs = append(configs, peerConfig)
+	}
+	return configs, nil
+}
+
+// keepAlive renews the lease halfway to its expiry until ctx is done. The
+// server decides when a lease is gone for good, so failed renewals are
+// retried until it rejects the lease. Without a lease there is nothing to
+// renew and it just waits. Close to the end of the session it re-enrolls
+// instead and returns errReenrolled once the new key is saved.
+func keepAlive(ctx context.Context, client *Client, session *Session, path, publicKey string) error {
+	if session.LeaseToken == "" || session.LeaseExpiresAt == nil {
+		<-ctx.Done()
+		return nil
+	}
+
+	wait := untilReenroll(session, renewWait(*session.LeaseExpiresAt))
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+
+		if untilReenroll(session, wait) == 0 {
+			err := reenroll(ctx, client, session, path, publicKey)
+			if err == nil {
+				return errReenrolled
+			} else if errors.Is(err, ErrLeaseRejected) {
+				return err
+			}
+			slog.Warn("Failed to re-enroll", "session_expires_at", *session.SessionExpiresAt, "error", err.Error())
+			wait = retryInterval
+			continue
+		}
+
+		lease, err := client.Renew(ctx, publicKey, session.LeaseToken)
+		if errors.Is(err, ErrLeaseRejected) {
+			return err
+		} else if err != nil {
+			slog.Warn("Failed to renew the lease", "expires_at", *session.LeaseExpiresAt, "error", err.Error())
+			wait = retryInterval
+			continue
+		}
+
+		session.LeaseExpiresAt = &lease.ExpiresAt
+		if err := session.Save(path); err != nil {
+			slog.Warn("Failed to save the session", "error", err.Error())
+		}
+		slog.Debug("Renewed the lease", "expires_at", lease.ExpiresAt)
+		wait = untilReenroll(session, renewWait(lease.ExpiresAt))
+	}
+}
+
+func renewWait(expiresAt time.Time) time.Duration {
+	return max(time.Until(expiresAt)/2, minRenewInterval)
+}
+
+// untilReenroll shortens wait to the time left before session has to
+// re-enroll, which is 0 once it is due.
+func untilReenroll(session *Session, wait time.Duration) time.Duration {
+	if session.SessionExpiresAt == nil {
+		return wait
+	}
+	return min(wait, max(time.Until(session.SessionExpiresAt.Add(-reenrollBefore)), 0))
+}
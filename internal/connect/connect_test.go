@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package connect_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/api"
+	"github.com/kubewg-net/container/internal/connect"
+	"github.com/kubewg-net/container/internal/enroll"
+)
+
+func TestSessionRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "state", "connect.json")
+	session, err := connect.LoadSession(path)
+	if err != nil || session != nil {
+		t.Fatalf("expected no session and no error for a missing file, got %v, %v", session, err)
+	}
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	saved := &connect.Session{
+		APIURL:         "https://kubewg.example.com",
+		PrivateKey:     "private",
+		Config:         "[Interface]\n",
+		LeaseToken:     "lease",
+		LeaseExpiresAt: &expiresAt,
+	}
+	if err := saved.Save(path); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat session: %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Errorf("expected the session to be private, got mode %o", mode)
+	}
+
+	session, err = connect.LoadSession(path)
+	if err != nil {
+		t.Fatalf("failed to load session: %v", err)
+	}
+	if session.PrivateKey != "private" || session.LeaseToken != "lease" || !session.LeaseExpiresAt.Equal(expiresAt) {
+		t.Errorf("expected the saved session, got %+v", session)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"api_url":"x"}`), 0o600); err != nil {
+		t.Fatalf("failed to write session: %v", err)
+	}
+	if _, err := connect.LoadSession(path); !errors.Is(err, connect.ErrInvalidSession) {
+		t.Errorf("expected ErrInvalidSession without a key, got %v", err)
+	}
+}
+
+func TestClient(t *testing.T) {
+	t.Parallel()
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/enroll", func(w http.ResponseWriter, r *http.Request) {
+		var req api.EnrollRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token != "good" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid enrollment token"}`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(api.EnrollResponse{
+			Addresses:      []string{"10.0.0.5/32"},
+			Config:         "[Interface]\n",
+			LeaseToken:     "lease",
+			LeaseExpiresAt: &expiresAt,
+		})
+	})
+	mux.HandleFunc("POST /api/v1/enroll/renew", func(w http.ResponseWriter, r *http.Request) {
+		var req api.RenewRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.LeaseToken != "lease" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid lease"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(enroll.Lease{PublicKey: req.PublicKey, ExpiresAt: expiresAt})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := connect.NewClient(server.URL+"/", server.Client())
+	if client.URL() != server.URL {
+		t.Errorf("expected the trailing slash to be trimmed, got %s", client.URL())
+	}
+
+	resp, err := client.Enroll(context.Background(), "good", "key")
+	if err != nil {
+		t.Fatalf("failed to enroll: %v", err)
+	}
+	if resp.LeaseToken != "lease" || len(resp.Addresses) != 1 {
+		t.Errorf("expected a lease and an address, got %+v", resp)
+	}
+	if _, err := client.Enroll(context.Background(), "bad", "key"); !errors.Is(err, connect.ErrTokenRejected) {
+		t.Errorf("expected ErrTokenRejected, got %v", err)
+	}
+
+	lease, err := client.Renew(context.Background(), "key", "lease")
+	if err != nil {
+		t.Fatalf("failed to renew: %v", err)
+	}
+	if !lease.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("expected the lease to expire at %s, got %s", expiresAt, lease.ExpiresAt)
+	}
+	if _, err := client.Renew(context.Background(), "key", "stale"); !errors.Is(err, connect.ErrLeaseRejected) {
+		t.Errorf("expected ErrLeaseRejected, got %v", err)
+	}
+}
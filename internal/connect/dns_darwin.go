@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package connect
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// setDNS publishes a resolver for the interface in the dynamic store, the
+// search domains also being the ones it answers for.
+func setDNS(iface string, servers []netip.Addr, domains []string) error {
+	var script strings.Builder
+	script.WriteString("d.init\n")
+	if len(servers) != 0 {
+		script.WriteString("d.add ServerAddresses *")
+		for _, server := range servers {
+			script.WriteString(" " + server.String())
+		}
+		script.WriteString("\n")
+	}
+	if len(domains) != 0 {
+		fmt.Fprintf(&script, "d.add SearchDomains * %s\n", strings.Join(domains, " "))
+		fmt.Fprintf(&script, "d.add SupplementalMatchDomains * %s\n", strings.Join(domains, " "))
+	}
+	fmt.Fprintf(&script, "set %s\n", dnsKey(iface))
+	return run(script.String(), "scutil")
+}
+
+func revertDNS(iface string) error {
+	return run(fmt.Sprintf("remove %s\n", dnsKey(iface)), "scutil")
+}
+
+func dnsKey(iface string) string {
+	return "State:/Network/Service/kubewg-" + iface + "/DNS"
+}
@@ -0,0 +1,44 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package connect
+
+import "net/netip"
+
+// setDNS hands the servers and search domains to systemd-resolved for the
+// interface alone, so only lookups routed to it go to the cluster.
+func setDNS(iface string, servers []netip.Addr, domains []string) error {
+	if len(servers) != 0 {
+		args := []string{"dns", iface}
+		for _, server := range servers {
+			args = append(args, server.String())
+		}
+		if err := run("", "resolvectl", args...); err != nil {
+			return err
+		}
+	}
+	if len(domains) != 0 {
+		return run("", "resolvectl", append([]string{"domain", iface}, domains...)...)
+	}
+	return nil
+}
+
+func revertDNS(iface string) error {
+	return run("", "resolvectl", "revert", iface)
+}
@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package connect
+
+import (
+	"log/slog"
+	"net/netip"
+)
+
+// setDNS sets the servers on the adapter with netsh. netsh has no search
+// domains, so those are left to the system.
+func setDNS(iface string, servers []netip.Addr, domains []string) error {
+	set := map[string]bool{}
+	for _, server := range servers {
+		family := dnsFamily(server)
+		if set[family] {
+			if err := run("", "netsh", "interface", family, "add", "dnsservers", "name="+iface,
+				"address="+server.String(), "validate=no"); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := run("", "netsh", "interface", family, "set", "dnsservers", "name="+iface,
+			"source=static", "address="+server.String(), "register=none", "validate=no"); err != nil {
+			return err
+		}
+		set[family] = true
+	}
+	if len(domains) != 0 {
+		slog.Warn("Search domains are not set on Windows, use fully qualified names", "domains", domains)
+	}
+	return nil
+}
+
+func revertDNS(iface string) error {
+	for _, family := range []string{"ipv4", "ipv6"} {
+		if err := run("", "netsh", "interface", family, "set", "dnsservers", "name="+iface, "source=dhcp"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dnsFamily(addr netip.Addr) string {
+	if addr.Unmap().Is4() {
+		return "ipv4"
+	}
+	return "ipv6"
+}
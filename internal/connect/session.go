@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package connect runs a workstation outside the cluster as an enrolled
+// client: it enrolls through the API, brings up a local interface with the
+// config it gets back and renews its lease until it is stopped.
+package connect
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var ErrInvalidSession = errors.New("invalid session file")
+
+// Session is what a client keeps between runs, so it only needs a token to
+// enroll the first time.
+type Session struct {
+	APIURL     string `json:"api_url"`
+	PrivateKey string `json:"private_key"`
+	// Config is the wg-quick config the server answered with, holding a
+	// placeholder for PrivateKey
+	Config         string     `json:"config"`
+	LeaseToken     string     `json:"lease_token,omitempty"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at,omitempty"`
+	// SessionExpiresAt is when the server stops renewing the lease, the
+	// client re-enrolls with a new key before then
+	SessionExpiresAt *time.Time `json:"session_expires_at,omitempty"`
+}
+
+// LoadSession reads the session at path. A missing file returns a nil
+// session without an error.
+func LoadSession(path string) (*Session, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read session: %w", err)
+	}
+
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSession, err)
+	}
+	if session.PrivateKey == "" || session.Config == "" {
+		return nil, fmt.Errorf("%w: missing private key or config", ErrInvalidSession)
+	}
+	return &session, nil
+}
+
+// Save writes the session to path, readable by its owner only since it
+// holds the private key.
+func (s *Session) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal session: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create session directory: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write session: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write session: %w", err)
+	}
+	return nil
+}
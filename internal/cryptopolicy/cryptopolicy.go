@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package cryptopolicy applies the configured crypto policy to the admin
+// API: the TLS versions, cipher suites and curves it accepts, the hash its
+// bearer tokens are kept under, and the certificates it may serve. The
+// clients of Vault, federation remotes, the OTLP collector and webhooks
+// connect under the same TLS settings. It also reports whether the binary
+// was built against BoringCrypto.
+package cryptopolicy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+// Crypto modules
+const (
+	ModuleBoring = "boringcrypto"
+	ModuleGo     = "go"
+)
+
+// minRSABits is the smallest RSA key FIPS 186 allows for signatures
+const minRSABits = 2048
+
+var (
+	ErrNotBoring   = errors.New("the fips crypto policy requires a binary built with GOEXPERIMENT=boringcrypto")
+	ErrCertificate = errors.New("the API certificate key is not FIPS approved, use RSA of at least 2048 bits or ECDSA on P-256, P-384 or P-521")
+)
+
+//nolint:golint,gochecknoglobals
+var (
+	// fipsCipherSuites are the TLS 1.2 suites approved under SP 800-52
+	// that Go implements
+	fipsCipherSuites = []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	}
+	fipsCurves = []tls.CurveID{tls.CurveP256, tls.CurveP384}
+)
+
+// Module names the crypto implementation the binary was built with.
+func Module() string {
+	if boringEnabled() {
+		return ModuleBoring
+	}
+	return ModuleGo
+}
+
+// Validate checks at startup that the binary, and the admin API's
+// certificate when it serves TLS, can honour the policy.
+func Validate(c *config.Config) error {
+	if !c.CryptoPolicy.FIPS() {
+		return nil
+	}
+	if !boringEnabled() {
+		return ErrNotBoring
+	}
+	if !c.API.Enabled || c.API.TLS.CertFile == "" {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.API.TLS.CertFile, c.API.TLS.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load API certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse API certificate: %w", err)
+	}
+	return checkKey(leaf)
+}
+
+// checkKey rejects certificate keys outside FIPS 186, Ed25519 among them
+// as BoringCrypto doesn't offer it.
+func checkKey(cert *x509.Certificate) error {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() >= minRSABits {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256(), elliptic.P384(), elliptic.P521():
+			return nil
+		}
+	}
+	return ErrCertificate
+}
+
+// TLSConfig returns the base TLS config of the admin API and of outgoing
+// connections under policy. The FIPS policy keeps TLS 1.3, whose cipher
+// suites can't be configured: BoringCrypto builds run crypto/tls in FIPS
+// only mode, which limits them to the approved AES-GCM ones.
+func TLSConfig(policy *config.CryptoPolicy) *tls.Config {
+	if !policy.FIPS() {
+		return &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+	return &tls.Config{
+		MinVersion:       tls.VersionTLS12,
+		CipherSuites:     fipsCipherSuites,
+		CurvePreferences: fipsCurves,
+	}
+}
+
+// HashToken hashes a bearer token for lookup. SHA-256 is approved under
+// every policy, and BoringCrypto builds compute it in the validated module.
+func HashToken(token string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(token))
+}
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package cryptopolicy_test
+
+import (
+	"crypto/tls"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/cryptopolicy"
+)
+
+func TestTLSConfig(t *testing.T) {
+	t.Parallel()
+
+	defaults := cryptopolicy.TLSConfig(&config.CryptoPolicy{Mode: config.CryptoDefault})
+	if defaults.MinVersion != tls.VersionTLS12 || defaults.MaxVersion != 0 || defaults.CipherSuites != nil {
+		t.Errorf("expected only a TLS 1.2 minimum by default, got %+v", defaults)
+	}
+
+	fips := cryptopolicy.TLSConfig(&config.CryptoPolicy{Mode: config.CryptoFIPS})
+	if fips.MinVersion != tls.VersionTLS12 || fips.MaxVersion != 0 {
+		t.Errorf("expected TLS 1.2 and up, got %#x to %#x", fips.MinVersion, fips.MaxVersion)
+	}
+	for _, suite := range fips.CipherSuites {
+		if slices.ContainsFunc(tls.InsecureCipherSuites(), func(s *tls.CipherSuite) bool { return s.ID == suite }) {
+			t.Errorf("expected no insecure cipher suites, got %s", tls.CipherSuiteName(suite))
+		}
+	}
+	if slices.Contains(fips.CurvePreferences, tls.X25519) {
+		t.Errorf("expected no X25519, got %v", fips.CurvePreferences)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	c := &config.Config{CryptoPolicy: config.CryptoPolicy{Mode: config.CryptoDefault}}
+	if err := cryptopolicy.Validate(c); err != nil {
+		t.Errorf("expected the default policy to be valid, got %v", err)
+	}
+
+	c.CryptoPolicy.Mode = config.CryptoFIPS
+	err := cryptopolicy.Validate(c)
+	if cryptopolicy.Module() == cryptopolicy.ModuleBoring {
+		if err != nil {
+			t.Errorf("expected the fips policy to be valid in a BoringCrypto build, got %v", err)
+		}
+	} else if !errors.Is(err, cryptopolicy.ErrNotBoring) {
+		t.Errorf("expected %v, got %v", cryptopolicy.ErrNotBoring, err)
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	t.Parallel()
+
+	if cryptopolicy.HashToken("a") == cryptopolicy.HashToken("b") {
+		t.Error("expected different tokens to hash differently")
+	}
+	if cryptopolicy.HashToken("a") != cryptopolicy.HashToken("a") {
+		t.Error("expected a token to hash the same every time")
+	}
+}
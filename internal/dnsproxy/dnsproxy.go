@@ -0,0 +1,212 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package dnsproxy forwards the DNS queries of clients that arrive over the
+// tunnel to the cluster DNS, which they can't reach on their own.
+package dnsproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/metrics"
+	"github.com/miekg/dns"
+)
+
+const resolvConfPath = "/etc/resolv.conf"
+
+var ErrNoUpstreams = errors.New("no upstream DNS servers")
+
+// Proxy serves DNS over UDP and TCP on the interface's addresses.
+type Proxy struct {
+	config    *config.DNSProxy
+	addrs     []netip.Addr
+	upstreams []string
+	servers   []*dns.Server
+}
+
+// NewProxy prepares a proxy listening on the addresses of wg, which are
+// CIDRs as configured.
+func NewProxy(proxy *config.DNSProxy, wg *config.WireGuard) (*Proxy, error) {
+	addrs, err := Addrs(wg)
+	if err != nil {
+		return nil, err
+	}
+
+	upstreams := proxy.Upstreams
+	if len(upstreams) == 0 {
+		clientConfig, err := dns.ClientConfigFromFile(resolvConfPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", resolvConfPath, err)
+		}
+		upstreams = clientConfig.Servers
+	}
+
+	p := &Proxy{config: proxy, addrs: addrs}
+	for _, upstream := range upstreams {
+		if _, _, err := net.SplitHostPort(upstream); err != nil {
+			upstream = net.JoinHostPort(upstream, "53")
+		}
+		// Forwarding to ourselves would loop until the timeout
+		if addrPort, err := netip.ParseAddrPort(upstream); err == nil && p.listensOn(addrPort) {
+			continue
+		}
+		p.upstreams = append(p.upstreams, upstream)
+	}
+	if len(p.upstreams) == 0 {
+		return nil, ErrNoUpstreams
+	}
+	return p, nil
+}
+
+// Addrs returns the IPs of wg's addresses, which the proxy listens on and
+// clients are pointed at.
+func Addrs(wg *config.WireGuard) ([]netip.Addr, error) {
+	addrs := make([]netip.Addr, 0, len(wg.Addresses))
+	for _, address := range wg.Addresses {
+		prefix, err := netip.ParsePrefix(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %w", address, err)
+		}
+		addrs = append(addrs, prefix.Addr())
+	}
+	return addrs, nil
+}
+
+// Advertise points the clients of wg at the proxy, unless the interface or
+// its network hands them a DNS server already.
+func Advertise(proxy *config.DNSProxy, wg *config.WireGuard) error {
+	if len(wg.EffectiveDNS().Value) > 0 {
+		return nil
+	}
+	addrs, err := Addrs(wg)
+	if err != nil {
+		return err
+	}
+	// wg-quick only takes a DNS server on port 53
+	if proxy.Port != config.DefaultDNSProxyPort {
+		slog.Warn("Not advertising the DNS proxy in client configs, it doesn't listen on port 53", "port", proxy.Port)
+		return nil
+	}
+
+	dnsServers := make([]string, 0, len(addrs)+len(proxy.Search))
+	for _, addr := range addrs {
+		dnsServers = append(dnsServers, addr.String())
+	}
+	wg.DNS = append(dnsServers, proxy.Search...)
+	return nil
+}
+
+func (p *Proxy) listensOn(addrPort netip.AddrPort) bool {
+	if addrPort.Port() != p.config.Port {
+		return false
+	}
+	for _, addr := range p.addrs {
+		if addr == addrPort.Addr() {
+			return true
+		}
+	}
+	return false
+}
+
+// Start listens on every address, which has to be on the interface by
+// then.
+func (p *Proxy) Start() error {
+	for _, addr := range p.addrs {
+		address := net.JoinHostPort(addr.String(), strconv.Itoa(int(p.config.Port)))
+
+		packetConn, err := net.ListenPacket("udp", address)
+		if err != nil {
+			p.shutdown(context.Background())
+			return fmt.Errorf("failed to listen on %s/udp: %w", address, err)
+		}
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			packetConn.Close()
+			p.shutdown(context.Background())
+			return fmt.Errorf("failed to listen on %s/tcp: %w", address, err)
+		}
+
+		for _, server := range []*dns.Server{
+			{PacketConn: packetConn, Handler: p},
+			{Listener: listener, Handler: p},
+		} {
+			p.servers = append(p.servers, server)
+			go func() {
+				if err := server.ActivateAndServe(); err != nil {
+					slog.Error("DNS proxy stopped", "address", address, "error", err.Error())
+				}
+			}()
+		}
+	}
+	slog.Info("DNS proxy started", "addresses", p.addrs, "port", p.config.Port, "upstreams", p.upstreams)
+	return nil
+}
+
+// Stop closes the listeners and waits for the queries in flight.
+func (p *Proxy) Stop(ctx context.Context) error {
+	if err := p.shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to stop DNS proxy: %w", err)
+	}
+	return nil
+}
+
+func (p *Proxy) shutdown(ctx context.Context) error {
+	var errs []error
+	for _, server := range p.servers {
+		errs = append(errs, server.ShutdownContext(ctx))
+	}
+	p.servers = nil
+	return errors.Join(errs...)
+}
+
+// ServeDNS forwards req to the upstreams in turn over the protocol it came
+// in on, so truncated UDP answers make the client retry over TCP as usual.
+func (p *Proxy) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	client := &dns.Client{
+		Net:     w.LocalAddr().Network(),
+		Timeout: p.config.Timeout.Std(),
+	}
+
+	var lastErr error
+	for _, upstream := range p.upstreams {
+		resp, _, err := client.Exchange(req, upstream)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		metrics.DNSProxyQueries.WithLabelValues("answered").Inc()
+		if err := w.WriteMsg(resp); err != nil {
+			slog.Debug("Failed to answer DNS query", "client", w.RemoteAddr().String(), "error", err.Error())
+		}
+		return
+	}
+
+	metrics.DNSProxyQueries.WithLabelValues("failed").Inc()
+	slog.Debug("Failed to forward DNS query", "upstreams", p.upstreams, "error", lastErr.Error())
+	fail := new(dns.Msg)
+	fail.SetRcode(req, dns.RcodeServerFailure)
+	_ = w.WriteMsg(fail)
+}
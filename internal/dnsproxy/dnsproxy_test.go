@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package dnsproxy_test
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/dnsproxy"
+	"github.com/miekg/dns"
+)
+
+// freePort returns a port free for both UDP and TCP on 127.0.0.1.
+func freePort(t *testing.T) uint16 {
+	t.Helper()
+	for range 10 {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to listen: %v", err)
+		}
+		//nolint:forcetypeassert
+		port := conn.LocalAddr().(*net.UDPAddr).Port
+		conn.Close()
+		if listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port))); err == nil {
+			listener.Close()
+			return uint16(port)
+		}
+	}
+	t.Fatal("no free port")
+	return 0
+}
+
+func TestProxy(t *testing.T) {
+	t.Parallel()
+
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := &dns.Server{PacketConn: upstream, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		rr, _ := dns.NewRR(req.Question[0].Name + " 30 IN A 10.96.0.1")
+		resp.Answer = append(resp.Answer, rr)
+		_ = w.WriteMsg(resp)
+	})}
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	defer server.Shutdown()
+
+	port := freePort(t)
+	proxyConfig := &config.DNSProxy{
+		Enabled:   true,
+		Port:      port,
+		Upstreams: []string{"127.0.0.1:1", upstream.LocalAddr().String()},
+		Timeout:   config.Duration(time.Second),
+	}
+	proxy, err := dnsproxy.NewProxy(proxyConfig, &config.WireGuard{Addresses: []string{"127.0.0.1/8"}})
+	if err != nil {
+		t.Fatalf("failed to create proxy: %v", err)
+	}
+	if err := proxy.Start(); err != nil {
+		t.Fatalf("failed to start proxy: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := proxy.Stop(ctx); err != nil {
+			t.Errorf("failed to stop proxy: %v", err)
+		}
+	}()
+
+	req := new(dns.Msg)
+	req.SetQuestion("kubernetes.default.svc.cluster.local.", dns.TypeA)
+	resp, _, err := new(dns.Client).Exchange(req, net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+	if err != nil {
+		t.Fatalf("failed to query proxy: %v", err)
+	}
+	if len(resp.Answer) != 1 || resp.Answer[0].(*dns.A).A.String() != "10.96.0.1" {
+		t.Errorf("expected the upstream answer after the dead upstream, got %v", resp.Answer)
+	}
+}
+
+func TestAdvertise(t *testing.T) {
+	t.Parallel()
+
+	proxy := &config.DNSProxy{Port: 53, Search: []string{"svc.cluster.local"}}
+	wg := &config.WireGuard{Addresses: []string{"10.0.0.1/24", "fd00::1/64"}}
+	if err := dnsproxy.Advertise(proxy, wg); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !slices.Equal(wg.DNS, []string{"10.0.0.1", "fd00::1", "svc.cluster.local"}) {
+		t.Errorf("expected the interface addresses and search domain, got %v", wg.DNS)
+	}
+
+	wg = &config.WireGuard{Addresses: []string{"10.0.0.1/24"}, Network: config.Network{DNS: []string{"1.1.1.1"}}}
+	if err := dnsproxy.Advertise(proxy, wg); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(wg.DNS) != 0 {
+		t.Errorf("expected the network DNS to be kept, got %v", wg.DNS)
+	}
+}
@@ -0,0 +1,169 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package endpoint picks the endpoint a node advertises to its peers from
+// a priority list of sources.
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/kube"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var (
+	ErrNotFound      = errors.New("no endpoint source yielded an address")
+	ErrNoNodeAddress = errors.New("node has no address of this type")
+	ErrNoGlobalAddr  = errors.New("interface has no global unicast address")
+)
+
+// Result is the endpoint chosen and the source it came from.
+type Result struct {
+	Endpoint string
+	Source   string
+}
+
+// Detector resolves the endpoint sources. Sources whose inputs are missing,
+// e.g. Node without Kubernetes, are skipped.
+type Detector struct {
+	// Port is paired with the addresses found
+	Port      uint16
+	Static    string
+	HostIP    string
+	Interface string
+	// Node fetches this node for the external_ip and internal_ip sources
+	Node func(ctx context.Context) (*corev1.Node, error)
+	// STUN discovers the public address of the listen port
+	STUN func(ctx context.Context) (netip.AddrPort, error)
+}
+
+// NewDetector reads the sources' inputs from c. node may be nil when
+// there is no Kubernetes client.
+func NewDetector(c *config.Config, node func(ctx context.Context) (*corev1.Node, error), stun func(ctx context.Context) (netip.AddrPort, error)) *Detector {
+	return &Detector{
+		Port:      c.WireGuard.ListenPort,
+		Static:    c.WireGuard.Endpoint,
+		HostIP:    c.Kubernetes.HostIP,
+		Interface: c.WireGuard.EndpointDetection.Interface,
+		Node:      node,
+		STUN:      stun,
+	}
+}
+
+// Detect tries sources in order and returns the first endpoint found. A
+// failing source is logged and the next one tried.
+func (d *Detector) Detect(ctx context.Context, sources []string) (Result, error) {
+	for _, source := range sources {
+		endpoint, err := d.resolve(ctx, source)
+		if err != nil {
+			slog.Info("Endpoint source yielded nothing", "source", source, "error", err.Error())
+			continue
+		}
+		if endpoint != "" {
+			return Result{Endpoint: endpoint, Source: source}, nil
+		}
+	}
+	return Result{}, ErrNotFound
+}
+
+func (d *Detector) resolve(ctx context.Context, source string) (string, error) {
+	switch source {
+	case config.EndpointSourceStatic:
+		return d.Static, nil
+	case config.EndpointSourceHostIP:
+		return d.join(d.HostIP), nil
+	case config.EndpointSourceExternalIP:
+		return d.nodeAddress(ctx, corev1.NodeExternalIP)
+	case config.EndpointSourceInternalIP:
+		return d.nodeAddress(ctx, corev1.NodeInternalIP)
+	case config.EndpointSourceInterface:
+		return d.interfaceAddress()
+	case config.EndpointSourceSTUN:
+		if d.STUN == nil {
+			return "", nil
+		}
+		addrPort, err := d.STUN(ctx)
+		if err != nil {
+			return "", err
+		}
+		return addrPort.String(), nil
+	}
+	return "", fmt.Errorf("%w: %q", config.ErrEndpointSource, source)
+}
+
+func (d *Detector) nodeAddress(ctx context.Context, addressType corev1.NodeAddressType) (string, error) {
+	if d.Node == nil {
+		return "", nil
+	}
+	node, err := d.Node(ctx)
+	if err != nil {
+		return "", err
+	}
+	address := kube.NodeAddress(node, addressType)
+	if address == "" {
+		return "", fmt.Errorf("%w: %s", ErrNoNodeAddress, addressType)
+	}
+	return d.join(address), nil
+}
+
+// interfaceAddress prefers the interface's first global IPv4 address over
+// IPv6 ones, which are more often temporary.
+func (d *Detector) interfaceAddress() (string, error) {
+	if d.Interface == "" {
+		return "", nil
+	}
+	iface, err := net.InterfaceByName(d.Interface)
+	if err != nil {
+		return "", fmt.Errorf("failed to get interface %s: %w", d.Interface, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", fmt.Errorf("failed to list addresses of %s: %w", d.Interface, err)
+	}
+
+	var found netip.Addr
+	for _, addr := range addrs {
+		prefix, err := netip.ParsePrefix(addr.String())
+		if err != nil || !prefix.Addr().IsGlobalUnicast() {
+			continue
+		}
+		if !found.IsValid() || (prefix.Addr().Is4() && !found.Is4()) {
+			found = prefix.Addr()
+		}
+	}
+	if !found.IsValid() {
+		return "", fmt.Errorf("%w: %s", ErrNoGlobalAddr, d.Interface)
+	}
+	return d.join(found.String()), nil
+}
+
+func (d *Detector) join(host string) string {
+	if host == "" {
+		return ""
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(d.Port)))
+}
@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package endpoint_test
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/endpoint"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func node(addresses ...corev1.NodeAddress) func(context.Context) (*corev1.Node, error) {
+	return func(context.Context) (*corev1.Node, error) {
+		return &corev1.Node{Status: corev1.NodeStatus{Addresses: addresses}}, nil
+	}
+}
+
+func TestDetectPriority(t *testing.T) {
+	t.Parallel()
+
+	internalOnly := node(corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: "10.1.0.5"})
+	stun := func(context.Context) (netip.AddrPort, error) {
+		return netip.MustParseAddrPort("203.0.113.7:51820"), nil
+	}
+
+	tests := []struct {
+		name     string
+		detector endpoint.Detector
+		sources  []string
+		expected endpoint.Result
+	}{
+		{
+			name:     "static wins when set",
+			detector: endpoint.Detector{Port: 51820, Static: "vpn.example.com:51820", Node: internalOnly},
+			sources:  []string{config.EndpointSourceStatic, config.EndpointSourceInternalIP},
+			expected: endpoint.Result{Endpoint: "vpn.example.com:51820", Source: config.EndpointSourceStatic},
+		},
+		{
+			name:     "missing external IP falls through",
+			detector: endpoint.Detector{Port: 51820, Node: internalOnly},
+			sources:  []string{config.EndpointSourceStatic, config.EndpointSourceExternalIP, config.EndpointSourceInternalIP},
+			expected: endpoint.Result{Endpoint: "10.1.0.5:51820", Source: config.EndpointSourceInternalIP},
+		},
+		{
+			name:     "node sources are skipped without Kubernetes",
+			detector: endpoint.Detector{Port: 51820, STUN: stun},
+			sources:  []string{config.EndpointSourceExternalIP, config.EndpointSourceSTUN},
+			expected: endpoint.Result{Endpoint: "203.0.113.7:51820", Source: config.EndpointSourceSTUN},
+		},
+		{
+			name:     "IPv6 host IP",
+			detector: endpoint.Detector{Port: 51820, HostIP: "2001:db8::5"},
+			sources:  []string{config.EndpointSourceHostIP},
+			expected: endpoint.Result{Endpoint: "[2001:db8::5]:51820", Source: config.EndpointSourceHostIP},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := test.detector.Detect(context.Background(), test.sources)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result != test.expected {
+				t.Errorf("expected %+v, got %+v", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestDetectNothingFound(t *testing.T) {
+	t.Parallel()
+
+	failing := func(context.Context) (netip.AddrPort, error) {
+		return netip.AddrPort{}, errors.New("timeout")
+	}
+	detector := endpoint.Detector{Port: 51820, STUN: failing, Interface: "does-not-exist0"}
+	_, err := detector.Detect(context.Background(), []string{
+		config.EndpointSourceStatic, config.EndpointSourceSTUN, config.EndpointSourceInterface,
+	})
+	if !errors.Is(err, endpoint.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,450 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package enroll lets peers register themselves with one-time tokens
+// instead of an administrator collecting their public keys.
+package enroll
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/cryptopolicy"
+	"github.com/kubewg-net/container/internal/ipam"
+	"github.com/kubewg-net/container/internal/peers"
+	"github.com/kubewg-net/container/internal/state"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const (
+	tokenBytes = 32
+	// tokenIDBytes of the token hash identify a token for listing and
+	// revocation without revealing it
+	tokenIDBytes = 8
+	// auditLimit bounds the audit trail kept in memory, oldest entries go
+	// first
+	auditLimit = 1000
+)
+
+var (
+	ErrInvalidToken     = errors.New("invalid or expired enrollment token")
+	ErrInvalidPublicKey = errors.New("invalid public key")
+	ErrTokenNotFound    = errors.New("enrollment token not found")
+	ErrUnknownProfile   = errors.New("unknown access profile")
+)
+
+type tokenHash [sha256.Size]byte
+
+// Token describes an outstanding enrollment token. The token itself is
+// only ever returned once, when it is issued.
+type Token struct {
+	ID        string    `json:"id"`
+	IssuedBy  string    `json:"issued_by"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Profile is the access profile the peer enrolled with this token gets
+	Profile string `json:"profile,omitempty"`
+}
+
+type AuditAction string
+
+const (
+	AuditIssued   AuditAction = "issued"
+	AuditUsed     AuditAction = "used"
+	AuditRejected AuditAction = "rejected"
+	AuditRevoked  AuditAction = "revoked"
+	// AuditExpired and AuditReleased follow the lease of an enrolled peer
+	AuditExpired  AuditAction = "lease_expired"
+	AuditReleased AuditAction = "lease_released"
+	// AuditReenrolled is a peer swapping its key at the end of its session
+	AuditReenrolled AuditAction = "reenrolled"
+)
+
+// AuditEntry records something that happened to a token. TokenID is empty
+// when a rejected token was never issued here.
+type AuditEntry struct {
+	Time      time.Time   `json:"time"`
+	Action    AuditAction `json:"action"`
+	TokenID   string      `json:"token_id,omitempty"`
+	Actor     string      `json:"actor,omitempty"`
+	PublicKey string      `json:"public_key,omitempty"`
+	Remote    string      `json:"remote,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// Enroller issues enrollment tokens and turns a valid token plus a public
+// key into a registered peer with a tunnel address from the pools. Only
+// hashes of the tokens are kept in memory. With leases enabled, enrolled
+// peers must renew or are removed again.
+type Enroller struct {
+	config    *config.Enrollment
+	wgConfig  *config.WireGuard
+	registry  *peers.Registry
+	allocator *ipam.Allocator
+	mu        sync.Mutex
+	tokens    map[tokenHash]Token
+	// enrolled are the peers registered through enrollment
+	enrolled map[string]config.WireGuardPeer
+	leases   map[string]*lease
+	audit    []AuditEntry
+	publish  PublishFunc
+	state    state.Store
+}
+
+func NewEnroller(enrollment *config.Enrollment, wgConfig *config.WireGuard, registry *peers.Registry) (*Enroller, error) {
+	allocator, err := ipam.NewAllocator(enrollment.Pools)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create address allocator: %w", err)
+	}
+
+	// Keep the interface's own addresses and everything routed to known
+	// peers out of the pools
+	for _, address := range wgConfig.Addresses {
+		prefix, err := netip.ParsePrefix(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %w", address, err)
+		}
+		allocator.Reserve(ipam.HostPrefix(prefix.Addr()))
+	}
+	for _, peer := range registry.List() {
+		for _, allowedIP := range peer.AllowedIPs {
+			prefix, err := netip.ParsePrefix(allowedIP)
+			if err != nil {
+				return nil, fmt.Errorf("invalid allowed IP %q: %w", allowedIP, err)
+			}
+			allocator.Reserve(prefix)
+		}
+	}
+
+	enroller := &Enroller{
+		config:    enrollment,
+		wgConfig:  wgConfig,
+		registry:  registry,
+		allocator: allocator,
+		tokens:    make(map[tokenHash]Token),
+		enrolled:  make(map[string]config.WireGuardPeer),
+		leases:    make(map[string]*lease),
+	}
+	registry.OnChange(enroller.peerChanged)
+	return enroller, nil
+}
+
+// IssueToken creates a new one-time token valid for ttl, or for the
+// configured default when ttl is 0. issuer names the caller for the audit
+// trail.
+func (e *Enroller) IssueToken(ttl time.Duration, issuer string) (string, Token, error) {
+	return e.IssueProfileToken(ttl, "", issuer)
+}
+
+// IssueProfileToken is IssueToken for a peer with the access profile
+// called profile, the default profile when empty.
+func (e *Enroller) IssueProfileToken(ttl time.Duration, profile, issuer string) (string, Token, error) {
+	if ttl == 0 {
+		ttl = e.config.TokenTTL.Std()
+	}
+	if profile == "" {
+		profile = e.config.DefaultProfile
+	}
+	if _, ok := e.wgConfig.Profile(profile); profile != "" && !ok {
+		return "", Token{}, fmt.Errorf("%w: %q", ErrUnknownProfile, profile)
+	}
+
+	secret, hash, err := newSecret()
+	if err != nil {
+		return "", Token{}, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	now := time.Now()
+	token := Token{
+		ID:        hex.EncodeToString(hash[:tokenIDBytes]),
+		IssuedBy:  issuer,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(ttl),
+		Profile:   profile,
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.pruneExpired(now)
+	e.tokens[hash] = token
+	e.record(AuditEntry{Action: AuditIssued, TokenID: token.ID, Actor: issuer})
+	e.save()
+
+	return secret, token, nil
+}
+
+// Tokens lists the outstanding tokens, soonest to expire first.
+func (e *Enroller) Tokens() []Token {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.pruneExpired(time.Now())
+	tokens := make([]Token, 0, len(e.tokens))
+	for _, token := range e.tokens {
+		tokens = append(tokens, token)
+	}
+	slices.SortFunc(tokens, func(a, b Token) int {
+		return a.ExpiresAt.Compare(b.ExpiresAt)
+	})
+	return tokens
+}
+
+// Revoke invalidates the token with id before it is used or expires.
+func (e *Enroller) Revoke(id, actor string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for hash, token := range e.tokens {
+		if token.ID != id {
+			continue
+		}
+		delete(e.tokens, hash)
+		e.record(AuditEntry{Action: AuditRevoked, TokenID: id, Actor: actor})
+		e.save()
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrTokenNotFound, id)
+}
+
+// Audit returns the recorded token activity, oldest first.
+func (e *Enroller) Audit() []AuditEntry {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return slices.Clone(e.audit)
+}
+
+// Enroll consumes token and registers publicKey as a peer with a freshly
+// allocated tunnel address. The token stays valid if registration fails.
+// remote is the address the request came from, kept for the audit trail.
+// With leases enabled it also returns the lease token the peer renews
+// with.
+func (e *Enroller) Enroll(secret, publicKey, remote string) (config.WireGuardPeer, string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	hash := cryptopolicy.HashToken(secret)
+	token, ok := e.tokens[hash]
+	entry := AuditEntry{TokenID: token.ID, PublicKey: publicKey, Remote: remote}
+
+	peer, leaseToken, err := e.enroll(hash, token, ok, publicKey)
+	if err != nil {
+		entry.Action = AuditRejected
+		entry.Error = err.Error()
+		e.record(entry)
+		return config.WireGuardPeer{}, "", err
+	}
+
+	entry.Action = AuditUsed
+	e.record(entry)
+	slog.Info("Enrolled peer", "public_key", publicKey, "addresses", peer.AllowedIPs)
+	return peer, leaseToken, nil
+}
+
+func (e *Enroller) enroll(hash tokenHash, token Token, ok bool, publicKey string) (config.WireGuardPeer, string, error) {
+	now := time.Now()
+	if !ok || now.After(token.ExpiresAt) {
+		return config.WireGuardPeer{}, "", ErrInvalidToken
+	}
+	var metadata map[string]string
+	if token.Profile != "" {
+		metadata = map[string]string{config.MetadataProfile: token.Profile}
+	}
+	peer, leaseToken, err := e.register(publicKey, metadata, nil, now)
+	if err != nil {
+		return config.WireGuardPeer{}, "", err
+	}
+
+	delete(e.tokens, hash)
+	e.save()
+	return peer, leaseToken, nil
+}
+
+// EnrollIdentity registers publicKey for an identity the caller verified,
+// such as the subject of an OIDC ID token, with the access profile its
+// groups map to. The identity is recorded on the peer and as the actor in
+// the audit trail.
+func (e *Enroller) EnrollIdentity(identity, profile, publicKey, remote string) (config.WireGuardPeer, string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	entry := AuditEntry{Actor: identity, PublicKey: publicKey, Remote: remote}
+	metadata := map[string]string{config.MetadataIdentity: identity}
+	if profile != "" {
+		metadata[config.MetadataProfile] = profile
+	}
+	peer, leaseToken, err := e.register(publicKey, metadata, nil, time.Now())
+	if err != nil {
+		entry.Action = AuditRejected
+		entry.Error = err.Error()
+		e.record(entry)
+		return config.WireGuardPeer{}, "", err
+	}
+	e.save()
+
+	entry.Action = AuditUsed
+	e.record(entry)
+	slog.Info("Enrolled peer", "public_key", publicKey, "identity", identity, "addresses", peer.AllowedIPs)
+	return peer, leaseToken, nil
+}
+
+// register adds publicKey as a peer with metadata and addresses, fresh ones
+// from the pools when nil. Addresses are released again if registration
+// fails. Callers hold e.mu.
+func (e *Enroller) register(publicKey string, metadata map[string]string, addresses []string, now time.Time) (config.WireGuardPeer, string, error) {
+	if _, err := wgtypes.ParseKey(publicKey); err != nil {
+		e.release(addresses)
+		return config.WireGuardPeer{}, "", fmt.Errorf("%w: %w", ErrInvalidPublicKey, err)
+	}
+	// A profile dropped from the config since must not fall back to the
+	// whole network
+	if profile, ok := metadata[config.MetadataProfile]; ok {
+		if _, known := e.wgConfig.Profile(profile); !known {
+			e.release(addresses)
+			return config.WireGuardPeer{}, "", fmt.Errorf("%w: %q", ErrUnknownProfile, profile)
+		}
+	}
+
+	peer := config.WireGuardPeer{PublicKey: publicKey, Metadata: metadata, AllowedIPs: addresses}
+	if addresses == nil {
+		// Dual-stack pools hand out an address of each family
+		addrs, err := e.allocator.AllocateDualStack()
+		if err != nil {
+			return config.WireGuardPeer{}, "", err
+		}
+		for _, addr := range addrs {
+			peer.AllowedIPs = append(peer.AllowedIPs, ipam.HostPrefix(addr).String())
+		}
+	}
+
+	// The lease token comes first so nothing needs undoing once the peer
+	// is registered
+	var leaseToken string
+	var leaseHash tokenHash
+	var err error
+	if e.config.Lease.Enabled {
+		leaseToken, leaseHash, err = newSecret()
+		if err != nil {
+			e.release(peer.AllowedIPs)
+			return config.WireGuardPeer{}, "", fmt.Errorf("failed to generate lease token: %w", err)
+		}
+	}
+	if err := e.registry.Add(peer); err != nil {
+		e.release(peer.AllowedIPs)
+		return config.WireGuardPeer{}, "", err
+	}
+	e.enrolled[publicKey] = peer
+	if e.config.Lease.Enabled {
+		e.addLease(publicKey, peer.AllowedIPs, leaseHash, now)
+	}
+	return peer, leaseToken, nil
+}
+
+// peerChanged forgets an enrolled peer once it leaves the registry, however
+// it was removed, returning its addresses to the pools.
+func (e *Enroller) peerChanged(peer config.WireGuardPeer, removed bool) {
+	if !removed {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.forget(peer.PublicKey)
+}
+
+// forget drops an enrolled peer and its lease. Callers hold e.mu.
+func (e *Enroller) forget(publicKey string) {
+	peer, ok := e.enrolled[publicKey]
+	if !ok {
+		return
+	}
+	e.release(peer.AllowedIPs)
+	delete(e.enrolled, publicKey)
+	delete(e.leases, publicKey)
+	e.save()
+}
+
+// newSecret generates a random token and its hash.
+func newSecret() (string, tokenHash, error) {
+	raw := make([]byte, tokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", tokenHash{}, err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(raw)
+	return secret, cryptopolicy.HashToken(secret), nil
+}
+
+// pruneExpired drops tokens past their expiry. Callers hold e.mu.
+func (e *Enroller) pruneExpired(now time.Time) {
+	for hash, token := range e.tokens {
+		if now.After(token.ExpiresAt) {
+			delete(e.tokens, hash)
+		}
+	}
+}
+
+// record appends entry to the audit trail and logs it. Callers hold e.mu.
+func (e *Enroller) record(entry AuditEntry) {
+	entry.Time = time.Now()
+	if len(e.audit) >= auditLimit {
+		e.audit = slices.Delete(e.audit, 0, len(e.audit)-auditLimit+1)
+	}
+	e.audit = append(e.audit, entry)
+
+	attrs := []any{"action", string(entry.Action), "token_id", entry.TokenID}
+	if entry.Actor != "" {
+		attrs = append(attrs, "actor", entry.Actor)
+	}
+	if entry.PublicKey != "" {
+		attrs = append(attrs, "public_key", entry.PublicKey, "remote", entry.Remote)
+	}
+	if entry.Error != "" {
+		attrs = append(attrs, "error", entry.Error)
+		slog.Warn("Enrollment token audit", attrs...)
+		return
+	}
+	slog.Info("Enrollment token audit", attrs...)
+}
+
+// Allocations describes the address pools and what was taken out of them.
+func (e *Enroller) Allocations() []ipam.PoolUsage {
+	return e.allocator.Usage()
+}
+
+// Reserve keeps the addresses of a peer that was added outside of
+// enrollment, e.g. from an imported bundle, out of the pools.
+func (e *Enroller) Reserve(peer config.WireGuardPeer) error {
+	for _, allowedIP := range peer.AllowedIPs {
+		prefix, err := netip.ParsePrefix(allowedIP)
+		if err != nil {
+			return fmt.Errorf("invalid allowed IP %q: %w", allowedIP, err)
+		}
+		e.allocator.Reserve(prefix)
+	}
+	return nil
+}
@@ -0,0 +1,445 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package enroll_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/enroll"
+	"github.com/kubewg-net/container/internal/events"
+	"github.com/kubewg-net/container/internal/peers"
+	"github.com/kubewg-net/container/internal/state"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func newEnroller(t *testing.T) *enroll.Enroller {
+	t.Helper()
+
+	enroller, err := enroll.NewEnroller(&config.Enrollment{
+		Enabled:  true,
+		TokenTTL: config.Duration(time.Minute),
+		Pools:    []string{"10.0.0.0/24"},
+	}, &config.WireGuard{Addresses: []string{"10.0.0.1/24"}}, peers.NewRegistry(nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return enroller
+}
+
+func publicKey(t *testing.T) string {
+	t.Helper()
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return key.PublicKey().String()
+}
+
+func TestTokenIsSingleUse(t *testing.T) {
+	t.Parallel()
+
+	enroller := newEnroller(t)
+	secret, _, err := enroller.IssueToken(0, "token:ci")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	peer, _, err := enroller.Enroll(secret, publicKey(t), "192.0.2.10:40000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(peer.AllowedIPs) != 1 || peer.AllowedIPs[0] != "10.0.0.2/32" {
+		t.Errorf("expected the first free address 10.0.0.2/32, got %v", peer.AllowedIPs)
+	}
+
+	if _, _, err := enroller.Enroll(secret, publicKey(t), "192.0.2.11:40000"); !errors.Is(err, enroll.ErrInvalidToken) {
+		t.Errorf("expected a reused token to be rejected, got %v", err)
+	}
+	if tokens := enroller.Tokens(); len(tokens) != 0 {
+		t.Errorf("expected no outstanding tokens, got %d", len(tokens))
+	}
+}
+
+func TestProfileToken(t *testing.T) {
+	t.Parallel()
+
+	wg := &config.WireGuard{
+		Addresses: []string{"10.0.0.1/24"},
+		Profiles:  []config.AccessProfile{{Name: "devs", AllowedIPs: []string{"10.96.0.0/12"}}},
+	}
+	enroller, err := enroll.NewEnroller(&config.Enrollment{
+		Enabled:        true,
+		TokenTTL:       config.Duration(time.Minute),
+		Pools:          []string{"10.0.0.0/24"},
+		DefaultProfile: "devs",
+	}, wg, peers.NewRegistry(nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, _, err := enroller.IssueProfileToken(0, "ops", "token:ci"); !errors.Is(err, enroll.ErrUnknownProfile) {
+		t.Errorf("expected ErrUnknownProfile, got %v", err)
+	}
+	secret, token, err := enroller.IssueToken(0, "token:ci")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if token.Profile != "devs" {
+		t.Errorf("expected the default profile devs, got %q", token.Profile)
+	}
+
+	peer, _, err := enroller.Enroll(secret, publicKey(t), "192.0.2.10:40000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if profile, ok := wg.PeerProfile(&peer); !ok || profile.Name != "devs" {
+		t.Errorf("expected the peer to carry the devs profile, got %v", peer.Metadata)
+	}
+}
+
+func TestEnrollIdentity(t *testing.T) {
+	t.Parallel()
+
+	enroller := newEnroller(t)
+	peer, _, err := enroller.EnrollIdentity("oidc:dev@example.com", "", publicKey(t), "192.0.2.10:40000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if peer.Metadata[config.MetadataIdentity] != "oidc:dev@example.com" {
+		t.Errorf("expected the identity on the peer, got %v", peer.Metadata)
+	}
+	audit := enroller.Audit()
+	if len(audit) != 1 || audit[0].Action != enroll.AuditUsed || audit[0].Actor != "oidc:dev@example.com" {
+		t.Errorf("expected the identity as the actor of the audit entry, got %+v", audit)
+	}
+
+	if _, _, err := enroller.EnrollIdentity("oidc:ops@example.com", "ops", publicKey(t), "192.0.2.11:40000"); !errors.Is(err, enroll.ErrUnknownProfile) {
+		t.Errorf("expected ErrUnknownProfile, got %v", err)
+	}
+}
+
+func TestRevokedTokenIsRejectedAndAudited(t *testing.T) {
+	t.Parallel()
+
+	enroller := newEnroller(t)
+	secret, token, err := enroller.IssueToken(0, "token:ci")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tokens := enroller.Tokens(); len(tokens) != 1 || tokens[0].ID != token.ID {
+		t.Fatalf("expected token %s to be outstanding, got %v", token.ID, tokens)
+	}
+
+	if err := enroller.Revoke(token.ID, "cert:admin"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := enroller.Revoke(token.ID, "cert:admin"); !errors.Is(err, enroll.ErrTokenNotFound) {
+		t.Errorf("expected revoking twice to fail with not found, got %v", err)
+	}
+
+	key := publicKey(t)
+	if _, _, err := enroller.Enroll(secret, key, "192.0.2.10:40000"); !errors.Is(err, enroll.ErrInvalidToken) {
+		t.Fatalf("expected a revoked token to be rejected, got %v", err)
+	}
+
+	audit := enroller.Audit()
+	expected := []enroll.AuditAction{enroll.AuditIssued, enroll.AuditRevoked, enroll.AuditRejected}
+	if len(audit) != len(expected) {
+		t.Fatalf("expected %d audit entries, got %d", len(expected), len(audit))
+	}
+	for i, action := range expected {
+		if audit[i].Action != action {
+			t.Errorf("expected audit entry %d to be %s, got %s", i, action, audit[i].Action)
+		}
+	}
+	if audit[1].Actor != "cert:admin" {
+		t.Errorf("expected the revocation to name cert:admin, got %q", audit[1].Actor)
+	}
+	if audit[2].PublicKey != key || audit[2].Remote != "192.0.2.10:40000" {
+		t.Errorf("expected the rejection to record the caller, got %+v", audit[2])
+	}
+}
+
+func TestEnrollDualStack(t *testing.T) {
+	t.Parallel()
+
+	enroller, err := enroll.NewEnroller(&config.Enrollment{
+		Enabled:  true,
+		TokenTTL: config.Duration(time.Minute),
+		Pools:    []string{"10.0.0.0/24", "fd00:77::/64"},
+	}, &config.WireGuard{Addresses: []string{"10.0.0.1/24", "fd00:77::1/64"}}, peers.NewRegistry(nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	secret, _, err := enroller.IssueToken(0, "token:ci")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	peer, _, err := enroller.Enroll(secret, publicKey(t), "192.0.2.10:40000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"10.0.0.2/32", "fd00:77::2/128"}; !slices.Equal(peer.AllowedIPs, want) {
+		t.Errorf("expected %v, got %v", want, peer.AllowedIPs)
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	t.Parallel()
+
+	registry := peers.NewRegistry(nil)
+	enroller, err := enroll.NewEnroller(&config.Enrollment{
+		Enabled:  true,
+		TokenTTL: config.Duration(time.Minute),
+		Pools:    []string{"10.0.0.0/30"},
+		Lease:    config.EnrollmentLease{Enabled: true, TTL: config.Duration(time.Minute), Grace: config.Duration(30 * time.Second)},
+	}, &config.WireGuard{Addresses: []string{"10.0.0.1/30"}}, registry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var published []events.Type
+	enroller.OnEvent(func(eventType events.Type, _, _ string) {
+		published = append(published, eventType)
+	})
+
+	secret, _, err := enroller.IssueToken(0, "token:ci")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	key := publicKey(t)
+	peer, leaseToken, err := enroller.Enroll(secret, key, "192.0.2.10:40000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if leaseToken == "" {
+		t.Fatal("expected a lease token")
+	}
+
+	if _, err := enroller.Renew(key, "wrong"); !errors.Is(err, enroll.ErrInvalidLease) {
+		t.Errorf("expected a wrong lease token to be rejected, got %v", err)
+	}
+	lease, err := enroller.Renew(key, leaseToken)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Expired, but still configured during the grace period
+	if removed := enroller.ExpireLeases(lease.ExpiresAt); len(removed) != 0 {
+		t.Fatalf("expected nothing removed during the grace period, got %v", removed)
+	}
+	if lease, _ := enroller.Lease(key); !lease.Expired {
+		t.Error("expected the lease to be expired")
+	}
+	if !slices.Equal(published, []events.Type{events.LeaseExpired}) {
+		t.Errorf("expected a LeaseExpired event, got %v", published)
+	}
+
+	removed := enroller.ExpireLeases(lease.ExpiresAt.Add(30 * time.Second))
+	if len(removed) != 1 || removed[0].PublicKey != key {
+		t.Fatalf("expected %s to be removed, got %v", key, removed)
+	}
+	if _, ok := registry.Get(key); ok {
+		t.Error("expected the peer to be removed from the registry")
+	}
+	if _, err := enroller.Renew(key, leaseToken); !errors.Is(err, enroll.ErrInvalidLease) {
+		t.Errorf("expected renewing a removed lease to fail, got %v", err)
+	}
+
+	// The /30 has a single free address, which must be back in the pool
+	secret, _, err = enroller.IssueToken(0, "token:ci")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	next, _, err := enroller.Enroll(secret, publicKey(t), "192.0.2.11:40000")
+	if err != nil {
+		t.Fatalf("expected the released address to be reused, got %v", err)
+	}
+	if !slices.Equal(next.AllowedIPs, peer.AllowedIPs) {
+		t.Errorf("expected %v, got %v", peer.AllowedIPs, next.AllowedIPs)
+	}
+}
+
+func TestReenrollBeforeSessionEnds(t *testing.T) {
+	t.Parallel()
+
+	registry := peers.NewRegistry(nil)
+	enroller, err := enroll.NewEnroller(&config.Enrollment{
+		Enabled:  true,
+		TokenTTL: config.Duration(time.Minute),
+		Pools:    []string{"10.0.0.0/24"},
+		Lease:    config.EnrollmentLease{Enabled: true, TTL: config.Duration(time.Hour), Grace: config.Duration(5 * time.Minute), MaxAge: config.Duration(30 * time.Minute)},
+	}, &config.WireGuard{Addresses: []string{"10.0.0.1/24"}}, registry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	secret, _, err := enroller.IssueToken(0, "token:ci")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	key := publicKey(t)
+	peer, leaseToken, err := enroller.Enroll(secret, key, "192.0.2.10:40000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lease, _ := enroller.Lease(key)
+	if lease.SessionExpiresAt.IsZero() || !lease.ExpiresAt.Equal(lease.SessionExpiresAt) {
+		t.Errorf("expected the lease to be capped at the end of the session, got %+v", lease)
+	}
+
+	newKey := publicKey(t)
+	if _, _, err := enroller.Reenroll(key, "wrong", newKey, "192.0.2.10:40000"); !errors.Is(err, enroll.ErrInvalidLease) {
+		t.Errorf("expected a wrong lease token to be rejected, got %v", err)
+	}
+	next, nextToken, err := enroller.Reenroll(key, leaseToken, newKey, "192.0.2.10:40000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if nextToken == "" || nextToken == leaseToken {
+		t.Errorf("expected a new lease token, got %q", nextToken)
+	}
+	if !slices.Equal(next.AllowedIPs, peer.AllowedIPs) {
+		t.Errorf("expected the addresses %v to be kept, got %v", peer.AllowedIPs, next.AllowedIPs)
+	}
+	if _, ok := registry.Get(key); ok {
+		t.Error("expected the old key to be removed from the registry")
+	}
+	if _, ok := registry.Get(newKey); !ok {
+		t.Error("expected the new key in the registry")
+	}
+	if _, err := enroller.Renew(key, leaseToken); !errors.Is(err, enroll.ErrInvalidLease) {
+		t.Errorf("expected the old lease to be gone, got %v", err)
+	}
+
+	// No grace period once the session is over
+	lease, _ = enroller.Lease(newKey)
+	removed := enroller.ExpireLeases(lease.SessionExpiresAt)
+	if len(removed) != 1 || removed[0].PublicKey != newKey {
+		t.Fatalf("expected %s to be removed, got %v", newKey, removed)
+	}
+	if _, ok := registry.Get(newKey); ok {
+		t.Error("expected the peer to be removed from the registry")
+	}
+}
+
+func TestReenrollToRegisteredKey(t *testing.T) {
+	t.Parallel()
+
+	registry := peers.NewRegistry(nil)
+	enroller, err := enroll.NewEnroller(&config.Enrollment{
+		Enabled:  true,
+		TokenTTL: config.Duration(time.Minute),
+		Pools:    []string{"10.0.0.0/24"},
+		Lease:    config.EnrollmentLease{Enabled: true, TTL: config.Duration(time.Hour), Grace: config.Duration(5 * time.Minute)},
+	}, &config.WireGuard{Addresses: []string{"10.0.0.1/24"}}, registry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	secret, _, err := enroller.IssueToken(0, "token:ci")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	key := publicKey(t)
+	_, leaseToken, err := enroller.Enroll(secret, key, "192.0.2.10:40000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	taken := publicKey(t)
+	if err := registry.Add(config.WireGuardPeer{PublicKey: taken, AllowedIPs: []string{"10.0.1.1/32"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, _, err := enroller.Reenroll(key, leaseToken, taken, "192.0.2.10:40000"); !errors.Is(err, peers.ErrPeerExists) {
+		t.Fatalf("expected %v, got %v", peers.ErrPeerExists, err)
+	}
+	if _, ok := registry.Get(key); !ok {
+		t.Error("expected the old key to stay in the registry")
+	}
+	if _, err := enroller.Renew(key, leaseToken); err != nil {
+		t.Errorf("expected the old lease to still renew, got %v", err)
+	}
+}
+
+func TestStateSurvivesRestart(t *testing.T) {
+	t.Parallel()
+
+	enrollment := &config.Enrollment{
+		Enabled:  true,
+		TokenTTL: config.Duration(time.Minute),
+		Pools:    []string{"10.0.0.0/24"},
+		Lease:    config.EnrollmentLease{Enabled: true, TTL: config.Duration(time.Minute), Grace: config.Duration(30 * time.Second)},
+	}
+	wg := &config.WireGuard{Addresses: []string{"10.0.0.1/24"}}
+	store := state.NewMemory()
+	start := func() (*enroll.Enroller, *peers.Registry) {
+		registry := peers.NewRegistry(nil)
+		enroller, err := enroll.NewEnroller(enrollment, wg, registry)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := enroller.UseStore(context.Background(), store); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return enroller, registry
+	}
+
+	enroller, _ := start()
+	secret, _, err := enroller.IssueToken(0, "token:ci")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	key := publicKey(t)
+	peer, leaseToken, err := enroller.Enroll(secret, key, "192.0.2.10:40000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	unused, token, err := enroller.IssueToken(0, "token:ci")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	enroller, registry := start()
+	if restored, ok := registry.Get(key); !ok || !slices.Equal(restored.AllowedIPs, peer.AllowedIPs) {
+		t.Errorf("expected %s with %v to be restored, got %+v", key, peer.AllowedIPs, restored)
+	}
+	if tokens := enroller.Tokens(); len(tokens) != 1 || tokens[0].ID != token.ID {
+		t.Errorf("expected token %s to be restored, got %v", token.ID, tokens)
+	}
+	if _, err := enroller.Renew(key, leaseToken); err != nil {
+		t.Errorf("expected the lease to be restored, got %v", err)
+	}
+
+	// The restored token works and doesn't hand out the restored address
+	next, _, err := enroller.Enroll(unused, publicKey(t), "192.0.2.11:40000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if slices.Equal(next.AllowedIPs, peer.AllowedIPs) {
+		t.Errorf("expected a new address, got %v again", next.AllowedIPs)
+	}
+}
@@ -0,0 +1,321 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package enroll
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/cryptopolicy"
+	"github.com/kubewg-net/container/internal/events"
+	"github.com/kubewg-net/container/internal/peers"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// leaseInterval is how often leases are checked for expiry
+const leaseInterval = 10 * time.Second
+
+var (
+	ErrInvalidLease   = errors.New("invalid lease token or no lease for this public key")
+	ErrSessionExpired = errors.New("session expired, enroll again")
+)
+
+// Lease keeps an enrolled peer configured for as long as it renews.
+type Lease struct {
+	PublicKey string    `json:"public_key"`
+	Addresses []string  `json:"addresses"`
+	RenewedAt time.Time `json:"renewed_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Expired is set during the grace period after ExpiresAt
+	Expired bool `json:"expired"`
+	// SessionExpiresAt ends the lease regardless of renewals when the
+	// lease max age is set. The peer re-enrolls with a new key before then.
+	SessionExpiresAt time.Time `json:"session_expires_at,omitempty"`
+}
+
+type lease struct {
+	Lease
+	hash tokenHash
+}
+
+// PublishFunc sends a peer lifecycle event, such as Engine.Publish.
+type PublishFunc func(eventType events.Type, publicKey, message string)
+
+// OnEvent sets where lease events go.
+func (e *Enroller) OnEvent(publish PublishFunc) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.publish = publish
+}
+
+// Leases lists the leases of the enrolled peers, soonest to expire first.
+func (e *Enroller) Leases() []Lease {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	leases := make([]Lease, 0, len(e.leases))
+	for _, l := range e.leases {
+		leases = append(leases, l.Lease)
+	}
+	slices.SortFunc(leases, func(a, b Lease) int {
+		return a.ExpiresAt.Compare(b.ExpiresAt)
+	})
+	return leases
+}
+
+// Lease returns the lease of publicKey.
+func (e *Enroller) Lease(publicKey string) (Lease, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	l, ok := e.leases[publicKey]
+	if !ok {
+		return Lease{}, false
+	}
+	return l.Lease, true
+}
+
+// Renew extends the lease of publicKey by the configured TTL, up to the
+// end of its session. secret is the lease token handed out on enrollment.
+// A lease in its grace period can still be renewed, one past its session
+// can't.
+func (e *Enroller) Renew(publicKey, secret string) (Lease, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	l, ok := e.leases[publicKey]
+	hash := cryptopolicy.HashToken(secret)
+	if !ok || subtle.ConstantTimeCompare(hash[:], l.hash[:]) != 1 {
+		return Lease{}, ErrInvalidLease
+	}
+
+	now := time.Now()
+	if !l.SessionExpiresAt.IsZero() && !now.Before(l.SessionExpiresAt) {
+		return Lease{}, ErrSessionExpired
+	}
+	l.RenewedAt = now
+	l.ExpiresAt = now.Add(e.config.Lease.TTL.Std())
+	if !l.SessionExpiresAt.IsZero() && l.ExpiresAt.After(l.SessionExpiresAt) {
+		l.ExpiresAt = l.SessionExpiresAt
+	}
+	if l.Expired {
+		l.Expired = false
+		slog.Info("Renewed expired lease", "public_key", publicKey, "expires_at", l.ExpiresAt)
+	}
+	e.save()
+	return l.Lease, nil
+}
+
+// Start expires leases until ctx is done.
+func (e *Enroller) Start(ctx context.Context) {
+	ticker := time.NewTicker(leaseInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		e.ExpireLeases(time.Now())
+	}
+}
+
+// ExpireLeases moves the leases past their expiry as of now into their
+// grace period, and removes the peers whose grace period is over along
+// with their lease. Peers whose session ended are removed right away. It
+// returns the removed leases.
+func (e *Enroller) ExpireLeases(now time.Time) []Lease {
+	e.mu.Lock()
+	grace := e.config.Lease.Grace.Std()
+	var due []*lease
+	changed := false
+	for publicKey, l := range e.leases {
+		if now.Before(l.ExpiresAt) {
+			continue
+		}
+		if !l.SessionExpiresAt.IsZero() && !now.Before(l.SessionExpiresAt) {
+			e.emit(events.LeaseExpired, publicKey, fmt.Sprintf("session ended at %s, peer is removed",
+				l.SessionExpiresAt.Format(time.RFC3339)))
+			e.record(AuditEntry{Action: AuditExpired, PublicKey: publicKey})
+			due = append(due, l)
+			continue
+		}
+		if !l.Expired {
+			l.Expired = true
+			changed = true
+			e.emit(events.LeaseExpired, publicKey, fmt.Sprintf("lease expired at %s, peer is removed at %s unless it renews",
+				l.ExpiresAt.Format(time.RFC3339), l.ExpiresAt.Add(grace).Format(time.RFC3339)))
+			e.record(AuditEntry{Action: AuditExpired, PublicKey: publicKey})
+		}
+		if l.due(now, grace) {
+			due = append(due, l)
+		}
+	}
+	if changed {
+		e.save()
+	}
+	e.mu.Unlock()
+
+	// The registry calls back into peerChanged, which needs e.mu
+	var removed []Lease
+	for _, l := range due {
+		// A lease renewed since is kept. One that is still due is dropped
+		// before its peer, so a renewal during the removal fails instead
+		// of being lost
+		e.mu.Lock()
+		if e.leases[l.PublicKey] != l || !l.due(now, grace) {
+			e.mu.Unlock()
+			continue
+		}
+		delete(e.leases, l.PublicKey)
+		e.mu.Unlock()
+
+		if _, err := e.registry.Remove(l.PublicKey); err != nil && !errors.Is(err, peers.ErrPeerNotFound) {
+			slog.Warn("Failed to remove peer with an expired lease", "public_key", l.PublicKey, "error", err.Error())
+			e.mu.Lock()
+			e.leases[l.PublicKey] = l
+			e.mu.Unlock()
+			continue
+		}
+		e.mu.Lock()
+		// In case the peer had left the registry already
+		e.forget(l.PublicKey)
+		e.record(AuditEntry{Action: AuditReleased, PublicKey: l.PublicKey})
+		e.mu.Unlock()
+		removed = append(removed, l.Lease)
+	}
+	return removed
+}
+
+// due reports whether the peer of l is to be removed as of now, its session
+// having ended or its grace period being over. Callers hold e.mu.
+func (l *lease) due(now time.Time, grace time.Duration) bool {
+	if !l.SessionExpiresAt.IsZero() && !now.Before(l.SessionExpiresAt) {
+		return true
+	}
+	return !now.Before(l.ExpiresAt.Add(grace))
+}
+
+// addLease starts the lease of a newly enrolled peer. Callers hold e.mu.
+func (e *Enroller) addLease(publicKey string, addresses []string, hash tokenHash, now time.Time) {
+	l := &lease{
+		Lease: Lease{
+			PublicKey: publicKey,
+			Addresses: addresses,
+			RenewedAt: now,
+			ExpiresAt: now.Add(e.config.Lease.TTL.Std()),
+		},
+		hash: hash,
+	}
+	if e.config.Lease.MaxAge != 0 {
+		l.SessionExpiresAt = now.Add(e.config.Lease.MaxAge.Std())
+		if l.ExpiresAt.After(l.SessionExpiresAt) {
+			l.ExpiresAt = l.SessionExpiresAt
+		}
+	}
+	e.leases[publicKey] = l
+}
+
+// Reenroll replaces the key of the peer enrolled as publicKey with
+// newPublicKey before its session ends. secret is its current lease token.
+// The peer keeps its addresses and metadata, and gets a new lease token
+// and session. remote is kept for the audit trail.
+func (e *Enroller) Reenroll(publicKey, secret, newPublicKey, remote string) (config.WireGuardPeer, string, error) {
+	entry := AuditEntry{Actor: publicKey, PublicKey: newPublicKey, Remote: remote}
+	if _, err := wgtypes.ParseKey(newPublicKey); err != nil {
+		return config.WireGuardPeer{}, "", fmt.Errorf("%w: %w", ErrInvalidPublicKey, err)
+	}
+
+	e.mu.Lock()
+	l, ok := e.leases[publicKey]
+	hash := cryptopolicy.HashToken(secret)
+	if !ok || subtle.ConstantTimeCompare(hash[:], l.hash[:]) != 1 {
+		e.mu.Unlock()
+		return config.WireGuardPeer{}, "", ErrInvalidLease
+	}
+	if _, taken := e.enrolled[newPublicKey]; taken || newPublicKey == publicKey {
+		e.mu.Unlock()
+		return config.WireGuardPeer{}, "", fmt.Errorf("%w: key already in use", ErrInvalidPublicKey)
+	}
+	// Checked before the old peer is dropped, a failed registration would
+	// leave the client without either key
+	if _, exists := e.registry.Get(newPublicKey); exists {
+		e.mu.Unlock()
+		return config.WireGuardPeer{}, "", fmt.Errorf("%w: %s", peers.ErrPeerExists, newPublicKey)
+	}
+	old := e.enrolled[publicKey]
+	// Dropping the old peer first keeps its addresses allocated while it
+	// leaves the registry
+	delete(e.enrolled, publicKey)
+	delete(e.leases, publicKey)
+	e.mu.Unlock()
+
+	// The registry calls back into peerChanged, which needs e.mu
+	if _, err := e.registry.Remove(publicKey); err != nil && !errors.Is(err, peers.ErrPeerNotFound) {
+		slog.Warn("Failed to remove re-enrolled peer's old key", "public_key", publicKey, "error", err.Error())
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	peer, leaseToken, err := e.register(newPublicKey, old.Metadata, old.AllowedIPs, time.Now())
+	if err != nil {
+		entry.Action = AuditRejected
+		entry.Error = err.Error()
+		e.record(entry)
+		e.save()
+		return config.WireGuardPeer{}, "", err
+	}
+	e.save()
+
+	entry.Action = AuditReenrolled
+	e.record(entry)
+	slog.Info("Re-enrolled peer", "old_public_key", publicKey, "public_key", newPublicKey, "addresses", peer.AllowedIPs)
+	return peer, leaseToken, nil
+}
+
+// release returns the host addresses of a peer to the pools. Callers hold
+// e.mu.
+func (e *Enroller) release(addresses []string) {
+	for _, address := range addresses {
+		prefix, err := netip.ParsePrefix(address)
+		if err != nil {
+			slog.Warn("Not releasing invalid address", "address", address, "error", err.Error())
+			continue
+		}
+		if err := e.allocator.Release(prefix.Addr()); err != nil {
+			slog.Warn("Failed to release address", "address", address, "error", err.Error())
+		}
+	}
+}
+
+// emit publishes an event if anyone listens. Callers hold e.mu.
+func (e *Enroller) emit(eventType events.Type, publicKey, message string) {
+	if e.publish != nil {
+		e.publish(eventType, publicKey, message)
+	}
+}
@@ -0,0 +1,184 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package enroll
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/peers"
+	"github.com/kubewg-net/container/internal/state"
+)
+
+const (
+	// stateName is the document the enroller keeps its state in
+	stateName = "enrollment"
+	// stateVersion is bumped on incompatible changes to snapshot
+	stateVersion = 1
+
+	saveTimeout = 10 * time.Second
+)
+
+var (
+	ErrInvalidState = errors.New("invalid enrollment state")
+	ErrStateVersion = errors.New("unsupported enrollment state version")
+)
+
+// snapshot is the persisted state of an Enroller. Tokens and leases are
+// kept as hashes only, like in memory.
+type snapshot struct {
+	Version     int                    `json:"version"`
+	Tokens      []savedToken           `json:"tokens"`
+	Peers       []config.WireGuardPeer `json:"peers"`
+	Leases      []savedLease           `json:"leases"`
+	Allocations []netip.Addr           `json:"allocations"`
+}
+
+type savedToken struct {
+	Token
+	Hash string `json:"hash"`
+}
+
+type savedLease struct {
+	Lease
+	Hash string `json:"hash"`
+}
+
+// UseStore restores what was saved in store, re-registering the enrolled
+// peers, and saves every change there from now on. Calling it again merges
+// the saved state into the current one, as after a state import.
+func (e *Enroller) UseStore(ctx context.Context, store state.Store) error {
+	data, err := store.Load(ctx, stateName)
+	if err != nil && !errors.Is(err, state.ErrNotFound) {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if data != nil {
+		var saved snapshot
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidState, err)
+		}
+		if err := e.restore(&saved); err != nil {
+			return err
+		}
+		slog.Info("Restored enrollment state", "tokens", len(e.tokens), "peers", len(e.enrolled), "leases", len(e.leases))
+	}
+	e.state = store
+	if data != nil {
+		// Write back what the merge added
+		e.save()
+	}
+	return nil
+}
+
+// restore loads saved on top of the current state. Callers hold e.mu.
+func (e *Enroller) restore(saved *snapshot) error {
+	if saved.Version != stateVersion {
+		return fmt.Errorf("%w: %d", ErrStateVersion, saved.Version)
+	}
+
+	for _, addr := range saved.Allocations {
+		if err := e.allocator.Claim(addr); err != nil {
+			slog.Warn("Dropping saved allocation outside the pools", "address", addr.String(), "error", err.Error())
+		}
+	}
+	for _, token := range saved.Tokens {
+		hash, err := parseHash(token.Hash)
+		if err != nil {
+			return err
+		}
+		e.tokens[hash] = token.Token
+	}
+	for _, peer := range saved.Peers {
+		// The registry may know the peer already, e.g. from an import
+		if err := e.registry.Add(peer); err != nil && !errors.Is(err, peers.ErrPeerExists) {
+			return fmt.Errorf("failed to restore enrolled peer %s: %w", peer.PublicKey, err)
+		}
+		e.enrolled[peer.PublicKey] = peer
+	}
+	for _, l := range saved.Leases {
+		hash, err := parseHash(l.Hash)
+		if err != nil {
+			return err
+		}
+		e.leases[l.PublicKey] = &lease{Lease: l.Lease, hash: hash}
+	}
+	return nil
+}
+
+// snapshot captures the current state. Callers hold e.mu.
+func (e *Enroller) snapshot() *snapshot {
+	saved := &snapshot{
+		Version:     stateVersion,
+		Tokens:      make([]savedToken, 0, len(e.tokens)),
+		Peers:       make([]config.WireGuardPeer, 0, len(e.enrolled)),
+		Leases:      make([]savedLease, 0, len(e.leases)),
+		Allocations: e.allocator.Allocated(),
+	}
+	for hash, token := range e.tokens {
+		saved.Tokens = append(saved.Tokens, savedToken{Token: token, Hash: hex.EncodeToString(hash[:])})
+	}
+	for _, peer := range e.enrolled {
+		saved.Peers = append(saved.Peers, peer)
+	}
+	for _, l := range e.leases {
+		saved.Leases = append(saved.Leases, savedLease{Lease: l.Lease, Hash: hex.EncodeToString(l.hash[:])})
+	}
+	return saved
+}
+
+// save writes the current state to the store, if there is one. A failed
+// write is logged, the next change writes everything again. Callers hold
+// e.mu.
+func (e *Enroller) save() {
+	if e.state == nil {
+		return
+	}
+	data, err := json.Marshal(e.snapshot())
+	if err != nil {
+		slog.Error("Failed to encode enrollment state", "error", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), saveTimeout)
+	defer cancel()
+	if err := e.state.Save(ctx, stateName, data); err != nil {
+		slog.Error("Failed to save enrollment state", "error", err.Error())
+	}
+}
+
+func parseHash(s string) (tokenHash, error) {
+	var hash tokenHash
+	raw, err := hex.DecodeString(s)
+	if err != nil || len(raw) != len(hash) {
+		return hash, fmt.Errorf("%w: hash %q", ErrInvalidState, s)
+	}
+	copy(hash[:], raw)
+	return hash, nil
+}
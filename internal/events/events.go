@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package events fans peer lifecycle events out to the integrations that
+// report them, such as Kubernetes Events.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+type Type string
+
+const (
+	PeerAdded         Type = "PeerAdded"
+	PeerRemoved       Type = "PeerRemoved"
+	HandshakeFailed   Type = "HandshakeFailed"
+	HandshakeRestored Type = "HandshakeRestored"
+	KeyRotated        Type = "KeyRotated"
+	// LeaseExpired starts the grace period of an enrolled peer that didn't
+	// renew, the peer is removed at its end
+	LeaseExpired Type = "LeaseExpired"
+)
+
+// Warning reports whether the event describes a problem.
+func (t Type) Warning() bool {
+	return t == HandshakeFailed || t == LeaseExpired
+}
+
+type Event struct {
+	Type      Type      `json:"type"`
+	PublicKey string    `json:"public_key"`
+	Message   string    `json:"message"`
+	Time      time.Time `json:"time"`
+}
+
+// Sink receives published events. Publish must not block for long since it
+// runs on the publisher's goroutine.
+type Sink interface {
+	Publish(event Event)
+}
+
+// Bus delivers every published event to all subscribed sinks.
+type Bus struct {
+	mu    sync.RWMutex
+	sinks []Sink
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+func (b *Bus) Subscribe(sink Sink) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sinks = append(b.sinks, sink)
+}
+
+func (b *Bus) Publish(eventType Type, publicKey, message string) {
+	event := Event{
+		Type:      eventType,
+		PublicKey: publicKey,
+		Message:   message,
+		Time:      time.Now(),
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, sink := range b.sinks {
+		sink.Publish(event)
+	}
+}
@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+package events_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/events"
+)
+
+type recorder struct {
+	events []events.Event
+}
+
+func (r *recorder) Publish(event events.Event) {
+	r.events = append(r.events, event)
+}
+
+func TestPublish(t *testing.T) {
+	t.Parallel()
+
+	bus := events.NewBus()
+	// Publishing without subscribers is a no-op
+	bus.Publish(events.PeerAdded, "dropped", "nobody listens")
+
+	first, second := &recorder{}, &recorder{}
+	bus.Subscribe(first)
+	bus.Subscribe(second)
+
+	before := time.Now()
+	bus.Publish(events.HandshakeFailed, "key", "no handshake for 3m")
+
+	for _, sink := range []*recorder{first, second} {
+		if len(sink.events) != 1 {
+			t.Fatalf("expected every sink to get one event, got %v", sink.events)
+		}
+		event := sink.events[0]
+		if event.Type != events.HandshakeFailed || event.PublicKey != "key" || event.Message != "no handshake for 3m" {
+			t.Errorf("expected the published event, got %+v", event)
+		}
+		if event.Time.Before(before) {
+			t.Errorf("expected the event to be stamped after %s, got %s", before, event.Time)
+		}
+	}
+}
+
+func TestWarning(t *testing.T) {
+	t.Parallel()
+
+	tests := map[events.Type]bool{
+		events.PeerAdded:         false,
+		events.PeerRemoved:       false,
+		events.HandshakeFailed:   true,
+		events.HandshakeRestored: false,
+		events.KeyRotated:        false,
+		events.LeaseExpired:      true,
+	}
+	for eventType, expected := range tests {
+		if got := eventType.Warning(); got != expected {
+			t.Errorf("expected %s warning to be %t, got %t", eventType, expected, got)
+		}
+	}
+}
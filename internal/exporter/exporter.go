@@ -0,0 +1,328 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package exporter reports on a WireGuard interface, managed by kubewg or
+// not, as Prometheus metrics and a JSON status.
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kubewg-net/container/internal/secmem"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// staleHandshake is how old a handshake may get before WireGuard rejects
+// the session it set up
+const staleHandshake = 180 * time.Second
+
+// NameFunc returns the configured name of a peer, or "" if it has none
+type NameFunc func(publicKey string) string
+
+//nolint:golint,gochecknoglobals
+var (
+	interfaceUp = prometheus.NewDesc(
+		"kubewg_interface_up",
+		"Whether the WireGuard interface could be read (1) or not (0)",
+		[]string{"interface"}, nil)
+	interfaceInfo = prometheus.NewDesc(
+		"kubewg_interface_info",
+		"Public key and listen port of the WireGuard interface",
+		[]string{"interface", "public_key", "listen_port"}, nil)
+	interfacePeers = prometheus.NewDesc(
+		"kubewg_interface_peers",
+		"Number of peers on the WireGuard interface",
+		[]string{"interface"}, nil)
+	peerReceiveBytes = prometheus.NewDesc(
+		"kubewg_peer_receive_bytes_total",
+		"Bytes received from a peer",
+		[]string{"interface", "public_key", "name"}, nil)
+	peerTransmitBytes = prometheus.NewDesc(
+		"kubewg_peer_transmit_bytes_total",
+		"Bytes sent to a peer",
+		[]string{"interface", "public_key", "name"}, nil)
+	peerReceiveRate = prometheus.NewDesc(
+		"kubewg_peer_receive_bytes_per_second",
+		"Bytes per second received from a peer between the last two samples",
+		[]string{"interface", "public_key", "name"}, nil)
+	peerTransmitRate = prometheus.NewDesc(
+		"kubewg_peer_transmit_bytes_per_second",
+		"Bytes per second sent to a peer between the last two samples",
+		[]string{"interface", "public_key", "name"}, nil)
+	peerLastHandshake = prometheus.NewDesc(
+		"kubewg_peer_last_handshake_timestamp_seconds",
+		"Unix time of the last handshake with a peer, 0 if there was none",
+		[]string{"interface", "public_key", "name"}, nil)
+	peerHandshakeStale = prometheus.NewDesc(
+		"kubewg_peer_handshake_stale",
+		"Whether the last handshake with a peer is over 3 minutes old or there was none (1) or not (0), idle peers without persistent keepalive go stale too",
+		[]string{"interface", "public_key", "name"}, nil)
+)
+
+// Exporter reads the interface on every scrape or status request, so it
+// never reports stale numbers and costs nothing in between. Only the rates
+// come from the samples SampleRates takes.
+type Exporter struct {
+	name   string
+	names  NameFunc
+	client Client
+	rates  *RateTracker
+	// others are reported in the metrics next to the interface, but not
+	// in Status
+	others []*Exporter
+}
+
+// Client reads WireGuard interfaces, such as a *wgctrl.Client.
+type Client interface {
+	Device(name string) (*wgtypes.Device, error)
+	Close() error
+}
+
+func New(name string, names NameFunc) (*Exporter, error) {
+	client, err := wgctrl.New()
+	if err != nil {
+		return nil, err
+	}
+	return newExporter(keyless{client}, name, names), nil
+}
+
+// NewWithClient reads the interfaces through client instead, e.g. when
+// another process owns them.
+func NewWithClient(client Client, name string, names NameFunc) *Exporter {
+	return newExporter(keyless{client}, name, names)
+}
+
+// keyless wipes the keys of every device read, the exporter never reports
+// them and they shouldn't linger on the heap until it is collected.
+type keyless struct {
+	Client
+}
+
+func (c keyless) Device(name string) (*wgtypes.Device, error) {
+	device, err := c.Client.Device(name)
+	if err == nil {
+		secmem.WipeDevice(device)
+	}
+	return device, err
+}
+
+func newExporter(client Client, name string, names NameFunc) *Exporter {
+	if names == nil {
+		names = func(string) string { return "" }
+	}
+	return &Exporter{
+		name:   name,
+		names:  names,
+		client: client,
+		rates:  NewRateTracker(),
+	}
+}
+
+// Add reports on another interface in the metrics. One collector has to
+// cover all of them, a second one would register the same metrics again.
+// It must be called before the exporter is registered.
+func (e *Exporter) Add(name string, names NameFunc) {
+	e.others = append(e.others, newExporter(e.client, name, names))
+}
+
+func (e *Exporter) Close() error {
+	return e.client.Close()
+}
+
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- interfaceUp
+	ch <- interfaceInfo
+	ch <- interfacePeers
+	ch <- peerReceiveBytes
+	ch <- peerTransmitBytes
+	ch <- peerReceiveRate
+	ch <- peerTransmitRate
+	ch <- peerLastHandshake
+	ch <- peerHandshakeStale
+}
+
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.collect(ch)
+	for _, other := range e.others {
+		other.collect(ch)
+	}
+}
+
+func (e *Exporter) collect(ch chan<- prometheus.Metric) {
+	device, err := e.client.Device(e.name)
+	if err != nil {
+		slog.Debug("Failed to read WireGuard interface", "interface", e.name, "error", err.Error())
+		ch <- prometheus.MustNewConstMetric(interfaceUp, prometheus.GaugeValue, 0, e.name)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(interfaceUp, prometheus.GaugeValue, 1, e.name)
+	ch <- prometheus.MustNewConstMetric(interfaceInfo, prometheus.GaugeValue, 1, e.name, device.PublicKey.String(), strconv.Itoa(device.ListenPort))
+	ch <- prometheus.MustNewConstMetric(interfacePeers, prometheus.GaugeValue, float64(len(device.Peers)), e.name)
+	now := time.Now()
+	for _, peer := range device.Peers {
+		publicKey := peer.PublicKey.String()
+		name := e.names(publicKey)
+		ch <- prometheus.MustNewConstMetric(peerReceiveBytes, prometheus.CounterValue, float64(peer.ReceiveBytes), e.name, publicKey, name)
+		ch <- prometheus.MustNewConstMetric(peerTransmitBytes, prometheus.CounterValue, float64(peer.TransmitBytes), e.name, publicKey, name)
+		if rate, ok := e.rates.Rate(publicKey); ok {
+			ch <- prometheus.MustNewConstMetric(peerReceiveRate, prometheus.GaugeValue, rate.Receive, e.name, publicKey, name)
+			ch <- prometheus.MustNewConstMetric(peerTransmitRate, prometheus.GaugeValue, rate.Transmit, e.name, publicKey, name)
+		}
+		ch <- prometheus.MustNewConstMetric(peerLastHandshake, prometheus.GaugeValue, handshakeSeconds(peer.LastHandshakeTime), e.name, publicKey, name)
+		ch <- prometheus.MustNewConstMetric(peerHandshakeStale, prometheus.GaugeValue, handshakeStale(peer.LastHandshakeTime, now), e.name, publicKey, name)
+	}
+}
+
+// SampleRates reads the byte counters every interval until ctx is done,
+// updating the rates reported by Collect and Status.
+func (e *Exporter) SampleRates(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, target := range append([]*Exporter{e}, e.others...) {
+			if device, err := e.client.Device(target.name); err == nil {
+				target.rates.Update(device.Peers, time.Now())
+			} else {
+				slog.Debug("Failed to sample WireGuard interface", "interface", target.name, "error", err.Error())
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func handshakeSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+func handshakeStale(t, now time.Time) float64 {
+	if t.IsZero() || now.Sub(t) > staleHandshake {
+		return 1
+	}
+	return 0
+}
+
+type Status struct {
+	Interface  string       `json:"interface"`
+	PublicKey  string       `json:"public_key"`
+	ListenPort int          `json:"listen_port"`
+	Peers      []PeerStatus `json:"peers"`
+}
+
+type PeerStatus struct {
+	PublicKey           string    `json:"public_key"`
+	Name                string    `json:"name,omitempty"`
+	Endpoint            string    `json:"endpoint,omitempty"`
+	AllowedIPs          []string  `json:"allowed_ips"`
+	LastHandshake       time.Time `json:"last_handshake,omitempty"`
+	ReceiveBytes        int64     `json:"receive_bytes"`
+	TransmitBytes       int64     `json:"transmit_bytes"`
+	Rate                *Rate     `json:"rate,omitempty"`
+	PersistentKeepalive uint32    `json:"persistent_keepalive,omitempty"`
+}
+
+// Backend names the implementation behind the interface, "kernel",
+// "userspace" or "unknown" when it can't be read.
+func (e *Exporter) Backend() string {
+	device, err := e.client.Device(e.name)
+	if err != nil {
+		return "unknown"
+	}
+	switch device.Type {
+	case wgtypes.LinuxKernel, wgtypes.OpenBSDKernel, wgtypes.FreeBSDKernel, wgtypes.WindowsKernel:
+		return "kernel"
+	case wgtypes.Userspace:
+		return "userspace"
+	case wgtypes.Unknown:
+		return "unknown"
+	}
+	return "unknown"
+}
+
+// Status describes the interface and its peers. Preshared keys are never
+// included.
+func (e *Exporter) Status() (*Status, error) {
+	device, err := e.client.Device(e.name)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &Status{
+		Interface:  device.Name,
+		PublicKey:  device.PublicKey.String(),
+		ListenPort: device.ListenPort,
+		Peers:      make([]PeerStatus, 0, len(device.Peers)),
+	}
+	for _, peer := range device.Peers {
+		status.Peers = append(status.Peers, e.peerStatus(&peer))
+	}
+	return status, nil
+}
+
+func (e *Exporter) peerStatus(peer *wgtypes.Peer) PeerStatus {
+	status := PeerStatus{
+		PublicKey:           peer.PublicKey.String(),
+		Name:                e.names(peer.PublicKey.String()),
+		AllowedIPs:          make([]string, 0, len(peer.AllowedIPs)),
+		LastHandshake:       peer.LastHandshakeTime,
+		ReceiveBytes:        peer.ReceiveBytes,
+		TransmitBytes:       peer.TransmitBytes,
+		PersistentKeepalive: uint32(peer.PersistentKeepaliveInterval / time.Second),
+	}
+	if rate, ok := e.rates.Rate(status.PublicKey); ok {
+		status.Rate = &rate
+	}
+	if peer.Endpoint != nil {
+		status.Endpoint = peer.Endpoint.String()
+	}
+	for _, allowedIP := range peer.AllowedIPs {
+		status.AllowedIPs = append(status.AllowedIPs, allowedIP.String())
+	}
+	return status
+}
+
+// ServeHTTP serves Status as JSON.
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	status, err := e.Status()
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		slog.Error("Failed to write status response", "error", err.Error())
+	}
+}
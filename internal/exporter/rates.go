@@ -0,0 +1,93 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package exporter
+
+import (
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Rate is a peer's throughput in bytes per second.
+type Rate struct {
+	Receive  float64 `json:"receive"`
+	Transmit float64 `json:"transmit"`
+}
+
+type sample struct {
+	receive  int64
+	transmit int64
+	at       time.Time
+}
+
+// RateTracker computes the throughput of every peer from the byte counters
+// of consecutive samples, so the rates don't depend on how often, or by
+// how many, the metrics are scraped.
+type RateTracker struct {
+	mu      sync.RWMutex
+	samples map[string]sample
+	rates   map[string]Rate
+}
+
+func NewRateTracker() *RateTracker {
+	return &RateTracker{
+		samples: make(map[string]sample),
+		rates:   make(map[string]Rate),
+	}
+}
+
+// Update records the counters of peers read at now. Peers that are gone
+// are forgotten, and a peer whose counters went backwards, because it was
+// removed and added again in between, starts over.
+func (t *RateTracker) Update(peers []wgtypes.Peer, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	samples := make(map[string]sample, len(peers))
+	rates := make(map[string]Rate, len(peers))
+	for i := range peers {
+		publicKey := peers[i].PublicKey.String()
+		current := sample{receive: peers[i].ReceiveBytes, transmit: peers[i].TransmitBytes, at: now}
+		samples[publicKey] = current
+
+		previous, ok := t.samples[publicKey]
+		elapsed := now.Sub(previous.at).Seconds()
+		if !ok || elapsed <= 0 || current.receive < previous.receive || current.transmit < previous.transmit {
+			continue
+		}
+		rates[publicKey] = Rate{
+			Receive:  float64(current.receive-previous.receive) / elapsed,
+			Transmit: float64(current.transmit-previous.transmit) / elapsed,
+		}
+	}
+	t.samples = samples
+	t.rates = rates
+}
+
+// Rate returns the throughput of the peer with publicKey between the last
+// two samples, and false until there are two.
+func (t *RateTracker) Rate(publicKey string) (Rate, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	rate, ok := t.rates[publicKey]
+	return rate, ok
+}
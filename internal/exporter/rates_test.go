@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package exporter_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/exporter"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestRateTracker(t *testing.T) {
+	t.Parallel()
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	publicKey := key.PublicKey()
+	start := time.Now()
+	tracker := exporter.NewRateTracker()
+
+	tracker.Update([]wgtypes.Peer{{PublicKey: publicKey, ReceiveBytes: 1000, TransmitBytes: 500}}, start)
+	if _, ok := tracker.Rate(publicKey.String()); ok {
+		t.Fatal("expected no rate after a single sample")
+	}
+
+	tracker.Update([]wgtypes.Peer{{PublicKey: publicKey, ReceiveBytes: 21000, TransmitBytes: 5500}}, start.Add(10*time.Second))
+	rate, ok := tracker.Rate(publicKey.String())
+	if !ok {
+		t.Fatal("expected a rate after two samples")
+	}
+	if rate.Receive != 2000 || rate.Transmit != 500 {
+		t.Errorf("expected 2000 B/s received and 500 B/s sent, got %+v", rate)
+	}
+
+	// The peer was re-added and its counters started over
+	tracker.Update([]wgtypes.Peer{{PublicKey: publicKey, ReceiveBytes: 100}}, start.Add(20*time.Second))
+	if _, ok := tracker.Rate(publicKey.String()); ok {
+		t.Error("expected no rate after the counters were reset")
+	}
+
+	tracker.Update(nil, start.Add(30*time.Second))
+	if _, ok := tracker.Rate(publicKey.String()); ok {
+		t.Error("expected a removed peer to be forgotten")
+	}
+}
@@ -0,0 +1,167 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package features is the registry of feature gates, following the
+// Kubernetes conventions: every feature has a maturity stage and a default,
+// alpha features are off until enabled, beta ones on until disabled, and GA
+// ones locked on before their gate is removed.
+package features
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnknown = errors.New("unknown feature gate")
+	ErrLocked  = errors.New("feature gate is locked to its default")
+	ErrFormat  = errors.New("feature gates must be written as Name=true,Other=false")
+)
+
+// Feature names a feature gate, in UpperCamelCase.
+type Feature string
+
+// Stage is the maturity of a feature.
+type Stage string
+
+const (
+	Alpha      Stage = "ALPHA"
+	Beta       Stage = "BETA"
+	GA         Stage = ""
+	Deprecated Stage = "DEPRECATED"
+)
+
+// Spec describes a registered feature.
+type Spec struct {
+	Default bool
+	Stage   Stage
+	// LockToDefault refuses any other value, for GA features whose gate
+	// is kept for a release so configs setting it still load
+	LockToDefault bool
+}
+
+// The gates, each checked where its subsystem is set up
+const (
+	// ACL filters the traffic peers send through the tunnel, see
+	// wireguard.acl
+	ACL Feature = "ACL"
+	// NATTraversal punches holes through NATs to peers behind them, see
+	// wireguard.hole_punching
+	NATTraversal Feature = "NATTraversal"
+)
+
+//nolint:golint,gochecknoglobals
+var registry = map[Feature]Spec{
+	ACL:          {Default: true, Stage: Beta},
+	NATTraversal: {Default: true, Stage: Beta},
+}
+
+// Lookup returns the spec of a registered feature.
+func Lookup(feature Feature) (Spec, bool) {
+	spec, ok := registry[feature]
+	return spec, ok
+}
+
+// Known returns the registered features sorted by name.
+func Known() []Feature {
+	known := make([]Feature, 0, len(registry))
+	for feature := range registry {
+		known = append(known, feature)
+	}
+	slices.Sort(known)
+	return known
+}
+
+// Describe lists a feature the way Kubernetes' --feature-gates help does,
+// e.g. ACL=true|false (BETA - default=true).
+func Describe(feature Feature) string {
+	spec := registry[feature]
+	stage := spec.Stage
+	if stage == GA {
+		stage = "GA"
+	}
+	return fmt.Sprintf("%s=true|false (%s - default=%t)", feature, stage, spec.Default)
+}
+
+// Enabled reports whether the feature is on with the given gates, falling
+// back to its default. Unregistered features are off.
+func Enabled(gates map[string]bool, feature Feature) bool {
+	spec, ok := registry[feature]
+	if !ok {
+		return false
+	}
+	if enabled, ok := gates[string(feature)]; ok && !spec.LockToDefault {
+		return enabled
+	}
+	return spec.Default
+}
+
+// Validate checks that the gates name registered features and leave
+// locked ones at their default.
+func Validate(gates map[string]bool) error {
+	for name, enabled := range gates {
+		spec, ok := registry[Feature(name)]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrUnknown, name)
+		}
+		if spec.LockToDefault && enabled != spec.Default {
+			return fmt.Errorf("%w: %s=%t", ErrLocked, name, spec.Default)
+		}
+	}
+	return nil
+}
+
+// Parse reads gates in the --feature-gates format, Name=true,Other=false.
+func Parse(s string) (map[string]bool, error) {
+	gates := map[string]bool{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("%w: %q", ErrFormat, pair)
+		}
+		enabled, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrFormat, pair)
+		}
+		gates[name] = enabled
+	}
+	return gates, nil
+}
+
+// Format writes gates in the --feature-gates format, sorted by name.
+func Format(gates map[string]bool) string {
+	names := make([]string, 0, len(gates))
+	for name := range gates {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+strconv.FormatBool(gates[name]))
+	}
+	return strings.Join(pairs, ",")
+}
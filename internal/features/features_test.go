@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package features_test
+
+import (
+	"errors"
+	"maps"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/features"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	gates, err := features.Parse("ACL=false, NATTraversal=true,,")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := map[string]bool{"ACL": false, "NATTraversal": true}
+	if !maps.Equal(gates, expected) {
+		t.Errorf("expected %v, got %v", expected, gates)
+	}
+	if s := features.Format(gates); s != "ACL=false,NATTraversal=true" {
+		t.Errorf("expected the gates to format back, got %q", s)
+	}
+
+	for _, input := range []string{"ACL", "ACL=maybe", "=true"} {
+		if _, err := features.Parse(input); !errors.Is(err, features.ErrFormat) {
+			t.Errorf("expected %v for %q, got %v", features.ErrFormat, input, err)
+		}
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	t.Parallel()
+
+	if !features.Enabled(nil, features.ACL) {
+		t.Error("expected the beta ACL feature to default to on")
+	}
+	if features.Enabled(map[string]bool{"ACL": false}, features.ACL) {
+		t.Error("expected the gate to turn the ACL feature off")
+	}
+	if features.Enabled(map[string]bool{"Unknown": true}, "Unknown") {
+		t.Error("expected unregistered features to be off")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	if err := features.Validate(map[string]bool{"ACL": false, "NATTraversal": true}); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if err := features.Validate(map[string]bool{"CNIMode": true}); !errors.Is(err, features.ErrUnknown) {
+		t.Errorf("expected %v, got %v", features.ErrUnknown, err)
+	}
+	for _, feature := range features.Known() {
+		spec, ok := features.Lookup(feature)
+		if !ok {
+			t.Errorf("expected %s to be registered", feature)
+		}
+		if spec.LockToDefault && spec.Stage != features.GA && spec.Stage != features.Deprecated {
+			t.Errorf("expected only GA or deprecated features to be locked, got %s", feature)
+		}
+	}
+}
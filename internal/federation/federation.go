@@ -0,0 +1,328 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package federation exchanges node peers between clusters, so pods in one
+// cluster can reach pods in another over WireGuard.
+package federation
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/cryptopolicy"
+	"github.com/kubewg-net/container/internal/peers"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const (
+	// MetadataCluster marks the cluster a federated peer belongs to
+	MetadataCluster = "kubewg.net/cluster"
+
+	// PeersPath is where every federated cluster serves its node peers
+	PeersPath = "/api/v1/federation/peers"
+
+	requestTimeout = 30 * time.Second
+)
+
+var (
+	ErrNoCACerts      = errors.New("no certificates found in CA file")
+	ErrRemoteResponse = errors.New("federation remote returned an error")
+	ErrAllowedIP      = errors.New("invalid allowed IP")
+)
+
+// LocalPeer describes this node the way remote clusters should configure
+// it: reachable at the WireGuard endpoint and routing its tunnel addresses
+// plus the advertised CIDRs.
+func LocalPeer(federation *config.Federation, wg *config.WireGuard, publicKey wgtypes.Key) (config.WireGuardPeer, error) {
+	allowedIPs := make([]string, 0, len(wg.Addresses)+len(federation.AdvertiseCIDRs))
+	for _, address := range wg.Addresses {
+		prefix, err := netip.ParsePrefix(address)
+		if err != nil {
+			return config.WireGuardPeer{}, fmt.Errorf("invalid address %q: %w", address, err)
+		}
+		allowedIPs = append(allowedIPs, netip.PrefixFrom(prefix.Addr(), prefix.Addr().BitLen()).String())
+	}
+	allowedIPs = append(allowedIPs, federation.AdvertiseCIDRs...)
+
+	return config.WireGuardPeer{
+		Name:       federation.ClusterName,
+		PublicKey:  publicKey.String(),
+		Endpoint:   wg.Endpoint,
+		AllowedIPs: allowedIPs,
+		Metadata: map[string]string{
+			MetadataCluster: federation.ClusterName,
+		},
+	}, nil
+}
+
+type remote struct {
+	config *config.FederationRemote
+	client *http.Client
+	// allowed bounds the allowed IPs of the remote's peers
+	allowed []netip.Prefix
+	// peers are the public keys last learned from this remote
+	peers map[string]struct{}
+	// synced is set once the remote answered
+	synced bool
+}
+
+// Federator periodically pulls the node peers of every remote cluster into
+// the registry, and drops the ones a remote stopped advertising.
+type Federator struct {
+	config   *config.Federation
+	registry *peers.Registry
+	remotes  []*remote
+	mu       sync.Mutex
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewFederator(config *config.Federation, policy *config.CryptoPolicy, registry *peers.Registry) (*Federator, error) {
+	federator := &Federator{
+		config:   config,
+		registry: registry,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	for i := range config.Remotes {
+		client, err := httpClient(&config.Remotes[i], policy)
+		if err != nil {
+			return nil, fmt.Errorf("federation remote %s: %w", config.Remotes[i].Name, err)
+		}
+		allowed := make([]netip.Prefix, 0, len(config.Remotes[i].AllowedCIDRs))
+		for _, cidr := range config.Remotes[i].AllowedCIDRs {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("federation remote %s: invalid allowed CIDR %q: %w", config.Remotes[i].Name, cidr, err)
+			}
+			allowed = append(allowed, prefix.Masked())
+		}
+		federator.remotes = append(federator.remotes, &remote{
+			config:  &config.Remotes[i],
+			client:  client,
+			allowed: allowed,
+			peers:   make(map[string]struct{}),
+		})
+	}
+	return federator, nil
+}
+
+// Start syncs every remote until Stop is called or ctx is done.
+func (f *Federator) Start(ctx context.Context) {
+	defer close(f.done)
+
+	ticker := time.NewTicker(f.config.Interval.Std())
+	defer ticker.Stop()
+
+	slog.Info("Federation started", "cluster", f.config.ClusterName, "remotes", len(f.remotes))
+
+	for {
+		f.Sync(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-f.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (f *Federator) Stop(ctx context.Context) error {
+	close(f.stop)
+	select {
+	case <-f.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("timed out waiting for federation to stop: %w", ctx.Err())
+	}
+}
+
+// Sync pulls the peers of every remote once. A remote that can't be reached
+// keeps the peers it advertised last, so a management outage doesn't cut
+// clusters apart.
+func (f *Federator) Sync(ctx context.Context) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, r := range f.remotes {
+		bundle, err := r.fetch(ctx)
+		if err != nil {
+			slog.Warn("Failed to sync federation remote", "remote", r.config.Name, "error", err.Error())
+			continue
+		}
+		f.apply(r, bundle)
+	}
+}
+
+// Backing is a peers.BackingFunc for the peers learned from a remote. A
+// peer exists as long as its remote is configured and advertised it last,
+// so an unreachable remote keeps its peers. Until a remote first answers
+// its peers aren't owned.
+func (f *Federator) Backing(peer config.WireGuardPeer) (bool, bool) {
+	cluster, ok := peer.Metadata[MetadataCluster]
+	if !ok {
+		return false, false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, r := range f.remotes {
+		if r.config.Name == cluster {
+			_, ok := r.peers[peer.PublicKey]
+			return ok, r.synced
+		}
+	}
+	return false, true
+}
+
+// apply puts the remote's peers into the registry. A remote only ever
+// touches the peers tagged with its own name, a key already used by a
+// local peer or another remote is refused rather than taken over.
+func (f *Federator) apply(r *remote, bundle *peers.Bundle) {
+	owns := func(peer config.WireGuardPeer) bool {
+		return peer.Metadata[MetadataCluster] == r.config.Name
+	}
+
+	seen := make(map[string]struct{}, len(bundle.Peers))
+	for _, peer := range bundle.Peers {
+		allowedIPs, err := r.allowedIPs(peer.AllowedIPs)
+		if err != nil {
+			slog.Warn("Skipping federated peer", "remote", r.config.Name, "public_key", peer.PublicKey, "error", err.Error())
+			continue
+		}
+		if len(allowedIPs) != len(peer.AllowedIPs) {
+			slog.Warn("Dropped allowed IPs of federated peer outside the remote's allowed CIDRs", "remote", r.config.Name,
+				"public_key", peer.PublicKey, "advertised", peer.AllowedIPs, "kept", allowedIPs)
+		}
+		peer.AllowedIPs = allowedIPs
+		peer.Metadata = maps.Clone(peer.Metadata)
+		if peer.Metadata == nil {
+			peer.Metadata = make(map[string]string, 1)
+		}
+		peer.Metadata[MetadataCluster] = r.config.Name
+		// Keys of remote peers never leave their cluster
+		peer.PresharedKey = ""
+
+		if err := f.registry.PutOwned(peer, owns); err != nil {
+			slog.Warn("Skipping federated peer", "remote", r.config.Name, "public_key", peer.PublicKey, "error", err.Error())
+			continue
+		}
+		seen[peer.PublicKey] = struct{}{}
+	}
+
+	for publicKey := range r.peers {
+		if _, ok := seen[publicKey]; ok {
+			continue
+		}
+		_, err := f.registry.RemoveOwned(publicKey, owns)
+		if err != nil && !errors.Is(err, peers.ErrPeerNotFound) && !errors.Is(err, peers.ErrPeerNotOwned) {
+			slog.Warn("Failed to remove federated peer", "remote", r.config.Name, "public_key", publicKey, "error", err.Error())
+		}
+	}
+	r.peers = seen
+	r.synced = true
+}
+
+// allowedIPs keeps the advertised allowed IPs that lie within the remote's
+// allowed CIDRs. A malformed one fails the whole peer.
+func (r *remote) allowedIPs(advertised []string) ([]string, error) {
+	kept := make([]string, 0, len(advertised))
+	for _, cidr := range advertised {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrAllowedIP, cidr)
+		}
+		for _, allowed := range r.allowed {
+			if allowed.Bits() <= prefix.Bits() && allowed.Contains(prefix.Addr()) {
+				kept = append(kept, prefix.Masked().String())
+				break
+			}
+		}
+	}
+	return kept, nil
+}
+
+func (r *remote) fetch(ctx context.Context) (*peers.Bundle, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(r.config.URL, "/")+PeersPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if r.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.config.Token)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call remote: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrRemoteResponse, resp.Status)
+	}
+
+	var bundle peers.Bundle
+	if err := json.NewDecoder(resp.Body).Decode(&bundle); err != nil {
+		return nil, fmt.Errorf("failed to decode peers: %w", err)
+	}
+	if err := bundle.Validate(); err != nil {
+		return nil, err
+	}
+	return &bundle, nil
+}
+
+func httpClient(remote *config.FederationRemote, policy *config.CryptoPolicy) (*http.Client, error) {
+	tlsConfig := cryptopolicy.TLSConfig(policy)
+	if remote.CAFile != "" {
+		pem, err := os.ReadFile(remote.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, ErrNoCACerts
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}, nil
+}
@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package federation_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/federation"
+	"github.com/kubewg-net/container/internal/peers"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func testKey(t *testing.T) string {
+	t.Helper()
+
+	key, err := wgtypes.GenerateKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return key.PublicKey().String()
+}
+
+func TestSyncStaysWithinRemote(t *testing.T) {
+	t.Parallel()
+
+	local, other, remote := testKey(t), testKey(t), testKey(t)
+	registry := peers.NewRegistry(nil)
+	for _, peer := range []config.WireGuardPeer{
+		{Name: "api", PublicKey: local, AllowedIPs: []string{"10.0.0.2/32"}},
+		{Name: "other", PublicKey: other, AllowedIPs: []string{"10.30.0.0/16"}, Metadata: map[string]string{federation.MetadataCluster: "us-east"}},
+	} {
+		if err := registry.Add(peer); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	bundle := peers.Bundle{Version: peers.BundleVersion, Peers: []config.WireGuardPeer{
+		{Name: "hijack", PublicKey: local, AllowedIPs: []string{"10.20.0.0/16"}},
+		{Name: "takeover", PublicKey: other, AllowedIPs: []string{"10.20.1.0/24"}},
+		{Name: "node", PublicKey: remote, AllowedIPs: []string{"10.20.0.0/24", "0.0.0.0/0", "10.0.0.0/8"}},
+	}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(bundle)
+	}))
+	defer server.Close()
+
+	federator, err := federation.NewFederator(&config.Federation{
+		ClusterName: "local",
+		Remotes:     []config.FederationRemote{{Name: "eu-west", URL: server.URL, AllowedCIDRs: []string{"10.20.0.0/16"}}},
+	}, &config.CryptoPolicy{}, registry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	federator.Sync(context.Background())
+
+	if peer, _ := registry.Get(local); peer.Name != "api" {
+		t.Errorf("expected the local peer to be kept, got %+v", peer)
+	}
+	if peer, _ := registry.Get(other); peer.Name != "other" {
+		t.Errorf("expected the other remote's peer to be kept, got %+v", peer)
+	}
+	peer, ok := registry.Get(remote)
+	if !ok {
+		t.Fatal("expected the remote's node to be added")
+	}
+	if !slices.Equal(peer.AllowedIPs, []string{"10.20.0.0/24"}) {
+		t.Errorf("expected only the allowed IPs within 10.20.0.0/16, got %v", peer.AllowedIPs)
+	}
+
+	// Peers the remote stops advertising go, the ones it never owned stay
+	bundle.Peers = nil
+	federator.Sync(context.Background())
+	if _, ok := registry.Get(remote); ok {
+		t.Error("expected the remote's node to be removed")
+	}
+	if _, ok := registry.Get(local); !ok {
+		t.Error("expected the local peer to survive the remote's removals")
+	}
+}
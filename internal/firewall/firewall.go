@@ -0,0 +1,198 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package firewall keeps iptables and ip6tables rules in place, the way
+// wg-quick's PostUp hooks would, and removes them again on teardown.
+package firewall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Comment tags every rule so they can be told apart from everyone else's
+const Comment = "kubewg"
+
+const commandTimeout = 10 * time.Second
+
+// Families
+const (
+	IPv4 = 4
+	IPv6 = 6
+)
+
+var ErrRule = errors.New("iptables command failed")
+
+// Command runs an iptables binary with args and returns its combined
+// output. A rule that doesn't exist makes "-C" exit with status 1, which
+// must come back as an *exec.ExitError.
+type Command func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+// Exec runs the binaries found on PATH.
+func Exec(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+// Rule is one iptables rule, e.g. Table "nat", Chain "POSTROUTING" and the
+// match and target in Args.
+type Rule struct {
+	Family int
+	Table  string
+	Chain  string
+	Args   []string
+}
+
+func (r *Rule) String() string {
+	return fmt.Sprintf("%s -t %s %s %s", r.binary(), r.Table, r.Chain, strings.Join(r.Args, " "))
+}
+
+func (r *Rule) binary() string {
+	if r.Family == IPv6 {
+		return "ip6tables"
+	}
+	return "iptables"
+}
+
+func (r *Rule) command(action string) []string {
+	args := []string{"-w", "-t", r.Table, action, r.Chain}
+	args = append(args, r.Args...)
+	return append(args, "-m", "comment", "--comment", Comment)
+}
+
+func (r *Rule) equal(other *Rule) bool {
+	return r.Family == other.Family && r.Table == other.Table && r.Chain == other.Chain && slices.Equal(r.Args, other.Args)
+}
+
+// Firewall remembers the rules it installed so Sync can drop the ones no
+// longer wanted and Flush can remove them all.
+type Firewall struct {
+	command Command
+	mu      sync.Mutex
+	rules   []Rule
+}
+
+// New runs iptables through command, Exec when nil.
+func New(command Command) *Firewall {
+	if command == nil {
+		command = Exec
+	}
+	return &Firewall{command: command}
+}
+
+// Sync appends the rules that are missing and deletes the ones installed
+// earlier that are no longer in rules.
+func (f *Firewall) Sync(ctx context.Context, rules []Rule) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range rules {
+		exists, err := f.exists(ctx, &rules[i])
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if err := f.run(ctx, &rules[i], "-A"); err != nil {
+			return err
+		}
+	}
+
+	for i := range f.rules {
+		wanted := slices.ContainsFunc(rules, func(rule Rule) bool {
+			return rule.equal(&f.rules[i])
+		})
+		if !wanted {
+			f.delete(ctx, &f.rules[i])
+		}
+	}
+
+	f.rules = slices.Clone(rules)
+	return nil
+}
+
+// Missing describes the installed rules that were removed behind our back.
+func (f *Firewall) Missing(ctx context.Context) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var missing []string
+	for i := range f.rules {
+		exists, err := f.exists(ctx, &f.rules[i])
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			missing = append(missing, fmt.Sprintf("firewall rule %q is missing", f.rules[i].String()))
+		}
+	}
+	return missing, nil
+}
+
+// Flush deletes every rule Sync installed. Failures are only logged, a
+// rule that can't be deleted is most likely gone already.
+func (f *Firewall) Flush(ctx context.Context) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := range f.rules {
+		f.delete(ctx, &f.rules[i])
+	}
+	f.rules = nil
+}
+
+func (f *Firewall) delete(ctx context.Context, rule *Rule) {
+	if err := f.run(ctx, rule, "-D"); err != nil {
+		slog.Warn("Failed to remove firewall rule", "rule", rule.String(), "error", err.Error())
+	}
+}
+
+func (f *Firewall) exists(ctx context.Context, rule *Rule) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	output, err := f.command(ctx, rule.binary(), rule.command("-C")...)
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		return false, nil
+	default:
+		return false, fmt.Errorf("%w: %s: %w: %s", ErrRule, rule.String(), err, strings.TrimSpace(string(output)))
+	}
+}
+
+func (f *Firewall) run(ctx context.Context, rule *Rule, action string) error {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	output, err := f.command(ctx, rule.binary(), rule.command(action)...)
+	if err != nil {
+		return fmt.Errorf("%w: %s %s: %w: %s", ErrRule, action, rule.String(), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package firewall_test
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/firewall"
+)
+
+// fakeTables keeps rules in memory the way iptables would, answering "-C"
+// for a missing rule with exit status 1.
+type fakeTables struct {
+	mu    sync.Mutex
+	rules map[string]bool
+}
+
+func (f *fakeTables) run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// args are -w -t <table> <action> <chain> ...
+	action := args[3]
+	key := name + " " + args[2] + " " + strings.Join(args[4:], " ")
+	switch action {
+	case "-C":
+		if !f.rules[key] {
+			return nil, exec.CommandContext(ctx, "sh", "-c", "exit 1").Run()
+		}
+	case "-A":
+		f.rules[key] = true
+	case "-D":
+		delete(f.rules, key)
+	}
+	return nil, nil
+}
+
+func TestFirewallSync(t *testing.T) {
+	t.Parallel()
+
+	tables := &fakeTables{rules: make(map[string]bool)}
+	fw := firewall.New(tables.run)
+	ctx := context.Background()
+
+	masquerade := firewall.Rule{Family: firewall.IPv4, Table: "nat", Chain: "POSTROUTING", Args: []string{"-s", "10.0.0.0/24", "-j", "MASQUERADE"}}
+	forward := firewall.Rule{Family: firewall.IPv6, Table: "filter", Chain: "FORWARD", Args: []string{"-i", "wg0", "-j", "ACCEPT"}}
+
+	if err := fw.Sync(ctx, []firewall.Rule{masquerade, forward}); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if len(tables.rules) != 2 {
+		t.Fatalf("expected 2 rules, got %d", len(tables.rules))
+	}
+
+	// Syncing again must not append duplicates
+	if err := fw.Sync(ctx, []firewall.Rule{masquerade, forward}); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if len(tables.rules) != 2 {
+		t.Fatalf("expected 2 rules after resync, got %d", len(tables.rules))
+	}
+
+	// A rule removed by someone else is reported
+	for key := range tables.rules {
+		if strings.HasPrefix(key, "ip6tables") {
+			delete(tables.rules, key)
+		}
+	}
+	missing, err := fw.Missing(ctx)
+	if err != nil {
+		t.Fatalf("failed to check rules: %v", err)
+	}
+	if len(missing) != 1 || !strings.Contains(missing[0], "ip6tables") {
+		t.Errorf("expected the ip6tables rule to be missing, got %v", missing)
+	}
+
+	// Rules no longer wanted are deleted
+	if err := fw.Sync(ctx, []firewall.Rule{forward}); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	for key := range tables.rules {
+		if strings.Contains(key, "MASQUERADE") {
+			t.Errorf("expected the masquerade rule to be deleted")
+		}
+	}
+
+	fw.Flush(ctx)
+	if len(tables.rules) != 0 {
+		t.Errorf("expected no rules after flush, got %v", tables.rules)
+	}
+}
@@ -0,0 +1,153 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package health tracks the conditions reported on the probe endpoints.
+package health
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Condition is the state of one dependency. A condition that is not OK
+// marks the process degraded, which is reported but doesn't fail readiness:
+// the dataplane keeps running from its last known good state.
+type Condition struct {
+	Type    string    `json:"type"`
+	OK      bool      `json:"ok"`
+	Reason  string    `json:"reason,omitempty"`
+	Message string    `json:"message,omitempty"`
+	Since   time.Time `json:"since"`
+}
+
+type Status struct {
+	mu         sync.RWMutex
+	conditions map[string]Condition
+	draining   bool
+}
+
+func NewStatus() *Status {
+	return &Status{
+		conditions: make(map[string]Condition),
+	}
+}
+
+// Set records a condition and reports whether its state changed. The since
+// time is kept while the state stays the same.
+func (s *Status) Set(condition Condition) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, ok := s.conditions[condition.Type]
+	if ok && previous.OK == condition.OK {
+		condition.Since = previous.Since
+		s.conditions[condition.Type] = condition
+		return false
+	}
+
+	condition.Since = time.Now()
+	s.conditions[condition.Type] = condition
+	return ok || !condition.OK
+}
+
+// Conditions returns every condition, sorted by type.
+func (s *Status) Conditions() []Condition {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	conditions := make([]Condition, 0, len(s.conditions))
+	for _, condition := range s.conditions {
+		conditions = append(conditions, condition)
+	}
+	sort.Slice(conditions, func(i, j int) bool {
+		return conditions[i].Type < conditions[j].Type
+	})
+	return conditions
+}
+
+// Degraded reports whether any condition is not OK.
+func (s *Status) Degraded() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, condition := range s.conditions {
+		if !condition.OK {
+			return true
+		}
+	}
+	return false
+}
+
+// SetDraining fails readiness from now on, so the pod is taken out of
+// Service endpoints while it shuts down.
+func (s *Status) SetDraining() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.draining = true
+}
+
+func (s *Status) isDraining() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.draining
+}
+
+type response struct {
+	Status     string      `json:"status"`
+	Degraded   bool        `json:"degraded"`
+	Conditions []Condition `json:"conditions"`
+}
+
+// Register adds the /healthz and /readyz probe endpoints to mux. Both
+// answer 200 while degraded and carry the conditions in the body. Readiness
+// fails with 503 once draining.
+func (s *Status) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", s.handleHealth)
+	mux.HandleFunc("GET /readyz", s.handleReady)
+}
+
+func (s *Status) handleHealth(w http.ResponseWriter, _ *http.Request) {
+	s.write(w, http.StatusOK, "ok")
+}
+
+func (s *Status) handleReady(w http.ResponseWriter, _ *http.Request) {
+	if s.isDraining() {
+		s.write(w, http.StatusServiceUnavailable, "draining")
+		return
+	}
+	s.write(w, http.StatusOK, "ok")
+}
+
+func (s *Status) write(w http.ResponseWriter, code int, status string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	body := response{
+		Status:     status,
+		Degraded:   s.Degraded(),
+		Conditions: s.Conditions(),
+	}
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		slog.Error("Failed to write health response", "error", err.Error())
+	}
+}
@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package health_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/health"
+)
+
+func TestDegradedStaysReady(t *testing.T) {
+	t.Parallel()
+
+	status := health.NewStatus()
+	if status.Set(health.Condition{Type: "KubernetesAPI", OK: true}) {
+		t.Error("expected an initially healthy condition not to count as a change")
+	}
+	if !status.Set(health.Condition{Type: "KubernetesAPI", OK: false, Reason: "Unreachable"}) {
+		t.Error("expected losing the API to count as a change")
+	}
+	if status.Set(health.Condition{Type: "KubernetesAPI", OK: false, Reason: "Unreachable"}) {
+		t.Error("expected a repeated failure not to count as a change")
+	}
+
+	mux := http.NewServeMux()
+	status.Register(mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected readiness to stay 200 while degraded, got %d", rec.Code)
+	}
+	var body struct {
+		Degraded bool `json:"degraded"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !body.Degraded {
+		t.Error("expected the readiness body to report degraded")
+	}
+}
+
+func TestDrainingFailsReadiness(t *testing.T) {
+	t.Parallel()
+
+	status := health.NewStatus()
+	status.SetDraining()
+
+	mux := http.NewServeMux()
+	status.Register(mux)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected readiness to fail while draining, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected liveness to stay 200 while draining, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,145 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package httplimit keeps a single client, or a storm of them, from
+// monopolising an HTTP listener.
+package httplimit
+
+import (
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"golang.org/x/time/rate"
+)
+
+// Clients idle for this long are forgotten, so the table doesn't grow with
+// every address ever seen
+const clientIdle = 10 * time.Minute
+
+type client struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Limiter enforces config.HTTPLimits on the handlers it wraps.
+type Limiter struct {
+	config *config.HTTPLimits
+	slots  chan struct{}
+
+	mu        sync.Mutex
+	clients   map[string]*client
+	lastSweep time.Time
+}
+
+func New(config *config.HTTPLimits) *Limiter {
+	l := &Limiter{
+		config:  config,
+		clients: make(map[string]*client),
+	}
+	if config.MaxConcurrent > 0 {
+		l.slots = make(chan struct{}, config.MaxConcurrent)
+	}
+	return l
+}
+
+// Handler wraps next: requests over a client's rate are answered with 429,
+// requests over the concurrency cap with 503 and bodies over the size limit
+// fail to read.
+func (l *Limiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.config.RateLimit > 0 {
+			if delay := l.reserve(clientAddr(r)); delay > 0 {
+				slog.Debug("HTTP client rate limited", "remote", r.RemoteAddr, "path", r.URL.Path)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+		}
+
+		if l.slots != nil {
+			select {
+			case l.slots <- struct{}{}:
+				defer func() { <-l.slots }()
+			default:
+				slog.Debug("HTTP concurrency limit reached", "remote", r.RemoteAddr, "path", r.URL.Path)
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		if l.config.MaxBodyBytes > 0 && r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, int64(l.config.MaxBodyBytes))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// reserve takes a token from addr's bucket, returning how long the client
+// has to wait if there is none.
+func (l *Limiter) reserve(addr string) time.Duration {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > clientIdle {
+		for key, c := range l.clients {
+			if now.Sub(c.lastSeen) > clientIdle {
+				delete(l.clients, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	c, ok := l.clients[addr]
+	if !ok {
+		c = &client{limiter: rate.NewLimiter(rate.Limit(l.config.RateLimit), l.config.Burst)}
+		l.clients[addr] = c
+	}
+	c.lastSeen = now
+
+	reservation := c.limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		return clientIdle
+	}
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		// Rejected requests don't count against the client
+		reservation.CancelAt(now)
+		return delay
+	}
+	return 0
+}
+
+// clientAddr is the address the request came from, without its port so
+// that new connections from one client share a bucket.
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,116 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package httplimit_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/httplimit"
+)
+
+func serve(handler http.Handler, remote, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.RemoteAddr = remote
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRateLimitPerClient(t *testing.T) {
+	t.Parallel()
+	limiter := httplimit.New(&config.HTTPLimits{RateLimit: 0.001, Burst: 2})
+	handler := limiter.Handler(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	for i := 0; i < 2; i++ {
+		if rec := serve(handler, "10.0.0.1:1000", ""); rec.Code != http.StatusOK {
+			t.Fatalf("expected request %d within the burst to pass, got %d", i, rec.Code)
+		}
+	}
+
+	// A new connection from the same address shares the bucket
+	rec := serve(handler, "10.0.0.1:2000", "")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 over the burst, got %d", rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Errorf("expected a Retry-After header")
+	}
+
+	if rec := serve(handler, "10.0.0.2:1000", ""); rec.Code != http.StatusOK {
+		t.Errorf("expected another client to pass, got %d", rec.Code)
+	}
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	t.Parallel()
+	limiter := httplimit.New(&config.HTTPLimits{MaxConcurrent: 1})
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	handler := limiter.Handler(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("block") != "" {
+			close(started)
+			<-release
+		}
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		req := httptest.NewRequest(http.MethodGet, "/?block=1", nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	<-started
+
+	if rec := serve(handler, "10.0.0.1:1000", ""); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while the only slot is taken, got %d", rec.Code)
+	}
+
+	close(release)
+	<-done
+	if rec := serve(handler, "10.0.0.1:1000", ""); rec.Code != http.StatusOK {
+		t.Errorf("expected the slot to be released, got %d", rec.Code)
+	}
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	t.Parallel()
+	limiter := httplimit.New(&config.HTTPLimits{MaxBodyBytes: 4})
+
+	var readErr error
+	handler := limiter.Handler(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		_, readErr = io.ReadAll(r.Body)
+	}))
+
+	serve(handler, "10.0.0.1:1000", "1234")
+	if readErr != nil {
+		t.Fatalf("expected a body at the limit to be read, got %v", readErr)
+	}
+
+	serve(handler, "10.0.0.1:1000", "12345")
+	if readErr == nil {
+		t.Errorf("expected a body over the limit to fail")
+	}
+}
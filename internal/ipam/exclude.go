@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package ipam
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// DefaultRoutes are the prefixes a full tunnel routes.
+//
+//nolint:golint,gochecknoglobals
+var DefaultRoutes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/0"),
+	netip.MustParsePrefix("::/0"),
+}
+
+// Exclude returns the smallest set of prefixes covering include without
+// any address in exclude, the way WireGuard AllowedIPs calculators turn
+// "everything but the LAN" into a list a peer config can express.
+func Exclude(include, exclude []netip.Prefix) []netip.Prefix {
+	var result []netip.Prefix
+	for _, prefix := range include {
+		result = appendExcluded(result, prefix.Masked(), exclude)
+	}
+	return result
+}
+
+// ExcludeStrings is Exclude for prefixes in their text form.
+func ExcludeStrings(include, exclude []string) ([]string, error) {
+	includePrefixes, err := parsePrefixes(include)
+	if err != nil {
+		return nil, err
+	}
+	excludePrefixes, err := parsePrefixes(exclude)
+	if err != nil {
+		return nil, err
+	}
+	prefixes := Exclude(includePrefixes, excludePrefixes)
+	result := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		result = append(result, prefix.String())
+	}
+	return result, nil
+}
+
+func appendExcluded(result []netip.Prefix, prefix netip.Prefix, exclude []netip.Prefix) []netip.Prefix {
+	overlaps := false
+	for _, excluded := range exclude {
+		if !excluded.Overlaps(prefix) {
+			continue
+		}
+		if excluded.Bits() <= prefix.Bits() {
+			// Excluded entirely
+			return result
+		}
+		overlaps = true
+	}
+	if !overlaps {
+		return append(result, prefix)
+	}
+	lower := netip.PrefixFrom(prefix.Addr(), prefix.Bits()+1)
+	upper := netip.PrefixFrom(lastAddr(lower).Next(), prefix.Bits()+1)
+	result = appendExcluded(result, lower, exclude)
+	return appendExcluded(result, upper, exclude)
+}
+
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefix %q: %w", value, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
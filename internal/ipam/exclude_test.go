@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package ipam_test
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/ipam"
+)
+
+func TestExcludeFromDefaultRoute(t *testing.T) {
+	t.Parallel()
+
+	got, err := ipam.ExcludeStrings([]string{"0.0.0.0/0"}, []string{"0.0.0.0/1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(got, []string{"128.0.0.0/1"}) {
+		t.Fatalf("expected [128.0.0.0/1], got %v", got)
+	}
+}
+
+func TestExcludeCoversEverythingElse(t *testing.T) {
+	t.Parallel()
+
+	exclude := []netip.Prefix{
+		netip.MustParsePrefix("192.168.0.0/16"),
+		netip.MustParsePrefix("169.254.169.254/32"),
+		netip.MustParsePrefix("fd00::/8"),
+	}
+	got := ipam.Exclude(ipam.DefaultRoutes, exclude)
+
+	for _, addr := range []string{"8.8.8.8", "192.167.255.255", "192.169.0.0", "169.254.169.253", "2001:db8::1"} {
+		if !containsAddr(got, netip.MustParseAddr(addr)) {
+			t.Errorf("expected %s to be routed, got %v", addr, got)
+		}
+	}
+	for _, addr := range []string{"192.168.1.1", "169.254.169.254", "fd12::1"} {
+		if containsAddr(got, netip.MustParseAddr(addr)) {
+			t.Errorf("expected %s to be excluded, got %v", addr, got)
+		}
+	}
+	for i, a := range got {
+		for _, b := range got[i+1:] {
+			if a.Overlaps(b) {
+				t.Errorf("expected disjoint prefixes, got %s and %s", a, b)
+			}
+		}
+	}
+}
+
+func TestExcludeNothing(t *testing.T) {
+	t.Parallel()
+
+	got := ipam.Exclude(ipam.DefaultRoutes, nil)
+	if !slices.Equal(got, ipam.DefaultRoutes) {
+		t.Fatalf("expected %v, got %v", ipam.DefaultRoutes, got)
+	}
+}
+
+func TestExcludeInvalidPrefix(t *testing.T) {
+	t.Parallel()
+
+	if _, err := ipam.ExcludeStrings([]string{"0.0.0.0/0"}, []string{"lan"}); err == nil {
+		t.Fatal("expected an error for an invalid prefix")
+	}
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
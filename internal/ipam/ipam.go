@@ -0,0 +1,253 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package ipam hands out tunnel addresses from configured CIDR pools.
+package ipam
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sync"
+)
+
+var (
+	ErrPoolExhausted = errors.New("address pools exhausted")
+	ErrNotInPool     = errors.New("address is not in any pool")
+)
+
+// Allocator assigns single addresses out of a list of pools, trying the
+// pools in order.
+type Allocator struct {
+	mu       sync.Mutex
+	pools    []netip.Prefix
+	reserved []netip.Prefix
+	used     map[netip.Addr]struct{}
+}
+
+func NewAllocator(pools []string) (*Allocator, error) {
+	allocator := &Allocator{
+		used: make(map[netip.Addr]struct{}),
+	}
+	for _, pool := range pools {
+		prefix, err := netip.ParsePrefix(pool)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pool %q: %w", pool, err)
+		}
+		allocator.pools = append(allocator.pools, prefix.Masked())
+	}
+	return allocator, nil
+}
+
+// Allocate returns the next free address.
+func (a *Allocator) Allocate() (netip.Addr, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.allocate(a.pools)
+}
+
+// AllocateDualStack returns the next free address of every address family
+// there are pools for, in the order of the first pool of each family. A
+// peer of a dual-stack network gets an IPv4 and an IPv6 address this way.
+// Nothing is allocated if any family is exhausted.
+func (a *Allocator) AllocateDualStack() ([]netip.Addr, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var families [][]netip.Prefix
+	for _, pool := range a.pools {
+		i := slices.IndexFunc(families, func(family []netip.Prefix) bool {
+			return family[0].Addr().Is4() == pool.Addr().Is4()
+		})
+		if i < 0 {
+			families = append(families, nil)
+			i = len(families) - 1
+		}
+		families[i] = append(families[i], pool)
+	}
+
+	addrs := make([]netip.Addr, 0, len(families))
+	for _, pools := range families {
+		addr, err := a.allocate(pools)
+		if err != nil {
+			for _, allocated := range addrs {
+				delete(a.used, allocated)
+			}
+			return nil, err
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) == 0 {
+		return nil, ErrPoolExhausted
+	}
+	return addrs, nil
+}
+
+// allocate takes the next free address out of pools. Callers hold a.mu.
+func (a *Allocator) allocate(pools []netip.Prefix) (netip.Addr, error) {
+	for _, pool := range pools {
+		addr := firstUsable(pool)
+		for addr.IsValid() && pool.Contains(addr) && !isBroadcast(pool, addr) {
+			if reserved, ok := a.reservedPrefix(addr); ok {
+				addr = lastAddr(reserved).Next()
+				continue
+			}
+			if _, ok := a.used[addr]; ok {
+				addr = addr.Next()
+				continue
+			}
+			a.used[addr] = struct{}{}
+			return addr, nil
+		}
+	}
+	return netip.Addr{}, ErrPoolExhausted
+}
+
+// Claim marks a specific address as allocated, e.g. when restoring
+// allocations that were handed out before a restart.
+func (a *Allocator) Claim(addr netip.Addr) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, pool := range a.pools {
+		if pool.Contains(addr) {
+			a.used[addr] = struct{}{}
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrNotInPool, addr)
+}
+
+// Allocated returns the allocated addresses, sorted.
+func (a *Allocator) Allocated() []netip.Addr {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	allocated := make([]netip.Addr, 0, len(a.used))
+	for addr := range a.used {
+		allocated = append(allocated, addr)
+	}
+	slices.SortFunc(allocated, netip.Addr.Compare)
+	return allocated
+}
+
+// Reserve excludes a prefix from allocation, e.g. the interface's own
+// addresses and those routed to statically configured peers.
+func (a *Allocator) Reserve(prefix netip.Prefix) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.reserved = append(a.reserved, prefix.Masked())
+}
+
+// Release returns an address to its pool.
+func (a *Allocator) Release(addr netip.Addr) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, pool := range a.pools {
+		if pool.Contains(addr) {
+			delete(a.used, addr)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrNotInPool, addr)
+}
+
+// PoolUsage describes what is taken out of a pool.
+type PoolUsage struct {
+	Pool      string   `json:"pool"`
+	Allocated []string `json:"allocated"`
+	Reserved  []string `json:"reserved"`
+}
+
+// Usage lists the allocated addresses and reserved prefixes of every pool,
+// in pool order.
+func (a *Allocator) Usage() []PoolUsage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	usage := make([]PoolUsage, 0, len(a.pools))
+	for _, pool := range a.pools {
+		pu := PoolUsage{Pool: pool.String(), Allocated: []string{}, Reserved: []string{}}
+		var allocated []netip.Addr
+		for addr := range a.used {
+			if pool.Contains(addr) {
+				allocated = append(allocated, addr)
+			}
+		}
+		slices.SortFunc(allocated, netip.Addr.Compare)
+		for _, addr := range allocated {
+			pu.Allocated = append(pu.Allocated, addr.String())
+		}
+		for _, prefix := range a.reserved {
+			if pool.Overlaps(prefix) {
+				pu.Reserved = append(pu.Reserved, prefix.String())
+			}
+		}
+		usage = append(usage, pu)
+	}
+	return usage
+}
+
+// HostPrefix returns addr as a single-address prefix.
+func HostPrefix(addr netip.Addr) netip.Prefix {
+	return netip.PrefixFrom(addr, addr.BitLen())
+}
+
+func (a *Allocator) reservedPrefix(addr netip.Addr) (netip.Prefix, bool) {
+	for _, prefix := range a.reserved {
+		if prefix.Contains(addr) {
+			return prefix, true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	addr := prefix.Masked().Addr().AsSlice()
+	for i := range addr {
+		for bit := 0; bit < 8; bit++ {
+			if i*8+bit >= prefix.Bits() {
+				addr[i] |= 0x80 >> bit
+			}
+		}
+	}
+	last, _ := netip.AddrFromSlice(addr)
+	return last
+}
+
+func firstUsable(pool netip.Prefix) netip.Addr {
+	addr := pool.Addr()
+	// Skip the network address unless the pool is a single address or a
+	// point-to-point /31
+	if pool.Bits() < addr.BitLen()-1 {
+		addr = addr.Next()
+	}
+	return addr
+}
+
+func isBroadcast(pool netip.Prefix, addr netip.Addr) bool {
+	if !addr.Is4() || pool.Bits() >= 31 {
+		return false
+	}
+	return !pool.Contains(addr.Next())
+}
@@ -0,0 +1,150 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package ipam_test
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/ipam"
+)
+
+func TestAllocateSkipsReserved(t *testing.T) {
+	t.Parallel()
+
+	allocator, err := ipam.NewAllocator([]string{"10.0.0.0/29"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	allocator.Reserve(netip.MustParsePrefix("10.0.0.1/32"))
+	allocator.Reserve(netip.MustParsePrefix("10.0.0.2/31"))
+
+	want := []string{"10.0.0.4", "10.0.0.5", "10.0.0.6"}
+	for _, expected := range want {
+		addr, err := allocator.Allocate()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if addr.String() != expected {
+			t.Errorf("expected %s, got %s", expected, addr)
+		}
+	}
+
+	if _, err := allocator.Allocate(); !errors.Is(err, ipam.ErrPoolExhausted) {
+		t.Errorf("expected ErrPoolExhausted, got %v", err)
+	}
+
+	if err := allocator.Release(netip.MustParseAddr("10.0.0.5")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	addr, err := allocator.Allocate()
+	if err != nil || addr.String() != "10.0.0.5" {
+		t.Errorf("expected released 10.0.0.5, got %s (%v)", addr, err)
+	}
+}
+
+func TestAllocateIPv6(t *testing.T) {
+	t.Parallel()
+
+	allocator, err := ipam.NewAllocator([]string{"fd00::/64"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	allocator.Reserve(netip.MustParsePrefix("fd00::1/128"))
+
+	addr, err := allocator.Allocate()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if addr.String() != "fd00::2" {
+		t.Errorf("expected fd00::2, got %s", addr)
+	}
+}
+
+func TestAllocateDualStack(t *testing.T) {
+	t.Parallel()
+
+	allocator, err := ipam.NewAllocator([]string{"10.0.0.0/30", "fd00::/126", "10.1.0.0/30"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	addrs, err := allocator.AllocateDualStack()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(addrs) != 2 || addrs[0].String() != "10.0.0.1" || addrs[1].String() != "fd00::1" {
+		t.Fatalf("expected [10.0.0.1 fd00::1], got %v", addrs)
+	}
+
+	// 10.0.0.0/30 is full after its second address, the next one comes
+	// from the other IPv4 pool
+	for _, want := range []string{"10.0.0.2", "10.1.0.1"} {
+		addrs, err = allocator.AllocateDualStack()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if addrs[0].String() != want {
+			t.Errorf("expected %s, got %s", want, addrs[0])
+		}
+	}
+
+	// The IPv6 pool is exhausted now, which must not leak an IPv4 address
+	if _, err := allocator.AllocateDualStack(); !errors.Is(err, ipam.ErrPoolExhausted) {
+		t.Fatalf("expected ErrPoolExhausted, got %v", err)
+	}
+	addr, err := allocator.Allocate()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if addr.String() != "10.1.0.2" {
+		t.Errorf("expected 10.1.0.2 to still be free, got %s", addr)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	t.Parallel()
+
+	allocator, err := ipam.NewAllocator([]string{"10.0.0.0/29", "10.1.0.0/29"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	allocator.Reserve(netip.MustParsePrefix("10.0.0.1/32"))
+	for range 2 {
+		if _, err := allocator.Allocate(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	usage := allocator.Usage()
+	if len(usage) != 2 {
+		t.Fatalf("expected 2 pools, got %+v", usage)
+	}
+	if got := usage[0].Allocated; len(got) != 2 || got[0] != "10.0.0.2" || got[1] != "10.0.0.3" {
+		t.Errorf("expected 10.0.0.2 and 10.0.0.3 allocated, got %v", got)
+	}
+	if got := usage[0].Reserved; len(got) != 1 || got[0] != "10.0.0.1/32" {
+		t.Errorf("expected 10.0.0.1/32 reserved, got %v", got)
+	}
+	if len(usage[1].Allocated) != 0 || len(usage[1].Reserved) != 0 {
+		t.Errorf("expected the second pool to be untouched, got %+v", usage[1])
+	}
+}
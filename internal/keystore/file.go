@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package keystore
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// File keeps each key base64 encoded in a file. Relative names are resolved
+// against the store's directory, absolute names are used as they are, which
+// is how the configured private key file keeps its path.
+type File struct {
+	dir string
+}
+
+func NewFile(dir string) *File {
+	return &File{dir: dir}
+}
+
+func (f *File) path(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(f.dir, filepath.FromSlash(name))
+}
+
+func (f *File) Get(_ context.Context, name string) (Entry, error) {
+	path := f.path(name)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Entry{}, fmt.Errorf("%w: %s", ErrNotFound, path)
+	} else if err != nil {
+		return Entry{}, fmt.Errorf("failed to read key %s: %w", path, err)
+	}
+	defer clear(data)
+	key, err := parseKey(bytes.TrimSpace(data))
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to parse key %s: %w", path, err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to stat key %s: %w", path, err)
+	}
+	return Entry{Key: key, Created: info.ModTime()}, nil
+}
+
+// Put replaces the key atomically so a crash never leaves a truncated key
+// behind.
+func (f *File) Put(_ context.Context, name string, key wgtypes.Key) error {
+	path := f.path(name)
+	tmp, err := f.writeTemp(path, key)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace key %s: %w", path, err)
+	}
+	return nil
+}
+
+// Create links the new key into place, which fails if the file exists.
+func (f *File) Create(_ context.Context, name string, key wgtypes.Key) error {
+	path := f.path(name)
+	tmp, err := f.writeTemp(path, key)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if err := os.Link(tmp, path); errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%w: %s", ErrExists, path)
+	} else if err != nil {
+		return fmt.Errorf("failed to create key %s: %w", path, err)
+	}
+	return nil
+}
+
+func (f *File) writeTemp(path string, key wgtypes.Key) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", fmt.Errorf("failed to create key directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	data := make([]byte, base64.StdEncoding.EncodedLen(wgtypes.KeyLen)+1)
+	defer clear(data)
+	base64.StdEncoding.Encode(data, key[:])
+	data[len(data)-1] = '\n'
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return "", fmt.Errorf("failed to write key %s: %w", path, err)
+	}
+	return tmp, nil
+}
+
+// parseKey decodes a base64 key like wgtypes.ParseKey, but without the
+// string copy that would outlive the wiped file contents.
+func parseKey(data []byte) (wgtypes.Key, error) {
+	var key wgtypes.Key
+	if len(data) != base64.StdEncoding.EncodedLen(wgtypes.KeyLen) {
+		return key, ErrInvalidKey
+	}
+	// Decoding may write up to DecodedLen bytes, one more than a key
+	var decoded [wgtypes.KeyLen + 1]byte
+	defer clear(decoded[:])
+	n, err := base64.StdEncoding.Decode(decoded[:], data)
+	if err != nil || n != wgtypes.KeyLen {
+		return key, ErrInvalidKey
+	}
+	copy(key[:], decoded[:])
+	return key, nil
+}
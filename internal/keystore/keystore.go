@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package keystore keeps WireGuard key material somewhere other than the
+// node's disk, e.g. in Vault, so it never passes through etcd.
+package keystore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+var (
+	ErrNotFound   = errors.New("key not found")
+	ErrExists     = errors.New("key already exists")
+	ErrInvalidKey = errors.New("key is not a base64 encoded 32 byte key")
+)
+
+// Entry is a stored key and when it was stored, which drives rotation.
+type Entry struct {
+	Key     wgtypes.Key
+	Created time.Time
+}
+
+// KeyStore keeps keys by name.
+type KeyStore interface {
+	// Get returns the key stored under name, or ErrNotFound.
+	Get(ctx context.Context, name string) (Entry, error)
+	// Put stores key under name, replacing any previous key.
+	Put(ctx context.Context, name string, key wgtypes.Key) error
+	// Create stores key under name unless a key is stored there already,
+	// in which case it returns ErrExists. Two nodes racing to create the
+	// same key therefore agree on the winner's.
+	Create(ctx context.Context, name string, key wgtypes.Key) error
+}
+
+// PrivateKeyName names the private key of a node.
+func PrivateKeyName(nodeName string) string {
+	return "nodes/" + nodeName + "/private-key"
+}
+
+// LoadOrGenerate returns the private key stored under name, generating and
+// storing one if there is none yet.
+func LoadOrGenerate(ctx context.Context, store KeyStore, name string) (Entry, error) {
+	entry, err := store.Get(ctx, name)
+	if !errors.Is(err, ErrNotFound) {
+		return entry, err
+	}
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to generate private key: %w", err)
+	}
+	err = store.Create(ctx, name, key)
+	if errors.Is(err, ErrExists) {
+		return store.Get(ctx, name)
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+	slog.Info("Generated WireGuard private key", "key", name, "public_key", key.PublicKey().String())
+	return Entry{Key: key, Created: time.Now()}, nil
+}
+
+// Rotate replaces the private key stored under name with a fresh one.
+func Rotate(ctx context.Context, store KeyStore, name string) (Entry, error) {
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to generate private key: %w", err)
+	}
+	if err := store.Put(ctx, name, key); err != nil {
+		return Entry{}, err
+	}
+	return Entry{Key: key, Created: time.Now()}, nil
+}
@@ -0,0 +1,262 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package keystore_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/keystore"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestKeyStores(t *testing.T) {
+	t.Parallel()
+
+	stores := map[string]func(t *testing.T) keystore.KeyStore{
+		"file": func(t *testing.T) keystore.KeyStore {
+			return keystore.NewFile(t.TempDir())
+		},
+		"memory": func(_ *testing.T) keystore.KeyStore {
+			return keystore.NewMemory()
+		},
+		"secret": func(_ *testing.T) keystore.KeyStore {
+			return keystore.NewSecrets(fake.NewSimpleClientset(), "kube-system")
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			store := newStore(t)
+			ctx := context.Background()
+			keyName := keystore.PrivateKeyName("node-a")
+
+			if _, err := store.Get(ctx, keyName); !errors.Is(err, keystore.ErrNotFound) {
+				t.Fatalf("expected ErrNotFound, got %v", err)
+			}
+			first, err := keystore.LoadOrGenerate(ctx, store, keyName)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			again, err := keystore.LoadOrGenerate(ctx, store, keyName)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if again.Key != first.Key {
+				t.Errorf("expected the generated key to be kept")
+			}
+
+			other, err := wgtypes.GeneratePrivateKey()
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := store.Create(ctx, keyName, other); !errors.Is(err, keystore.ErrExists) {
+				t.Errorf("expected ErrExists, got %v", err)
+			}
+
+			rotated, err := keystore.Rotate(ctx, store, keyName)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			got, err := store.Get(ctx, keyName)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if got.Key != rotated.Key || got.Key == first.Key {
+				t.Errorf("expected the rotated key to replace the first")
+			}
+		})
+	}
+}
+
+func TestFileAbsoluteName(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "keys", "private.key")
+	store := keystore.NewFile("")
+	entry, err := keystore.LoadOrGenerate(context.Background(), store, path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	got, err := keystore.NewFile("/elsewhere").Get(context.Background(), path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got.Key != entry.Key {
+		t.Errorf("expected the key at %s", path)
+	}
+}
+
+func TestFileInvalidKey(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"short":   "c2hvcnQ=\n",
+		"corrupt": "!" + strings.Repeat("A", 42) + "=\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := keystore.NewFile(dir).Get(context.Background(), name); !errors.Is(err, keystore.ErrInvalidKey) {
+			t.Errorf("expected ErrInvalidKey for a %s key, got %v", name, err)
+		}
+	}
+}
+
+func TestPresharedKeys(t *testing.T) {
+	t.Parallel()
+
+	keyA, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	keyB, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	a, b := keyA.PublicKey().String(), keyB.PublicKey().String()
+	node := map[string]string{"kubewg.net/node": "true"}
+
+	// Each node has its own view of the same Secrets
+	client := fake.NewSimpleClientset()
+	onA, err := keystore.NewPresharedKeys(keystore.NewSecrets(client, "kube-system"), "kubewg.net/node", time.Minute)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	onB, err := keystore.NewPresharedKeys(keystore.NewSecrets(client, "kube-system"), "kubewg.net/node", time.Minute)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	fromA, err := onA.PresharedKey(context.Background(), a, &config.WireGuardPeer{PublicKey: b, Metadata: node})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := wgtypes.ParseKey(fromA); err != nil {
+		t.Fatalf("expected a valid key, got %q: %v", fromA, err)
+	}
+	fromB, err := onB.PresharedKey(context.Background(), b, &config.WireGuardPeer{PublicKey: a, Metadata: node})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if fromA != fromB {
+		t.Errorf("expected both nodes to get the same key, got %q and %q", fromA, fromB)
+	}
+
+	secrets, err := client.CoreV1().Secrets("kube-system").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := keystore.SecretName(keystore.PresharedKeyName(b, a))
+	if len(secrets.Items) != 1 || secrets.Items[0].Name != expected {
+		t.Errorf("expected one secret named %s, got %d", expected, len(secrets.Items))
+	}
+
+	external, err := onA.PresharedKey(context.Background(), a, &config.WireGuardPeer{PublicKey: b})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if external != "" {
+		t.Errorf("expected no key for an unselected peer, got %q", external)
+	}
+}
+
+func TestPresharedKeysRefresh(t *testing.T) {
+	t.Parallel()
+
+	keyA, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	keyB, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	a := keyA.PublicKey().String()
+	peer := &config.WireGuardPeer{PublicKey: keyB.PublicKey().String(), Metadata: map[string]string{"kubewg.net/node": "true"}}
+	name := keystore.PresharedKeyName(a, peer.PublicKey)
+
+	// Without a TTL every call reads the Secret again
+	client := fake.NewSimpleClientset()
+	secrets := keystore.NewSecrets(client, "kube-system")
+	psk, err := keystore.NewPresharedKeys(secrets, "kubewg.net/node", 0)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	first, err := psk.PresharedKey(context.Background(), a, peer)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	replaced, err := wgtypes.GenerateKey()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := secrets.Put(context.Background(), name, replaced); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	updated, err := psk.PresharedKey(context.Background(), a, peer)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if updated != replaced.String() {
+		t.Errorf("expected the updated key %q, got %q", replaced.String(), updated)
+	}
+
+	err = client.CoreV1().Secrets("kube-system").Delete(context.Background(), keystore.SecretName(name), metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	regenerated, err := psk.PresharedKey(context.Background(), a, peer)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if regenerated == first || regenerated == updated {
+		t.Errorf("expected a new key after the secret was deleted, got %q again", regenerated)
+	}
+	if _, err := secrets.Get(context.Background(), name); err != nil {
+		t.Errorf("expected the new key to be stored, got %v", err)
+	}
+
+	// An unreachable store keeps the cached key in use
+	client.PrependReactor("get", "secrets", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("connection refused")
+	})
+	cached, err := psk.PresharedKey(context.Background(), a, peer)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if cached != regenerated {
+		t.Errorf("expected the cached key %q, got %q", regenerated, cached)
+	}
+}
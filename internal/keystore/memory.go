@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package keystore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kubewg-net/container/internal/secmem"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Memory keeps keys for the lifetime of the process only, so a restart
+// means a new identity. Suits ephemeral nodes and tests. The keys are held
+// in locked memory.
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	key     *secmem.Key
+	created time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{entries: make(map[string]memoryEntry)}
+}
+
+func (m *Memory) Get(_ context.Context, name string) (Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[name]
+	if !ok {
+		return Entry{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	found := Entry{Created: entry.created}
+	err := entry.key.Use(func(key *wgtypes.Key) error {
+		found.Key = *key
+		return nil
+	})
+	return found, err
+}
+
+func (m *Memory) Put(_ context.Context, name string, key wgtypes.Key) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.store(name, &key)
+}
+
+func (m *Memory) Create(_ context.Context, name string, key wgtypes.Key) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.entries[name]; ok {
+		return fmt.Errorf("%w: %s", ErrExists, name)
+	}
+	return m.store(name, &key)
+}
+
+// store moves key into locked memory under name, freeing the key it
+// replaces.
+func (m *Memory) store(name string, key *wgtypes.Key) error {
+	locked, err := secmem.NewKey(key)
+	if err != nil {
+		return err
+	}
+	m.entries[name].key.Destroy()
+	m.entries[name] = memoryEntry{key: locked, created: time.Now()}
+	return nil
+}
@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package keystore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// PresharedKeyName names the key shared by a pair of public keys, the same
+// whichever side asks.
+func PresharedKeyName(a, b string) string {
+	if b < a {
+		a, b = b, a
+	}
+	sum := sha256.Sum256([]byte(a + "\n" + b))
+	return "psk/" + hex.EncodeToString(sum[:10])
+}
+
+// PresharedKeys hands out a preshared key for every pair of nodes from a
+// key store. Whichever node of the pair needs it first generates it.
+type PresharedKeys struct {
+	store    KeyStore
+	selector labels.Selector
+	ttl      time.Duration
+	mu       sync.Mutex
+	cache    map[string]cachedKey
+}
+
+type cachedKey struct {
+	key     string
+	expires time.Time
+}
+
+// NewPresharedKeys only hands out keys for peers whose metadata matches
+// selector. A key is read from the store again once it was cached for ttl,
+// so a key replaced or deleted in the store is picked up.
+func NewPresharedKeys(store KeyStore, selector string, ttl time.Duration) (*PresharedKeys, error) {
+	parsed, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid preshared key peer selector: %w", err)
+	}
+	return &PresharedKeys{
+		store:    store,
+		selector: parsed,
+		ttl:      ttl,
+		cache:    make(map[string]cachedKey),
+	}, nil
+}
+
+// PresharedKey returns the key shared by local and peer, or "" when the peer
+// isn't selected. Keys are cached, so peers keep theirs while the store is
+// unreachable. A deleted key is generated anew.
+func (p *PresharedKeys) PresharedKey(ctx context.Context, local string, peer *config.WireGuardPeer) (string, error) {
+	if !p.selector.Matches(labels.Set(peer.Metadata)) {
+		return "", nil
+	}
+
+	name := PresharedKeyName(local, peer.PublicKey)
+	now := time.Now()
+	p.mu.Lock()
+	cached, ok := p.cache[name]
+	p.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.key, nil
+	}
+
+	entry, err := p.store.Get(ctx, name)
+	if errors.Is(err, ErrNotFound) {
+		entry, err = p.create(ctx, name, peer.PublicKey)
+	}
+	if err != nil {
+		if ok {
+			slog.Warn("Failed to refresh preshared key, keeping the cached one", "key", name, "error", err.Error())
+			return cached.key, nil
+		}
+		return "", err
+	}
+
+	key := entry.Key.String()
+	p.mu.Lock()
+	p.cache[name] = cachedKey{key: key, expires: now.Add(p.ttl)}
+	p.mu.Unlock()
+	return key, nil
+}
+
+func (p *PresharedKeys) create(ctx context.Context, name, peer string) (Entry, error) {
+	key, err := wgtypes.GenerateKey()
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to generate preshared key: %w", err)
+	}
+	err = p.store.Create(ctx, name, key)
+	if errors.Is(err, ErrExists) {
+		return p.store.Get(ctx, name)
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+	slog.Info("Generated preshared key", "key", name, "peer", peer)
+	return Entry{Key: key}, nil
+}
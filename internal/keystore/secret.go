@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package keystore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// SecretType marks the Secrets holding keys
+	SecretType corev1.SecretType = "kubewg.net/key"
+	// SecretDataKey is the data key of the raw key
+	SecretDataKey = "key"
+
+	// SecretLabel is set to "true" on the Secrets holding keys
+	SecretLabel = "kubewg.net/keystore"
+
+	annotationCreated = "kubewg.net/created"
+)
+
+// Secrets keeps each key in a Secret named after it. Keys are only ever
+// read by name, never listed, so nodes need nothing beyond get. That still
+// covers every Secret of the namespace, a node that may get its own key may
+// get those of the others too.
+type Secrets struct {
+	client    kubernetes.Interface
+	namespace string
+}
+
+func NewSecrets(client kubernetes.Interface, namespace string) *Secrets {
+	return &Secrets{client: client, namespace: namespace}
+}
+
+// SecretName names the Secret holding the key called name.
+func SecretName(name string) string {
+	return "kubewg-" + strings.ReplaceAll(name, "/", "-")
+}
+
+func (s *Secrets) Get(ctx context.Context, name string) (Entry, error) {
+	secret, err := s.client.CoreV1().Secrets(s.namespace).Get(ctx, SecretName(name), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return Entry{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+	} else if err != nil {
+		return Entry{}, fmt.Errorf("failed to get secret %s/%s: %w", s.namespace, SecretName(name), err)
+	}
+
+	key, err := wgtypes.NewKey(secret.Data[SecretDataKey])
+	if err != nil {
+		return Entry{}, fmt.Errorf("invalid key in secret %s/%s: %w", s.namespace, secret.Name, err)
+	}
+	created, err := time.Parse(time.RFC3339, secret.Annotations[annotationCreated])
+	if err != nil {
+		created = secret.CreationTimestamp.Time
+	}
+	return Entry{Key: key, Created: created}, nil
+}
+
+func (s *Secrets) Put(ctx context.Context, name string, key wgtypes.Key) error {
+	secrets := s.client.CoreV1().Secrets(s.namespace)
+	existing, err := secrets.Get(ctx, SecretName(name), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = secrets.Create(ctx, s.secret(name, key), metav1.CreateOptions{})
+	} else if err == nil {
+		// Carrying the resourceVersion over makes a concurrent writer fail
+		// instead of being overwritten
+		secret := s.secret(name, key)
+		secret.ResourceVersion = existing.ResourceVersion
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write secret %s/%s: %w", s.namespace, SecretName(name), err)
+	}
+	return nil
+}
+
+func (s *Secrets) Create(ctx context.Context, name string, key wgtypes.Key) error {
+	_, err := s.client.CoreV1().Secrets(s.namespace).Create(ctx, s.secret(name, key), metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("%w: %s", ErrExists, name)
+	} else if err != nil {
+		return fmt.Errorf("failed to create secret %s/%s: %w", s.namespace, SecretName(name), err)
+	}
+	return nil
+}
+
+func (s *Secrets) secret(name string, key wgtypes.Key) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        SecretName(name),
+			Namespace:   s.namespace,
+			Labels:      map[string]string{SecretLabel: "true"},
+			Annotations: map[string]string{annotationCreated: time.Now().UTC().Format(time.RFC3339)},
+		},
+		Type: SecretType,
+		Data: map[string][]byte{SecretDataKey: key[:]},
+	}
+}
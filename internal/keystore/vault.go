@@ -0,0 +1,276 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package keystore
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/cryptopolicy"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const vaultTimeout = 10 * time.Second
+
+var (
+	ErrNoCACerts     = errors.New("no certificates found in CA file")
+	ErrVaultResponse = errors.New("unexpected response from vault")
+)
+
+// Vault keeps keys in a KV v2 secrets engine, under the configured path.
+// With a Transit key, KV only ever holds the key encrypted by Transit. It
+// logs in with the Kubernetes auth method using the pod's service account
+// token and logs in again once the token is about to expire.
+type Vault struct {
+	config  *config.Vault
+	client  *http.Client
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func NewVault(vault *config.Vault, policy *config.CryptoPolicy) (*Vault, error) {
+	tlsConfig := cryptopolicy.TLSConfig(policy)
+	if vault.CAFile != "" {
+		pem, err := os.ReadFile(vault.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read vault CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, ErrNoCACerts
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &Vault{
+		config: vault,
+		client: &http.Client{
+			Timeout: vaultTimeout,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+	}, nil
+}
+
+type kvResponse struct {
+	Data struct {
+		Data     map[string]string `json:"data"`
+		Metadata struct {
+			CreatedTime time.Time `json:"created_time"`
+		} `json:"metadata"`
+	} `json:"data"`
+}
+
+func (v *Vault) Get(ctx context.Context, name string) (Entry, error) {
+	var response kvResponse
+	status, err := v.request(ctx, http.MethodGet, v.kvPath(name), nil, &response)
+	if status == http.StatusNotFound {
+		return Entry{}, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+
+	var key wgtypes.Key
+	if v.config.Transit.Key != "" {
+		key, err = v.decrypt(ctx, response.Data.Data["ciphertext"])
+	} else {
+		key, err = wgtypes.ParseKey(response.Data.Data["key"])
+	}
+	if err != nil {
+		return Entry{}, fmt.Errorf("invalid key %s in vault: %w", name, err)
+	}
+	return Entry{Key: key, Created: response.Data.Metadata.CreatedTime}, nil
+}
+
+func (v *Vault) Put(ctx context.Context, name string, key wgtypes.Key) error {
+	return v.write(ctx, name, key, nil)
+}
+
+func (v *Vault) Create(ctx context.Context, name string, key wgtypes.Key) error {
+	// A check-and-set version of 0 only writes if nothing is stored yet
+	err := v.write(ctx, name, key, map[string]int{"cas": 0})
+	if err != nil && strings.Contains(err.Error(), "check-and-set") {
+		return fmt.Errorf("%w: %s", ErrExists, name)
+	}
+	return err
+}
+
+func (v *Vault) write(ctx context.Context, name string, key wgtypes.Key, options map[string]int) error {
+	data := map[string]string{"key": key.String()}
+	if v.config.Transit.Key != "" {
+		ciphertext, err := v.encrypt(ctx, key)
+		if err != nil {
+			return err
+		}
+		data = map[string]string{"ciphertext": ciphertext}
+	}
+
+	body := map[string]any{"data": data}
+	if options != nil {
+		body["options"] = options
+	}
+	_, err := v.request(ctx, http.MethodPost, v.kvPath(name), body, nil)
+	return err
+}
+
+func (v *Vault) kvPath(name string) string {
+	return v.config.KV.Mount + "/data/" + strings.Trim(v.config.KV.Path, "/") + "/" + name
+}
+
+func (v *Vault) encrypt(ctx context.Context, key wgtypes.Key) (string, error) {
+	var response struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	body := map[string]string{"plaintext": base64.StdEncoding.EncodeToString(key[:])}
+	if _, err := v.request(ctx, http.MethodPost, v.config.Transit.Mount+"/encrypt/"+v.config.Transit.Key, body, &response); err != nil {
+		return "", fmt.Errorf("failed to encrypt key: %w", err)
+	}
+	return response.Data.Ciphertext, nil
+}
+
+func (v *Vault) decrypt(ctx context.Context, ciphertext string) (wgtypes.Key, error) {
+	var response struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	body := map[string]string{"ciphertext": ciphertext}
+	if _, err := v.request(ctx, http.MethodPost, v.config.Transit.Mount+"/decrypt/"+v.config.Transit.Key, body, &response); err != nil {
+		return wgtypes.Key{}, fmt.Errorf("failed to decrypt key: %w", err)
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(response.Data.Plaintext)
+	if err != nil {
+		return wgtypes.Key{}, fmt.Errorf("failed to decode decrypted key: %w", err)
+	}
+	return wgtypes.NewKey(plaintext)
+}
+
+// request calls the Vault API at path, logging in first when needed and
+// once more if the token was rejected, e.g. because it was revoked.
+func (v *Vault) request(ctx context.Context, method, path string, body, result any) (int, error) {
+	status, err := v.authenticated(ctx, method, path, body, result, false)
+	if status == http.StatusForbidden {
+		status, err = v.authenticated(ctx, method, path, body, result, true)
+	}
+	return status, err
+}
+
+func (v *Vault) authenticated(ctx context.Context, method, path string, body, result any, relogin bool) (int, error) {
+	token, err := v.loginToken(ctx, relogin)
+	if err != nil {
+		return 0, err
+	}
+	return v.do(ctx, method, path, token, body, result)
+}
+
+// loginToken returns a valid client token, logging in through the
+// Kubernetes auth method if there is none or force is set.
+func (v *Vault) loginToken(ctx context.Context, force bool) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if !force && v.token != "" && time.Now().Before(v.expires) {
+		return v.token, nil
+	}
+
+	jwt, err := os.ReadFile(v.config.Auth.TokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read service account token: %w", err)
+	}
+	var response struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	body := map[string]string{"role": v.config.Auth.Role, "jwt": strings.TrimSpace(string(jwt))}
+	if _, err := v.do(ctx, http.MethodPost, "auth/"+v.config.Auth.Mount+"/login", "", body, &response); err != nil {
+		return "", fmt.Errorf("failed to log in to vault: %w", err)
+	}
+
+	// Renew well before the lease runs out
+	v.token = response.Auth.ClientToken
+	v.expires = time.Now().Add(time.Duration(response.Auth.LeaseDuration) * time.Second * 4 / 5)
+	return v.token, nil
+}
+
+func (v *Vault) do(ctx context.Context, method, path, token string, body, result any) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode vault request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	url := strings.TrimSuffix(v.config.Address, "/") + "/v1/" + path
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create vault request: %w", err)
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if v.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.config.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reach vault: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var failure struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&failure)
+		return resp.StatusCode, fmt.Errorf("%w: %s %s: %s: %s", ErrVaultResponse, method, path, resp.Status, strings.Join(failure.Errors, "; "))
+	}
+	if result != nil && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			return resp.StatusCode, fmt.Errorf("failed to decode vault response: %w", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,167 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package keystore_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/keystore"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// fakeVault implements just enough of the Kubernetes auth method, KV v2 and
+// Transit. Its "encryption" prefixes the plaintext.
+type fakeVault struct {
+	mu     sync.Mutex
+	kv     map[string]map[string]string
+	logins int
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var body map[string]any
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	reply := func(status int, v any) {
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(v)
+	}
+
+	switch {
+	case r.URL.Path == "/v1/auth/kubernetes/login":
+		if body["jwt"] != "sa-token" || body["role"] != "kubewg" {
+			reply(http.StatusForbidden, map[string]any{"errors": []string{"permission denied"}})
+			return
+		}
+		f.logins++
+		reply(http.StatusOK, map[string]any{"auth": map[string]any{"client_token": "vault-token", "lease_duration": 3600}})
+		return
+	case r.Header.Get("X-Vault-Token") != "vault-token":
+		reply(http.StatusForbidden, map[string]any{"errors": []string{"permission denied"}})
+		return
+	case r.URL.Path == "/v1/transit/encrypt/kubewg":
+		plaintext, _ := body["plaintext"].(string)
+		reply(http.StatusOK, map[string]any{"data": map[string]any{"ciphertext": "vault:v1:" + plaintext}})
+		return
+	case r.URL.Path == "/v1/transit/decrypt/kubewg":
+		ciphertext, _ := body["ciphertext"].(string)
+		reply(http.StatusOK, map[string]any{"data": map[string]any{"plaintext": strings.TrimPrefix(ciphertext, "vault:v1:")}})
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")
+	switch r.Method {
+	case http.MethodGet:
+		data, ok := f.kv[path]
+		if !ok {
+			reply(http.StatusNotFound, map[string]any{"errors": []string{}})
+			return
+		}
+		reply(http.StatusOK, map[string]any{"data": map[string]any{
+			"data":     data,
+			"metadata": map[string]any{"created_time": "2026-01-02T03:04:05Z"},
+		}})
+	case http.MethodPost:
+		if options, ok := body["options"].(map[string]any); ok && options["cas"] == float64(0) {
+			if _, exists := f.kv[path]; exists {
+				reply(http.StatusBadRequest, map[string]any{"errors": []string{"check-and-set parameter did not match the current version"}})
+				return
+			}
+		}
+		data := map[string]string{}
+		fields, _ := body["data"].(map[string]any)
+		for k, v := range fields {
+			data[k], _ = v.(string)
+		}
+		f.kv[path] = data
+		reply(http.StatusOK, map[string]any{})
+	}
+}
+
+func TestVault(t *testing.T) {
+	t.Parallel()
+
+	fake := &fakeVault{kv: map[string]map[string]string{}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("sa-token\n"), 0o600); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	vault, err := keystore.NewVault(&config.Vault{
+		Address: server.URL,
+		Auth:    config.VaultAuth{Mount: "kubernetes", Role: "kubewg", TokenFile: tokenFile},
+		KV:      config.VaultKV{Mount: "secret", Path: "kubewg"},
+		Transit: config.VaultTransit{Mount: "transit", Key: "kubewg"},
+	}, &config.CryptoPolicy{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	ctx := context.Background()
+	name := keystore.PrivateKeyName("node-a")
+	if _, err := vault.Get(ctx, name); !errors.Is(err, keystore.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	entry, err := keystore.LoadOrGenerate(ctx, vault, name)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	stored := fake.kv["kubewg/nodes/node-a/private-key"]
+	if _, ok := stored["key"]; ok || !strings.HasPrefix(stored["ciphertext"], "vault:v1:") {
+		t.Errorf("expected only the transit ciphertext in KV, got %v", stored)
+	}
+
+	again, err := vault.Get(ctx, name)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if again.Key != entry.Key {
+		t.Errorf("expected the stored key back, got a different one")
+	}
+	if want := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC); !again.Created.Equal(want) {
+		t.Errorf("expected created time %s, got %s", want, again.Created)
+	}
+
+	other, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := vault.Create(ctx, name, other); !errors.Is(err, keystore.ErrExists) {
+		t.Errorf("expected ErrExists, got %v", err)
+	}
+	if fake.logins != 1 {
+		t.Errorf("expected a single login, got %d", fake.logins)
+	}
+}
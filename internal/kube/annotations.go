@@ -0,0 +1,208 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/kubewg-net/container/internal/events"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// Names of the node annotations, after the configured prefix
+const (
+	AnnotationPublicKey = "public-key"
+	AnnotationTunnelIPs = "tunnel-ips"
+	AnnotationEndpoint  = "endpoint"
+	// AnnotationListenPort also tells a restarted node which port it had
+	// picked from its range
+	AnnotationListenPort = "listen-port"
+	// AnnotationAttestation vouches for the public key with the node's
+	// SPIFFE SVID
+	AnnotationAttestation = "spiffe-attestation"
+)
+
+// annotationResync restores annotations someone else removed or changed
+const annotationResync = 5 * time.Minute
+
+// NodeAnnotations are the values this node advertises to the rest of the
+// cluster. Empty values remove their annotation.
+type NodeAnnotations struct {
+	PublicKey string
+	TunnelIPs string
+	Endpoint  string
+	// ListenPort 0 removes the annotation
+	ListenPort uint16
+	// Attestation is only published with SPIFFE enabled
+	Attestation string
+}
+
+// AnnotationPublisher keeps the WireGuard details of this node in its
+// Node's annotations, where the other nodes discover them.
+type AnnotationPublisher struct {
+	client   kubernetes.Interface
+	nodeName string
+	prefix   string
+	values   func() NodeAnnotations
+	trigger  chan struct{}
+}
+
+// NewAnnotationPublisher publishes whatever values returns, which is called
+// again each time the publisher syncs.
+func NewAnnotationPublisher(client kubernetes.Interface, nodeName, prefix string, values func() NodeAnnotations) *AnnotationPublisher {
+	return &AnnotationPublisher{
+		client:   client,
+		nodeName: nodeName,
+		prefix:   prefix,
+		values:   values,
+		trigger:  make(chan struct{}, 1),
+	}
+}
+
+// Publish schedules a sync when the interface key is rotated.
+func (p *AnnotationPublisher) Publish(event events.Event) {
+	if event.Type == events.KeyRotated {
+		p.Trigger()
+	}
+}
+
+// Trigger schedules a sync, e.g. after the endpoint changed.
+func (p *AnnotationPublisher) Trigger() {
+	select {
+	case p.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Start syncs the annotations until ctx is cancelled.
+func (p *AnnotationPublisher) Start(ctx context.Context) {
+	ticker := time.NewTicker(annotationResync)
+	defer ticker.Stop()
+
+	for {
+		if err := p.Sync(ctx); err != nil && ctx.Err() == nil {
+			slog.Error("Failed to publish node annotations", "node", p.nodeName, "error", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-p.trigger:
+		}
+	}
+}
+
+// Sync brings the node's annotations up to date. The patch only touches
+// this publisher's annotations and is guarded by the resourceVersion it was
+// computed from, so a concurrent writer, e.g. the previous pod during a
+// rollout, makes it start over instead of overwriting newer values.
+func (p *AnnotationPublisher) Sync(ctx context.Context) error {
+	values := p.values()
+	changed, err := p.patch(ctx, values)
+	if changed {
+		slog.Info("Published node annotations", "node", p.nodeName, "public_key", values.PublicKey,
+			"tunnel_ips", values.TunnelIPs, "endpoint", values.Endpoint, "listen_port", values.ListenPort)
+	}
+	return err
+}
+
+// Remove deletes the annotations, for a node that is shutting down. It
+// must not run concurrently with Start.
+func (p *AnnotationPublisher) Remove(ctx context.Context) error {
+	changed, err := p.patch(ctx, NodeAnnotations{})
+	if changed {
+		slog.Info("Removed node annotations", "node", p.nodeName)
+	}
+	return err
+}
+
+// patch sets the annotations to values and reports whether it changed any.
+func (p *AnnotationPublisher) patch(ctx context.Context, values NodeAnnotations) (bool, error) {
+	listenPort := ""
+	if values.ListenPort != 0 {
+		listenPort = strconv.Itoa(int(values.ListenPort))
+	}
+	desired := map[string]string{
+		p.prefix + AnnotationPublicKey:   values.PublicKey,
+		p.prefix + AnnotationTunnelIPs:   values.TunnelIPs,
+		p.prefix + AnnotationEndpoint:    values.Endpoint,
+		p.prefix + AnnotationListenPort:  listenPort,
+		p.prefix + AnnotationAttestation: values.Attestation,
+	}
+
+	changed := false
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := p.client.CoreV1().Nodes().Get(ctx, p.nodeName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get node %s: %w", p.nodeName, err)
+		}
+
+		changes := map[string]*string{}
+		for key, value := range desired {
+			current, ok := node.Annotations[key]
+			switch {
+			case value == "" && ok:
+				changes[key] = nil
+			case value != "" && current != value:
+				changes[key] = &value
+			}
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+
+		patch, err := json.Marshal(map[string]any{
+			"metadata": map[string]any{
+				"resourceVersion": node.ResourceVersion,
+				"annotations":     changes,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to encode node annotations: %w", err)
+		}
+		_, err = p.client.CoreV1().Nodes().Patch(ctx, p.nodeName, types.MergePatchType, patch, metav1.PatchOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to patch node %s: %w", p.nodeName, err)
+		}
+		changed = true
+		return nil
+	})
+	return changed, err
+}
+
+// PublishedListenPort returns the listen port node advertised under
+// prefix, or 0 if it advertised none.
+func PublishedListenPort(node *corev1.Node, prefix string) uint16 {
+	port, err := strconv.ParseUint(node.Annotations[prefix+AnnotationListenPort], 10, 16)
+	if err != nil {
+		return 0
+	}
+	return uint16(port)
+}
@@ -0,0 +1,140 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package kube_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/kube"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAnnotationPublisherSync(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewSimpleClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-a",
+			Annotations: map[string]string{
+				"other.io/owner":      "someone else",
+				"kubewg.net/endpoint": "198.51.100.1:51820",
+			},
+		},
+	})
+	values := kube.NodeAnnotations{
+		PublicKey: "key-1",
+		TunnelIPs: "10.0.0.1",
+	}
+	publisher := kube.NewAnnotationPublisher(client, "node-a", "kubewg.net/", func() kube.NodeAnnotations {
+		return values
+	})
+
+	if err := publisher.Sync(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	node, err := client.CoreV1().Nodes().Get(context.Background(), "node-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got := node.Annotations["kubewg.net/public-key"]; got != "key-1" {
+		t.Errorf("expected public key key-1, got %q", got)
+	}
+	if got := node.Annotations["kubewg.net/tunnel-ips"]; got != "10.0.0.1" {
+		t.Errorf("expected tunnel IPs 10.0.0.1, got %q", got)
+	}
+	if _, ok := node.Annotations["kubewg.net/endpoint"]; ok {
+		t.Errorf("expected the empty endpoint to be removed, got %q", node.Annotations["kubewg.net/endpoint"])
+	}
+	if got := node.Annotations["other.io/owner"]; got != "someone else" {
+		t.Errorf("expected foreign annotations to be kept, got %q", got)
+	}
+
+	// Nothing changed, so nothing is patched
+	client.ClearActions()
+	if err := publisher.Sync(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "patch" {
+			t.Fatalf("expected no patch for unchanged values, got %v", action)
+		}
+	}
+
+	// A rotated key is republished
+	values.PublicKey = "key-2"
+	if err := publisher.Sync(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	node, err = client.CoreV1().Nodes().Get(context.Background(), "node-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got := node.Annotations["kubewg.net/public-key"]; got != "key-2" {
+		t.Errorf("expected public key key-2, got %q", got)
+	}
+	if got := kube.PublishedListenPort(node, "kubewg.net/"); got != 0 {
+		t.Errorf("expected no listen port, got %d", got)
+	}
+
+	// So is a port picked anew after a restart
+	values.ListenPort = 51821
+	if err := publisher.Sync(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	node, err = client.CoreV1().Nodes().Get(context.Background(), "node-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got := kube.PublishedListenPort(node, "kubewg.net/"); got != 51821 {
+		t.Errorf("expected listen port 51821, got %d", got)
+	}
+}
+
+func TestAnnotationPublisherRemove(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewSimpleClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-a",
+			Annotations: map[string]string{
+				"other.io/owner":         "someone else",
+				"kubewg.net/public-key":  "key-1",
+				"kubewg.net/listen-port": "51820",
+			},
+		},
+	})
+	publisher := kube.NewAnnotationPublisher(client, "node-a", "kubewg.net/", func() kube.NodeAnnotations {
+		return kube.NodeAnnotations{PublicKey: "key-1", ListenPort: 51820}
+	})
+
+	if err := publisher.Remove(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	node, err := client.CoreV1().Nodes().Get(context.Background(), "node-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(node.Annotations) != 1 || node.Annotations["other.io/owner"] != "someone else" {
+		t.Errorf("expected only the foreign annotation to be left, got %v", node.Annotations)
+	}
+}
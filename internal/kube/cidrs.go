@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Where discovered cluster CIDRs come from, in the order they are tried
+const (
+	CIDRSourceKubeadm      = "kubeadm"
+	CIDRSourceControlPlane = "control_plane"
+	CIDRSourceNodes        = "nodes"
+)
+
+const (
+	kubeadmNamespace = "kube-system"
+	kubeadmConfigMap = "kubeadm-config"
+	kubeadmConfigKey = "ClusterConfiguration"
+
+	podCIDRFlag     = "--cluster-cidr"
+	serviceCIDRFlag = "--service-cluster-ip-range"
+)
+
+var ErrNoClusterCIDRs = errors.New("no Pod or Service CIDRs found in the cluster")
+
+// ClusterCIDRs are the Pod and Service CIDRs found by DiscoverClusterCIDRs
+// and the source each came from.
+type ClusterCIDRs struct {
+	Pods          []string
+	PodSource     string
+	Services      []string
+	ServiceSource string
+}
+
+// DiscoverClusterCIDRs looks up the cluster's Pod and Service CIDRs. The
+// kubeadm ConfigMap has both, then the flags of the kube-apiserver and
+// kube-controller-manager static pods, and the nodes' Pod CIDRs as a last
+// resort for the Pods. Managed clusters usually hide the control plane, so
+// a source that can't be read is skipped rather than failing.
+func DiscoverClusterCIDRs(ctx context.Context, client kubernetes.Interface) (*ClusterCIDRs, error) {
+	var cidrs ClusterCIDRs
+	found := func(source string, pods, services []string) {
+		if len(cidrs.Pods) == 0 && len(pods) > 0 {
+			cidrs.Pods, cidrs.PodSource = pods, source
+		}
+		if len(cidrs.Services) == 0 && len(services) > 0 {
+			cidrs.Services, cidrs.ServiceSource = services, source
+		}
+	}
+
+	pods, services, err := kubeadmCIDRs(ctx, client)
+	if err != nil {
+		slog.Debug("Failed to read the kubeadm cluster configuration", "error", err.Error())
+	}
+	found(CIDRSourceKubeadm, pods, services)
+
+	if len(cidrs.Pods) == 0 || len(cidrs.Services) == 0 {
+		pods, services, err = controlPlaneCIDRs(ctx, client)
+		if err != nil {
+			slog.Debug("Failed to read the control plane flags", "error", err.Error())
+		}
+		found(CIDRSourceControlPlane, pods, services)
+	}
+
+	if len(cidrs.Pods) == 0 {
+		pods, err = nodePodCIDRs(ctx, client)
+		if err != nil {
+			slog.Debug("Failed to read the nodes' Pod CIDRs", "error", err.Error())
+		}
+		found(CIDRSourceNodes, pods, nil)
+	}
+
+	if len(cidrs.Pods) == 0 && len(cidrs.Services) == 0 {
+		return nil, ErrNoClusterCIDRs
+	}
+	return &cidrs, nil
+}
+
+func kubeadmCIDRs(ctx context.Context, client kubernetes.Interface) (pods, services []string, err error) {
+	configMap, err := client.CoreV1().ConfigMaps(kubeadmNamespace).Get(ctx, kubeadmConfigMap, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get %s/%s: %w", kubeadmNamespace, kubeadmConfigMap, err)
+	}
+
+	var clusterConfig struct {
+		Networking struct {
+			PodSubnet     string `json:"podSubnet"`
+			ServiceSubnet string `json:"serviceSubnet"`
+		} `json:"networking"`
+	}
+	if err := yaml.Unmarshal([]byte(configMap.Data[kubeadmConfigKey]), &clusterConfig); err != nil {
+		return nil, nil, fmt.Errorf("invalid %s in %s/%s: %w", kubeadmConfigKey, kubeadmNamespace, kubeadmConfigMap, err)
+	}
+	return parseCIDRs(clusterConfig.Networking.PodSubnet), parseCIDRs(clusterConfig.Networking.ServiceSubnet), nil
+}
+
+func controlPlaneCIDRs(ctx context.Context, client kubernetes.Interface) (pods, services []string, err error) {
+	list, err := client.CoreV1().Pods(kubeadmNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: "component in (kube-apiserver,kube-controller-manager)",
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list control plane pods: %w", err)
+	}
+
+	for i := range list.Items {
+		for _, container := range list.Items[i].Spec.Containers {
+			if value, ok := flagValue(container, podCIDRFlag); ok && len(pods) == 0 {
+				pods = parseCIDRs(value)
+			}
+			if value, ok := flagValue(container, serviceCIDRFlag); ok && len(services) == 0 {
+				services = parseCIDRs(value)
+			}
+		}
+	}
+	return pods, services, nil
+}
+
+// flagValue returns the value of a --flag=value in the container's command
+// or arguments.
+func flagValue(container corev1.Container, flag string) (string, bool) {
+	for _, arg := range append(container.Command, container.Args...) {
+		if value, ok := strings.CutPrefix(arg, flag+"="); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// nodePodCIDRs collects the Pod CIDRs assigned to every node, which
+// together cover the Pods when the cluster-wide range is unknown.
+func nodePodCIDRs(ctx context.Context, client kubernetes.Interface) ([]string, error) {
+	list, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	var cidrs []string
+	seen := make(map[string]struct{})
+	for i := range list.Items {
+		spec := &list.Items[i].Spec
+		nodeCIDRs := spec.PodCIDRs
+		if len(nodeCIDRs) == 0 && spec.PodCIDR != "" {
+			nodeCIDRs = []string{spec.PodCIDR}
+		}
+		for _, cidr := range parseCIDRs(strings.Join(nodeCIDRs, ",")) {
+			if _, ok := seen[cidr]; !ok {
+				seen[cidr] = struct{}{}
+				cidrs = append(cidrs, cidr)
+			}
+		}
+	}
+	return cidrs, nil
+}
+
+// parseCIDRs splits a comma-separated list of CIDRs, as dual-stack
+// clusters configure them, dropping the invalid ones.
+func parseCIDRs(value string) []string {
+	var cidrs []string
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			slog.Warn("Ignoring invalid cluster CIDR", "cidr", field)
+			continue
+		}
+		cidrs = append(cidrs, prefix.Masked().String())
+	}
+	return cidrs
+}
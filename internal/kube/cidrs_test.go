@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package kube_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/kube"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDiscoverClusterCIDRsKubeadm(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "kubeadm-config", Namespace: "kube-system"},
+		Data: map[string]string{
+			"ClusterConfiguration": "apiVersion: kubeadm.k8s.io/v1beta3\nkind: ClusterConfiguration\n" +
+				"networking:\n  podSubnet: 10.244.0.0/16,fd00:10:244::/56\n  serviceSubnet: 10.96.0.0/12\n",
+		},
+	})
+	cidrs, err := kube.DiscoverClusterCIDRs(context.Background(), client)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !slices.Equal(cidrs.Pods, []string{"10.244.0.0/16", "fd00:10:244::/56"}) || cidrs.PodSource != kube.CIDRSourceKubeadm {
+		t.Errorf("expected both Pod CIDRs from kubeadm, got %v from %s", cidrs.Pods, cidrs.PodSource)
+	}
+	if !slices.Equal(cidrs.Services, []string{"10.96.0.0/12"}) || cidrs.ServiceSource != kube.CIDRSourceKubeadm {
+		t.Errorf("expected the Service CIDR from kubeadm, got %v from %s", cidrs.Services, cidrs.ServiceSource)
+	}
+}
+
+func TestDiscoverClusterCIDRsFallback(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "kube-apiserver-cp",
+				Namespace: "kube-system",
+				Labels:    map[string]string{"component": "kube-apiserver"},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name:    "kube-apiserver",
+				Command: []string{"kube-apiserver", "--secure-port=6443", "--service-cluster-ip-range=10.43.0.1/16"},
+			}}},
+		},
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+			Spec:       corev1.NodeSpec{PodCIDR: "10.42.0.0/24", PodCIDRs: []string{"10.42.0.0/24"}},
+		},
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-b"},
+			Spec:       corev1.NodeSpec{PodCIDR: "10.42.1.0/24"},
+		},
+	)
+	cidrs, err := kube.DiscoverClusterCIDRs(context.Background(), client)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !slices.Equal(cidrs.Services, []string{"10.43.0.0/16"}) || cidrs.ServiceSource != kube.CIDRSourceControlPlane {
+		t.Errorf("expected the masked Service CIDR from the API server flags, got %v from %s", cidrs.Services, cidrs.ServiceSource)
+	}
+	if !slices.Equal(cidrs.Pods, []string{"10.42.0.0/24", "10.42.1.0/24"}) || cidrs.PodSource != kube.CIDRSourceNodes {
+		t.Errorf("expected the nodes' Pod CIDRs, got %v from %s", cidrs.Pods, cidrs.PodSource)
+	}
+
+	_, err = kube.DiscoverClusterCIDRs(context.Background(), fake.NewSimpleClientset())
+	if !errors.Is(err, kube.ErrNoClusterCIDRs) {
+		t.Errorf("expected ErrNoClusterCIDRs in an empty cluster, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package kube holds the Kubernetes integrations.
+package kube
+
+import (
+	"fmt"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/tracing"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// RESTConfig loads the configured kubeconfig, or the in-cluster config when
+// none is set. Requests made with it are traced.
+func RESTConfig(config *config.Kubernetes) (*rest.Config, error) {
+	var restConfig *rest.Config
+	var err error
+	if config.Kubeconfig == "" {
+		restConfig, err = rest.InClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load in-cluster config: %w", err)
+		}
+	} else {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", config.Kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load kubeconfig %s: %w", config.Kubeconfig, err)
+		}
+	}
+	restConfig.Wrap(tracing.WrapTransport)
+	return restConfig, nil
+}
+
+func NewClient(config *config.Kubernetes) (kubernetes.Interface, error) {
+	restConfig, err := RESTConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+	return client, nil
+}
+
+// NewDynamicClient returns a client for the kubewg custom resources.
+func NewDynamicClient(config *config.Kubernetes) (dynamic.Interface, error) {
+	restConfig, err := RESTConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic Kubernetes client: %w", err)
+	}
+	return client, nil
+}
@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package kube
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/kubewg-net/container/internal/health"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// ConditionAPI is the health condition of the Kubernetes API connection
+	ConditionAPI = "KubernetesAPI"
+
+	probeInterval = 10 * time.Second
+	probeTimeout  = 5 * time.Second
+)
+
+// Monitor probes the Kubernetes API server and reports whether it can be
+// reached. Callers keep working from their last known state while it
+// can't, and resync once it can again.
+type Monitor struct {
+	client    kubernetes.Interface
+	status    *health.Status
+	listeners []func(reachable bool)
+}
+
+func NewMonitor(client kubernetes.Interface, status *health.Status) *Monitor {
+	return &Monitor{
+		client: client,
+		status: status,
+	}
+}
+
+// OnChange registers fn to be called whenever the API server becomes
+// unreachable or reachable again. It must be called before Start.
+func (m *Monitor) OnChange(fn func(reachable bool)) {
+	m.listeners = append(m.listeners, fn)
+}
+
+// Start probes until ctx is done.
+func (m *Monitor) Start(ctx context.Context) {
+	ticker := time.NewTicker(probeInterval)
+	defer ticker.Stop()
+
+	for {
+		m.probe(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Monitor) probe(parent context.Context) {
+	ctx, cancel := context.WithTimeout(parent, probeTimeout)
+	defer cancel()
+
+	condition := health.Condition{Type: ConditionAPI, OK: true}
+	err := m.client.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Error()
+	if err != nil {
+		// Shutting down, not an outage
+		if parent.Err() != nil {
+			return
+		}
+		condition.OK = false
+		condition.Reason = "Unreachable"
+		condition.Message = err.Error()
+	}
+
+	if !m.status.Set(condition) {
+		return
+	}
+	if condition.OK {
+		slog.Info("Kubernetes API is reachable again, resyncing")
+	} else {
+		slog.Warn("Kubernetes API is unreachable, running from the last known state", "error", condition.Message)
+	}
+	for _, fn := range m.listeners {
+		fn(condition.OK)
+	}
+}
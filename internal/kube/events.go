@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package kube
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kubewg-net/container/internal/events"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	eventComponent = "kubewg"
+
+	// maxBufferedEvents bounds the events kept while the API server is
+	// unreachable, the oldest are dropped first
+	maxBufferedEvents = 256
+)
+
+// PeerReferences finds the object a peer is defined by.
+type PeerReferences interface {
+	Reference(publicKey string) (*corev1.ObjectReference, bool)
+}
+
+// EventRecorder turns peer lifecycle events into Kubernetes Events attached
+// to the peer's WireGuardPeer when it has one, or to this node, so they show
+// up in `kubectl describe`.
+type EventRecorder struct {
+	broadcaster record.EventBroadcaster
+	recorder    record.EventRecorder
+	node        *corev1.ObjectReference
+	peers       PeerReferences
+	mu          sync.Mutex
+	buffered    bool
+	buffer      []events.Event
+}
+
+func NewEventRecorder(client kubernetes.Interface, nodeName string) *EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: client.CoreV1().Events(""),
+	})
+
+	return &EventRecorder{
+		broadcaster: broadcaster,
+		recorder:    broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: eventComponent, Host: nodeName}),
+		// The kubelet uses the node name as the UID of node references too
+		node: &corev1.ObjectReference{
+			Kind: "Node",
+			Name: nodeName,
+			UID:  types.UID(nodeName),
+		},
+	}
+}
+
+// AttachPeers makes events about peers found by refs go to their objects.
+// It must be called before events are published.
+func (e *EventRecorder) AttachPeers(refs PeerReferences) {
+	e.peers = refs
+}
+
+func (e *EventRecorder) Publish(event events.Event) {
+	e.mu.Lock()
+	if e.buffered {
+		if len(e.buffer) == maxBufferedEvents {
+			e.buffer = e.buffer[1:]
+		}
+		e.buffer = append(e.buffer, event)
+		e.mu.Unlock()
+		return
+	}
+	e.mu.Unlock()
+
+	e.record(event, "")
+}
+
+// SetBuffered switches between holding events back while the API server is
+// unreachable and recording them. Switching back records the held events.
+func (e *EventRecorder) SetBuffered(buffered bool) {
+	e.mu.Lock()
+	e.buffered = buffered
+	held := e.buffer
+	if !buffered {
+		e.buffer = nil
+	}
+	e.mu.Unlock()
+
+	if buffered {
+		return
+	}
+	for _, event := range held {
+		e.record(event, fmt.Sprintf(" (delayed from %s)", event.Time.Format(time.RFC3339)))
+	}
+}
+
+func (e *EventRecorder) record(event events.Event, suffix string) {
+	eventType := corev1.EventTypeNormal
+	if event.Type.Warning() {
+		eventType = corev1.EventTypeWarning
+	}
+
+	if e.peers != nil {
+		if ref, ok := e.peers.Reference(event.PublicKey); ok {
+			e.recorder.Eventf(ref, eventType, string(event.Type), "%s on node %s%s", event.Message, e.node.Name, suffix)
+			return
+		}
+	}
+	e.recorder.Eventf(e.node, eventType, string(event.Type), "Peer %s: %s%s", event.PublicKey, event.Message, suffix)
+}
+
+// Stop flushes and shuts down the event broadcaster.
+func (e *EventRecorder) Stop() {
+	e.broadcaster.Shutdown()
+}
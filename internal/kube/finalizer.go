@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package kube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+// NodeFinalizer holds back the deletion of a Node until its gateway, or the
+// operator in its place, removed the node's WireGuardPeer status entries
+const NodeFinalizer = "kubewg.net/teardown"
+
+// AddNodeFinalizer adds NodeFinalizer to the Node nodeName.
+func AddNodeFinalizer(ctx context.Context, client kubernetes.Interface, nodeName string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get node %s: %w", nodeName, err)
+		}
+		if slices.Contains(node.Finalizers, NodeFinalizer) {
+			return nil
+		}
+		return patchFinalizers(ctx, client, nodeName, node.ResourceVersion, append(node.Finalizers, NodeFinalizer))
+	})
+}
+
+// RemoveNodeFinalizer removes NodeFinalizer from the Node nodeName, letting
+// its deletion go ahead. A Node that is already gone is not an error.
+func RemoveNodeFinalizer(ctx context.Context, client kubernetes.Interface, nodeName string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to get node %s: %w", nodeName, err)
+		}
+		if !slices.Contains(node.Finalizers, NodeFinalizer) {
+			return nil
+		}
+		finalizers := slices.DeleteFunc(slices.Clone(node.Finalizers), func(finalizer string) bool {
+			return finalizer == NodeFinalizer
+		})
+		return patchFinalizers(ctx, client, nodeName, node.ResourceVersion, finalizers)
+	})
+}
+
+// NodeDeleting reports whether the Node nodeName is being deleted or is
+// already gone.
+func NodeDeleting(ctx context.Context, client kubernetes.Interface, nodeName string) (bool, error) {
+	node, err := client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to get node %s: %w", nodeName, err)
+	}
+	return node.DeletionTimestamp != nil, nil
+}
+
+// patchFinalizers replaces the finalizers of the Node, failing with a
+// conflict if it changed since resourceVersion.
+func patchFinalizers(ctx context.Context, client kubernetes.Interface, nodeName, resourceVersion string, finalizers []string) error {
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"resourceVersion": resourceVersion,
+			"finalizers":      finalizers,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode finalizer patch: %w", err)
+	}
+	_, err = client.CoreV1().Nodes().Patch(ctx, nodeName, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to patch node %s: %w", nodeName, err)
+	}
+	return nil
+}
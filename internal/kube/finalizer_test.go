@@ -0,0 +1,86 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package kube_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/kube"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNodeFinalizer(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(&corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "node-a",
+			Finalizers: []string{"other.io/cleanup"},
+		},
+	})
+	finalizers := func() []string {
+		node, err := client.CoreV1().Nodes().Get(ctx, "node-a", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return node.Finalizers
+	}
+
+	for range 2 {
+		if err := kube.AddNodeFinalizer(ctx, client, "node-a"); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if got := finalizers(); !slices.Equal(got, []string{"other.io/cleanup", kube.NodeFinalizer}) {
+		t.Errorf("expected the finalizer to be added once, got %v", got)
+	}
+
+	deleting, err := kube.NodeDeleting(ctx, client, "node-a")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if deleting {
+		t.Error("expected the node not to be deleting")
+	}
+
+	if err := kube.RemoveNodeFinalizer(ctx, client, "node-a"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got := finalizers(); !slices.Equal(got, []string{"other.io/cleanup"}) {
+		t.Errorf("expected only the foreign finalizer to be left, got %v", got)
+	}
+
+	// A node that is gone is being deleted, and has nothing to remove
+	deleting, err = kube.NodeDeleting(ctx, client, "node-b")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !deleting {
+		t.Error("expected a missing node to count as deleted")
+	}
+	if err := kube.RemoveNodeFinalizer(ctx, client, "node-b"); err != nil {
+		t.Errorf("expected no error for a missing node, got %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/pkg/apis/kubewg/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const networkResync = 10 * time.Minute
+
+//nolint:golint,gochecknoglobals
+var WireGuardNetworks = v1alpha1.SchemeGroupVersion.WithResource("wireguardnetworks")
+
+var (
+	ErrAddressOutsideNetwork = errors.New("wireguard address is outside the network's tunnel CIDR")
+	ErrTunnelCIDRFamily      = errors.New("a network has at most one tunnel CIDR per address family")
+)
+
+// GetNetwork fetches the WireGuardNetwork called name.
+func GetNetwork(ctx context.Context, client dynamic.Interface, name string) (*v1alpha1.WireGuardNetwork, error) {
+	obj, err := client.Resource(WireGuardNetworks).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get WireGuardNetwork %s: %w", name, err)
+	}
+	return toNetwork(obj)
+}
+
+// NetworkConfig converts a WireGuardNetwork into the network defaults its
+// members inherit.
+func NetworkConfig(network *v1alpha1.WireGuardNetwork) (config.Network, error) {
+	topology := network.Spec.Topology
+	if topology == "" {
+		topology = v1alpha1.TopologyFullMesh
+	}
+
+	var hubSelector string
+	if network.Spec.HubSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(network.Spec.HubSelector)
+		if err != nil {
+			return config.Network{}, fmt.Errorf("invalid hub selector in WireGuardNetwork %s: %w", network.Name, err)
+		}
+		hubSelector = selector.String()
+	}
+	if topology == v1alpha1.TopologyHubSpoke && hubSelector == "" {
+		return config.Network{}, fmt.Errorf("%w: WireGuardNetwork %s", config.ErrHubSelector, network.Name)
+	}
+
+	return config.Network{
+		Name:                network.Name,
+		Topology:            string(topology),
+		HubSelector:         hubSelector,
+		TunnelCIDR:          network.Spec.TunnelCIDR,
+		TunnelCIDRs:         network.Spec.CIDRs(),
+		MTU:                 network.Spec.MTU,
+		PersistentKeepalive: network.Spec.PersistentKeepalive,
+		DNS:                 network.Spec.DNS,
+		KeyRotation:         network.Spec.KeyRotation,
+	}, nil
+}
+
+// ApplyNetwork makes wg a member of network, replacing its network defaults
+// and moving the listen port, or the range it is picked from, into the
+// network's port range.
+func ApplyNetwork(wg *config.WireGuard, network *v1alpha1.WireGuardNetwork) error {
+	cidrs, err := tunnelCIDRs(network)
+	if err != nil {
+		return err
+	}
+	for _, address := range wg.Addresses {
+		prefix, err := netip.ParsePrefix(address)
+		if err != nil {
+			return fmt.Errorf("invalid address %q: %w", address, err)
+		}
+		inNetwork := slices.ContainsFunc(cidrs, func(cidr netip.Prefix) bool {
+			return cidr.Contains(prefix.Addr())
+		})
+		if !inNetwork {
+			return fmt.Errorf("%w: %s is not in %v", ErrAddressOutsideNetwork, address, cidrs)
+		}
+	}
+
+	if ports := network.Spec.ListenPortRange; ports != nil && wg.ListenPort == 0 {
+		// The port is picked later, from the network's range
+		wg.ListenPortRange = config.PortRange{Min: ports.Min, Max: ports.Max}
+	} else if ports != nil && !ports.Contains(wg.ListenPort) {
+		slog.Info("Moving listen port into the network's range", "network", network.Name, "from", wg.ListenPort, "to", ports.Min)
+		wg.ListenPort = ports.Min
+	}
+
+	wg.Network, err = NetworkConfig(network)
+	return err
+}
+
+// tunnelCIDRs parses the ranges of network, at most one per address
+// family.
+func tunnelCIDRs(network *v1alpha1.WireGuardNetwork) ([]netip.Prefix, error) {
+	var cidrs []netip.Prefix
+	for _, value := range network.Spec.CIDRs() {
+		cidr, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tunnel CIDR %q in WireGuardNetwork %s: %w", value, network.Name, err)
+		}
+		for _, other := range cidrs {
+			if other.Addr().Is4() == cidr.Addr().Is4() {
+				return nil, fmt.Errorf("%w: %s and %s in WireGuardNetwork %s", ErrTunnelCIDRFamily, other, cidr, network.Name)
+			}
+		}
+		cidrs = append(cidrs, cidr)
+	}
+	return cidrs, nil
+}
+
+// NetworkWatcher calls back whenever the watched WireGuardNetwork changes.
+type NetworkWatcher struct {
+	factory  dynamicinformer.DynamicSharedInformerFactory
+	onChange func(*v1alpha1.WireGuardNetwork)
+	stop     chan struct{}
+}
+
+func NewNetworkWatcher(client dynamic.Interface, name string, onChange func(*v1alpha1.WireGuardNetwork)) *NetworkWatcher {
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(client, networkResync, metav1.NamespaceAll, func(options *metav1.ListOptions) {
+		options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+	})
+
+	return &NetworkWatcher{
+		factory:  factory,
+		onChange: onChange,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start watches in the background until Stop is called or ctx is done.
+func (w *NetworkWatcher) Start(ctx context.Context) error {
+	informer := w.factory.ForResource(WireGuardNetworks).Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: w.handle,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if oldObj.(*unstructured.Unstructured).GetGeneration() != newObj.(*unstructured.Unstructured).GetGeneration() {
+				w.handle(newObj)
+			}
+		},
+		DeleteFunc: func(_ interface{}) {
+			slog.Warn("WireGuardNetwork was deleted, keeping the last known settings")
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch WireGuardNetworks: %w", err)
+	}
+
+	stop := make(chan struct{})
+	go func() {
+		defer close(stop)
+		select {
+		case <-ctx.Done():
+		case <-w.stop:
+		}
+	}()
+	w.factory.Start(stop)
+	return nil
+}
+
+func (w *NetworkWatcher) Stop() {
+	close(w.stop)
+	w.factory.Shutdown()
+}
+
+func (w *NetworkWatcher) handle(obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	network, err := toNetwork(u)
+	if err != nil {
+		slog.Error("Ignoring invalid WireGuardNetwork", "error", err.Error())
+		return
+	}
+	w.onChange(network)
+}
+
+func toNetwork(obj *unstructured.Unstructured) (*v1alpha1.WireGuardNetwork, error) {
+	var network v1alpha1.WireGuardNetwork
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &network); err != nil {
+		return nil, fmt.Errorf("failed to decode WireGuardNetwork %s: %w", obj.GetName(), err)
+	}
+	return &network, nil
+}
+
+// GetNode fetches the node called name.
+func GetNode(ctx context.Context, client kubernetes.Interface, name string) (*corev1.Node, error) {
+	node, err := client.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node %s: %w", name, err)
+	}
+	return node, nil
+}
+
+// NodeLabels returns the labels of the node called name.
+func NodeLabels(ctx context.Context, client kubernetes.Interface, name string) (map[string]string, error) {
+	node, err := GetNode(ctx, client, name)
+	if err != nil {
+		return nil, err
+	}
+	return node.Labels, nil
+}
@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package kube_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/kube"
+	"github.com/kubewg-net/container/pkg/apis/kubewg/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestApplyNetworkDualStack(t *testing.T) {
+	t.Parallel()
+
+	network := &v1alpha1.WireGuardNetwork{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh"},
+		Spec: v1alpha1.WireGuardNetworkSpec{
+			TunnelCIDR:  "10.0.0.0/24",
+			TunnelCIDRs: []string{"10.0.0.0/24", "fd00:77::/64"},
+		},
+	}
+
+	wg := &config.WireGuard{Addresses: []string{"10.0.0.1/24", "fd00:77::1/64"}}
+	if err := kube.ApplyNetwork(wg, network); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"10.0.0.0/24", "fd00:77::/64"}; !slices.Equal(wg.Network.TunnelCIDRs, want) {
+		t.Errorf("expected tunnel CIDRs %v, got %v", want, wg.Network.TunnelCIDRs)
+	}
+
+	outside := &config.WireGuard{Addresses: []string{"fd00:78::1/64"}}
+	if err := kube.ApplyNetwork(outside, network); !errors.Is(err, kube.ErrAddressOutsideNetwork) {
+		t.Errorf("expected ErrAddressOutsideNetwork, got %v", err)
+	}
+}
+
+func TestApplyNetworkOneCIDRPerFamily(t *testing.T) {
+	t.Parallel()
+
+	network := &v1alpha1.WireGuardNetwork{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh"},
+		Spec: v1alpha1.WireGuardNetworkSpec{
+			TunnelCIDR:  "10.0.0.0/24",
+			TunnelCIDRs: []string{"10.1.0.0/24"},
+		},
+	}
+
+	if err := kube.ApplyNetwork(&config.WireGuard{}, network); !errors.Is(err, kube.ErrTunnelCIDRFamily) {
+		t.Errorf("expected ErrTunnelCIDRFamily, got %v", err)
+	}
+}
+
+func TestApplyNetworkListenPortRange(t *testing.T) {
+	t.Parallel()
+
+	network := &v1alpha1.WireGuardNetwork{
+		ObjectMeta: metav1.ObjectMeta{Name: "mesh"},
+		Spec: v1alpha1.WireGuardNetworkSpec{
+			TunnelCIDR:      "10.0.0.0/24",
+			ListenPortRange: &v1alpha1.PortRange{Min: 52000, Max: 52010},
+		},
+	}
+
+	// A port to be picked is picked from the network's range
+	dynamic := &config.WireGuard{ListenPortRange: config.PortRange{Min: 51820, Max: 51899}}
+	if err := kube.ApplyNetwork(dynamic, network); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if dynamic.ListenPort != 0 || dynamic.ListenPortRange != (config.PortRange{Min: 52000, Max: 52010}) {
+		t.Errorf("expected port 0 from 52000-52010, got %d from %+v", dynamic.ListenPort, dynamic.ListenPortRange)
+	}
+
+	// A fixed port outside the range is moved into it
+	fixed := &config.WireGuard{ListenPort: 51820}
+	if err := kube.ApplyNetwork(fixed, network); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fixed.ListenPort != 52000 {
+		t.Errorf("expected port 52000, got %d", fixed.ListenPort)
+	}
+}
@@ -0,0 +1,235 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/peers"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// MetadataNode names the Node a registry peer came from
+const MetadataNode = "kubewg.net/node"
+
+// NodePeer turns a Node's published annotations into a peer, reporting
+// false for a node that doesn't publish a public key. With podCIDRs the
+// node's Pod CIDRs are routed to it too, so pods reach each other through
+// the mesh.
+func NodePeer(node *corev1.Node, prefix string, podCIDRs bool) (config.WireGuardPeer, bool) {
+	publicKey := node.Annotations[prefix+AnnotationPublicKey]
+	if publicKey == "" {
+		return config.WireGuardPeer{}, false
+	}
+
+	var allowedIPs []string
+	for _, ip := range strings.Split(node.Annotations[prefix+AnnotationTunnelIPs], ",") {
+		addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+		if err != nil {
+			continue
+		}
+		allowedIPs = append(allowedIPs, netip.PrefixFrom(addr, addr.BitLen()).String())
+	}
+	if podCIDRs {
+		cidrs := node.Spec.PodCIDRs
+		if len(cidrs) == 0 && node.Spec.PodCIDR != "" {
+			cidrs = []string{node.Spec.PodCIDR}
+		}
+		allowedIPs = append(allowedIPs, parseCIDRs(strings.Join(cidrs, ","))...)
+	}
+
+	metadata := make(map[string]string, len(node.Labels)+1)
+	maps.Copy(metadata, node.Labels)
+	metadata[MetadataNode] = node.Name
+
+	return config.WireGuardPeer{
+		Name:       node.Name,
+		PublicKey:  publicKey,
+		Endpoint:   node.Annotations[prefix+AnnotationEndpoint],
+		AllowedIPs: allowedIPs,
+		Metadata:   metadata,
+	}, true
+}
+
+// VerifyFunc checks the attestation a node published for its public key
+// and returns the identity it proves.
+type VerifyFunc func(nodeName, publicKey, attestation string) (string, error)
+
+// NodePeerWatcher keeps the other nodes that publish their annotations in
+// the registry, following their keys, endpoints and Pod CIDRs as they
+// change.
+type NodePeerWatcher struct {
+	factory  informers.SharedInformerFactory
+	nodeName string
+	prefix   string
+	podCIDRs bool
+	registry *peers.Registry
+	mu       sync.Mutex
+	// known maps node names to the public key they were added with
+	known  map[string]string
+	synced cache.InformerSynced
+	lister cache.Store
+	verify VerifyFunc
+}
+
+func NewNodePeerWatcher(client kubernetes.Interface, k *config.Kubernetes, registry *peers.Registry) *NodePeerWatcher {
+	return &NodePeerWatcher{
+		factory:  informers.NewSharedInformerFactory(client, networkResync),
+		nodeName: k.NodeName,
+		prefix:   k.AnnotationPrefix,
+		podCIDRs: k.NodePeers.PodCIDRs,
+		registry: registry,
+		known:    make(map[string]string),
+	}
+}
+
+// RequireAttestation only accepts nodes whose attestation verify accepts,
+// recording the identity it returns on their peer. Call it before Start.
+func (w *NodePeerWatcher) RequireAttestation(verify VerifyFunc) {
+	w.verify = verify
+}
+
+// Start watches in the background until ctx is done or Stop is called.
+func (w *NodePeerWatcher) Start(ctx context.Context) error {
+	informer := w.factory.Core().V1().Nodes().Informer()
+	w.mu.Lock()
+	w.synced = informer.HasSynced
+	w.lister = informer.GetStore()
+	w.mu.Unlock()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: w.handle,
+		UpdateFunc: func(_, newObj interface{}) {
+			w.handle(newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if node, ok := obj.(*corev1.Node); ok {
+				w.forget(node.Name)
+			}
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch nodes: %w", err)
+	}
+
+	w.factory.Start(ctx.Done())
+	return nil
+}
+
+// Resync checks every node again, e.g. once the trust bundle changed.
+func (w *NodePeerWatcher) Resync() {
+	w.mu.Lock()
+	lister := w.lister
+	w.mu.Unlock()
+	if lister == nil {
+		return
+	}
+	for _, obj := range lister.List() {
+		w.handle(obj)
+	}
+}
+
+// Stop shuts down the informer.
+func (w *NodePeerWatcher) Stop() {
+	w.factory.Shutdown()
+}
+
+// Backing is a peers.BackingFunc for the peers that came from a Node.
+// Until the first list completes it owns none.
+func (w *NodePeerWatcher) Backing(peer config.WireGuardPeer) (bool, bool) {
+	name, ok := peer.Metadata[MetadataNode]
+	if !ok {
+		return false, false
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.synced == nil || !w.synced() {
+		return false, false
+	}
+	publicKey, ok := w.known[name]
+	return ok && publicKey == peer.PublicKey, true
+}
+
+func (w *NodePeerWatcher) handle(obj interface{}) {
+	node, ok := obj.(*corev1.Node)
+	if !ok || node.Name == w.nodeName {
+		return
+	}
+
+	// A node that stopped publishing, e.g. on shutdown, is no peer
+	peer, ok := NodePeer(node, w.prefix, w.podCIDRs)
+	if !ok {
+		w.forget(node.Name)
+		return
+	}
+	if w.verify != nil {
+		identity, err := w.verify(node.Name, peer.PublicKey, node.Annotations[w.prefix+AnnotationAttestation])
+		if err != nil {
+			slog.Warn("Not peering with unattested node", "node", node.Name, "public_key", peer.PublicKey, "error", err.Error())
+			w.forget(node.Name)
+			return
+		}
+		peer.Metadata[config.MetadataIdentity] = identity
+	}
+
+	w.mu.Lock()
+	previous, ok := w.known[node.Name]
+	w.known[node.Name] = peer.PublicKey
+	w.mu.Unlock()
+
+	// A rotated key replaces the old one instead of leaving it authorized
+	if ok && previous != peer.PublicKey {
+		w.remove(node.Name, previous)
+	}
+	if err := w.registry.Put(peer); err != nil {
+		slog.Warn("Failed to add node peer", "node", node.Name, "error", err.Error())
+	}
+}
+
+func (w *NodePeerWatcher) forget(name string) {
+	w.mu.Lock()
+	previous, ok := w.known[name]
+	delete(w.known, name)
+	w.mu.Unlock()
+
+	if ok {
+		w.remove(name, previous)
+	}
+}
+
+func (w *NodePeerWatcher) remove(name, publicKey string) {
+	if _, err := w.registry.Remove(publicKey); err != nil && !errors.Is(err, peers.ErrPeerNotFound) {
+		slog.Warn("Failed to remove node peer", "node", name, "error", err.Error())
+	}
+}
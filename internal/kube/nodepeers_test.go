@@ -0,0 +1,171 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package kube_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/kube"
+	"github.com/kubewg-net/container/internal/peers"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const nodeKey = "YWJjZGVmZ2hpamtsbW5vcHFyc3R1dnd4eXoxMjM0NTY="
+
+func annotatedNode(name, publicKey string, podCIDRs ...string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"zone": "a"},
+			Annotations: map[string]string{
+				"kubewg.net/public-key": publicKey,
+				"kubewg.net/tunnel-ips": "10.0.0.2,fd00::2",
+				"kubewg.net/endpoint":   "192.0.2.2:51820",
+			},
+		},
+		Spec: corev1.NodeSpec{PodCIDRs: podCIDRs},
+	}
+}
+
+func TestNodePeer(t *testing.T) {
+	t.Parallel()
+
+	node := annotatedNode("node-b", nodeKey, "10.244.1.0/24", "fd00:10:244:1::/64")
+	peer, ok := kube.NodePeer(node, "kubewg.net/", true)
+	if !ok {
+		t.Fatal("expected an annotated node to be a peer")
+	}
+	want := []string{"10.0.0.2/32", "fd00::2/128", "10.244.1.0/24", "fd00:10:244:1::/64"}
+	if !slices.Equal(peer.AllowedIPs, want) {
+		t.Errorf("expected the tunnel IPs and Pod CIDRs %v, got %v", want, peer.AllowedIPs)
+	}
+	if peer.Endpoint != "192.0.2.2:51820" || peer.Metadata[kube.MetadataNode] != "node-b" || peer.Metadata["zone"] != "a" {
+		t.Errorf("expected the endpoint and node metadata, got %+v", peer)
+	}
+
+	peer, _ = kube.NodePeer(node, "kubewg.net/", false)
+	if !slices.Equal(peer.AllowedIPs, []string{"10.0.0.2/32", "fd00::2/128"}) {
+		t.Errorf("expected only the tunnel IPs without pod_cidrs, got %v", peer.AllowedIPs)
+	}
+
+	if _, ok := kube.NodePeer(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "plain"}}, "kubewg.net/", true); ok {
+		t.Error("expected a node without a public key not to be a peer")
+	}
+}
+
+func TestNodePeerWatcher(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := fake.NewSimpleClientset(
+		annotatedNode("node-a", "c2VsZi1rZXktc2VsZi1rZXktc2VsZi1rZXktc2VsZjE="),
+		annotatedNode("node-b", nodeKey, "10.244.1.0/24"),
+	)
+	registry := peers.NewRegistry(nil)
+	watcher := kube.NewNodePeerWatcher(client, &config.Kubernetes{
+		NodeName:         "node-a",
+		AnnotationPrefix: "kubewg.net/",
+		NodePeers:        config.NodePeers{Enabled: true, PodCIDRs: true},
+	}, registry)
+	if err := watcher.Start(ctx); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer func() {
+		cancel()
+		watcher.Stop()
+	}()
+
+	waitFor := func(what string, fn func([]config.WireGuardPeer) bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !fn(registry.List()) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s, got %+v", what, registry.List())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor("the other node", func(list []config.WireGuardPeer) bool {
+		return len(list) == 1 && list[0].PublicKey == nodeKey && slices.Contains(list[0].AllowedIPs, "10.244.1.0/24")
+	})
+
+	if err := client.CoreV1().Nodes().Delete(ctx, "node-b", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	waitFor("the deleted node to be removed", func(list []config.WireGuardPeer) bool {
+		return len(list) == 0
+	})
+}
+
+func TestNodePeerWatcherRequiresAttestation(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	attested := annotatedNode("node-b", nodeKey)
+	attested.Annotations["kubewg.net/spiffe-attestation"] = "signed"
+	client := fake.NewSimpleClientset(attested, annotatedNode("node-c", "b3RoZXIta2V5LW90aGVyLWtleS1vdGhlci1rZXkxMjM="))
+	registry := peers.NewRegistry(nil)
+	watcher := kube.NewNodePeerWatcher(client, &config.Kubernetes{
+		NodeName:         "node-a",
+		AnnotationPrefix: "kubewg.net/",
+		NodePeers:        config.NodePeers{Enabled: true},
+	}, registry)
+	watcher.RequireAttestation(func(nodeName, _, attestation string) (string, error) {
+		if attestation != "signed" {
+			return "", errors.New("no attestation")
+		}
+		return "spiffe://example.org/" + nodeName, nil
+	})
+	if err := watcher.Start(ctx); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer func() {
+		cancel()
+		watcher.Stop()
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(registry.List()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the attested node")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Give the unattested node a chance to show up by resyncing both
+	watcher.Resync()
+	list := registry.List()
+	if len(list) != 1 || list[0].PublicKey != nodeKey {
+		t.Fatalf("expected only the attested node, got %+v", list)
+	}
+	if identity := list[0].Metadata[config.MetadataIdentity]; identity != "spiffe://example.org/node-b" {
+		t.Errorf("expected the SPIFFE ID as identity, got %q", identity)
+	}
+}
@@ -0,0 +1,244 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"sync"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/peers"
+	"github.com/kubewg-net/container/pkg/apis/kubewg/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// MetadataPeerResource names the WireGuardPeer a registry peer came from
+const MetadataPeerResource = "kubewg.net/wireguardpeer"
+
+//nolint:golint,gochecknoglobals
+var WireGuardPeers = v1alpha1.SchemeGroupVersion.WithResource("wireguardpeers")
+
+type watchedPeer struct {
+	publicKey string
+	uid       types.UID
+}
+
+// PeerWatcher keeps the WireGuardPeers of the network this node is a
+// gateway for in the registry.
+type PeerWatcher struct {
+	factory  dynamicinformer.DynamicSharedInformerFactory
+	wg       *config.WireGuard
+	registry *peers.Registry
+	mu       sync.Mutex
+	known    map[string]watchedPeer
+	synced   cache.InformerSynced
+	stop     chan struct{}
+}
+
+func NewPeerWatcher(client dynamic.Interface, wg *config.WireGuard, registry *peers.Registry) *PeerWatcher {
+	return &PeerWatcher{
+		factory:  dynamicinformer.NewDynamicSharedInformerFactory(client, networkResync),
+		wg:       wg,
+		registry: registry,
+		known:    make(map[string]watchedPeer),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start watches in the background until Stop is called or ctx is done.
+func (w *PeerWatcher) Start(ctx context.Context) error {
+	informer := w.factory.ForResource(WireGuardPeers).Informer()
+	w.mu.Lock()
+	w.synced = informer.HasSynced
+	w.mu.Unlock()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: w.handle,
+		UpdateFunc: func(_, newObj interface{}) {
+			w.handle(newObj)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if u, ok := obj.(*unstructured.Unstructured); ok {
+				w.forget(u.GetName())
+			}
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch WireGuardPeers: %w", err)
+	}
+
+	stop := make(chan struct{})
+	go func() {
+		defer close(stop)
+		select {
+		case <-ctx.Done():
+		case <-w.stop:
+		}
+	}()
+	w.factory.Start(stop)
+	return nil
+}
+
+func (w *PeerWatcher) Stop() {
+	close(w.stop)
+	w.factory.Shutdown()
+}
+
+// Reference returns the WireGuardPeer a public key came from, so events
+// about the peer can be attached to it.
+func (w *PeerWatcher) Reference(publicKey string) (*corev1.ObjectReference, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for name, known := range w.known {
+		if known.publicKey == publicKey {
+			return &corev1.ObjectReference{
+				APIVersion: v1alpha1.SchemeGroupVersion.String(),
+				Kind:       "WireGuardPeer",
+				Name:       name,
+				UID:        known.uid,
+			}, true
+		}
+	}
+	return nil, false
+}
+
+// Names maps the WireGuardPeers this node configures to their public keys.
+func (w *PeerWatcher) Names() map[string]string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	names := make(map[string]string, len(w.known))
+	for name, known := range w.known {
+		names[name] = known.publicKey
+	}
+	return names
+}
+
+// Backing is a peers.BackingFunc for the peers that came from a
+// WireGuardPeer. Until the first list completes it owns none, so a slow
+// start doesn't count against them.
+func (w *PeerWatcher) Backing(peer config.WireGuardPeer) (bool, bool) {
+	name, ok := peer.Metadata[MetadataPeerResource]
+	if !ok {
+		return false, false
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.synced == nil || !w.synced() {
+		return false, false
+	}
+	known, ok := w.known[name]
+	return ok && known.publicKey == peer.PublicKey, true
+}
+
+func (w *PeerWatcher) handle(obj interface{}) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+
+	var resource v1alpha1.WireGuardPeer
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &resource); err != nil {
+		slog.Error("Ignoring invalid WireGuardPeer", "name", u.GetName(), "error", err.Error())
+		return
+	}
+
+	gateway, err := w.isGateway(&resource)
+	if err != nil {
+		slog.Error("Ignoring WireGuardPeer with an invalid gateway selector", "name", resource.Name, "error", err.Error())
+		return
+	}
+	if resource.Spec.Network != w.wg.Network.Name || !gateway {
+		w.forget(resource.Name)
+		return
+	}
+
+	metadata := make(map[string]string, len(resource.Labels)+1)
+	maps.Copy(metadata, resource.Labels)
+	metadata[MetadataPeerResource] = resource.Name
+
+	peer := config.WireGuardPeer{
+		Name:                resource.Name,
+		PublicKey:           resource.Spec.PublicKey,
+		Endpoint:            resource.Spec.Endpoint,
+		AllowedIPs:          resource.Spec.AllowedIPs,
+		ExcludedIPs:         resource.Spec.ExcludedIPs,
+		PersistentKeepalive: resource.Spec.PersistentKeepalive,
+		Metadata:            metadata,
+	}
+
+	w.mu.Lock()
+	previous, ok := w.known[resource.Name]
+	w.known[resource.Name] = watchedPeer{publicKey: peer.PublicKey, uid: resource.UID}
+	w.mu.Unlock()
+
+	// A new key replaces the old one instead of leaving it authorized
+	if ok && previous.publicKey != peer.PublicKey {
+		w.remove(resource.Name, previous.publicKey)
+	}
+	if err := w.registry.Put(peer); err != nil {
+		slog.Warn("Failed to add WireGuardPeer", "name", resource.Name, "error", err.Error())
+	}
+}
+
+// isGateway reports whether this node should configure the peer.
+func (w *PeerWatcher) isGateway(resource *v1alpha1.WireGuardPeer) (bool, error) {
+	if resource.Spec.GatewaySelector == nil {
+		return w.wg.IsHub(), nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(resource.Spec.GatewaySelector)
+	if err != nil {
+		return false, fmt.Errorf("invalid gateway selector: %w", err)
+	}
+	return selector.Matches(labels.Set(w.wg.Labels)), nil
+}
+
+func (w *PeerWatcher) forget(name string) {
+	w.mu.Lock()
+	previous, ok := w.known[name]
+	delete(w.known, name)
+	w.mu.Unlock()
+
+	if ok {
+		w.remove(name, previous.publicKey)
+	}
+}
+
+func (w *PeerWatcher) remove(name, publicKey string) {
+	if _, err := w.registry.Remove(publicKey); err != nil && !errors.Is(err, peers.ErrPeerNotFound) {
+		slog.Warn("Failed to remove WireGuardPeer", "name", name, "error", err.Error())
+	}
+}
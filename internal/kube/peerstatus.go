@@ -0,0 +1,251 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package kube
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/kubewg-net/container/pkg/apis/kubewg/v1alpha1"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// handshakeTimeout matches WireGuard's reject-after-time, after which a
+	// session without a new handshake is dead
+	handshakeTimeout = 180 * time.Second
+
+	// staleGateways is how many intervals a gateway's entry is kept after
+	// it stopped updating it
+	staleGateways = 3
+)
+
+// PeerStatusWriter writes what this node sees of the WireGuardPeers it is
+// a gateway for to their status subresource. Every gateway keeps its own
+// entry under gateways, and the conditions and totals are recomputed from
+// all of them, so peers with several gateways don't flap between their
+// views.
+type PeerStatusWriter struct {
+	client   dynamic.Interface
+	nodeName string
+	interval time.Duration
+	names    func() map[string]string
+	device   func() ([]wgtypes.Peer, error)
+}
+
+// NewPeerStatusWriter writes the status of the WireGuardPeers names returns,
+// from the peers device reports.
+func NewPeerStatusWriter(client dynamic.Interface, nodeName string, interval time.Duration, names func() map[string]string, device func() ([]wgtypes.Peer, error)) *PeerStatusWriter {
+	return &PeerStatusWriter{
+		client:   client,
+		nodeName: nodeName,
+		interval: interval,
+		names:    names,
+		device:   device,
+	}
+}
+
+// Start writes every interval until ctx is done.
+func (w *PeerStatusWriter) Start(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := w.Sync(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("Failed to write WireGuardPeer status", "error", err.Error())
+		}
+	}
+}
+
+// Sync writes the status of every WireGuardPeer once.
+func (w *PeerStatusWriter) Sync(ctx context.Context) error {
+	live, err := w.device()
+	if err != nil {
+		return fmt.Errorf("failed to read interface peers: %w", err)
+	}
+	byKey := make(map[string]*wgtypes.Peer, len(live))
+	for i := range live {
+		byKey[live[i].PublicKey.String()] = &live[i]
+	}
+
+	now := time.Now()
+	for name, publicKey := range w.names() {
+		if err := w.write(ctx, name, byKey[publicKey], now); err != nil {
+			slog.Warn("Failed to write WireGuardPeer status", "name", name, "error", err.Error())
+		}
+	}
+	return nil
+}
+
+// Remove drops this node's entry from the status of every WireGuardPeer,
+// for a node that is shutting down. It must not run concurrently with
+// Start.
+func (w *PeerStatusWriter) Remove(ctx context.Context) error {
+	var errs []error
+	now := time.Now()
+	for name := range w.names() {
+		if err := w.write(ctx, name, nil, now); err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("WireGuardPeer %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (w *PeerStatusWriter) write(ctx context.Context, name string, peer *wgtypes.Peer, now time.Time) error {
+	resources := w.client.Resource(WireGuardPeers)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		obj, err := resources.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		var resource v1alpha1.WireGuardPeer
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &resource); err != nil {
+			return fmt.Errorf("invalid WireGuardPeer: %w", err)
+		}
+
+		var entry *v1alpha1.WireGuardPeerGatewayStatus
+		if peer != nil {
+			entry = gatewayStatus(w.nodeName, peer, now)
+		}
+		UpdatePeerStatus(&resource, w.nodeName, entry, now, staleGateways*w.interval)
+
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&resource)
+		if err != nil {
+			return fmt.Errorf("failed to convert WireGuardPeer: %w", err)
+		}
+		_, err = resources.UpdateStatus(ctx, &unstructured.Unstructured{Object: content}, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func gatewayStatus(nodeName string, peer *wgtypes.Peer, now time.Time) *v1alpha1.WireGuardPeerGatewayStatus {
+	entry := &v1alpha1.WireGuardPeerGatewayStatus{
+		Node:          nodeName,
+		ReceiveBytes:  peer.ReceiveBytes,
+		TransmitBytes: peer.TransmitBytes,
+		UpdateTime:    metav1.NewTime(now),
+	}
+	if peer.Endpoint != nil {
+		entry.Endpoint = peer.Endpoint.String()
+	}
+	if !peer.LastHandshakeTime.IsZero() {
+		handshake := metav1.NewTime(peer.LastHandshakeTime)
+		entry.LastHandshakeTime = &handshake
+	}
+	return entry
+}
+
+// UpdatePeerStatus replaces the entry of nodeName in the status of resource
+// with entry, or removes it when entry is nil because the node doesn't
+// have the peer configured. Entries no gateway updated within staleAfter
+// are dropped, then the totals and conditions are recomputed.
+func UpdatePeerStatus(resource *v1alpha1.WireGuardPeer, nodeName string, entry *v1alpha1.WireGuardPeerGatewayStatus, now time.Time, staleAfter time.Duration) {
+	status := &resource.Status
+	status.ObservedGeneration = resource.Generation
+	status.Gateways = slices.DeleteFunc(status.Gateways, func(gateway v1alpha1.WireGuardPeerGatewayStatus) bool {
+		return gateway.Node == nodeName || now.Sub(gateway.UpdateTime.Time) > staleAfter
+	})
+	if entry != nil {
+		status.Gateways = append(status.Gateways, *entry)
+	}
+	slices.SortFunc(status.Gateways, func(a, b v1alpha1.WireGuardPeerGatewayStatus) int {
+		return cmp.Compare(a.Node, b.Node)
+	})
+
+	status.Endpoint = ""
+	status.LastHandshakeTime = nil
+	status.ReceiveBytes = 0
+	status.TransmitBytes = 0
+	var latest *v1alpha1.WireGuardPeerGatewayStatus
+	endpoint := ""
+	for i := range status.Gateways {
+		gateway := &status.Gateways[i]
+		status.ReceiveBytes += gateway.ReceiveBytes
+		status.TransmitBytes += gateway.TransmitBytes
+		if endpoint == "" {
+			endpoint = gateway.Endpoint
+		}
+		if gateway.LastHandshakeTime != nil && (latest == nil || gateway.LastHandshakeTime.After(latest.LastHandshakeTime.Time)) {
+			latest = gateway
+		}
+	}
+	if latest != nil {
+		status.LastHandshakeTime = latest.LastHandshakeTime.DeepCopy()
+		endpoint = latest.Endpoint
+	}
+	status.Endpoint = endpoint
+
+	recent := metav1.Condition{Type: v1alpha1.PeerHandshakeRecent, Status: metav1.ConditionFalse}
+	switch {
+	case latest == nil:
+		recent.Reason = "NeverHandshaked"
+		recent.Message = "no gateway has completed a handshake with the peer"
+	case now.Sub(latest.LastHandshakeTime.Time) > handshakeTimeout:
+		recent.Reason = "HandshakeStale"
+		recent.Message = fmt.Sprintf("no handshake for over %s, last through %s", handshakeTimeout, latest.Node)
+	default:
+		recent.Status = metav1.ConditionTrue
+		recent.Reason = "HandshakeRecent"
+		recent.Message = "last handshake through " + latest.Node
+	}
+
+	resolved := metav1.Condition{Type: v1alpha1.PeerEndpointResolved, Status: metav1.ConditionTrue, Reason: "EndpointKnown", Message: endpoint}
+	if endpoint == "" {
+		resolved.Status = metav1.ConditionFalse
+		resolved.Reason = "EndpointUnknown"
+		resolved.Message = "no gateway knows the endpoint yet, the peer has to dial in"
+	}
+
+	ready := metav1.Condition{Type: v1alpha1.PeerReady, Status: metav1.ConditionFalse}
+	switch {
+	case len(status.Gateways) == 0:
+		ready.Reason = "NotConfigured"
+		ready.Message = "no gateway has the peer configured"
+	case recent.Status != metav1.ConditionTrue:
+		ready.Reason = recent.Reason
+		ready.Message = recent.Message
+	default:
+		ready.Status = metav1.ConditionTrue
+		ready.Reason = "Connected"
+		ready.Message = "connected through " + latest.Node
+	}
+
+	for _, condition := range []metav1.Condition{ready, recent, resolved} {
+		condition.ObservedGeneration = resource.Generation
+		meta.SetStatusCondition(&status.Conditions, condition)
+	}
+}
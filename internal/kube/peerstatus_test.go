@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package kube_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/kube"
+	"github.com/kubewg-net/container/pkg/apis/kubewg/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestUpdatePeerStatus(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	handshake := metav1.NewTime(now.Add(-time.Minute))
+	resource := &v1alpha1.WireGuardPeer{ObjectMeta: metav1.ObjectMeta{Name: "laptop", Generation: 2}}
+	resource.Status.Gateways = []v1alpha1.WireGuardPeerGatewayStatus{
+		// Another gateway that is still reporting
+		{Node: "node-b", ReceiveBytes: 10, TransmitBytes: 20, UpdateTime: metav1.NewTime(now.Add(-time.Minute))},
+		// A gateway that went away
+		{Node: "node-c", ReceiveBytes: 1000, UpdateTime: metav1.NewTime(now.Add(-time.Hour))},
+	}
+
+	kube.UpdatePeerStatus(resource, "node-a", &v1alpha1.WireGuardPeerGatewayStatus{
+		Node:              "node-a",
+		Endpoint:          "203.0.113.7:51820",
+		LastHandshakeTime: &handshake,
+		ReceiveBytes:      100,
+		TransmitBytes:     200,
+		UpdateTime:        metav1.NewTime(now),
+	}, now, 90*time.Second)
+
+	status := &resource.Status
+	if len(status.Gateways) != 2 || status.Gateways[0].Node != "node-a" || status.Gateways[1].Node != "node-b" {
+		t.Fatalf("expected node-a and node-b, got %+v", status.Gateways)
+	}
+	if status.ReceiveBytes != 110 || status.TransmitBytes != 220 {
+		t.Errorf("expected 110 and 220 bytes, got %d and %d", status.ReceiveBytes, status.TransmitBytes)
+	}
+	if status.Endpoint != "203.0.113.7:51820" || status.LastHandshakeTime == nil {
+		t.Errorf("expected the endpoint and handshake of node-a, got %q and %v", status.Endpoint, status.LastHandshakeTime)
+	}
+	if status.ObservedGeneration != 2 {
+		t.Errorf("expected observed generation 2, got %d", status.ObservedGeneration)
+	}
+	for _, condition := range []string{v1alpha1.PeerReady, v1alpha1.PeerHandshakeRecent, v1alpha1.PeerEndpointResolved} {
+		if !meta.IsStatusConditionTrue(status.Conditions, condition) {
+			t.Errorf("expected %s to be true, got %+v", condition, meta.FindStatusCondition(status.Conditions, condition))
+		}
+	}
+
+	// The handshake goes stale
+	later := now.Add(5 * time.Minute)
+	entry := status.Gateways[0]
+	entry.UpdateTime = metav1.NewTime(later)
+	kube.UpdatePeerStatus(resource, "node-a", &entry, later, 90*time.Second)
+	ready := meta.FindStatusCondition(status.Conditions, v1alpha1.PeerReady)
+	if ready == nil || ready.Status != metav1.ConditionFalse || ready.Reason != "HandshakeStale" {
+		t.Errorf("expected Ready to be false with a stale handshake, got %+v", ready)
+	}
+
+	// node-a no longer has the peer configured
+	kube.UpdatePeerStatus(resource, "node-a", nil, later, 90*time.Second)
+	if len(status.Gateways) != 0 {
+		t.Fatalf("expected no gateways, got %+v", status.Gateways)
+	}
+	ready = meta.FindStatusCondition(status.Conditions, v1alpha1.PeerReady)
+	if ready == nil || ready.Reason != "NotConfigured" {
+		t.Errorf("expected Ready to be NotConfigured, got %+v", ready)
+	}
+	if meta.IsStatusConditionTrue(status.Conditions, v1alpha1.PeerEndpointResolved) {
+		t.Errorf("expected the endpoint to be unresolved without gateways")
+	}
+}
@@ -0,0 +1,271 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package kube
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/health"
+	"github.com/kubewg-net/container/internal/state"
+	"github.com/kubewg-net/container/pkg/apis/kubewg/v1alpha1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// ConditionPermissions is the health condition of the RBAC self-check
+	ConditionPermissions = "KubernetesPermissions"
+
+	permissionInterval = 5 * time.Minute
+)
+
+// Permission is a verb on a resource that a configured feature needs. An
+// empty Namespace means cluster-wide.
+type Permission struct {
+	Verb     string `json:"verb"`
+	Group    string `json:"group,omitempty"`
+	Resource string `json:"resource"`
+	// Subresource such as "status"
+	Subresource string `json:"subresource,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	// Feature is the config option that needs it
+	Feature string `json:"feature"`
+}
+
+func (p *Permission) String() string {
+	resource := p.Resource
+	if p.Group != "" {
+		resource += "." + p.Group
+	}
+	if p.Subresource != "" {
+		resource += "/" + p.Subresource
+	}
+	if p.Namespace != "" {
+		return fmt.Sprintf("%s %s in %s", p.Verb, resource, p.Namespace)
+	}
+	return p.Verb + " " + resource
+}
+
+// PermissionResult is the outcome of checking one Permission.
+type PermissionResult struct {
+	Permission
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// RequiredPermissions lists what the Kubernetes integrations enabled in c
+// do with the API server, one entry per verb and resource, where resource
+// may name a subresource as in "pods/status". Features that need the same
+// permission share its entry.
+func RequiredPermissions(c *config.Config) []Permission {
+	var permissions []Permission
+	add := func(feature, group, resource, namespace string, verbs ...string) {
+		resource, subresource, _ := strings.Cut(resource, "/")
+	next:
+		for _, verb := range verbs {
+			for i := range permissions {
+				p := &permissions[i]
+				if p.Verb == verb && p.Group == group && p.Resource == resource && p.Subresource == subresource && p.Namespace == namespace {
+					p.Feature += ", " + feature
+					continue next
+				}
+			}
+			permissions = append(permissions, Permission{
+				Verb:        verb,
+				Group:       group,
+				Resource:    resource,
+				Subresource: subresource,
+				Namespace:   namespace,
+				Feature:     feature,
+			})
+		}
+	}
+
+	k := &c.Kubernetes
+	if k.Events {
+		add("kubernetes.events", "", "events", "", "create", "patch")
+	}
+	if k.Network != "" {
+		add("kubernetes.network", v1alpha1.SchemeGroupVersion.Group, WireGuardNetworks.Resource, "", "get", "list", "watch")
+	}
+	if k.Peers {
+		add("kubernetes.peers", v1alpha1.SchemeGroupVersion.Group, WireGuardPeers.Resource, "", "list", "watch")
+	}
+	if k.PeerStatus.Enabled {
+		add("kubernetes.peer_status", v1alpha1.SchemeGroupVersion.Group, WireGuardPeers.Resource, "", "get")
+		add("kubernetes.peer_status", v1alpha1.SchemeGroupVersion.Group, WireGuardPeers.Resource+"/status", "", "update")
+	}
+	if k.Annotate {
+		add("kubernetes.annotate", "", "nodes", "", "get", "patch")
+	}
+	if k.NodePeers.Enabled {
+		add("kubernetes.node_peers", "", "nodes", "", "list", "watch")
+	}
+	if k.NodeFinalizer {
+		add("kubernetes.node_finalizer", "", "nodes", "", "get", "patch")
+	}
+	if namespace, _, ok := k.EndpointServiceRef(); ok {
+		add("kubernetes.endpoint_service", "", "services", namespace, "get", "list", "watch")
+		add("kubernetes.endpoint_service", "", "nodes", "", "get")
+	}
+	for _, namespace := range ServiceEndpointNamespaces(c) {
+		add("wireguard.peers.endpoint", "", "services", namespace, "get", "list", "watch")
+	}
+	if c.NeedsNodeLabels() {
+		add("node_overrides", "", "nodes", "", "get")
+	}
+	if c.NeedsNodeAddresses() {
+		add("wireguard.endpoint_detection", "", "nodes", "", "get")
+	}
+	if c.KeyStore.Type == config.KeyStoreSecret {
+		add("keystore", "", "secrets", c.KeyStore.Secret.Namespace, "get", "create", "update")
+	}
+	if k.PresharedKeys.Enabled && !c.KeyStore.Shared() {
+		add("kubernetes.preshared_keys", "", "secrets", k.PresharedKeys.Namespace, "get", "create", "update")
+	}
+	if c.WireGuard.Enabled && c.State.Type == config.StateKubernetes {
+		add("state", "", "secrets", c.State.Namespace, "get", "create", "update")
+	}
+	if c.WireGuard.Enabled && c.State.Type == config.StateCRD {
+		add("state", v1alpha1.SchemeGroupVersion.Group, state.WireGuardStates.Resource, "", "get", "create", "update")
+	}
+	if c.WireGuard.ClusterCIDRs.Discover {
+		// The kubeadm ConfigMap and control plane pods are read when
+		// allowed, the nodes are the fallback that has to work
+		add("wireguard.cluster_cidrs.discover", "", "nodes", "", "list")
+	}
+	if c.API.Auth.TokenReview.Enabled {
+		add("api.auth.token_review", "authentication.k8s.io", "tokenreviews", "", "create")
+	}
+	return permissions
+}
+
+// CheckPermissions asks the API server whether this client may do each of
+// permissions with SelfSubjectAccessReviews, which every authenticated
+// client is allowed to create.
+func CheckPermissions(ctx context.Context, client kubernetes.Interface, permissions []Permission) ([]PermissionResult, error) {
+	results := make([]PermissionResult, 0, len(permissions))
+	for _, permission := range permissions {
+		review, err := client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   permission.Namespace,
+					Verb:        permission.Verb,
+					Group:       permission.Group,
+					Resource:    permission.Resource,
+					Subresource: permission.Subresource,
+				},
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return results, fmt.Errorf("failed to review %s: %w", permission.String(), err)
+		}
+		results = append(results, PermissionResult{
+			Permission: permission,
+			Allowed:    review.Status.Allowed,
+			Reason:     review.Status.Reason,
+		})
+	}
+	return results, nil
+}
+
+// PermissionChecker reviews the permissions the enabled features need at
+// startup and then periodically, so a missing RBAC rule shows up as a
+// failed health condition naming it instead of as a 403 deep inside a
+// controller. Fixing the rule clears the condition on the next check.
+type PermissionChecker struct {
+	client      kubernetes.Interface
+	permissions []Permission
+	status      *health.Status
+	mu          sync.RWMutex
+	results     []PermissionResult
+}
+
+func NewPermissionChecker(client kubernetes.Interface, permissions []Permission, status *health.Status) *PermissionChecker {
+	return &PermissionChecker{
+		client:      client,
+		permissions: permissions,
+		status:      status,
+	}
+}
+
+// Check reviews every permission once and updates the health condition.
+// A failed review leaves the condition as it was.
+func (c *PermissionChecker) Check(ctx context.Context) error {
+	results, err := CheckPermissions(ctx, c.client, c.permissions)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.results = results
+	c.mu.Unlock()
+
+	var missing []string
+	for i := range results {
+		if !results[i].Allowed {
+			missing = append(missing, fmt.Sprintf("%s (%s)", results[i].Permission.String(), results[i].Feature))
+		}
+	}
+	condition := health.Condition{Type: ConditionPermissions, OK: len(missing) == 0}
+	if !condition.OK {
+		condition.Reason = "Forbidden"
+		condition.Message = "missing " + strings.Join(missing, ", ")
+	}
+	if c.status.Set(condition) {
+		if condition.OK {
+			slog.Info("Kubernetes RBAC grants every permission needed", "permissions", len(results))
+		} else {
+			slog.Error("Kubernetes RBAC is missing permissions, the features needing them will fail", "missing", missing)
+		}
+	}
+	return nil
+}
+
+// Start re-checks until ctx is done. Check should be called once before.
+func (c *PermissionChecker) Start(ctx context.Context) {
+	ticker := time.NewTicker(permissionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := c.Check(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("Failed to check Kubernetes permissions", "error", err.Error())
+		}
+	}
+}
+
+// Results returns the outcome of the last check.
+func (c *PermissionChecker) Results() []PermissionResult {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.results
+}
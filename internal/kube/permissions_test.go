@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package kube_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/health"
+	"github.com/kubewg-net/container/internal/kube"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRequiredPermissions(t *testing.T) {
+	t.Parallel()
+
+	c := &config.Config{}
+	c.Kubernetes.Annotate = true
+	c.Kubernetes.EndpointService = "kube-system/kubewg"
+	c.API.Auth.TokenReview.Enabled = true
+
+	var nodes []kube.Permission
+	var tokenReviews, services int
+	for _, permission := range kube.RequiredPermissions(c) {
+		switch permission.Resource {
+		case "nodes":
+			nodes = append(nodes, permission)
+		case "tokenreviews":
+			tokenReviews++
+		case "services":
+			services++
+			if permission.Namespace != "kube-system" {
+				t.Errorf("expected services to be checked in kube-system, got %q", permission.Namespace)
+			}
+		}
+	}
+	// get nodes is needed by both features but only checked once
+	if len(nodes) != 2 {
+		t.Fatalf("expected get and patch on nodes, got %+v", nodes)
+	}
+	if nodes[0].Verb != "get" || nodes[0].Feature != "kubernetes.annotate, kubernetes.endpoint_service" {
+		t.Errorf("expected get nodes for both features, got %+v", nodes[0])
+	}
+	if tokenReviews != 1 || services != 3 {
+		t.Errorf("expected 1 token review and 3 service permissions, got %d and %d", tokenReviews, services)
+	}
+}
+
+func TestCheckPermissions(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		create, ok := action.(k8stesting.CreateAction)
+		if !ok {
+			t.Fatalf("expected a create action, got %T", action)
+		}
+		review, ok := create.GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		if !ok {
+			t.Fatalf("expected a SelfSubjectAccessReview, got %T", create.GetObject())
+		}
+		review.Status.Allowed = review.Spec.ResourceAttributes.Verb == "get"
+		if !review.Status.Allowed {
+			review.Status.Reason = "no RBAC policy matched"
+		}
+		return true, review, nil
+	})
+
+	permissions := []kube.Permission{
+		{Verb: "get", Resource: "nodes"},
+		{Verb: "patch", Resource: "nodes"},
+	}
+	results, err := kube.CheckPermissions(context.Background(), client, permissions)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(results) != 2 || !results[0].Allowed || results[1].Allowed {
+		t.Fatalf("expected only get to be allowed, got %+v", results)
+	}
+	if results[1].Reason != "no RBAC policy matched" {
+		t.Errorf("expected the denial reason, got %q", results[1].Reason)
+	}
+}
+
+func TestPermissionChecker(t *testing.T) {
+	t.Parallel()
+
+	var allowed atomic.Bool
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		create, ok := action.(k8stesting.CreateAction)
+		if !ok {
+			t.Fatalf("expected a create action, got %T", action)
+		}
+		review, ok := create.GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		if !ok {
+			t.Fatalf("expected a SelfSubjectAccessReview, got %T", create.GetObject())
+		}
+		review.Status.Allowed = allowed.Load()
+		return true, review, nil
+	})
+
+	status := health.NewStatus()
+	permissions := []kube.Permission{{Verb: "patch", Resource: "nodes", Feature: "kubernetes.annotate"}}
+	checker := kube.NewPermissionChecker(client, permissions, status)
+	if err := checker.Check(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	conditions := status.Conditions()
+	if len(conditions) != 1 || conditions[0].OK {
+		t.Fatalf("expected a failed %s condition, got %+v", kube.ConditionPermissions, conditions)
+	}
+	if conditions[0].Message != "missing patch nodes (kubernetes.annotate)" {
+		t.Errorf("expected the missing permission to be named, got %q", conditions[0].Message)
+	}
+
+	allowed.Store(true)
+	if err := checker.Check(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status.Degraded() {
+		t.Errorf("expected granting the permission to clear the condition, got %+v", status.Conditions())
+	}
+	if results := checker.Results(); len(results) != 1 || !results[0].Allowed {
+		t.Errorf("expected the last results to be kept, got %+v", results)
+	}
+}
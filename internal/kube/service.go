@@ -0,0 +1,218 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+var (
+	ErrServiceType      = errors.New("only LoadBalancer and NodePort services can advertise the endpoint")
+	ErrServiceNoPort    = errors.New("service has no UDP port")
+	ErrServicePending   = errors.New("load balancer has no ingress yet")
+	ErrNodeNoAddress    = errors.New("node has no external or internal IP")
+	ErrNodePortNodeName = errors.New("advertising a NodePort service requires the node name")
+)
+
+// ServiceEndpoint extracts the endpoint peers reach this node at from the
+// Service in front of it: the load balancer's ingress and the service port,
+// or for a NodePort service the node's external IP, falling back to its
+// internal IP, and the node port. node may be nil for LoadBalancer services.
+func ServiceEndpoint(service *corev1.Service, node *corev1.Node) (string, error) {
+	var port *corev1.ServicePort
+	for i := range service.Spec.Ports {
+		if service.Spec.Ports[i].Protocol == corev1.ProtocolUDP {
+			port = &service.Spec.Ports[i]
+			break
+		}
+	}
+	if port == nil {
+		return "", fmt.Errorf("%w: %s/%s", ErrServiceNoPort, service.Namespace, service.Name)
+	}
+
+	switch service.Spec.Type {
+	case corev1.ServiceTypeLoadBalancer:
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			host := ingress.IP
+			if host == "" {
+				host = ingress.Hostname
+			}
+			if host != "" {
+				return net.JoinHostPort(host, strconv.Itoa(int(port.Port))), nil
+			}
+		}
+		return "", fmt.Errorf("%w: %s/%s", ErrServicePending, service.Namespace, service.Name)
+	case corev1.ServiceTypeNodePort:
+		if node == nil {
+			return "", ErrNodePortNodeName
+		}
+		address := NodeAddress(node, corev1.NodeExternalIP)
+		if address == "" {
+			address = NodeAddress(node, corev1.NodeInternalIP)
+		}
+		if address == "" {
+			return "", fmt.Errorf("%w: %s", ErrNodeNoAddress, node.Name)
+		}
+		return net.JoinHostPort(address, strconv.Itoa(int(port.NodePort))), nil
+	default:
+		return "", fmt.Errorf("%w: %s/%s is %s", ErrServiceType, service.Namespace, service.Name, service.Spec.Type)
+	}
+}
+
+// NodeAddress returns the node's first address of addressType, empty when
+// it has none.
+func NodeAddress(node *corev1.Node, addressType corev1.NodeAddressType) string {
+	for _, address := range node.Status.Addresses {
+		if address.Type == addressType {
+			return address.Address
+		}
+	}
+	return ""
+}
+
+// ServiceWatcher follows the endpoint advertised by a Service, e.g. when the
+// cloud provider assigns or replaces the load balancer's address.
+type ServiceWatcher struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+	nodeName  string
+	factory   informers.SharedInformerFactory
+	mu        sync.Mutex
+	endpoint  string
+	listeners []func(endpoint string)
+}
+
+// NewServiceWatcher watches the Service called name in namespace.
+func NewServiceWatcher(client kubernetes.Interface, namespace, name, nodeName string) *ServiceWatcher {
+	factory := informers.NewSharedInformerFactoryWithOptions(client, networkResync,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
+
+	return &ServiceWatcher{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+		nodeName:  nodeName,
+		factory:   factory,
+	}
+}
+
+// OnChange registers fn to be called with every new endpoint. Unlike the
+// other watchers it may be called after Start, so consumers created once the
+// first endpoint is known can still follow it.
+func (w *ServiceWatcher) OnChange(fn func(endpoint string)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.listeners = append(w.listeners, fn)
+}
+
+// Start watches in the background until ctx is done, after the first
+// endpoint, if the Service has one yet, is known.
+func (w *ServiceWatcher) Start(ctx context.Context) error {
+	informer := w.factory.Core().V1().Services().Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			w.handle(ctx, obj)
+		},
+		UpdateFunc: func(_, newObj interface{}) {
+			w.handle(ctx, newObj)
+		},
+		DeleteFunc: func(_ interface{}) {
+			slog.Warn("Endpoint service was deleted, keeping the last known endpoint", "service", w.namespace+"/"+w.name)
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch service %s/%s: %w", w.namespace, w.name, err)
+	}
+
+	w.factory.Start(ctx.Done())
+	for informerType, synced := range w.factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return fmt.Errorf("failed to sync %v informer for service %s/%s", informerType, w.namespace, w.name)
+		}
+	}
+	return nil
+}
+
+// Stop shuts down the informer.
+func (w *ServiceWatcher) Stop() {
+	w.factory.Shutdown()
+}
+
+// Endpoint returns the last endpoint the Service advertised, or "" before
+// it had one.
+func (w *ServiceWatcher) Endpoint() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.endpoint
+}
+
+func (w *ServiceWatcher) handle(ctx context.Context, obj interface{}) {
+	service, ok := obj.(*corev1.Service)
+	if !ok {
+		return
+	}
+
+	var node *corev1.Node
+	if service.Spec.Type == corev1.ServiceTypeNodePort && w.nodeName != "" {
+		var err error
+		node, err = w.client.CoreV1().Nodes().Get(ctx, w.nodeName, metav1.GetOptions{})
+		if err != nil {
+			slog.Error("Failed to get node for the NodePort endpoint", "node", w.nodeName, "error", err.Error())
+			return
+		}
+	}
+
+	endpoint, err := ServiceEndpoint(service, node)
+	if err != nil {
+		slog.Warn("Service does not advertise an endpoint", "error", err.Error())
+		return
+	}
+
+	w.mu.Lock()
+	changed := endpoint != w.endpoint
+	w.endpoint = endpoint
+	listeners := w.listeners
+	w.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	slog.Info("Advertised endpoint changed", "service", w.namespace+"/"+w.name, "endpoint", endpoint)
+	for _, fn := range listeners {
+		fn(endpoint)
+	}
+}
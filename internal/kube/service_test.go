@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package kube_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/kube"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestServiceEndpoint(t *testing.T) {
+	t.Parallel()
+
+	ports := []corev1.ServicePort{
+		{Name: "metrics", Protocol: corev1.ProtocolTCP, Port: 8081, NodePort: 30081},
+		{Name: "wireguard", Protocol: corev1.ProtocolUDP, Port: 51820, NodePort: 31820},
+	}
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+		Status: corev1.NodeStatus{
+			Addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeInternalIP, Address: "10.1.0.5"},
+				{Type: corev1.NodeExternalIP, Address: "203.0.113.5"},
+			},
+		},
+	}
+	internalOnly := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-b"},
+		Status: corev1.NodeStatus{
+			Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.1.0.6"}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		service  corev1.Service
+		node     *corev1.Node
+		expected string
+		err      error
+	}{
+		{
+			name: "load balancer ip",
+			service: corev1.Service{
+				Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, Ports: ports},
+				Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{
+					Ingress: []corev1.LoadBalancerIngress{{IP: "198.51.100.7"}},
+				}},
+			},
+			expected: "198.51.100.7:51820",
+		},
+		{
+			name: "load balancer hostname",
+			service: corev1.Service{
+				Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, Ports: ports},
+				Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{
+					Ingress: []corev1.LoadBalancerIngress{{Hostname: "lb.example.com"}},
+				}},
+			},
+			expected: "lb.example.com:51820",
+		},
+		{
+			name:    "load balancer pending",
+			service: corev1.Service{Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, Ports: ports}},
+			err:     kube.ErrServicePending,
+		},
+		{
+			name:     "node port prefers the external ip",
+			service:  corev1.Service{Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeNodePort, Ports: ports}},
+			node:     node,
+			expected: "203.0.113.5:31820",
+		},
+		{
+			name:     "node port falls back to the internal ip",
+			service:  corev1.Service{Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeNodePort, Ports: ports}},
+			node:     internalOnly,
+			expected: "10.1.0.6:31820",
+		},
+		{
+			name:    "no udp port",
+			service: corev1.Service{Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, Ports: ports[:1]}},
+			err:     kube.ErrServiceNoPort,
+		},
+		{
+			name:    "cluster ip",
+			service: corev1.Service{Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP, Ports: ports}},
+			err:     kube.ErrServiceType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			endpoint, err := kube.ServiceEndpoint(&tt.service, tt.node)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if endpoint != tt.expected {
+				t.Errorf("expected endpoint %q, got %q", tt.expected, endpoint)
+			}
+		})
+	}
+}
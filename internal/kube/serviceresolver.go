@@ -0,0 +1,204 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/resolver"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	listerscorev1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+var (
+	ErrServiceNoClusterIP = errors.New("service has no cluster IP")
+	ErrServicePortMissing = errors.New("service has no such port")
+)
+
+// ServiceResolver resolves service endpoints to the Service's cluster IP
+// and port from informers, so a Service that moves is followed as soon as
+// the API server reports it instead of once a DNS record expires. Each
+// namespace is only watched once an endpoint in it is resolved.
+type ServiceResolver struct {
+	client    kubernetes.Interface
+	mu        sync.Mutex
+	ctx       context.Context
+	listers   map[string]listerscorev1.ServiceLister
+	factories []informers.SharedInformerFactory
+	// resolved are the Services endpoints were resolved from, the only
+	// ones whose changes matter
+	resolved  map[string]struct{}
+	listeners []func()
+}
+
+// NewServiceResolver creates a resolver whose informers run until ctx is
+// done.
+func NewServiceResolver(ctx context.Context, client kubernetes.Interface) *ServiceResolver {
+	return &ServiceResolver{
+		client:   client,
+		ctx:      ctx,
+		listers:  make(map[string]listerscorev1.ServiceLister),
+		resolved: make(map[string]struct{}),
+	}
+}
+
+// OnChange registers fn to be called when a Service an endpoint was
+// resolved from changes or goes away, e.g. to reconcile right away.
+func (r *ServiceResolver) OnChange(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.listeners = append(r.listeners, fn)
+}
+
+// ResolveService implements resolver.ServiceSource.
+func (r *ServiceResolver) ResolveService(ctx context.Context, endpoint resolver.ServiceEndpoint) (*net.UDPAddr, error) {
+	lister, err := r.lister(ctx, endpoint.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	key := endpoint.Namespace + "/" + endpoint.Name
+	r.mu.Lock()
+	r.resolved[key] = struct{}{}
+	r.mu.Unlock()
+
+	service, err := lister.Services(endpoint.Namespace).Get(endpoint.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service %s: %w", key, err)
+	}
+	return ServiceClusterEndpoint(service, endpoint.Port)
+}
+
+// ServiceClusterEndpoint returns the cluster IP of service and the number
+// of its port called, or numbered, port.
+func ServiceClusterEndpoint(service *corev1.Service, port string) (*net.UDPAddr, error) {
+	addr, err := netip.ParseAddr(service.Spec.ClusterIP)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s/%s", ErrServiceNoClusterIP, service.Namespace, service.Name)
+	}
+
+	number, numeric := strconv.ParseUint(port, 10, 16)
+	for _, servicePort := range service.Spec.Ports {
+		if servicePort.Name == port || (numeric == nil && uint64(servicePort.Port) == number) {
+			return net.UDPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(servicePort.Port))), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s/%s has no port %s", ErrServicePortMissing, service.Namespace, service.Name, port)
+}
+
+// ServiceEndpointNamespaces returns the namespaces of the Services the
+// configured peers' endpoints name, in the order they first appear.
+func ServiceEndpointNamespaces(c *config.Config) []string {
+	var namespaces []string
+	for _, peer := range c.WireGuard.Peers {
+		endpoint, ok, err := resolver.ParseServiceEndpoint(peer.Endpoint)
+		if ok && err == nil && !slices.Contains(namespaces, endpoint.Namespace) {
+			namespaces = append(namespaces, endpoint.Namespace)
+		}
+	}
+	return namespaces
+}
+
+// Stop shuts down the informers.
+func (r *ServiceResolver) Stop() {
+	r.mu.Lock()
+	factories := r.factories
+	r.factories = nil
+	r.mu.Unlock()
+
+	for _, factory := range factories {
+		factory.Shutdown()
+	}
+}
+
+// lister returns the Service lister of namespace, starting its informer
+// and waiting for it to sync the first time.
+func (r *ServiceResolver) lister(ctx context.Context, namespace string) (listerscorev1.ServiceLister, error) {
+	r.mu.Lock()
+	lister, ok := r.listers[namespace]
+	if ok {
+		r.mu.Unlock()
+		return lister, nil
+	}
+
+	factory := informers.NewSharedInformerFactoryWithOptions(r.client, networkResync, informers.WithNamespace(namespace))
+	informer := factory.Core().V1().Services()
+	_, err := informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldService, ok := oldObj.(*corev1.Service)
+			newService, ok2 := newObj.(*corev1.Service)
+			if ok && ok2 && oldService.ResourceVersion != newService.ResourceVersion {
+				r.changed(newService)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if service, ok := obj.(*corev1.Service); ok {
+				r.changed(service)
+			}
+		},
+	})
+	if err != nil {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("failed to watch services in %s: %w", namespace, err)
+	}
+	lister = informer.Lister()
+	r.listers[namespace] = lister
+	r.factories = append(r.factories, factory)
+	r.mu.Unlock()
+
+	factory.Start(r.ctx.Done())
+	for informerType, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return nil, fmt.Errorf("failed to sync %v informer in %s", informerType, namespace)
+		}
+	}
+	return lister, nil
+}
+
+func (r *ServiceResolver) changed(service *corev1.Service) {
+	key := service.Namespace + "/" + service.Name
+	r.mu.Lock()
+	_, ok := r.resolved[key]
+	listeners := r.listeners
+	r.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	slog.Info("Service behind a peer endpoint changed", "service", key)
+	for _, fn := range listeners {
+		fn()
+	}
+}
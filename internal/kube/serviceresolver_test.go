@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package kube_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/kube"
+	"github.com/kubewg-net/container/internal/resolver"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func gatewayService(clusterIP string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "vpn", Name: "gateway"},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: clusterIP,
+			Ports: []corev1.ServicePort{
+				{Name: "metrics", Port: 9090, Protocol: corev1.ProtocolTCP},
+				{Name: "wireguard", Port: 51820, Protocol: corev1.ProtocolUDP},
+			},
+		},
+	}
+}
+
+func TestServiceClusterEndpoint(t *testing.T) {
+	t.Parallel()
+
+	service := gatewayService("10.96.0.20")
+	for _, port := range []string{"wireguard", "51820"} {
+		addr, err := kube.ServiceClusterEndpoint(service, port)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if addr.String() != "10.96.0.20:51820" {
+			t.Errorf("expected 10.96.0.20:51820 for port %s, got %s", port, addr)
+		}
+	}
+
+	if _, err := kube.ServiceClusterEndpoint(service, "dns"); !errors.Is(err, kube.ErrServicePortMissing) {
+		t.Errorf("expected ErrServicePortMissing, got %v", err)
+	}
+	if _, err := kube.ServiceClusterEndpoint(gatewayService(corev1.ClusterIPNone), "wireguard"); !errors.Is(err, kube.ErrServiceNoClusterIP) {
+		t.Errorf("expected ErrServiceNoClusterIP for a headless service, got %v", err)
+	}
+}
+
+func TestServiceResolver(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewSimpleClientset(gatewayService("10.96.0.20"))
+	ctx, cancel := context.WithCancel(context.Background())
+	serviceResolver := kube.NewServiceResolver(ctx, client)
+	defer func() {
+		cancel()
+		serviceResolver.Stop()
+	}()
+	changed := make(chan struct{}, 1)
+	serviceResolver.OnChange(func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+
+	endpoint := resolver.ServiceEndpoint{Namespace: "vpn", Name: "gateway", Port: "wireguard"}
+	addr, err := serviceResolver.ResolveService(ctx, endpoint)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if addr.String() != "10.96.0.20:51820" {
+		t.Errorf("expected 10.96.0.20:51820, got %s", addr)
+	}
+
+	moved := gatewayService("10.96.0.30")
+	moved.ResourceVersion = "2"
+	if _, err := client.CoreV1().Services("vpn").Update(ctx, moved, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a change notification for the moved service")
+	}
+	addr, err = serviceResolver.ResolveService(ctx, endpoint)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if addr.String() != "10.96.0.30:51820" {
+		t.Errorf("expected the moved service at 10.96.0.30:51820, got %s", addr)
+	}
+
+	if _, err := serviceResolver.ResolveService(ctx, resolver.ServiceEndpoint{Namespace: "vpn", Name: "missing", Port: "51820"}); err == nil {
+		t.Error("expected an error for a missing service")
+	}
+}
+
+func TestServiceEndpointNamespaces(t *testing.T) {
+	t.Parallel()
+
+	c := &config.Config{}
+	c.WireGuard.Peers = []config.WireGuardPeer{
+		{Endpoint: "service:vpn/gateway:51820"},
+		{Endpoint: "peer.example.com:51820"},
+		{Endpoint: "service:vpn/other:wireguard"},
+		{Endpoint: "service:edge/gateway:51820"},
+	}
+	namespaces := kube.ServiceEndpointNamespaces(c)
+	if len(namespaces) != 2 || namespaces[0] != "vpn" || namespaces[1] != "edge" {
+		t.Errorf("expected [vpn edge], got %v", namespaces)
+	}
+}
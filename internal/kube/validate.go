@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package kube
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+
+	"github.com/kubewg-net/container/internal/resolver"
+	"github.com/kubewg-net/container/pkg/apis/kubewg/v1alpha1"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	ErrInvalidPeer    = errors.New("invalid WireGuardPeer")
+	ErrInvalidNetwork = errors.New("invalid WireGuardNetwork")
+)
+
+// ValidatePeer checks what the gateways would otherwise only reject once
+// they try to configure the peer.
+func ValidatePeer(resource *v1alpha1.WireGuardPeer) error {
+	spec := &resource.Spec
+	if spec.Network == "" {
+		return fmt.Errorf("%w: network is required", ErrInvalidPeer)
+	}
+	if _, err := wgtypes.ParseKey(spec.PublicKey); err != nil {
+		return fmt.Errorf("%w: public key: %w", ErrInvalidPeer, err)
+	}
+	if _, ok, err := resolver.ParseServiceEndpoint(spec.Endpoint); ok {
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidPeer, err)
+		}
+	} else if spec.Endpoint != "" {
+		_, port, err := net.SplitHostPort(spec.Endpoint)
+		if err == nil {
+			_, err = strconv.ParseUint(port, 10, 16)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: endpoint %q is not host:port: %w", ErrInvalidPeer, spec.Endpoint, err)
+		}
+	}
+	if len(spec.AllowedIPs) == 0 {
+		return fmt.Errorf("%w: at least one allowed IP is required", ErrInvalidPeer)
+	}
+	for _, prefixes := range [][]string{spec.AllowedIPs, spec.ExcludedIPs} {
+		for _, prefix := range prefixes {
+			if _, err := netip.ParsePrefix(prefix); err != nil {
+				return fmt.Errorf("%w: %w", ErrInvalidPeer, err)
+			}
+		}
+	}
+	if spec.GatewaySelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(spec.GatewaySelector); err != nil {
+			return fmt.Errorf("%w: gateway selector: %w", ErrInvalidPeer, err)
+		}
+	}
+	return nil
+}
+
+// PeerWarnings lists what is valid but likely a mistake about a peer in
+// network: its tunnel IP, the first allowed IP, should be in the network.
+func PeerWarnings(resource *v1alpha1.WireGuardPeer, network *v1alpha1.WireGuardNetwork) []string {
+	if len(resource.Spec.AllowedIPs) == 0 {
+		return nil
+	}
+	tunnelIP, err := netip.ParsePrefix(resource.Spec.AllowedIPs[0])
+	if err != nil {
+		return nil
+	}
+	cidrs, err := tunnelCIDRs(network)
+	if err != nil {
+		return nil
+	}
+	if !slices.ContainsFunc(cidrs, func(cidr netip.Prefix) bool { return cidr.Contains(tunnelIP.Addr()) }) {
+		return []string{fmt.Sprintf("the first allowed IP %s is outside the tunnel CIDRs %v of WireGuardNetwork %s", tunnelIP, cidrs, network.Name)}
+	}
+	return nil
+}
+
+// ValidateNetwork checks what the members would otherwise only reject once
+// they try to join the network.
+func ValidateNetwork(network *v1alpha1.WireGuardNetwork) error {
+	if _, err := tunnelCIDRs(network); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidNetwork, err)
+	}
+	if ports := network.Spec.ListenPortRange; ports != nil && ports.Min > ports.Max {
+		return fmt.Errorf("%w: listen port range %d-%d is empty", ErrInvalidNetwork, ports.Min, ports.Max)
+	}
+	if _, err := NetworkConfig(network); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidNetwork, err)
+	}
+	return nil
+}
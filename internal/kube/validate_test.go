@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package kube_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/kube"
+	"github.com/kubewg-net/container/pkg/apis/kubewg/v1alpha1"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func validPeer(t *testing.T) *v1alpha1.WireGuardPeer {
+	t.Helper()
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return &v1alpha1.WireGuardPeer{
+		ObjectMeta: metav1.ObjectMeta{Name: "laptop"},
+		Spec: v1alpha1.WireGuardPeerSpec{
+			Network:    "office",
+			PublicKey:  key.PublicKey().String(),
+			Endpoint:   "vpn.example.com:51820",
+			AllowedIPs: []string{"10.10.0.5/32"},
+		},
+	}
+}
+
+func TestValidatePeer(t *testing.T) {
+	t.Parallel()
+
+	if err := kube.ValidatePeer(validPeer(t)); err != nil {
+		t.Fatalf("expected a valid peer, got %v", err)
+	}
+	service := validPeer(t)
+	service.Spec.Endpoint = "service:vpn/gateway:wireguard"
+	if err := kube.ValidatePeer(service); err != nil {
+		t.Errorf("expected a service endpoint to be valid, got %v", err)
+	}
+
+	tests := map[string]func(*v1alpha1.WireGuardPeer){
+		"no network":     func(p *v1alpha1.WireGuardPeer) { p.Spec.Network = "" },
+		"bad key":        func(p *v1alpha1.WireGuardPeer) { p.Spec.PublicKey = "nope" },
+		"bad endpoint":   func(p *v1alpha1.WireGuardPeer) { p.Spec.Endpoint = "vpn.example.com" },
+		"bad port":       func(p *v1alpha1.WireGuardPeer) { p.Spec.Endpoint = "vpn.example.com:70000" },
+		"bad service":    func(p *v1alpha1.WireGuardPeer) { p.Spec.Endpoint = "service:vpn/gateway" },
+		"no allowed IPs": func(p *v1alpha1.WireGuardPeer) { p.Spec.AllowedIPs = nil },
+		"bad allowed IP": func(p *v1alpha1.WireGuardPeer) { p.Spec.AllowedIPs = []string{"10.10.0.5"} },
+		"bad excluded":   func(p *v1alpha1.WireGuardPeer) { p.Spec.ExcludedIPs = []string{"nope"} },
+		"bad selector": func(p *v1alpha1.WireGuardPeer) {
+			p.Spec.GatewaySelector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "zone", Operator: "Near"}}}
+		},
+	}
+	for name, mutate := range tests {
+		peer := validPeer(t)
+		mutate(peer)
+		if err := kube.ValidatePeer(peer); !errors.Is(err, kube.ErrInvalidPeer) {
+			t.Errorf("%s: expected ErrInvalidPeer, got %v", name, err)
+		}
+	}
+}
+
+func TestPeerWarnings(t *testing.T) {
+	t.Parallel()
+
+	network := &v1alpha1.WireGuardNetwork{
+		ObjectMeta: metav1.ObjectMeta{Name: "office"},
+		Spec:       v1alpha1.WireGuardNetworkSpec{TunnelCIDR: "10.10.0.0/24"},
+	}
+	peer := validPeer(t)
+	if warnings := kube.PeerWarnings(peer, network); len(warnings) != 0 {
+		t.Errorf("expected no warnings, got %v", warnings)
+	}
+	peer.Spec.AllowedIPs = []string{"192.168.1.0/24"}
+	if warnings := kube.PeerWarnings(peer, network); len(warnings) != 1 {
+		t.Errorf("expected a warning, got %v", warnings)
+	}
+}
+
+func TestValidateNetwork(t *testing.T) {
+	t.Parallel()
+
+	network := &v1alpha1.WireGuardNetwork{Spec: v1alpha1.WireGuardNetworkSpec{
+		TunnelCIDR:      "10.10.0.0/24",
+		ListenPortRange: &v1alpha1.PortRange{Min: 51820, Max: 51830},
+	}}
+	if err := kube.ValidateNetwork(network); err != nil {
+		t.Fatalf("expected a valid network, got %v", err)
+	}
+
+	network.Spec.ListenPortRange.Max = 51000
+	if err := kube.ValidateNetwork(network); !errors.Is(err, kube.ErrInvalidNetwork) {
+		t.Errorf("expected ErrInvalidNetwork for an empty port range, got %v", err)
+	}
+	network.Spec.ListenPortRange = nil
+	network.Spec.TunnelCIDRs = []string{"10.10.0.0/24", "10.20.0.0/24"}
+	if err := kube.ValidateNetwork(network); !errors.Is(err, kube.ErrInvalidNetwork) {
+		t.Errorf("expected ErrInvalidNetwork for two IPv4 ranges, got %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package logbuffer keeps the most recent log lines in memory so they can
+// be read back through the API, e.g. for a support bundle.
+package logbuffer
+
+import (
+	"io"
+	"sync"
+)
+
+// DefaultSize is how many lines are kept by default
+const DefaultSize = 1000
+
+// Buffer is an io.Writer that keeps the last lines written to it. The log
+// package writes every entry with a single Write, so each Write is kept as
+// one line.
+type Buffer struct {
+	mu    sync.Mutex
+	lines [][]byte
+	next  int
+	full  bool
+}
+
+func New(size int) *Buffer {
+	return &Buffer{lines: make([][]byte, size)}
+}
+
+func (b *Buffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.lines) == 0 {
+		return len(p), nil
+	}
+	b.lines[b.next] = append(b.lines[b.next][:0], p...)
+	b.next = (b.next + 1) % len(b.lines)
+	if b.next == 0 {
+		b.full = true
+	}
+	return len(p), nil
+}
+
+// WriteTo writes the kept lines to w, oldest first.
+func (b *Buffer) WriteTo(w io.Writer) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var written int64
+	start := 0
+	count := b.next
+	if b.full {
+		start = b.next
+		count = len(b.lines)
+	}
+	for i := range count {
+		n, err := w.Write(b.lines[(start+i)%len(b.lines)])
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package logbuffer_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/logbuffer"
+)
+
+func TestBuffer(t *testing.T) {
+	t.Parallel()
+
+	buffer := logbuffer.New(3)
+	for i := range 2 {
+		fmt.Fprintf(buffer, "line %d\n", i)
+	}
+
+	var out bytes.Buffer
+	if _, err := buffer.WriteTo(&out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out.String() != "line 0\nline 1\n" {
+		t.Errorf("expected two lines, got %q", out.String())
+	}
+}
+
+func TestBufferWrapsAround(t *testing.T) {
+	t.Parallel()
+
+	buffer := logbuffer.New(3)
+	for i := range 5 {
+		fmt.Fprintf(buffer, "line %d\n", i)
+	}
+
+	var out bytes.Buffer
+	if _, err := buffer.WriteTo(&out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out.String() != "line 2\nline 3\nline 4\n" {
+		t.Errorf("expected the last three lines, got %q", out.String())
+	}
+}
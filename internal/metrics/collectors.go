@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+//nolint:golint,gochecknoglobals
+var (
+	EndpointFlaps = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubewg_peer_endpoint_flaps_total",
+		Help: "Number of times a peer's resolved endpoint changed",
+	}, []string{"public_key"})
+	EndpointHeldDown = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kubewg_peer_endpoint_held_down",
+		Help: "Whether a peer's endpoint change is being held down (1) or not (0)",
+	}, []string{"public_key"})
+	DriftItems = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kubewg_drift_items",
+		Help: "Number of differences between the desired and the live state",
+	}, []string{"kind"})
+	DriftSince = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "kubewg_drift_since_timestamp_seconds",
+		Help: "Unix time the oldest outstanding drift was first seen, 0 when there is none",
+	})
+	QueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kubewg_reconcile_queue_depth",
+		Help: "Number of queued reconcile work items by priority",
+	}, []string{"priority"})
+	PeerRelayed = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kubewg_peer_relayed",
+		Help: "Whether a peer is reached through a relay (1) because its direct path failed",
+	}, []string{"public_key", "relay"})
+	ProbeRTT = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kubewg_peer_probe_rtt_seconds",
+		Help:    "Round trip times of probes to a peer's tunnel address",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"public_key"})
+	ProbeLoss = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kubewg_peer_probe_loss_ratio",
+		Help: "Share of the last probe round's probes to a peer that went unanswered",
+	}, []string{"public_key"})
+	EndpointSource = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kubewg_endpoint_source",
+		Help: "Always 1, labeled with where the advertised endpoint came from",
+	}, []string{"source"})
+	RelayTransferBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubewg_relay_transfer_bytes_total",
+		Help: "Bytes exchanged with a relay peer while it carries relayed traffic, including its own",
+	}, []string{"relay", "direction"})
+	ReconcileDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kubewg_reconcile_duration_seconds",
+		Help:    "Time taken by reconcile passes, failed ones included",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"interface"})
+	ReconcileErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubewg_reconcile_errors_total",
+		Help: "Number of reconcile passes that failed",
+	}, []string{"interface"})
+	ConfigReloadSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "kubewg_config_reload_success_timestamp_seconds",
+		Help: "Unix time the config, or the WireGuardNetwork over it, was last applied successfully",
+	})
+	ConfigReloadSuccessful = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "kubewg_config_last_reload_successful",
+		Help: "Whether the last attempt to apply the config or a WireGuardNetwork change succeeded (1) or not (0)",
+	})
+	FeatureEnabled = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kubewg_feature_enabled",
+		Help: "Whether a feature gate is on (1) or off (0), labeled with its stage",
+	}, []string{"name", "stage"})
+	WebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubewg_webhook_deliveries_total",
+		Help: "Number of peer events posted to webhooks by result: delivered, failed after all retries or dropped from a full queue",
+	}, []string{"result"})
+	DNSProxyQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubewg_dns_proxy_queries_total",
+		Help: "Number of client DNS queries forwarded by the DNS proxy by result: answered by an upstream or failed",
+	}, []string{"result"})
+	RemoteWritePushes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubewg_remote_write_pushes_total",
+		Help: "Number of pushes to the remote-write endpoint by result: success or failed",
+	}, []string{"result"})
+)
+
+// Leader is only registered by the operator, with its manager's registry,
+// since the agents don't elect a leader.
+//
+//nolint:golint,gochecknoglobals
+var Leader = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "kubewg_leader",
+	Help: "Whether this operator replica is the elected leader running the controllers (1) or not (0)",
+})
@@ -0,0 +1,43 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package metrics
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ExemplarTraceID is the exemplar label holding the trace ID, the name
+// Grafana looks for by default.
+const ExemplarTraceID = "trace_id"
+
+// Observe records value on observer, with the trace of ctx attached as an
+// exemplar when the trace is sampled, so a slow bucket in a dashboard leads
+// to the trace behind it. Exemplars are only exposed to OpenMetrics scrapes.
+func Observe(ctx context.Context, observer prometheus.Observer, value float64) {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if exemplars, ok := observer.(prometheus.ExemplarObserver); ok && spanContext.IsSampled() {
+		exemplars.ObserveWithExemplar(value, prometheus.Labels{ExemplarTraceID: spanContext.TraceID().String()})
+		return
+	}
+	observer.Observe(value)
+}
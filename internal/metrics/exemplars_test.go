@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package metrics_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestObserveExemplar(t *testing.T) {
+	t.Parallel()
+	traceID := trace.TraceID{0x0a, 0x0b, 0x0c}
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     trace.SpanID{0x01},
+		TraceFlags: trace.FlagsSampled,
+	})
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		exemplar bool
+	}{
+		{name: "sampled", ctx: trace.ContextWithSpanContext(context.Background(), spanContext), exemplar: true},
+		{name: "unsampled", ctx: trace.ContextWithSpanContext(context.Background(), spanContext.WithTraceFlags(0))},
+		{name: "untraced", ctx: context.Background()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test", Buckets: []float64{1}})
+			metrics.Observe(tt.ctx, histogram, 0.5)
+
+			var metric dto.Metric
+			if err := histogram.Write(&metric); err != nil {
+				t.Fatalf("failed to write histogram: %v", err)
+			}
+			if got := metric.GetHistogram().GetSampleCount(); got != 1 {
+				t.Fatalf("expected 1 observation, got %d", got)
+			}
+			exemplar := metric.GetHistogram().GetBucket()[0].GetExemplar()
+			if !tt.exemplar {
+				if exemplar != nil {
+					t.Errorf("expected no exemplar, got %v", exemplar)
+				}
+				return
+			}
+			if exemplar == nil {
+				t.Fatal("expected an exemplar, got none")
+			}
+			labels := exemplar.GetLabel()
+			if len(labels) != 1 || labels[0].GetName() != metrics.ExemplarTraceID || labels[0].GetValue() != traceID.String() {
+				t.Errorf("expected trace_id=%s, got %v", traceID, labels)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kubewg-net/container/internal/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//nolint:golint,gochecknoglobals
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kubewg_http_requests_total",
+		Help: "Number of HTTP requests served by status class, e.g. 2xx",
+	}, []string{"server", "method", "class"})
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "kubewg_http_request_duration_seconds",
+		Help:    "Time taken to serve HTTP requests",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"server", "method"})
+	HTTPInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kubewg_http_requests_in_flight",
+		Help: "Number of HTTP requests being served",
+	}, []string{"server"})
+)
+
+// statusWriter remembers the status a handler responded with.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the connection, e.g. to flush.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Instrument counts and times the requests next serves under server's
+// name, each in a span continuing the trace of the caller, if any. Paths
+// are left out of the labels, they would include IDs.
+func Instrument(server string, next http.Handler) http.Handler {
+	inFlight := HTTPInFlight.WithLabelValues(server)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		method := normalizeMethod(r.Method)
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Tracer().Start(ctx, server+" "+method, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+
+		start := time.Now()
+		writer := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(writer, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.status_code", writer.status))
+		if writer.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(writer.status))
+		}
+		Observe(ctx, HTTPDuration.WithLabelValues(server, method), time.Since(start).Seconds())
+		HTTPRequests.WithLabelValues(server, method, strconv.Itoa(writer.status/100)+"xx").Inc()
+	})
+}
+
+// normalizeMethod keeps clients from creating label values at will.
+func normalizeMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrument(t *testing.T) {
+	t.Parallel()
+	handler := metrics.Instrument("test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+		}
+	}))
+
+	for _, path := range []string{"/", "/", "/missing"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/", nil))
+
+	if got := testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("test", http.MethodGet, "2xx")); got != 2 {
+		t.Errorf("expected 2 successful requests, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("test", http.MethodGet, "4xx")); got != 1 {
+		t.Errorf("expected 1 client error, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues("test", "OTHER", "2xx")); got != 1 {
+		t.Errorf("expected unknown methods to be grouped, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.HTTPInFlight.WithLabelValues("test")); got != 0 {
+		t.Errorf("expected no requests in flight, got %v", got)
+	}
+}
@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package metrics
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// WrapGatherer returns a gatherer prefixing every metric name gatherer
+// collects with namespace and an underscore, and adding labels to every
+// metric, so several kubewg instances, or kubewg and an embedding program,
+// can share a Prometheus without their series colliding. A metric's own
+// label wins over one of labels. gatherer is returned as is when there is
+// nothing to add.
+func WrapGatherer(gatherer prometheus.Gatherer, namespace string, labels map[string]string) prometheus.Gatherer {
+	if namespace == "" && len(labels) == 0 {
+		return gatherer
+	}
+	pairs := make([]*dto.LabelPair, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+	}
+	return &wrappedGatherer{gatherer: gatherer, namespace: namespace, labels: pairs}
+}
+
+type wrappedGatherer struct {
+	gatherer  prometheus.Gatherer
+	namespace string
+	labels    []*dto.LabelPair
+}
+
+func (w *wrappedGatherer) Gather() ([]*dto.MetricFamily, error) {
+	// Gather returns what it could collect along with the error, which is
+	// passed on the same way
+	families, err := w.gatherer.Gather()
+	for _, family := range families {
+		if w.namespace != "" {
+			family.Name = proto.String(w.namespace + "_" + family.GetName())
+		}
+		for _, metric := range family.GetMetric() {
+			metric.Label = w.addLabels(metric.GetLabel())
+		}
+	}
+	return families, err
+}
+
+func (w *wrappedGatherer) addLabels(own []*dto.LabelPair) []*dto.LabelPair {
+	if len(w.labels) == 0 {
+		return own
+	}
+	labels := own
+	for _, pair := range w.labels {
+		if !hasLabel(own, pair.GetName()) {
+			labels = append(labels, pair)
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+	return labels
+}
+
+func hasLabel(pairs []*dto.LabelPair, name string) bool {
+	for _, pair := range pairs {
+		if pair.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package metrics_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestWrapGatherer(t *testing.T) {
+	t.Parallel()
+
+	registry := prometheus.NewRegistry()
+	peers := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "kubewg_peers", Help: "Peers"}, []string{"cluster", "interface"})
+	peers.WithLabelValues("own", "kubewg0").Set(2)
+	registry.MustRegister(peers)
+
+	if metrics.WrapGatherer(registry, "", nil) != prometheus.Gatherer(registry) {
+		t.Error("expected the gatherer to be returned as is without a namespace or labels")
+	}
+
+	gatherer := metrics.WrapGatherer(registry, "tenant_a", map[string]string{"cluster": "prod", "environment": "staging"})
+	expected := `
+# HELP tenant_a_kubewg_peers Peers
+# TYPE tenant_a_kubewg_peers gauge
+tenant_a_kubewg_peers{cluster="own",environment="staging",interface="kubewg0"} 2
+`
+	if err := testutil.GatherAndCompare(gatherer, strings.NewReader(expected)); err != nil {
+		t.Errorf("expected the prefixed metric with the metric's own cluster label, got %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package metrics
+
+import (
+	"runtime"
+
+	"github.com/kubewg-net/container/internal/cryptopolicy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+//nolint:golint,gochecknoglobals
+var BuildInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "kubewg_build_info",
+	Help: "Always 1, labeled with the running version, WireGuard backend and crypto policy",
+}, []string{"version", "commit", "goversion", "backend", "crypto_policy", "crypto_module"})
+
+// RegisterRuntime extends the default Go collector with GC and scheduler
+// metrics next to the process collector, and publishes kubewg_build_info.
+// backend names the WireGuard implementation: kernel, userspace or none,
+// cryptoPolicy the configured crypto policy mode.
+func RegisterRuntime(version, commit, backend, cryptoPolicy string) error {
+	prometheus.Unregister(collectors.NewGoCollector())
+	prometheus.Unregister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	if err := prometheus.Register(collectors.NewGoCollector(
+		collectors.WithGoCollectorRuntimeMetrics(collectors.MetricsGC, collectors.MetricsScheduler),
+	)); err != nil {
+		return err
+	}
+	if err := prometheus.Register(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{})); err != nil {
+		return err
+	}
+
+	BuildInfo.Reset()
+	BuildInfo.WithLabelValues(version, commit, runtime.Version(), backend, cryptoPolicy, cryptopolicy.Module()).Set(1)
+	return nil
+}
@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package metrics_test
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/cryptopolicy"
+	"github.com/kubewg-net/container/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRegisterRuntime(t *testing.T) {
+	t.Parallel()
+	if err := metrics.RegisterRuntime("v1.2.3", "abc", "kernel", "default"); err != nil {
+		t.Fatalf("failed to register runtime metrics: %v", err)
+	}
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+
+	found := map[string]bool{}
+	for _, family := range families {
+		found[family.GetName()] = true
+		if family.GetName() != "kubewg_build_info" {
+			continue
+		}
+		labels := map[string]string{}
+		for _, label := range family.GetMetric()[0].GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		if labels["version"] != "v1.2.3" || labels["backend"] != "kernel" || labels["goversion"] != runtime.Version() {
+			t.Errorf("expected build info labels, got %v", labels)
+		}
+		if labels["crypto_policy"] != "default" || labels["crypto_module"] != cryptopolicy.Module() {
+			t.Errorf("expected the crypto policy and module labels, got %v", labels)
+		}
+	}
+
+	for _, name := range []string{"kubewg_build_info", "go_goroutines", "go_gc_cycles_total_gc_cycles_total"} {
+		if !found[name] {
+			t.Errorf("expected %s to be registered", name)
+		}
+	}
+}
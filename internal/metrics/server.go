@@ -23,11 +23,15 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"sync"
 	"time"
 
 	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/health"
+	"github.com/kubewg-net/container/internal/httplimit"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"golang.org/x/sync/errgroup"
 )
@@ -37,25 +41,73 @@ type Server struct {
 	ipv6Server *http.Server
 	stopped    bool
 	config     *config.Metrics
+	mux        *http.ServeMux
+	gatherer   prometheus.Gatherer
 }
 
-func NewServer(config *config.Metrics) *Server {
+// NewServer serves the metrics gatherer collects, the default registry
+// when nil, and, when status is not nil, the health probes. The config's
+// namespace and constant labels are applied to every metric served.
+func NewServer(config *config.Metrics, status *health.Status, gatherer prometheus.Gatherer) *Server {
+	if gatherer == nil {
+		gatherer = prometheus.DefaultGatherer
+	}
+	wrapped := WrapGatherer(gatherer, config.Namespace, config.ConstLabels)
+
+	mux := http.NewServeMux()
+	// OpenMetrics, when the scraper asks for it, carries the exemplars
+	metricsHandler := promhttp.HandlerFor(wrapped, promhttp.HandlerOpts{EnableOpenMetrics: true})
+	if registerer, ok := gatherer.(prometheus.Registerer); ok {
+		metricsHandler = promhttp.InstrumentMetricHandler(registerer, metricsHandler)
+	}
+	mux.Handle("/", Instrument("metrics", metricsHandler))
+	// Probes are told apart from scrapes so they can be watched on their
+	// own
+	if status != nil {
+		probes := http.NewServeMux()
+		status.Register(probes)
+		mux.Handle("/healthz", Instrument("health", probes))
+		mux.Handle("/readyz", Instrument("health", probes))
+	}
+
+	handler := httplimit.New(&config.Limits).Handler(mux)
+
 	return &Server{
 		ipv4Server: &http.Server{
 			Addr:              fmt.Sprintf("%s:%d", config.IPV4Host, config.Port),
 			ReadHeaderTimeout: 5 * time.Second,
-			Handler:           promhttp.Handler(),
+			Handler:           handler,
 		},
 		ipv6Server: &http.Server{
 			Addr:              fmt.Sprintf("[%s]:%d", config.IPV6Host, config.Port),
 			ReadHeaderTimeout: 5 * time.Second,
-			Handler:           promhttp.Handler(),
+			Handler:           handler,
 		},
-		config: config,
+		config:   config,
+		mux:      mux,
+		gatherer: wrapped,
 	}
 }
 
-func (s *Server) Start() {
+// Gatherer returns what the server serves, with the namespace and constant
+// labels applied, for pushing the same series elsewhere.
+func (s *Server) Gatherer() prometheus.Gatherer {
+	return s.gatherer
+}
+
+// Handle serves handler for pattern next to the metrics. It must be called
+// before Start.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, Instrument("metrics", handler))
+}
+
+func (s *Server) Start(ctx context.Context) {
+	baseContext := func(net.Listener) context.Context {
+		return ctx
+	}
+	s.ipv4Server.BaseContext = baseContext
+	s.ipv6Server.BaseContext = baseContext
+
 	waitGrp := sync.WaitGroup{}
 	waitGrp.Add(1)
 	go func() {
@@ -78,11 +130,12 @@ func (s *Server) Start() {
 	waitGrp.Wait()
 }
 
-func (s *Server) Stop() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
+// Stop gives in-flight requests the shutdown timeout to finish before
+// their connections are closed.
+func (s *Server) Stop(ctx context.Context) error {
 	s.stopped = true
+	ctx, cancel := context.WithTimeout(ctx, s.config.ShutdownTimeout.Std())
+	defer cancel()
 
 	errGrp := errgroup.Group{}
 	if s.ipv4Server != nil {
@@ -96,5 +149,13 @@ func (s *Server) Stop() error {
 		})
 	}
 
-	return errGrp.Wait()
+	if err := errGrp.Wait(); err != nil {
+		for _, server := range []*http.Server{s.ipv4Server, s.ipv6Server} {
+			if server != nil {
+				_ = server.Close()
+			}
+		}
+		return fmt.Errorf("closed connections still open after the shutdown timeout: %w", err)
+	}
+	return nil
 }
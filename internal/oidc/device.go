@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	deviceCodeGrant = "urn:ietf:params:oauth:grant-type:device_code"
+	// defaultPollInterval applies when the provider doesn't say, RFC 8628
+	// section 3.2
+	defaultPollInterval = 5 * time.Second
+	// slowDown is added to the interval when the provider asks for it
+	slowDown = 5 * time.Second
+)
+
+var (
+	ErrNoDeviceFlow = errors.New("the OIDC provider does not support the device authorization flow")
+	ErrDeviceFlow   = errors.New("device authorization failed")
+)
+
+// DeviceCode is what the user needs to approve the login elsewhere.
+type DeviceCode struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// DeviceLogin runs the device authorization flow of RFC 8628 for clientID
+// and returns the ID token once the user approved it. prompt tells the
+// user where to go and which code to enter.
+func DeviceLogin(ctx context.Context, httpClient *http.Client, provider *Provider, clientID string, scopes []string, prompt func(*DeviceCode)) (string, error) {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	if provider.DeviceAuthorizationEndpoint == "" {
+		return "", ErrNoDeviceFlow
+	}
+
+	var code DeviceCode
+	err := postForm(ctx, httpClient, provider.DeviceAuthorizationEndpoint, url.Values{
+		"client_id": {clientID},
+		"scope":     {strings.Join(scopes, " ")},
+	}, &code)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrDeviceFlow, err)
+	}
+	if code.DeviceCode == "" || code.UserCode == "" {
+		return "", fmt.Errorf("%w: the provider returned no device code", ErrDeviceFlow)
+	}
+	prompt(&code)
+
+	if code.ExpiresIn > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(code.ExpiresIn)*time.Second)
+		defer cancel()
+	}
+	interval := defaultPollInterval
+	if code.Interval > 0 {
+		interval = time.Duration(code.Interval) * time.Second
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("%w: %w", ErrDeviceFlow, ctx.Err())
+		case <-time.After(interval):
+		}
+
+		var token struct {
+			IDToken string `json:"id_token"`
+		}
+		err := postForm(ctx, httpClient, provider.TokenEndpoint, url.Values{
+			"grant_type":  {deviceCodeGrant},
+			"device_code": {code.DeviceCode},
+			"client_id":   {clientID},
+		}, &token)
+		var oauthErr *oauthError
+		switch {
+		case errors.As(err, &oauthErr) && oauthErr.Code == "authorization_pending":
+			continue
+		case errors.As(err, &oauthErr) && oauthErr.Code == "slow_down":
+			interval += slowDown
+			continue
+		case err != nil:
+			return "", fmt.Errorf("%w: %w", ErrDeviceFlow, err)
+		case token.IDToken == "":
+			return "", fmt.Errorf("%w: the provider returned no ID token, is the openid scope requested?", ErrDeviceFlow)
+		}
+		return token.IDToken, nil
+	}
+}
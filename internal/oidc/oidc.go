@@ -0,0 +1,164 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package oidc verifies OpenID Connect ID tokens and runs the device
+// authorization flow that gets one on a machine without a browser.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// discoveryPath is appended to the issuer to find the provider metadata
+const discoveryPath = "/.well-known/openid-configuration"
+
+// maxResponse bounds what is read from the provider
+const maxResponse = 1 << 20
+
+var (
+	ErrDiscovery = errors.New("OIDC discovery failed")
+	ErrProvider  = errors.New("OIDC provider request failed")
+)
+
+// Provider is the part of an issuer's metadata used here.
+type Provider struct {
+	Issuer                      string `json:"issuer"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	JWKSURI                     string `json:"jwks_uri"`
+}
+
+// Discover fetches the metadata of issuer, which must name itself the
+// same way.
+func Discover(ctx context.Context, httpClient *http.Client, issuer string) (*Provider, error) {
+	var provider Provider
+	if err := getJSON(ctx, httpClient, strings.TrimSuffix(issuer, "/")+discoveryPath, &provider); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDiscovery, err)
+	}
+	if strings.TrimSuffix(provider.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+		return nil, fmt.Errorf("%w: metadata names issuer %q instead of %q", ErrDiscovery, provider.Issuer, issuer)
+	}
+	if provider.TokenEndpoint == "" || provider.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: metadata of %s lacks the token endpoint or the JWKS", ErrDiscovery, issuer)
+	}
+	return &provider, nil
+}
+
+// Claims are the decoded claims of an ID token.
+type Claims map[string]interface{}
+
+// String returns the string claim called name.
+func (c Claims) String(name string) string {
+	value, _ := c[name].(string)
+	return value
+}
+
+// Strings returns the claim called name as a list, whether the provider
+// sends a single string or an array of them.
+func (c Claims) Strings(name string) []string {
+	switch value := c[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func getJSON(ctx context.Context, httpClient *http.Client, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	return doJSON(httpClient, req, out)
+}
+
+func postForm(ctx context.Context, httpClient *http.Client, endpoint string, form url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	return doJSON(httpClient, req, out)
+}
+
+// doJSON decodes the response into out. OAuth errors come back as a JSON
+// body with a 4xx status, so a body with an "error" is decoded too and left
+// to the caller.
+func doJSON(httpClient *http.Client, req *http.Request, out interface{}) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrProvider, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponse))
+	if err != nil {
+		return fmt.Errorf("%w: failed to read %s: %w", ErrProvider, req.URL, err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		var oauthErr oauthError
+		if json.Unmarshal(data, &oauthErr) != nil || oauthErr.Code == "" {
+			return fmt.Errorf("%w: %s: %s", ErrProvider, req.URL, resp.Status)
+		}
+		return &oauthErr
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("%w: failed to decode %s: %w", ErrProvider, req.URL, err)
+	}
+	return nil
+}
+
+// oauthError is the error response of RFC 6749 section 5.2.
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *oauthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+// timeClaim returns the NumericDate claim called name.
+func timeClaim(claims Claims, name string) (time.Time, bool) {
+	seconds, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
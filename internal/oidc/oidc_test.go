@@ -0,0 +1,175 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package oidc_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/oidc"
+)
+
+// provider is an identity provider signing with one P-256 key. Its device
+// flow is approved on the second poll.
+type provider struct {
+	server *httptest.Server
+	key    *ecdsa.PrivateKey
+	polls  atomic.Int32
+}
+
+func newProvider(t *testing.T) *provider {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	p := &provider{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(oidc.Provider{
+			Issuer:                      p.server.URL,
+			TokenEndpoint:               p.server.URL + "/token",
+			DeviceAuthorizationEndpoint: p.server.URL + "/device",
+			JWKSURI:                     p.server.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("GET /keys", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "EC", "kid": "one", "use": "sig", "crv": "P-256",
+			"x": base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y": base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		}}})
+	})
+	mux.HandleFunc("POST /device", func(w http.ResponseWriter, _ *http.Request) {
+		_ = json.NewEncoder(w).Encode(oidc.DeviceCode{DeviceCode: "device", UserCode: "ABCD-EFGH", VerificationURI: p.server.URL + "/activate", Interval: 1, ExpiresIn: 60})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("device_code") != "device" || p.polls.Add(1) < 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "authorization_pending"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": p.sign(t, p.claims("kubewg"))})
+	})
+	p.server = httptest.NewServer(mux)
+	t.Cleanup(p.server.Close)
+	return p
+}
+
+func (p *provider) claims(audience string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":    p.server.URL,
+		"aud":    audience,
+		"sub":    "1234",
+		"email":  "dev@example.com",
+		"groups": []string{"devs"},
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func (p *provider) sign(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": "one"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("failed to marshal claims: %v", err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := crypto.SHA256.New()
+	digest.Write([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, p.key, digest.Sum(nil))
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	signature := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	p := newProvider(t)
+	verifier := oidc.NewVerifier(p.server.URL, "kubewg", nil)
+	ctx := context.Background()
+
+	claims, err := verifier.Verify(ctx, p.sign(t, p.claims("kubewg")))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if claims.String("email") != "dev@example.com" {
+		t.Errorf("expected the email claim, got %q", claims.String("email"))
+	}
+	if groups := claims.Strings("groups"); len(groups) != 1 || groups[0] != "devs" {
+		t.Errorf("expected groups [devs], got %v", groups)
+	}
+
+	expired := p.claims("kubewg")
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	tampered := p.sign(t, p.claims("kubewg"))
+	tampered = tampered[:len(tampered)-4] + "AAAA"
+	for name, token := range map[string]string{
+		"other audience": p.sign(t, p.claims("someone-else")),
+		"expired":        p.sign(t, expired),
+		"tampered":       tampered,
+		"garbage":        "not.a.token",
+	} {
+		if _, err := verifier.Verify(ctx, token); !errors.Is(err, oidc.ErrInvalidIDToken) {
+			t.Errorf("%s: expected ErrInvalidIDToken, got %v", name, err)
+		}
+	}
+}
+
+func TestDeviceLogin(t *testing.T) {
+	t.Parallel()
+
+	p := newProvider(t)
+	ctx := context.Background()
+	discovered, err := oidc.Discover(ctx, http.DefaultClient, p.server.URL)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var prompted *oidc.DeviceCode
+	idToken, err := oidc.DeviceLogin(ctx, nil, discovered, "kubewg", []string{"openid"}, func(code *oidc.DeviceCode) {
+		prompted = code
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if prompted == nil || prompted.UserCode != "ABCD-EFGH" {
+		t.Errorf("expected the user to be shown the code, got %+v", prompted)
+	}
+	if _, err := oidc.NewVerifier(p.server.URL, "kubewg", nil).Verify(ctx, idToken); err != nil {
+		t.Errorf("expected a valid ID token, got %v", err)
+	}
+}
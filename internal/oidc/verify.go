@@ -0,0 +1,306 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// clockSkew is tolerated between here and the provider
+	clockSkew = time.Minute
+	// jwksRefresh limits how often an unknown key ID refetches the keys,
+	// so garbage tokens can't hammer the provider
+	jwksRefresh = time.Minute
+)
+
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+// algorithms are the signature algorithms accepted, with their hash
+var algorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+}
+
+// Verifier checks ID tokens issued to a client. The provider is only
+// discovered on first use, so a provider that is down doesn't hold up
+// startup, and discovery is retried until it succeeds.
+type Verifier struct {
+	issuer   string
+	clientID string
+	http     *http.Client
+	mu       sync.Mutex
+	provider *Provider
+	keys     map[string]crypto.PublicKey
+	fetched  time.Time
+}
+
+func NewVerifier(issuer, clientID string, httpClient *http.Client) *Verifier {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Verifier{issuer: issuer, clientID: clientID, http: httpClient}
+}
+
+// Provider returns the discovered metadata of the issuer.
+func (v *Verifier) Provider(ctx context.Context) (*Provider, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.discover(ctx)
+}
+
+// Verify checks the signature, issuer, audience and expiry of raw and
+// returns its claims.
+func (v *Verifier) Verify(ctx context.Context, raw string) (Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a JWS in compact form", ErrInvalidIDToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %w", ErrInvalidIDToken, err)
+	}
+	hash, ok := algorithms[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidIDToken, header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %w", ErrInvalidIDToken, err)
+	}
+
+	keys, err := v.keysFor(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	digest := h.Sum(nil)
+	if !slices.ContainsFunc(keys, func(key crypto.PublicKey) bool {
+		return verifySignature(key, header.Alg, hash, digest, signature)
+	}) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidIDToken)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %w", ErrInvalidIDToken, err)
+	}
+	if err := v.checkClaims(claims, time.Now()); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *Verifier) checkClaims(claims Claims, now time.Time) error {
+	if strings.TrimSuffix(claims.String("iss"), "/") != strings.TrimSuffix(v.issuer, "/") {
+		return fmt.Errorf("%w: issued by %q", ErrInvalidIDToken, claims.String("iss"))
+	}
+	if !slices.Contains(claims.Strings("aud"), v.clientID) {
+		return fmt.Errorf("%w: not issued to %s", ErrInvalidIDToken, v.clientID)
+	}
+	expiry, ok := timeClaim(claims, "exp")
+	if !ok || now.After(expiry.Add(clockSkew)) {
+		return fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	}
+	if notBefore, ok := timeClaim(claims, "nbf"); ok && now.Add(clockSkew).Before(notBefore) {
+		return fmt.Errorf("%w: not valid yet", ErrInvalidIDToken)
+	}
+	return nil
+}
+
+// keysFor returns the key called kid, every key when the token doesn't
+// name one. Keys the provider rotated in are fetched on demand.
+func (v *Verifier) keysFor(ctx context.Context, kid string) ([]crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	lookup := func() []crypto.PublicKey {
+		if kid == "" {
+			keys := make([]crypto.PublicKey, 0, len(v.keys))
+			for _, key := range v.keys {
+				keys = append(keys, key)
+			}
+			return keys
+		}
+		if key, ok := v.keys[kid]; ok {
+			return []crypto.PublicKey{key}
+		}
+		return nil
+	}
+
+	keys := lookup()
+	if len(keys) == 0 && time.Since(v.fetched) > jwksRefresh {
+		if err := v.fetchKeys(ctx); err != nil {
+			return nil, err
+		}
+		keys = lookup()
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidIDToken, kid)
+	}
+	return keys, nil
+}
+
+// discover fetches the provider metadata once. Callers hold v.mu.
+func (v *Verifier) discover(ctx context.Context) (*Provider, error) {
+	if v.provider != nil {
+		return v.provider, nil
+	}
+	provider, err := Discover(ctx, v.http, v.issuer)
+	if err != nil {
+		return nil, err
+	}
+	v.provider = provider
+	return provider, nil
+}
+
+// fetchKeys replaces the keys with the provider's JWKS. Keys of types
+// that aren't supported are skipped. Callers hold v.mu.
+func (v *Verifier) fetchKeys(ctx context.Context) error {
+	provider, err := v.discover(ctx)
+	if err != nil {
+		return err
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(ctx, v.http, provider.JWKSURI, &set); err != nil {
+		return fmt.Errorf("failed to fetch signing keys: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	v.keys = keys
+	v.fetched = time.Now()
+	return nil
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("RSA exponent of key %q is too large", k.Kid)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("key %q is not on %s", k.Kid, k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func verifySignature(key crypto.PublicKey, alg string, hash crypto.Hash, digest, signature []byte) bool {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") && rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		// JWS signatures are R and S padded to the curve size, not ASN.1
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(key, digest, r, s)
+	}
+	return false
+}
+
+func decodeSegment(segment string, out interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func decodeInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key parameter: %w", err)
+	}
+	return new(big.Int).SetBytes(data), nil
+}
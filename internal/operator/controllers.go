@@ -0,0 +1,256 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package operator
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/kubewg-net/container/internal/keystore"
+	"github.com/kubewg-net/container/internal/kube"
+	"github.com/kubewg-net/container/pkg/apis/kubewg/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// NodeReconciler removes what a deleted node left in the status of the
+// WireGuardPeers. The node's agent would have kept its entry up to date,
+// with the node gone nobody does until the entry goes stale. Nodes held
+// by kube.NodeFinalizer are cleaned up and released once they are being
+// deleted, in case their agent is gone before it could do so itself.
+type NodeReconciler struct {
+	Client client.Client
+}
+
+func (r *NodeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("node").
+		For(&corev1.Node{}, builder.WithPredicates(predicate.Funcs{
+			CreateFunc: func(e event.CreateEvent) bool { return finalizing(e.Object) },
+			UpdateFunc: func(e event.UpdateEvent) bool { return finalizing(e.ObjectNew) },
+		})).
+		Complete(r)
+}
+
+// finalizing reports whether node is being deleted and waits for us.
+func finalizing(node client.Object) bool {
+	return node.GetDeletionTimestamp() != nil && controllerutil.ContainsFinalizer(node, kube.NodeFinalizer)
+}
+
+func (r *NodeReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	var node corev1.Node
+	err := r.Client.Get(ctx, req.NamespacedName, &node)
+	if apierrors.IsNotFound(err) {
+		return reconcile.Result{}, r.removeGateway(ctx, req.Name)
+	} else if err != nil {
+		return reconcile.Result{}, err
+	}
+	if !finalizing(&node) {
+		return reconcile.Result{}, nil
+	}
+
+	if err := r.removeGateway(ctx, node.Name); err != nil {
+		return reconcile.Result{}, err
+	}
+	controllerutil.RemoveFinalizer(&node, kube.NodeFinalizer)
+	if err := r.Client.Update(ctx, &node); err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to remove finalizer of node %s: %w", node.Name, err)
+	}
+	return reconcile.Result{}, nil
+}
+
+// removeGateway removes the entries of nodeName from the status of every
+// WireGuardPeer.
+func (r *NodeReconciler) removeGateway(ctx context.Context, nodeName string) error {
+	var peers v1alpha1.WireGuardPeerList
+	if err := r.Client.List(ctx, &peers); err != nil {
+		return fmt.Errorf("failed to list WireGuardPeers: %w", err)
+	}
+	now := time.Now()
+	for i := range peers.Items {
+		peer := &peers.Items[i]
+		if !hasGateway(peer, nodeName) {
+			continue
+		}
+		// Entries of other nodes are left alone, whether they are stale is
+		// up to the agents
+		kube.UpdatePeerStatus(peer, nodeName, nil, now, math.MaxInt64)
+		if err := r.Client.Status().Update(ctx, peer); err != nil {
+			return fmt.Errorf("failed to update status of WireGuardPeer %s: %w", peer.Name, err)
+		}
+	}
+	return nil
+}
+
+func hasGateway(peer *v1alpha1.WireGuardPeer, nodeName string) bool {
+	for i := range peer.Status.Gateways {
+		if peer.Status.Gateways[i].Node == nodeName {
+			return true
+		}
+	}
+	return false
+}
+
+// PeerReconciler sets the Accepted condition of WireGuardPeers, so a peer
+// the gateways skip shows why without looking at their logs.
+type PeerReconciler struct {
+	Client client.Client
+}
+
+func (r *PeerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("peer").
+		For(&v1alpha1.WireGuardPeer{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		// A peer is only accepted once its network exists
+		Watches(&v1alpha1.WireGuardNetwork{}, handler.EnqueueRequestsFromMapFunc(r.peersOfNetwork)).
+		Complete(r)
+}
+
+func (r *PeerReconciler) peersOfNetwork(ctx context.Context, network client.Object) []reconcile.Request {
+	var peers v1alpha1.WireGuardPeerList
+	if err := r.Client.List(ctx, &peers); err != nil {
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range peers.Items {
+		if peers.Items[i].Spec.Network == network.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: peers.Items[i].Name}})
+		}
+	}
+	return requests
+}
+
+func (r *PeerReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	var peer v1alpha1.WireGuardPeer
+	if err := r.Client.Get(ctx, req.NamespacedName, &peer); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+
+	condition := metav1.Condition{
+		Type:               v1alpha1.PeerAccepted,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Valid",
+		ObservedGeneration: peer.Generation,
+	}
+	var network v1alpha1.WireGuardNetwork
+	if err := kube.ValidatePeer(&peer); err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "Invalid"
+		condition.Message = err.Error()
+	} else if err := r.Client.Get(ctx, types.NamespacedName{Name: peer.Spec.Network}, &network); apierrors.IsNotFound(err) {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "NetworkNotFound"
+		condition.Message = fmt.Sprintf("WireGuardNetwork %s does not exist", peer.Spec.Network)
+	} else if err != nil {
+		return reconcile.Result{}, err
+	} else {
+		condition.Message = strings.Join(kube.PeerWarnings(&peer, &network), "; ")
+	}
+
+	if !meta.SetStatusCondition(&peer.Status.Conditions, condition) {
+		return reconcile.Result{}, nil
+	}
+	if err := r.Client.Status().Update(ctx, &peer); err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to update status of WireGuardPeer %s: %w", peer.Name, err)
+	}
+	return reconcile.Result{}, nil
+}
+
+// SecretReconciler deletes the private key Secrets of nodes that are gone,
+// a node joining again under the same name gets a new key.
+type SecretReconciler struct {
+	Client    client.Client
+	Namespace string
+}
+
+var (
+	//nolint:golint,gochecknoglobals
+	privateKeyPrefix, privateKeySuffix, _ = strings.Cut(keystore.SecretName(keystore.PrivateKeyName("*")), "*")
+)
+
+func (r *SecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	keys := predicate.NewPredicateFuncs(func(object client.Object) bool {
+		_, ok := r.nodeName(object)
+		return ok
+	})
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("secret").
+		For(&corev1.Secret{}, builder.WithPredicates(keys)).
+		// Deleting a node has to find its Secret
+		Watches(&corev1.Node{}, handler.EnqueueRequestsFromMapFunc(func(_ context.Context, node client.Object) []reconcile.Request {
+			return []reconcile.Request{{NamespacedName: types.NamespacedName{
+				Namespace: r.Namespace,
+				Name:      privateKeyPrefix + node.GetName() + privateKeySuffix,
+			}}}
+		}), builder.WithPredicates(predicate.Funcs{
+			CreateFunc: func(_ event.CreateEvent) bool { return false },
+			UpdateFunc: func(_ event.UpdateEvent) bool { return false },
+		})).
+		Complete(r)
+}
+
+// nodeName returns the node whose private key object holds. Preshared key
+// Secrets are named after a pair of keys, not nodes, and are left alone.
+func (r *SecretReconciler) nodeName(object client.Object) (string, bool) {
+	if object.GetNamespace() != r.Namespace || object.GetLabels()[keystore.SecretLabel] != "true" {
+		return "", false
+	}
+	name, ok := strings.CutPrefix(object.GetName(), privateKeyPrefix)
+	if !ok {
+		return "", false
+	}
+	return strings.CutSuffix(name, privateKeySuffix)
+}
+
+func (r *SecretReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	var secret corev1.Secret
+	if err := r.Client.Get(ctx, req.NamespacedName, &secret); err != nil {
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+	nodeName, ok := r.nodeName(&secret)
+	if !ok {
+		return reconcile.Result{}, nil
+	}
+
+	var node corev1.Node
+	err := r.Client.Get(ctx, types.NamespacedName{Name: nodeName}, &node)
+	if err == nil || !apierrors.IsNotFound(err) {
+		return reconcile.Result{}, err
+	}
+	ctrl.LoggerFrom(ctx).Info("deleting private key of removed node", "node", nodeName)
+	if err := r.Client.Delete(ctx, &secret, client.Preconditions{UID: &secret.UID}); client.IgnoreNotFound(err) != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to delete secret %s/%s: %w", secret.Namespace, secret.Name, err)
+	}
+	return reconcile.Result{}, nil
+}
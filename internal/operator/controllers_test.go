@@ -0,0 +1,201 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package operator_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/keystore"
+	"github.com/kubewg-net/container/internal/kube"
+	"github.com/kubewg-net/container/internal/operator"
+	"github.com/kubewg-net/container/pkg/apis/kubewg/v1alpha1"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func newClient(t *testing.T, objects ...client.Object) client.Client {
+	t.Helper()
+	scheme, err := operator.NewScheme()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objects...).
+		WithStatusSubresource(&v1alpha1.WireGuardPeer{}).
+		Build()
+}
+
+func request(namespace, name string) reconcile.Request {
+	return reconcile.Request{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}
+}
+
+func TestNodeReconcilerRemovesGateway(t *testing.T) {
+	t.Parallel()
+
+	now := metav1.NewTime(time.Now())
+	peer := &v1alpha1.WireGuardPeer{ObjectMeta: metav1.ObjectMeta{Name: "laptop"}}
+	peer.Status.Gateways = []v1alpha1.WireGuardPeerGatewayStatus{
+		{Node: "node-a", ReceiveBytes: 10, UpdateTime: now},
+		{Node: "node-b", ReceiveBytes: 20, UpdateTime: now},
+	}
+	c := newClient(t, peer, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}})
+	r := &operator.NodeReconciler{Client: c}
+	ctx := context.Background()
+
+	// node-a still exists, node-b is gone
+	for _, name := range []string{"node-a", "node-b"} {
+		if _, err := r.Reconcile(ctx, request("", name)); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	var got v1alpha1.WireGuardPeer
+	if err := c.Get(ctx, types.NamespacedName{Name: "laptop"}, &got); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(got.Status.Gateways) != 1 || got.Status.Gateways[0].Node != "node-a" {
+		t.Fatalf("expected only node-a, got %+v", got.Status.Gateways)
+	}
+	if got.Status.ReceiveBytes != 10 {
+		t.Errorf("expected 10 bytes, got %d", got.Status.ReceiveBytes)
+	}
+}
+
+func TestNodeReconcilerReleasesFinalizer(t *testing.T) {
+	t.Parallel()
+
+	now := metav1.NewTime(time.Now())
+	peer := &v1alpha1.WireGuardPeer{ObjectMeta: metav1.ObjectMeta{Name: "laptop"}}
+	peer.Status.Gateways = []v1alpha1.WireGuardPeerGatewayStatus{
+		{Node: "node-a", ReceiveBytes: 10, UpdateTime: now},
+		{Node: "node-b", ReceiveBytes: 20, UpdateTime: now},
+	}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:              "node-b",
+		Finalizers:        []string{kube.NodeFinalizer},
+		DeletionTimestamp: &now,
+	}}
+	c := newClient(t, peer, node)
+	r := &operator.NodeReconciler{Client: c}
+	ctx := context.Background()
+
+	if _, err := r.Reconcile(ctx, request("", "node-b")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var got v1alpha1.WireGuardPeer
+	if err := c.Get(ctx, types.NamespacedName{Name: "laptop"}, &got); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(got.Status.Gateways) != 1 || got.Status.Gateways[0].Node != "node-a" {
+		t.Fatalf("expected only node-a, got %+v", got.Status.Gateways)
+	}
+	// Without its last finalizer the Node is deleted
+	if err := c.Get(ctx, types.NamespacedName{Name: "node-b"}, &corev1.Node{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected node-b to be deleted, got %v", err)
+	}
+}
+
+func TestPeerReconcilerAccepted(t *testing.T) {
+	t.Parallel()
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	valid := v1alpha1.WireGuardPeerSpec{Network: "office", PublicKey: key.PublicKey().String(), AllowedIPs: []string{"10.10.0.5/32"}}
+	orphan := valid
+	orphan.Network = "lab"
+	invalid := valid
+	invalid.PublicKey = "nope"
+
+	c := newClient(t,
+		&v1alpha1.WireGuardNetwork{ObjectMeta: metav1.ObjectMeta{Name: "office"}, Spec: v1alpha1.WireGuardNetworkSpec{TunnelCIDR: "10.10.0.0/24"}},
+		&v1alpha1.WireGuardPeer{ObjectMeta: metav1.ObjectMeta{Name: "valid"}, Spec: valid},
+		&v1alpha1.WireGuardPeer{ObjectMeta: metav1.ObjectMeta{Name: "orphan"}, Spec: orphan},
+		&v1alpha1.WireGuardPeer{ObjectMeta: metav1.ObjectMeta{Name: "invalid"}, Spec: invalid},
+	)
+	r := &operator.PeerReconciler{Client: c}
+	ctx := context.Background()
+
+	expected := map[string]string{"valid": "Valid", "orphan": "NetworkNotFound", "invalid": "Invalid"}
+	for name, reason := range expected {
+		if _, err := r.Reconcile(ctx, request("", name)); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		var got v1alpha1.WireGuardPeer
+		if err := c.Get(ctx, types.NamespacedName{Name: name}, &got); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		condition := meta.FindStatusCondition(got.Status.Conditions, v1alpha1.PeerAccepted)
+		if condition == nil {
+			t.Fatalf("%s: expected an Accepted condition", name)
+		}
+		if condition.Reason != reason || (condition.Status == metav1.ConditionTrue) != (reason == "Valid") {
+			t.Errorf("%s: expected reason %s, got %s %s", name, reason, condition.Status, condition.Reason)
+		}
+	}
+}
+
+func TestSecretReconcilerDeletesRemovedNodes(t *testing.T) {
+	t.Parallel()
+
+	secret := func(name string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Namespace: "kubewg",
+			Name:      keystore.SecretName(name),
+			Labels:    map[string]string{keystore.SecretLabel: "true"},
+		}}
+	}
+	c := newClient(t,
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}},
+		secret(keystore.PrivateKeyName("node-a")),
+		secret(keystore.PrivateKeyName("node-b")),
+		secret("psk/abc"),
+	)
+	r := &operator.SecretReconciler{Client: c, Namespace: "kubewg"}
+	ctx := context.Background()
+
+	for _, name := range []string{keystore.PrivateKeyName("node-a"), keystore.PrivateKeyName("node-b"), "psk/abc"} {
+		if _, err := r.Reconcile(ctx, request("kubewg", keystore.SecretName(name))); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	expected := map[string]bool{keystore.PrivateKeyName("node-a"): true, keystore.PrivateKeyName("node-b"): false, "psk/abc": true}
+	for name, exists := range expected {
+		err := c.Get(ctx, types.NamespacedName{Namespace: "kubewg", Name: keystore.SecretName(name)}, &corev1.Secret{})
+		if exists && err != nil {
+			t.Errorf("expected %s to be kept, got %v", name, err)
+		} else if !exists && !apierrors.IsNotFound(err) {
+			t.Errorf("expected %s to be deleted, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package operator runs the cluster-wide controllers on a controller-runtime
+// manager: the per-node agents configure WireGuard, the operator cleans up
+// after nodes that are gone and checks the custom resources.
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/kubewg-net/container/internal/keystore"
+	"github.com/kubewg-net/container/internal/metrics"
+	"github.com/kubewg-net/container/pkg/apis/kubewg/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+const (
+	DefaultLeaderElectionID = "kubewg-operator"
+	DefaultMetricsAddress   = ":8080"
+	DefaultProbeAddress     = ":8081"
+	DefaultWebhookPort      = 9443
+)
+
+// Options are the standard manager settings plus what the controllers need
+// to know about the agents' config.
+type Options struct {
+	LeaderElection          bool
+	LeaderElectionID        string
+	LeaderElectionNamespace string
+	// MetricsAddress and ProbeAddress are host:port, "0" turns them off
+	MetricsAddress string
+	ProbeAddress   string
+	// WebhookPort serves the validating webhooks, 0 turns them off
+	WebhookPort    int
+	WebhookCertDir string
+	// KeyNamespace is where the agents keep their keys in Secrets. Empty
+	// disables the Secret controller.
+	KeyNamespace string
+}
+
+// NewScheme registers the built-in types and the kubewg custom resources.
+func NewScheme() (*runtime.Scheme, error) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	return scheme, nil
+}
+
+// Run starts the manager and blocks until ctx is done. Only the elected
+// leader runs the controllers, every replica serves the webhooks.
+func Run(ctx context.Context, restConfig *rest.Config, opts *Options) error {
+	scheme, err := NewScheme()
+	if err != nil {
+		return fmt.Errorf("failed to build scheme: %w", err)
+	}
+
+	managerOptions := ctrl.Options{
+		Scheme:                  scheme,
+		LeaderElection:          opts.LeaderElection,
+		LeaderElectionID:        opts.LeaderElectionID,
+		LeaderElectionNamespace: opts.LeaderElectionNamespace,
+		Metrics:                 metricsserver.Options{BindAddress: opts.MetricsAddress},
+		HealthProbeBindAddress:  opts.ProbeAddress,
+	}
+	if opts.WebhookPort != 0 {
+		managerOptions.WebhookServer = webhook.NewServer(webhook.Options{
+			Port:    opts.WebhookPort,
+			CertDir: opts.WebhookCertDir,
+		})
+	}
+	if opts.KeyNamespace != "" {
+		// Only the keystore's Secrets are cached, not every Secret in the
+		// cluster
+		managerOptions.Cache.ByObject = map[client.Object]cache.ByObject{
+			&corev1.Secret{}: {
+				Namespaces: map[string]cache.Config{opts.KeyNamespace: {}},
+				Label:      labels.SelectorFromSet(labels.Set{keystore.SecretLabel: "true"}),
+			},
+		}
+	}
+	mgr, err := ctrl.NewManager(restConfig, managerOptions)
+	if err != nil {
+		return fmt.Errorf("failed to create manager: %w", err)
+	}
+
+	if err := (&NodeReconciler{Client: mgr.GetClient()}).SetupWithManager(mgr); err != nil {
+		return err
+	}
+	if err := (&PeerReconciler{Client: mgr.GetClient()}).SetupWithManager(mgr); err != nil {
+		return err
+	}
+	if opts.KeyNamespace != "" {
+		if err := (&SecretReconciler{Client: mgr.GetClient(), Namespace: opts.KeyNamespace}).SetupWithManager(mgr); err != nil {
+			return err
+		}
+	}
+	if opts.WebhookPort != 0 {
+		if err := SetupWebhooks(mgr); err != nil {
+			return err
+		}
+	}
+
+	if err := mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
+		return fmt.Errorf("failed to add health check: %w", err)
+	}
+	readyz := healthz.Ping
+	if opts.WebhookPort != 0 {
+		readyz = mgr.GetWebhookServer().StartedChecker()
+	}
+	if err := mgr.AddReadyzCheck("ready", readyz); err != nil {
+		return fmt.Errorf("failed to add ready check: %w", err)
+	}
+
+	var registered prometheus.AlreadyRegisteredError
+	if err := ctrlmetrics.Registry.Register(metrics.Leader); err != nil && !errors.As(err, &registered) {
+		return fmt.Errorf("failed to register leader metric: %w", err)
+	}
+	metrics.Leader.Set(0)
+	go func() {
+		// Without leader election every replica counts as elected, and a
+		// leader that loses its lease exits
+		select {
+		case <-mgr.Elected():
+			metrics.Leader.Set(1)
+		case <-ctx.Done():
+		}
+	}()
+
+	return mgr.Start(ctx)
+}
@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package operator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kubewg-net/container/internal/kube"
+	"github.com/kubewg-net/container/pkg/apis/kubewg/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// SetupWebhooks registers the validating webhooks, served under
+// /validate-kubewg-net-v1alpha1-wireguardpeer and
+// /validate-kubewg-net-v1alpha1-wireguardnetwork.
+func SetupWebhooks(mgr ctrl.Manager) error {
+	if err := ctrl.NewWebhookManagedBy(mgr).
+		For(&v1alpha1.WireGuardPeer{}).
+		WithValidator(&PeerValidator{Reader: mgr.GetAPIReader()}).
+		Complete(); err != nil {
+		return fmt.Errorf("failed to register WireGuardPeer webhook: %w", err)
+	}
+	if err := ctrl.NewWebhookManagedBy(mgr).
+		For(&v1alpha1.WireGuardNetwork{}).
+		WithValidator(&NetworkValidator{}).
+		Complete(); err != nil {
+		return fmt.Errorf("failed to register WireGuardNetwork webhook: %w", err)
+	}
+	return nil
+}
+
+// PeerValidator rejects WireGuardPeers the gateways couldn't configure and
+// warns about ones joining a network that doesn't exist (yet).
+type PeerValidator struct {
+	Reader client.Reader
+}
+
+func (v *PeerValidator) ValidateCreate(ctx context.Context, object runtime.Object) (admission.Warnings, error) {
+	return v.validate(ctx, object)
+}
+
+func (v *PeerValidator) ValidateUpdate(ctx context.Context, _, object runtime.Object) (admission.Warnings, error) {
+	return v.validate(ctx, object)
+}
+
+func (v *PeerValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *PeerValidator) validate(ctx context.Context, object runtime.Object) (admission.Warnings, error) {
+	peer, ok := object.(*v1alpha1.WireGuardPeer)
+	if !ok {
+		return nil, fmt.Errorf("%w: got %T", kube.ErrInvalidPeer, object)
+	}
+	if err := kube.ValidatePeer(peer); err != nil {
+		return nil, err
+	}
+
+	var network v1alpha1.WireGuardNetwork
+	err := v.Reader.Get(ctx, types.NamespacedName{Name: peer.Spec.Network}, &network)
+	if apierrors.IsNotFound(err) {
+		return admission.Warnings{fmt.Sprintf("WireGuardNetwork %s does not exist", peer.Spec.Network)}, nil
+	} else if err != nil {
+		// Whether the network exists is only worth a warning, not failing
+		// the request over
+		return admission.Warnings{fmt.Sprintf("failed to get WireGuardNetwork %s: %v", peer.Spec.Network, err)}, nil
+	}
+	return kube.PeerWarnings(peer, &network), nil
+}
+
+// NetworkValidator rejects WireGuardNetworks the members couldn't join.
+type NetworkValidator struct{}
+
+func (v *NetworkValidator) ValidateCreate(_ context.Context, object runtime.Object) (admission.Warnings, error) {
+	return nil, v.validate(object)
+}
+
+func (v *NetworkValidator) ValidateUpdate(_ context.Context, _, object runtime.Object) (admission.Warnings, error) {
+	return nil, v.validate(object)
+}
+
+func (v *NetworkValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *NetworkValidator) validate(object runtime.Object) error {
+	network, ok := object.(*v1alpha1.WireGuardNetwork)
+	if !ok {
+		return fmt.Errorf("%w: got %T", kube.ErrInvalidNetwork, object)
+	}
+	return kube.ValidateNetwork(network)
+}
@@ -0,0 +1,159 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package peers
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// BundleVersion is bumped whenever the bundle format changes incompatibly.
+const BundleVersion = 1
+
+var (
+	ErrBundleVersion = errors.New("unsupported bundle version")
+	ErrEndpointHost  = errors.New("endpoint has no host")
+	ErrEndpointPort  = errors.New("endpoint port must be between 1 and 65535")
+)
+
+// Bundle is a portable export of peers for backups and migrations between
+// deployments. It never carries private keys, and preshared keys only when
+// asked for explicitly.
+type Bundle struct {
+	Version    int                    `json:"version"`
+	ExportedAt time.Time              `json:"exported_at"`
+	Peers      []config.WireGuardPeer `json:"peers"`
+}
+
+// ImportResult lists which peers of a bundle were added and which already
+// existed.
+type ImportResult struct {
+	Imported []string `json:"imported"`
+	Skipped  []string `json:"skipped"`
+}
+
+// Export bundles every registered peer.
+func (r *Registry) Export(includePresharedKeys bool) *Bundle {
+	list := r.List()
+	if !includePresharedKeys {
+		for i := range list {
+			list[i].PresharedKey = ""
+		}
+	}
+
+	return &Bundle{
+		Version:    BundleVersion,
+		ExportedAt: time.Now().UTC(),
+		Peers:      list,
+	}
+}
+
+// Validate checks the bundle before anything is imported from it, so a bad
+// entry doesn't leave a half-imported bundle behind.
+func (b *Bundle) Validate() error {
+	if b.Version != BundleVersion {
+		return fmt.Errorf("%w: %d", ErrBundleVersion, b.Version)
+	}
+
+	for i, peer := range b.Peers {
+		if _, err := wgtypes.ParseKey(peer.PublicKey); err != nil {
+			return fmt.Errorf("peer %d has an invalid public key: %w", i, err)
+		}
+		if peer.PresharedKey != "" {
+			if _, err := wgtypes.ParseKey(peer.PresharedKey); err != nil {
+				return fmt.Errorf("peer %d has an invalid preshared key: %w", i, err)
+			}
+		}
+		for _, cidr := range peer.AllowedIPs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("peer %d has an invalid allowed IP: %w", i, err)
+			}
+		}
+		if peer.Endpoint != "" {
+			if err := validateEndpoint(peer.Endpoint); err != nil {
+				return fmt.Errorf("peer %d has an invalid endpoint %q: %w", i, peer.Endpoint, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateEndpoint checks that endpoint is a host, which is resolved later,
+// and a port.
+func validateEndpoint(endpoint string) error {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return err
+	}
+	if host == "" {
+		return ErrEndpointHost
+	}
+	if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+		return fmt.Errorf("%w: %q", ErrEndpointPort, port)
+	}
+	return nil
+}
+
+// Import adds the peers of a bundle as runtime peers, skipping any that are
+// already registered. The imported peers are saved to the store, if the
+// registry has one.
+func (r *Registry) Import(bundle *Bundle) (*ImportResult, error) {
+	if err := bundle.Validate(); err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{
+		Imported: []string{},
+		Skipped:  []string{},
+	}
+	for _, peer := range bundle.Peers {
+		if err := r.Add(peer); errors.Is(err, ErrPeerExists) {
+			result.Skipped = append(result.Skipped, peer.PublicKey)
+			continue
+		} else if err != nil {
+			r.markImported(result.Imported)
+			return result, err
+		}
+		result.Imported = append(result.Imported, peer.PublicKey)
+	}
+	r.markImported(result.Imported)
+	return result, nil
+}
+
+func (r *Registry) markImported(publicKeys []string) {
+	if len(publicKeys) == 0 {
+		return
+	}
+	r.mu.Lock()
+	for _, publicKey := range publicKeys {
+		// Removed again while the rest of the bundle was imported
+		if _, ok := r.dynamic[publicKey]; ok {
+			r.imported[publicKey] = struct{}{}
+		}
+	}
+	r.mu.Unlock()
+	r.saveImports()
+}
@@ -0,0 +1,67 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package peers_test
+
+import (
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/peers"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestBundleValidate(t *testing.T) {
+	t.Parallel()
+
+	key, err := wgtypes.GenerateKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	publicKey := key.PublicKey().String()
+
+	tests := []struct {
+		name  string
+		peer  config.WireGuardPeer
+		valid bool
+	}{
+		{name: "valid", peer: config.WireGuardPeer{PublicKey: publicKey, Endpoint: "vpn.example.com:51820", AllowedIPs: []string{"10.0.0.2/32", "fd00::2/128"}}, valid: true},
+		{name: "no endpoint", peer: config.WireGuardPeer{PublicKey: publicKey, AllowedIPs: []string{"10.0.0.2/32"}}, valid: true},
+		{name: "bare address", peer: config.WireGuardPeer{PublicKey: publicKey, AllowedIPs: []string{"10.0.0.2"}}},
+		{name: "bad address", peer: config.WireGuardPeer{PublicKey: publicKey, AllowedIPs: []string{"10.0.0.300/32"}}},
+		{name: "endpoint without port", peer: config.WireGuardPeer{PublicKey: publicKey, Endpoint: "vpn.example.com"}},
+		{name: "endpoint without host", peer: config.WireGuardPeer{PublicKey: publicKey, Endpoint: ":51820"}},
+		{name: "endpoint port out of range", peer: config.WireGuardPeer{PublicKey: publicKey, Endpoint: "192.0.2.1:70000"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			bundle := &peers.Bundle{Version: peers.BundleVersion, Peers: []config.WireGuardPeer{tt.peer}}
+			err := bundle.Validate()
+			if tt.valid && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("expected an error, got nil")
+			}
+		})
+	}
+}
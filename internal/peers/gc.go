@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package peers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+// BackingFunc reports whether the object a runtime peer was created from
+// still exists. owned is false for peers that didn't come from it.
+type BackingFunc func(peer config.WireGuardPeer) (exists, owned bool)
+
+// Collector removes runtime peers whose backing object has been gone for
+// longer than a TTL. Their source normally removes them along with the
+// object, the collector catches the removals it missed, e.g. while it
+// wasn't running. Peers no BackingFunc owns are never collected.
+type Collector struct {
+	registry *Registry
+	ttl      time.Duration
+	interval time.Duration
+	mu       sync.Mutex
+	backings []BackingFunc
+	// missing is when each peer was first seen without its object
+	missing map[string]time.Time
+}
+
+func NewCollector(registry *Registry, ttl, interval time.Duration) *Collector {
+	return &Collector{
+		registry: registry,
+		ttl:      ttl,
+		interval: interval,
+		missing:  make(map[string]time.Time),
+	}
+}
+
+// Watch adds a source of runtime peers.
+func (c *Collector) Watch(fn BackingFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.backings = append(c.backings, fn)
+}
+
+// Start collects every interval until ctx is done.
+func (c *Collector) Start(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		c.Collect(time.Now())
+	}
+}
+
+// Collect removes the peers whose object has been missing for the TTL as
+// of now and returns them.
+func (c *Collector) Collect(now time.Time) []config.WireGuardPeer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var collected []config.WireGuardPeer
+	current := make(map[string]struct{})
+	for _, peer := range c.registry.Dynamic() {
+		if !c.gone(peer) {
+			continue
+		}
+		current[peer.PublicKey] = struct{}{}
+		since, ok := c.missing[peer.PublicKey]
+		if !ok {
+			c.missing[peer.PublicKey] = now
+			continue
+		}
+		if now.Sub(since) < c.ttl {
+			continue
+		}
+
+		if _, err := c.registry.Remove(peer.PublicKey); err != nil && !errors.Is(err, ErrPeerNotFound) {
+			slog.Warn("Failed to remove stale peer", "name", peer.Name, "public_key", peer.PublicKey, "error", err.Error())
+			continue
+		}
+		slog.Info("Removed stale peer", "name", peer.Name, "public_key", peer.PublicKey, "missing_for", now.Sub(since).String())
+		delete(current, peer.PublicKey)
+		collected = append(collected, peer)
+	}
+
+	// Peers that came back or were removed otherwise start over
+	for publicKey := range c.missing {
+		if _, ok := current[publicKey]; !ok {
+			delete(c.missing, publicKey)
+		}
+	}
+	return collected
+}
+
+// gone reports whether a source owns peer and its object doesn't exist.
+// Callers hold c.mu.
+func (c *Collector) gone(peer config.WireGuardPeer) bool {
+	for _, fn := range c.backings {
+		if exists, owned := fn(peer); owned {
+			return !exists
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package peers_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/peers"
+)
+
+func TestCollector(t *testing.T) {
+	t.Parallel()
+
+	registry := peers.NewRegistry([]config.WireGuardPeer{{Name: "static", PublicKey: "key-static"}})
+	for _, peer := range []config.WireGuardPeer{
+		{Name: "kept", PublicKey: "key-kept", Metadata: map[string]string{"source": "watched"}},
+		{Name: "gone", PublicKey: "key-gone", Metadata: map[string]string{"source": "watched"}},
+		{Name: "manual", PublicKey: "key-manual"},
+	} {
+		if err := registry.Add(peer); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	exists := map[string]bool{"key-kept": true}
+	collector := peers.NewCollector(registry, time.Minute, time.Second)
+	collector.Watch(func(peer config.WireGuardPeer) (bool, bool) {
+		if peer.Metadata["source"] != "watched" {
+			return false, false
+		}
+		return exists[peer.PublicKey], true
+	})
+
+	start := time.Now()
+	if got := collector.Collect(start); len(got) != 0 {
+		t.Fatalf("expected nothing collected on first sight, got %v", got)
+	}
+	if got := collector.Collect(start.Add(30 * time.Second)); len(got) != 0 {
+		t.Fatalf("expected nothing collected within the TTL, got %v", got)
+	}
+
+	got := collector.Collect(start.Add(time.Minute))
+	if len(got) != 1 || got[0].PublicKey != "key-gone" {
+		t.Fatalf("expected key-gone to be collected, got %v", got)
+	}
+	if _, ok := registry.Get("key-gone"); ok {
+		t.Error("expected key-gone to be removed from the registry")
+	}
+	for _, publicKey := range []string{"key-static", "key-kept", "key-manual"} {
+		if _, ok := registry.Get(publicKey); !ok {
+			t.Errorf("expected %s to be kept", publicKey)
+		}
+	}
+
+	// An object that comes back resets the clock
+	delete(exists, "key-kept")
+	collector.Collect(start.Add(2 * time.Minute))
+	exists["key-kept"] = true
+	collector.Collect(start.Add(150 * time.Second))
+	delete(exists, "key-kept")
+	if got := collector.Collect(start.Add(3 * time.Minute)); len(got) != 0 {
+		t.Errorf("expected the returned object to restart the TTL, got %v", got)
+	}
+}
@@ -0,0 +1,241 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package peers keeps track of the peers the device should have, both the
+// ones from the config file and the ones added at runtime.
+package peers
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/state"
+)
+
+var (
+	ErrPeerExists   = errors.New("peer already exists")
+	ErrPeerNotFound = errors.New("peer not found")
+	ErrStaticPeer   = errors.New("peer is configured in the config file")
+	ErrPeerNotOwned = errors.New("peer belongs to another source")
+)
+
+// OwnsFunc reports whether a runtime peer belongs to the caller.
+type OwnsFunc func(peer config.WireGuardPeer) bool
+
+// ChangeFunc is called after a runtime peer was added, replaced or removed.
+type ChangeFunc func(peer config.WireGuardPeer, removed bool)
+
+type Registry struct {
+	mu        sync.RWMutex
+	static    map[string]config.WireGuardPeer
+	dynamic   map[string]config.WireGuardPeer
+	listeners []ChangeFunc
+
+	// imported are the runtime peers added by Import, which nothing but the
+	// store recreates after a restart
+	imported map[string]struct{}
+	store    state.Store
+	saveMu   sync.Mutex
+}
+
+func NewRegistry(static []config.WireGuardPeer) *Registry {
+	registry := &Registry{
+		static:   make(map[string]config.WireGuardPeer, len(static)),
+		dynamic:  make(map[string]config.WireGuardPeer),
+		imported: make(map[string]struct{}),
+	}
+	for _, peer := range static {
+		registry.static[peer.PublicKey] = peer
+	}
+	return registry
+}
+
+// OnChange registers fn to be told about every runtime peer change.
+func (r *Registry) OnChange(fn ChangeFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.listeners = append(r.listeners, fn)
+}
+
+func (r *Registry) notify(peer config.WireGuardPeer, removed bool) {
+	r.mu.RLock()
+	listeners := r.listeners
+	r.mu.RUnlock()
+
+	for _, fn := range listeners {
+		fn(peer, removed)
+	}
+}
+
+// List returns every peer, sorted by public key.
+func (r *Registry) List() []config.WireGuardPeer {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]config.WireGuardPeer, 0, len(r.static)+len(r.dynamic))
+	for _, peer := range r.static {
+		list = append(list, peer)
+	}
+	for _, peer := range r.dynamic {
+		list = append(list, peer)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].PublicKey < list[j].PublicKey
+	})
+	return list
+}
+
+// Dynamic returns the runtime peers, sorted by public key.
+func (r *Registry) Dynamic() []config.WireGuardPeer {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]config.WireGuardPeer, 0, len(r.dynamic))
+	for _, peer := range r.dynamic {
+		list = append(list, peer)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].PublicKey < list[j].PublicKey
+	})
+	return list
+}
+
+// Get looks up a peer by public key.
+func (r *Registry) Get(publicKey string) (config.WireGuardPeer, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if peer, ok := r.static[publicKey]; ok {
+		return peer, true
+	}
+	peer, ok := r.dynamic[publicKey]
+	return peer, ok
+}
+
+// Add registers a runtime peer.
+func (r *Registry) Add(peer config.WireGuardPeer) error {
+	if err := r.add(peer); err != nil {
+		return err
+	}
+	r.notify(peer, false)
+	return nil
+}
+
+func (r *Registry) add(peer config.WireGuardPeer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.static[peer.PublicKey]; ok {
+		return fmt.Errorf("%w: %s", ErrPeerExists, peer.PublicKey)
+	}
+	if _, ok := r.dynamic[peer.PublicKey]; ok {
+		return fmt.Errorf("%w: %s", ErrPeerExists, peer.PublicKey)
+	}
+	r.dynamic[peer.PublicKey] = peer
+	return nil
+}
+
+// Put registers a runtime peer, replacing any runtime peer with the same
+// public key. Listeners are only told when the peer actually changed.
+func (r *Registry) Put(peer config.WireGuardPeer) error {
+	return r.PutOwned(peer, nil)
+}
+
+// PutOwned is Put for a source that must not take over the peers of
+// another: a runtime peer with the same public key is only replaced when
+// owns accepts it. A nil owns accepts every peer.
+func (r *Registry) PutOwned(peer config.WireGuardPeer, owns OwnsFunc) error {
+	changed, err := r.put(peer, owns)
+	if err != nil {
+		return err
+	}
+	if changed {
+		if r.isImported(peer.PublicKey) {
+			r.saveImports()
+		}
+		r.notify(peer, false)
+	}
+	return nil
+}
+
+func (r *Registry) put(peer config.WireGuardPeer, owns OwnsFunc) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.static[peer.PublicKey]; ok {
+		return false, fmt.Errorf("%w: %s", ErrStaticPeer, peer.PublicKey)
+	}
+	existing, ok := r.dynamic[peer.PublicKey]
+	if ok && owns != nil && !owns(existing) {
+		return false, fmt.Errorf("%w: %s", ErrPeerNotOwned, peer.PublicKey)
+	}
+	r.dynamic[peer.PublicKey] = peer
+	return !ok || !reflect.DeepEqual(existing, peer), nil
+}
+
+// Remove unregisters a runtime peer. Peers from the config file can't be
+// removed this way.
+func (r *Registry) Remove(publicKey string) (config.WireGuardPeer, error) {
+	return r.RemoveOwned(publicKey, nil)
+}
+
+// RemoveOwned is Remove for a source that must not drop the peers of
+// another, the peer is only removed when owns accepts it.
+func (r *Registry) RemoveOwned(publicKey string, owns OwnsFunc) (config.WireGuardPeer, error) {
+	peer, imported, err := r.remove(publicKey, owns)
+	if err != nil {
+		return peer, err
+	}
+	if imported {
+		r.saveImports()
+	}
+	r.notify(peer, true)
+	return peer, nil
+}
+
+func (r *Registry) remove(publicKey string, owns OwnsFunc) (config.WireGuardPeer, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.static[publicKey]; ok {
+		return config.WireGuardPeer{}, false, fmt.Errorf("%w: %s", ErrStaticPeer, publicKey)
+	}
+	peer, ok := r.dynamic[publicKey]
+	if !ok {
+		return config.WireGuardPeer{}, false, fmt.Errorf("%w: %s", ErrPeerNotFound, publicKey)
+	}
+	if owns != nil && !owns(peer) {
+		return config.WireGuardPeer{}, false, fmt.Errorf("%w: %s", ErrPeerNotOwned, publicKey)
+	}
+	delete(r.dynamic, publicKey)
+	_, imported := r.imported[publicKey]
+	delete(r.imported, publicKey)
+	return peer, imported, nil
+}
+
+func (r *Registry) isImported(publicKey string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.imported[publicKey]
+	return ok
+}
@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package peers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/state"
+)
+
+const (
+	// importsName is the document the imported peers are kept in
+	importsName = "imports"
+	// importsVersion is bumped on incompatible changes to imports
+	importsVersion = 1
+
+	saveTimeout = 10 * time.Second
+)
+
+var (
+	ErrInvalidImports = errors.New("invalid imported peers")
+	ErrImportsVersion = errors.New("unsupported imported peers version")
+)
+
+type imports struct {
+	Version int                    `json:"version"`
+	Peers   []config.WireGuardPeer `json:"peers"`
+}
+
+// UseStore re-registers the peers imported before a restart from store and
+// saves every later import there. Calling it again adds the peers of a
+// state import.
+func (r *Registry) UseStore(ctx context.Context, store state.Store) error {
+	data, err := store.Load(ctx, importsName)
+	if err != nil && !errors.Is(err, state.ErrNotFound) {
+		return err
+	}
+
+	if data != nil {
+		var saved imports
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidImports, err)
+		}
+		if saved.Version != importsVersion {
+			return fmt.Errorf("%w: %d", ErrImportsVersion, saved.Version)
+		}
+		for _, peer := range saved.Peers {
+			// The peer may have moved into the config file since
+			if err := r.Add(peer); errors.Is(err, ErrPeerExists) {
+				continue
+			} else if err != nil {
+				return fmt.Errorf("failed to restore imported peer %s: %w", peer.PublicKey, err)
+			}
+			r.mu.Lock()
+			r.imported[peer.PublicKey] = struct{}{}
+			r.mu.Unlock()
+		}
+		slog.Info("Restored imported peers", "peers", len(saved.Peers))
+	}
+
+	r.mu.Lock()
+	r.store = store
+	r.mu.Unlock()
+	if data != nil {
+		// Write back the peers registered before a repeated call
+		r.saveImports()
+	}
+	return nil
+}
+
+// saveImports writes the imported peers to the store, if there is one. A
+// failed write is logged, the next change writes them all again.
+func (r *Registry) saveImports() {
+	// Serialized so an older list can't overwrite a newer one
+	r.saveMu.Lock()
+	defer r.saveMu.Unlock()
+
+	r.mu.RLock()
+	store := r.store
+	saved := imports{Version: importsVersion, Peers: make([]config.WireGuardPeer, 0, len(r.imported))}
+	for publicKey := range r.imported {
+		saved.Peers = append(saved.Peers, r.dynamic[publicKey])
+	}
+	r.mu.RUnlock()
+	sort.Slice(saved.Peers, func(i, j int) bool {
+		return saved.Peers[i].PublicKey < saved.Peers[j].PublicKey
+	})
+	if store == nil {
+		return
+	}
+
+	data, err := json.Marshal(saved)
+	if err != nil {
+		slog.Error("Failed to encode imported peers", "error", err.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), saveTimeout)
+	defer cancel()
+	if err := store.Save(ctx, importsName, data); err != nil {
+		slog.Error("Failed to save imported peers", "error", err.Error())
+	}
+}
@@ -0,0 +1,87 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package peers_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/peers"
+	"github.com/kubewg-net/container/internal/state"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestImportsSurviveRestart(t *testing.T) {
+	t.Parallel()
+
+	keys := make([]string, 3)
+	for i := range keys {
+		key, err := wgtypes.GeneratePrivateKey()
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		keys[i] = key.PublicKey().String()
+	}
+
+	store := state.NewMemory()
+	registry := peers.NewRegistry(nil)
+	if err := registry.UseStore(context.Background(), store); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	_, err := registry.Import(&peers.Bundle{
+		Version: peers.BundleVersion,
+		Peers: []config.WireGuardPeer{
+			{Name: "kept", PublicKey: keys[0], AllowedIPs: []string{"10.0.0.2/32"}},
+			{Name: "removed", PublicKey: keys[1]},
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := registry.Remove(keys[1]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// Runtime peers from other sources bring themselves back
+	if err := registry.Add(config.WireGuardPeer{Name: "watched", PublicKey: keys[2]}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	restarted := peers.NewRegistry(nil)
+	if err := restarted.UseStore(context.Background(), store); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	list := restarted.Dynamic()
+	if len(list) != 1 || list[0].Name != "kept" || len(list[0].AllowedIPs) != 1 {
+		t.Fatalf("expected only the kept import, got %+v", list)
+	}
+
+	// Removing a restored peer removes it from the store too
+	if _, err := restarted.Remove(keys[0]); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	again := peers.NewRegistry(nil)
+	if err := again.UseStore(context.Background(), store); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if list := again.Dynamic(); len(list) != 0 {
+		t.Errorf("expected no peers, got %+v", list)
+	}
+}
@@ -0,0 +1,194 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package pprof
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime/pprof"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+// capturePrefix marks the files a Capturer owns in its directory, only
+// those count against and are removed by the retention
+const capturePrefix = "kubewg-"
+
+var ErrCaptureRunning = errors.New("a capture is already running")
+
+// Capturer writes CPU profiles and goroutine dumps to a directory.
+type Capturer struct {
+	config *config.Capture
+	mu     sync.Mutex
+}
+
+func NewCapturer(config *config.Capture) *Capturer {
+	return &Capturer{config: config}
+}
+
+// Capture profiles the CPU for duration, the configured seconds when 0,
+// then writes the profile next to a goroutine dump and returns their paths.
+// The CPU profile is left out while another one is running, such as one
+// pulled from the pprof server. Only one capture runs at a time.
+func (c *Capturer) Capture(ctx context.Context, duration time.Duration) ([]string, error) {
+	if !c.mu.TryLock() {
+		return nil, ErrCaptureRunning
+	}
+	defer c.mu.Unlock()
+
+	if duration == 0 {
+		duration = time.Duration(c.config.Seconds) * time.Second
+	}
+	if err := os.MkdirAll(c.config.Directory, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create capture directory: %w", err)
+	}
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+
+	var files []string
+	cpu, err := c.captureCPU(ctx, filepath.Join(c.config.Directory, capturePrefix+"cpu-"+stamp+".pprof"), duration)
+	if err != nil {
+		slog.Warn("Skipping CPU profile", "error", err.Error())
+	} else {
+		files = append(files, cpu)
+	}
+
+	goroutines := filepath.Join(c.config.Directory, capturePrefix+"goroutines-"+stamp+".txt")
+	if err := writeFile(goroutines, func(f *os.File) error {
+		return pprof.Lookup("goroutine").WriteTo(f, 2)
+	}); err != nil {
+		return files, err
+	}
+	files = append(files, goroutines)
+
+	if err := c.prune(files); err != nil {
+		slog.Warn("Failed to remove old captures", "error", err.Error())
+	}
+	slog.Info("Captured profiles", "files", files)
+	return files, nil
+}
+
+func (c *Capturer) captureCPU(ctx context.Context, path string, duration time.Duration) (string, error) {
+	err := writeFile(path, func(f *os.File) error {
+		if err := pprof.StartCPUProfile(f); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(duration):
+		}
+		pprof.StopCPUProfile()
+		return nil
+	})
+	if err != nil {
+		_ = os.Remove(path)
+		return "", err
+	}
+	return path, nil
+}
+
+func writeFile(path string, write func(*os.File) error) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// prune removes the oldest captures until the rest fit in MaxBytes, never
+// the ones just written.
+func (c *Capturer) prune(keep []string) error {
+	entries, err := os.ReadDir(c.config.Directory)
+	if err != nil {
+		return err
+	}
+	type capture struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var captures []capture
+	var total int64
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), capturePrefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		total += info.Size()
+		path := filepath.Join(c.config.Directory, entry.Name())
+		if !slices.Contains(keep, path) {
+			captures = append(captures, capture{path, info.Size(), info.ModTime()})
+		}
+	}
+	sort.Slice(captures, func(i, j int) bool { return captures[i].modTime.Before(captures[j].modTime) })
+
+	for _, old := range captures {
+		if total <= int64(c.config.MaxBytes) {
+			break
+		}
+		if err := os.Remove(old.path); err != nil {
+			return err
+		}
+		total -= old.size
+	}
+	return nil
+}
+
+// Listen captures on every SIGUSR1 until ctx is done. A signal arriving
+// during a capture is ignored.
+func (c *Capturer) Listen(ctx context.Context) {
+	if captureSignal == nil {
+		slog.Warn("Capturing profiles on a signal is not supported on this platform")
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, captureSignal)
+	defer signal.Stop(signals)
+
+	slog.Info("Capturing profiles on SIGUSR1", "directory", c.config.Directory)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			go func() {
+				if _, err := c.Capture(ctx, 0); err != nil {
+					slog.Warn("Failed to capture profiles", "error", err.Error())
+				}
+			}()
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package pprof_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/pprof"
+)
+
+func TestCapture(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	// Neither a leftover capture nor a file kubewg doesn't own may stop the
+	// new capture from being kept
+	old := filepath.Join(dir, "kubewg-goroutines-20000101T000000Z.txt")
+	if err := os.WriteFile(old, make([]byte, 4096), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(old, past, past); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(other, make([]byte, 4096), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	capturer := pprof.NewCapturer(&config.Capture{Directory: dir, Seconds: 1, MaxBytes: 1})
+	files, err := capturer.Capture(context.Background(), 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("failed to capture: %v", err)
+	}
+	if len(files) == 0 || !strings.HasSuffix(files[len(files)-1], ".txt") {
+		t.Fatalf("expected at least the goroutine dump, got %v", files)
+	}
+	for _, file := range files {
+		if _, err := os.Stat(file); err != nil {
+			t.Errorf("expected %s to be kept, got %v", file, err)
+		}
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("expected the old capture to be removed, got %v", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("expected other files to be left alone, got %v", err)
+	}
+}
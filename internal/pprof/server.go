@@ -23,12 +23,17 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/http/pprof"
+	"runtime"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/httplimit"
+	"github.com/kubewg-net/container/internal/metrics"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -46,29 +51,41 @@ func NewServer(config *config.PProf) *Server {
 	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
 	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
 	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
-	mux.HandleFunc("/debug/pprof/allocs", pprof.Handler("allocs").ServeHTTP)
-	mux.HandleFunc("/debug/pprof/block", pprof.Handler("block").ServeHTTP)
+	mux.Handle("/debug/pprof/allocs", delta(pprof.Handler("allocs"), config.MaxDelta.Std()))
+	mux.Handle("/debug/pprof/block", delta(pprof.Handler("block"), config.MaxDelta.Std()))
 	mux.HandleFunc("/debug/pprof/goroutine", pprof.Handler("goroutine").ServeHTTP)
-	mux.HandleFunc("/debug/pprof/heap", pprof.Handler("heap").ServeHTTP)
-	mux.HandleFunc("/debug/pprof/mutex", pprof.Handler("mutex").ServeHTTP)
+	mux.Handle("/debug/pprof/heap", delta(pprof.Handler("heap"), config.MaxDelta.Std()))
+	mux.Handle("/debug/pprof/mutex", delta(pprof.Handler("mutex"), config.MaxDelta.Std()))
 	mux.HandleFunc("/debug/pprof/threadcreate", pprof.Handler("threadcreate").ServeHTTP)
 
+	handler := metrics.Instrument("pprof", httplimit.New(&config.Limits).Handler(mux))
+
 	return &Server{
 		ipv4Server: &http.Server{
 			Addr:              fmt.Sprintf("%s:%d", config.IPV4Host, config.Port),
 			ReadHeaderTimeout: 5 * time.Second,
-			Handler:           mux,
+			Handler:           handler,
 		},
 		ipv6Server: &http.Server{
 			Addr:              fmt.Sprintf("[%s]:%d", config.IPV6Host, config.Port),
 			ReadHeaderTimeout: 5 * time.Second,
-			Handler:           mux,
+			Handler:           handler,
 		},
 		config: config,
 	}
 }
 
-func (s *Server) Start() {
+func (s *Server) Start(ctx context.Context) {
+	// The rates are process wide, they are reset when the server stops
+	runtime.SetBlockProfileRate(s.config.BlockProfileRate)
+	runtime.SetMutexProfileFraction(s.config.MutexProfileFraction)
+
+	baseContext := func(net.Listener) context.Context {
+		return ctx
+	}
+	s.ipv4Server.BaseContext = baseContext
+	s.ipv6Server.BaseContext = baseContext
+
 	waitGrp := sync.WaitGroup{}
 	waitGrp.Add(1)
 	go func() {
@@ -91,11 +108,14 @@ func (s *Server) Start() {
 	waitGrp.Wait()
 }
 
-func (s *Server) Stop() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
+// Stop gives in-flight requests the shutdown timeout to finish before
+// their connections are closed.
+func (s *Server) Stop(ctx context.Context) error {
 	s.stopped = true
+	runtime.SetBlockProfileRate(0)
+	runtime.SetMutexProfileFraction(0)
+	ctx, cancel := context.WithTimeout(ctx, s.config.ShutdownTimeout.Std())
+	defer cancel()
 
 	errGrp := errgroup.Group{}
 	if s.ipv4Server != nil {
@@ -109,5 +129,30 @@ func (s *Server) Stop() error {
 		})
 	}
 
-	return errGrp.Wait()
+	if err := errGrp.Wait(); err != nil {
+		for _, server := range []*http.Server{s.ipv4Server, s.ipv6Server} {
+			if server != nil {
+				_ = server.Close()
+			}
+		}
+		return fmt.Errorf("closed connections still open after the shutdown timeout: %w", err)
+	}
+	return nil
+}
+
+// delta serves the profile as it is, or with ?seconds=N what changed over
+// the next N seconds, rejecting N above maxDelta so a request can't hold a
+// connection open for hours.
+func delta(profile http.Handler, maxDelta time.Duration) http.Handler {
+	maxSeconds := uint64(maxDelta / time.Second)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if raw := r.FormValue("seconds"); raw != "" {
+			seconds, err := strconv.ParseUint(raw, 10, 32)
+			if err != nil || seconds == 0 || seconds > maxSeconds {
+				http.Error(w, fmt.Sprintf("seconds must be between 1 and %d", maxSeconds), http.StatusBadRequest)
+				return
+			}
+		}
+		profile.ServeHTTP(w, r)
+	})
 }
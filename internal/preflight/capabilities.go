@@ -0,0 +1,104 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package preflight
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Capability is a Linux capability number as in linux/capability.h
+type Capability uint
+
+const (
+	CapNetBindService Capability = 10
+	CapNetAdmin       Capability = 12
+	CapNetRaw         Capability = 13
+)
+
+func (c Capability) String() string {
+	switch c {
+	case CapNetBindService:
+		return "CAP_NET_BIND_SERVICE"
+	case CapNetAdmin:
+		return "CAP_NET_ADMIN"
+	case CapNetRaw:
+		return "CAP_NET_RAW"
+	default:
+		return fmt.Sprintf("CAP_%d", uint(c))
+	}
+}
+
+const procStatus = "/proc/self/status"
+
+var ErrNoCapabilities = errors.New("no CapEff line in " + procStatus)
+
+// Process is the privilege state of the running process
+type Process struct {
+	UID        int
+	Effective  uint64
+	NoNewPrivs bool
+}
+
+// Has reports whether capability is in the effective set.
+func (p Process) Has(capability Capability) bool {
+	return p.Effective&(1<<capability) != 0
+}
+
+// CurrentProcess reads the privilege state of this process from procfs.
+func CurrentProcess() (Process, error) {
+	file, err := os.Open(procStatus)
+	if err != nil {
+		return Process{}, err
+	}
+	defer file.Close()
+
+	process := Process{UID: os.Getuid()}
+	found := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "CapEff":
+			process.Effective, err = strconv.ParseUint(value, 16, 64)
+			if err != nil {
+				return Process{}, fmt.Errorf("invalid CapEff %q: %w", value, err)
+			}
+			found = true
+		case "NoNewPrivs":
+			process.NoNewPrivs = value == "1"
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Process{}, err
+	}
+	if !found {
+		return Process{}, ErrNoCapabilities
+	}
+	return process, nil
+}
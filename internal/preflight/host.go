@@ -0,0 +1,194 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package preflight
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/vishvananda/netlink"
+)
+
+// Host checks the machine rather than the process: the kernel module,
+// /dev/net/tun, sysctls and the listen port. They only run from doctor,
+// since at startup the interface itself is the better test.
+type Host struct {
+	// Root prefixes the /sys, /proc, /dev and /lib/modules paths
+	Root string
+	// Release is the kernel release, the running one when empty
+	Release string
+	// Command runs iptables to tell its backend, the binary on PATH when
+	// nil
+	Command func(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// Run checks the host against what config needs.
+func (h Host) Run(config *config.Config) []Result {
+	if !config.WireGuard.Enabled {
+		return nil
+	}
+	results := []Result{h.checkKernelModule(), h.checkTun()}
+	if config.WireGuard.Relay.Serve || config.WireGuard.ExitNode.Enabled {
+		results = append(results, h.checkForwarding()...)
+	}
+	if config.WireGuard.ListenPort != 0 {
+		results = append(results, checkListenPort(config.WireGuard.ListenPort))
+	} else if ports := config.WireGuard.ListenPortRange; ports.Min != 0 {
+		results = append(results, checkListenPortRange(ports))
+	}
+	return results
+}
+
+func (h Host) path(path string) string {
+	return filepath.Join(h.Root, path)
+}
+
+// checkKernelModule looks for WireGuard loaded, built in, or available to
+// be loaded when the interface is first created.
+func (h Host) checkKernelModule() Result {
+	result := Result{Name: "kernel_module", OK: true}
+	switch h.WireGuardModule() {
+	case ModuleLoaded:
+		result.Message = "wireguard is loaded"
+	case ModuleBuiltin:
+		result.Message = "wireguard is built in"
+	case ModuleAvailable:
+		result.Message = "wireguard is available in modules.dep and loads when the interface is created"
+	default:
+		result.OK = false
+		result.Message = fmt.Sprintf("wireguard is neither loaded nor found in %s, it needs Linux 5.6 or later or the wireguard module installed", h.modulesDir())
+	}
+	return result
+}
+
+// WireGuardModule is the state of the wireguard module, which also counts as built in
+// when its generic netlink family is registered: built-in modules without
+// parameters don't show up under /sys/module.
+func (h Host) WireGuardModule() ModuleState {
+	state := h.module("wireguard")
+	if state == ModuleMissing && h.Root == "" {
+		if _, err := netlink.GenlFamilyGet("wireguard"); err == nil {
+			return ModuleBuiltin
+		}
+	}
+	return state
+}
+
+// module finds the kernel module name loaded, built in, or in the module
+// index of the running release.
+func (h Host) module(name string) ModuleState {
+	if _, err := os.Stat(h.path("/sys/module/" + name)); err == nil {
+		return ModuleLoaded
+	}
+	for _, index := range []struct {
+		file  string
+		state ModuleState
+	}{{"modules.builtin", ModuleBuiltin}, {"modules.dep", ModuleAvailable}} {
+		data, err := os.ReadFile(filepath.Join(h.modulesDir(), index.file))
+		if err == nil && bytes.Contains(data, []byte("/"+name+".ko")) {
+			return index.state
+		}
+	}
+	return ModuleMissing
+}
+
+func (h Host) modulesDir() string {
+	return h.path(filepath.Join("/lib/modules", h.release()))
+}
+
+func (h Host) release() string {
+	if h.Release != "" {
+		return h.Release
+	}
+	return kernelRelease()
+}
+
+// checkTun reports /dev/net/tun, which only userspace implementations need,
+// so it never fails.
+func (h Host) checkTun() Result {
+	info, err := os.Stat(h.path("/dev/net/tun"))
+	if err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		return Result{Name: "tun", OK: true, Message: "/dev/net/tun is present"}
+	}
+	return Result{Name: "tun", OK: true, Message: "/dev/net/tun is missing, which only matters for userspace WireGuard"}
+}
+
+// checkForwarding makes sure the forwarding sysctls are on or can be
+// turned on at startup.
+func (h Host) checkForwarding() []Result {
+	var results []Result
+	for _, name := range []string{"net.ipv4.ip_forward", "net.ipv6.conf.all.forwarding"} {
+		path := h.path("/proc/sys/" + strings.ReplaceAll(name, ".", "/"))
+		value, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			// No IPv6 on this host
+			continue
+		}
+		result := Result{Name: name, OK: true}
+		switch {
+		case err != nil:
+			result.OK = false
+			result.Message = fmt.Sprintf("failed to read %s: %s", path, err)
+		case strings.TrimSpace(string(value)) == "1":
+			result.Message = "is on"
+		case access(path, writeAccess) == nil:
+			result.Message = "is off and will be turned on at startup"
+		default:
+			result.OK = false
+			result.Message = "is off and /proc/sys is read-only, set " + name + "=1 on the host"
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// checkListenPort binds the listen port the way the interface will. It
+// can't tell whether the port is reachable from outside, only that nothing
+// else holds it.
+func checkListenPort(port uint16) Result {
+	result := Result{Name: "listen_port", OK: true}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: int(port)})
+	if err != nil {
+		result.OK = false
+		result.Message = fmt.Sprintf("UDP port %d can't be bound, a running instance or another program may hold it: %s", port, err)
+		return result
+	}
+	conn.Close()
+	result.Message = fmt.Sprintf("UDP port %d is free", port)
+	return result
+}
+
+// checkListenPortRange finds the port a listen_port of 0 would start with.
+func checkListenPortRange(ports config.PortRange) Result {
+	for port := int(ports.Min); port <= int(ports.Max); port++ {
+		if result := checkListenPort(uint16(port)); result.OK {
+			result.Message = fmt.Sprintf("UDP port %d is the first free one in %d-%d", port, ports.Min, ports.Max)
+			return result
+		}
+	}
+	return Result{Name: "listen_port", OK: false, Message: fmt.Sprintf("no UDP port in %d-%d can be bound", ports.Min, ports.Max)}
+}
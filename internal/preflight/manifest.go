@@ -0,0 +1,235 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package preflight
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/ghodss/yaml"
+	"github.com/kubewg-net/container/internal/config"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	DefaultImage = "ghcr.io/kubewg-net/container:latest"
+	// NonRootUID matches the user the image runs as
+	NonRootUID = 65532
+)
+
+// SecurityContext is the least a container needs to run the WireGuard
+// engine: a non-root user whose only capability is CAP_NET_ADMIN. The image
+// grants the capability through file capabilities on the binary, which
+// only apply if privilege escalation stays allowed.
+func SecurityContext() *corev1.SecurityContext {
+	return &corev1.SecurityContext{
+		RunAsNonRoot:             ptr.To(true),
+		RunAsUser:                ptr.To(int64(NonRootUID)),
+		RunAsGroup:               ptr.To(int64(NonRootUID)),
+		AllowPrivilegeEscalation: ptr.To(true),
+		ReadOnlyRootFilesystem:   ptr.To(true),
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+			Add:  []corev1.Capability{"NET_ADMIN"},
+		},
+		SeccompProfile: &corev1.SeccompProfile{
+			Type: corev1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+}
+
+// fieldEnv sets name from a field of the pod through the Downward API.
+func fieldEnv(name, fieldPath string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{FieldPath: fieldPath},
+		},
+	}
+}
+
+// RestrictedSecurityContext is the securityContext of a controller that
+// leaves the interfaces to an agent. It needs no capabilities at all and
+// meets the restricted Pod Security Standard.
+func RestrictedSecurityContext() *corev1.SecurityContext {
+	return &corev1.SecurityContext{
+		RunAsNonRoot:             ptr.To(true),
+		RunAsUser:                ptr.To(int64(NonRootUID)),
+		RunAsGroup:               ptr.To(int64(NonRootUID)),
+		AllowPrivilegeEscalation: ptr.To(false),
+		ReadOnlyRootFilesystem:   ptr.To(true),
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+		SeccompProfile: &corev1.SeccompProfile{
+			Type: corev1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+}
+
+// Manifest renders an example DaemonSet running image with SecurityContext.
+func Manifest(image string) ([]byte, error) {
+	daemonSet := nodeDaemonSet([]corev1.Container{{
+		Name:            "kubewg",
+		Image:           image,
+		Env:             podEnv(),
+		SecurityContext: SecurityContext(),
+		VolumeMounts: []corev1.VolumeMount{{
+			Name:      "state",
+			MountPath: "/var/lib/kubewg",
+		}},
+	}}, []corev1.Volume{emptyDir("state")})
+
+	data, err := render(daemonSet)
+	if err != nil {
+		return nil, err
+	}
+	header := "# Minimal privileges for kubewg: non-root with only CAP_NET_ADMIN.\n" +
+		"# The state volume holds the generated private key, back it with\n" +
+		"# persistent storage to keep the key across restarts.\n"
+	return append([]byte(header), data...), nil
+}
+
+// AgentManifest renders an example DaemonSet that splits kubewg in two: an
+// agent container with SecurityContext runs the interfaces, the controller
+// container next to it runs everything else with
+// RestrictedSecurityContext. They share the agent's socket through an
+// emptyDir.
+func AgentManifest(image string) ([]byte, error) {
+	sockets := corev1.VolumeMount{
+		Name:      "agent",
+		MountPath: filepath.Dir(config.DefaultAgentSocket),
+	}
+	daemonSet := nodeDaemonSet([]corev1.Container{{
+		Name:            "agent",
+		Image:           image,
+		Args:            []string{"agent", "--socket", config.DefaultAgentSocket},
+		SecurityContext: SecurityContext(),
+		VolumeMounts:    []corev1.VolumeMount{sockets},
+	}, {
+		Name:  "kubewg",
+		Image: image,
+		Args: []string{
+			"--" + config.PrivSepKey,
+			"--" + config.PrivSepAgentKey, config.DefaultAgentSocket,
+		},
+		Env:             podEnv(),
+		SecurityContext: RestrictedSecurityContext(),
+		VolumeMounts: []corev1.VolumeMount{sockets, {
+			Name:      "state",
+			MountPath: "/var/lib/kubewg",
+		}},
+	}}, []corev1.Volume{emptyDir("agent"), emptyDir("state")})
+
+	data, err := render(daemonSet)
+	if err != nil {
+		return nil, err
+	}
+	header := "# kubewg split in two: only the agent container holds CAP_NET_ADMIN,\n" +
+		"# the kubewg container drops every capability. The pod still needs the\n" +
+		"# host network, the interfaces live there.\n"
+	return append([]byte(header), data...), nil
+}
+
+func podEnv() []corev1.EnvVar {
+	return []corev1.EnvVar{
+		fieldEnv(config.EnvNodeName, "spec.nodeName"),
+		fieldEnv(config.EnvPodName, "metadata.name"),
+		fieldEnv(config.EnvPodNamespace, "metadata.namespace"),
+		fieldEnv(config.EnvHostIP, "status.hostIP"),
+	}
+}
+
+func emptyDir(name string) corev1.Volume {
+	return corev1.Volume{
+		Name: name,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	}
+}
+
+// nodeDaemonSet runs containers on the host network of every node.
+func nodeDaemonSet(containers []corev1.Container, volumes []corev1.Volume) appsv1.DaemonSet {
+	labels := map[string]string{"app.kubernetes.io/name": "kubewg"}
+	return appsv1.DaemonSet{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "apps/v1",
+			Kind:       "DaemonSet",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kubewg",
+			Namespace: "kube-system",
+			Labels:    labels,
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					HostNetwork: true,
+					SecurityContext: &corev1.PodSecurityContext{
+						FSGroup: ptr.To(int64(NonRootUID)),
+					},
+					Containers: containers,
+					Volumes:    volumes,
+				},
+			},
+		},
+	}
+}
+
+// render marshals daemonSet as YAML.
+func render(daemonSet appsv1.DaemonSet) ([]byte, error) {
+	// Drop the fields a typed object always carries but nobody writes by
+	// hand
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&daemonSet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render manifest: %w", err)
+	}
+	delete(object, "status")
+	unstructured.RemoveNestedField(object, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(object, "spec", "updateStrategy")
+	unstructured.RemoveNestedField(object, "spec", "template", "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(object, "spec", "template", "spec", "containers")
+	containers := make([]interface{}, 0, len(daemonSet.Spec.Template.Spec.Containers))
+	for i := range daemonSet.Spec.Template.Spec.Containers {
+		container, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&daemonSet.Spec.Template.Spec.Containers[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to render manifest: %w", err)
+		}
+		delete(container, "resources")
+		containers = append(containers, container)
+	}
+	if err := unstructured.SetNestedSlice(object, containers, "spec", "template", "spec", "containers"); err != nil {
+		return nil, fmt.Errorf("failed to render manifest: %w", err)
+	}
+
+	data, err := yaml.Marshal(object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render manifest: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,284 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+const commandTimeout = 5 * time.Second
+
+// ModuleState is how a kernel module is available
+type ModuleState string
+
+const (
+	ModuleLoaded    ModuleState = "loaded"
+	ModuleBuiltin   ModuleState = "builtin"
+	ModuleAvailable ModuleState = "available"
+	ModuleMissing   ModuleState = "missing"
+)
+
+const (
+	// IPTablesNFT is the iptables-nft backend, which programs nftables
+	IPTablesNFT = "nf_tables"
+	// IPTablesLegacy programs the x_tables of older kernels and tools
+	IPTablesLegacy = "legacy"
+)
+
+// modules are the kernel modules the features depend on
+//
+//nolint:golint,gochecknoglobals
+var modules = []string{"wireguard", "tun", "nf_tables", "ip_tables"}
+
+// Platform is what the node offers, detected at runtime rather than
+// assumed from the architecture: edge distributions on ARM64 often ship
+// kernels without a module, images with musl or iptables talking to
+// another backend than the host's.
+type Platform struct {
+	OS      string                 `json:"os"`
+	Arch    string                 `json:"arch"`
+	Kernel  string                 `json:"kernel,omitempty"`
+	Libc    string                 `json:"libc,omitempty"`
+	Cgroup  string                 `json:"cgroup,omitempty"`
+	Modules map[string]ModuleState `json:"modules"`
+	// IPTables is the backend of the iptables on PATH, empty without one
+	IPTables string `json:"iptables,omitempty"`
+	// LegacyRules is set when the host keeps rules in the legacy tables,
+	// e.g. from kube-proxy or k3s
+	LegacyRules bool `json:"legacy_rules"`
+	NFT         bool `json:"nft"`
+	// Features is the compatibility matrix of the features kubewg uses
+	Features []Feature `json:"features"`
+}
+
+// Feature is one row of the compatibility matrix.
+type Feature struct {
+	Name string `json:"name"`
+	// Needed is set when the config uses the feature
+	Needed    bool   `json:"needed"`
+	Supported bool   `json:"supported"`
+	Message   string `json:"message"`
+}
+
+// Unsupported returns the features config needs that the node lacks.
+func (p *Platform) Unsupported() []Feature {
+	var features []Feature
+	for _, feature := range p.Features {
+		if feature.Needed && !feature.Supported {
+			features = append(features, feature)
+		}
+	}
+	return features
+}
+
+// Detect finds out what the host offers and which of it config needs.
+func (h Host) Detect(c *config.Config) *Platform {
+	p := &Platform{
+		OS:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+		Kernel:  h.release(),
+		Libc:    h.libc(),
+		Cgroup:  h.cgroup(),
+		Modules: make(map[string]ModuleState, len(modules)),
+	}
+	for _, name := range modules {
+		p.Modules[name] = h.module(name)
+	}
+	p.Modules["wireguard"] = h.WireGuardModule()
+	p.IPTables = h.iptablesBackend()
+	if names, err := os.ReadFile(h.path("/proc/net/ip_tables_names")); err == nil {
+		p.LegacyRules = len(strings.TrimSpace(string(names))) != 0
+	}
+	if _, err := exec.LookPath("nft"); err == nil {
+		p.NFT = true
+	}
+
+	p.Features = []Feature{
+		p.kernelWireGuard(c),
+		h.userspaceWireGuard(p, c),
+		p.iptables(c),
+		p.nftables(c),
+		p.landlock(c),
+	}
+	return p
+}
+
+func (p *Platform) kernelWireGuard(c *config.Config) Feature {
+	userspace := c.WireGuard.Userspace
+	feature := Feature{
+		Name:      "kernel_wireguard",
+		Needed:    c.WireGuard.Enabled && (userspace == "" || userspace == config.UserspaceOff),
+		Supported: p.Modules["wireguard"] != ModuleMissing,
+		Message:   "wireguard is " + string(p.Modules["wireguard"]),
+	}
+	if !feature.Supported {
+		feature.Message = fmt.Sprintf("kernel %s has no wireguard module, it needs Linux 5.6 or later or wireguard.userspace set to auto", p.Kernel)
+	}
+	return feature
+}
+
+func (h Host) userspaceWireGuard(p *Platform, c *config.Config) Feature {
+	userspace := c.WireGuard.Userspace
+	feature := Feature{
+		Name: "userspace_wireguard",
+		Needed: c.WireGuard.Enabled && (userspace == config.UserspaceAlways ||
+			userspace == config.UserspaceAuto && p.Modules["wireguard"] == ModuleMissing),
+		Supported: true,
+		Message:   "/dev/net/tun is present",
+	}
+	info, err := os.Stat(h.path("/dev/net/tun"))
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		feature.Supported = false
+		feature.Message = "/dev/net/tun is missing, mount it into the container"
+	}
+	return feature
+}
+
+// iptables fails when the iptables on PATH programs another backend than
+// the one the host's rules are in, since the rules kubewg installs then
+// sit apart from kube-proxy's and are evaluated in no defined order.
+func (p *Platform) iptables(c *config.Config) Feature {
+	feature := Feature{
+		Name:      "iptables",
+		Needed:    c.WireGuard.Enabled && (c.WireGuard.ExitNode.Enabled || c.WireGuard.ClampMSS),
+		Supported: true,
+		Message:   "iptables uses " + p.IPTables,
+	}
+	switch {
+	case p.IPTables == "":
+		feature.Supported = false
+		feature.Message = "iptables is not installed"
+	case p.IPTables == IPTablesNFT && p.LegacyRules:
+		feature.Supported = false
+		feature.Message = "iptables uses nf_tables but the host keeps its rules in the legacy tables, use iptables-legacy"
+	}
+	return feature
+}
+
+func (p *Platform) nftables(c *config.Config) Feature {
+	feature := Feature{
+		Name:      "nftables",
+		Needed:    c.NeedsNFTables(),
+		Supported: p.NFT && p.Modules["nf_tables"] != ModuleMissing,
+		Message:   "nf_tables is " + string(p.Modules["nf_tables"]),
+	}
+	if !p.NFT {
+		feature.Message = "nft is not installed"
+	} else if !feature.Supported {
+		feature.Message = "the kernel has no nf_tables module"
+	}
+	return feature
+}
+
+// landlock reports whether the sandbox restricts paths too, which takes
+// Linux 5.13.
+func (p *Platform) landlock(c *config.Config) Feature {
+	feature := Feature{
+		Name:      "landlock",
+		Needed:    !c.Sandbox.Disabled,
+		Supported: kernelAtLeast(p.Kernel, 5, 13),
+		Message:   "the sandbox restricts paths with Landlock",
+	}
+	if !feature.Supported {
+		feature.Message = "Landlock needs Linux 5.13 or later, the sandbox only filters system calls"
+	}
+	return feature
+}
+
+// libc tells the C library of the image the tools run from, musl or glibc,
+// empty for images with neither such as distroless.
+func (h Host) libc() string {
+	if matches, _ := filepath.Glob(h.path("/lib/ld-musl-*.so.1")); len(matches) != 0 {
+		return "musl"
+	}
+	for _, pattern := range []string{"/lib*/ld-linux*.so.*", "/lib/*-linux-gnu*/libc.so.6"} {
+		if matches, _ := filepath.Glob(h.path(pattern)); len(matches) != 0 {
+			return "glibc"
+		}
+	}
+	return ""
+}
+
+func (h Host) cgroup() string {
+	if _, err := os.Stat(h.path("/sys/fs/cgroup/cgroup.controllers")); err == nil {
+		return "v2"
+	}
+	if entries, err := os.ReadDir(h.path("/sys/fs/cgroup")); err == nil && len(entries) != 0 {
+		return "v1"
+	}
+	return ""
+}
+
+// iptablesBackend reads the backend off "iptables --version", which older
+// releases without the nft backend don't name.
+func (h Host) iptablesBackend() string {
+	command := h.Command
+	if command == nil {
+		if _, err := exec.LookPath("iptables"); err != nil {
+			return ""
+		}
+		command = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+			return exec.CommandContext(ctx, name, args...).CombinedOutput()
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	out, err := command(ctx, "iptables", "--version")
+	if err != nil {
+		return ""
+	}
+	if strings.Contains(string(out), "("+IPTablesNFT+")") {
+		return IPTablesNFT
+	}
+	return IPTablesLegacy
+}
+
+// kernelAtLeast compares the major and minor version of release, e.g.
+// "6.1.21-v8+" on a Raspberry Pi.
+func kernelAtLeast(release string, major, minor int) bool {
+	fields := strings.SplitN(release, ".", 3)
+	if len(fields) < 2 {
+		return false
+	}
+	gotMajor, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return false
+	}
+	// The minor version may run into a suffix, as in "5.15-rc1"
+	digits := strings.IndexFunc(fields[1], func(r rune) bool { return r < '0' || r > '9' })
+	if digits == -1 {
+		digits = len(fields[1])
+	}
+	gotMinor, err := strconv.Atoi(fields[1][:digits])
+	if err != nil {
+		return false
+	}
+	return gotMajor > major || gotMajor == major && gotMinor >= minor
+}
@@ -0,0 +1,258 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package preflight checks that the process holds the privileges the
+// configured features need, so it can run as non-root with nothing but
+// CAP_NET_ADMIN and still fail early with a useful message.
+package preflight
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+const unprivilegedPortStart = "/proc/sys/net/ipv4/ip_unprivileged_port_start"
+
+var ErrFailed = errors.New("preflight checks failed")
+
+// Result is the outcome of a single check
+type Result struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+// Run checks process against what config needs.
+func Run(config *config.Config, process Process) []Result {
+	results := []Result{checkUser(process)}
+	// The agent runs the interfaces and holds CAP_NET_ADMIN instead
+	agent := config.PrivilegeSeparation.UsesAgent()
+	if config.WireGuard.Enabled && agent {
+		results = append(results, checkAgent(config.PrivilegeSeparation.AgentSocket), checkKeyFile(config))
+	} else if config.WireGuard.Enabled {
+		results = append(results, checkNetAdmin(process), checkKeyFile(config))
+	} else if config.Exporter.Enabled {
+		// Even reading a WireGuard interface takes CAP_NET_ADMIN
+		results = append(results, checkNetAdmin(process))
+	}
+	if result, ok := checkPorts(config, process); ok {
+		results = append(results, result)
+	}
+	if config.WireGuard.Enabled && !agent && (config.WireGuard.ExitNode.Enabled || config.WireGuard.ClampMSS) {
+		results = append(results, checkIPTables())
+	}
+	if config.NeedsNFTables() {
+		results = append(results, checkNFT())
+	}
+	if needsNetRaw(config) {
+		results = append(results, checkNetRaw(process))
+	}
+	return results
+}
+
+// privilegedPorts lists the listeners below the unprivileged port range,
+// which starts at the returned port.
+func privilegedPorts(config *config.Config) ([]string, int) {
+	start := 1024
+	if data, err := os.ReadFile(unprivilegedPortStart); err == nil {
+		if value, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+			start = value
+		}
+	}
+
+	var privileged []string
+	check := func(name string, enabled bool, port uint16) {
+		if enabled && port != 0 && int(port) < start {
+			privileged = append(privileged, fmt.Sprintf("%s %d", name, port))
+		}
+	}
+	check("metrics", config.Metrics.Enabled, config.Metrics.Port)
+	check("pprof", config.PProf.Enabled, config.PProf.Port)
+	check("api", config.API.Enabled, config.API.Port)
+	return privileged, start
+}
+
+// RuntimeCapabilities are the capabilities the process needs after startup
+// when the interfaces run in a privilege separation helper: CAP_NET_RAW for
+// ICMP probes, and CAP_NET_BIND_SERVICE for listeners that may bind after
+// the capabilities were dropped.
+func RuntimeCapabilities(config *config.Config) []Capability {
+	var capabilities []Capability
+	if needsNetRaw(config) {
+		capabilities = append(capabilities, CapNetRaw)
+	}
+	if privileged, _ := privilegedPorts(config); len(privileged) > 0 {
+		capabilities = append(capabilities, CapNetBindService)
+	}
+	return capabilities
+}
+
+// needsNetRaw reports whether the prober sends ICMP.
+func needsNetRaw(c *config.Config) bool {
+	return c.WireGuard.Enabled && c.Prober.Enabled && c.Prober.Mode != config.ProbeUDP
+}
+
+// checkNetRaw makes sure the prober can open its ICMP sockets.
+func checkNetRaw(process Process) Result {
+	result := Result{Name: "net_raw", OK: process.Has(CapNetRaw)}
+	if result.OK {
+		result.Message = CapNetRaw.String() + " is effective"
+	} else {
+		result.Message = CapNetRaw.String() + " is missing for ICMP probes, add it to the container's capabilities or use prober.mode udp"
+	}
+	return result
+}
+
+// Err returns an error naming the failed checks, if any.
+func Err(results []Result) error {
+	var failed []string
+	for _, result := range results {
+		if !result.OK {
+			failed = append(failed, result.Name+": "+result.Message)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrFailed, strings.Join(failed, "; "))
+}
+
+func checkUser(process Process) Result {
+	if process.UID == 0 {
+		return Result{Name: "user", OK: true, Message: "running as root, consider a non-root user with only CAP_NET_ADMIN"}
+	}
+	return Result{Name: "user", OK: true, Message: fmt.Sprintf("running as uid %d", process.UID)}
+}
+
+func checkNetAdmin(process Process) Result {
+	result := Result{Name: "net_admin", OK: process.Has(CapNetAdmin)}
+	switch {
+	case result.OK:
+		result.Message = CapNetAdmin.String() + " is effective"
+	case process.UID != 0 && process.NoNewPrivs:
+		// File capabilities on the binary are ignored under no_new_privs
+		result.Message = CapNetAdmin.String() + " is missing and no_new_privs is set, allow privilege escalation so the binary's file capabilities apply"
+	case process.UID != 0:
+		result.Message = CapNetAdmin.String() + " is missing, add it to the container's capabilities"
+	default:
+		result.Message = CapNetAdmin.String() + " is missing even though running as root, add it to the container's capabilities"
+	}
+	return result
+}
+
+// checkAgent makes sure the agent's socket is there to connect to.
+func checkAgent(path string) Result {
+	result := Result{Name: "agent", OK: false}
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		result.Message = fmt.Sprintf("agent socket %s is missing, is the agent running? %s", path, err)
+	case info.Mode()&os.ModeSocket == 0:
+		result.Message = path + " is not a socket"
+	case access(path, writeAccess) != nil:
+		result.Message = path + " is not writable, run as a UID the agent allows"
+	default:
+		result.OK = true
+		result.Message = "agent socket " + path + " is there"
+	}
+	return result
+}
+
+// checkKeyFile makes sure a missing private key can be generated, which is
+// where running as non-root usually trips first.
+func checkKeyFile(c *config.Config) Result {
+	wg := &c.WireGuard
+	result := Result{Name: "private_key_file", OK: true}
+	if wg.PrivateKey != "" {
+		result.Message = "private key is set inline"
+		return result
+	}
+	if c.KeyStore.Type != "" && c.KeyStore.Type != config.KeyStoreFile {
+		result.Message = "private key is kept in the " + c.KeyStore.Type + " keystore"
+		return result
+	}
+	if _, err := os.Stat(wg.PrivateKeyFile); err == nil {
+		if err := access(wg.PrivateKeyFile, readAccess); err != nil {
+			result.OK = false
+			result.Message = fmt.Sprintf("%s is not readable: %s", wg.PrivateKeyFile, err)
+			return result
+		}
+		result.Message = wg.PrivateKeyFile + " is readable"
+		return result
+	}
+
+	// Walk up to the closest existing directory, the rest is created
+	dir := filepath.Dir(wg.PrivateKeyFile)
+	for {
+		if _, err := os.Stat(dir); err == nil || dir == filepath.Dir(dir) {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	if err := access(dir, writeAccess|searchAccess); err != nil {
+		result.OK = false
+		result.Message = fmt.Sprintf("%s is missing and %s is not writable to generate it: %s", wg.PrivateKeyFile, dir, err)
+		return result
+	}
+	result.Message = wg.PrivateKeyFile + " will be generated"
+	return result
+}
+
+// checkIPTables makes sure the firewall rules of an exit node or MSS
+// clamping can be installed.
+func checkIPTables() Result {
+	path, err := exec.LookPath("iptables")
+	if err != nil {
+		return Result{Name: "iptables", OK: false, Message: "iptables is needed for the exit node and MSS clamping rules: " + err.Error()}
+	}
+	return Result{Name: "iptables", OK: true, Message: "found " + path}
+}
+
+// checkNFT makes sure the ACLs can be enforced.
+func checkNFT() Result {
+	path, err := exec.LookPath("nft")
+	if err != nil {
+		return Result{Name: "nft", OK: false, Message: "nft is needed to enforce wireguard.acl: " + err.Error()}
+	}
+	return Result{Name: "nft", OK: true, Message: "found " + path}
+}
+
+// checkPorts reports listeners below the unprivileged port range, which
+// need CAP_NET_BIND_SERVICE. It only yields a result if there are any.
+func checkPorts(config *config.Config, process Process) (Result, bool) {
+	privileged, start := privilegedPorts(config)
+	if len(privileged) == 0 {
+		return Result{}, false
+	}
+
+	result := Result{Name: "net_bind_service", OK: process.Has(CapNetBindService)}
+	if result.OK {
+		result.Message = CapNetBindService.String() + " is effective for " + strings.Join(privileged, ", ")
+	} else {
+		result.Message = fmt.Sprintf("%s is missing for %s, use ports from %d up", CapNetBindService, strings.Join(privileged, ", "), start)
+	}
+	return result, true
+}
@@ -0,0 +1,235 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package preflight_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/preflight"
+)
+
+func TestNetAdminIsEnough(t *testing.T) {
+	t.Parallel()
+
+	cfg := &config.Config{WireGuard: config.WireGuard{
+		Enabled:        true,
+		PrivateKeyFile: filepath.Join(t.TempDir(), "kubewg", "private.key"),
+	}}
+	process := preflight.Process{UID: preflight.NonRootUID, Effective: 1 << preflight.CapNetAdmin}
+
+	results := preflight.Run(cfg, process)
+	if err := preflight.Err(results); err != nil {
+		t.Fatalf("expected non-root with only CAP_NET_ADMIN to pass, got %v", err)
+	}
+}
+
+func TestMissingNetAdminExplainsNoNewPrivs(t *testing.T) {
+	t.Parallel()
+
+	cfg := &config.Config{WireGuard: config.WireGuard{
+		Enabled:    true,
+		PrivateKey: "inline",
+	}}
+	process := preflight.Process{UID: preflight.NonRootUID, NoNewPrivs: true}
+
+	err := preflight.Err(preflight.Run(cfg, process))
+	if !errors.Is(err, preflight.ErrFailed) {
+		t.Fatalf("expected the checks to fail, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "no_new_privs") {
+		t.Errorf("expected the failure to point at no_new_privs, got %q", err)
+	}
+}
+
+func TestAgentNeedsNoCapabilities(t *testing.T) {
+	t.Parallel()
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	cfg := &config.Config{
+		WireGuard:           config.WireGuard{Enabled: true, PrivateKey: "inline"},
+		PrivilegeSeparation: config.PrivilegeSeparation{Enabled: true, AgentSocket: socket},
+	}
+	process := preflight.Process{UID: preflight.NonRootUID, NoNewPrivs: true}
+
+	err := preflight.Err(preflight.Run(cfg, process))
+	if err == nil || !strings.Contains(err.Error(), "agent socket") {
+		t.Fatalf("expected the missing agent socket to fail, got %v", err)
+	}
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer listener.Close()
+	if err := preflight.Err(preflight.Run(cfg, process)); err != nil {
+		t.Errorf("expected no capabilities to be needed next to an agent, got %v", err)
+	}
+}
+
+func TestICMPProberNeedsNetRaw(t *testing.T) {
+	t.Parallel()
+
+	cfg := &config.Config{
+		WireGuard: config.WireGuard{Enabled: true, PrivateKey: "inline"},
+		Prober:    config.Prober{Enabled: true, Mode: config.ProbeICMP},
+	}
+	process := preflight.Process{UID: preflight.NonRootUID, Effective: 1 << preflight.CapNetAdmin}
+
+	err := preflight.Err(preflight.Run(cfg, process))
+	if err == nil || !strings.Contains(err.Error(), "CAP_NET_RAW") {
+		t.Fatalf("expected CAP_NET_RAW to be missing, got %v", err)
+	}
+
+	cfg.Prober.Mode = config.ProbeUDP
+	if err := preflight.Err(preflight.Run(cfg, process)); err != nil {
+		t.Errorf("expected UDP probes to need no CAP_NET_RAW, got %v", err)
+	}
+}
+
+func writeHostFile(t *testing.T, root, path, content string) {
+	t.Helper()
+
+	path = filepath.Join(root, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func hostResult(t *testing.T, results []preflight.Result, name string) preflight.Result {
+	t.Helper()
+
+	for _, result := range results {
+		if result.Name == name {
+			return result
+		}
+	}
+	t.Fatalf("expected a %s result, got %+v", name, results)
+	return preflight.Result{}
+}
+
+func TestHostKernelModule(t *testing.T) {
+	t.Parallel()
+
+	cfg := &config.Config{WireGuard: config.WireGuard{Enabled: true}}
+	root := t.TempDir()
+	host := preflight.Host{Root: root, Release: "6.1.0"}
+
+	if result := hostResult(t, host.Run(cfg), "kernel_module"); result.OK {
+		t.Errorf("expected a missing module to fail, got %+v", result)
+	}
+
+	writeHostFile(t, root, "/lib/modules/6.1.0/modules.dep", "kernel/drivers/net/wireguard/wireguard.ko.zst: kernel/net/ipv4/udp_tunnel.ko.zst\n")
+	if result := hostResult(t, host.Run(cfg), "kernel_module"); !result.OK {
+		t.Errorf("expected a loadable module to pass, got %+v", result)
+	}
+}
+
+func TestHostForwarding(t *testing.T) {
+	t.Parallel()
+
+	cfg := &config.Config{WireGuard: config.WireGuard{Enabled: true, ExitNode: config.ExitNode{Enabled: true}}}
+	root := t.TempDir()
+	writeHostFile(t, root, "/proc/sys/net/ipv4/ip_forward", "1\n")
+	host := preflight.Host{Root: root, Release: "6.1.0"}
+
+	results := host.Run(cfg)
+	if result := hostResult(t, results, "net.ipv4.ip_forward"); !result.OK {
+		t.Errorf("expected forwarding to pass, got %+v", result)
+	}
+	for _, result := range results {
+		if result.Name == "net.ipv6.conf.all.forwarding" {
+			t.Errorf("expected no IPv6 check without IPv6, got %+v", result)
+		}
+	}
+}
+
+func TestHostListenPortInUse(t *testing.T) {
+	t.Parallel()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		t.Fatalf("expected a UDP address, got %T", conn.LocalAddr())
+	}
+
+	cfg := &config.Config{WireGuard: config.WireGuard{Enabled: true, ListenPort: uint16(addr.Port)}}
+	host := preflight.Host{Root: t.TempDir(), Release: "6.1.0"}
+	if result := hostResult(t, host.Run(cfg), "listen_port"); result.OK {
+		t.Errorf("expected a bound port to fail, got %+v", result)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	writeHostFile(t, root, "/sys/module/wireguard/version", "1.0.0\n")
+	writeHostFile(t, root, "/proc/net/ip_tables_names", "filter\n")
+	writeHostFile(t, root, "/sys/fs/cgroup/cgroup.controllers", "cpu memory\n")
+	writeHostFile(t, root, "/lib/ld-musl-aarch64.so.1", "")
+	host := preflight.Host{
+		Root:    root,
+		Release: "6.1.21-v8+",
+		Command: func(_ context.Context, _ string, _ ...string) ([]byte, error) {
+			return []byte("iptables v1.8.9 (nf_tables)\n"), nil
+		},
+	}
+	cfg := &config.Config{WireGuard: config.WireGuard{Enabled: true, ExitNode: config.ExitNode{Enabled: true}}}
+
+	platform := host.Detect(cfg)
+	if platform.Libc != "musl" {
+		t.Errorf("expected musl, got %q", platform.Libc)
+	}
+	if platform.Cgroup != "v2" {
+		t.Errorf("expected cgroup v2, got %q", platform.Cgroup)
+	}
+	if platform.IPTables != preflight.IPTablesNFT || !platform.LegacyRules {
+		t.Errorf("expected nf_tables iptables next to legacy rules, got %q and %v", platform.IPTables, platform.LegacyRules)
+	}
+
+	features := make(map[string]preflight.Feature)
+	for _, feature := range platform.Features {
+		features[feature.Name] = feature
+	}
+	if feature := features["kernel_wireguard"]; !feature.Needed || !feature.Supported {
+		t.Errorf("expected a loaded module to support kernel WireGuard, got %+v", feature)
+	}
+	if feature := features["landlock"]; !feature.Supported {
+		t.Errorf("expected Linux 6.1 to support Landlock, got %+v", feature)
+	}
+	unsupported := platform.Unsupported()
+	if len(unsupported) != 1 || unsupported[0].Name != "iptables" {
+		t.Errorf("expected only iptables to be unsupported, got %+v", unsupported)
+	}
+}
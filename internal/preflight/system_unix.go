@@ -0,0 +1,46 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+//go:build unix
+
+package preflight
+
+import "golang.org/x/sys/unix"
+
+const (
+	readAccess   = unix.R_OK
+	writeAccess  = unix.W_OK
+	searchAccess = unix.X_OK
+)
+
+// access checks path with the real rather than the effective IDs, the
+// same as access(2).
+func access(path string, mode uint32) error {
+	return unix.Access(path, mode)
+}
+
+// kernelRelease returns the release of the running kernel, empty when
+// uname fails.
+func kernelRelease() string {
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err != nil {
+		return ""
+	}
+	return unix.ByteSliceToString(uname.Release[:])
+}
@@ -0,0 +1,150 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package privsep
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+)
+
+var (
+	ErrNotAllowed = errors.New("peer UID is not allowed")
+	ErrBusy       = errors.New("another controller is connected")
+	ErrNoKeys     = errors.New("no key store socket received")
+)
+
+// Agent runs the interfaces for a controller in another container, which
+// connects on a Unix socket instead of starting a helper. It serves one
+// controller at a time, and only processes running as one of the allowed
+// UIDs.
+type Agent struct {
+	listener *net.UnixListener
+	allowed  []uint32
+	busy     atomic.Bool
+}
+
+// Listen creates the agent's socket at path, replacing a stale one. Without
+// allowed UIDs only the agent's own UID may connect.
+func Listen(path string, allowed []uint32) (*Agent, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0o660); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict socket: %w", err)
+	}
+	if len(allowed) == 0 {
+		allowed = []uint32{uint32(os.Getuid())}
+	}
+	return &Agent{listener: listener, allowed: allowed}, nil
+}
+
+// Serve accepts controllers until ctx is done. The interfaces outlive a
+// controller, the next one to connect picks them up again.
+func (a *Agent) Serve(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		a.listener.Close()
+	}()
+	for {
+		conn, err := a.listener.AcceptUnix()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept: %w", err)
+		}
+		go func() {
+			if err := a.handle(conn); err != nil {
+				slog.Warn("Rejected controller", "error", err.Error())
+			}
+		}()
+	}
+}
+
+func (a *Agent) handle(conn *net.UnixConn) error {
+	defer conn.Close()
+
+	uid, err := peerUID(conn)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(a.allowed, uid) {
+		return fmt.Errorf("%w: %d", ErrNotAllowed, uid)
+	}
+	if !a.busy.CompareAndSwap(false, true) {
+		return ErrBusy
+	}
+	defer a.busy.Store(false)
+
+	keys, err := receiveConn(conn)
+	if err != nil {
+		return err
+	}
+	slog.Info("Controller connected", "uid", uid)
+	err = ServeConn(conn, keys)
+	slog.Info("Controller disconnected", "uid", uid)
+	return err
+}
+
+// Dial connects to the agent at path and drives it like a helper. The key
+// store requests come back on a socket pair whose other end is passed
+// along with the connection.
+func Dial(path string) (*Helper, error) {
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to agent: %w", err)
+	}
+	keys, agentKeys, err := socketPair("keys")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	err = passFile(conn, agentKeys)
+	agentKeys.Close()
+	if err != nil {
+		conn.Close()
+		keys.Close()
+		return nil, fmt.Errorf("failed to pass key store socket to agent: %w", err)
+	}
+	keysConn, err := net.FileConn(keys)
+	keys.Close()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	slog.Info("Connected to privilege separation agent", "socket", path)
+	return NewHelper(conn, keysConn), nil
+}
@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package privsep_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/privsep"
+)
+
+func startAgent(t *testing.T, allowed []uint32) string {
+	t.Helper()
+
+	socket := filepath.Join(t.TempDir(), "kubewg", "agent.sock")
+	agent, err := privsep.Listen(socket, allowed)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go agent.Serve(ctx)
+	return socket
+}
+
+func TestAgentServesOneController(t *testing.T) {
+	t.Parallel()
+
+	socket := startAgent(t, nil)
+	first, err := privsep.Dial(socket)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	device, err := first.Dataplane(&config.WireGuard{InterfaceName: "kubewg-test"}, nil, "")
+	if err != nil {
+		t.Fatalf("expected the first controller to get through, got %v", err)
+	}
+	if device.Name() != "kubewg-test" {
+		t.Errorf("expected kubewg-test, got %s", device.Name())
+	}
+
+	second, err := privsep.Dial(socket)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := second.Dataplane(&config.WireGuard{InterfaceName: "kubewg-test"}, nil, ""); err == nil {
+		t.Error("expected a second controller to be turned away")
+	}
+	second.Close()
+
+	// Once the first one is gone the next one gets through
+	if err := first.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	third, err := privsep.Dial(socket)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer third.Close()
+	if _, err := third.Dataplane(&config.WireGuard{InterfaceName: "kubewg-test"}, nil, ""); err != nil {
+		t.Errorf("expected the next controller to get through, got %v", err)
+	}
+}
+
+func TestAgentRejectsOtherUIDs(t *testing.T) {
+	t.Parallel()
+
+	socket := startAgent(t, []uint32{uint32(os.Getuid()) + 1})
+	helper, err := privsep.Dial(socket)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer helper.Close()
+	if _, err := helper.Dataplane(&config.WireGuard{InterfaceName: "kubewg-test"}, nil, ""); err == nil {
+		t.Error("expected a UID that isn't allowed to be turned away")
+	}
+}
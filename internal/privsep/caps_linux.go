@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package privsep
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/kubewg-net/container/internal/preflight"
+	"golang.org/x/sys/unix"
+)
+
+const capLastCap = "/proc/sys/kernel/cap_last_cap"
+
+// capSetPCap allows dropping capabilities from the bounding set
+const capSetPCap = 8
+
+var ErrCgo = errors.New("dropping capabilities needs a binary built with CGO_ENABLED=0")
+
+// DropCapabilities drops every capability but keep from all threads of the
+// process and sets no_new_privs, so executing another binary can't bring
+// them back. Capabilities the process doesn't have can't be kept.
+func DropCapabilities(keep ...preflight.Capability) error {
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var current [2]unix.CapUserData
+	if err := unix.Capget(&header, &current[0]); err != nil {
+		return fmt.Errorf("failed to get capabilities: %w", err)
+	}
+
+	var data [2]unix.CapUserData
+	kept := map[uint]bool{}
+	for _, capability := range keep {
+		index, bit := capability/32, uint32(1)<<(capability%32)
+		if index > 1 || current[index].Permitted&bit == 0 {
+			continue
+		}
+		data[index].Permitted |= bit
+		data[index].Effective |= bit
+		kept[uint(capability)] = true
+	}
+
+	// The bounding set limits what a later exec could gain, it can only
+	// be reduced while CAP_SETPCAP is still effective
+	if current[0].Effective&(1<<capSetPCap) != 0 {
+		for capability := uint(0); capability <= lastCap(); capability++ {
+			if kept[capability] {
+				continue
+			}
+			if err := allThreads(unix.SYS_PRCTL, unix.PR_CAPBSET_DROP, uintptr(capability), 0); err != nil {
+				return fmt.Errorf("failed to drop capability %d from the bounding set: %w", capability, err)
+			}
+		}
+	}
+	if err := allThreads(unix.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %w", err)
+	}
+	// capset only changes the calling thread, every thread has to call it
+	err := allThreads(unix.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0)
+	if err != nil {
+		return fmt.Errorf("failed to set capabilities: %w", err)
+	}
+	return nil
+}
+
+func allThreads(trap, a1, a2, a3 uintptr) error {
+	_, _, errno := syscall.AllThreadsSyscall(trap, a1, a2, a3)
+	if errno == syscall.ENOTSUP {
+		return ErrCgo
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func lastCap() uint {
+	data, err := os.ReadFile(capLastCap)
+	if err != nil {
+		return unix.CAP_LAST_CAP
+	}
+	last, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 32)
+	if err != nil {
+		return unix.CAP_LAST_CAP
+	}
+	return uint(last)
+}
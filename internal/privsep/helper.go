@@ -0,0 +1,317 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package privsep
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/keystore"
+	"github.com/kubewg-net/container/internal/secmem"
+	"github.com/kubewg-net/container/internal/wireguard"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+var (
+	ErrNotHelper     = errors.New("not started as a privilege separation helper")
+	ErrUnknownDevice = errors.New("unknown device")
+)
+
+// Serve runs the helper on the socket pairs Start passed it and returns
+// once the parent closes its end.
+func Serve() error {
+	// The parent takes the interfaces down on shutdown, a signal to the
+	// whole process group must not end the helper before that
+	signal.Ignore(syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+
+	dataplane, err := fileConn(dataplaneFD, "dataplane")
+	if err != nil {
+		return err
+	}
+	keys, err := fileConn(keysFD, "keys")
+	if err != nil {
+		dataplane.Close()
+		return err
+	}
+	return ServeConn(dataplane, keys)
+}
+
+func fileConn(fd uintptr, name string) (net.Conn, error) {
+	file := os.NewFile(fd, name)
+	defer file.Close()
+	conn, err := net.FileConn(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrNotHelper, name, err)
+	}
+	return conn, nil
+}
+
+// ServeConn serves the devices on dataplane and asks the parent for keys on
+// keys until dataplane is closed.
+func ServeConn(dataplane, keys io.ReadWriteCloser) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service := &dataplaneService{
+		ctx:     ctx,
+		keys:    rpc.NewClient(keys),
+		devices: map[string]*wireguard.Device{},
+		configs: map[string]*config.WireGuard{},
+	}
+	defer service.close()
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("Dataplane", service); err != nil {
+		return err
+	}
+	server.ServeConn(dataplane)
+	return nil
+}
+
+// dataplaneService runs the devices. The devices aren't safe for
+// concurrent use, so every call holds mu. RPC calls carry no context of
+// their own, ctx lasts until the parent hangs up.
+type dataplaneService struct {
+	ctx  context.Context
+	keys *rpc.Client
+
+	mu      sync.Mutex
+	devices map[string]*wireguard.Device
+	configs map[string]*config.WireGuard
+	client  *wgctrl.Client
+}
+
+func (s *dataplaneService) close() {
+	s.keys.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		s.client.Close()
+	}
+}
+
+func (s *dataplaneService) device(name string) (*wireguard.Device, error) {
+	device, ok := s.devices[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownDevice, name)
+	}
+	return device, nil
+}
+
+func stateOf(device *wireguard.Device) State {
+	return State{PublicKey: device.PublicKey(), MTU: device.MTU()}
+}
+
+func (s *dataplaneService) Open(args OpenArgs, reply *State) error {
+	var wg config.WireGuard
+	if err := json.Unmarshal(args.Config, &wg); err != nil {
+		return fmt.Errorf("failed to decode WireGuard config: %w", err)
+	}
+	device := wireguard.NewDevice(&wg)
+	if args.KeyStore {
+		device.UseKeyStore(&remoteKeyStore{client: s.keys, device: device.Name()}, args.KeyName)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.devices[device.Name()]; ok {
+		return fmt.Errorf("device %s is open already", device.Name())
+	}
+	s.devices[device.Name()] = device
+	s.configs[device.Name()] = &wg
+	*reply = stateOf(device)
+	return nil
+}
+
+func (s *dataplaneService) Up(args DeviceArgs, reply *State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	device, err := s.device(args.Name)
+	if err != nil {
+		return err
+	}
+	// The device holds on to the config, update it in place
+	wg := s.configs[args.Name]
+	var update config.WireGuard
+	if err := json.Unmarshal(args.Config, &update); err != nil {
+		return fmt.Errorf("failed to decode WireGuard config: %w", err)
+	}
+	*wg = update
+	if err := device.Up(s.ctx); err != nil {
+		return err
+	}
+	*reply = stateOf(device)
+	return nil
+}
+
+func (s *dataplaneService) Down(args DeviceArgs, reply *State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	device, err := s.device(args.Name)
+	if err != nil {
+		return err
+	}
+	if err := device.Down(); err != nil {
+		return err
+	}
+	*reply = stateOf(device)
+	return nil
+}
+
+func (s *dataplaneService) Inspect(args DeviceArgs, reply *[]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	device, err := s.device(args.Name)
+	if err != nil {
+		return err
+	}
+	*reply, err = device.Inspect()
+	return err
+}
+
+func (s *dataplaneService) Peers(args DeviceArgs, reply *[]wgtypes.Peer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	device, err := s.device(args.Name)
+	if err != nil {
+		return err
+	}
+	*reply, err = device.Peers()
+	return err
+}
+
+func (s *dataplaneService) ConfigurePeers(args ConfigureArgs, reply *State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	device, err := s.device(args.Name)
+	if err != nil {
+		return err
+	}
+	peers := make([]wgtypes.PeerConfig, 0, len(args.Peers))
+	for _, peer := range args.Peers {
+		peers = append(peers, peer.peerConfig())
+	}
+	if err := device.ConfigurePeers(peers); err != nil {
+		return err
+	}
+	*reply = stateOf(device)
+	return nil
+}
+
+func (s *dataplaneService) RotateKeyIfDue(args RotateArgs, reply *State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	device, err := s.device(args.Name)
+	if err != nil {
+		return err
+	}
+	rotated, err := device.RotateKeyIfDue(s.ctx, args.Now)
+	if err != nil {
+		return err
+	}
+	*reply = stateOf(device)
+	reply.Rotated = rotated
+	return nil
+}
+
+func (s *dataplaneService) EnableForwarding(_ DeviceArgs, reply *bool) error {
+	if err := wireguard.EnableForwarding(); err != nil {
+		return err
+	}
+	*reply = true
+	return nil
+}
+
+// Device reads any WireGuard interface, for the exporter. The private and
+// preshared keys stay in the helper.
+func (s *dataplaneService) Device(args DeviceArgs, reply *wgtypes.Device) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		client, err := wgctrl.New()
+		if err != nil {
+			return err
+		}
+		s.client = client
+	}
+	device, err := s.client.Device(args.Name)
+	if err != nil {
+		return err
+	}
+	secmem.WipeDevice(device)
+	*reply = *device
+	return nil
+}
+
+// remoteKeyStore asks the parent for the key of a device.
+type remoteKeyStore struct {
+	client *rpc.Client
+	device string
+}
+
+var _ keystore.KeyStore = (*remoteKeyStore)(nil)
+
+func (s *remoteKeyStore) call(ctx context.Context, method string, args KeyArgs) (KeyReply, error) {
+	var reply KeyReply
+	call := s.client.Go("KeyStore."+method, args, &reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		return reply, call.Error
+	case <-ctx.Done():
+		return reply, ctx.Err()
+	}
+}
+
+func (s *remoteKeyStore) Get(ctx context.Context, name string) (keystore.Entry, error) {
+	reply, err := s.call(ctx, "Get", KeyArgs{Device: s.device, Name: name})
+	if err != nil {
+		return keystore.Entry{}, err
+	}
+	if reply.NotFound {
+		return keystore.Entry{}, keystore.ErrNotFound
+	}
+	return keystore.Entry{Key: reply.Key, Created: reply.Created}, nil
+}
+
+func (s *remoteKeyStore) Put(ctx context.Context, name string, key wgtypes.Key) error {
+	_, err := s.call(ctx, "Put", KeyArgs{Device: s.device, Name: name, Key: key})
+	return err
+}
+
+func (s *remoteKeyStore) Create(ctx context.Context, name string, key wgtypes.Key) error {
+	reply, err := s.call(ctx, "Create", KeyArgs{Device: s.device, Name: name, Key: key})
+	if err != nil {
+		return err
+	}
+	if reply.Exists {
+		return keystore.ErrExists
+	}
+	return nil
+}
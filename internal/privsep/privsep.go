@@ -0,0 +1,388 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package privsep runs the WireGuard interfaces in a helper process, so
+// the rest of kubewg can drop its capabilities once they are up. The helper
+// is the same binary re-executed with HelperCommand. The parent drives it
+// over net/rpc on one socket pair and answers its key store requests on a
+// second one, so the key material stays wherever the parent keeps it.
+package privsep
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/exporter"
+	"github.com/kubewg-net/container/internal/keystore"
+	"github.com/kubewg-net/container/internal/wireguard"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// HelperCommand is the subcommand the helper is started with
+const HelperCommand = "privsep-helper"
+
+const (
+	// dataplaneFD and keysFD are the helper's ends of the socket pairs,
+	// right after stdin, stdout and stderr
+	dataplaneFD = 3
+	keysFD      = 4
+
+	keyStoreTimeout = 10 * time.Second
+	closeTimeout    = 5 * time.Second
+)
+
+var (
+	ErrHelperExited = errors.New("privilege separation helper exited")
+	ErrUnknownStore = errors.New("no key store for device")
+	ErrUnsupported  = errors.New("privilege separation is only supported on Linux")
+)
+
+// Helper is the parent's end of a running helper.
+type Helper struct {
+	client *rpc.Client
+	keys   io.Closer
+	done   chan struct{}
+	// ctx bounds the key store requests, it is cancelled on Close
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	stores map[string]keyStore
+}
+
+type keyStore struct {
+	store keystore.KeyStore
+	name  string
+}
+
+// Start re-executes the running binary as the helper. It must be called
+// while the process still holds CAP_NET_ADMIN, the helper keeps what it
+// inherits.
+func Start() (*Helper, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find executable: %w", err)
+	}
+	dataplane, dataplaneChild, err := socketPair("dataplane")
+	if err != nil {
+		return nil, err
+	}
+	keys, keysChild, err := socketPair("keys")
+	if err != nil {
+		dataplane.Close()
+		dataplaneChild.Close()
+		return nil, err
+	}
+
+	cmd := exec.Command(executable, HelperCommand)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{dataplaneChild, keysChild}
+	err = cmd.Start()
+	dataplaneChild.Close()
+	keysChild.Close()
+	if err != nil {
+		dataplane.Close()
+		keys.Close()
+		return nil, fmt.Errorf("failed to start privilege separation helper: %w", err)
+	}
+	slog.Info("Started privilege separation helper", "pid", cmd.Process.Pid)
+
+	helper := NewHelper(dataplane, keys)
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			slog.Error("Privilege separation helper failed", "error", err.Error())
+		}
+	}()
+	return helper, nil
+}
+
+// NewHelper drives a helper serving dataplane and keys, see ServeConn.
+func NewHelper(dataplane, keys io.ReadWriteCloser) *Helper {
+	ctx, cancel := context.WithCancel(context.Background())
+	helper := &Helper{
+		client: rpc.NewClient(dataplane),
+		keys:   keys,
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+		stores: map[string]keyStore{},
+	}
+	server := rpc.NewServer()
+	// The service has no other exported methods, registering can't fail
+	_ = server.RegisterName("KeyStore", &keyService{helper: helper})
+	go func() {
+		server.ServeConn(keys)
+		close(helper.done)
+	}()
+	return helper
+}
+
+// Done is closed once the helper is gone, whether Close stopped it or it
+// died.
+func (h *Helper) Done() <-chan struct{} {
+	return h.done
+}
+
+// Close stops the helper. The interfaces it runs stay as they are, take
+// them down first.
+func (h *Helper) Close() error {
+	h.cancel()
+	err := h.client.Close()
+	select {
+	case <-h.done:
+	case <-time.After(closeTimeout):
+		h.keys.Close()
+	}
+	return err
+}
+
+// Dataplane opens the interface wg describes in the helper. The helper
+// keeps its private key in store under keyName, or in the private key file
+// if store is nil.
+func (h *Helper) Dataplane(wg *config.WireGuard, store keystore.KeyStore, keyName string) (wireguard.Dataplane, error) {
+	data, err := json.Marshal(wg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode WireGuard config: %w", err)
+	}
+	device := &remoteDevice{
+		client: h.client,
+		name:   cmp.Or(wg.InterfaceName, config.DefaultInterfaceName),
+		config: wg,
+	}
+	if store != nil {
+		h.mu.Lock()
+		h.stores[device.name] = keyStore{store: store, name: keyName}
+		h.mu.Unlock()
+	}
+	if _, err := device.apply(context.Background(), "Open", OpenArgs{Config: data, KeyStore: store != nil, KeyName: keyName}); err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// EnableForwarding enables IP forwarding, see wireguard.EnableForwarding.
+func (h *Helper) EnableForwarding() error {
+	var ok bool
+	return h.client.Call("Dataplane.EnableForwarding", DeviceArgs{}, &ok)
+}
+
+// ListenPort returns the listen port of the WireGuard interface name, if
+// there is one, see wireguard.SelectListenPort.
+func (h *Helper) ListenPort(name string) (uint16, bool) {
+	device, err := h.Devices().Device(name)
+	if err != nil || device.ListenPort <= 0 {
+		return 0, false
+	}
+	return uint16(device.ListenPort), true
+}
+
+// Devices reads the interfaces the helper runs, for the exporter.
+func (h *Helper) Devices() exporter.Client {
+	return deviceReader{client: h.client}
+}
+
+func (h *Helper) store(device, name string) (keystore.KeyStore, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	store, ok := h.stores[device]
+	// The helper only gets to the key the device was opened with
+	if !ok || store.name != name {
+		return nil, fmt.Errorf("%w %s", ErrUnknownStore, device)
+	}
+	return store.store, nil
+}
+
+// keyService answers the helper's key store requests from the parent's key
+// stores.
+type keyService struct {
+	helper *Helper
+}
+
+func (s *keyService) Get(args KeyArgs, reply *KeyReply) error {
+	store, err := s.helper.store(args.Device, args.Name)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(s.helper.ctx, keyStoreTimeout)
+	defer cancel()
+	entry, err := store.Get(ctx, args.Name)
+	if errors.Is(err, keystore.ErrNotFound) {
+		reply.NotFound = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	reply.Key = entry.Key
+	reply.Created = entry.Created
+	return nil
+}
+
+func (s *keyService) Put(args KeyArgs, reply *KeyReply) error {
+	store, err := s.helper.store(args.Device, args.Name)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(s.helper.ctx, keyStoreTimeout)
+	defer cancel()
+	return store.Put(ctx, args.Name, args.Key)
+}
+
+func (s *keyService) Create(args KeyArgs, reply *KeyReply) error {
+	store, err := s.helper.store(args.Device, args.Name)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(s.helper.ctx, keyStoreTimeout)
+	defer cancel()
+	err = store.Create(ctx, args.Name, args.Key)
+	if errors.Is(err, keystore.ErrExists) {
+		reply.Exists = true
+		return nil
+	}
+	return err
+}
+
+// remoteDevice is an interface the helper runs. Up resends the config, the
+// reconciler changes it in place, e.g. the MTU of a new network.
+type remoteDevice struct {
+	client *rpc.Client
+	name   string
+	config *config.WireGuard
+
+	mu    sync.Mutex
+	state State
+}
+
+var _ wireguard.Dataplane = (*remoteDevice)(nil)
+
+// call calls a method of the device, giving up on the reply once ctx is
+// done. The helper carries on with the call regardless.
+func (d *remoteDevice) call(ctx context.Context, method string, args, reply any) error {
+	var err error
+	call := d.client.Go("Dataplane."+method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		err = call.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if errors.Is(err, rpc.ErrShutdown) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrHelperExited
+	}
+	return err
+}
+
+// apply calls a method that may change the device and keeps its state.
+func (d *remoteDevice) apply(ctx context.Context, method string, args any) (State, error) {
+	var state State
+	if err := d.call(ctx, method, args, &state); err != nil {
+		return state, err
+	}
+	d.mu.Lock()
+	d.state = state
+	d.mu.Unlock()
+	return state, nil
+}
+
+func (d *remoteDevice) Name() string {
+	return d.name
+}
+
+func (d *remoteDevice) Up(ctx context.Context) error {
+	data, err := json.Marshal(d.config)
+	if err != nil {
+		return fmt.Errorf("failed to encode WireGuard config: %w", err)
+	}
+	_, err = d.apply(ctx, "Up", DeviceArgs{Name: d.name, Config: data})
+	return err
+}
+
+func (d *remoteDevice) Down() error {
+	_, err := d.apply(context.Background(), "Down", DeviceArgs{Name: d.name})
+	return err
+}
+
+func (d *remoteDevice) Inspect() ([]string, error) {
+	var drift []string
+	err := d.call(context.Background(), "Inspect", DeviceArgs{Name: d.name}, &drift)
+	return drift, err
+}
+
+func (d *remoteDevice) Peers() ([]wgtypes.Peer, error) {
+	var peers []wgtypes.Peer
+	err := d.call(context.Background(), "Peers", DeviceArgs{Name: d.name}, &peers)
+	return peers, err
+}
+
+func (d *remoteDevice) ConfigurePeers(peers []wgtypes.PeerConfig) error {
+	args := ConfigureArgs{Name: d.name, Peers: make([]PeerConfig, 0, len(peers))}
+	for _, peer := range peers {
+		args.Peers = append(args.Peers, toPeerConfig(peer))
+	}
+	_, err := d.apply(context.Background(), "ConfigurePeers", args)
+	return err
+}
+
+func (d *remoteDevice) PublicKey() wgtypes.Key {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.state.PublicKey
+}
+
+func (d *remoteDevice) MTU() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.state.MTU
+}
+
+func (d *remoteDevice) RotateKeyIfDue(ctx context.Context, now time.Time) (bool, error) {
+	state, err := d.apply(ctx, "RotateKeyIfDue", RotateArgs{Name: d.name, Now: now})
+	return state.Rotated, err
+}
+
+// deviceReader reads interfaces through the helper. Closing it leaves the
+// helper running, Helper.Close stops it.
+type deviceReader struct {
+	client *rpc.Client
+}
+
+func (r deviceReader) Device(name string) (*wgtypes.Device, error) {
+	var device wgtypes.Device
+	if err := r.client.Call("Dataplane.Device", DeviceArgs{Name: name}, &device); err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+func (r deviceReader) Close() error {
+	return nil
+}
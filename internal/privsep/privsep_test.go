@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package privsep_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/privsep"
+)
+
+func TestHelperOpensDevices(t *testing.T) {
+	t.Parallel()
+
+	dataplane, helperDataplane := net.Pipe()
+	keys, helperKeys := net.Pipe()
+	served := make(chan error, 1)
+	go func() {
+		served <- privsep.ServeConn(helperDataplane, helperKeys)
+	}()
+	helper := privsep.NewHelper(dataplane, keys)
+
+	device, err := helper.Dataplane(&config.WireGuard{InterfaceName: "kubewg-test"}, nil, "")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if device.Name() != "kubewg-test" {
+		t.Errorf("expected kubewg-test, got %s", device.Name())
+	}
+	if device.MTU() != 0 {
+		t.Errorf("expected no MTU before Up, got %d", device.MTU())
+	}
+	if _, err := helper.Dataplane(&config.WireGuard{InterfaceName: "kubewg-test"}, nil, ""); err == nil {
+		t.Error("expected opening the same device twice to fail")
+	}
+	if _, err := helper.Devices().Device("kubewg-missing"); err == nil {
+		t.Error("expected reading a missing device to fail")
+	}
+
+	if err := helper.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	select {
+	case <-helper.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the helper to be done after Close")
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := device.Peers(); err == nil {
+		t.Error("expected calls after Close to fail")
+	}
+}
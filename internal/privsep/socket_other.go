@@ -0,0 +1,43 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+//go:build !linux
+
+package privsep
+
+import (
+	"net"
+	"os"
+)
+
+func socketPair(string) (*os.File, *os.File, error) {
+	return nil, nil, ErrUnsupported
+}
+
+func passFile(*net.UnixConn, *os.File) error {
+	return ErrUnsupported
+}
+
+func peerUID(*net.UnixConn) (uint32, error) {
+	return 0, ErrUnsupported
+}
+
+func receiveConn(*net.UnixConn) (net.Conn, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package privsep
+
+import (
+	"net"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// OpenArgs opens a device from the JSON of its config.WireGuard. With
+// KeyStore set the helper asks the parent for the private key under
+// KeyName, otherwise it reads the private key file itself.
+type OpenArgs struct {
+	Config   []byte
+	KeyStore bool
+	KeyName  string
+}
+
+// DeviceArgs names a device. Up also carries the current config.
+type DeviceArgs struct {
+	Name   string
+	Config []byte
+}
+
+type ConfigureArgs struct {
+	Name  string
+	Peers []PeerConfig
+}
+
+type RotateArgs struct {
+	Name string
+	Now  time.Time
+}
+
+// State is what the parent keeps of a device after each call that may
+// change it.
+type State struct {
+	PublicKey wgtypes.Key
+	MTU       int
+	Rotated   bool
+}
+
+// PeerConfig is a wgtypes.PeerConfig gob can carry. gob drops a pointer to
+// a zero value, which for the preshared key and keepalive means removing
+// them rather than leaving them alone.
+type PeerConfig struct {
+	PublicKey         wgtypes.Key
+	Remove            bool
+	UpdateOnly        bool
+	PresharedKey      wgtypes.Key
+	HasPresharedKey   bool
+	Endpoint          *net.UDPAddr
+	Keepalive         time.Duration
+	HasKeepalive      bool
+	ReplaceAllowedIPs bool
+	AllowedIPs        []net.IPNet
+}
+
+func toPeerConfig(peer wgtypes.PeerConfig) PeerConfig {
+	wire := PeerConfig{
+		PublicKey:         peer.PublicKey,
+		Remove:            peer.Remove,
+		UpdateOnly:        peer.UpdateOnly,
+		Endpoint:          peer.Endpoint,
+		ReplaceAllowedIPs: peer.ReplaceAllowedIPs,
+		AllowedIPs:        peer.AllowedIPs,
+	}
+	if peer.PresharedKey != nil {
+		wire.PresharedKey = *peer.PresharedKey
+		wire.HasPresharedKey = true
+	}
+	if peer.PersistentKeepaliveInterval != nil {
+		wire.Keepalive = *peer.PersistentKeepaliveInterval
+		wire.HasKeepalive = true
+	}
+	return wire
+}
+
+func (p PeerConfig) peerConfig() wgtypes.PeerConfig {
+	peer := wgtypes.PeerConfig{
+		PublicKey:         p.PublicKey,
+		Remove:            p.Remove,
+		UpdateOnly:        p.UpdateOnly,
+		Endpoint:          p.Endpoint,
+		ReplaceAllowedIPs: p.ReplaceAllowedIPs,
+		AllowedIPs:        p.AllowedIPs,
+	}
+	if p.HasPresharedKey {
+		key := p.PresharedKey
+		peer.PresharedKey = &key
+	}
+	if p.HasKeepalive {
+		keepalive := p.Keepalive
+		peer.PersistentKeepaliveInterval = &keepalive
+	}
+	return peer
+}
+
+// KeyArgs asks for the key of a device. Key is only set by Put and Create.
+type KeyArgs struct {
+	Device string
+	Name   string
+	Key    wgtypes.Key
+}
+
+// KeyReply carries keystore.ErrNotFound and keystore.ErrExists as flags,
+// errors lose their identity on the way.
+type KeyReply struct {
+	Key      wgtypes.Key
+	Created  time.Time
+	NotFound bool
+	Exists   bool
+}
@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package prober
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	protocolICMP   = 1
+	protocolICMPv6 = 58
+)
+
+var ErrNoIPv6 = errors.New("no IPv6 ICMP socket")
+
+// ICMPPinger sends echo requests over raw sockets, which needs
+// CAP_NET_RAW.
+type ICMPPinger struct {
+	id      int
+	seq     atomic.Uint32
+	v4      *icmp.PacketConn
+	v6      *icmp.PacketConn
+	replies *replies
+}
+
+func NewICMPPinger() (*ICMPPinger, error) {
+	v4, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ICMP socket: %w", err)
+	}
+	// Hosts without IPv6 still probe IPv4 peers
+	v6, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		slog.Debug("IPv6 peers are not probed", "error", err.Error())
+		v6 = nil
+	}
+
+	p := &ICMPPinger{
+		id:      os.Getpid() & 0xffff,
+		v4:      v4,
+		v6:      v6,
+		replies: newReplies(),
+	}
+	go p.read(v4, protocolICMP)
+	if v6 != nil {
+		go p.read(v6, protocolICMPv6)
+	}
+	return p, nil
+}
+
+func (p *ICMPPinger) Ping(ctx context.Context, addr netip.Addr) (time.Duration, error) {
+	conn, kind := p.v4, icmp.Type(ipv4.ICMPTypeEcho)
+	if addr.Is6() {
+		conn, kind = p.v6, ipv6.ICMPTypeEchoRequest
+	}
+	if conn == nil {
+		return 0, ErrNoIPv6
+	}
+
+	seq := uint16(p.seq.Add(1))
+	msg := icmp.Message{Type: kind, Body: &icmp.Echo{ID: p.id, Seq: int(seq), Data: []byte("kubewg")}}
+	packet, err := msg.Marshal(nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode echo request: %w", err)
+	}
+	return p.replies.wait(ctx, replyKey{addr: addr, seq: seq}, func() error {
+		_, err := conn.WriteTo(packet, &net.IPAddr{IP: addr.AsSlice()})
+		return err
+	})
+}
+
+func (p *ICMPPinger) read(conn *icmp.PacketConn, protocol int) {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			// Closed
+			return
+		}
+		msg, err := icmp.ParseMessage(protocol, buf[:n])
+		if err != nil || (msg.Type != ipv4.ICMPTypeEchoReply && msg.Type != ipv6.ICMPTypeEchoReply) {
+			continue
+		}
+		echo, ok := msg.Body.(*icmp.Echo)
+		if !ok || echo.ID != p.id {
+			continue
+		}
+		ipAddr, ok := from.(*net.IPAddr)
+		if !ok {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(ipAddr.IP)
+		if !ok {
+			continue
+		}
+		p.replies.deliver(replyKey{addr: addr.Unmap(), seq: uint16(echo.Seq)})
+	}
+}
+
+func (p *ICMPPinger) Close() error {
+	var errs []error
+	for _, conn := range []*icmp.PacketConn{p.v4, p.v6} {
+		if conn != nil {
+			errs = append(errs, conn.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,268 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package prober measures the connectivity to every peer through the
+// tunnel, instead of inferring it from handshake times.
+package prober
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/health"
+	"github.com/kubewg-net/container/internal/metrics"
+)
+
+// ConditionConnectivity is the health condition reporting peers that
+// answered none of their probes
+const ConditionConnectivity = "PeerConnectivity"
+
+// Pinger sends a single probe to addr and returns its round trip time.
+type Pinger interface {
+	Ping(ctx context.Context, addr netip.Addr) (time.Duration, error)
+	Close() error
+}
+
+// Result is the outcome of the last probe round to one peer.
+type Result struct {
+	PublicKey string    `json:"public_key"`
+	Target    string    `json:"target"`
+	Sent      int       `json:"sent"`
+	Received  int       `json:"received"`
+	RTT       string    `json:"rtt,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// Loss is the share of unanswered probes.
+func (r *Result) Loss() float64 {
+	if r.Sent == 0 {
+		return 0
+	}
+	return float64(r.Sent-r.Received) / float64(r.Sent)
+}
+
+type Option func(*Prober)
+
+// WithPinger replaces the pinger picked by the probe mode.
+func WithPinger(pinger Pinger) Option {
+	return func(p *Prober) {
+		p.pinger = pinger
+	}
+}
+
+// Prober probes the tunnel address of every peer in rounds.
+type Prober struct {
+	config  *config.Prober
+	wg      *config.WireGuard
+	peers   func() []config.WireGuardPeer
+	status  *health.Status
+	pinger  Pinger
+	echo    *Echo
+	mu      sync.RWMutex
+	results map[string]Result
+}
+
+// New probes the peers returned by peers, reporting to status.
+func New(config *config.Prober, wg *config.WireGuard, peers func() []config.WireGuardPeer, status *health.Status, opts ...Option) *Prober {
+	p := &Prober{
+		config:  config,
+		wg:      wg,
+		peers:   peers,
+		status:  status,
+		results: make(map[string]Result),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Start opens the sockets and probes in the background until ctx is done.
+// In UDP mode it also echoes the probes of the other peers.
+func (p *Prober) Start(ctx context.Context) error {
+	if p.pinger == nil {
+		var err error
+		switch p.config.Mode {
+		case config.ProbeUDP:
+			p.pinger, err = NewUDPPinger(p.config.Port)
+		default:
+			p.pinger, err = NewICMPPinger()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if p.config.Mode == config.ProbeUDP && p.echo == nil {
+		echo, err := ListenEcho(p.wg.Addresses, p.config.Port)
+		if err != nil {
+			return err
+		}
+		p.echo = echo
+	}
+
+	go func() {
+		ticker := time.NewTicker(p.config.Interval.Std())
+		defer ticker.Stop()
+		for {
+			p.Probe(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	slog.Info("Prober started", "mode", p.config.Mode, "interval", p.config.Interval)
+	return nil
+}
+
+// Stop closes the sockets.
+func (p *Prober) Stop() error {
+	var errs []error
+	if p.echo != nil {
+		errs = append(errs, p.echo.Close())
+	}
+	if p.pinger != nil {
+		errs = append(errs, p.pinger.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Results returns the last round's results, sorted by public key.
+func (p *Prober) Results() []Result {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	results := make([]Result, 0, len(p.results))
+	for _, result := range p.results {
+		results = append(results, result)
+	}
+	slices.SortFunc(results, func(a, b Result) int {
+		return strings.Compare(a.PublicKey, b.PublicKey)
+	})
+	return results
+}
+
+// Probe runs one round, probing all peers concurrently.
+func (p *Prober) Probe(ctx context.Context) {
+	results := make(map[string]Result)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, peer := range p.peers() {
+		target, ok := Target(&peer)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := p.probePeer(ctx, peer.PublicKey, target)
+			mu.Lock()
+			results[peer.PublicKey] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	p.mu.Lock()
+	for publicKey := range p.results {
+		if _, ok := results[publicKey]; !ok {
+			metrics.ProbeRTT.DeleteLabelValues(publicKey)
+			metrics.ProbeLoss.DeleteLabelValues(publicKey)
+		}
+	}
+	p.results = results
+	p.mu.Unlock()
+
+	p.report(results)
+}
+
+func (p *Prober) probePeer(ctx context.Context, publicKey string, target netip.Addr) Result {
+	result := Result{PublicKey: publicKey, Target: target.String(), Time: time.Now()}
+	var total time.Duration
+	for range p.config.Count {
+		probeCtx, cancel := context.WithTimeout(ctx, p.config.Timeout.Std())
+		rtt, err := p.pinger.Ping(probeCtx, target)
+		cancel()
+		if ctx.Err() != nil {
+			break
+		}
+		result.Sent++
+		if err != nil {
+			slog.Debug("Probe failed", "public_key", publicKey, "target", result.Target, "error", err.Error())
+			continue
+		}
+		result.Received++
+		total += rtt
+		metrics.ProbeRTT.WithLabelValues(publicKey).Observe(rtt.Seconds())
+	}
+	if result.Received > 0 {
+		result.RTT = (total / time.Duration(result.Received)).String()
+	}
+	metrics.ProbeLoss.WithLabelValues(publicKey).Set(result.Loss())
+	return result
+}
+
+// report marks connectivity degraded while any peer answers none of its
+// probes.
+func (p *Prober) report(results map[string]Result) {
+	var unreachable []string
+	for publicKey, result := range results {
+		if result.Sent > 0 && result.Received == 0 {
+			unreachable = append(unreachable, publicKey)
+		}
+	}
+	slices.Sort(unreachable)
+
+	condition := health.Condition{Type: ConditionConnectivity, OK: len(unreachable) == 0}
+	if !condition.OK {
+		condition.Reason = "PeersUnreachable"
+		condition.Message = fmt.Sprintf("%d of %d peers unreachable: %s", len(unreachable), len(results), strings.Join(unreachable, ", "))
+	}
+	if p.status.Set(condition) {
+		if condition.OK {
+			slog.Info("All peers answer probes again")
+		} else {
+			slog.Warn("Peers don't answer probes", "unreachable", unreachable)
+		}
+	}
+}
+
+// Target picks the tunnel address of peer: the first of its allowed IPs
+// that is a single address. Peers only routing subnets aren't probed.
+func Target(peer *config.WireGuardPeer) (netip.Addr, bool) {
+	for _, allowedIP := range peer.AllowedIPs {
+		prefix, err := netip.ParsePrefix(allowedIP)
+		if err == nil && prefix.IsSingleIP() {
+			return prefix.Addr(), true
+		}
+	}
+	return netip.Addr{}, false
+}
@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package prober_test
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/health"
+	"github.com/kubewg-net/container/internal/prober"
+)
+
+type fakePinger struct {
+	down map[netip.Addr]bool
+}
+
+func (f *fakePinger) Ping(_ context.Context, addr netip.Addr) (time.Duration, error) {
+	if f.down[addr] {
+		return 0, prober.ErrTimeout
+	}
+	return time.Millisecond, nil
+}
+
+func (f *fakePinger) Close() error {
+	return nil
+}
+
+func TestProbeReportsUnreachablePeers(t *testing.T) {
+	t.Parallel()
+
+	peers := []config.WireGuardPeer{
+		{PublicKey: "a", AllowedIPs: []string{"10.0.0.2/32", "10.244.1.0/24"}},
+		{PublicKey: "b", AllowedIPs: []string{"10.0.0.3/32"}},
+		{PublicKey: "subnet-only", AllowedIPs: []string{"10.244.2.0/24"}},
+	}
+	pinger := &fakePinger{down: map[netip.Addr]bool{netip.MustParseAddr("10.0.0.3"): true}}
+	status := health.NewStatus()
+	p := prober.New(&config.Prober{Count: 2, Timeout: config.Duration(time.Second)}, &config.WireGuard{}, func() []config.WireGuardPeer {
+		return peers
+	}, status, prober.WithPinger(pinger))
+
+	p.Probe(context.Background())
+
+	results := p.Results()
+	if len(results) != 2 {
+		t.Fatalf("expected results for the 2 peers with a tunnel address, got %+v", results)
+	}
+	if results[0].PublicKey != "a" || results[0].Received != 2 || results[0].Loss() != 0 {
+		t.Errorf("expected peer a to answer every probe, got %+v", results[0])
+	}
+	if results[1].PublicKey != "b" || results[1].Received != 0 || results[1].Loss() != 1 {
+		t.Errorf("expected peer b to lose every probe, got %+v", results[1])
+	}
+	if !status.Degraded() {
+		t.Error("expected an unreachable peer to degrade health")
+	}
+
+	pinger.down = nil
+	p.Probe(context.Background())
+	if status.Degraded() {
+		t.Errorf("expected health to recover, got %+v", status.Conditions())
+	}
+}
+
+func TestUDPEcho(t *testing.T) {
+	t.Parallel()
+
+	echo, err := prober.ListenEcho([]string{"127.0.0.1/8"}, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer echo.Close()
+
+	pinger, err := prober.NewUDPPinger(echo.Port())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer pinger.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := pinger.Ping(ctx, netip.MustParseAddr("127.0.0.1")); err != nil {
+		t.Fatalf("expected an echo, got %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := pinger.Ping(ctx, netip.MustParseAddr("127.0.0.2")); !errors.Is(err, prober.ErrTimeout) {
+		t.Errorf("expected ErrTimeout without an echo, got %v", err)
+	}
+}
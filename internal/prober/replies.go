@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package prober
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+var ErrTimeout = errors.New("probe timed out")
+
+type replyKey struct {
+	addr netip.Addr
+	seq  uint16
+}
+
+// replies hands the replies read from a socket to the probes waiting for
+// them.
+type replies struct {
+	mu      sync.Mutex
+	waiting map[replyKey]chan time.Time
+}
+
+func newReplies() *replies {
+	return &replies{waiting: make(map[replyKey]chan time.Time)}
+}
+
+// wait sends a probe through send and returns the time until its reply
+// is delivered.
+func (r *replies) wait(ctx context.Context, key replyKey, send func() error) (time.Duration, error) {
+	received := make(chan time.Time, 1)
+	r.mu.Lock()
+	r.waiting[key] = received
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.waiting, key)
+		r.mu.Unlock()
+	}()
+
+	start := time.Now()
+	if err := send(); err != nil {
+		return 0, fmt.Errorf("failed to send probe to %s: %w", key.addr, err)
+	}
+	select {
+	case at := <-received:
+		return at.Sub(start), nil
+	case <-ctx.Done():
+		return 0, fmt.Errorf("%w: %s", ErrTimeout, key.addr)
+	}
+}
+
+func (r *replies) deliver(key replyKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if received, ok := r.waiting[key]; ok {
+		select {
+		case received <- time.Now():
+		default:
+		}
+	}
+}
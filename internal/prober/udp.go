@@ -0,0 +1,168 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package prober
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	probeRequest = 0
+	probeReply   = 1
+	// probeLen is the magic, the kind and the sequence number
+	probeLen = 7
+)
+
+//nolint:golint,gochecknoglobals
+var probeMagic = []byte("kwgp")
+
+func probePacket(kind byte, seq uint16) []byte {
+	packet := append(bytes.Clone(probeMagic), kind, 0, 0)
+	binary.BigEndian.PutUint16(packet[len(probeMagic)+1:], seq)
+	return packet
+}
+
+func parseProbe(packet []byte) (byte, uint16, bool) {
+	if len(packet) < probeLen || !bytes.Equal(packet[:len(probeMagic)], probeMagic) {
+		return 0, 0, false
+	}
+	return packet[len(probeMagic)], binary.BigEndian.Uint16(packet[len(probeMagic)+1:]), true
+}
+
+// UDPPinger sends datagrams to the echo of the peer's prober. Unlike ICMP
+// it needs no privileges, and it measures the path a UDP service sees.
+type UDPPinger struct {
+	port    uint16
+	seq     atomic.Uint32
+	conn    *net.UDPConn
+	replies *replies
+}
+
+func NewUDPPinger(port uint16) (*UDPPinger, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open UDP probe socket: %w", err)
+	}
+	p := &UDPPinger{port: port, conn: conn, replies: newReplies()}
+	go p.read()
+	return p, nil
+}
+
+func (p *UDPPinger) Ping(ctx context.Context, addr netip.Addr) (time.Duration, error) {
+	seq := uint16(p.seq.Add(1))
+	packet := probePacket(probeRequest, seq)
+	return p.replies.wait(ctx, replyKey{addr: addr, seq: seq}, func() error {
+		_, err := p.conn.WriteToUDPAddrPort(packet, netip.AddrPortFrom(addr, p.port))
+		return err
+	})
+}
+
+func (p *UDPPinger) read() {
+	buf := make([]byte, 64)
+	for {
+		n, from, err := p.conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			// Closed
+			return
+		}
+		kind, seq, ok := parseProbe(buf[:n])
+		if !ok || kind != probeReply {
+			continue
+		}
+		p.replies.deliver(replyKey{addr: from.Addr().Unmap(), seq: seq})
+	}
+}
+
+func (p *UDPPinger) Close() error {
+	return p.conn.Close()
+}
+
+// Echo answers the UDP probes of other peers. It only listens on the
+// tunnel addresses so it can't be used as a reflector from outside.
+type Echo struct {
+	conns []*net.UDPConn
+}
+
+func ListenEcho(addresses []string, port uint16) (*Echo, error) {
+	echo := &Echo{}
+	for _, address := range addresses {
+		prefix, err := netip.ParsePrefix(address)
+		if err != nil {
+			_ = echo.Close()
+			return nil, fmt.Errorf("invalid address %q: %w", address, err)
+		}
+		conn, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(prefix.Addr(), port)))
+		if err != nil {
+			_ = echo.Close()
+			return nil, fmt.Errorf("failed to listen for probes on %s: %w", prefix.Addr(), err)
+		}
+		echo.conns = append(echo.conns, conn)
+		go serveEcho(conn)
+	}
+	return echo, nil
+}
+
+func serveEcho(conn *net.UDPConn) {
+	buf := make([]byte, 64)
+	for {
+		n, from, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			// Closed
+			return
+		}
+		kind, seq, ok := parseProbe(buf[:n])
+		if !ok || kind != probeRequest {
+			continue
+		}
+		if _, err := conn.WriteToUDPAddrPort(probePacket(probeReply, seq), from); err != nil {
+			slog.Debug("Failed to echo probe", "from", from.String(), "error", err.Error())
+		}
+	}
+}
+
+// Port returns the port the echo listens on, which differs from the one
+// requested when that was 0.
+func (e *Echo) Port() uint16 {
+	if len(e.conns) == 0 {
+		return 0
+	}
+	addr, ok := e.conns[0].LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return 0
+	}
+	return addr.AddrPort().Port()
+}
+
+func (e *Echo) Close() error {
+	var errs []error
+	for _, conn := range e.conns {
+		errs = append(errs, conn.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,237 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package profiling pushes CPU and heap profiles to a Pyroscope server, so
+// a regression can be looked at after the fact instead of only while it is
+// happening.
+package profiling
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+const uploadTimeout = 10 * time.Second
+
+var (
+	ErrNoCACerts = errors.New("no certificates found in CA file")
+	ErrResponse  = errors.New("unexpected response from profiling server")
+)
+
+type Pusher struct {
+	config *config.Profiling
+	client *http.Client
+	ingest string
+	name   string
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewPusher pushes profiles tagged with the config's tags and the node
+// name, unless the tags set one.
+func NewPusher(config *config.Profiling, nodeName string) (*Pusher, error) {
+	server, err := url.Parse(config.ServerAddress)
+	if err != nil {
+		return nil, fmt.Errorf("invalid profiling server address: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read profiling CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, ErrNoCACerts
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	tags := make(map[string]string, len(config.Tags)+1)
+	if nodeName != "" {
+		tags["node"] = nodeName
+	}
+	for key, value := range config.Tags {
+		tags[key] = value
+	}
+
+	return &Pusher{
+		config: config,
+		client: &http.Client{
+			Timeout: uploadTimeout,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+		ingest: server.JoinPath("ingest").String(),
+		name:   config.ApplicationName + formatTags(tags),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+// formatTags renders tags the way Pyroscope expects them after the
+// application name, e.g. {node=a,zone=b}.
+func formatTags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(tags))
+	for key, value := range tags {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Start profiles the CPU for an interval at a time, pushing that profile
+// and a heap profile after each, until Stop is called or ctx is done.
+func (p *Pusher) Start(ctx context.Context) {
+	defer close(p.done)
+
+	interval := p.config.Interval.Std()
+	slog.Info("Continuous profiling started", "server", p.config.ServerAddress, "interval", interval)
+
+	for {
+		from := time.Now()
+		var cpu bytes.Buffer
+		// Fails while someone pulls a CPU profile from the pprof server,
+		// that interval is only pushed as a heap profile then
+		cpuErr := pprof.StartCPUProfile(&cpu)
+		if cpuErr != nil {
+			slog.Debug("Skipping CPU profile", "error", cpuErr.Error())
+		}
+
+		stopped := false
+		select {
+		case <-ctx.Done():
+			stopped = true
+		case <-p.stop:
+			stopped = true
+		case <-time.After(interval):
+		}
+		if cpuErr == nil {
+			pprof.StopCPUProfile()
+		}
+		until := time.Now()
+
+		// Push what was collected so far even when stopping, but not with
+		// a context that is already done
+		pushCtx := context.WithoutCancel(ctx)
+		if cpuErr == nil {
+			p.push(pushCtx, "cpu", from, until, cpu.Bytes())
+		}
+		var heap bytes.Buffer
+		if err := pprof.Lookup("heap").WriteTo(&heap, 0); err != nil {
+			slog.Warn("Failed to collect heap profile", "error", err.Error())
+		} else {
+			p.push(pushCtx, "heap", from, until, heap.Bytes())
+		}
+
+		if stopped {
+			return
+		}
+	}
+}
+
+func (p *Pusher) Stop(ctx context.Context) error {
+	close(p.stop)
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("timed out waiting for profiling to stop: %w", ctx.Err())
+	}
+}
+
+func (p *Pusher) push(ctx context.Context, kind string, from, until time.Time, profile []byte) {
+	if err := p.upload(ctx, from, until, profile); err != nil {
+		slog.Warn("Failed to push profile", "profile", kind, "error", err.Error())
+	}
+}
+
+// upload sends one pprof encoded profile to the Pyroscope ingest API.
+func (p *Pusher) upload(ctx context.Context, from, until time.Time, profile []byte) error {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("profile", "profile.pprof")
+	if err != nil {
+		return fmt.Errorf("failed to encode profile: %w", err)
+	}
+	if _, err := part.Write(profile); err != nil {
+		return fmt.Errorf("failed to encode profile: %w", err)
+	}
+	if err := form.Close(); err != nil {
+		return fmt.Errorf("failed to encode profile: %w", err)
+	}
+
+	query := url.Values{}
+	query.Set("name", p.name)
+	query.Set("from", strconv.FormatInt(from.Unix(), 10))
+	query.Set("until", strconv.FormatInt(until.Unix(), 10))
+	query.Set("spyName", "gospy")
+	query.Set("format", "pprof")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.ingest+"?"+query.Encode(), &body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	switch {
+	case p.config.Token != "":
+		req.Header.Set("Authorization", "Bearer "+p.config.Token)
+	case p.config.BasicAuthUser != "":
+		req.SetBasicAuth(p.config.BasicAuthUser, p.config.BasicAuthPassword)
+	}
+	if p.config.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", p.config.TenantID)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push profile: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%w: %s: %s", ErrResponse, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
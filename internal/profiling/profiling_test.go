@@ -0,0 +1,89 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package profiling_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/profiling"
+)
+
+type upload struct {
+	name   string
+	tenant string
+}
+
+func TestPusherUploads(t *testing.T) {
+	t.Parallel()
+
+	uploads := make(chan upload, 8)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ingest" || r.URL.Query().Get("format") != "pprof" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		file, _, err := r.FormFile("profile")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		uploads <- upload{name: r.URL.Query().Get("name"), tenant: r.Header.Get("X-Scope-OrgID")}
+	}))
+	defer server.Close()
+
+	pusher, err := profiling.NewPusher(&config.Profiling{
+		ServerAddress:   server.URL,
+		ApplicationName: "kubewg",
+		Interval:        config.Duration(time.Second),
+		TenantID:        "tenant",
+		Tags:            map[string]string{"zone": "a"},
+	}, "node-a")
+	if err != nil {
+		t.Fatalf("failed to create pusher: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pusher.Start(ctx)
+
+	select {
+	case got := <-uploads:
+		if got.name != "kubewg{node=node-a,zone=a}" {
+			t.Errorf("expected the name to carry the tags, got %q", got.name)
+		}
+		if got.tenant != "tenant" {
+			t.Errorf("expected tenant header, got %q", got.tenant)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected a profile to be pushed")
+	}
+
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer stopCancel()
+	if err := pusher.Stop(stopCtx); err != nil {
+		t.Fatalf("failed to stop pusher: %v", err)
+	}
+}
@@ -0,0 +1,252 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package punch
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/reconciler"
+	"github.com/kubewg-net/container/internal/wireguard"
+)
+
+const (
+	// staleHandshake is WireGuard's reject-after time, past which a
+	// session is gone and the peer is worth punching to
+	staleHandshake = 180 * time.Second
+	requestTimeout = 10 * time.Second
+)
+
+var (
+	ErrNoCACerts          = errors.New("no certificates found in CA file")
+	ErrRendezvousResponse = errors.New("rendezvous returned an error")
+	ErrNoEndpoint         = errors.New("no endpoint to announce, set wireguard.endpoint or enable wireguard.stun")
+)
+
+// Puncher announces this node to the rendezvous every interval and
+// schedules the punches it gets back on the reconciler.
+type Puncher struct {
+	config     *config.WireGuard
+	device     wireguard.Dataplane
+	reconciler *reconciler.Reconciler
+	client     *http.Client
+	mu         sync.Mutex
+	timers     map[string]*scheduled
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+type scheduled struct {
+	at    time.Time
+	timer *time.Timer
+}
+
+func NewPuncher(config *config.WireGuard, device wireguard.Dataplane, reconciler *reconciler.Reconciler) (*Puncher, error) {
+	client, err := httpClient(&config.HolePunching.Rendezvous)
+	if err != nil {
+		return nil, fmt.Errorf("hole punching rendezvous: %w", err)
+	}
+	return &Puncher{
+		config:     config,
+		device:     device,
+		reconciler: reconciler,
+		client:     client,
+		timers:     make(map[string]*scheduled),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}, nil
+}
+
+// Start announces until Stop is called or ctx is done.
+func (p *Puncher) Start(ctx context.Context) {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.config.HolePunching.Interval.Std())
+	defer ticker.Stop()
+
+	slog.Info("Hole punching started", "rendezvous", p.config.HolePunching.Rendezvous.URL)
+
+	for {
+		if err := p.announce(ctx); err != nil {
+			slog.Warn("Failed to announce to the rendezvous", "error", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Puncher) Stop(ctx context.Context) error {
+	close(p.stop)
+
+	p.mu.Lock()
+	for _, punch := range p.timers {
+		punch.timer.Stop()
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("timed out waiting for hole punching to stop: %w", ctx.Err())
+	}
+}
+
+func (p *Puncher) announce(ctx context.Context) error {
+	// The endpoint may only be known after STUN discovery at startup
+	if p.config.Endpoint == "" {
+		return ErrNoEndpoint
+	}
+
+	wants, err := p.wants(time.Now())
+	if err != nil {
+		return err
+	}
+
+	punches, err := p.post(ctx, Announcement{
+		PublicKey: p.device.PublicKey().String(),
+		Endpoint:  p.config.Endpoint,
+		Wants:     wants,
+	})
+	if err != nil {
+		return err
+	}
+	for _, punch := range punches {
+		p.schedule(punch)
+	}
+	return nil
+}
+
+// wants lists the peers without a live session.
+func (p *Puncher) wants(now time.Time) ([]string, error) {
+	current, err := p.device.Peers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list peers: %w", err)
+	}
+
+	wants := make([]string, 0, len(current))
+	for _, peer := range current {
+		if peer.LastHandshakeTime.IsZero() || now.Sub(peer.LastHandshakeTime) > staleHandshake {
+			wants = append(wants, peer.PublicKey.String())
+		}
+	}
+	return wants, nil
+}
+
+// schedule arranges for punch to be applied at its time. The rendezvous
+// repeats a punch until it expires, only a new time replaces the timer.
+func (p *Puncher) schedule(punch Punch) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if existing, ok := p.timers[punch.PublicKey]; ok {
+		if existing.at.Equal(punch.At) {
+			return
+		}
+		existing.timer.Stop()
+	}
+
+	slog.Debug("Scheduled hole punch", "public_key", punch.PublicKey, "endpoint", punch.Endpoint, "at", punch.At)
+	p.timers[punch.PublicKey] = &scheduled{
+		at: punch.At,
+		timer: time.AfterFunc(time.Until(punch.At), func() {
+			slog.Info("Punching through to peer", "public_key", punch.PublicKey, "endpoint", punch.Endpoint)
+			p.reconciler.Punch(punch.PublicKey, punch.Endpoint)
+		}),
+	}
+}
+
+func (p *Puncher) post(ctx context.Context, announcement Announcement) ([]Punch, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	body, err := json.Marshal(announcement)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal announcement: %w", err)
+	}
+
+	rendezvous := &p.config.HolePunching.Rendezvous
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(rendezvous.URL, "/")+RendezvousPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if rendezvous.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+rendezvous.Token)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call rendezvous: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrRendezvousResponse, resp.Status)
+	}
+
+	var response AnnounceResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode punches: %w", err)
+	}
+	return response.Punches, nil
+}
+
+func httpClient(rendezvous *config.Rendezvous) (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if rendezvous.CAFile != "" {
+		pem, err := os.ReadFile(rendezvous.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, ErrNoCACerts
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}, nil
+}
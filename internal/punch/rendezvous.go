@@ -0,0 +1,169 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package punch coordinates UDP hole punching between peers behind NAT. A
+// rendezvous reachable by both sides pairs up peers that lack a handshake
+// and hands each the other's public endpoint along with a common time, at
+// which both program the endpoint and send a handshake, opening a mapping
+// in both NATs.
+package punch
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// RendezvousPath is where the admin API serves the rendezvous
+const RendezvousPath = "/api/v1/rendezvous"
+
+var ErrInvalidAnnouncement = errors.New("invalid announcement")
+
+// Announcement is what a peer tells the rendezvous every interval: where
+// it can be reached and which peers it has no handshake with
+type Announcement struct {
+	PublicKey string   `json:"public_key"`
+	Endpoint  string   `json:"endpoint"`
+	Wants     []string `json:"wants"`
+}
+
+// Punch tells a peer to program Endpoint for PublicKey at At
+type Punch struct {
+	PublicKey string    `json:"public_key"`
+	Endpoint  string    `json:"endpoint"`
+	At        time.Time `json:"at"`
+}
+
+type AnnounceResponse struct {
+	Punches []Punch `json:"punches"`
+}
+
+type announced struct {
+	Announcement
+	at time.Time
+}
+
+type pair [2]string
+
+func newPair(a, b string) pair {
+	if a > b {
+		a, b = b, a
+	}
+	return pair{a, b}
+}
+
+// Rendezvous pairs up announcing peers. Announcements expire after a few
+// missed intervals, and a pair keeps its punch time until both sides have
+// had an interval to act on it.
+type Rendezvous struct {
+	interval time.Duration
+	mu       sync.Mutex
+	peers    map[string]announced
+	pairs    map[pair]time.Time
+}
+
+func NewRendezvous(interval time.Duration) *Rendezvous {
+	return &Rendezvous{
+		interval: interval,
+		peers:    make(map[string]announced),
+		pairs:    make(map[pair]time.Time),
+	}
+}
+
+// Announce records announcement and returns the punches for its peer:
+// every announced peer it wants, and every announced peer that wants it.
+func (r *Rendezvous) Announce(announcement Announcement, now time.Time) ([]Punch, error) {
+	if err := validate(&announcement); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.expire(now)
+	r.peers[announcement.PublicKey] = announced{Announcement: announcement, at: now}
+
+	candidates := make(map[string]struct{}, len(announcement.Wants))
+	for _, want := range announcement.Wants {
+		candidates[want] = struct{}{}
+	}
+	for publicKey, other := range r.peers {
+		for _, want := range other.Wants {
+			if want == announcement.PublicKey {
+				candidates[publicKey] = struct{}{}
+			}
+		}
+	}
+	delete(candidates, announcement.PublicKey)
+
+	var punches []Punch
+	for publicKey := range candidates {
+		other, ok := r.peers[publicKey]
+		if !ok {
+			continue
+		}
+
+		// Far enough ahead that the other side sees it on its next
+		// announcement
+		key := newPair(announcement.PublicKey, publicKey)
+		at, ok := r.pairs[key]
+		if !ok {
+			at = now.Add(r.interval + time.Second)
+			r.pairs[key] = at
+		}
+		punches = append(punches, Punch{
+			PublicKey: publicKey,
+			Endpoint:  other.Endpoint,
+			At:        at,
+		})
+	}
+	return punches, nil
+}
+
+func (r *Rendezvous) expire(now time.Time) {
+	for publicKey, peer := range r.peers {
+		if now.Sub(peer.at) > 3*r.interval {
+			delete(r.peers, publicKey)
+		}
+	}
+	for key, at := range r.pairs {
+		if now.Sub(at) > r.interval {
+			delete(r.pairs, key)
+		}
+	}
+}
+
+func validate(announcement *Announcement) error {
+	if _, err := wgtypes.ParseKey(announcement.PublicKey); err != nil {
+		return fmt.Errorf("%w: public key: %w", ErrInvalidAnnouncement, err)
+	}
+	if _, _, err := net.SplitHostPort(announcement.Endpoint); err != nil {
+		return fmt.Errorf("%w: endpoint: %w", ErrInvalidAnnouncement, err)
+	}
+	for _, want := range announcement.Wants {
+		if _, err := wgtypes.ParseKey(want); err != nil {
+			return fmt.Errorf("%w: wanted peer %q: %w", ErrInvalidAnnouncement, want, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package punch_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/punch"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func publicKey(t *testing.T) string {
+	t.Helper()
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return key.PublicKey().String()
+}
+
+func TestBothSidesPunchAtTheSameTime(t *testing.T) {
+	t.Parallel()
+
+	rendezvous := punch.NewRendezvous(10 * time.Second)
+	a, b := publicKey(t), publicKey(t)
+	now := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	punches, err := rendezvous.Announce(punch.Announcement{PublicKey: a, Endpoint: "198.51.100.1:51820", Wants: []string{b}}, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(punches) != 0 {
+		t.Fatalf("expected no punches before the wanted peer announced, got %v", punches)
+	}
+
+	// b has no session problems of its own, but a wants it
+	punches, err = rendezvous.Announce(punch.Announcement{PublicKey: b, Endpoint: "203.0.113.7:40001"}, now.Add(4*time.Second))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(punches) != 1 || punches[0].PublicKey != a || punches[0].Endpoint != "198.51.100.1:51820" {
+		t.Fatalf("expected b to be told to punch to a, got %v", punches)
+	}
+	at := punches[0].At
+
+	punches, err = rendezvous.Announce(punch.Announcement{PublicKey: a, Endpoint: "198.51.100.1:51820", Wants: []string{b}}, now.Add(10*time.Second))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(punches) != 1 || punches[0].PublicKey != b || punches[0].Endpoint != "203.0.113.7:40001" {
+		t.Fatalf("expected a to be told to punch to b, got %v", punches)
+	}
+	if !punches[0].At.Equal(at) {
+		t.Errorf("expected both sides to punch at %s, got %s", at, punches[0].At)
+	}
+	if !at.After(now.Add(10 * time.Second)) {
+		t.Errorf("expected the punch time %s to leave a its next announcement to learn of it", at)
+	}
+}
+
+func TestStalePeersAreForgotten(t *testing.T) {
+	t.Parallel()
+
+	rendezvous := punch.NewRendezvous(10 * time.Second)
+	a, b := publicKey(t), publicKey(t)
+	now := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	if _, err := rendezvous.Announce(punch.Announcement{PublicKey: b, Endpoint: "203.0.113.7:40001"}, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	punches, err := rendezvous.Announce(punch.Announcement{PublicKey: a, Endpoint: "198.51.100.1:51820", Wants: []string{b}}, now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(punches) != 0 {
+		t.Errorf("expected no punch to a peer that stopped announcing, got %v", punches)
+	}
+}
+
+func TestInvalidAnnouncement(t *testing.T) {
+	t.Parallel()
+
+	rendezvous := punch.NewRendezvous(10 * time.Second)
+	_, err := rendezvous.Announce(punch.Announcement{PublicKey: publicKey(t), Endpoint: "198.51.100.1"}, time.Now())
+	if !errors.Is(err, punch.ErrInvalidAnnouncement) {
+		t.Errorf("expected an endpoint without port to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package reconciler
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kubewg-net/container/internal/metrics"
+)
+
+const (
+	DriftKindInterface = "interface"
+	DriftKindPeer      = "peer"
+)
+
+// Drift is a single difference between the desired and the live state.
+type Drift struct {
+	Kind   string    `json:"kind"`
+	Object string    `json:"object"`
+	Detail string    `json:"detail"`
+	Since  time.Time `json:"since"`
+}
+
+func (d *Drift) key() string {
+	return d.Kind + "\x00" + d.Object + "\x00" + d.Detail
+}
+
+// driftTracker remembers when each drift was first seen so reports can say
+// for how long the live state has been off.
+type driftTracker struct {
+	mu    sync.Mutex
+	items map[string]Drift
+}
+
+func newDriftTracker() *driftTracker {
+	return &driftTracker{
+		items: make(map[string]Drift),
+	}
+}
+
+// update replaces the outstanding drift with found, keeping the first-seen
+// time of drift that was already known.
+func (t *driftTracker) update(found []Drift, now time.Time) []Drift {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	items := make(map[string]Drift, len(found))
+	counts := map[string]float64{
+		DriftKindInterface: 0,
+		DriftKindPeer:      0,
+	}
+	var oldest time.Time
+	for _, drift := range found {
+		if known, ok := t.items[drift.key()]; ok {
+			drift.Since = known.Since
+		} else {
+			drift.Since = now
+		}
+		items[drift.key()] = drift
+		counts[drift.Kind]++
+		if oldest.IsZero() || drift.Since.Before(oldest) {
+			oldest = drift.Since
+		}
+	}
+	t.items = items
+
+	for kind, count := range counts {
+		metrics.DriftItems.WithLabelValues(kind).Set(count)
+	}
+	if oldest.IsZero() {
+		metrics.DriftSince.Set(0)
+	} else {
+		metrics.DriftSince.Set(float64(oldest.Unix()))
+	}
+
+	return t.list()
+}
+
+// resolved forgets all outstanding drift after it has been repaired.
+func (t *driftTracker) resolved() {
+	t.update(nil, time.Time{})
+}
+
+func (t *driftTracker) list() []Drift {
+	list := make([]Drift, 0, len(t.items))
+	for _, drift := range t.items {
+		list = append(list, drift)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].key() < list[j].key()
+	})
+	return list
+}
+
+// Outstanding returns the drift found by the last reconcile that hasn't been
+// repaired yet.
+func (t *driftTracker) Outstanding() []Drift {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.list()
+}
+
+func peerDrift(summary *Summary) []Drift {
+	drift := make([]Drift, 0, len(summary.Added)+len(summary.Removed)+len(summary.Updated))
+	for _, key := range summary.Added {
+		drift = append(drift, Drift{Kind: DriftKindPeer, Object: key, Detail: "peer is missing"})
+	}
+	for _, key := range summary.Removed {
+		drift = append(drift, Drift{Kind: DriftKindPeer, Object: key, Detail: "unexpected peer"})
+	}
+	for _, key := range summary.Updated {
+		drift = append(drift, Drift{Kind: DriftKindPeer, Object: key, Detail: "peer settings differ"})
+	}
+	return drift
+}
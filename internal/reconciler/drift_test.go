@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+package reconciler_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/events"
+	"github.com/kubewg-net/container/internal/peers"
+	"github.com/kubewg-net/container/internal/reconciler"
+	"github.com/kubewg-net/container/internal/resolver"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// pass triggers a reconcile of the running loop and waits for it to inspect
+// the device.
+func pass(t *testing.T, r *reconciler.Reconciler, device *fakeDevice) {
+	t.Helper()
+
+	device.mu.Lock()
+	inspects := device.inspects
+	device.mu.Unlock()
+	r.Trigger(reconciler.PriorityUrgent)
+	waitFor(t, "a reconcile", func() bool {
+		device.mu.Lock()
+		defer device.mu.Unlock()
+		return device.inspects > inspects
+	})
+}
+
+func TestDetectOnlyReportsDrift(t *testing.T) {
+	t.Parallel()
+
+	peer := newKey(t).PublicKey()
+	wg := &config.WireGuard{
+		ResyncInterval: config.Duration(time.Hour),
+		DetectOnly:     true,
+		Peers:          []config.WireGuardPeer{{PublicKey: peer.String(), AllowedIPs: []string{"10.0.0.7/32"}}},
+	}
+	device := &fakeDevice{key: newKey(t).PublicKey(), handshakes: make(map[wgtypes.Key]time.Time)}
+	res, err := resolver.NewResolver(&config.Resolver{Server: "127.0.0.1:53", Timeout: config.Duration(time.Second)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r := reconciler.NewReconciler(wg, device, peers.NewRegistry(wg.Peers), res, events.NewBus())
+
+	go r.Start(context.Background())
+	t.Cleanup(func() {
+		if err := r.Stop(context.Background()); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	// The first pass applies even in detect-only mode
+	waitFor(t, "the first pass", func() bool { return len(device.allowedIPs(peer)) == 1 })
+
+	device.mu.Lock()
+	device.peers = nil
+	device.drift = []string{"interface is down"}
+	device.mu.Unlock()
+
+	pass(t, r, device)
+	first := r.Drift()
+	if len(first) != 2 {
+		t.Fatalf("expected the missing peer and the interface to drift, got %v", first)
+	}
+
+	pass(t, r, device)
+	second := r.Drift()
+	if len(second) != 2 {
+		t.Fatalf("expected the drift to still be reported, got %v", second)
+	}
+	for i := range first {
+		if !second[i].Since.Equal(first[i].Since) {
+			t.Errorf("expected %s drift to be known since %s, got %s", second[i].Kind, first[i].Since, second[i].Since)
+		}
+	}
+	device.mu.Lock()
+	ups := device.ups
+	device.mu.Unlock()
+	if got := device.allowedIPs(peer); len(got) != 0 || ups != 0 {
+		t.Errorf("expected detect-only mode not to repair, got peer addresses %v and %d ups", got, ups)
+	}
+
+	summary, err := r.Reconcile(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !summary.Applied || len(summary.Drift) != 2 {
+		t.Errorf("expected the drift to be reported and repaired, got %+v", summary)
+	}
+	if drift := r.Drift(); len(drift) != 0 {
+		t.Errorf("expected repaired drift to be resolved, got %v", drift)
+	}
+	if got := device.allowedIPs(peer); len(got) != 1 {
+		t.Errorf("expected the peer to be restored, got %v", got)
+	}
+}
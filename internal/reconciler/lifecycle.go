@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package reconciler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/events"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// handshakeTimeout matches WireGuard's reject-after-time, after which a
+// session is dead if it was not renewed by a fresh handshake.
+const handshakeTimeout = 180 * time.Second
+
+// lifecycle turns successive reconciles into peer lifecycle events.
+type lifecycle struct {
+	bus       *events.Bus
+	keys      map[string]string
+	firstSeen map[string]time.Time
+	failing   map[string]struct{}
+}
+
+func newLifecycle(bus *events.Bus) *lifecycle {
+	return &lifecycle{
+		bus:       bus,
+		keys:      make(map[string]string),
+		firstSeen: make(map[string]time.Time),
+		failing:   make(map[string]struct{}),
+	}
+}
+
+// applied publishes the changes of a reconcile that was written to the
+// device.
+func (l *lifecycle) applied(summary *Summary, desired []config.WireGuardPeer, now time.Time) {
+	for _, publicKey := range summary.Added {
+		l.firstSeen[publicKey] = now
+		l.bus.Publish(events.PeerAdded, publicKey, "peer added to the interface")
+	}
+	for _, publicKey := range summary.Removed {
+		delete(l.firstSeen, publicKey)
+		delete(l.failing, publicKey)
+		l.bus.Publish(events.PeerRemoved, publicKey, "peer removed from the interface")
+	}
+
+	// Peers are keyed by public key, so a named peer that shows up under a
+	// new key has had its key rotated
+	for _, peer := range desired {
+		if peer.Name == "" {
+			continue
+		}
+		previous, ok := l.keys[peer.Name]
+		if ok && previous != peer.PublicKey {
+			l.bus.Publish(events.KeyRotated, peer.PublicKey, fmt.Sprintf("peer %s rotated its key from %s", peer.Name, previous))
+		}
+		l.keys[peer.Name] = peer.PublicKey
+	}
+}
+
+// handshakes publishes a failure when a peer we dial has gone without a
+// handshake for longer than handshakeTimeout, and a recovery once it
+// handshakes again.
+func (l *lifecycle) handshakes(current []wgtypes.Peer, now time.Time) {
+	for i := range current {
+		peer := &current[i]
+		publicKey := peer.PublicKey.String()
+		if peer.Endpoint == nil {
+			continue
+		}
+
+		since, ok := l.firstSeen[publicKey]
+		if !ok {
+			// Peers already on the interface when we started
+			since = now
+			l.firstSeen[publicKey] = now
+		}
+		if peer.LastHandshakeTime.After(since) {
+			since = peer.LastHandshakeTime
+		}
+
+		_, wasFailing := l.failing[publicKey]
+		failing := now.Sub(since) > handshakeTimeout
+		switch {
+		case failing && !wasFailing:
+			l.failing[publicKey] = struct{}{}
+			l.bus.Publish(events.HandshakeFailed, publicKey, fmt.Sprintf("no handshake with %s for over %s", peer.Endpoint, handshakeTimeout))
+		case !failing && wasFailing:
+			delete(l.failing, publicKey)
+			l.bus.Publish(events.HandshakeRestored, publicKey, fmt.Sprintf("handshake with %s succeeded", peer.Endpoint))
+		}
+	}
+}
+
+// deviceKey publishes a rotation of the interface's own key.
+func (l *lifecycle) deviceKey(previous, current wgtypes.Key) {
+	if previous != (wgtypes.Key{}) && previous != current {
+		l.bus.Publish(events.KeyRotated, current.String(), fmt.Sprintf("interface key rotated from %s", previous))
+	}
+}
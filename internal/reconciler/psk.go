@@ -0,0 +1,53 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package reconciler
+
+import (
+	"context"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+// PresharedKeySource supplies preshared keys for peers configured without
+// one, e.g. keys generated per pair of nodes.
+type PresharedKeySource interface {
+	// PresharedKey returns the key local shares with peer, or "" for none.
+	PresharedKey(ctx context.Context, local string, peer *config.WireGuardPeer) (string, error)
+}
+
+// SetPresharedKeys makes peers without a configured preshared key use the
+// one source supplies. It must be called before Start.
+func (r *Reconciler) SetPresharedKeys(source PresharedKeySource) {
+	r.presharedKeys = source
+}
+
+// fillPresharedKey sets the peer's preshared key from the source unless it
+// has one configured.
+func (r *Reconciler) fillPresharedKey(ctx context.Context, peer *config.WireGuardPeer) error {
+	if r.presharedKeys == nil || peer.PresharedKey != "" {
+		return nil
+	}
+	key, err := r.presharedKeys.PresharedKey(ctx, r.device.PublicKey().String(), peer)
+	if err != nil {
+		return err
+	}
+	peer.PresharedKey = key
+	return nil
+}
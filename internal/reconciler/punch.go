@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package reconciler
+
+import (
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+// punchKeepalive holds the NAT mapping of a punched path open for peers
+// without a keepalive of their own
+const punchKeepalive = 25 * time.Second
+
+// Punch points the peer with publicKey at endpoint, learned through hole
+// punching, and applies it right away so the handshake goes out at the
+// same time as the peer's. It wins over the configured endpoint, which is
+// what failed to connect in the first place.
+func (r *Reconciler) Punch(publicKey, endpoint string) {
+	r.punchMu.Lock()
+	r.punched[publicKey] = endpoint
+	r.punchMu.Unlock()
+
+	r.Trigger(PriorityUrgent)
+}
+
+func (r *Reconciler) punchedEndpoint(publicKey string) (string, bool) {
+	r.punchMu.Lock()
+	defer r.punchMu.Unlock()
+	endpoint, ok := r.punched[publicKey]
+	return endpoint, ok
+}
+
+func (r *Reconciler) forgetPunched(peer config.WireGuardPeer) {
+	r.punchMu.Lock()
+	defer r.punchMu.Unlock()
+	delete(r.punched, peer.PublicKey)
+}
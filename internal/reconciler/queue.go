@@ -0,0 +1,147 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package reconciler
+
+import (
+	"context"
+	"sync"
+
+	"github.com/kubewg-net/container/internal/metrics"
+)
+
+// Priority orders queued work. Higher priorities are always handed out
+// first, so revocations never wait behind routine resyncs.
+type Priority int
+
+const (
+	// PriorityRoutine is periodic work like resyncs and status refreshes
+	PriorityRoutine Priority = iota
+	// PriorityNormal is work caused by a change of the desired state
+	PriorityNormal
+	// PriorityUrgent is security relevant work like revoking a peer
+	PriorityUrgent
+
+	numPriorities = int(PriorityUrgent) + 1
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityRoutine:
+		return "routine"
+	case PriorityNormal:
+		return "normal"
+	case PriorityUrgent:
+		return "urgent"
+	}
+	return "unknown"
+}
+
+// Queue is a deduplicating priority work queue. A key that is queued again
+// before it was handed out stays queued once, at the higher of the two
+// priorities.
+type Queue struct {
+	mu      sync.Mutex
+	items   [numPriorities][]string
+	pending map[string]Priority
+	ready   chan struct{}
+}
+
+func NewQueue() *Queue {
+	return &Queue{
+		pending: make(map[string]Priority),
+		ready:   make(chan struct{}, 1),
+	}
+}
+
+// Add queues key at priority.
+func (q *Queue) Add(key string, priority Priority) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if current, ok := q.pending[key]; ok {
+		if current >= priority {
+			return
+		}
+		q.remove(key, current)
+	}
+	q.pending[key] = priority
+	q.items[priority] = append(q.items[priority], key)
+	q.updateMetrics()
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Get waits for the highest priority key, returning false once ctx is done.
+func (q *Queue) Get(ctx context.Context) (string, Priority, bool) {
+	for {
+		if key, priority, ok := q.pop(); ok {
+			return key, priority, true
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", 0, false
+		case <-q.ready:
+		}
+	}
+}
+
+// Len returns the number of queued keys.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+func (q *Queue) pop() (string, Priority, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for priority := numPriorities - 1; priority >= 0; priority-- {
+		if len(q.items[priority]) == 0 {
+			continue
+		}
+		key := q.items[priority][0]
+		q.items[priority] = q.items[priority][1:]
+		delete(q.pending, key)
+		q.updateMetrics()
+		return key, Priority(priority), true
+	}
+	return "", 0, false
+}
+
+func (q *Queue) remove(key string, priority Priority) {
+	items := q.items[priority]
+	for i := range items {
+		if items[i] == key {
+			q.items[priority] = append(items[:i], items[i+1:]...)
+			return
+		}
+	}
+}
+
+func (q *Queue) updateMetrics() {
+	for priority := range q.items {
+		metrics.QueueDepth.WithLabelValues(Priority(priority).String()).Set(float64(len(q.items[priority])))
+	}
+}
@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package reconciler_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/reconciler"
+)
+
+func TestQueuePriorityOrder(t *testing.T) {
+	t.Parallel()
+
+	queue := reconciler.NewQueue()
+	queue.Add("status", reconciler.PriorityRoutine)
+	queue.Add("resync", reconciler.PriorityRoutine)
+	queue.Add("revoke", reconciler.PriorityUrgent)
+	queue.Add("enroll", reconciler.PriorityNormal)
+
+	want := []string{"revoke", "enroll", "status", "resync"}
+	for _, expected := range want {
+		key, _, ok := queue.Get(context.Background())
+		if !ok || key != expected {
+			t.Fatalf("expected %s, got %s", expected, key)
+		}
+	}
+}
+
+func TestQueueDeduplicatesAndPromotes(t *testing.T) {
+	t.Parallel()
+
+	queue := reconciler.NewQueue()
+	queue.Add("peers", reconciler.PriorityRoutine)
+	queue.Add("status", reconciler.PriorityRoutine)
+	queue.Add("peers", reconciler.PriorityUrgent)
+	queue.Add("peers", reconciler.PriorityNormal)
+
+	if queue.Len() != 2 {
+		t.Fatalf("expected 2 queued keys, got %d", queue.Len())
+	}
+
+	key, priority, _ := queue.Get(context.Background())
+	if key != "peers" || priority != reconciler.PriorityUrgent {
+		t.Errorf("expected peers to be promoted to urgent, got %s at %s", key, priority)
+	}
+	key, _, _ = queue.Get(context.Background())
+	if key != "status" {
+		t.Errorf("expected status, got %s", key)
+	}
+}
+
+func TestQueueGetHonorsContext(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, _, ok := reconciler.NewQueue().Get(ctx); ok {
+		t.Error("expected Get on an empty queue to give up once the context is done")
+	}
+}
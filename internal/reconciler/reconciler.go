@@ -0,0 +1,472 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/kubewg-net/container/internal/acl"
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/events"
+	"github.com/kubewg-net/container/internal/metrics"
+	"github.com/kubewg-net/container/internal/peers"
+	"github.com/kubewg-net/container/internal/resolver"
+	"github.com/kubewg-net/container/internal/tracing"
+	"github.com/kubewg-net/container/internal/wireguard"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Work queued for the reconcile loop
+const (
+	workPeers  = "peers"
+	workStatus = "status"
+)
+
+// Reconciler periodically programs the configured peers onto the device,
+// resolving their endpoints through the shared resolver.
+type Reconciler struct {
+	config   *config.WireGuard
+	device   wireguard.Dataplane
+	registry *peers.Registry
+	resolver *resolver.Resolver
+	tracker  *wireguard.EndpointTracker
+	drift    *driftTracker
+	events   *lifecycle
+	relays   *relays
+	queue    *Queue
+	// acl is nil unless the interface enforces an ACL
+	acl *acl.Enforcer
+	// presharedKeys fills in keys for peers configured without one
+	presharedKeys PresharedKeySource
+	mu            sync.Mutex
+	// punched holds endpoints learned through hole punching by public key
+	punched map[string]string
+	punchMu sync.Mutex
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func NewReconciler(config *config.WireGuard, device wireguard.Dataplane, registry *peers.Registry, resolver *resolver.Resolver, bus *events.Bus) *Reconciler {
+	r := &Reconciler{
+		config:   config,
+		device:   device,
+		registry: registry,
+		resolver: resolver,
+		tracker:  wireguard.NewEndpointTracker(&config.HoldDown),
+		drift:    newDriftTracker(),
+		events:   newLifecycle(bus),
+		relays:   newRelays(&config.Relay),
+		queue:    NewQueue(),
+		punched:  make(map[string]string),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	if config.ACL.Enabled {
+		r.acl = acl.New(&config.ACL, config.Profiles, device.Name(), nil)
+	}
+	registry.OnChange(r.peerChanged)
+	return r
+}
+
+// peerChanged queues a reconcile for a registry change. Removing a peer
+// revokes its access, so it jumps the queue.
+func (r *Reconciler) peerChanged(peer config.WireGuardPeer, removed bool) {
+	if removed {
+		r.forgetPunched(peer)
+		r.Trigger(PriorityUrgent)
+		return
+	}
+	r.Trigger(PriorityNormal)
+}
+
+// Start processes queued work until Stop is called or ctx is done, queueing
+// a routine resync and status refresh every resync interval. Every pass
+// runs with ctx, so cancelling it also aborts a pass that is waiting on DNS.
+func (r *Reconciler) Start(ctx context.Context) {
+	defer close(r.done)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-r.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	go r.schedule(ctx)
+
+	slog.Info("Reconciler started", "interval", r.config.ResyncInterval, "detect_only", r.config.DetectOnly)
+
+	// The first pass always applies so the device starts out configured,
+	// detect-only mode only stops later passes from touching it
+	repair := true
+	r.queue.Add(workPeers, PriorityNormal)
+	for {
+		key, priority, ok := r.queue.Get(ctx)
+		if !ok {
+			return
+		}
+
+		switch key {
+		case workPeers:
+			r.process(ctx, priority, repair)
+			repair = !r.config.DetectOnly
+		case workStatus:
+			if err := r.refreshStatus(); err != nil {
+				slog.Warn("Failed to refresh peer status", "error", err.Error())
+			}
+		}
+	}
+}
+
+// Trigger queues a reconcile at priority, ahead of any routine work.
+func (r *Reconciler) Trigger(priority Priority) {
+	r.queue.Add(workPeers, priority)
+}
+
+func (r *Reconciler) schedule(ctx context.Context) {
+	ticker := time.NewTicker(r.config.ResyncInterval.Std())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.queue.Add(workStatus, PriorityRoutine)
+			r.queue.Add(workPeers, PriorityRoutine)
+		}
+	}
+}
+
+func (r *Reconciler) process(ctx context.Context, priority Priority, repair bool) {
+	summary, err := r.reconcile(ctx, repair)
+	switch {
+	case err != nil:
+		metrics.ReconcileErrors.WithLabelValues(r.device.Name()).Inc()
+		slog.Error("Reconcile failed", "priority", priority.String(), "error", err.Error())
+	case !summary.Applied && len(summary.Drift) > 0:
+		slog.Warn("Drift detected, not repairing in detect-only mode", "items", len(summary.Drift))
+	case summary.Changed():
+		slog.Info("Reconciled peers", "priority", priority.String(), "added", len(summary.Added), "removed", len(summary.Removed), "updated", len(summary.Updated))
+	}
+}
+
+// refreshStatus checks the peers' handshakes without touching the device.
+func (r *Reconciler) refreshStatus() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, err := r.device.Peers()
+	if err != nil {
+		return err
+	}
+	r.events.handshakes(current, time.Now())
+	return nil
+}
+
+// Stop ends the reconcile loop and waits for the current pass to finish, or
+// for ctx to be done.
+func (r *Reconciler) Stop(ctx context.Context) error {
+	close(r.stop)
+	select {
+	case <-r.done:
+		return r.removeACL(ctx)
+	case <-ctx.Done():
+		return fmt.Errorf("timed out waiting for the reconciler to stop: %w", ctx.Err())
+	}
+}
+
+// removeACL deletes the ACL along with the rest of the interface's rules,
+// unless the interface stays up, which would leave its peers unrestricted.
+func (r *Reconciler) removeACL(ctx context.Context) error {
+	if r.acl == nil || r.config.Teardown.KeepInterface {
+		return nil
+	}
+	if err := r.acl.Flush(ctx); err != nil {
+		return fmt.Errorf("failed to remove ACL: %w", err)
+	}
+	return nil
+}
+
+// Reconcile programs the desired peer set onto the device, repairs any
+// interface drift and reports what changed. It always applies, even in
+// detect-only mode, and is safe to call while the periodic loop is running.
+func (r *Reconciler) Reconcile(ctx context.Context) (*Summary, error) {
+	summary, err := r.reconcile(ctx, true)
+	if err != nil {
+		metrics.ReconcileErrors.WithLabelValues(r.device.Name()).Inc()
+	}
+	return summary, err
+}
+
+// UpdateNetwork replaces the network defaults, reapplying the interface when
+// its effective MTU changes. Peers pick up new defaults on the next reconcile.
+func (r *Reconciler) UpdateNetwork(ctx context.Context, network config.Network) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previousMTU := r.config.EffectiveMTU().Value
+	r.config.Network = network
+	if r.config.EffectiveMTU().Value == previousMTU {
+		return nil
+	}
+
+	slog.Info("Network MTU changed, reapplying interface", "network", network.Name)
+	if err := r.device.Up(ctx); err != nil {
+		return fmt.Errorf("failed to apply network MTU: %w", err)
+	}
+	return nil
+}
+
+// Drift returns the drift found by the last reconcile that is still
+// outstanding.
+func (r *Reconciler) Drift() []Drift {
+	return r.drift.Outstanding()
+}
+
+// reconcile runs a pass under the lock, in a span and timed, the trace
+// attached to the duration as an exemplar.
+func (r *Reconciler) reconcile(ctx context.Context, repair bool) (*Summary, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := r.device.Name()
+	ctx, span := tracing.Tracer().Start(ctx, "reconcile", trace.WithAttributes(
+		attribute.String("interface", name),
+		attribute.Bool("repair", repair),
+	))
+
+	start := time.Now()
+	summary, err := r.pass(ctx, repair)
+	metrics.Observe(ctx, metrics.ReconcileDuration.WithLabelValues(name), time.Since(start).Seconds())
+	if summary != nil {
+		span.SetAttributes(attribute.Bool("applied", summary.Applied))
+	}
+	tracing.End(span, err)
+	return summary, err
+}
+
+func (r *Reconciler) pass(ctx context.Context, repair bool) (*Summary, error) {
+	now := time.Now()
+	desired := r.config.TopologyPeers(r.registry.List())
+	peerConfigs := make([]wgtypes.PeerConfig, 0, len(desired))
+	seen := make(map[string]struct{}, len(desired))
+
+	// A peer that can't be configured is left out rather than holding up
+	// every other peer
+	valid := make([]config.WireGuardPeer, 0, len(desired))
+	for _, peer := range desired {
+		original := peer
+		peerConfig, ok, err := r.preparePeer(ctx, &peer, now)
+		if err != nil {
+			slog.Warn("Skipping peer with an invalid config", "public_key", peer.PublicKey, "name", peer.Name, "error", err.Error())
+			continue
+		}
+		valid = append(valid, original)
+		if !ok {
+			continue
+		}
+		seen[peer.PublicKey] = struct{}{}
+		peerConfigs = append(peerConfigs, peerConfig)
+	}
+	desired = valid
+
+	for _, status := range r.tracker.Status() {
+		if _, ok := seen[status.PublicKey]; !ok {
+			r.tracker.Forget(status.PublicKey)
+		}
+	}
+
+	var interfaceDrift []string
+	err := r.netlink(ctx, "inspect", func() (err error) {
+		interfaceDrift, err = r.device.Inspect()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var current []wgtypes.Peer
+	err = r.netlink(ctx, "peers", func() (err error) {
+		current, err = r.device.Peers()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.relays.apply(peerConfigs, current, now)
+	summary := diffPeers(current, peerConfigs)
+
+	found := peerDrift(summary)
+	for _, detail := range interfaceDrift {
+		found = append(found, Drift{Kind: DriftKindInterface, Object: r.device.Name(), Detail: detail})
+	}
+	summary.Drift = r.drift.update(found, now)
+	traceSummary(ctx, summary, desired)
+
+	if !repair {
+		summary.Duration = time.Since(now)
+		return summary, nil
+	}
+
+	if len(interfaceDrift) > 0 {
+		slog.Warn("Repairing WireGuard interface", "drift", interfaceDrift)
+		previousKey := r.device.PublicKey()
+		if err := r.netlink(ctx, "up", func() error {
+			return r.device.Up(ctx)
+		}); err != nil {
+			return nil, err
+		}
+		r.events.deviceKey(previousKey, r.device.PublicKey())
+	}
+
+	previousKey := r.device.PublicKey()
+	var rotated bool
+	err = r.netlink(ctx, "rotate_key", func() (err error) {
+		rotated, err = r.device.RotateKeyIfDue(ctx, now)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate private key: %w", err)
+	}
+	if rotated {
+		slog.Info("Rotated WireGuard private key", "public_key", r.device.PublicKey().String())
+		r.events.deviceKey(previousKey, r.device.PublicKey())
+	}
+
+	// The ACL goes first, so a peer is never reachable before the rules
+	// naming it are in place
+	if r.acl != nil {
+		if err := r.acl.Sync(ctx, desired); err != nil {
+			return nil, fmt.Errorf("failed to apply ACL: %w", err)
+		}
+	}
+
+	err = r.netlink(ctx, "configure_peers", func() error {
+		return r.device.ConfigurePeers(peerConfigs)
+	}, attribute.Int("peers", len(peerConfigs)))
+	if err != nil {
+		return nil, err
+	}
+	r.drift.resolved()
+	r.events.applied(summary, desired, now)
+
+	summary.Applied = true
+	summary.Duration = time.Since(now)
+	return summary, nil
+}
+
+// preparePeer builds the device config of a desired peer in a span naming
+// it. A peer whose preshared key isn't available yet is left out, without
+// the key the pair agreed on the handshake fails anyway, so the peer waits
+// for the next pass.
+func (r *Reconciler) preparePeer(ctx context.Context, peer *config.WireGuardPeer, now time.Time) (peerConfig wgtypes.PeerConfig, ok bool, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "reconcile.peer", trace.WithAttributes(tracing.Peer(peer.PublicKey, peer.Name)...))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	if keyErr := r.fillPresharedKey(ctx, peer); keyErr != nil {
+		slog.Warn("Leaving out peer until its preshared key is available", "public_key", peer.PublicKey, "error", keyErr.Error())
+		span.SetAttributes(attribute.Bool("deferred", true))
+		return wgtypes.PeerConfig{}, false, nil
+	}
+	peerConfig, err = r.peerConfig(ctx, peer, now)
+	return peerConfig, err == nil, err
+}
+
+// Status returns the endpoint state of every peer.
+func (r *Reconciler) Status() []wireguard.EndpointStatus {
+	return r.tracker.Status()
+}
+
+func (r *Reconciler) peerConfig(ctx context.Context, peer *config.WireGuardPeer, now time.Time) (wgtypes.PeerConfig, error) {
+	publicKey, err := wgtypes.ParseKey(peer.PublicKey)
+	if err != nil {
+		return wgtypes.PeerConfig{}, fmt.Errorf("invalid public key %q: %w", peer.PublicKey, err)
+	}
+
+	allowedIPs := make([]net.IPNet, 0, len(peer.AllowedIPs))
+	for _, cidr := range peer.AllowedIPs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return wgtypes.PeerConfig{}, fmt.Errorf("invalid allowed IP %q for peer %s: %w", cidr, peer.PublicKey, err)
+		}
+		allowedIPs = append(allowedIPs, *ipNet)
+	}
+
+	peerConfig := wgtypes.PeerConfig{
+		PublicKey:         publicKey,
+		ReplaceAllowedIPs: true,
+		AllowedIPs:        allowedIPs,
+	}
+
+	if peer.PresharedKey != "" {
+		presharedKey, err := wgtypes.ParseKey(peer.PresharedKey)
+		if err != nil {
+			return wgtypes.PeerConfig{}, fmt.Errorf("invalid preshared key for peer %s: %w", peer.PublicKey, err)
+		}
+		peerConfig.PresharedKey = &presharedKey
+	}
+
+	configured := peer.Endpoint
+	punched, isPunched := r.punchedEndpoint(peer.PublicKey)
+	if isPunched {
+		configured = punched
+	}
+
+	if keepalive := r.config.EffectiveKeepalive(peer).Value; keepalive != 0 {
+		interval := time.Duration(keepalive) * time.Second
+		peerConfig.PersistentKeepaliveInterval = &interval
+	} else if isPunched {
+		interval := punchKeepalive
+		peerConfig.PersistentKeepaliveInterval = &interval
+	}
+
+	if configured != "" {
+		endpoint := r.tracker.Current(peer.PublicKey)
+		addr, err := r.resolver.ResolveUDPAddr(ctx, configured)
+		if err != nil {
+			slog.Warn("Failed to resolve peer endpoint", "public_key", peer.PublicKey, "endpoint", configured, "error", err.Error())
+		} else if isPunched {
+			endpoint = r.tracker.Set(peer.PublicKey, addr.String(), now)
+		} else {
+			endpoint = r.tracker.Observe(peer.PublicKey, addr.String(), now)
+		}
+
+		if endpoint != "" {
+			peerConfig.Endpoint, err = net.ResolveUDPAddr("udp", endpoint)
+			if err != nil {
+				return wgtypes.PeerConfig{}, fmt.Errorf("invalid endpoint %q for peer %s: %w", endpoint, peer.PublicKey, err)
+			}
+		}
+	}
+
+	return peerConfig, nil
+}
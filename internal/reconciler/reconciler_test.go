@@ -0,0 +1,65 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package reconciler_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/events"
+	"github.com/kubewg-net/container/internal/peers"
+	"github.com/kubewg-net/container/internal/reconciler"
+	"github.com/kubewg-net/container/internal/resolver"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestInvalidPeerIsSkipped(t *testing.T) {
+	t.Parallel()
+
+	good, bad := newKey(t).PublicKey(), newKey(t).PublicKey()
+	wg := &config.WireGuard{ResyncInterval: config.Duration(30 * time.Second)}
+	device := &fakeDevice{key: newKey(t).PublicKey(), handshakes: make(map[wgtypes.Key]time.Time)}
+	res, err := resolver.NewResolver(&config.Resolver{Server: "127.0.0.1:53", Timeout: config.Duration(time.Second)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	registry := peers.NewRegistry(nil)
+	for _, peer := range []config.WireGuardPeer{
+		{PublicKey: good.String(), AllowedIPs: []string{"10.0.0.2/32"}},
+		{PublicKey: bad.String(), AllowedIPs: []string{"10.0.0.300/32"}},
+	} {
+		if err := registry.Add(peer); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	r := reconciler.NewReconciler(wg, device, registry, res, events.NewBus())
+
+	if _, err := r.Reconcile(context.Background()); err != nil {
+		t.Fatalf("expected the pass to go ahead without the invalid peer, got %v", err)
+	}
+	if got := device.allowedIPs(good); len(got) != 1 || got[0] != "10.0.0.2/32" {
+		t.Errorf("expected the valid peer to be configured, got %v", got)
+	}
+	if got, _ := device.Peers(); len(got) != 1 {
+		t.Errorf("expected only the valid peer on the device, got %d peers", len(got))
+	}
+}
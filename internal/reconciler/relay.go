@@ -0,0 +1,174 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package reconciler
+
+import (
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/metrics"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// relays reroutes peers whose direct path failed through a relay peer.
+// WireGuard routes by allowed IPs, so relaying a peer means handing its
+// allowed IPs to the relay, which forwards them over its own session with
+// the peer. The peer's entry stays with its endpoint and a keepalive, so
+// a direct handshake coming back ends the detour.
+//
+// A path counts as failed once a peer we dial with a persistent keepalive
+// went without a handshake for the failover time. Without a keepalive an
+// idle peer looks no different from an unreachable one, so such peers are
+// never relayed.
+type relays struct {
+	config *config.Relay
+	// firstSeen gives newly added peers time for their first handshake
+	firstSeen map[string]time.Time
+	// relayed maps relayed peers to their relay
+	relayed  map[string]string
+	transfer map[string]wgtypes.Peer
+}
+
+func newRelays(config *config.Relay) *relays {
+	return &relays{
+		config:    config,
+		firstSeen: make(map[string]time.Time),
+		relayed:   make(map[string]string),
+		transfer:  make(map[string]wgtypes.Peer),
+	}
+}
+
+// apply rewrites desired so failed peers go through the preferred relay
+// with a live session.
+func (r *relays) apply(desired []wgtypes.PeerConfig, current []wgtypes.Peer, now time.Time) {
+	if len(r.config.Peers) == 0 {
+		return
+	}
+
+	live := make(map[string]wgtypes.Peer, len(current))
+	for _, peer := range current {
+		live[peer.PublicKey.String()] = peer
+	}
+	index := make(map[string]int, len(desired))
+	for i := range desired {
+		index[desired[i].PublicKey.String()] = i
+	}
+
+	failover := r.config.FailoverAfter.Std()
+	relay := ""
+	for _, candidate := range r.config.Peers {
+		peer, ok := live[candidate]
+		if _, wanted := index[candidate]; ok && wanted && now.Sub(peer.LastHandshakeTime) < failover {
+			relay = candidate
+			break
+		}
+	}
+
+	for publicKey := range r.firstSeen {
+		if _, ok := index[publicKey]; !ok {
+			delete(r.firstSeen, publicKey)
+			r.restore(publicKey, "peer removed")
+		}
+	}
+
+	for i := range desired {
+		peerConfig := &desired[i]
+		publicKey := peerConfig.PublicKey.String()
+		if slices.Contains(r.config.Peers, publicKey) {
+			continue
+		}
+		if _, ok := r.firstSeen[publicKey]; !ok {
+			r.firstSeen[publicKey] = now
+		}
+
+		if !r.failed(peerConfig, live[publicKey], now, failover) {
+			r.restore(publicKey, "direct handshake")
+			continue
+		}
+		if relay == "" {
+			r.restore(publicKey, "no relay with a live session")
+			continue
+		}
+
+		if previous := r.relayed[publicKey]; previous != relay {
+			if previous != "" {
+				metrics.PeerRelayed.DeleteLabelValues(publicKey, previous)
+			}
+			slog.Warn("Direct path to peer failed, relaying", "public_key", publicKey, "relay", relay)
+			r.relayed[publicKey] = relay
+			metrics.PeerRelayed.WithLabelValues(publicKey, relay).Set(1)
+		}
+
+		relayConfig := &desired[index[relay]]
+		relayConfig.AllowedIPs = append(relayConfig.AllowedIPs, peerConfig.AllowedIPs...)
+		peerConfig.AllowedIPs = nil
+	}
+
+	r.count(live)
+}
+
+func (r *relays) failed(peerConfig *wgtypes.PeerConfig, peer wgtypes.Peer, now time.Time, failover time.Duration) bool {
+	if peerConfig.Endpoint == nil || peerConfig.PersistentKeepaliveInterval == nil || *peerConfig.PersistentKeepaliveInterval == 0 {
+		return false
+	}
+	since := peer.LastHandshakeTime
+	if since.IsZero() {
+		since = r.firstSeen[peer.PublicKey.String()]
+	}
+	return now.Sub(since) >= failover
+}
+
+func (r *relays) restore(publicKey, reason string) {
+	relay, ok := r.relayed[publicKey]
+	if !ok {
+		return
+	}
+	slog.Info("Stopped relaying peer", "public_key", publicKey, "relay", relay, "reason", reason)
+	delete(r.relayed, publicKey)
+	metrics.PeerRelayed.DeleteLabelValues(publicKey, relay)
+}
+
+// count adds what was exchanged with each relay since the last pass to the
+// relay metrics, as long as the relay carries any peer.
+func (r *relays) count(live map[string]wgtypes.Peer) {
+	active := make(map[string]struct{}, len(r.relayed))
+	for _, relay := range r.relayed {
+		active[relay] = struct{}{}
+	}
+
+	for _, relay := range r.config.Peers {
+		peer, ok := live[relay]
+		if !ok {
+			delete(r.transfer, relay)
+			continue
+		}
+		previous, seen := r.transfer[relay]
+		r.transfer[relay] = peer
+
+		// Counters restart when the peer is re-added
+		if _, ok := active[relay]; !ok || !seen || peer.ReceiveBytes < previous.ReceiveBytes || peer.TransmitBytes < previous.TransmitBytes {
+			continue
+		}
+		metrics.RelayTransferBytes.WithLabelValues(relay, "rx").Add(float64(peer.ReceiveBytes - previous.ReceiveBytes))
+		metrics.RelayTransferBytes.WithLabelValues(relay, "tx").Add(float64(peer.TransmitBytes - previous.TransmitBytes))
+	}
+}
@@ -0,0 +1,179 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package reconciler_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/events"
+	"github.com/kubewg-net/container/internal/peers"
+	"github.com/kubewg-net/container/internal/reconciler"
+	"github.com/kubewg-net/container/internal/resolver"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// fakeDevice keeps programmed peers in memory and reports the handshake
+// times and interface drift the test sets. Up repairs the drift.
+type fakeDevice struct {
+	mu         sync.Mutex
+	key        wgtypes.Key
+	peers      []wgtypes.Peer
+	handshakes map[wgtypes.Key]time.Time
+	drift      []string
+	inspects   int
+	ups        int
+}
+
+func (d *fakeDevice) Name() string                                                { return "wg-test" }
+func (d *fakeDevice) Down() error                                                 { return nil }
+func (d *fakeDevice) PublicKey() wgtypes.Key                                      { return d.key }
+func (d *fakeDevice) MTU() int                                                    { return 1420 }
+func (d *fakeDevice) RotateKeyIfDue(_ context.Context, _ time.Time) (bool, error) { return false, nil }
+
+func (d *fakeDevice) Up(_ context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ups++
+	d.drift = nil
+	return nil
+}
+
+func (d *fakeDevice) Inspect() ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inspects++
+	return append([]string(nil), d.drift...), nil
+}
+
+func (d *fakeDevice) Peers() ([]wgtypes.Peer, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	current := make([]wgtypes.Peer, 0, len(d.peers))
+	for _, peer := range d.peers {
+		peer.LastHandshakeTime = d.handshakes[peer.PublicKey]
+		current = append(current, peer)
+	}
+	return current, nil
+}
+
+func (d *fakeDevice) ConfigurePeers(configs []wgtypes.PeerConfig) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.peers = d.peers[:0]
+	for _, peerConfig := range configs {
+		peer := wgtypes.Peer{
+			PublicKey:  peerConfig.PublicKey,
+			Endpoint:   peerConfig.Endpoint,
+			AllowedIPs: peerConfig.AllowedIPs,
+		}
+		if peerConfig.PersistentKeepaliveInterval != nil {
+			peer.PersistentKeepaliveInterval = *peerConfig.PersistentKeepaliveInterval
+		}
+		d.peers = append(d.peers, peer)
+	}
+	return nil
+}
+
+func (d *fakeDevice) allowedIPs(key wgtypes.Key) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var allowedIPs []string
+	for _, peer := range d.peers {
+		if peer.PublicKey == key {
+			for _, ipNet := range peer.AllowedIPs {
+				allowedIPs = append(allowedIPs, ipNet.String())
+			}
+		}
+	}
+	return allowedIPs
+}
+
+func (d *fakeDevice) setHandshake(key wgtypes.Key, at time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handshakes[key] = at
+}
+
+func newKey(t *testing.T) wgtypes.Key {
+	t.Helper()
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return key
+}
+
+func TestFailedPeerIsRelayedUntilDirectHandshake(t *testing.T) {
+	t.Parallel()
+
+	relay, peer := newKey(t).PublicKey(), newKey(t).PublicKey()
+	wg := &config.WireGuard{
+		ResyncInterval: config.Duration(30 * time.Second),
+		HoldDown:       config.HoldDown{Duration: config.Duration(30 * time.Second), FlapThreshold: 3, FlapWindow: config.Duration(5 * time.Minute)},
+		Relay:          config.Relay{Peers: []string{relay.String()}, FailoverAfter: config.Duration(5 * time.Minute)},
+		Peers: []config.WireGuardPeer{
+			{PublicKey: relay.String(), Endpoint: "192.0.2.1:51820", AllowedIPs: []string{"10.0.0.1/32"}, PersistentKeepalive: 25},
+			{PublicKey: peer.String(), Endpoint: "198.51.100.7:51820", AllowedIPs: []string{"10.0.0.7/32"}, PersistentKeepalive: 25},
+		},
+	}
+	device := &fakeDevice{key: newKey(t).PublicKey(), handshakes: make(map[wgtypes.Key]time.Time)}
+	res, err := resolver.NewResolver(&config.Resolver{Server: "127.0.0.1:53", Timeout: config.Duration(time.Second)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r := reconciler.NewReconciler(wg, device, peers.NewRegistry(wg.Peers), res, events.NewBus())
+
+	now := time.Now()
+	device.setHandshake(relay, now)
+	device.setHandshake(peer, now.Add(-10*time.Minute))
+	if _, err := r.Reconcile(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The first pass programs the peers, the second sees the stale handshake
+	if _, err := r.Reconcile(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := device.allowedIPs(relay); len(got) != 2 || got[1] != "10.0.0.7/32" {
+		t.Fatalf("expected the relay to carry the failed peer's address, got %v", got)
+	}
+	if got := device.allowedIPs(peer); len(got) != 0 {
+		t.Fatalf("expected the failed peer to keep no addresses, got %v", got)
+	}
+
+	device.setHandshake(peer, time.Now())
+	if _, err := r.Reconcile(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := device.allowedIPs(relay); len(got) != 1 || got[0] != "10.0.0.1/32" {
+		t.Errorf("expected the relay to be back to its own address, got %v", got)
+	}
+	if got := device.allowedIPs(peer); len(got) != 1 || got[0] != "10.0.0.7/32" {
+		t.Errorf("expected the peer to have its address back, got %v", got)
+	}
+}
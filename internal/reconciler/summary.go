@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package reconciler
+
+import (
+	"net"
+	"slices"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Summary describes the changes a reconcile applied to the device. When
+// Applied is false the reconcile only detected drift and the peer lists
+// describe what would have changed.
+type Summary struct {
+	Applied   bool          `json:"applied"`
+	Added     []string      `json:"added"`
+	Removed   []string      `json:"removed"`
+	Updated   []string      `json:"updated"`
+	Unchanged int           `json:"unchanged"`
+	Drift     []Drift       `json:"drift"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// Changed reports whether the reconcile touched any peer.
+func (s *Summary) Changed() bool {
+	return len(s.Added)+len(s.Removed)+len(s.Updated) > 0
+}
+
+func diffPeers(current []wgtypes.Peer, desired []wgtypes.PeerConfig) *Summary {
+	summary := &Summary{
+		Added:   []string{},
+		Removed: []string{},
+		Updated: []string{},
+	}
+
+	existing := make(map[wgtypes.Key]*wgtypes.Peer, len(current))
+	for i := range current {
+		existing[current[i].PublicKey] = &current[i]
+	}
+
+	for i := range desired {
+		peer, ok := existing[desired[i].PublicKey]
+		switch {
+		case !ok:
+			summary.Added = append(summary.Added, desired[i].PublicKey.String())
+		case peerDiffers(peer, &desired[i]):
+			summary.Updated = append(summary.Updated, desired[i].PublicKey.String())
+		default:
+			summary.Unchanged++
+		}
+		delete(existing, desired[i].PublicKey)
+	}
+
+	for key := range existing {
+		summary.Removed = append(summary.Removed, key.String())
+	}
+	slices.Sort(summary.Removed)
+
+	return summary
+}
+
+func peerDiffers(current *wgtypes.Peer, desired *wgtypes.PeerConfig) bool {
+	if desired.Endpoint != nil && (current.Endpoint == nil || current.Endpoint.String() != desired.Endpoint.String()) {
+		return true
+	}
+
+	var presharedKey wgtypes.Key
+	if desired.PresharedKey != nil {
+		presharedKey = *desired.PresharedKey
+	}
+	if current.PresharedKey != presharedKey {
+		return true
+	}
+
+	var keepalive time.Duration
+	if desired.PersistentKeepaliveInterval != nil {
+		keepalive = *desired.PersistentKeepaliveInterval
+	}
+	if current.PersistentKeepaliveInterval != keepalive {
+		return true
+	}
+
+	return !sameIPNets(current.AllowedIPs, desired.AllowedIPs)
+}
+
+func sameIPNets(a, b []net.IPNet) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	seen := make(map[string]struct{}, len(a))
+	for _, ipNet := range a {
+		seen[ipNet.String()] = struct{}{}
+	}
+	for _, ipNet := range b {
+		if _, ok := seen[ipNet.String()]; !ok {
+			return false
+		}
+	}
+	return true
+}
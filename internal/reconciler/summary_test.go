@@ -0,0 +1,155 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package reconciler_test
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/events"
+	"github.com/kubewg-net/container/internal/peers"
+	"github.com/kubewg-net/container/internal/reconciler"
+	"github.com/kubewg-net/container/internal/resolver"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestSummary(t *testing.T) {
+	t.Parallel()
+
+	key := newKey(t).PublicKey()
+	endpoint := func(s string) *net.UDPAddr {
+		addr, err := net.ResolveUDPAddr("udp", s)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return addr
+	}
+	allowedIPs := func(cidrs ...string) []net.IPNet {
+		var ipNets []net.IPNet
+		for _, cidr := range cidrs {
+			_, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			ipNets = append(ipNets, *ipNet)
+		}
+		return ipNets
+	}
+	live := wgtypes.Peer{
+		PublicKey:  key,
+		Endpoint:   endpoint("192.0.2.1:51820"),
+		AllowedIPs: allowedIPs("10.0.0.2/32", "10.1.0.0/24"),
+	}
+
+	tests := []struct {
+		name      string
+		current   []wgtypes.Peer
+		desired   []config.WireGuardPeer
+		added     []string
+		removed   []string
+		updated   []string
+		unchanged int
+	}{
+		{
+			name:    "added",
+			desired: []config.WireGuardPeer{{PublicKey: key.String(), AllowedIPs: []string{"10.0.0.2/32"}}},
+			added:   []string{key.String()},
+		},
+		{
+			name:    "removed",
+			current: []wgtypes.Peer{live},
+			removed: []string{key.String()},
+		},
+		{
+			name:    "changed endpoint",
+			current: []wgtypes.Peer{live},
+			desired: []config.WireGuardPeer{{PublicKey: key.String(), Endpoint: "192.0.2.2:51820", AllowedIPs: []string{"10.0.0.2/32", "10.1.0.0/24"}}},
+			updated: []string{key.String()},
+		},
+		{
+			name:    "changed allowed IPs",
+			current: []wgtypes.Peer{live},
+			desired: []config.WireGuardPeer{{PublicKey: key.String(), Endpoint: "192.0.2.1:51820", AllowedIPs: []string{"10.0.0.2/32", "10.2.0.0/24"}}},
+			updated: []string{key.String()},
+		},
+		{
+			name:    "fewer allowed IPs",
+			current: []wgtypes.Peer{live},
+			desired: []config.WireGuardPeer{{PublicKey: key.String(), Endpoint: "192.0.2.1:51820", AllowedIPs: []string{"10.0.0.2/32"}}},
+			updated: []string{key.String()},
+		},
+		{
+			name:      "unchanged",
+			current:   []wgtypes.Peer{live},
+			desired:   []config.WireGuardPeer{{PublicKey: key.String(), Endpoint: "192.0.2.1:51820", AllowedIPs: []string{"10.1.0.0/24", "10.0.0.2/32"}}},
+			unchanged: 1,
+		},
+		{
+			// The endpoint a peer roamed to is kept when none is configured
+			name:      "roamed without an endpoint",
+			current:   []wgtypes.Peer{live},
+			desired:   []config.WireGuardPeer{{PublicKey: key.String(), AllowedIPs: []string{"10.0.0.2/32", "10.1.0.0/24"}}},
+			unchanged: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			device := &fakeDevice{key: newKey(t).PublicKey(), peers: slices.Clone(tt.current), handshakes: make(map[wgtypes.Key]time.Time)}
+			res, err := resolver.NewResolver(&config.Resolver{Server: "127.0.0.1:53", Timeout: config.Duration(time.Second)})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			registry := peers.NewRegistry(nil)
+			for _, peer := range tt.desired {
+				if err := registry.Add(peer); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			r := reconciler.NewReconciler(&config.WireGuard{}, device, registry, res, events.NewBus())
+
+			summary, err := r.Reconcile(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, check := range []struct {
+				field    string
+				got      []string
+				expected []string
+			}{
+				{"added", summary.Added, tt.added},
+				{"removed", summary.Removed, tt.removed},
+				{"updated", summary.Updated, tt.updated},
+			} {
+				if !slices.Equal(check.got, check.expected) && len(check.got)+len(check.expected) > 0 {
+					t.Errorf("expected %s %v, got %v", check.field, check.expected, check.got)
+				}
+			}
+			if summary.Unchanged != tt.unchanged {
+				t.Errorf("expected %d unchanged, got %d", tt.unchanged, summary.Unchanged)
+			}
+		})
+	}
+}
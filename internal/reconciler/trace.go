@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package reconciler
+
+import (
+	"context"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// netlink runs a device operation in a span of its own under the pass.
+func (r *Reconciler) netlink(ctx context.Context, operation string, fn func() error, attributes ...attribute.KeyValue) error {
+	_, span := tracing.Tracer().Start(ctx, "netlink."+operation, trace.WithAttributes(
+		append(attributes, attribute.String("interface", r.device.Name()))...,
+	))
+	err := fn()
+	tracing.End(span, err)
+	return err
+}
+
+// traceSummary adds what a pass found to its span, an event per peer it
+// adds, updates or removes.
+func traceSummary(ctx context.Context, summary *Summary, desired []config.WireGuardPeer) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+
+	nodes := make(map[string]string, len(desired))
+	for _, peer := range desired {
+		nodes[peer.PublicKey] = peer.Name
+	}
+	for _, change := range []struct {
+		event string
+		keys  []string
+	}{
+		{"peer added", summary.Added},
+		{"peer updated", summary.Updated},
+		{"peer removed", summary.Removed},
+	} {
+		for _, key := range change.keys {
+			span.AddEvent(change.event, trace.WithAttributes(tracing.Peer(key, nodes[key])...))
+		}
+	}
+	span.SetAttributes(
+		attribute.Int("peers.added", len(summary.Added)),
+		attribute.Int("peers.updated", len(summary.Updated)),
+		attribute.Int("peers.removed", len(summary.Removed)),
+		attribute.Int("peers.unchanged", summary.Unchanged),
+		attribute.Int("drift", len(summary.Drift)),
+	)
+}
@@ -0,0 +1,156 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package remotewrite
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// series is one time series of a write request with its single sample.
+type series struct {
+	labels    []label
+	value     float64
+	timestamp int64
+}
+
+type label struct {
+	name, value string
+}
+
+// toSeries flattens the selected families the way the text exposition
+// does, histograms and summaries into their _bucket, _sum and _count or
+// quantile series. Samples without a timestamp of their own get now.
+func toSeries(families []*dto.MetricFamily, selected func(string) bool, extra map[string]string, now time.Time) []series {
+	var out []series
+	for _, family := range families {
+		name := family.GetName()
+		if !selected(name) {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			timestamp := now.UnixMilli()
+			if metric.TimestampMs != nil {
+				timestamp = metric.GetTimestampMs()
+			}
+			add := func(name string, value float64, more ...label) {
+				out = append(out, series{
+					labels:    labelSet(name, metric.GetLabel(), extra, more),
+					value:     value,
+					timestamp: timestamp,
+				})
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, metric.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, metric.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, metric.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				summary := metric.GetSummary()
+				for _, quantile := range summary.GetQuantile() {
+					add(name, quantile.GetValue(), label{"quantile", formatFloat(quantile.GetQuantile())})
+				}
+				add(name+"_sum", summary.GetSampleSum())
+				add(name+"_count", float64(summary.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+				histogram := metric.GetHistogram()
+				for _, bucket := range histogram.GetBucket() {
+					add(name+"_bucket", float64(bucket.GetCumulativeCount()), label{"le", formatFloat(bucket.GetUpperBound())})
+				}
+				add(name+"_bucket", float64(histogram.GetSampleCount()), label{"le", "+Inf"})
+				add(name+"_sum", histogram.GetSampleSum())
+				add(name+"_count", float64(histogram.GetSampleCount()))
+			}
+		}
+	}
+	return out
+}
+
+// labelSet returns the labels of a series sorted by name, as remote write
+// requires. The metric's own labels win over the extra ones.
+func labelSet(name string, pairs []*dto.LabelPair, extra map[string]string, more []label) []label {
+	set := make(map[string]string, len(pairs)+len(extra)+len(more)+1)
+	for key, value := range extra {
+		set[key] = value
+	}
+	for _, pair := range pairs {
+		set[pair.GetName()] = pair.GetValue()
+	}
+	for _, l := range more {
+		set[l.name] = l.value
+	}
+	set["__name__"] = name
+
+	labels := make([]label, 0, len(set))
+	for key, value := range set {
+		labels = append(labels, label{key, value})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+	return labels
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// encodeWriteRequest encodes a prometheus.WriteRequest by hand, the only
+// message pushed doesn't warrant the generated code:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []series) []byte {
+	var request []byte
+	for _, s := range series {
+		var ts []byte
+		for _, l := range s.labels {
+			var encoded []byte
+			encoded = protowire.AppendTag(encoded, 1, protowire.BytesType)
+			encoded = protowire.AppendString(encoded, l.name)
+			encoded = protowire.AppendTag(encoded, 2, protowire.BytesType)
+			encoded = protowire.AppendString(encoded, l.value)
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, encoded)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.timestamp))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sample)
+
+		request = protowire.AppendTag(request, 1, protowire.BytesType)
+		request = protowire.AppendBytes(request, ts)
+	}
+	return request
+}
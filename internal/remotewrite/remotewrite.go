@@ -0,0 +1,205 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package remotewrite pushes metrics to a Prometheus remote-write endpoint
+// on an interval, for nodes whose metrics listener the monitoring cluster
+// can't reach.
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	ErrNoCACerts = errors.New("no certificates found in CA file")
+	ErrResponse  = errors.New("unexpected response from remote-write endpoint")
+)
+
+type Pusher struct {
+	config   *config.RemoteWrite
+	client   *http.Client
+	gatherer prometheus.Gatherer
+	include  []*regexp.Regexp
+	labels   map[string]string
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewPusher pushes what gatherer collects, labeled with the config's labels
+// and the node name, unless the labels set one.
+func NewPusher(config *config.RemoteWrite, nodeName string, gatherer prometheus.Gatherer) (*Pusher, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read remote-write CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, ErrNoCACerts
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	include := make([]*regexp.Regexp, 0, len(config.Include))
+	for _, pattern := range config.Include {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid remote-write include %q: %w", pattern, err)
+		}
+		include = append(include, re)
+	}
+
+	labels := make(map[string]string, len(config.Labels)+1)
+	if nodeName != "" {
+		labels["node"] = nodeName
+	}
+	for name, value := range config.Labels {
+		labels[name] = value
+	}
+
+	return &Pusher{
+		config: config,
+		client: &http.Client{
+			Timeout: config.Timeout.Std(),
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+		gatherer: gatherer,
+		include:  include,
+		labels:   labels,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// Start pushes every interval until Stop is called or ctx is done. A failed
+// push isn't retried, the next one carries fresh values anyway.
+func (p *Pusher) Start(ctx context.Context) {
+	defer close(p.done)
+
+	interval := p.config.Interval.Std()
+	slog.Info("Metrics remote write started", "url", p.config.URL, "interval", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			if err := p.Push(ctx); err != nil {
+				metrics.RemoteWritePushes.WithLabelValues("failed").Inc()
+				slog.Warn("Failed to push metrics", "error", err.Error())
+				continue
+			}
+			metrics.RemoteWritePushes.WithLabelValues("success").Inc()
+		}
+	}
+}
+
+func (p *Pusher) Stop(ctx context.Context) error {
+	close(p.stop)
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("timed out waiting for remote write to stop: %w", ctx.Err())
+	}
+}
+
+// Push gathers the selected metrics and sends them in one write request.
+func (p *Pusher) Push(ctx context.Context) error {
+	families, err := p.gatherer.Gather()
+	if err != nil {
+		// Gather returns what it could collect along with the error
+		slog.Warn("Failed to gather some metrics", "error", err.Error())
+	}
+	series := toSeries(families, p.selected, p.labels, time.Now())
+	if len(series) == 0 {
+		return nil
+	}
+	body := snappy.Encode(nil, encodeWriteRequest(series))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for name, value := range p.config.Headers {
+		req.Header.Set(name, value)
+	}
+	switch {
+	case p.config.Token != "":
+		req.Header.Set("Authorization", "Bearer "+p.config.Token)
+	case p.config.BasicAuthUser != "":
+		req.SetBasicAuth(p.config.BasicAuthUser, p.config.BasicAuthPassword)
+	}
+	if p.config.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", p.config.TenantID)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%w: %s: %s", ErrResponse, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+func (p *Pusher) selected(name string) bool {
+	if len(p.include) == 0 {
+		return true
+	}
+	for _, re := range p.include {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package remotewrite_test
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/remotewrite"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestPush(t *testing.T) {
+	t.Parallel()
+
+	registry := prometheus.NewRegistry()
+	peers := prometheus.NewGauge(prometheus.GaugeOpts{Name: "kubewg_peers", ConstLabels: prometheus.Labels{"interface": "kubewg0"}})
+	peers.Set(3)
+	duration := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "kubewg_reconcile_duration_seconds", Buckets: []float64{0.1}})
+	duration.Observe(0.05)
+	ignored := prometheus.NewCounter(prometheus.CounterOpts{Name: "go_ignored_total"})
+	registry.MustRegister(peers, duration, ignored)
+
+	var got map[string]float64
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		compressed, _ := io.ReadAll(r.Body)
+		body, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			t.Errorf("expected a snappy body, got %v", err)
+			return
+		}
+		got = decode(t, body)
+	}))
+	defer server.Close()
+
+	c := &config.RemoteWrite{
+		URL:     server.URL,
+		Timeout: config.Duration(5 * time.Second),
+		Include: []string{"kubewg_.*"},
+		Labels:  map[string]string{"cluster": "edge"},
+		Token:   "secret",
+	}
+	pusher, err := remotewrite.NewPusher(c, "node-a", registry)
+	if err != nil {
+		t.Fatalf("failed to create pusher: %v", err)
+	}
+	if err := pusher.Push(context.Background()); err != nil {
+		t.Fatalf("failed to push: %v", err)
+	}
+
+	if headers.Get("Content-Encoding") != "snappy" || headers.Get("Authorization") != "Bearer secret" {
+		t.Errorf("expected snappy encoding and the bearer token, got %v", headers)
+	}
+	expected := map[string]float64{
+		`__name__=kubewg_peers,cluster=edge,interface=kubewg0,node=node-a`:                   3,
+		`__name__=kubewg_reconcile_duration_seconds_bucket,cluster=edge,le=0.1,node=node-a`:  1,
+		`__name__=kubewg_reconcile_duration_seconds_bucket,cluster=edge,le=+Inf,node=node-a`: 1,
+		`__name__=kubewg_reconcile_duration_seconds_sum,cluster=edge,node=node-a`:            0.05,
+		`__name__=kubewg_reconcile_duration_seconds_count,cluster=edge,node=node-a`:          1,
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d series, got %v", len(expected), got)
+	}
+	for key, value := range expected {
+		if got[key] != value {
+			t.Errorf("expected %s = %v, got %v", key, value, got[key])
+		}
+	}
+}
+
+func TestPushError(t *testing.T) {
+	t.Parallel()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "kubewg_test_total"}))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	pusher, err := remotewrite.NewPusher(&config.RemoteWrite{URL: server.URL, Timeout: config.Duration(5 * time.Second)}, "", registry)
+	if err != nil {
+		t.Fatalf("failed to create pusher: %v", err)
+	}
+	err = pusher.Push(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "out of order sample") {
+		t.Errorf("expected the server's message in the error, got %v", err)
+	}
+}
+
+// decode reads a write request into sorted name=value label sets mapped to
+// their sample value.
+func decode(t *testing.T, body []byte) map[string]float64 {
+	t.Helper()
+	out := map[string]float64{}
+	for _, ts := range fields(t, body) {
+		var labels []string
+		var value float64
+		for _, field := range messageFields(t, ts) {
+			switch field.number {
+			case 1:
+				pair := messageFields(t, field.bytes)
+				labels = append(labels, string(pair[0].bytes)+"="+string(pair[1].bytes))
+			case 2:
+				sample := messageFields(t, field.bytes)
+				value = math.Float64frombits(sample[0].fixed)
+			}
+		}
+		sort.Strings(labels)
+		out[strings.Join(labels, ",")] = value
+	}
+	return out
+}
+
+type field struct {
+	number protowire.Number
+	bytes  []byte
+	fixed  uint64
+}
+
+// fields returns the embedded messages of a repeated field 1.
+func fields(t *testing.T, body []byte) [][]byte {
+	t.Helper()
+	var out [][]byte
+	for _, f := range messageFields(t, body) {
+		out = append(out, f.bytes)
+	}
+	return out
+}
+
+func messageFields(t *testing.T, body []byte) []field {
+	t.Helper()
+	var out []field
+	for len(body) > 0 {
+		number, typ, n := protowire.ConsumeTag(body)
+		if n < 0 {
+			t.Fatalf("invalid tag: %v", protowire.ParseError(n))
+		}
+		body = body[n:]
+		f := field{number: number}
+		switch typ {
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(body)
+		case protowire.Fixed64Type:
+			f.fixed, n = protowire.ConsumeFixed64(body)
+		case protowire.VarintType:
+			f.fixed, n = protowire.ConsumeVarint(body)
+		default:
+			t.Fatalf("unexpected wire type %v", typ)
+		}
+		if n < 0 {
+			t.Fatalf("invalid field: %v", protowire.ParseError(n))
+		}
+		body = body[n:]
+		out = append(out, f)
+	}
+	return out
+}
@@ -0,0 +1,318 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package resolver
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/miekg/dns"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	resolvConfPath = "/etc/resolv.conf"
+	// sweepInterval is how often answers too old to be served even stale
+	// are dropped, so hosts looked up once don't stay cached for good
+	sweepInterval = time.Minute
+)
+
+var (
+	ErrNotFound      = errors.New("host not found")
+	ErrNoNameservers = errors.New("no nameservers configured")
+)
+
+type entry struct {
+	addrs   []netip.Addr
+	err     error
+	expires time.Time
+	staleBy time.Time
+}
+
+// Resolver is a caching DNS resolver shared by everything that needs to
+// turn peer endpoints into addresses. Answers are cached for their record
+// TTL (clamped to the configured bounds), lookups that fail are cached for
+// the negative TTL, and an expired answer keeps being served for a while
+// if refreshing it fails so a flaky DNS server doesn't flap endpoints.
+type Resolver struct {
+	config       *config.Resolver
+	client       *dns.Client
+	clientConfig *dns.ClientConfig
+	services     ServiceSource
+	group        singleflight.Group
+	mu           sync.RWMutex
+	cache        map[string]*entry
+	swept        time.Time
+}
+
+func NewResolver(config *config.Resolver) (*Resolver, error) {
+	var clientConfig *dns.ClientConfig
+	if config.Server != "" {
+		host, port, err := net.SplitHostPort(config.Server)
+		if err != nil {
+			host, port = config.Server, "53"
+		}
+		clientConfig = &dns.ClientConfig{
+			Servers:  []string{host},
+			Port:     port,
+			Ndots:    1,
+			Attempts: 2,
+		}
+	} else {
+		var err error
+		clientConfig, err = dns.ClientConfigFromFile(resolvConfPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", resolvConfPath, err)
+		}
+	}
+	if len(clientConfig.Servers) == 0 {
+		return nil, ErrNoNameservers
+	}
+
+	return &Resolver{
+		config: config,
+		client: &dns.Client{
+			Timeout: config.Timeout.Std(),
+		},
+		clientConfig: clientConfig,
+		cache:        make(map[string]*entry),
+	}, nil
+}
+
+// ResolveUDPAddr resolves a host:port endpoint. IP literals are returned
+// as-is without touching the cache, and service endpoints are looked up
+// through the ServiceSource.
+func (r *Resolver) ResolveUDPAddr(ctx context.Context, endpoint string) (*net.UDPAddr, error) {
+	service, ok, err := ParseServiceEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	} else if ok {
+		return r.resolveService(ctx, service)
+	}
+
+	host, portStr, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %q: %w", endpoint, err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint port %q: %w", portStr, err)
+	}
+
+	addrs, err := r.LookupAddrs(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	return net.UDPAddrFromAddrPort(netip.AddrPortFrom(addrs[0], uint16(port))), nil
+}
+
+// LookupAddrs returns the IPv4 and IPv6 addresses for host, IPv4 first.
+func (r *Resolver) LookupAddrs(ctx context.Context, host string) ([]netip.Addr, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr.Unmap()}, nil
+	}
+
+	now := time.Now()
+	r.mu.RLock()
+	cached, ok := r.cache[host]
+	r.mu.RUnlock()
+	if ok && now.Before(cached.expires) {
+		return cached.addrs, cached.err
+	}
+
+	// The lookup is shared by every caller asking for host meanwhile, so it
+	// runs on its own timeout rather than being cancelled with the first
+	results := r.group.DoChan(host, func() (interface{}, error) {
+		timeout := cmp.Or(r.config.Timeout.Std(), config.DefaultResolverTimeout)
+		lookupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+		defer cancel()
+		return r.refresh(lookupCtx, host, cached), nil
+	})
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("DNS lookup cancelled: %w", ctx.Err())
+	case result := <-results:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		//nolint:forcetypeassert
+		fresh := result.Val.(*entry)
+		return fresh.addrs, fresh.err
+	}
+}
+
+// Flush drops every cached answer.
+func (r *Resolver) Flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cache = make(map[string]*entry)
+}
+
+func (r *Resolver) refresh(ctx context.Context, host string, cached *entry) *entry {
+	now := time.Now()
+	addrs, ttl, err := r.query(ctx, host)
+
+	var fresh *entry
+	switch {
+	case err == nil:
+		lifetime := time.Duration(ttl) * time.Second
+		lifetime = max(lifetime, r.config.MinTTL.Std())
+		lifetime = min(lifetime, r.config.MaxTTL.Std())
+		expires := now.Add(lifetime)
+		fresh = &entry{
+			addrs:   addrs,
+			expires: expires,
+			staleBy: expires.Add(r.config.StaleTTL.Std()),
+		}
+	case errors.Is(err, ErrNotFound):
+		fresh = &entry{
+			err:     err,
+			expires: now.Add(r.config.NegativeTTL.Std()),
+		}
+	case cached != nil && cached.err == nil && now.Before(cached.staleBy):
+		// Transient failure, keep serving what we had
+		slog.Warn("DNS refresh failed, serving stale answer", "host", host, "error", err.Error())
+		return cached
+	default:
+		// Transient failure with nothing to fall back on, don't cache it
+		return &entry{err: err}
+	}
+
+	r.mu.Lock()
+	r.cache[host] = fresh
+	if now.Sub(r.swept) >= sweepInterval {
+		r.sweep(now)
+	}
+	r.mu.Unlock()
+
+	return fresh
+}
+
+// sweep drops the answers that have expired and can't be served stale
+// either. Callers hold r.mu.
+func (r *Resolver) sweep(now time.Time) {
+	for host, cached := range r.cache {
+		if now.After(cached.expires) && now.After(cached.staleBy) {
+			delete(r.cache, host)
+		}
+	}
+	r.swept = now
+}
+
+func (r *Resolver) query(ctx context.Context, host string) ([]netip.Addr, uint32, error) {
+	var lastErr error
+	for _, name := range r.clientConfig.NameList(host) {
+		addrs, ttl, err := r.queryName(ctx, name)
+		if err == nil {
+			return addrs, ttl, nil
+		}
+		lastErr = err
+		if !errors.Is(err, ErrNotFound) {
+			return nil, 0, err
+		}
+	}
+	return nil, 0, lastErr
+}
+
+// queryName looks up the A and AAAA records of name. A family whose query
+// fails is left out as long as the other one resolved, a server that fails
+// AAAA queries mustn't take down IPv4 endpoints.
+func (r *Resolver) queryName(ctx context.Context, name string) ([]netip.Addr, uint32, error) {
+	var addrs []netip.Addr
+	var ttl uint32
+	var failed error
+	found := false
+
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		msg := new(dns.Msg)
+		msg.SetQuestion(name, qtype)
+
+		resp, err := r.exchange(ctx, msg)
+		if err != nil {
+			failed = err
+			continue
+		}
+
+		switch resp.Rcode {
+		case dns.RcodeSuccess:
+		case dns.RcodeNameError:
+			return nil, 0, fmt.Errorf("%w: %s", ErrNotFound, name)
+		default:
+			failed = fmt.Errorf("DNS server returned %s for %s %s", dns.RcodeToString[resp.Rcode], dns.TypeToString[qtype], name)
+			continue
+		}
+
+		for _, rr := range resp.Answer {
+			var addr netip.Addr
+			switch rec := rr.(type) {
+			case *dns.A:
+				addr, _ = netip.AddrFromSlice(rec.A.To4())
+			case *dns.AAAA:
+				addr, _ = netip.AddrFromSlice(rec.AAAA)
+			default:
+				continue
+			}
+			if !found || rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+			}
+			found = true
+			addrs = append(addrs, addr)
+		}
+	}
+
+	switch {
+	case found && failed != nil:
+		slog.Debug("DNS query failed, using the other address family", "name", name, "error", failed.Error())
+	case failed != nil:
+		return nil, 0, failed
+	case !found:
+		return nil, 0, fmt.Errorf("%w: %s has no A or AAAA records", ErrNotFound, name)
+	}
+
+	return addrs, ttl, nil
+}
+
+func (r *Resolver) exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	var lastErr error
+	attempts := max(r.clientConfig.Attempts, 1)
+	for i := 0; i < attempts; i++ {
+		for _, server := range r.clientConfig.Servers {
+			resp, _, err := r.client.ExchangeContext(ctx, msg, net.JoinHostPort(server, r.clientConfig.Port))
+			if err == nil {
+				return resp, nil
+			}
+			lastErr = err
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("DNS query cancelled: %w", ctx.Err())
+			}
+		}
+	}
+	return nil, fmt.Errorf("DNS query failed: %w", lastErr)
+}
@@ -0,0 +1,293 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package resolver_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/resolver"
+	"github.com/miekg/dns"
+)
+
+const testHost = "peer.example.com"
+
+// fakeDNS answers A and AAAA queries for testHost with the rcode and TTL
+// set per family, and counts the queries it gets.
+type fakeDNS struct {
+	mu      sync.Mutex
+	rcode   map[uint16]int
+	ttl     uint32
+	delay   time.Duration
+	queries int
+}
+
+func (f *fakeDNS) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	f.mu.Lock()
+	delay := f.delay
+	f.mu.Unlock()
+	time.Sleep(delay)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.queries++
+
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	question := req.Question[0]
+	resp.Rcode = f.rcode[question.Qtype]
+	if resp.Rcode == dns.RcodeSuccess {
+		header := dns.RR_Header{Name: question.Name, Class: dns.ClassINET, Ttl: f.ttl, Rrtype: question.Qtype}
+		switch question.Qtype {
+		case dns.TypeA:
+			resp.Answer = append(resp.Answer, &dns.A{Hdr: header, A: net.ParseIP("192.0.2.1")})
+		case dns.TypeAAAA:
+			resp.Answer = append(resp.Answer, &dns.AAAA{Hdr: header, AAAA: net.ParseIP("2001:db8::1")})
+		}
+	}
+	_ = w.WriteMsg(resp)
+}
+
+func (f *fakeDNS) set(a, aaaa int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rcode = map[uint16]int{dns.TypeA: a, dns.TypeAAAA: aaaa}
+}
+
+func (f *fakeDNS) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.queries
+}
+
+// newResolver starts a fake DNS server and returns a resolver using it.
+func newResolver(t *testing.T, ttl uint32, conf config.Resolver) (*resolver.Resolver, *fakeDNS) {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	fake := &fakeDNS{ttl: ttl}
+	fake.set(dns.RcodeSuccess, dns.RcodeSuccess)
+	server := &dns.Server{PacketConn: conn, Handler: fake}
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	t.Cleanup(func() {
+		_ = server.Shutdown()
+	})
+
+	conf.Server = conn.LocalAddr().String()
+	conf.Timeout = config.Duration(time.Second)
+	r, err := resolver.NewResolver(&conf)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return r, fake
+}
+
+var (
+	addrA    = netip.MustParseAddr("192.0.2.1")
+	addrAAAA = netip.MustParseAddr("2001:db8::1")
+)
+
+func TestTTLClamping(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		ttl    uint32
+		minTTL time.Duration
+		maxTTL time.Duration
+		cached bool
+	}{
+		{name: "record ttl", ttl: 3600, maxTTL: time.Hour, cached: true},
+		{name: "raised to min", ttl: 0, minTTL: time.Hour, maxTTL: time.Hour, cached: true},
+		{name: "lowered to max", ttl: 3600, cached: false},
+		{name: "expired record", ttl: 0, maxTTL: time.Hour, cached: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r, fake := newResolver(t, tt.ttl, config.Resolver{
+				MinTTL: config.Duration(tt.minTTL),
+				MaxTTL: config.Duration(tt.maxTTL),
+			})
+			for range 2 {
+				addrs, err := r.LookupAddrs(context.Background(), testHost)
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if !slices.Equal(addrs, []netip.Addr{addrA, addrAAAA}) {
+					t.Errorf("expected %v, got %v", []netip.Addr{addrA, addrAAAA}, addrs)
+				}
+			}
+			// Each lookup sends an A and an AAAA query
+			expected := 4
+			if tt.cached {
+				expected = 2
+			}
+			if fake.count() != expected {
+				t.Errorf("expected %d queries, got %d", expected, fake.count())
+			}
+		})
+	}
+}
+
+func TestNegativeCaching(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		negativeTTL time.Duration
+		queries     int
+	}{
+		{name: "cached", negativeTTL: time.Hour, queries: 1},
+		{name: "expired", negativeTTL: 0, queries: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r, fake := newResolver(t, 60, config.Resolver{NegativeTTL: config.Duration(tt.negativeTTL)})
+			fake.set(dns.RcodeNameError, dns.RcodeNameError)
+			for range 2 {
+				if _, err := r.LookupAddrs(context.Background(), testHost); !errors.Is(err, resolver.ErrNotFound) {
+					t.Fatalf("expected ErrNotFound, got %v", err)
+				}
+			}
+			// NXDOMAIN on A ends the lookup before AAAA
+			if fake.count() != tt.queries {
+				t.Errorf("expected %d queries, got %d", tt.queries, fake.count())
+			}
+		})
+	}
+}
+
+func TestStaleOnError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		staleTTL time.Duration
+		stale    bool
+	}{
+		{name: "within stale ttl", staleTTL: time.Hour, stale: true},
+		{name: "past stale ttl", staleTTL: 0, stale: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// The answer expires right away, leaving only the stale TTL
+			r, fake := newResolver(t, 0, config.Resolver{StaleTTL: config.Duration(tt.staleTTL)})
+			if _, err := r.LookupAddrs(context.Background(), testHost); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			fake.set(dns.RcodeServerFailure, dns.RcodeServerFailure)
+			addrs, err := r.LookupAddrs(context.Background(), testHost)
+			if tt.stale {
+				if err != nil {
+					t.Fatalf("expected the stale answer, got %v", err)
+				}
+				if !slices.Equal(addrs, []netip.Addr{addrA, addrAAAA}) {
+					t.Errorf("expected %v, got %v", []netip.Addr{addrA, addrAAAA}, addrs)
+				}
+			} else if err == nil || errors.Is(err, resolver.ErrNotFound) {
+				t.Errorf("expected the server failure, got %v, %v", addrs, err)
+			}
+		})
+	}
+}
+
+func TestFailedFamily(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		a        int
+		aaaa     int
+		expected []netip.Addr
+	}{
+		{name: "aaaa fails", a: dns.RcodeSuccess, aaaa: dns.RcodeServerFailure, expected: []netip.Addr{addrA}},
+		{name: "a fails", a: dns.RcodeServerFailure, aaaa: dns.RcodeSuccess, expected: []netip.Addr{addrAAAA}},
+		{name: "both fail", a: dns.RcodeServerFailure, aaaa: dns.RcodeServerFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r, fake := newResolver(t, 60, config.Resolver{MaxTTL: config.Duration(time.Hour)})
+			fake.set(tt.a, tt.aaaa)
+			addrs, err := r.LookupAddrs(context.Background(), testHost)
+			if tt.expected == nil {
+				if err == nil || errors.Is(err, resolver.ErrNotFound) {
+					t.Errorf("expected the server failure, got %v, %v", addrs, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !slices.Equal(addrs, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, addrs)
+			}
+		})
+	}
+}
+
+func TestSharedLookupOutlivesCaller(t *testing.T) {
+	t.Parallel()
+
+	r, fake := newResolver(t, 60, config.Resolver{MaxTTL: config.Duration(time.Hour)})
+	fake.mu.Lock()
+	fake.delay = 100 * time.Millisecond
+	fake.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	impatient := make(chan error, 1)
+	go func() {
+		_, err := r.LookupAddrs(ctx, testHost)
+		impatient <- err
+	}()
+	// Joins the lookup the impatient caller started
+	time.Sleep(5 * time.Millisecond)
+	addrs, err := r.LookupAddrs(context.Background(), testHost)
+	if err != nil {
+		t.Fatalf("expected the shared lookup to outlive the first caller, got %v", err)
+	}
+	if !slices.Equal(addrs, []netip.Addr{addrA, addrAAAA}) {
+		t.Errorf("expected %v, got %v", []netip.Addr{addrA, addrAAAA}, addrs)
+	}
+	if err := <-impatient; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the first caller to give up, got %v", err)
+	}
+}
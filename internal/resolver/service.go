@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ServiceEndpointPrefix marks a peer endpoint naming a Kubernetes Service,
+// service:namespace/name:port, which is resolved through the API instead
+// of DNS
+const ServiceEndpointPrefix = "service:"
+
+var (
+	ErrInvalidServiceEndpoint = errors.New("service endpoints must be service:namespace/name:port")
+	ErrNoServiceSource        = errors.New("service endpoints require a Kubernetes client")
+)
+
+// ServiceEndpoint is a parsed service:namespace/name:port endpoint. Port is
+// the number or the name of one of the Service's ports.
+type ServiceEndpoint struct {
+	Namespace string
+	Name      string
+	Port      string
+}
+
+func (s ServiceEndpoint) String() string {
+	return ServiceEndpointPrefix + s.Namespace + "/" + s.Name + ":" + s.Port
+}
+
+// ServiceSource looks up where a Service is reached.
+type ServiceSource interface {
+	ResolveService(ctx context.Context, service ServiceEndpoint) (*net.UDPAddr, error)
+}
+
+// ParseServiceEndpoint reports whether endpoint names a Service and parses
+// it. A host that happens to be called service, as in service:51820, is
+// left to DNS.
+func ParseServiceEndpoint(endpoint string) (ServiceEndpoint, bool, error) {
+	ref, ok := strings.CutPrefix(endpoint, ServiceEndpointPrefix)
+	if !ok || !strings.Contains(ref, "/") {
+		return ServiceEndpoint{}, false, nil
+	}
+
+	namespacedName, port, ok := strings.Cut(ref, ":")
+	namespace, name, _ := strings.Cut(namespacedName, "/")
+	if !ok || namespace == "" || name == "" || port == "" {
+		return ServiceEndpoint{}, true, fmt.Errorf("%w: %q", ErrInvalidServiceEndpoint, endpoint)
+	}
+	return ServiceEndpoint{Namespace: namespace, Name: name, Port: port}, true, nil
+}
+
+// UseServices resolves service endpoints through source. It must be called
+// before the resolver is used.
+func (r *Resolver) UseServices(source ServiceSource) {
+	r.services = source
+}
+
+func (r *Resolver) resolveService(ctx context.Context, service ServiceEndpoint) (*net.UDPAddr, error) {
+	if r.services == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNoServiceSource, service.String())
+	}
+	return r.services.ResolveService(ctx, service)
+}
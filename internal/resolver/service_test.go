@@ -0,0 +1,52 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package resolver_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/resolver"
+)
+
+func TestParseServiceEndpoint(t *testing.T) {
+	t.Parallel()
+
+	endpoint, ok, err := resolver.ParseServiceEndpoint("service:vpn/gateway:wireguard")
+	if err != nil || !ok {
+		t.Fatalf("expected a service endpoint, got %v, %v", ok, err)
+	}
+	want := resolver.ServiceEndpoint{Namespace: "vpn", Name: "gateway", Port: "wireguard"}
+	if endpoint != want {
+		t.Errorf("expected %+v, got %+v", want, endpoint)
+	}
+
+	for _, plain := range []string{"peer.example.com:51820", "service:51820", ""} {
+		if _, ok, err := resolver.ParseServiceEndpoint(plain); ok || err != nil {
+			t.Errorf("expected %q to be left to DNS, got %v, %v", plain, ok, err)
+		}
+	}
+
+	for _, invalid := range []string{"service:vpn/gateway", "service:/gateway:51820", "service:vpn/:51820"} {
+		if _, ok, err := resolver.ParseServiceEndpoint(invalid); !ok || !errors.Is(err, resolver.ErrInvalidServiceEndpoint) {
+			t.Errorf("expected ErrInvalidServiceEndpoint for %q, got %v, %v", invalid, ok, err)
+		}
+	}
+}
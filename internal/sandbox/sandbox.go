@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package sandbox restricts the running process once it is set up, beyond
+// what the container runtime does: a seccomp filter to the system calls
+// kubewg makes and a Landlock ruleset to the paths it writes and executes.
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+var (
+	ErrCgo         = errors.New("sandboxing needs a binary built with CGO_ENABLED=0")
+	ErrUnsupported = errors.New("sandboxing is only supported on Linux")
+)
+
+// Paths are what the Landlock ruleset allows beyond reading.
+type Paths struct {
+	// Writable paths may be written to, files and directories below
+	// them created and removed
+	Writable []string
+	// Executable paths hold the binaries that may be run
+	Executable []string
+}
+
+// PathsFor returns the paths the features of c write to and execute from.
+func PathsFor(c *config.Config) Paths {
+	paths := Paths{Writable: []string{os.TempDir()}}
+	write := func(path string) {
+		if path != "" {
+			paths.Writable = append(paths.Writable, path)
+		}
+	}
+
+	if c.State.Type == config.StateFile {
+		write(filepath.Dir(c.State.Path))
+	}
+	if c.KeyStore.Type == "" || c.KeyStore.Type == config.KeyStoreFile {
+		write(keyDir(&c.WireGuard))
+		for i := range c.Interfaces {
+			write(keyDir(&c.Interfaces[i]))
+		}
+	}
+	if c.Audit.Enabled && c.Audit.Path != "" && c.Audit.Path != "-" {
+		write(filepath.Dir(c.Audit.Path))
+	}
+	if c.PProf.Capture.Enabled {
+		write(c.PProf.Capture.Directory)
+	}
+	for _, path := range c.Sandbox.WritablePaths {
+		write(path)
+	}
+
+	// Running the interfaces in this process takes the TUN device of the
+	// userspace fallback, the forwarding sysctls and the firewall tools
+	// along with their lock files
+	if c.WireGuard.Enabled && !c.PrivilegeSeparation.Enabled {
+		write("/dev")
+		write("/proc/sys/net")
+		write("/run")
+		paths.Executable = append(paths.Executable, "/bin", "/sbin", "/usr", "/lib", "/lib64")
+	}
+	slices.Sort(paths.Writable)
+	paths.Writable = slices.Compact(paths.Writable)
+	return paths
+}
+
+func keyDir(wg *config.WireGuard) string {
+	if wg.PrivateKey != "" || wg.PrivateKeyFile == "" {
+		return ""
+	}
+	return filepath.Dir(wg.PrivateKeyFile)
+}
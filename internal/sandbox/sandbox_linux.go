@@ -0,0 +1,61 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package sandbox
+
+import (
+	"fmt"
+	"log/slog"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Apply sets no_new_privs and restricts every thread of the process. The
+// Landlock ruleset is skipped with a warning on kernels without Landlock,
+// the seccomp filter is not.
+func Apply(paths Paths) error {
+	if err := allThreads(unix.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %w", err)
+	}
+
+	abi, err := landlockABI()
+	if err != nil {
+		slog.Warn("Landlock is not available, only applying the seccomp filter", "error", err.Error())
+	} else if err := restrictPaths(paths); err != nil {
+		return err
+	}
+
+	if err := filterSyscalls(); err != nil {
+		return err
+	}
+	slog.Info("Sandboxed the process", "landlock_abi", abi, "writable", paths.Writable, "executable", paths.Executable)
+	return nil
+}
+
+func allThreads(trap, a1, a2, a3 uintptr) error {
+	_, _, errno := syscall.AllThreadsSyscall(trap, a1, a2, a3)
+	if errno == syscall.ENOTSUP {
+		return ErrCgo
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package sandbox_test
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/sandbox"
+	"golang.org/x/sys/unix"
+)
+
+// childEnv makes the test binary run the sandboxed half of TestApply,
+// the sandbox can't be lifted from the process running the tests
+const childEnv = "KUBEWG_SANDBOX_DIR"
+
+func TestApply(t *testing.T) {
+	if dir := os.Getenv(childEnv); dir != "" {
+		sandboxed(dir)
+		return
+	}
+	t.Parallel()
+
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-test.run=^TestApply$")
+	cmd.Env = append(os.Environ(), childEnv+"="+dir)
+	out, err := cmd.CombinedOutput()
+	if strings.Contains(string(out), sandbox.ErrCgo.Error()) {
+		t.Skip("the test binary is built with cgo")
+	}
+	if err != nil {
+		t.Fatalf("expected the sandboxed process to succeed, got %v: %s", err, out)
+	}
+}
+
+// sandboxed applies the sandbox and exits non-zero with a message on the
+// first thing that doesn't behave.
+func sandboxed(dir string) {
+	fail := func(format string, args ...any) {
+		fmt.Fprintf(os.Stderr, format+"\n", args...)
+		os.Exit(1)
+	}
+	allowed := filepath.Join(dir, "allowed")
+	if err := os.Mkdir(allowed, 0o700); err != nil {
+		fail("unexpected error: %v", err)
+	}
+	if err := sandbox.Apply(sandbox.Paths{Writable: []string{allowed}}); err != nil {
+		if errors.Is(err, sandbox.ErrCgo) {
+			os.Stderr.WriteString(err.Error() + "\n")
+			os.Exit(0)
+		}
+		fail("expected no error, got %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(allowed, "file"), []byte("ok"), 0o600); err != nil {
+		fail("expected writing below a writable path to work, got %v", err)
+	}
+	if _, err := os.ReadFile("/proc/self/status"); err != nil {
+		fail("expected reading anywhere to work, got %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "denied"), []byte("no"), 0o600); err == nil {
+		fail("expected writing elsewhere to be denied")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fail("expected listening to work, got %v", err)
+	}
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		fail("expected connecting to work, got %v", err)
+	}
+	conn.Close()
+
+	// acct(2) is nothing kubewg needs
+	if _, _, errno := syscall.Syscall(unix.SYS_ACCT, 0, 0, 0); errno != syscall.EPERM {
+		fail("expected acct to be filtered, got %v", errno)
+	}
+}
@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package sandbox_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/sandbox"
+)
+
+func TestPathsFor(t *testing.T) {
+	t.Parallel()
+
+	c := &config.Config{
+		WireGuard: config.WireGuard{Enabled: true, PrivateKeyFile: "/var/lib/kubewg/private.key"},
+		State:     config.State{Type: config.StateFile, Path: "/var/lib/kubewg/state.db"},
+		Audit:     config.Audit{Enabled: true, Path: "/var/log/kubewg/audit.log"},
+		Sandbox:   config.Sandbox{WritablePaths: []string{"/srv/extra"}},
+	}
+	paths := sandbox.PathsFor(c)
+	for _, path := range []string{"/var/lib/kubewg", "/var/log/kubewg", "/srv/extra", "/proc/sys/net"} {
+		if !slices.Contains(paths.Writable, path) {
+			t.Errorf("expected %s to be writable, got %v", path, paths.Writable)
+		}
+	}
+	if !slices.Contains(paths.Executable, "/usr") {
+		t.Errorf("expected the firewall tools to be executable, got %v", paths.Executable)
+	}
+
+	// The helper runs the interfaces and the firewall tools instead
+	c.PrivilegeSeparation.Enabled = true
+	paths = sandbox.PathsFor(c)
+	if len(paths.Executable) != 0 || slices.Contains(paths.Writable, "/proc/sys/net") {
+		t.Errorf("expected nothing for the interfaces with privilege separation, got %+v", paths)
+	}
+}
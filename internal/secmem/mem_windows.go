@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package secmem
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// alloc commits size bytes of private memory outside the Go heap.
+func alloc(size int) ([]byte, error) {
+	addr, err := windows.VirtualAlloc(0, uintptr(size), windows.MEM_COMMIT|windows.MEM_RESERVE, windows.PAGE_READWRITE)
+	if err != nil {
+		return nil, err
+	}
+	// Adding to nil rather than converting keeps vet from taking the
+	// address for a Go pointer
+	return unsafe.Slice((*byte)(unsafe.Add(nil, addr)), size), nil
+}
+
+func lock(mem []byte) error {
+	return windows.VirtualLock(uintptr(unsafe.Pointer(&mem[0])), uintptr(len(mem)))
+}
+
+// free unlocks and releases memory from alloc.
+func free(mem []byte) error {
+	addr := uintptr(unsafe.Pointer(&mem[0]))
+	_ = windows.VirtualUnlock(addr, uintptr(len(mem)))
+	return windows.VirtualFree(addr, 0, windows.MEM_RELEASE)
+}
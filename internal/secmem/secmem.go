@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package secmem keeps private keys outside the Go heap, in memory that is
+// locked against swapping, left out of core dumps and zeroed before it is
+// freed. Heap dumps and swap therefore never hold a key, and since keys are
+// only handed out by pointer they don't show up in goroutine dumps either.
+// Keeping keys out of core dumps is up to the system outside Linux.
+package secmem
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/kubewg-net/container/internal/config"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+var ErrDestroyed = errors.New("key was destroyed")
+
+// lockWarning logs a missing mlock allowance once rather than per key
+var lockWarning sync.Once
+
+// Key is a WireGuard private key in locked memory. It prints and logs as
+// config.Redacted.
+type Key struct {
+	mu     sync.Mutex
+	mem    []byte
+	public wgtypes.Key
+}
+
+// NewKey moves key into locked memory and zeroes the caller's copy. Failing
+// to lock the memory, e.g. with a low RLIMIT_MEMLOCK, is only logged.
+func NewKey(key *wgtypes.Key) (*Key, error) {
+	mem, err := alloc(os.Getpagesize())
+	if err != nil {
+		return nil, fmt.Errorf("failed to map key memory: %w", err)
+	}
+	if err := lock(mem); err != nil {
+		lockWarning.Do(func() {
+			slog.Warn("Failed to lock private keys into memory, they may be swapped out", "error", err.Error())
+		})
+	}
+	exclude(mem)
+
+	k := &Key{mem: mem, public: key.PublicKey()}
+	copy(mem, key[:])
+	Wipe(key)
+	runtime.SetFinalizer(k, (*Key).Destroy)
+	return k, nil
+}
+
+// Use calls fn with the key in place. fn must neither keep the pointer nor
+// copy the key anywhere that outlives the call.
+func (k *Key) Use(fn func(key *wgtypes.Key) error) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.mem == nil {
+		return ErrDestroyed
+	}
+	return fn((*wgtypes.Key)(k.mem[:wgtypes.KeyLen]))
+}
+
+// Equal reports in constant time whether other is the key.
+func (k *Key) Equal(other *wgtypes.Key) bool {
+	if k == nil {
+		return false
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.mem != nil && subtle.ConstantTimeCompare(k.mem[:wgtypes.KeyLen], other[:]) == 1
+}
+
+// PublicKey returns the public key, computed when the key was stored. A
+// nil Key has the zero public key.
+func (k *Key) PublicKey() wgtypes.Key {
+	if k == nil {
+		return wgtypes.Key{}
+	}
+	return k.public
+}
+
+// Destroy zeroes and frees the key. It is safe to call more than once, and
+// runs as a finalizer should the key be dropped without it.
+func (k *Key) Destroy() {
+	if k == nil {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.mem == nil {
+		return
+	}
+	clear(k.mem)
+	if err := free(k.mem); err != nil {
+		slog.Warn("Failed to unmap key memory", "error", err.Error())
+	}
+	k.mem = nil
+}
+
+func (k *Key) String() string {
+	return config.Redacted
+}
+
+func (k *Key) GoString() string {
+	return config.Redacted
+}
+
+func (k *Key) LogValue() slog.Value {
+	return slog.StringValue(config.Redacted)
+}
+
+// Wipe zeroes a transient copy of a key.
+func Wipe(key *wgtypes.Key) {
+	clear(key[:])
+}
+
+// WipeDevice zeroes the private key and the peers' preshared keys of a
+// device read from the kernel, for callers that only need its state.
+func WipeDevice(device *wgtypes.Device) {
+	Wipe(&device.PrivateKey)
+	for i := range device.Peers {
+		Wipe(&device.Peers[i].PresharedKey)
+	}
+}
@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package secmem_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/secmem"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestKey(t *testing.T) {
+	t.Parallel()
+
+	original, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := original
+	locked, err := secmem.NewKey(&key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != (wgtypes.Key{}) {
+		t.Error("expected the copy passed in to be wiped")
+	}
+	if locked.PublicKey() != original.PublicKey() {
+		t.Errorf("expected public key %s, got %s", original.PublicKey(), locked.PublicKey())
+	}
+	if !locked.Equal(&original) {
+		t.Error("expected the locked key to equal the original")
+	}
+	err = locked.Use(func(key *wgtypes.Key) error {
+		if *key != original {
+			return errors.New("key differs")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("expected Use to hand out the key, got %v", err)
+	}
+	if printed := fmt.Sprintf("%v %+v %#v %s", locked, locked, locked, locked); printed != "REDACTED REDACTED REDACTED REDACTED" {
+		t.Errorf("expected the key to print as %s, got %q", config.Redacted, printed)
+	}
+
+	locked.Destroy()
+	locked.Destroy()
+	if locked.Equal(&original) {
+		t.Error("expected a destroyed key to equal nothing")
+	}
+	if err := locked.Use(func(*wgtypes.Key) error { return nil }); !errors.Is(err, secmem.ErrDestroyed) {
+		t.Errorf("expected ErrDestroyed, got %v", err)
+	}
+}
+
+func TestWipeDevice(t *testing.T) {
+	t.Parallel()
+
+	device := &wgtypes.Device{
+		PrivateKey: wgtypes.Key{1},
+		Peers:      []wgtypes.Peer{{PublicKey: wgtypes.Key{2}, PresharedKey: wgtypes.Key{3}}},
+	}
+	secmem.WipeDevice(device)
+	if device.PrivateKey != (wgtypes.Key{}) || device.Peers[0].PresharedKey != (wgtypes.Key{}) {
+		t.Errorf("expected the keys to be wiped, got %+v", device)
+	}
+	if device.Peers[0].PublicKey != (wgtypes.Key{2}) {
+		t.Errorf("expected the public key to be kept, got %s", device.Peers[0].PublicKey)
+	}
+}
@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package sops
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/kubewg-net/container/internal/age"
+)
+
+var ErrVaultResponse = errors.New("unexpected response from vault")
+
+// dataKey decrypts the data key with the first master key that works,
+// age recipients first as they need no network.
+func (m *metadata) dataKey(ctx context.Context, keys Keys) ([]byte, error) {
+	var errs []error
+	if len(keys.Age) > 0 {
+		for _, stanza := range m.Age {
+			key, err := age.Decrypt([]byte(stanza.Enc), keys.Age)
+			if err == nil {
+				return key, nil
+			}
+			errs = append(errs, fmt.Errorf("age %s: %w", stanza.Recipient, err))
+		}
+	}
+	if keys.VaultToken != "" {
+		for _, stanza := range m.HCVault {
+			key, err := vaultDecrypt(ctx, keys, stanza.VaultAddress, stanza.EnginePath, stanza.KeyName, stanza.Enc)
+			if err == nil {
+				return key, nil
+			}
+			errs = append(errs, fmt.Errorf("vault %s: %w", stanza.VaultAddress, err))
+		}
+	}
+	return nil, errors.Join(append([]error{ErrNoDataKey}, errs...)...)
+}
+
+// vaultDecrypt decrypts the data key with a Transit key.
+func vaultDecrypt(ctx context.Context, keys Keys, address, enginePath, keyName, ciphertext string) ([]byte, error) {
+	body, err := json.Marshal(map[string]string{"ciphertext": ciphertext})
+	if err != nil {
+		return nil, err
+	}
+	url := strings.TrimSuffix(address, "/") + "/v1/" + strings.Trim(enginePath, "/") + "/decrypt/" + keyName
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", keys.VaultToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := keys.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s", ErrVaultResponse, resp.Status)
+	}
+	var decrypted struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&decrypted); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrVaultResponse, err)
+	}
+	return base64.StdEncoding.DecodeString(decrypted.Data.Plaintext)
+}
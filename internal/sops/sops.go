@@ -0,0 +1,304 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package sops decrypts YAML and JSON documents encrypted by sops
+// (https://getsops.io). Their values are encrypted with AES-256-GCM under a
+// data key, which the sops metadata holds encrypted to master keys, of
+// which age recipients and Vault Transit keys are supported.
+package sops
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/kubewg-net/container/internal/age"
+	"gopkg.in/yaml.v3"
+)
+
+// metadataKey is where sops keeps its metadata in a document
+const metadataKey = "sops"
+
+var (
+	ErrNoDataKey   = errors.New("none of the sops master keys could be decrypted, only age and Vault Transit keys are supported")
+	ErrKeyGroups   = errors.New("sops key groups are not supported")
+	ErrValue       = errors.New("malformed sops encrypted value")
+	ErrMAC         = errors.New("sops MAC mismatch, the document was modified")
+	ErrNotDocument = errors.New("not a sops document")
+)
+
+// macOnlyEncryptedInitialization starts the MAC of documents with
+// mac_only_encrypted set, so it never matches the MAC over all values
+//
+//nolint:golint,gochecknoglobals
+var macOnlyEncryptedInitialization = []byte{
+	0x8a, 0x3f, 0xd2, 0xad, 0x54, 0xce, 0x66, 0x52, 0x7b, 0x10, 0x34, 0xf3, 0xd1, 0x47, 0xbe, 0x0b,
+	0x0b, 0x97, 0x5b, 0x3b, 0xf4, 0x4f, 0x72, 0xc6, 0xfd, 0xad, 0xec, 0x81, 0x76, 0xf2, 0x7d, 0x69,
+}
+
+//nolint:golint,gochecknoglobals
+var encryptedValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.*),tag:(.*),type:(.*)\]$`)
+
+// Keys decrypt the data key of a document.
+type Keys struct {
+	Age []age.Identity
+	// VaultToken authenticates to the Vault of hc_vault master keys
+	VaultToken string
+	// Client talks to Vault, http.DefaultClient if nil
+	Client *http.Client
+}
+
+type metadata struct {
+	Age []struct {
+		Recipient string `yaml:"recipient"`
+		Enc       string `yaml:"enc"`
+	} `yaml:"age"`
+	HCVault []struct {
+		VaultAddress string `yaml:"vault_address"`
+		EnginePath   string `yaml:"engine_path"`
+		KeyName      string `yaml:"key_name"`
+		Enc          string `yaml:"enc"`
+	} `yaml:"hc_vault"`
+	KeyGroups        []yaml.Node `yaml:"key_groups"`
+	LastModified     string      `yaml:"lastmodified"`
+	MAC              string      `yaml:"mac"`
+	MACOnlyEncrypted bool        `yaml:"mac_only_encrypted"`
+}
+
+// Encrypted reports whether data is a sops document.
+func Encrypted(data []byte) bool {
+	if !bytes.Contains(data, []byte(metadataKey)) {
+		return false
+	}
+	_, node, err := parse(data)
+	return err == nil && node != nil
+}
+
+// Decrypt returns the document with its values decrypted and the sops
+// metadata removed, as YAML. The MAC over all values is verified.
+func Decrypt(ctx context.Context, data []byte, keys Keys) ([]byte, error) {
+	doc, metaNode, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if metaNode == nil {
+		return nil, ErrNotDocument
+	}
+	var meta metadata
+	if err := metaNode.Decode(&meta); err != nil {
+		return nil, fmt.Errorf("invalid sops metadata: %w", err)
+	}
+	if len(meta.KeyGroups) > 0 {
+		return nil, ErrKeyGroups
+	}
+
+	dataKey, err := meta.dataKey(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(dataKey)
+
+	root := doc.Content[0]
+	root.Content = removeKey(root.Content, metadataKey)
+	d := decrypter{key: dataKey, hash: sha512.New(), macOnlyEncrypted: meta.MACOnlyEncrypted}
+	if meta.MACOnlyEncrypted {
+		d.hash.Write(macOnlyEncryptedInitialization)
+	}
+	if err := d.walk(doc, nil); err != nil {
+		return nil, err
+	}
+
+	mac, _, err := decryptValue(dataKey, meta.MAC, meta.LastModified)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the sops MAC: %w", err)
+	}
+	if mac != fmt.Sprintf("%X", d.hash.Sum(nil)) {
+		return nil, ErrMAC
+	}
+	return yaml.Marshal(doc)
+}
+
+// parse returns the document and its sops metadata, nil if it has none.
+func parse(data []byte) (*yaml.Node, *yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return &doc, nil, nil
+	}
+	root := doc.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == metadataKey && root.Content[i+1].Kind == yaml.MappingNode {
+			return &doc, root.Content[i+1], nil
+		}
+	}
+	return &doc, nil, nil
+}
+
+func removeKey(content []*yaml.Node, key string) []*yaml.Node {
+	for i := 0; i+1 < len(content); i += 2 {
+		if content[i].Value == key {
+			return append(content[:i:i], content[i+2:]...)
+		}
+	}
+	return content
+}
+
+// decrypter decrypts the values of a document in the order sops walks it,
+// hashing their plaintext for the MAC.
+type decrypter struct {
+	key              []byte
+	hash             hash.Hash
+	macOnlyEncrypted bool
+}
+
+// walk decrypts node in place. path holds the keys leading to it, which
+// every value is authenticated with. Comments are dropped: sops leaves them
+// out of the MAC, so they could have been changed without it noticing.
+func (d *decrypter) walk(node *yaml.Node, path []string) error {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			if err := d.walk(child, path); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			key.HeadComment, key.LineComment, key.FootComment = "", "", ""
+			if err := d.walk(value, append(path[:len(path):len(path)], key.Value)); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if err := d.leaf(node, path); err != nil {
+			return err
+		}
+	case yaml.AliasNode:
+	}
+	node.HeadComment, node.LineComment, node.FootComment = "", "", ""
+	return nil
+}
+
+func (d *decrypter) leaf(node *yaml.Node, path []string) error {
+	if node.Tag == "!!null" {
+		return nil
+	}
+	if !encryptedValue.MatchString(node.Value) {
+		if !d.macOnlyEncrypted {
+			d.hash.Write(plainBytes(node))
+		}
+		return nil
+	}
+
+	plaintext, kind, err := decryptValue(d.key, node.Value, aad(path))
+	if err != nil {
+		return fmt.Errorf("%s: %w", strings.Join(path, "."), err)
+	}
+	d.hash.Write([]byte(plaintext))
+	node.Style = 0
+	node.Value = plaintext
+	switch kind {
+	case "int":
+		node.Tag = "!!int"
+	case "float":
+		node.Tag = "!!float"
+	case "bool":
+		node.Tag = "!!bool"
+		node.Value = strings.ToLower(plaintext)
+	default:
+		node.Tag = "!!str"
+	}
+	return nil
+}
+
+// plainBytes formats an unencrypted value the way sops does for the MAC.
+func plainBytes(node *yaml.Node) []byte {
+	switch node.Tag {
+	case "!!bool":
+		if value, err := strconv.ParseBool(node.Value); err == nil {
+			if value {
+				return []byte("True")
+			}
+			return []byte("False")
+		}
+	case "!!int":
+		if value, err := strconv.ParseInt(node.Value, 0, 64); err == nil {
+			return []byte(strconv.FormatInt(value, 10))
+		}
+	case "!!float":
+		if value, err := strconv.ParseFloat(node.Value, 64); err == nil {
+			return []byte(strconv.FormatFloat(value, 'f', -1, 64))
+		}
+	}
+	return []byte(node.Value)
+}
+
+// aad is the additional data a value is authenticated with: the keys
+// leading to it, each followed by a colon, or a lone colon at the top.
+func aad(path []string) string {
+	return strings.Join(path, ":") + ":"
+}
+
+// decryptValue decrypts an ENC[AES256_GCM,...] value, returning its
+// plaintext and type.
+func decryptValue(key []byte, value, additionalData string) (string, string, error) {
+	match := encryptedValue.FindStringSubmatch(value)
+	if match == nil {
+		return "", "", ErrValue
+	}
+	var parts [3][]byte
+	for i := range parts {
+		decoded, err := base64.StdEncoding.DecodeString(match[i+1])
+		if err != nil {
+			return "", "", fmt.Errorf("%w: %w", ErrValue, err)
+		}
+		parts[i] = decoded
+	}
+	data, iv, tag := parts[0], parts[1], parts[2]
+	if len(iv) == 0 {
+		return "", "", ErrValue
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return "", "", err
+	}
+	plaintext, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %w", ErrValue, err)
+	}
+	return string(plaintext), match[4], nil
+}
@@ -0,0 +1,231 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package sops_test
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/age"
+	"github.com/kubewg-net/container/internal/sops"
+	"gopkg.in/yaml.v3"
+)
+
+const lastModified = "2024-07-01T12:00:00Z"
+
+func TestDecrypt(t *testing.T) {
+	t.Parallel()
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		t.Fatal(err)
+	}
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if r.URL.Path != "/v1/transit/decrypt/kubewg" || r.Header.Get("X-Vault-Token") != "token" || body["ciphertext"] != "vault:v1:wrapped" {
+			http.Error(w, "denied", http.StatusForbidden)
+			return
+		}
+		fmt.Fprintf(w, `{"data":{"plaintext":%q}}`, base64.StdEncoding.EncodeToString(dataKey))
+	}))
+	t.Cleanup(vault.Close)
+
+	document := encryptDocument(t, dataKey, vault.URL)
+	if !sops.Encrypted(document) {
+		t.Fatal("expected the document to be recognized as sops encrypted")
+	}
+	if sops.Encrypted([]byte("wireguard:\n  enabled: true\n")) {
+		t.Error("expected a plain config not to be recognized as sops encrypted")
+	}
+
+	decrypted, err := sops.Decrypt(context.Background(), document, sops.Keys{VaultToken: "token"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var got struct {
+		WireGuard struct {
+			PrivateKey string `yaml:"private_key"`
+			ListenPort int    `yaml:"listen_port"`
+			Enabled    bool   `yaml:"enabled"`
+			MTU        int    `yaml:"mtu_unencrypted"`
+		} `yaml:"wireguard"`
+		Tokens []string       `yaml:"tokens"`
+		SOPS   map[string]any `yaml:"sops"`
+	}
+	if err := yaml.Unmarshal(decrypted, &got); err != nil {
+		t.Fatalf("failed to unmarshal the decrypted document: %v\n%s", err, decrypted)
+	}
+	if got.WireGuard.PrivateKey != "secret" || got.WireGuard.ListenPort != 51820 || !got.WireGuard.Enabled || got.WireGuard.MTU != 1420 {
+		t.Errorf("expected the decrypted values, got %+v", got.WireGuard)
+	}
+	if len(got.Tokens) != 2 || got.Tokens[0] != "a" || got.Tokens[1] != "b" {
+		t.Errorf("expected the decrypted list, got %v", got.Tokens)
+	}
+	if got.SOPS != nil || strings.Contains(string(decrypted), "ENC[") {
+		t.Errorf("expected no sops metadata or encrypted values left, got\n%s", decrypted)
+	}
+
+	if _, err := sops.Decrypt(context.Background(), document, sops.Keys{VaultToken: "wrong"}); !errors.Is(err, sops.ErrNoDataKey) {
+		t.Errorf("expected %v, got %v", sops.ErrNoDataKey, err)
+	}
+	// Changing a value sops left unencrypted breaks the MAC
+	tampered := strings.Replace(string(document), "mtu_unencrypted: 1420", "mtu_unencrypted: 1280", 1)
+	if _, err := sops.Decrypt(context.Background(), []byte(tampered), sops.Keys{VaultToken: "token"}); !errors.Is(err, sops.ErrMAC) {
+		t.Errorf("expected %v, got %v", sops.ErrMAC, err)
+	}
+	// Moving an encrypted value to another key breaks its authentication
+	moved := strings.Replace(string(document), "private_key:", "public_key:", 1)
+	if _, err := sops.Decrypt(context.Background(), []byte(moved), sops.Keys{VaultToken: "token"}); !errors.Is(err, sops.ErrValue) {
+		t.Errorf("expected %v, got %v", sops.ErrValue, err)
+	}
+}
+
+// TestDecryptSOPSFiles decrypts files the sops 3.9.4 CLI encrypted to the
+// age identity in testdata/key.txt, fully, with --encrypted-regex and with
+// --mac-only-encrypted on top.
+func TestDecryptSOPSFiles(t *testing.T) {
+	t.Parallel()
+
+	key, err := os.ReadFile(filepath.Join("testdata", "key.txt"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	identities, err := age.ParseIdentities(key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		// tamperable is set when listen_port is in the clear but covered
+		// by the MAC
+		tamperable bool
+	}{
+		{name: "config.sops.yaml"},
+		{name: "config.sops.json"},
+		{name: "plain-mac.sops.yaml", tamperable: true},
+		{name: "partial.sops.yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			document, err := os.ReadFile(filepath.Join("testdata", tt.name))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !sops.Encrypted(document) {
+				t.Fatal("expected the document to be recognized as sops encrypted")
+			}
+			decrypted, err := sops.Decrypt(context.Background(), document, sops.Keys{Age: identities})
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			var got struct {
+				WireGuard struct {
+					Enabled    bool   `yaml:"enabled"`
+					ListenPort int    `yaml:"listen_port"`
+					PrivateKey string `yaml:"private_key"`
+				} `yaml:"wireguard"`
+			}
+			if err := yaml.Unmarshal(decrypted, &got); err != nil {
+				t.Fatalf("failed to unmarshal the decrypted document: %v\n%s", err, decrypted)
+			}
+			if !got.WireGuard.Enabled || got.WireGuard.ListenPort != 51820 || got.WireGuard.PrivateKey != "secret" {
+				t.Errorf("expected the decrypted values, got %+v", got.WireGuard)
+			}
+			if strings.Contains(string(decrypted), "ENC[") || strings.Contains(string(decrypted), "sops:") {
+				t.Errorf("expected no sops metadata or encrypted values left, got\n%s", decrypted)
+			}
+
+			if !tt.tamperable {
+				return
+			}
+			tampered := strings.Replace(string(document), "listen_port: 51820", "listen_port: 51821", 1)
+			if _, err := sops.Decrypt(context.Background(), []byte(tampered), sops.Keys{Age: identities}); !errors.Is(err, sops.ErrMAC) {
+				t.Errorf("expected %v, got %v", sops.ErrMAC, err)
+			}
+		})
+	}
+}
+
+// encryptDocument writes a document the way sops encrypts one to a Vault
+// Transit key.
+func encryptDocument(t *testing.T, dataKey []byte, vaultAddress string) []byte {
+	t.Helper()
+	mac := sha512.New()
+	enc := func(plaintext, kind, aad string) string {
+		mac.Write([]byte(plaintext))
+		return encryptValue(t, dataKey, plaintext, kind, aad)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "wireguard:\n")
+	fmt.Fprintf(&b, "    private_key: %s\n", enc("secret", "str", "wireguard:private_key:"))
+	fmt.Fprintf(&b, "    listen_port: %s\n", enc("51820", "int", "wireguard:listen_port:"))
+	fmt.Fprintf(&b, "    enabled: %s\n", enc("True", "bool", "wireguard:enabled:"))
+	mac.Write([]byte("1420"))
+	fmt.Fprintf(&b, "    mtu_unencrypted: 1420\n")
+	fmt.Fprintf(&b, "tokens:\n")
+	fmt.Fprintf(&b, "    - %s\n", enc("a", "str", "tokens:"))
+	fmt.Fprintf(&b, "    - %s\n", enc("b", "str", "tokens:"))
+	fmt.Fprintf(&b, "sops:\n")
+	fmt.Fprintf(&b, "    hc_vault:\n")
+	fmt.Fprintf(&b, "        - vault_address: %s\n          engine_path: transit\n          key_name: kubewg\n          enc: vault:v1:wrapped\n", vaultAddress)
+	fmt.Fprintf(&b, "    lastmodified: %q\n", lastModified)
+	fmt.Fprintf(&b, "    mac: %s\n", encryptValue(t, dataKey, fmt.Sprintf("%X", mac.Sum(nil)), "str", lastModified))
+	fmt.Fprintf(&b, "    unencrypted_suffix: _unencrypted\n    version: 3.8.1\n")
+	return []byte(b.String())
+}
+
+func encryptValue(t *testing.T, key []byte, plaintext, kind, aad string) string {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := make([]byte, 32)
+	if _, err := rand.Read(iv); err != nil {
+		t.Fatal(err)
+	}
+	sealed := gcm.Seal(nil, iv, []byte(plaintext), []byte(aad))
+	data, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	encode := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]", encode(data), encode(iv), encode(tag), kind)
+}
@@ -0,0 +1,219 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package spiffe
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+// attestationContext keeps the signature from meaning anything else
+const attestationContext = "kubewg.net/attestation/v1"
+
+var (
+	ErrNoAttestation      = errors.New("no SPIFFE attestation")
+	ErrInvalidAttestation = errors.New("invalid SPIFFE attestation")
+	ErrIDNotAllowed       = errors.New("SPIFFE ID not allowed")
+)
+
+// Attestation is a node's WireGuard key signed with its SVID, published
+// next to the key.
+type Attestation struct {
+	// Chain holds the DER certificates of the SVID, leaf first
+	Chain     [][]byte `json:"chain"`
+	Signature []byte   `json:"signature"`
+}
+
+// Attestor signs this node's key and verifies the keys of the others
+// against the trust bundle of the current SVID.
+type Attestor struct {
+	source *Source
+	config *config.SPIFFE
+	mu     sync.Mutex
+	// cached is the last attestation, ECDSA signatures differ every time
+	// and would change the published value on every sync
+	cached     string
+	cachedFor  string
+	cachedSVID *SVID
+}
+
+func NewAttestor(source *Source, spiffeConfig *config.SPIFFE) *Attestor {
+	return &Attestor{source: source, config: spiffeConfig}
+}
+
+// Attest returns the attestation of publicKey on the Node nodeName, or an
+// empty one without an SVID.
+func (a *Attestor) Attest(nodeName, publicKey string) string {
+	svid, err := a.source.SVID()
+	if err != nil {
+		return ""
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	message := nodeName + "\n" + publicKey
+	if a.cachedSVID == svid && a.cachedFor == message {
+		return a.cached
+	}
+
+	attestation, err := Sign(svid, nodeName, publicKey)
+	if err != nil {
+		slog.Error("Failed to sign the public key with the SVID", "spiffe_id", svid.ID, "error", err.Error())
+		return ""
+	}
+	a.cached, a.cachedFor, a.cachedSVID = attestation, message, svid
+	return attestation
+}
+
+// Verify checks the attestation another node published for publicKey and
+// returns its SPIFFE ID, which has to be one allowed for nodeName.
+func (a *Attestor) Verify(nodeName, publicKey, attestation string) (string, error) {
+	svid, err := a.source.SVID()
+	if err != nil {
+		return "", err
+	}
+	id, err := Verify(attestation, nodeName, publicKey, svid.Bundle, time.Now())
+	if err != nil {
+		return "", err
+	}
+	if !a.config.Allowed(id, nodeName) {
+		return "", fmt.Errorf("%w: %s for node %s", ErrIDNotAllowed, id, nodeName)
+	}
+	return id, nil
+}
+
+// Sign signs publicKey on the Node nodeName with svid.
+func Sign(svid *SVID, nodeName, publicKey string) (string, error) {
+	digest, opts := digest(svid.PrivateKey.Public(), signedMessage(nodeName, publicKey))
+	signature, err := svid.PrivateKey.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		return "", err
+	}
+
+	attestation := Attestation{Signature: signature}
+	for _, cert := range svid.Certificates {
+		attestation.Chain = append(attestation.Chain, cert.Raw)
+	}
+	data, err := json.Marshal(attestation)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Verify checks that attestation signs publicKey on the Node nodeName with
+// an SVID valid at now under the roots of bundle, and returns the SPIFFE
+// ID of the SVID.
+func Verify(attestation, nodeName, publicKey string, bundle []*x509.Certificate, now time.Time) (string, error) {
+	if attestation == "" {
+		return "", ErrNoAttestation
+	}
+	var decoded Attestation
+	if err := json.Unmarshal([]byte(attestation), &decoded); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidAttestation, err)
+	}
+	if len(decoded.Chain) == 0 {
+		return "", fmt.Errorf("%w: no certificates", ErrInvalidAttestation)
+	}
+
+	chain := make([]*x509.Certificate, 0, len(decoded.Chain))
+	for _, der := range decoded.Chain {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", ErrInvalidAttestation, err)
+		}
+		chain = append(chain, cert)
+	}
+	leaf := chain[0]
+
+	roots := x509.NewCertPool()
+	for _, cert := range bundle {
+		roots.AddCert(cert)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidAttestation, err)
+	}
+
+	// An X.509 SVID carries exactly one URI SAN, its SPIFFE ID
+	if len(leaf.URIs) != 1 || leaf.URIs[0].Scheme != "spiffe" {
+		return "", fmt.Errorf("%w: no SPIFFE ID", ErrInvalidAttestation)
+	}
+	if err := verifySignature(leaf.PublicKey, signedMessage(nodeName, publicKey), decoded.Signature); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidAttestation, err)
+	}
+	return leaf.URIs[0].String(), nil
+}
+
+func signedMessage(nodeName, publicKey string) []byte {
+	return []byte(attestationContext + "\n" + nodeName + "\n" + publicKey)
+}
+
+// digest returns what key signs for message: a SHA-256 digest, or the
+// message itself for Ed25519.
+func digest(key crypto.PublicKey, message []byte) ([]byte, crypto.SignerOpts) {
+	if _, ok := key.(ed25519.PublicKey); ok {
+		return message, crypto.Hash(0)
+	}
+	sum := sha256.Sum256(message)
+	return sum[:], crypto.SHA256
+}
+
+func verifySignature(key crypto.PublicKey, message, signature []byte) error {
+	digest, _ := digest(key, message)
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest, signature) {
+			return errors.New("bad signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, signature)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, digest, signature) {
+			return errors.New("bad signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported key %T", key)
+	}
+}
@@ -0,0 +1,233 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package spiffe_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/spiffe"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	nodeKey  = "YWJjZGVmZ2hpamtsbW5vcHFyc3R1dnd4eXoxMjM0NTY="
+	workload = "spiffe://example.org/ns/kube-system/sa/kubewg"
+)
+
+// issuer is a trust domain's root and an SVID it issued.
+type issuer struct {
+	root    *x509.Certificate
+	leaf    *x509.Certificate
+	leafKey *ecdsa.PrivateKey
+}
+
+func newIssuer(t *testing.T, id string) *issuer {
+	t.Helper()
+
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"SPIRE"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, &rootKey.PublicKey, rootKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	root, _ := x509.ParseCertificate(rootDER)
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	uri, _ := url.Parse(id)
+	leafDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		URIs:         []*url.URL{uri},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}, root, &leafKey.PublicKey, rootKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	leaf, _ := x509.ParseCertificate(leafDER)
+	return &issuer{root: root, leaf: leaf, leafKey: leafKey}
+}
+
+func (i *issuer) svid(id string) *spiffe.SVID {
+	return &spiffe.SVID{
+		ID:           id,
+		Certificates: []*x509.Certificate{i.leaf},
+		PrivateKey:   i.leafKey,
+		Bundle:       []*x509.Certificate{i.root},
+	}
+}
+
+// response encodes an X509SVIDResponse with the issuer's SVID.
+func (i *issuer) response(t *testing.T, id string) []byte {
+	t.Helper()
+
+	key, err := x509.MarshalPKCS8PrivateKey(i.leafKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var svid []byte
+	svid = protowire.AppendTag(svid, 1, protowire.BytesType)
+	svid = protowire.AppendString(svid, id)
+	svid = protowire.AppendTag(svid, 2, protowire.BytesType)
+	svid = protowire.AppendBytes(svid, i.leaf.Raw)
+	svid = protowire.AppendTag(svid, 3, protowire.BytesType)
+	svid = protowire.AppendBytes(svid, key)
+	svid = protowire.AppendTag(svid, 4, protowire.BytesType)
+	svid = protowire.AppendBytes(svid, i.root.Raw)
+
+	var response []byte
+	response = protowire.AppendTag(response, 1, protowire.BytesType)
+	return protowire.AppendBytes(response, svid)
+}
+
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) { return *v.(*[]byte), nil }
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	*v.(*[]byte) = append([]byte(nil), data...)
+	return nil
+}
+
+func (rawCodec) Name() string { return "proto" }
+
+func TestSourceFetchesSVID(t *testing.T) {
+	t.Parallel()
+
+	ca := newIssuer(t, workload)
+	response := ca.response(t, workload)
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	server := grpc.NewServer(grpc.ForceServerCodec(rawCodec{}), grpc.UnknownServiceHandler(func(_ any, stream grpc.ServerStream) error {
+		method, _ := grpc.MethodFromServerStream(stream)
+		md, _ := metadata.FromIncomingContext(stream.Context())
+		if method != "/SpiffeWorkloadAPI/FetchX509SVID" || len(md.Get("workload.spiffe.io")) == 0 {
+			return status.Error(codes.InvalidArgument, "unexpected call")
+		}
+		var request []byte
+		if err := stream.RecvMsg(&request); err != nil {
+			return err
+		}
+		if err := stream.SendMsg(&response); err != nil {
+			return err
+		}
+		<-stream.Context().Done()
+		return nil
+	}))
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	source := spiffe.NewSource(socket)
+	if _, err := source.SVID(); !errors.Is(err, spiffe.ErrNoSVID) {
+		t.Errorf("expected ErrNoSVID before the first update, got %v", err)
+	}
+	changed := make(chan struct{}, 1)
+	source.OnChange(func() {
+		changed <- struct{}{}
+	})
+	go source.Start(ctx)
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the SVID")
+	}
+	svid, err := source.SVID()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if svid.ID != workload || len(svid.Certificates) != 1 || len(svid.Bundle) != 1 {
+		t.Errorf("expected the SVID of %s, got %+v", workload, svid)
+	}
+}
+
+func TestAttestation(t *testing.T) {
+	t.Parallel()
+
+	ca := newIssuer(t, workload)
+	svid := ca.svid(workload)
+	attestation, err := spiffe.Sign(svid, "node-b", nodeKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	id, err := spiffe.Verify(attestation, "node-b", nodeKey, svid.Bundle, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if id != workload {
+		t.Errorf("expected %s, got %s", workload, id)
+	}
+
+	if _, err := spiffe.Verify(attestation, "node-c", nodeKey, svid.Bundle, time.Now()); !errors.Is(err, spiffe.ErrInvalidAttestation) {
+		t.Errorf("expected the attestation not to hold for another node, got %v", err)
+	}
+	if _, err := spiffe.Verify(attestation, "node-b", "b3RoZXIta2V5LW90aGVyLWtleS1vdGhlci1rZXkxMjM=", svid.Bundle, time.Now()); !errors.Is(err, spiffe.ErrInvalidAttestation) {
+		t.Errorf("expected the attestation not to hold for another key, got %v", err)
+	}
+	other := newIssuer(t, workload)
+	if _, err := spiffe.Verify(attestation, "node-b", nodeKey, []*x509.Certificate{other.root}, time.Now()); !errors.Is(err, spiffe.ErrInvalidAttestation) {
+		t.Errorf("expected an SVID of another trust domain to be rejected, got %v", err)
+	}
+	if _, err := spiffe.Verify(attestation, "node-b", nodeKey, svid.Bundle, time.Now().Add(2*time.Hour)); !errors.Is(err, spiffe.ErrInvalidAttestation) {
+		t.Errorf("expected an expired SVID to be rejected, got %v", err)
+	}
+	if _, err := spiffe.Verify("", "node-b", nodeKey, svid.Bundle, time.Now()); !errors.Is(err, spiffe.ErrNoAttestation) {
+		t.Errorf("expected ErrNoAttestation, got %v", err)
+	}
+}
@@ -0,0 +1,267 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package spiffe fetches the X.509 SVID of this workload from a SPIRE
+// agent and uses it to vouch for the node's WireGuard key, so nodes only
+// peer with workloads the trust domain attested.
+package spiffe
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// fetchX509SVID streams the SVIDs of the caller whenever they change
+	fetchX509SVID = "/SpiffeWorkloadAPI/FetchX509SVID"
+	// securityHeader must be set on every Workload API call
+	securityHeader = "workload.spiffe.io"
+	// retryInterval is how long to wait before reconnecting to the agent
+	retryInterval = 5 * time.Second
+)
+
+var (
+	ErrNoSVID      = errors.New("no X.509 SVID from the SPIRE agent yet")
+	ErrInvalidSVID = errors.New("invalid X.509 SVID response")
+)
+
+// SVID is the X.509 identity document the agent issued to this workload.
+type SVID struct {
+	ID string
+	// Certificates is the chain, leaf first
+	Certificates []*x509.Certificate
+	PrivateKey   crypto.Signer
+	// Bundle holds the roots of the trust domain
+	Bundle []*x509.Certificate
+}
+
+// Source keeps the latest SVID from the Workload API of a SPIRE agent,
+// which rotates it well before it expires.
+type Source struct {
+	socketPath string
+	mu         sync.Mutex
+	svid       *SVID
+	onChange   func()
+}
+
+func NewSource(socketPath string) *Source {
+	return &Source{socketPath: socketPath}
+}
+
+// OnChange sets a callback for every new SVID.
+func (s *Source) OnChange(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = f
+}
+
+// SVID returns the current SVID.
+func (s *Source) SVID() (*SVID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.svid == nil {
+		return nil, ErrNoSVID
+	}
+	return s.svid, nil
+}
+
+// Start follows the SVID until ctx is done, reconnecting to the agent when
+// the stream breaks.
+func (s *Source) Start(ctx context.Context) {
+	for {
+		err := s.watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("Lost the SPIRE agent's Workload API", "socket", s.socketPath, "error", err.Error())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+func (s *Source) watch(ctx context.Context) error {
+	conn, err := grpc.Dial("unix://"+s.socketPath,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(rawCodec{})),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the SPIRE agent: %w", err)
+	}
+	defer conn.Close()
+
+	ctx = metadata.AppendToOutgoingContext(ctx, securityHeader, "true")
+	stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, fetchX509SVID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch X.509 SVID: %w", err)
+	}
+	// X509SVIDRequest has no fields
+	request := []byte{}
+	if err := stream.SendMsg(&request); err != nil {
+		return fmt.Errorf("failed to fetch X.509 SVID: %w", err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		return fmt.Errorf("failed to fetch X.509 SVID: %w", err)
+	}
+
+	for {
+		var response []byte
+		if err := stream.RecvMsg(&response); err != nil {
+			return fmt.Errorf("failed to receive X.509 SVID: %w", err)
+		}
+		svid, err := parseX509SVIDResponse(response)
+		if err != nil {
+			slog.Warn("Ignoring X.509 SVID update", "error", err.Error())
+			continue
+		}
+
+		s.mu.Lock()
+		s.svid = svid
+		onChange := s.onChange
+		s.mu.Unlock()
+		slog.Info("Received X.509 SVID", "spiffe_id", svid.ID, "expires_at", svid.Certificates[0].NotAfter)
+		if onChange != nil {
+			onChange()
+		}
+	}
+}
+
+// parseX509SVIDResponse decodes an X509SVIDResponse and returns its first
+// SVID, which is the workload's default.
+//
+//	message X509SVIDResponse { repeated X509SVID svids = 1; ... }
+//	message X509SVID {
+//	  string spiffe_id = 1;
+//	  bytes x509_svid = 2;     // ASN.1 DER certificates, leaf first
+//	  bytes x509_svid_key = 3; // PKCS#8 DER private key
+//	  bytes bundle = 4;        // ASN.1 DER root certificates
+//	}
+func parseX509SVIDResponse(data []byte) (*SVID, error) {
+	var first []byte
+	err := eachField(data, func(num protowire.Number, value []byte) error {
+		if num == 1 && first == nil {
+			first = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSVID, err)
+	}
+	if first == nil {
+		return nil, fmt.Errorf("%w: no SVIDs", ErrInvalidSVID)
+	}
+
+	svid := &SVID{}
+	err = eachField(first, func(num protowire.Number, value []byte) error {
+		var err error
+		switch num {
+		case 1:
+			svid.ID = string(value)
+		case 2:
+			svid.Certificates, err = x509.ParseCertificates(value)
+		case 3:
+			var key any
+			key, err = x509.ParsePKCS8PrivateKey(value)
+			signer, ok := key.(crypto.Signer)
+			if err == nil && !ok {
+				err = fmt.Errorf("unsupported private key %T", key)
+			}
+			svid.PrivateKey = signer
+		case 4:
+			svid.Bundle, err = x509.ParseCertificates(value)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSVID, err)
+	}
+	if svid.ID == "" || len(svid.Certificates) == 0 || svid.PrivateKey == nil || len(svid.Bundle) == 0 {
+		return nil, fmt.Errorf("%w: incomplete SVID", ErrInvalidSVID)
+	}
+	return svid, nil
+}
+
+// eachField calls f with the length-delimited fields of a message, which
+// are the only ones the Workload API messages used here have.
+func eachField(data []byte, f func(protowire.Number, []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			data = data[n:]
+			continue
+		}
+		value, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if err := f(num, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rawCodec passes messages through as encoded bytes, so the Workload API
+// needs no generated code.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	data, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message %T", v)
+	}
+	return *data, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	out, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("unexpected message %T", v)
+	}
+	*out = append((*out)[:0], data...)
+	return nil
+}
+
+// Name keeps the content type the agent expects
+func (rawCodec) Name() string {
+	return "proto"
+}
@@ -0,0 +1,136 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package state
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/kubewg-net/container/pkg/apis/kubewg/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/retry"
+)
+
+//nolint:golint,gochecknoglobals
+var WireGuardStates = v1alpha1.SchemeGroupVersion.WithResource("wireguardstates")
+
+// CRD keeps the documents of a node in the WireGuardState named after it,
+// for clusters that would rather not hand the pods access to Secrets.
+type CRD struct {
+	client dynamic.Interface
+	name   string
+}
+
+func NewCRD(client dynamic.Interface, nodeName string) *CRD {
+	return &CRD{client: client, name: nodeName}
+}
+
+func (c *CRD) Load(ctx context.Context, name string) ([]byte, error) {
+	resource, err := c.get(ctx)
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get WireGuardState %s: %w", c.name, err)
+	}
+	data, ok := resource.Spec.Documents[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return data, nil
+}
+
+func (c *CRD) Save(ctx context.Context, name string, data []byte) error {
+	resources := c.client.Resource(WireGuardStates)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		resource, err := c.get(ctx)
+		if apierrors.IsNotFound(err) {
+			resource = &v1alpha1.WireGuardState{
+				TypeMeta:   metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: "WireGuardState"},
+				ObjectMeta: metav1.ObjectMeta{Name: c.name},
+				Spec:       v1alpha1.WireGuardStateSpec{Documents: map[string][]byte{name: data}},
+			}
+			obj, err := toUnstructured(resource)
+			if err != nil {
+				return err
+			}
+			_, err = resources.Create(ctx, obj, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				// Lost a race with another writer, retry as an update
+				return apierrors.NewConflict(WireGuardStates.GroupResource(), c.name, err)
+			}
+			return err
+		} else if err != nil {
+			return err
+		}
+
+		if resource.Spec.Documents == nil {
+			resource.Spec.Documents = make(map[string][]byte, 1)
+		}
+		resource.Spec.Documents[name] = data
+		obj, err := toUnstructured(resource)
+		if err != nil {
+			return err
+		}
+		_, err = resources.Update(ctx, obj, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write WireGuardState %s: %w", c.name, err)
+	}
+	return nil
+}
+
+func (c *CRD) List(ctx context.Context) ([]string, error) {
+	resource, err := c.get(ctx)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get WireGuardState %s: %w", c.name, err)
+	}
+	return names(resource.Spec.Documents), nil
+}
+
+func (c *CRD) Close() error {
+	return nil
+}
+
+func (c *CRD) get(ctx context.Context) (*v1alpha1.WireGuardState, error) {
+	obj, err := c.client.Resource(WireGuardStates).Get(ctx, c.name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var resource v1alpha1.WireGuardState
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &resource); err != nil {
+		return nil, fmt.Errorf("invalid WireGuardState %s: %w", c.name, err)
+	}
+	return &resource, nil
+}
+
+func toUnstructured(resource *v1alpha1.WireGuardState) (*unstructured.Unstructured, error) {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(resource)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert WireGuardState %s: %w", resource.Name, err)
+	}
+	return &unstructured.Unstructured{Object: obj}, nil
+}
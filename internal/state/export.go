@@ -0,0 +1,88 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DumpVersion is bumped whenever the dump format changes incompatibly.
+const DumpVersion = 1
+
+var (
+	ErrDumpVersion     = errors.New("unsupported state dump version")
+	ErrInvalidDocument = errors.New("state document is not JSON")
+)
+
+// Dump is every document of a store, for backups and for moving state
+// from one store type to another. The documents are JSON and embedded as
+// they are, so a dump carries whatever the store holds, enrollment token
+// and lease hashes included.
+type Dump struct {
+	Version    int                        `json:"version"`
+	ExportedAt time.Time                  `json:"exported_at"`
+	Documents  map[string]json.RawMessage `json:"documents"`
+}
+
+// Export dumps every document of store.
+func Export(ctx context.Context, store Store) (*Dump, error) {
+	list, err := store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dump := &Dump{
+		Version:    DumpVersion,
+		ExportedAt: time.Now().UTC(),
+		Documents:  make(map[string]json.RawMessage, len(list)),
+	}
+	for _, name := range list {
+		data, err := store.Load(ctx, name)
+		if errors.Is(err, ErrNotFound) {
+			// Gone since it was listed
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if !json.Valid(data) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidDocument, name)
+		}
+		dump.Documents[name] = data
+	}
+	return dump, nil
+}
+
+// Import writes the documents of dump to store, replacing the documents
+// of the same name and leaving any others alone.
+func Import(ctx context.Context, store Store, dump *Dump) error {
+	if dump.Version != DumpVersion {
+		return fmt.Errorf("%w: %d", ErrDumpVersion, dump.Version)
+	}
+	for _, name := range names(dump.Documents) {
+		if err := store.Save(ctx, name, dump.Documents[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
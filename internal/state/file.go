@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package state
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// openTimeout bounds the wait for another process holding the database
+const openTimeout = 5 * time.Second
+
+//nolint:golint,gochecknoglobals
+var fileBucket = []byte("state")
+
+// File keeps documents in a bbolt database, each write committed to disk
+// before Save returns.
+type File struct {
+	db *bolt.DB
+}
+
+// OpenFile opens the database at path, creating it if needed.
+func OpenFile(path string) (*File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(fileBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize state %s: %w", path, err)
+	}
+	return &File{db: db}, nil
+}
+
+func (f *File) Load(_ context.Context, name string) ([]byte, error) {
+	var data []byte
+	err := f.db.View(func(tx *bolt.Tx) error {
+		// The value is only valid within the transaction
+		data = slices.Clone(tx.Bucket(fileBucket).Get([]byte(name)))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state %s: %w", name, err)
+	}
+	if data == nil {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return data, nil
+}
+
+func (f *File) Save(_ context.Context, name string, data []byte) error {
+	err := f.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(fileBucket).Put([]byte(name), data)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write state %s: %w", name, err)
+	}
+	return nil
+}
+
+func (f *File) List(_ context.Context) ([]string, error) {
+	var list []string
+	err := f.db.View(func(tx *bolt.Tx) error {
+		// Keys come in byte order, which is sorted
+		return tx.Bucket(fileBucket).ForEach(func(k, _ []byte) error {
+			list = append(list, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list state: %w", err)
+	}
+	return list, nil
+}
+
+func (f *File) Close() error {
+	return f.db.Close()
+}
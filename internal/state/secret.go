@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package state
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// SecretType marks the Secrets holding state
+	SecretType corev1.SecretType = "kubewg.net/state"
+	// SecretLabel is set to "true" on the Secrets holding state
+	SecretLabel = "kubewg.net/state"
+)
+
+// Secret keeps the documents of a node as the data keys of one Secret, so
+// the state follows the node to wherever its pod is scheduled.
+type Secret struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+}
+
+func NewSecret(client kubernetes.Interface, namespace, nodeName string) *Secret {
+	return &Secret{client: client, namespace: namespace, name: SecretName(nodeName)}
+}
+
+// SecretName names the Secret holding the state of a node.
+func SecretName(nodeName string) string {
+	return "kubewg-state-" + nodeName
+}
+
+func (s *Secret) Load(ctx context.Context, name string) ([]byte, error) {
+	secret, err := s.client.CoreV1().Secrets(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %w", s.namespace, s.name, err)
+	}
+	data, ok := secret.Data[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return data, nil
+}
+
+func (s *Secret) Save(ctx context.Context, name string, data []byte) error {
+	secrets := s.client.CoreV1().Secrets(s.namespace)
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret, err := secrets.Get(ctx, s.name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = secrets.Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      s.name,
+					Namespace: s.namespace,
+					Labels:    map[string]string{SecretLabel: "true"},
+				},
+				Type: SecretType,
+				Data: map[string][]byte{name: data},
+			}, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				// Lost a race with another writer, retry as an update
+				return apierrors.NewConflict(corev1.Resource("secrets"), s.name, err)
+			}
+			return err
+		} else if err != nil {
+			return err
+		}
+
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte, 1)
+		}
+		secret.Data[name] = data
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write secret %s/%s: %w", s.namespace, s.name, err)
+	}
+	return nil
+}
+
+func (s *Secret) List(ctx context.Context) ([]string, error) {
+	secret, err := s.client.CoreV1().Secrets(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %w", s.namespace, s.name, err)
+	}
+	return names(secret.Data), nil
+}
+
+func (s *Secret) Close() error {
+	return nil
+}
@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package state keeps node-local state, such as enrolled peers and their
+// leases, across restarts. Every component saves through the same Store, so
+// a node keeps it in a file when it runs standalone and in the cluster when
+// it runs under the operator.
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/kubewg-net/container/internal/config"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+var (
+	ErrNotFound = errors.New("state not found")
+)
+
+// Store keeps documents by name. Each component keeps its state in a
+// document of its own and replaces it as a whole.
+type Store interface {
+	// Load returns the document stored under name, or ErrNotFound.
+	Load(ctx context.Context, name string) ([]byte, error)
+	// Save replaces the document stored under name.
+	Save(ctx context.Context, name string, data []byte) error
+	// List returns the names of the stored documents, sorted.
+	List(ctx context.Context) ([]string, error)
+	Close() error
+}
+
+// Open returns the store c selects. kubeClient is only used by the
+// kubernetes type and kubeDynamic by the crd type.
+func Open(c *config.Config, kubeClient kubernetes.Interface, kubeDynamic dynamic.Interface) (Store, error) {
+	switch c.State.Type {
+	case config.StateFile:
+		return OpenFile(c.State.Path)
+	case config.StateKubernetes:
+		return NewSecret(kubeClient, c.State.Namespace, c.Kubernetes.NodeName), nil
+	case config.StateCRD:
+		return NewCRD(kubeDynamic, c.Kubernetes.NodeName), nil
+	case "", config.StateMemory:
+		return NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", config.ErrStateType, c.State.Type)
+	}
+}
+
+func names[V any](documents map[string]V) []string {
+	list := make([]string, 0, len(documents))
+	for name := range documents {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
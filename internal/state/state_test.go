@@ -0,0 +1,182 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package state_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/state"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestStores(t *testing.T) {
+	t.Parallel()
+
+	file, err := state.OpenFile(filepath.Join(t.TempDir(), "state", "state.db"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	t.Cleanup(func() { file.Close() })
+
+	kubeDynamic := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{state.WireGuardStates: "WireGuardStateList"})
+
+	for name, store := range map[string]state.Store{
+		"memory": state.NewMemory(),
+		"file":   file,
+		"secret": state.NewSecret(fake.NewSimpleClientset(), "kubewg", "node-a"),
+		"crd":    state.NewCRD(kubeDynamic, "node-a"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+
+			if _, err := store.Load(ctx, "enrollment"); !errors.Is(err, state.ErrNotFound) {
+				t.Fatalf("expected not found, got %v", err)
+			}
+			for _, data := range []string{`{"version":1}`, `{"version":2}`} {
+				if err := store.Save(ctx, "enrollment", []byte(data)); err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+			}
+			if err := store.Save(ctx, "other", []byte("other")); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			data, err := store.Load(ctx, "enrollment")
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if string(data) != `{"version":2}` {
+				t.Errorf("expected the last saved document, got %s", data)
+			}
+
+			list, err := store.List(ctx)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if !slices.Equal(list, []string{"enrollment", "other"}) {
+				t.Errorf("expected enrollment and other, got %v", list)
+			}
+		})
+	}
+}
+
+func TestFileSurvivesReopen(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "state.db")
+	file, err := state.OpenFile(path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := file.Save(context.Background(), "enrollment", []byte("saved")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	file, err = state.OpenFile(path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer file.Close()
+	data, err := file.Load(context.Background(), "enrollment")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if string(data) != "saved" {
+		t.Errorf("expected saved, got %s", data)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	source := state.NewMemory()
+	for name, data := range map[string]string{
+		"enrollment": `{"version":1,"tokens":[]}`,
+		"imports":    `{"version":1,"peers":[]}`,
+	} {
+		if err := source.Save(ctx, name, []byte(data)); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	dump, err := state.Export(ctx, source)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(dump.Documents) != 2 {
+		t.Fatalf("expected 2 documents, got %d", len(dump.Documents))
+	}
+
+	// Through JSON, as between export and import
+	data, err := json.Marshal(dump)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	var restored state.Dump
+	if err := json.Unmarshal(data, &restored); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	target, err := state.OpenFile(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer target.Close()
+	if err := target.Save(ctx, "other", []byte(`{}`)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := state.Import(ctx, target, &restored); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	list, err := target.List(ctx)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !slices.Equal(list, []string{"enrollment", "imports", "other"}) {
+		t.Errorf("expected the imported documents next to other, got %v", list)
+	}
+	enrollment, err := target.Load(ctx, "enrollment")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if string(enrollment) != `{"version":1,"tokens":[]}` {
+		t.Errorf("expected the exported document, got %s", enrollment)
+	}
+
+	restored.Version = state.DumpVersion + 1
+	if err := state.Import(ctx, target, &restored); !errors.Is(err, state.ErrDumpVersion) {
+		t.Errorf("expected ErrDumpVersion, got %v", err)
+	}
+}
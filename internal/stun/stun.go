@@ -0,0 +1,245 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package stun
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// A STUN Binding exchange as described in RFC 5389, just enough of it to
+// learn the address a NAT maps a local UDP port to
+const (
+	headerLength        = 20
+	magicCookie         = 0x2112A442
+	bindingRequest      = 0x0001
+	bindingSuccess      = 0x0101
+	attrMappedAddress   = 0x0001
+	attrXORMappedAddr   = 0x0020
+	familyIPv4          = 0x01
+	familyIPv6          = 0x02
+	maxMessageSize      = 1500
+	defaultQueryTimeout = 5 * time.Second
+)
+
+var (
+	ErrNoServers       = errors.New("no STUN servers configured")
+	ErrMalformed       = errors.New("malformed STUN response")
+	ErrNoMappedAddress = errors.New("STUN response carries no mapped address")
+
+	errOtherTransaction = errors.New("response to another transaction")
+)
+
+// Discover asks the servers in order for the public address of localPort
+// and returns the first answer. A localPort of 0 queries from an ephemeral
+// port, which only tells the public IP: the port is then the NAT's mapping
+// of that ephemeral port, not of the WireGuard socket.
+func Discover(ctx context.Context, servers []string, localPort uint16) (netip.AddrPort, error) {
+	if len(servers) == 0 {
+		return netip.AddrPort{}, ErrNoServers
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: int(localPort)})
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("failed to listen on UDP port %d: %w", localPort, err)
+	}
+	defer conn.Close()
+
+	var errs []error
+	for _, server := range servers {
+		mapped, err := query(ctx, conn, server)
+		if err == nil {
+			return mapped, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", server, err))
+	}
+	return netip.AddrPort{}, errors.Join(errs...)
+}
+
+// Endpoint discovers the endpoint to advertise for a WireGuard socket on
+// listenPort. Querying from the listen port itself reveals the NAT's real
+// mapping, but only works while WireGuard isn't bound to it yet; otherwise
+// the public IP is paired with the listen port, which holds for NATs that
+// preserve ports and for forwarded ports.
+func Endpoint(ctx context.Context, servers []string, listenPort uint16) (netip.AddrPort, error) {
+	mapped, err := Discover(ctx, servers, listenPort)
+	if err == nil {
+		return mapped, nil
+	}
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		return netip.AddrPort{}, err
+	}
+
+	mapped, err = Discover(ctx, servers, 0)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	return netip.AddrPortFrom(mapped.Addr(), listenPort), nil
+}
+
+func query(ctx context.Context, conn *net.UDPConn, server string) (netip.AddrPort, error) {
+	addr, err := net.ResolveUDPAddr("udp4", server)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+
+	var txID [12]byte
+	if _, err := rand.Read(txID[:]); err != nil {
+		return netip.AddrPort{}, err
+	}
+	request := make([]byte, headerLength)
+	binary.BigEndian.PutUint16(request[0:2], bindingRequest)
+	binary.BigEndian.PutUint32(request[4:8], magicCookie)
+	copy(request[8:20], txID[:])
+
+	deadline := time.Now().Add(defaultQueryTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return netip.AddrPort{}, err
+	}
+
+	if _, err := conn.WriteToUDP(request, addr); err != nil {
+		return netip.AddrPort{}, err
+	}
+
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, from, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return netip.AddrPort{}, err
+		}
+		// Stray packets, e.g. late answers from a server tried before, are
+		// not ours to parse
+		if from.Addr().Unmap() != addr.AddrPort().Addr().Unmap() || from.Port() != addr.AddrPort().Port() {
+			continue
+		}
+		mapped, err := parseResponse(buf[:n], txID)
+		if errors.Is(err, errOtherTransaction) {
+			continue
+		}
+		return mapped, err
+	}
+}
+
+// parseResponse extracts the mapped address from a Binding success
+// response, preferring XOR-MAPPED-ADDRESS over the legacy MAPPED-ADDRESS.
+func parseResponse(msg []byte, txID [12]byte) (netip.AddrPort, error) {
+	if len(msg) < headerLength || binary.BigEndian.Uint32(msg[4:8]) != magicCookie {
+		return netip.AddrPort{}, ErrMalformed
+	}
+	if [12]byte(msg[8:20]) != txID {
+		return netip.AddrPort{}, errOtherTransaction
+	}
+	if msgType := binary.BigEndian.Uint16(msg[0:2]); msgType != bindingSuccess {
+		return netip.AddrPort{}, fmt.Errorf("%w: message type %#04x", ErrMalformed, msgType)
+	}
+	length := int(binary.BigEndian.Uint16(msg[2:4]))
+	if headerLength+length > len(msg) {
+		return netip.AddrPort{}, ErrMalformed
+	}
+
+	var mapped netip.AddrPort
+	attrs := msg[headerLength : headerLength+length]
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:2])
+		attrLength := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if 4+attrLength > len(attrs) {
+			return netip.AddrPort{}, ErrMalformed
+		}
+		value := attrs[4 : 4+attrLength]
+
+		switch attrType {
+		case attrXORMappedAddr:
+			return parseAddress(value, true, txID)
+		case attrMappedAddress:
+			var err error
+			if mapped, err = parseAddress(value, false, txID); err != nil {
+				return netip.AddrPort{}, err
+			}
+		}
+
+		// Attributes are padded to a multiple of four bytes
+		padded := (attrLength + 3) &^ 3
+		if 4+padded > len(attrs) {
+			break
+		}
+		attrs = attrs[4+padded:]
+	}
+
+	if !mapped.IsValid() {
+		return netip.AddrPort{}, ErrNoMappedAddress
+	}
+	return mapped, nil
+}
+
+func parseAddress(value []byte, xored bool, txID [12]byte) (netip.AddrPort, error) {
+	if len(value) < 4 {
+		return netip.AddrPort{}, ErrMalformed
+	}
+	port := binary.BigEndian.Uint16(value[2:4])
+	ip := value[4:]
+
+	var key []byte
+	if xored {
+		port ^= magicCookie >> 16
+		key = binary.BigEndian.AppendUint32(nil, magicCookie)
+		key = append(key, txID[:]...)
+	}
+
+	var addr netip.Addr
+	switch value[1] {
+	case familyIPv4:
+		if len(ip) != 4 {
+			return netip.AddrPort{}, ErrMalformed
+		}
+		var b [4]byte
+		for i := range b {
+			b[i] = ip[i]
+			if xored {
+				b[i] ^= key[i]
+			}
+		}
+		addr = netip.AddrFrom4(b)
+	case familyIPv6:
+		if len(ip) != 16 {
+			return netip.AddrPort{}, ErrMalformed
+		}
+		var b [16]byte
+		for i := range b {
+			b[i] = ip[i]
+			if xored {
+				b[i] ^= key[i]
+			}
+		}
+		addr = netip.AddrFrom16(b)
+	default:
+		return netip.AddrPort{}, fmt.Errorf("%w: address family %#02x", ErrMalformed, value[1])
+	}
+	return netip.AddrPortFrom(addr, port), nil
+}
@@ -0,0 +1,136 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package stun_test
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/stun"
+)
+
+// serveSTUN answers Binding requests with the XOR-MAPPED-ADDRESS of the
+// sender, like a STUN server with no NAT in between.
+func serveSTUN(t *testing.T) string {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFromUDPAddrPort(buf)
+			if err != nil {
+				return
+			}
+			if n < 20 {
+				continue
+			}
+			cookie := buf[4:8]
+			ip := from.Addr().Unmap().As4()
+
+			response := make([]byte, 20, 32)
+			binary.BigEndian.PutUint16(response[0:2], 0x0101)
+			binary.BigEndian.PutUint16(response[2:4], 12)
+			copy(response[4:20], buf[4:20])
+			response = binary.BigEndian.AppendUint16(response, 0x0020)
+			response = binary.BigEndian.AppendUint16(response, 8)
+			response = append(response, 0, 0x01)
+			response = binary.BigEndian.AppendUint16(response, from.Port()^binary.BigEndian.Uint16(cookie[0:2]))
+			for i := range ip {
+				response = append(response, ip[i]^cookie[i])
+			}
+			_, _ = conn.WriteToUDPAddrPort(response, from)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestDiscoverFromListenPort(t *testing.T) {
+	t.Parallel()
+
+	server := serveSTUN(t)
+
+	// Borrow a free port the way WireGuard would be configured with one
+	probe, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	port := uint16(probe.LocalAddr().(*net.UDPAddr).Port)
+	probe.Close()
+
+	mapped, err := stun.Endpoint(context.Background(), []string{server}, port)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), port)
+	if mapped != expected {
+		t.Errorf("expected %s, got %s", expected, mapped)
+	}
+}
+
+func TestEndpointFallsBackWhenListenPortIsBound(t *testing.T) {
+	t.Parallel()
+
+	server := serveSTUN(t)
+
+	// Stands in for the WireGuard socket already holding the port
+	bound, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer bound.Close()
+	port := uint16(bound.LocalAddr().(*net.UDPAddr).Port)
+
+	mapped, err := stun.Endpoint(context.Background(), []string{server}, port)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), port)
+	if mapped != expected {
+		t.Errorf("expected the public IP with the listen port %s, got %s", expected, mapped)
+	}
+}
+
+func TestDiscoverSkipsUnreachableServers(t *testing.T) {
+	t.Parallel()
+
+	_, err := stun.Discover(context.Background(), nil, 0)
+	if err == nil {
+		t.Fatal("expected an error without servers")
+	}
+
+	server := serveSTUN(t)
+	mapped, err := stun.Discover(context.Background(), []string{"127.0.0.1", server}, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mapped.Addr() != netip.MustParseAddr("127.0.0.1") {
+		t.Errorf("expected 127.0.0.1, got %s", mapped.Addr())
+	}
+}
@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package tracing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync/atomic"
+
+	"github.com/kubewg-net/container/internal/config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Attribute keys of the peers and nodes spans are about
+const (
+	PeerKeyAttribute     = attribute.Key("kubewg.peer.public_key")
+	PeerKeyHashAttribute = attribute.Key("kubewg.peer.public_key_hash")
+	NodeAttribute        = attribute.Key("kubewg.node")
+)
+
+//nolint:golint,gochecknoglobals
+var redaction atomic.Value
+
+// SetRedaction sets how Peer and Node record identifiers, one of the
+// config.Redact* policies. Start sets it from the config.
+func SetRedaction(policy string) {
+	redaction.Store(policy)
+}
+
+func policy() string {
+	if policy, ok := redaction.Load().(string); ok && policy != "" {
+		return policy
+	}
+	return config.RedactHash
+}
+
+// Peer returns the attributes identifying a peer by its public key and the
+// node it runs on, if known, under the redaction policy.
+func Peer(publicKey, node string) []attribute.KeyValue {
+	switch policy() {
+	case config.RedactOmit:
+		return nil
+	case config.RedactNone:
+		return appendNode([]attribute.KeyValue{PeerKeyAttribute.String(publicKey)}, node)
+	default:
+		return appendNode([]attribute.KeyValue{PeerKeyHashAttribute.String(HashKey(publicKey))}, node)
+	}
+}
+
+// Node returns the attribute naming a node, unless the policy omits it.
+func Node(name string) []attribute.KeyValue {
+	if policy() == config.RedactOmit {
+		return nil
+	}
+	return appendNode(nil, name)
+}
+
+func appendNode(attributes []attribute.KeyValue, node string) []attribute.KeyValue {
+	if node == "" {
+		return attributes
+	}
+	return append(attributes, NodeAttribute.String(node))
+}
+
+// HashKey returns the first 16 hex digits of the SHA-256 of a public key,
+// stable across nodes so a peer can be followed between their traces.
+func HashKey(publicKey string) string {
+	sum := sha256.Sum256([]byte(publicKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// End records the outcome of the operation span covers and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
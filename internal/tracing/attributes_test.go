@@ -0,0 +1,110 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package tracing_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const publicKey = "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+
+//nolint:paralleltest // sets the global redaction policy
+func TestPeer(t *testing.T) {
+	defer tracing.SetRedaction(config.RedactHash)
+
+	tests := []struct {
+		policy   string
+		expected []attribute.KeyValue
+	}{
+		{config.RedactHash, []attribute.KeyValue{
+			tracing.PeerKeyHashAttribute.String(tracing.HashKey(publicKey)),
+			tracing.NodeAttribute.String("node-a"),
+		}},
+		{config.RedactNone, []attribute.KeyValue{
+			tracing.PeerKeyAttribute.String(publicKey),
+			tracing.NodeAttribute.String("node-a"),
+		}},
+		{config.RedactOmit, nil},
+	}
+	for _, tt := range tests {
+		tracing.SetRedaction(tt.policy)
+		got := tracing.Peer(publicKey, "node-a")
+		if len(got) != len(tt.expected) {
+			t.Fatalf("%s: expected %v, got %v", tt.policy, tt.expected, got)
+		}
+		for i := range got {
+			if got[i] != tt.expected[i] {
+				t.Errorf("%s: expected %v, got %v", tt.policy, tt.expected[i], got[i])
+			}
+		}
+	}
+
+	if hash := tracing.HashKey(publicKey); len(hash) != 16 || hash == publicKey {
+		t.Errorf("expected a 16 digit hash, got %q", hash)
+	}
+}
+
+//nolint:paralleltest // sets the global otel tracer provider and propagator
+func TestWrapTransport(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	client := &http.Client{Transport: tracing.WrapTransport(http.DefaultTransport)}
+	response, err := client.Get(server.URL + "/api/v1/nodes/node-a")
+	if err != nil {
+		t.Fatalf("failed to get: %v", err)
+	}
+	response.Body.Close()
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "kubernetes GET" {
+		t.Errorf("expected span kubernetes GET, got %s", span.Name())
+	}
+	if span.Status().Code.String() != "Error" {
+		t.Errorf("expected an error status for a 503, got %s", span.Status().Code)
+	}
+	if traceparent == "" {
+		t.Error("expected the trace to be propagated, got no traceparent")
+	}
+}
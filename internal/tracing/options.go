@@ -0,0 +1,115 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package tracing
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/cryptopolicy"
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+var ErrNoCACerts = errors.New("no certificates found in the tracing CA file")
+
+func sampler(c *config.TraceSampler) sdktrace.Sampler {
+	switch c.Type {
+	case config.SamplerAlwaysOn:
+		return sdktrace.AlwaysSample()
+	case config.SamplerAlwaysOff:
+		return sdktrace.NeverSample()
+	case config.SamplerRatio:
+		return sdktrace.TraceIDRatioBased(c.Ratio)
+	case config.SamplerParentAlwaysOff:
+		return sdktrace.ParentBased(sdktrace.NeverSample())
+	case config.SamplerParentRatio:
+		return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.Ratio))
+	default:
+		return sdktrace.ParentBased(sdktrace.AlwaysSample())
+	}
+}
+
+// propagator reads and writes the trace context in every configured format.
+// Extracting tries them in order, the last one found wins.
+func propagator(formats []string) propagation.TextMapPropagator {
+	propagators := make([]propagation.TextMapPropagator, 0, len(formats))
+	for _, format := range formats {
+		switch format {
+		case config.PropagateTraceContext:
+			propagators = append(propagators, propagation.TraceContext{})
+		case config.PropagateBaggage:
+			propagators = append(propagators, propagation.Baggage{})
+		case config.PropagateB3:
+			propagators = append(propagators, b3.New(b3.WithInjectEncoding(b3.B3SingleHeader)))
+		case config.PropagateB3Multi:
+			propagators = append(propagators, b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader)))
+		}
+	}
+	return propagation.NewCompositeTextMapPropagator(propagators...)
+}
+
+// exporterOptions points the exporter at the endpoint with the configured
+// headers and TLS settings.
+func exporterOptions(c *config.Tracing, policy *config.CryptoPolicy) ([]otlptracehttp.Option, error) {
+	options, err := endpointOptions(c.OTLPEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Headers) > 0 {
+		options = append(options, otlptracehttp.WithHeaders(c.Headers))
+	}
+	tlsConfig, err := tlsConfig(&c.TLS, policy)
+	if err != nil {
+		return nil, err
+	}
+	options = append(options, otlptracehttp.WithTLSClientConfig(tlsConfig))
+	return options, nil
+}
+
+func tlsConfig(c *config.TracingTLS, policy *config.CryptoPolicy) (*tls.Config, error) {
+	tlsConfig := cryptopolicy.TLSConfig(policy)
+	tlsConfig.InsecureSkipVerify = c.InsecureSkipVerify //nolint:gosec // opted into for test collectors
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tracing CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, ErrNoCACerts
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tracing client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package tracing exports OpenTelemetry spans over OTLP/HTTP. Until Start
+// runs, or when tracing is disabled, spans are no-ops that cost next to
+// nothing and carry no trace ID.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/kubewg-net/container/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	instrumentationName = "github.com/kubewg-net/container"
+	serviceName         = "kubewg"
+)
+
+// Tracer starts the spans of kubewg.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start installs a tracer provider exporting to c.OTLPEndpoint and returns
+// the function flushing it on shutdown. The endpoint is host:port or a URL,
+// http:// sends in plain text. Without an endpoint the OTEL_EXPORTER_OTLP_*
+// variables apply. The configured propagators are installed even with
+// tracing disabled, so incoming trace context still reaches the outgoing
+// requests.
+func Start(ctx context.Context, c *config.Tracing, policy *config.CryptoPolicy, version string) (func(context.Context) error, error) {
+	SetRedaction(c.Redaction)
+	otel.SetTextMapPropagator(propagator(c.Propagators))
+	if !c.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	options, err := exporterOptions(c, policy)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource(version)),
+		sdktrace.WithSampler(sampler(&c.Sampler)),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+func endpointOptions(endpoint string) ([]otlptracehttp.Option, error) {
+	if endpoint == "" {
+		return nil, nil
+	}
+	if !strings.Contains(endpoint, "://") {
+		return []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}, nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tracing endpoint: %w", err)
+	}
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	if u.Scheme == "http" {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	if u.Path != "" && u.Path != "/" {
+		options = append(options, otlptracehttp.WithURLPath(u.Path))
+	}
+	return options, nil
+}
+
+// resource names the service in every exported span, merged with what the
+// OTEL_RESOURCE_ATTRIBUTES variable adds.
+func resource(version string) *sdkresource.Resource {
+	merged, err := sdkresource.Merge(sdkresource.Default(), sdkresource.NewSchemaless(
+		semconv.ServiceName(serviceName),
+		semconv.ServiceVersion(version),
+	))
+	if err != nil {
+		return sdkresource.Default()
+	}
+	return merged
+}
+
+// TraceID returns the ID of the sampled trace ctx belongs to, or "".
+func TraceID(ctx context.Context) string {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsSampled() {
+		return ""
+	}
+	return spanContext.TraceID().String()
+}
@@ -0,0 +1,262 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package webhook posts peer events to HTTP endpoints, so they can be piped
+// into chat, paging or automation.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/cryptopolicy"
+	"github.com/kubewg-net/container/internal/events"
+	"github.com/kubewg-net/container/internal/metrics"
+)
+
+const (
+	// SignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the body
+	// keyed with webhooks.secret
+	SignatureHeader = "X-KubeWG-Signature"
+	// EventHeader carries the event type
+	EventHeader = "X-KubeWG-Event"
+
+	// maxQueued bounds the events waiting for each URL, further events
+	// are dropped while an endpoint is down
+	maxQueued = 256
+	// maxBackoff caps the wait between retries
+	maxBackoff = time.Minute
+)
+
+var (
+	ErrUnknownEvent = errors.New("unknown webhook event type")
+	ErrDelivery     = errors.New("webhook delivery failed")
+)
+
+//nolint:golint,gochecknoglobals
+var knownEvents = []events.Type{
+	events.PeerAdded,
+	events.PeerRemoved,
+	events.HandshakeFailed,
+	events.HandshakeRestored,
+	events.KeyRotated,
+	events.LeaseExpired,
+}
+
+// Payload is the JSON body of a delivery. Text repeats the event as a line
+// of prose, which is what Slack and compatible incoming webhooks show.
+type Payload struct {
+	events.Event
+	Node    string `json:"node,omitempty"`
+	Warning bool   `json:"warning"`
+	Text    string `json:"text"`
+}
+
+// Notifier is an events.Sink that posts every event to each URL. Every URL
+// has its own queue, so an endpoint that is down doesn't hold back the
+// others.
+type Notifier struct {
+	config  *config.Webhooks
+	node    string
+	client  *http.Client
+	types   map[events.Type]bool
+	targets []*target
+	wg      sync.WaitGroup
+}
+
+type target struct {
+	url   string
+	queue chan events.Event
+}
+
+// New creates a Notifier for c, naming node as the sender of every event.
+func New(c *config.Webhooks, policy *config.CryptoPolicy, node string) (*Notifier, error) {
+	n := &Notifier{
+		config: c,
+		node:   node,
+		client: &http.Client{
+			Timeout: c.Timeout.Std(),
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: cryptopolicy.TLSConfig(policy),
+			},
+		},
+	}
+	if len(c.Events) > 0 {
+		n.types = make(map[events.Type]bool, len(c.Events))
+		for _, name := range c.Events {
+			eventType := events.Type(name)
+			if !known(eventType) {
+				return nil, fmt.Errorf("%w: %q", ErrUnknownEvent, name)
+			}
+			n.types[eventType] = true
+		}
+	}
+	for _, u := range c.URLs {
+		n.targets = append(n.targets, &target{url: u, queue: make(chan events.Event, maxQueued)})
+	}
+	return n, nil
+}
+
+func known(eventType events.Type) bool {
+	for _, t := range knownEvents {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish queues event for every URL without waiting for the deliveries.
+func (n *Notifier) Publish(event events.Event) {
+	if n.types != nil && !n.types[event.Type] {
+		return
+	}
+	for _, t := range n.targets {
+		select {
+		case t.queue <- event:
+		default:
+			metrics.WebhookDeliveries.WithLabelValues("dropped").Inc()
+			slog.Warn("Dropping webhook event, the queue is full", "url", redactedURL(t.url), "type", string(event.Type))
+		}
+	}
+}
+
+// Start delivers queued events until ctx is done. Events still queued then
+// are dropped.
+func (n *Notifier) Start(ctx context.Context) {
+	for _, t := range n.targets {
+		n.wg.Add(1)
+		go func(t *target) {
+			defer n.wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case event := <-t.queue:
+					n.deliver(ctx, t.url, event)
+				}
+			}
+		}(t)
+	}
+	n.wg.Wait()
+}
+
+// deliver posts event to u, retrying with exponential backoff.
+func (n *Notifier) deliver(ctx context.Context, u string, event events.Event) {
+	body, err := json.Marshal(n.payload(event))
+	if err != nil {
+		slog.Error("Failed to encode webhook payload", "error", err.Error())
+		return
+	}
+
+	backoff := n.config.Backoff.Std()
+	for attempt := 0; ; attempt++ {
+		retry, err := n.post(ctx, u, event.Type, body)
+		if err == nil {
+			metrics.WebhookDeliveries.WithLabelValues("delivered").Inc()
+			return
+		}
+		if !retry || attempt >= n.config.Retries || ctx.Err() != nil {
+			metrics.WebhookDeliveries.WithLabelValues("failed").Inc()
+			slog.Warn("Failed to deliver webhook", "url", redactedURL(u), "type", string(event.Type), "attempts", attempt+1, "error", err.Error())
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// post makes one delivery attempt and reports whether a failure is worth
+// retrying.
+func (n *Notifier) post(ctx context.Context, u string, eventType events.Type, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, string(eventType))
+	if n.config.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign([]byte(n.config.Secret), body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// Drop the URL from the error, it may carry a secret
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return true, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("%w: %s", ErrDelivery, resp.Status)
+}
+
+func (n *Notifier) payload(event events.Event) *Payload {
+	text := fmt.Sprintf("%s: peer %s: %s", event.Type, event.PublicKey, event.Message)
+	if n.node != "" {
+		text = fmt.Sprintf("[%s] %s", n.node, text)
+	}
+	return &Payload{
+		Event:   event,
+		Node:    n.node,
+		Warning: event.Type.Warning(),
+		Text:    text,
+	}
+}
+
+// Sign returns the signature header value for body, for receivers to
+// compare against with hmac.Equal.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// redactedURL names an endpoint in logs without the path, where services
+// such as Slack put the secret.
+func redactedURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "invalid URL"
+	}
+	return u.Scheme + "://" + u.Host
+}
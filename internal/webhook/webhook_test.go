@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package webhook_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/events"
+	"github.com/kubewg-net/container/internal/webhook"
+)
+
+func TestNotifier(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	attempts := 0
+	received := make(chan webhook.Payload, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if got := r.Header.Get(webhook.SignatureHeader); !hmac.Equal([]byte(got), []byte(webhook.Sign([]byte("secret"), body))) {
+			t.Errorf("expected a valid signature, got %q", got)
+		}
+
+		mu.Lock()
+		attempts++
+		first := attempts == 1
+		mu.Unlock()
+		if first {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var payload webhook.Payload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("expected a JSON payload, got %v", err)
+		}
+		received <- payload
+	}))
+	defer server.Close()
+
+	notifier, err := webhook.New(&config.Webhooks{
+		URLs:    []string{server.URL},
+		Secret:  "secret",
+		Events:  []string{string(events.HandshakeFailed)},
+		Timeout: config.Duration(5 * time.Second),
+		Retries: 2,
+		Backoff: config.Duration(time.Second),
+	}, &config.CryptoPolicy{}, "node-a")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go notifier.Start(ctx)
+
+	// Filtered out
+	notifier.Publish(events.Event{Type: events.PeerAdded, PublicKey: "key-a", Time: time.Now()})
+	notifier.Publish(events.Event{Type: events.HandshakeFailed, PublicKey: "key-b", Message: "no handshake", Time: time.Now()})
+
+	select {
+	case payload := <-received:
+		if payload.Type != events.HandshakeFailed || payload.PublicKey != "key-b" || payload.Node != "node-a" || !payload.Warning {
+			t.Errorf("expected the handshake failure of key-b on node-a, got %+v", payload)
+		}
+		if payload.Text != "[node-a] HandshakeFailed: peer key-b: no handshake" {
+			t.Errorf("expected a text line, got %q", payload.Text)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected the event to be delivered after a retry")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 2 {
+		t.Errorf("expected 2 attempts, got %d", attempts)
+	}
+}
+
+func TestUnknownEvent(t *testing.T) {
+	t.Parallel()
+
+	_, err := webhook.New(&config.Webhooks{URLs: []string{"https://example.com"}, Events: []string{"PeerJoined"}}, &config.CryptoPolicy{}, "")
+	if !errors.Is(err, webhook.ErrUnknownEvent) {
+		t.Errorf("expected ErrUnknownEvent, got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package wgquick
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// PrivateKeyPlaceholder stands in for a private key that isn't known to
+// whoever renders the file.
+const PrivateKeyPlaceholder = "<client private key>"
+
+// Render writes the file back out in wg-quick format.
+func (f *File) Render() []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString("[Interface]\n")
+	if f.Interface != nil {
+		privateKey := f.Interface.PrivateKey
+		if privateKey == "" {
+			privateKey = PrivateKeyPlaceholder
+		}
+		fmt.Fprintf(&buf, "PrivateKey = %s\n", privateKey)
+		if len(f.Interface.Addresses) != 0 {
+			fmt.Fprintf(&buf, "Address = %s\n", strings.Join(f.Interface.Addresses, ", "))
+		}
+		if f.Interface.ListenPort != 0 {
+			fmt.Fprintf(&buf, "ListenPort = %d\n", f.Interface.ListenPort)
+		}
+		if len(f.Interface.DNS) != 0 {
+			fmt.Fprintf(&buf, "DNS = %s\n", strings.Join(f.Interface.DNS, ", "))
+		}
+		if f.Interface.MTU != 0 {
+			fmt.Fprintf(&buf, "MTU = %d\n", f.Interface.MTU)
+		}
+	}
+
+	for _, peer := range f.Peers {
+		buf.WriteString("\n[Peer]\n")
+		fmt.Fprintf(&buf, "PublicKey = %s\n", peer.PublicKey)
+		if peer.PresharedKey != "" {
+			fmt.Fprintf(&buf, "PresharedKey = %s\n", peer.PresharedKey)
+		}
+		if len(peer.AllowedIPs) != 0 {
+			fmt.Fprintf(&buf, "AllowedIPs = %s\n", strings.Join(peer.AllowedIPs, ", "))
+		}
+		if peer.Endpoint != "" {
+			fmt.Fprintf(&buf, "Endpoint = %s\n", peer.Endpoint)
+		}
+		if peer.PersistentKeepalive != 0 {
+			fmt.Fprintf(&buf, "PersistentKeepalive = %d\n", peer.PersistentKeepalive)
+		}
+	}
+
+	return buf.Bytes()
+}
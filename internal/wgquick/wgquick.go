@@ -0,0 +1,219 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package wgquick reads the [Interface]/[Peer] configuration files used by
+// wg-quick so existing WireGuard setups can be migrated.
+package wgquick
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNoInterface    = errors.New("missing [Interface] section")
+	ErrUnknownSection = errors.New("unknown section")
+	ErrOutsideSection = errors.New("setting outside of a section")
+	ErrInvalidLine    = errors.New("expected key = value")
+)
+
+type Interface struct {
+	PrivateKey string
+	ListenPort uint16
+	Addresses  []string
+	DNS        []string
+	MTU        int
+}
+
+type Peer struct {
+	PublicKey           string
+	PresharedKey        string
+	Endpoint            string
+	AllowedIPs          []string
+	PersistentKeepalive uint32
+}
+
+type File struct {
+	Interface *Interface
+	Peers     []Peer
+}
+
+// Load parses the wg-quick config file at path.
+func Load(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	file, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// Parse reads a wg-quick config. Settings that only make sense to wg-quick
+// itself (Table, PreUp, ...) are skipped with a warning.
+//
+//nolint:golint,gocyclo
+func Parse(r io.Reader) (*File, error) {
+	var file File
+	var peer *Peer
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if idx := strings.IndexByte(line, '#'); idx >= 0 {
+			line = line[:idx]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			switch section := strings.ToLower(strings.TrimSpace(line[1 : len(line)-1])); section {
+			case "interface":
+				file.Interface = &Interface{}
+				peer = nil
+			case "peer":
+				file.Peers = append(file.Peers, Peer{})
+				peer = &file.Peers[len(file.Peers)-1]
+			default:
+				return nil, fmt.Errorf("line %d: %w %q", lineNum, ErrUnknownSection, section)
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: %w", lineNum, ErrInvalidLine)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		var err error
+		switch {
+		case peer != nil:
+			err = parsePeerSetting(peer, key, value)
+		case file.Interface != nil:
+			err = parseInterfaceSetting(file.Interface, key, value)
+		default:
+			err = ErrOutsideSection
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if file.Interface == nil {
+		return nil, ErrNoInterface
+	}
+
+	return &file, nil
+}
+
+func parseInterfaceSetting(iface *Interface, key, value string) error {
+	switch key {
+	case "privatekey":
+		iface.PrivateKey = value
+	case "listenport":
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid ListenPort: %w", err)
+		}
+		iface.ListenPort = uint16(port)
+	case "address":
+		iface.Addresses = append(iface.Addresses, splitList(value)...)
+	case "dns":
+		iface.DNS = append(iface.DNS, splitList(value)...)
+	case "mtu":
+		mtu, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid MTU: %w", err)
+		}
+		iface.MTU = mtu
+	default:
+		slog.Warn("Ignoring unsupported wg-quick interface setting", "key", key)
+	}
+	return nil
+}
+
+func parsePeerSetting(peer *Peer, key, value string) error {
+	switch key {
+	case "publickey":
+		peer.PublicKey = value
+	case "presharedkey":
+		peer.PresharedKey = value
+	case "endpoint":
+		peer.Endpoint = value
+	case "allowedips":
+		peer.AllowedIPs = append(peer.AllowedIPs, splitList(value)...)
+	case "persistentkeepalive":
+		if value == "off" {
+			return nil
+		}
+		keepalive, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid PersistentKeepalive: %w", err)
+		}
+		peer.PersistentKeepalive = uint32(keepalive)
+	default:
+		slog.Warn("Ignoring unsupported wg-quick peer setting", "key", key)
+	}
+	return nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// SplitDNS separates DNS entries the way wg-quick does: addresses are name
+// servers, anything else is a search domain.
+func SplitDNS(entries []string) ([]netip.Addr, []string) {
+	var servers []netip.Addr
+	var domains []string
+	for _, entry := range entries {
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			servers = append(servers, addr)
+		} else {
+			domains = append(domains, entry)
+		}
+	}
+	return servers, domains
+}
@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package wgquick_test
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/wgquick"
+)
+
+const sample = `
+[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+Address = 10.0.0.1/24, fd00::1/64
+ListenPort = 51821
+DNS = 1.1.1.1
+
+[Peer] # laptop
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+AllowedIPs = 10.0.0.2/32
+AllowedIPs = fd00::2/128
+Endpoint = laptop.example.com:51820
+PersistentKeepalive = 25
+`
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	file, err := wgquick.Parse(strings.NewReader(sample))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if file.Interface.ListenPort != 51821 {
+		t.Errorf("expected listen port 51821, got %d", file.Interface.ListenPort)
+	}
+	if len(file.Interface.Addresses) != 2 {
+		t.Errorf("expected 2 addresses, got %v", file.Interface.Addresses)
+	}
+	if len(file.Peers) != 1 {
+		t.Fatalf("expected 1 peer, got %d", len(file.Peers))
+	}
+	peer := file.Peers[0]
+	if len(peer.AllowedIPs) != 2 {
+		t.Errorf("expected 2 allowed IPs, got %v", peer.AllowedIPs)
+	}
+	if peer.Endpoint != "laptop.example.com:51820" {
+		t.Errorf("unexpected endpoint %q", peer.Endpoint)
+	}
+	if peer.PersistentKeepalive != 25 {
+		t.Errorf("expected keepalive 25, got %d", peer.PersistentKeepalive)
+	}
+}
+
+func TestParseNoInterface(t *testing.T) {
+	t.Parallel()
+
+	_, err := wgquick.Parse(strings.NewReader("[Peer]\nPublicKey = abc\n"))
+	if !errors.Is(err, wgquick.ErrNoInterface) {
+		t.Errorf("expected ErrNoInterface, got %v", err)
+	}
+}
+
+func TestSplitDNS(t *testing.T) {
+	t.Parallel()
+
+	servers, domains := wgquick.SplitDNS([]string{"10.96.0.10", "cluster.local", "fd00::a", "svc.cluster.local"})
+	expected := []netip.Addr{netip.MustParseAddr("10.96.0.10"), netip.MustParseAddr("fd00::a")}
+	if !slices.Equal(servers, expected) {
+		t.Errorf("expected servers %v, got %v", expected, servers)
+	}
+	if !slices.Equal(domains, []string{"cluster.local", "svc.cluster.local"}) {
+		t.Errorf("expected the names as search domains, got %v", domains)
+	}
+}
@@ -0,0 +1,112 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package wireguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/kubewg-net/container/internal/keystore"
+	"github.com/kubewg-net/container/internal/secmem"
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// linkAlias marks the links kubewg created
+const linkAlias = "kubewg"
+
+var (
+	ErrNotWireGuard  = errors.New("interface exists but is not a WireGuard interface")
+	ErrForeignDevice = errors.New("WireGuard interface exists but was not created by kubewg, delete it or set adopt to manage it")
+)
+
+// claim decides whether Up may configure the existing link. Links carrying
+// kubewg's alias are reused, e.g. after a crash left one behind. Any other
+// WireGuard link belongs to the host or another tool and is only taken
+// over in adopt mode, a link of another type never, short of the TUN
+// interface of the userspace device Up started.
+func (d *Device) claim(ctx context.Context, link netlink.Link) error {
+	// The TUN interface of the running userspace device is its own
+	if d.userspace != nil && link.Type() == "tuntap" {
+		return nil
+	}
+	adopt, err := Claimable(link.Type(), link.Attrs().Alias, d.config.Adopt)
+	if err != nil {
+		return fmt.Errorf("%w: %s, a %s link", err, d.name, link.Type())
+	}
+	if !adopt || d.adopted {
+		return nil
+	}
+
+	client, err := wgctrl.New()
+	if err != nil {
+		return fmt.Errorf("failed to open wgctrl client: %w", err)
+	}
+	defer client.Close()
+	device, err := client.Device(d.name)
+	if err != nil {
+		return fmt.Errorf("failed to get device %s: %w", d.name, err)
+	}
+	defer secmem.WipeDevice(device)
+	if err := d.keepPrivateKey(ctx, device.PrivateKey); err != nil {
+		return err
+	}
+
+	d.adopted = true
+	slog.Warn("Adopting existing WireGuard interface, its peers are replaced by the configured ones",
+		"name", d.name, "listen_port", device.ListenPort, "peers", len(device.Peers))
+	return nil
+}
+
+// Claimable decides whether an existing link of linkType named by alias may
+// be configured, and whether that takes adopting a foreign WireGuard link.
+func Claimable(linkType, alias string, adopt bool) (bool, error) {
+	switch {
+	case linkType != "wireguard":
+		return false, ErrNotWireGuard
+	case alias == linkAlias:
+		return false, nil
+	case !adopt:
+		return false, ErrForeignDevice
+	}
+	return true, nil
+}
+
+// keepPrivateKey stores the key of an adopted device unless a key is
+// configured or stored already, so the peers of the device keep reaching
+// it under the same public key.
+func (d *Device) keepPrivateKey(ctx context.Context, key wgtypes.Key) error {
+	if d.config.PrivateKey != "" || key == (wgtypes.Key{}) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, keyStoreTimeout)
+	defer cancel()
+	err := d.keys.Create(ctx, d.keyName, key)
+	if errors.Is(err, keystore.ErrExists) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to store the private key of %s: %w", d.name, err)
+	}
+	slog.Info("Keeping the private key of the adopted interface", "name", d.name, "public_key", key.PublicKey().String())
+	return nil
+}
@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+package wireguard_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/wireguard"
+)
+
+func TestClaimable(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		linkType string
+		alias    string
+		adopt    bool
+		expected bool
+		err      error
+	}{
+		{"own link is reused", "wireguard", "kubewg", false, false, nil},
+		{"own link is reused in adopt mode", "wireguard", "kubewg", true, false, nil},
+		{"foreign link is refused", "wireguard", "", false, false, wireguard.ErrForeignDevice},
+		{"foreign link is adopted", "wireguard", "wg-quick", true, true, nil},
+		{"other link type is refused", "bridge", "kubewg", true, false, wireguard.ErrNotWireGuard},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			adopt, err := wireguard.Claimable(test.linkType, test.alias, test.adopt)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+			if adopt != test.expected {
+				t.Errorf("expected adopt to be %t, got %t", test.expected, adopt)
+			}
+		})
+	}
+}
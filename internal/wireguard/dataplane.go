@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package wireguard
+
+import (
+	"context"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Dataplane is a WireGuard interface the reconciler programs. Device is the
+// kernel implementation, embedders can provide their own, e.g. userspace.
+type Dataplane interface {
+	// Name returns the interface name.
+	Name() string
+	// Up creates and configures the interface. It must be idempotent.
+	Up(ctx context.Context) error
+	// Down removes the interface.
+	Down() error
+	// Inspect describes every way the live interface differs from what Up
+	// applied.
+	Inspect() ([]string, error)
+	// Peers returns the peers currently programmed on the interface.
+	Peers() ([]wgtypes.Peer, error)
+	// ConfigurePeers brings the interface's peers in line with the given set.
+	ConfigurePeers(peers []wgtypes.PeerConfig) error
+	// PublicKey returns the interface's public key.
+	PublicKey() wgtypes.Key
+	// MTU returns the MTU applied to the interface.
+	MTU() int
+	// RotateKeyIfDue replaces the private key when the rotation policy
+	// requires it and reports whether it did.
+	RotateKeyIfDue(ctx context.Context, now time.Time) (bool, error)
+}
+
+var _ Dataplane = (*Device)(nil)
@@ -0,0 +1,178 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package wireguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/firewall"
+	"github.com/kubewg-net/container/internal/keystore"
+	"github.com/kubewg-net/container/internal/secmem"
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const keyStoreTimeout = 10 * time.Second
+
+var (
+	ErrNoDefaultRoute = errors.New("no default route found")
+	ErrDeviceDown     = errors.New("device is not up")
+)
+
+type Device struct {
+	name       string
+	config     *config.WireGuard
+	link       netlink.Link
+	client     *wgctrl.Client
+	privateKey *secmem.Key
+	mtu        int
+	routes     map[netip.Prefix]struct{}
+	rules      []*netlink.Rule
+	firewall   *firewall.Firewall
+	// keys holds the private key under keyName
+	keys       keystore.KeyStore
+	keyName    string
+	keyCreated time.Time
+	// adopted is set when the interface was taken over rather than
+	// created, Down leaves it in place
+	adopted bool
+	// userspace runs the interface when the kernel doesn't
+	userspace *userspace
+}
+
+func NewDevice(wg *config.WireGuard) *Device {
+	name := wg.InterfaceName
+	if name == "" {
+		name = config.DefaultInterfaceName
+	}
+	return &Device{
+		name:     name,
+		config:   wg,
+		keys:     keystore.NewFile(""),
+		keyName:  wg.PrivateKeyFile,
+		firewall: firewall.New(nil),
+	}
+}
+
+// UseKeyStore keeps the private key in store under name instead of the
+// private key file. It must be called before Up.
+func (d *Device) UseKeyStore(store keystore.KeyStore, name string) {
+	d.keys = store
+	d.keyName = name
+}
+
+// ConfigurePeers brings the device's peers in line with the given set and
+// routes their allowed IPs through the interface. Only peers that are new,
+// changed or gone are touched, so established sessions survive a resync.
+func (d *Device) ConfigurePeers(peers []wgtypes.PeerConfig) error {
+	if d.client == nil {
+		return ErrDeviceDown
+	}
+
+	device, err := d.client.Device(d.name)
+	if err != nil {
+		return fmt.Errorf("failed to get device %s: %w", d.name, err)
+	}
+	changes := PeerChanges(device.Peers, peers)
+	secmem.WipeDevice(device)
+
+	if len(changes) != 0 {
+		err = d.client.ConfigureDevice(d.name, wgtypes.Config{Peers: changes})
+		if err != nil {
+			return fmt.Errorf("failed to configure peers on %s: %w", d.name, err)
+		}
+	}
+	return d.syncRoutes(peers)
+}
+
+// Peers returns the peers currently programmed on the device.
+func (d *Device) Peers() ([]wgtypes.Peer, error) {
+	if d.client == nil {
+		return nil, ErrDeviceDown
+	}
+
+	device, err := d.client.Device(d.name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device %s: %w", d.name, err)
+	}
+	secmem.Wipe(&device.PrivateKey)
+	return device.Peers, nil
+}
+
+// PublicKey returns the public key of the device.
+func (d *Device) PublicKey() wgtypes.Key {
+	return d.privateKey.PublicKey()
+}
+
+// MTU returns the MTU applied to the interface, including a detected one.
+func (d *Device) MTU() int {
+	return d.mtu
+}
+
+// RotateKeyIfDue replaces the private key with a fresh one once it is older
+// than the effective key rotation policy and reports whether it did. Keys
+// set inline in the config are never rotated.
+func (d *Device) RotateKeyIfDue(ctx context.Context, now time.Time) (bool, error) {
+	rotation := d.config.EffectiveKeyRotation().Value
+	if rotation == 0 || d.config.PrivateKey != "" {
+		return false, nil
+	}
+
+	if now.Sub(d.keyCreated) < time.Duration(rotation)*time.Second {
+		return false, nil
+	}
+	storeCtx, cancel := context.WithTimeout(ctx, keyStoreTimeout)
+	defer cancel()
+	if _, err := keystore.Rotate(storeCtx, d.keys, d.keyName); err != nil {
+		return false, fmt.Errorf("failed to rotate private key %s: %w", d.keyName, err)
+	}
+	return true, d.Up(ctx)
+}
+
+// loadPrivateKey moves the private key into locked memory, wiping the
+// copy it was read into.
+func (d *Device) loadPrivateKey(ctx context.Context) (*secmem.Key, error) {
+	if d.config.PrivateKey != "" {
+		key, err := wgtypes.ParseKey(d.config.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key: %w", err)
+		}
+		return secmem.NewKey(&key)
+	}
+	ctx, cancel := context.WithTimeout(ctx, keyStoreTimeout)
+	defer cancel()
+	entry, err := keystore.LoadOrGenerate(ctx, d.keys, d.keyName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load private key %s: %w", d.keyName, err)
+	}
+	d.keyCreated = entry.Created
+	return secmem.NewKey(&entry.Key)
+}
+
+// Name returns the interface name.
+func (d *Device) Name() string {
+	return d.name
+}
@@ -0,0 +1,247 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package wireguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+
+	"github.com/kubewg-net/container/internal/secmem"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Up creates the WireGuard interface if it doesn't exist yet, applies the
+// MTU, configures the private key and listen port and brings the link up.
+// It is idempotent, so it is also used to repair a drifted interface.
+func (d *Device) Up(ctx context.Context) error {
+	link, err := netlink.LinkByName(d.name)
+	var notFound netlink.LinkNotFoundError
+	if errors.As(err, &notFound) {
+		link = nil
+	} else if err != nil {
+		return fmt.Errorf("failed to get link %s: %w", d.name, err)
+	} else if err := d.claim(ctx, link); err != nil {
+		return err
+	}
+
+	privateKey, err := d.loadPrivateKey(ctx)
+	if err != nil {
+		return err
+	}
+	// The key replaces the one held so far only once it is configured
+	defer func() {
+		if d.privateKey != privateKey {
+			privateKey.Destroy()
+		}
+	}()
+
+	mtu := resolveMTU(d.config.EffectiveMTU().Value)
+
+	if link == nil {
+		link, err = d.createLink(mtu)
+		if err != nil {
+			return err
+		}
+		// The alias tells a later run the link is its own to reuse
+		if err := netlink.LinkSetAlias(link, linkAlias); err != nil {
+			return fmt.Errorf("failed to mark link %s: %w", d.name, err)
+		}
+		d.adopted = false
+	} else if link.Attrs().MTU != mtu {
+		if err := netlink.LinkSetMTU(link, mtu); err != nil {
+			return fmt.Errorf("failed to set MTU on %s: %w", d.name, err)
+		}
+	}
+
+	for _, cidr := range d.config.Addresses {
+		addr, err := netlink.ParseAddr(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid address %q: %w", cidr, err)
+		}
+		// There are no other hosts on the link to detect duplicates with,
+		// and IPv6 addresses would stay tentative until DAD is done
+		if addr.IP.To4() == nil {
+			addr.Flags |= unix.IFA_F_NODAD
+		}
+		if err := netlink.AddrReplace(link, addr); err != nil {
+			return fmt.Errorf("failed to add address %s to %s: %w", cidr, d.name, err)
+		}
+	}
+
+	if d.client == nil {
+		d.client, err = wgctrl.New()
+		if err != nil {
+			return fmt.Errorf("failed to open wgctrl client: %w", err)
+		}
+	}
+
+	listenPort := int(d.config.ListenPort)
+	fwMark := int(d.config.FwMark)
+	err = privateKey.Use(func(key *wgtypes.Key) error {
+		return d.client.ConfigureDevice(d.name, wgtypes.Config{
+			PrivateKey:   key,
+			ListenPort:   &listenPort,
+			FirewallMark: &fwMark,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to configure %s: %w", d.name, err)
+	}
+
+	if err := netlink.LinkSetUp(link); err != nil {
+		return fmt.Errorf("failed to bring up %s: %w", d.name, err)
+	}
+
+	if err := d.syncRules(); err != nil {
+		return err
+	}
+	if err := d.syncFirewall(); err != nil {
+		return err
+	}
+
+	d.link = link
+	d.privateKey.Destroy()
+	d.privateKey = privateKey
+	d.mtu = mtu
+	return nil
+}
+
+// Inspect compares the live interface against the settings applied by Up
+// and describes every difference found.
+func (d *Device) Inspect() ([]string, error) {
+	if d.client == nil {
+		return nil, ErrDeviceDown
+	}
+
+	link, err := netlink.LinkByName(d.name)
+	if err != nil {
+		var notFound netlink.LinkNotFoundError
+		if errors.As(err, &notFound) {
+			return []string{"interface is missing"}, nil
+		}
+		return nil, fmt.Errorf("failed to get link %s: %w", d.name, err)
+	}
+
+	var drift []string
+	if link.Attrs().Flags&net.FlagUp == 0 {
+		drift = append(drift, "interface is down")
+	}
+	if link.Attrs().MTU != d.mtu {
+		drift = append(drift, fmt.Sprintf("MTU is %d, expected %d", link.Attrs().MTU, d.mtu))
+	}
+
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses on %s: %w", d.name, err)
+	}
+	present := make(map[string]struct{}, len(addrs))
+	for _, addr := range addrs {
+		present[addr.IPNet.String()] = struct{}{}
+	}
+	for _, cidr := range d.config.Addresses {
+		addr, err := netlink.ParseAddr(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %w", cidr, err)
+		}
+		if _, ok := present[addr.IPNet.String()]; !ok {
+			drift = append(drift, fmt.Sprintf("address %s is missing", cidr))
+		}
+	}
+
+	device, err := d.client.Device(d.name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device %s: %w", d.name, err)
+	}
+	defer secmem.WipeDevice(device)
+	if device.ListenPort != int(d.config.ListenPort) {
+		drift = append(drift, fmt.Sprintf("listen port is %d, expected %d", device.ListenPort, d.config.ListenPort))
+	}
+	if !d.privateKey.Equal(&device.PrivateKey) {
+		drift = append(drift, "private key was replaced")
+	}
+	if device.FirewallMark != int(d.config.FwMark) {
+		drift = append(drift, fmt.Sprintf("fwmark is %#x, expected %#x", device.FirewallMark, d.config.FwMark))
+	}
+
+	missing, err := d.missingRules()
+	if err != nil {
+		return nil, err
+	}
+	drift = append(drift, missing...)
+	missing, err = d.firewall.Missing(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	drift = append(drift, missing...)
+
+	return drift, nil
+}
+
+// Down tears the interface down in the reverse order Up built it: the
+// peers' routes first, then the policy rules, the firewall rules and the
+// interface itself.
+func (d *Device) Down() error {
+	if d.client != nil {
+		if err := d.client.Close(); err != nil {
+			slog.Warn("Failed to close wgctrl client", "error", err.Error())
+		}
+		d.client = nil
+	}
+	d.privateKey.Destroy()
+	d.privateKey = nil
+
+	if d.link == nil {
+		return nil
+	}
+
+	// Deleting the link would take the routes along, removing them first
+	// keeps the node from routing into an interface that is going away
+	d.removeRoutes()
+	d.removeRules()
+	d.firewall.Flush(context.Background())
+
+	if d.userspace != nil {
+		// The TUN interface goes away with the device
+		d.userspace.Close()
+		d.userspace = nil
+		d.link = nil
+		d.routes = nil
+		return nil
+	}
+	if d.adopted || d.config.Teardown.KeepInterface {
+		slog.Info("Leaving WireGuard interface in place", "name", d.name, "adopted", d.adopted)
+		d.link = nil
+		d.routes = nil
+		return nil
+	}
+	if err := netlink.LinkDel(d.link); err != nil {
+		return fmt.Errorf("failed to delete link %s: %w", d.name, err)
+	}
+
+	d.link = nil
+	d.routes = nil
+	return nil
+}
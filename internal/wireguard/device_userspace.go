@@ -0,0 +1,205 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+//go:build !linux
+
+package wireguard
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os/exec"
+	"strings"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/secmem"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+var ErrNoKernelDevice = errors.New("WireGuard only runs in userspace outside Linux, set userspace to auto or always")
+
+// Up starts the interface in wireguard-go, the only implementation outside
+// Linux, assigns its addresses and configures the private key and listen
+// port. A running interface is only reconfigured. There are neither policy
+// rules nor firewall rules to install here.
+func (d *Device) Up(ctx context.Context) error {
+	if d.config.Userspace == config.UserspaceOff {
+		return ErrNoKernelDevice
+	}
+
+	privateKey, err := d.loadPrivateKey(ctx)
+	if err != nil {
+		return err
+	}
+	// The key replaces the one held so far only once it is configured
+	defer func() {
+		if d.privateKey != privateKey {
+			privateKey.Destroy()
+		}
+	}()
+
+	mtu := resolveMTU(d.config.EffectiveMTU().Value)
+
+	if d.userspace == nil {
+		u, err := startUserspace(tunName(d.name), mtu)
+		if err != nil {
+			return err
+		}
+		d.name = u.name
+		if err := d.configureInterface(); err != nil {
+			u.Close()
+			return err
+		}
+		d.userspace = u
+	}
+
+	if d.client == nil {
+		d.client, err = wgctrl.New()
+		if err != nil {
+			return fmt.Errorf("failed to open wgctrl client: %w", err)
+		}
+	}
+
+	listenPort := int(d.config.ListenPort)
+	err = privateKey.Use(func(key *wgtypes.Key) error {
+		return d.client.ConfigureDevice(d.name, wgtypes.Config{
+			PrivateKey: key,
+			ListenPort: &listenPort,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to configure %s: %w", d.name, err)
+	}
+
+	d.privateKey.Destroy()
+	d.privateKey = privateKey
+	d.mtu = mtu
+	return nil
+}
+
+// configureInterface assigns the addresses and brings the interface up.
+func (d *Device) configureInterface() error {
+	for _, cidr := range d.config.Addresses {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid address %q: %w", cidr, err)
+		}
+		if err := d.addAddress(prefix); err != nil {
+			return fmt.Errorf("failed to add address %s to %s: %w", cidr, d.name, err)
+		}
+	}
+	if err := d.setUp(); err != nil {
+		return fmt.Errorf("failed to bring up %s: %w", d.name, err)
+	}
+	return nil
+}
+
+// Inspect compares the live interface against the settings applied by Up
+// and describes every difference found.
+func (d *Device) Inspect() ([]string, error) {
+	if d.client == nil {
+		return nil, ErrDeviceDown
+	}
+
+	iface, err := net.InterfaceByName(d.name)
+	if err != nil {
+		return []string{"interface is missing"}, nil
+	}
+
+	var drift []string
+	if iface.Flags&net.FlagUp == 0 {
+		drift = append(drift, "interface is down")
+	}
+	if iface.MTU != d.mtu {
+		drift = append(drift, fmt.Sprintf("MTU is %d, expected %d", iface.MTU, d.mtu))
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses on %s: %w", d.name, err)
+	}
+	present := make(map[netip.Addr]struct{}, len(addrs))
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			if ip, ok := netip.AddrFromSlice(ipNet.IP); ok {
+				present[ip.Unmap()] = struct{}{}
+			}
+		}
+	}
+	for _, cidr := range d.config.Addresses {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %w", cidr, err)
+		}
+		if _, ok := present[prefix.Addr()]; !ok {
+			drift = append(drift, fmt.Sprintf("address %s is missing", cidr))
+		}
+	}
+
+	device, err := d.client.Device(d.name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device %s: %w", d.name, err)
+	}
+	defer secmem.WipeDevice(device)
+	if device.ListenPort != int(d.config.ListenPort) {
+		drift = append(drift, fmt.Sprintf("listen port is %d, expected %d", device.ListenPort, d.config.ListenPort))
+	}
+	if !d.privateKey.Equal(&device.PrivateKey) {
+		drift = append(drift, "private key was replaced")
+	}
+
+	return drift, nil
+}
+
+// Down removes the peers' routes and stops wireguard-go, which takes the
+// interface and its addresses along.
+func (d *Device) Down() error {
+	if d.client != nil {
+		if err := d.client.Close(); err != nil {
+			slog.Warn("Failed to close wgctrl client", "error", err.Error())
+		}
+		d.client = nil
+	}
+	d.privateKey.Destroy()
+	d.privateKey = nil
+
+	if d.userspace == nil {
+		return nil
+	}
+	d.removeRoutes()
+	d.userspace.Close()
+	d.userspace = nil
+	return nil
+}
+
+// run runs one of the system's network configuration tools, returning its
+// output with the error.
+func run(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package wireguard
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/kubewg-net/container/internal/firewall"
+)
+
+// exitNodeRules masquerades the clients of an exit node and lets their
+// traffic be forwarded.
+func (d *Device) exitNodeRules(families []int) ([]firewall.Rule, error) {
+	exit := &d.config.ExitNode
+	if !exit.Enabled {
+		return nil, nil
+	}
+
+	var rules []firewall.Rule
+	for _, address := range d.config.Addresses {
+		prefix, err := netip.ParsePrefix(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %w", address, err)
+		}
+
+		// Clients' traffic leaves with this node's address, replies find
+		// their way back through conntrack
+		args := []string{"-s", prefix.Masked().String(), "!", "-o", d.name, "-j", "MASQUERADE"}
+		if exit.EgressInterface != "" {
+			args = []string{"-s", prefix.Masked().String(), "-o", exit.EgressInterface, "-j", "MASQUERADE"}
+		}
+		rules = append(rules, firewall.Rule{Family: familyOfPrefix(prefix), Table: "nat", Chain: "POSTROUTING", Args: args})
+	}
+
+	// Hosts with a DROP forward policy, e.g. with Docker, would otherwise
+	// drop the clients' traffic
+	for _, family := range families {
+		rules = append(rules,
+			firewall.Rule{Family: family, Table: "filter", Chain: "FORWARD", Args: []string{"-i", d.name, "-j", "ACCEPT"}},
+			firewall.Rule{Family: family, Table: "filter", Chain: "FORWARD", Args: []string{
+				"-o", d.name, "-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT",
+			}},
+		)
+	}
+	return rules, nil
+}
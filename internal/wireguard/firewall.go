@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package wireguard
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	"github.com/kubewg-net/container/internal/firewall"
+)
+
+// FirewallRules builds the iptables rules the config needs, none unless
+// this is an exit node or MSS clamping is on.
+func (d *Device) FirewallRules() ([]firewall.Rule, error) {
+	families, err := d.families()
+	if err != nil {
+		return nil, err
+	}
+	rules, err := d.exitNodeRules(families)
+	if err != nil {
+		return nil, err
+	}
+	return append(rules, d.clampMSSRules(families)...), nil
+}
+
+// clampMSSRules rewrite the MSS of TCP handshakes crossing the interface
+// to fit the path MTU, so connections don't stall on full-size segments
+// when a link on the way has a smaller MTU than expected and ICMP is
+// filtered.
+func (d *Device) clampMSSRules(families []int) []firewall.Rule {
+	if !d.config.ClampMSS {
+		return nil
+	}
+	var rules []firewall.Rule
+	for _, family := range families {
+		for _, direction := range []string{"-o", "-i"} {
+			rules = append(rules, firewall.Rule{Family: family, Table: "mangle", Chain: "FORWARD", Args: []string{
+				direction, d.name, "-p", "tcp", "--tcp-flags", "SYN,RST", "SYN", "-j", "TCPMSS", "--clamp-mss-to-pmtu",
+			}})
+		}
+	}
+	return rules
+}
+
+// families lists the address families of the interface addresses, IPv4
+// first.
+func (d *Device) families() ([]int, error) {
+	seen := map[int]bool{}
+	for _, address := range d.config.Addresses {
+		prefix, err := netip.ParsePrefix(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %w", address, err)
+		}
+		seen[familyOfPrefix(prefix)] = true
+	}
+	var families []int
+	for _, family := range []int{firewall.IPv4, firewall.IPv6} {
+		if seen[family] {
+			families = append(families, family)
+		}
+	}
+	return families, nil
+}
+
+func familyOfPrefix(prefix netip.Prefix) int {
+	if prefix.Addr().Is4() {
+		return firewall.IPv4
+	}
+	return firewall.IPv6
+}
+
+func (d *Device) syncFirewall() error {
+	rules, err := d.FirewallRules()
+	if err != nil {
+		return err
+	}
+	return d.firewall.Sync(context.Background(), rules)
+}
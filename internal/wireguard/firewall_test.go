@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+package wireguard_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/firewall"
+	"github.com/kubewg-net/container/internal/wireguard"
+)
+
+func TestClampMSSRules(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		clampMSS  bool
+		addresses []string
+		families  []int
+	}{
+		{"off", false, []string{"10.0.0.1/24"}, nil},
+		{"IPv4", true, []string{"10.0.0.1/24"}, []int{firewall.IPv4, firewall.IPv4}},
+		{"dual-stack", true, []string{"fd00:77::1/64", "10.0.0.1/24"}, []int{firewall.IPv4, firewall.IPv4, firewall.IPv6, firewall.IPv6}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			device := wireguard.NewDevice(&config.WireGuard{InterfaceName: "kubewg-test", Addresses: test.addresses, ClampMSS: test.clampMSS})
+			rules, err := device.FirewallRules()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			families := make([]int, 0, len(rules))
+			for _, rule := range rules {
+				families = append(families, rule.Family)
+				if rule.Table != "mangle" || rule.Chain != "FORWARD" || !slices.Contains(rule.Args, "kubewg-test") ||
+					!slices.Contains(rule.Args, "--clamp-mss-to-pmtu") {
+					t.Errorf("expected an MSS clamping rule for the interface, got %s", rule.String())
+				}
+			}
+			if !slices.Equal(families, test.families) {
+				t.Errorf("expected rules for the families %v, got %v", test.families, families)
+			}
+		})
+	}
+}
+
+func TestFirewallRulesInvalidAddress(t *testing.T) {
+	t.Parallel()
+
+	device := wireguard.NewDevice(&config.WireGuard{Addresses: []string{"10.0.0.300/24"}, ClampMSS: true})
+	if _, err := device.FirewallRules(); err == nil {
+		t.Error("expected an invalid address to be an error")
+	}
+}
@@ -0,0 +1,55 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package wireguard
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+//nolint:golint,gochecknoglobals
+var forwardingSysctls = []string{
+	"/proc/sys/net/ipv4/ip_forward",
+	"/proc/sys/net/ipv6/conf/all/forwarding",
+}
+
+// EnableForwarding turns on IP forwarding so traffic relayed between peers
+// can leave through the interface it came in on. Sysctls that are already
+// on are left alone, so a read-only /proc/sys only fails if it has to.
+func EnableForwarding() error {
+	for _, path := range forwardingSysctls {
+		value, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			// No IPv6 on this host
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if string(bytes.TrimSpace(value)) == "1" {
+			continue
+		}
+		if err := os.WriteFile(path, []byte("1"), 0o644); err != nil {
+			return fmt.Errorf("failed to enable forwarding through %s: %w", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package wireguard
+
+import (
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/metrics"
+)
+
+type EndpointStatus struct {
+	PublicKey string    `json:"public_key"`
+	Endpoint  string    `json:"endpoint"`
+	Pending   string    `json:"pending,omitempty"`
+	Flaps     uint64    `json:"flaps"`
+	HeldDown  bool      `json:"held_down"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+type endpointState struct {
+	current   string
+	changedAt time.Time
+	observed  string
+	recent    []time.Time
+	flaps     uint64
+}
+
+// EndpointTracker dampens endpoint changes so a peer whose DNS answer
+// oscillates between addresses doesn't cause the device to be reprogrammed
+// on every resync. After a change is applied, further changes are held down
+// for the configured duration, and every flap past the threshold within the
+// flap window doubles the hold-down, up to the window itself. A zero
+// duration applies every change right away.
+type EndpointTracker struct {
+	config *config.HoldDown
+	mu     sync.Mutex
+	peers  map[string]*endpointState
+}
+
+func NewEndpointTracker(config *config.HoldDown) *EndpointTracker {
+	return &EndpointTracker{
+		config: config,
+		peers:  make(map[string]*endpointState),
+	}
+}
+
+// Observe records the latest resolved endpoint of a peer and returns the
+// endpoint that should be programmed on the device.
+func (t *EndpointTracker) Observe(publicKey, endpoint string, now time.Time) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.peers[publicKey]
+	if !ok {
+		t.peers[publicKey] = &endpointState{
+			current:   endpoint,
+			changedAt: now,
+			observed:  endpoint,
+		}
+		return endpoint
+	}
+
+	window := t.config.FlapWindow.Std()
+	recent := state.recent[:0]
+	for _, flap := range state.recent {
+		if now.Sub(flap) < window {
+			recent = append(recent, flap)
+		}
+	}
+	state.recent = recent
+
+	if endpoint != state.observed {
+		state.observed = endpoint
+		state.flaps++
+		state.recent = append(state.recent, now)
+		metrics.EndpointFlaps.WithLabelValues(publicKey).Inc()
+	}
+
+	if endpoint == state.current {
+		metrics.EndpointHeldDown.WithLabelValues(publicKey).Set(0)
+		return state.current
+	}
+
+	if now.Sub(state.changedAt) < t.holdDown(state) {
+		metrics.EndpointHeldDown.WithLabelValues(publicKey).Set(1)
+		return state.current
+	}
+
+	slog.Info("Peer endpoint changed", "public_key", publicKey, "old", state.current, "new", endpoint, "flaps", state.flaps)
+	state.current = endpoint
+	state.changedAt = now
+	metrics.EndpointHeldDown.WithLabelValues(publicKey).Set(0)
+	return endpoint
+}
+
+// Set applies endpoint right away, bypassing the hold-down. It is meant for
+// endpoints learned deliberately, like through hole punching, rather than
+// from a DNS answer that may flap.
+func (t *EndpointTracker) Set(publicKey, endpoint string, now time.Time) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.peers[publicKey]
+	if !ok {
+		state = &endpointState{}
+		t.peers[publicKey] = state
+	}
+	if state.current != endpoint {
+		slog.Info("Peer endpoint set", "public_key", publicKey, "old", state.current, "new", endpoint)
+		state.current = endpoint
+		state.changedAt = now
+	}
+	state.observed = endpoint
+	metrics.EndpointHeldDown.WithLabelValues(publicKey).Set(0)
+	return endpoint
+}
+
+// Current returns the endpoint last applied for a peer, if any.
+func (t *EndpointTracker) Current(publicKey string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if state, ok := t.peers[publicKey]; ok {
+		return state.current
+	}
+	return ""
+}
+
+// Forget drops the state of a peer that is no longer configured.
+func (t *EndpointTracker) Forget(publicKey string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.peers, publicKey)
+	metrics.EndpointFlaps.DeleteLabelValues(publicKey)
+	metrics.EndpointHeldDown.DeleteLabelValues(publicKey)
+}
+
+// Status returns the endpoint state of every tracked peer.
+func (t *EndpointTracker) Status() []EndpointStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status := make([]EndpointStatus, 0, len(t.peers))
+	for publicKey, state := range t.peers {
+		peerStatus := EndpointStatus{
+			PublicKey: publicKey,
+			Endpoint:  state.current,
+			Flaps:     state.flaps,
+			ChangedAt: state.changedAt,
+		}
+		if state.observed != state.current {
+			peerStatus.Pending = state.observed
+			peerStatus.HeldDown = true
+		}
+		status = append(status, peerStatus)
+	}
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].PublicKey < status[j].PublicKey
+	})
+	return status
+}
+
+func (t *EndpointTracker) holdDown(state *endpointState) time.Duration {
+	base := t.config.Duration.Std()
+	window := t.config.FlapWindow.Std()
+
+	holdDown := base
+	for i := int(t.config.FlapThreshold); i < len(state.recent) && holdDown < window; i++ {
+		holdDown *= 2
+	}
+
+	return min(holdDown, max(window, base))
+}
@@ -0,0 +1,107 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+package wireguard_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/wireguard"
+)
+
+func TestEndpointHoldDown(t *testing.T) {
+	t.Parallel()
+
+	type step struct {
+		at       time.Duration
+		endpoint string
+		expected string
+	}
+	tests := []struct {
+		name     string
+		holdDown config.HoldDown
+		steps    []step
+	}{
+		{
+			name:     "first endpoint is applied",
+			holdDown: config.HoldDown{Duration: config.Duration(30 * time.Second), FlapThreshold: 1, FlapWindow: config.Duration(5 * time.Minute)},
+			steps:    []step{{0, "a", "a"}},
+		},
+		{
+			name:     "change is held down",
+			holdDown: config.HoldDown{Duration: config.Duration(30 * time.Second), FlapThreshold: 1, FlapWindow: config.Duration(5 * time.Minute)},
+			steps:    []step{{0, "a", "a"}, {10 * time.Second, "b", "a"}, {30 * time.Second, "b", "b"}},
+		},
+		{
+			name:     "flaps double the hold-down",
+			holdDown: config.HoldDown{Duration: config.Duration(30 * time.Second), FlapThreshold: 1, FlapWindow: config.Duration(5 * time.Minute)},
+			steps: []step{
+				{0, "a", "a"},
+				{31 * time.Second, "b", "b"},
+				{40 * time.Second, "a", "b"},
+				{70 * time.Second, "a", "b"},
+				{91 * time.Second, "a", "a"},
+			},
+		},
+		{
+			name:     "hold-down is capped at the window",
+			holdDown: config.HoldDown{Duration: config.Duration(30 * time.Second), FlapWindow: config.Duration(100 * time.Second)},
+			steps: []step{
+				{0, "a", "a"},
+				{10 * time.Second, "b", "a"},
+				{20 * time.Second, "a", "a"},
+				{30 * time.Second, "b", "a"},
+				{99 * time.Second, "b", "a"},
+				{100 * time.Second, "b", "b"},
+			},
+		},
+		{
+			name:     "flaps reset after the window",
+			holdDown: config.HoldDown{Duration: config.Duration(30 * time.Second), FlapThreshold: 1, FlapWindow: config.Duration(time.Minute)},
+			steps: []step{
+				{0, "a", "a"},
+				{time.Second, "b", "a"},
+				{31 * time.Second, "b", "b"},
+				{32 * time.Second, "a", "b"},
+				{50 * time.Second, "a", "b"},
+				{61 * time.Second, "a", "a"},
+			},
+		},
+		{
+			name:     "zero duration applies every change",
+			holdDown: config.HoldDown{FlapThreshold: 1, FlapWindow: config.Duration(5 * time.Minute)},
+			steps:    []step{{0, "a", "a"}, {time.Second, "b", "b"}, {2 * time.Second, "a", "a"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			tracker := wireguard.NewEndpointTracker(&test.holdDown)
+			publicKey := testKey(t).PublicKey().String()
+			start := time.Now()
+			for _, step := range test.steps {
+				if got := tracker.Observe(publicKey, step.endpoint, start.Add(step.at)); got != step.expected {
+					t.Fatalf("expected %s at %s, got %s", step.expected, step.at, got)
+				}
+			}
+		})
+	}
+}
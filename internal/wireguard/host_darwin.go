@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package wireguard
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// tunName keeps a utun name and otherwise lets macOS pick the next free
+// utun interface, the only kind it creates.
+func tunName(name string) string {
+	if strings.HasPrefix(name, "utun") {
+		return name
+	}
+	return "utun"
+}
+
+// addAddress assigns prefix the way wg-quick does, an IPv4 address being
+// its own destination on the point-to-point interface.
+func (d *Device) addAddress(prefix netip.Prefix) error {
+	if prefix.Addr().Is4() {
+		return run("ifconfig", d.name, "inet", prefix.String(), prefix.Addr().String(), "alias")
+	}
+	return run("ifconfig", d.name, "inet6", prefix.String(), "alias")
+}
+
+func (d *Device) setUp() error {
+	return run("ifconfig", d.name, "up")
+}
+
+// addRoute adds the route to prefix through the interface, or points an
+// existing one at it. macOS has a single routing table.
+func (d *Device) addRoute(prefix netip.Prefix) error {
+	err := run("route", "-q", "-n", "add", routeFamily(prefix), prefix.String(), "-interface", d.name)
+	if err != nil && run("route", "-q", "-n", "change", routeFamily(prefix), prefix.String(), "-interface", d.name) == nil {
+		return nil
+	}
+	return err
+}
+
+func (d *Device) deleteRoute(prefix netip.Prefix) error {
+	return run("route", "-q", "-n", "delete", routeFamily(prefix), prefix.String(), "-interface", d.name)
+}
+
+func routeFamily(prefix netip.Prefix) string {
+	if prefix.Addr().Is4() {
+		return "-inet"
+	}
+	return "-inet6"
+}
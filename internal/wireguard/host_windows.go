@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package wireguard
+
+import (
+	"net"
+	"net/netip"
+)
+
+// tunName keeps the name, Wintun adapters may be called anything.
+func tunName(name string) string {
+	return name
+}
+
+// addAddress assigns prefix with netsh for as long as the adapter exists.
+func (d *Device) addAddress(prefix netip.Prefix) error {
+	if prefix.Addr().Is4() {
+		mask := net.IP(net.CIDRMask(prefix.Bits(), 32)).String()
+		return run("netsh", "interface", "ipv4", "add", "address", "name="+d.name,
+			"address="+prefix.Addr().String(), "mask="+mask, "store=active")
+	}
+	return run("netsh", "interface", "ipv6", "add", "address", "interface="+d.name,
+		"address="+prefix.String(), "store=active")
+}
+
+// setUp does nothing, the adapter is up as soon as wireguard-go opens its
+// session.
+func (d *Device) setUp() error {
+	return nil
+}
+
+// addRoute adds the on-link route to prefix through the adapter, or
+// updates an existing one.
+func (d *Device) addRoute(prefix netip.Prefix) error {
+	family := routeFamily(prefix)
+	err := run("netsh", "interface", family, "add", "route", "prefix="+prefix.String(), "interface="+d.name, "store=active")
+	if err != nil && run("netsh", "interface", family, "set", "route", "prefix="+prefix.String(), "interface="+d.name, "store=active") == nil {
+		return nil
+	}
+	return err
+}
+
+func (d *Device) deleteRoute(prefix netip.Prefix) error {
+	return run("netsh", "interface", routeFamily(prefix), "delete", "route", "prefix="+prefix.String(), "interface="+d.name)
+}
+
+func routeFamily(prefix netip.Prefix) string {
+	if prefix.Addr().Is4() {
+		return "ipv4"
+	}
+	return "ipv6"
+}
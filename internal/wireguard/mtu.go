@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package wireguard
+
+import "log/slog"
+
+const (
+	// WireGuard adds 32 bytes (header, counter and auth tag) and 8 bytes of UDP
+	// on top of the outer IP header
+	ipv4Overhead = 20 + 8 + 32
+	ipv6Overhead = 40 + 8 + 32
+
+	// FallbackMTU matches wg-quick's default when the underlay can't be found
+	FallbackMTU = 1420
+)
+
+func resolveMTU(configured int) int {
+	if configured != 0 {
+		return configured
+	}
+
+	mtu, err := DetectMTU()
+	if err != nil {
+		slog.Warn("Failed to detect MTU, using fallback", "mtu", FallbackMTU, "error", err.Error())
+		return FallbackMTU
+	}
+
+	slog.Info("Detected WireGuard MTU", "mtu", mtu)
+	return mtu
+}
@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package wireguard
+
+import (
+	"fmt"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/vishvananda/netlink"
+)
+
+// DetectMTU derives the tunnel MTU from the interfaces carrying the default
+// routes. An IPv6 default route means peers may be reached over IPv6, so the
+// larger IPv6 overhead is taken into account as well.
+func DetectMTU() (int, error) {
+	routes, err := netlink.RouteList(nil, netlink.FAMILY_ALL)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list routes: %w", err)
+	}
+
+	mtu := 0
+	for _, route := range routes {
+		if route.Dst != nil {
+			if ones, _ := route.Dst.Mask.Size(); ones != 0 {
+				continue
+			}
+		}
+
+		outer, err := routeMTU(&route)
+		if err != nil {
+			return 0, err
+		}
+
+		overhead := ipv4Overhead
+		if route.Family == netlink.FAMILY_V6 {
+			overhead = ipv6Overhead
+		}
+
+		if candidate := outer - overhead; mtu == 0 || candidate < mtu {
+			mtu = candidate
+		}
+	}
+
+	if mtu == 0 {
+		return 0, ErrNoDefaultRoute
+	}
+
+	return max(mtu, config.MinWireGuardMTU), nil
+}
+
+// routeMTU returns the MTU set on route, or else that of the link it goes
+// out of. A multipath route has no link of its own, packets may leave
+// through any of its nexthops, so the smallest of their links counts.
+func routeMTU(route *netlink.Route) (int, error) {
+	if route.MTU != 0 {
+		return route.MTU, nil
+	}
+	if len(route.MultiPath) == 0 {
+		return linkMTU(route.LinkIndex)
+	}
+
+	mtu := 0
+	for _, nexthop := range route.MultiPath {
+		candidate, err := linkMTU(nexthop.LinkIndex)
+		if err != nil {
+			return 0, err
+		}
+		if mtu == 0 || candidate < mtu {
+			mtu = candidate
+		}
+	}
+	return mtu, nil
+}
+
+func linkMTU(index int) (int, error) {
+	link, err := netlink.LinkByIndex(index)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get link %d: %w", index, err)
+	}
+	return link.Attrs().MTU, nil
+}
@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+//go:build !linux
+
+package wireguard
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/kubewg-net/container/internal/config"
+)
+
+// DetectMTU has no routing table to find the underlay in, so it derives
+// the tunnel MTU from the smallest of the interfaces that are up with a
+// global address, assuming peers may be reached over IPv6.
+func DetectMTU() (int, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list interfaces: %w", err)
+	}
+
+	mtu := 0
+	for i := range interfaces {
+		iface := &interfaces[i]
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&(net.FlagLoopback|net.FlagPointToPoint) != 0 {
+			continue
+		}
+		if !hasGlobalAddress(iface) {
+			continue
+		}
+		if candidate := iface.MTU - ipv6Overhead; mtu == 0 || candidate < mtu {
+			mtu = candidate
+		}
+	}
+
+	if mtu == 0 {
+		return 0, ErrNoDefaultRoute
+	}
+
+	return max(mtu, config.MinWireGuardMTU), nil
+}
+
+func hasGlobalAddress(iface *net.Interface) bool {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.IsGlobalUnicast() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package wireguard
+
+import (
+	"net"
+	"slices"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// PeerChanges returns the updates that take the device from its current
+// peers to desired: new peers are added, changed ones updated in place and
+// peers no longer desired removed. Unchanged peers are left out so their
+// sessions, handshake times and counters survive.
+func PeerChanges(current []wgtypes.Peer, desired []wgtypes.PeerConfig) []wgtypes.PeerConfig {
+	programmed := make(map[wgtypes.Key]*wgtypes.Peer, len(current))
+	for i := range current {
+		programmed[current[i].PublicKey] = &current[i]
+	}
+
+	var changes []wgtypes.PeerConfig
+	wanted := make(map[wgtypes.Key]struct{}, len(desired))
+	for _, peer := range desired {
+		wanted[peer.PublicKey] = struct{}{}
+		existing, ok := programmed[peer.PublicKey]
+		if !ok {
+			changes = append(changes, peer)
+			continue
+		}
+		if !peerDiffers(existing, &peer) {
+			continue
+		}
+		// A key or keepalive dropped from the config has to be cleared
+		// explicitly, leaving the field out keeps the old value
+		if peer.PresharedKey == nil && existing.PresharedKey != (wgtypes.Key{}) {
+			peer.PresharedKey = &wgtypes.Key{}
+		}
+		if peer.PersistentKeepaliveInterval == nil && existing.PersistentKeepaliveInterval != 0 {
+			var none time.Duration
+			peer.PersistentKeepaliveInterval = &none
+		}
+		peer.UpdateOnly = true
+		changes = append(changes, peer)
+	}
+
+	for _, peer := range current {
+		if _, ok := wanted[peer.PublicKey]; !ok {
+			changes = append(changes, wgtypes.PeerConfig{PublicKey: peer.PublicKey, Remove: true})
+		}
+	}
+	return changes
+}
+
+// peerDiffers reports whether desired changes anything on the programmed
+// peer. An endpoint left out isn't a change, the kernel keeps the one the
+// peer roamed to.
+func peerDiffers(current *wgtypes.Peer, desired *wgtypes.PeerConfig) bool {
+	presharedKey := wgtypes.Key{}
+	if desired.PresharedKey != nil {
+		presharedKey = *desired.PresharedKey
+	}
+	if presharedKey != current.PresharedKey {
+		return true
+	}
+
+	var keepalive time.Duration
+	if desired.PersistentKeepaliveInterval != nil {
+		keepalive = *desired.PersistentKeepaliveInterval
+	}
+	if keepalive != current.PersistentKeepaliveInterval {
+		return true
+	}
+
+	if desired.Endpoint != nil && (current.Endpoint == nil || desired.Endpoint.String() != current.Endpoint.String()) {
+		return true
+	}
+
+	if !desired.ReplaceAllowedIPs {
+		return len(desired.AllowedIPs) != 0
+	}
+	return !slices.Equal(sortedIPNets(current.AllowedIPs), sortedIPNets(desired.AllowedIPs))
+}
+
+func sortedIPNets(ipNets []net.IPNet) []string {
+	sorted := make([]string, 0, len(ipNets))
+	for _, ipNet := range ipNets {
+		sorted = append(sorted, ipNet.String())
+	}
+	slices.Sort(sorted)
+	return sorted
+}
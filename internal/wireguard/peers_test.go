@@ -0,0 +1,109 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package wireguard_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/internal/wireguard"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func testKey(t *testing.T) wgtypes.Key {
+	t.Helper()
+
+	key, err := wgtypes.GenerateKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return key
+}
+
+func ipNets(t *testing.T, cidrs ...string) []net.IPNet {
+	t.Helper()
+
+	ipNets := make([]net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ipNets = append(ipNets, *ipNet)
+	}
+	return ipNets
+}
+
+func TestPeerChanges(t *testing.T) {
+	t.Parallel()
+
+	unchanged, changed, added, removed := testKey(t), testKey(t), testKey(t), testKey(t)
+	presharedKey := testKey(t)
+	keepalive := 25 * time.Second
+	endpoint := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 51820}
+
+	current := []wgtypes.Peer{
+		{PublicKey: unchanged, AllowedIPs: ipNets(t, "10.0.0.2/32", "10.1.0.0/16"), Endpoint: endpoint, PersistentKeepaliveInterval: keepalive},
+		{PublicKey: changed, AllowedIPs: ipNets(t, "10.0.0.3/32"), PresharedKey: presharedKey},
+		{PublicKey: removed, AllowedIPs: ipNets(t, "10.0.0.4/32")},
+	}
+	desired := []wgtypes.PeerConfig{
+		// The roamed endpoint is kept when the config names none
+		{PublicKey: unchanged, ReplaceAllowedIPs: true, AllowedIPs: ipNets(t, "10.1.0.0/16", "10.0.0.2/32"), PersistentKeepaliveInterval: &keepalive},
+		{PublicKey: changed, ReplaceAllowedIPs: true, AllowedIPs: ipNets(t, "10.0.0.3/32", "10.2.0.0/16")},
+		{PublicKey: added, ReplaceAllowedIPs: true, AllowedIPs: ipNets(t, "10.0.0.5/32")},
+	}
+
+	changes := wireguard.PeerChanges(current, desired)
+	byKey := make(map[wgtypes.Key]wgtypes.PeerConfig, len(changes))
+	for _, change := range changes {
+		byKey[change.PublicKey] = change
+	}
+
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 changes, got %d: %+v", len(changes), changes)
+	}
+	if _, ok := byKey[unchanged]; ok {
+		t.Errorf("expected the unchanged peer to be left alone, got %+v", byKey[unchanged])
+	}
+	if change := byKey[changed]; !change.UpdateOnly || change.PresharedKey == nil || *change.PresharedKey != (wgtypes.Key{}) {
+		t.Errorf("expected the changed peer to be updated in place with its preshared key cleared, got %+v", change)
+	}
+	if change := byKey[added]; change.UpdateOnly || change.Remove {
+		t.Errorf("expected the new peer to be added, got %+v", change)
+	}
+	if change := byKey[removed]; !change.Remove {
+		t.Errorf("expected the dropped peer to be removed, got %+v", change)
+	}
+}
+
+func TestPeerChangesEndpoint(t *testing.T) {
+	t.Parallel()
+
+	key := testKey(t)
+	current := []wgtypes.Peer{{PublicKey: key, Endpoint: &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 51820}}}
+	desired := []wgtypes.PeerConfig{{PublicKey: key, Endpoint: &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 51820}}}
+
+	changes := wireguard.PeerChanges(current, desired)
+	if len(changes) != 1 || !changes[0].UpdateOnly {
+		t.Errorf("expected a moved endpoint to update the peer, got %+v", changes)
+	}
+}
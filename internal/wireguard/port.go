@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package wireguard
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/secmem"
+	"golang.zx2c4.com/wireguard/wgctrl"
+)
+
+var ErrNoFreePort = errors.New("no free listen port")
+
+// SelectListenPort picks the listen port for a listen_port of 0 from
+// ports. It keeps the port of the interface if it still exists, then tries
+// previous, the port advertised before a restart, so the other nodes don't
+// have to learn a new one. Only then does it take the first free port.
+func SelectListenPort(name string, ports config.PortRange, previous uint16) (uint16, error) {
+	if port, ok := devicePort(name); ok && ports.Contains(port) {
+		return port, nil
+	}
+	if previous != 0 && ports.Contains(previous) && PortFree(previous) {
+		return previous, nil
+	}
+	for port := int(ports.Min); port <= int(ports.Max); port++ {
+		if PortFree(uint16(port)) {
+			return uint16(port), nil
+		}
+	}
+	return 0, fmt.Errorf("%w in %d-%d", ErrNoFreePort, ports.Min, ports.Max)
+}
+
+// devicePort returns the listen port of the WireGuard interface name.
+func devicePort(name string) (uint16, bool) {
+	client, err := wgctrl.New()
+	if err != nil {
+		return 0, false
+	}
+	defer client.Close()
+	device, err := client.Device(name)
+	if err != nil {
+		return 0, false
+	}
+	secmem.WipeDevice(device)
+	if device.ListenPort <= 0 {
+		return 0, false
+	}
+	return uint16(device.ListenPort), true
+}
+
+// PortFree reports whether UDP port can be bound on every address, which
+// is what the interface does with it.
+func PortFree(port uint16) bool {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: int(port)})
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+package wireguard_test
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/wireguard"
+)
+
+// occupy binds a UDP port for the rest of the test and returns it.
+func occupy(t *testing.T) uint16 {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return uint16(conn.LocalAddr().(*net.UDPAddr).Port)
+}
+
+func TestSelectListenPort(t *testing.T) {
+	t.Parallel()
+
+	taken := occupy(t)
+	if taken > 65535-20 {
+		t.Skipf("port %d leaves no room for a range after it", taken)
+	}
+	if wireguard.PortFree(taken) {
+		t.Fatalf("expected port %d to be taken", taken)
+	}
+
+	if _, err := wireguard.SelectListenPort("kubewg-test", config.PortRange{Min: taken, Max: taken}, 0); !errors.Is(err, wireguard.ErrNoFreePort) {
+		t.Errorf("expected %v, got %v", wireguard.ErrNoFreePort, err)
+	}
+
+	// The walk skips the taken port, as well as a taken previous port
+	ports := config.PortRange{Min: taken, Max: taken + 20}
+	port, err := wireguard.SelectListenPort("kubewg-test", ports, taken)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if port == taken || !ports.Contains(port) {
+		t.Errorf("expected a free port in %d-%d other than %d, got %d", ports.Min, ports.Max, taken, port)
+	}
+
+	// A free previous port is kept even when an earlier one is free too
+	if port, err := wireguard.SelectListenPort("kubewg-test", ports, ports.Max); err != nil || port != ports.Max {
+		t.Errorf("expected the previous port %d, got %d, %v", ports.Max, port, err)
+	}
+}
@@ -0,0 +1,102 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package wireguard
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// syncRoutes routes the peers' allowed IPs through the interface, the way
+// wg-quick does, in the configured routing table. Prefixes already covered
+// by an interface address are reached through its connected route. Default
+// routes are left alone so a peer can't take over the node's traffic,
+// unless a fwmark keeps the tunnel's own packets out of them.
+func (d *Device) syncRoutes(peers []wgtypes.PeerConfig) error {
+	connected := make([]netip.Prefix, 0, len(d.config.Addresses))
+	for _, address := range d.config.Addresses {
+		prefix, err := netip.ParsePrefix(address)
+		if err != nil {
+			return fmt.Errorf("invalid address %q: %w", address, err)
+		}
+		connected = append(connected, prefix.Masked())
+	}
+
+	desired := make(map[netip.Prefix]struct{})
+	for i := range peers {
+		for _, ipNet := range peers[i].AllowedIPs {
+			prefix, ok := prefixFromIPNet(ipNet)
+			if !ok || (prefix.Bits() == 0 && d.config.FwMark == 0) || coveredBy(prefix, connected) {
+				continue
+			}
+			desired[prefix] = struct{}{}
+		}
+	}
+
+	for prefix := range desired {
+		if err := d.addRoute(prefix); err != nil {
+			return fmt.Errorf("failed to add route %s via %s: %w", prefix, d.name, err)
+		}
+	}
+	for prefix := range d.routes {
+		if _, ok := desired[prefix]; ok {
+			continue
+		}
+		if err := d.deleteRoute(prefix); err != nil {
+			return fmt.Errorf("failed to remove route %s via %s: %w", prefix, d.name, err)
+		}
+	}
+
+	d.routes = desired
+	return nil
+}
+
+// removeRoutes deletes every route syncRoutes added. Failures are only
+// logged, the routes go away with the interface anyway.
+func (d *Device) removeRoutes() {
+	for prefix := range d.routes {
+		if err := d.deleteRoute(prefix); err != nil {
+			slog.Warn("Failed to remove route", "prefix", prefix.String(), "interface", d.name, "error", err.Error())
+		}
+	}
+	d.routes = nil
+}
+
+func prefixFromIPNet(ipNet net.IPNet) (netip.Prefix, bool) {
+	addr, ok := netip.AddrFromSlice(ipNet.IP)
+	if !ok {
+		return netip.Prefix{}, false
+	}
+	ones, _ := ipNet.Mask.Size()
+	return netip.PrefixFrom(addr.Unmap(), ones).Masked(), true
+}
+
+func coveredBy(prefix netip.Prefix, connected []netip.Prefix) bool {
+	for _, c := range connected {
+		if c.Bits() <= prefix.Bits() && c.Contains(prefix.Addr()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package wireguard
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/vishvananda/netlink"
+)
+
+func (d *Device) addRoute(prefix netip.Prefix) error {
+	return netlink.RouteReplace(d.route(prefix))
+}
+
+func (d *Device) deleteRoute(prefix netip.Prefix) error {
+	return netlink.RouteDel(d.route(prefix))
+}
+
+func (d *Device) route(prefix netip.Prefix) *netlink.Route {
+	table := d.config.Routing.Table
+	if prefix.Bits() == 0 {
+		table = d.config.Routing.ExclusionTable(d.config.FwMark)
+	}
+	return &netlink.Route{
+		LinkIndex: d.link.Attrs().Index,
+		Dst: &net.IPNet{
+			IP:   prefix.Addr().AsSlice(),
+			Mask: net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen()),
+		},
+		Scope: netlink.SCOPE_LINK,
+		Table: table,
+	}
+}
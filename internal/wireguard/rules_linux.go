@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package wireguard
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// policyRules builds the rules selecting the routing table, in priority
+// order, followed by the exclusion rules when a fwmark is set. A rule that
+// only matches a fwmark applies to both address families, so it becomes one
+// rule per family.
+func (d *Device) policyRules() ([]*netlink.Rule, error) {
+	routing := &d.config.Routing
+	rules := make([]*netlink.Rule, 0, len(routing.Rules))
+	for i := range routing.Rules {
+		from, to, err := routing.Rules[i].Prefixes()
+		if err != nil {
+			return nil, err
+		}
+
+		families := []int{unix.AF_INET, unix.AF_INET6}
+		switch {
+		case from.IsValid():
+			families = []int{familyOf(from)}
+		case to.IsValid():
+			families = []int{familyOf(to)}
+		}
+
+		for _, family := range families {
+			rule := netlink.NewRule()
+			rule.Family = family
+			rule.Priority = routing.RulePriority + i
+			rule.Table = routing.Table
+			if mark := routing.Rules[i].FwMark; mark != 0 {
+				rule.Mark = mark
+				if mask := routing.Rules[i].FwMask; mask != 0 {
+					rule.Mask = &mask
+				}
+			}
+			if from.IsValid() {
+				rule.Src = ipNet(from)
+			}
+			if to.IsValid() {
+				rule.Dst = ipNet(to)
+			}
+			rules = append(rules, rule)
+		}
+	}
+
+	if d.config.FwMark == 0 {
+		return rules, nil
+	}
+	// Like wg-quick: the main table still wins with anything more specific
+	// than a default route, everything else not sent by WireGuard itself
+	// takes the tunnel's default route
+	priority := routing.RulePriority + len(routing.Rules)
+	for _, family := range []int{unix.AF_INET, unix.AF_INET6} {
+		suppress := netlink.NewRule()
+		suppress.Family = family
+		suppress.Priority = priority
+		suppress.Table = unix.RT_TABLE_MAIN
+		suppress.SuppressPrefixlen = 0
+		rules = append(rules, suppress)
+
+		exclude := netlink.NewRule()
+		exclude.Family = family
+		exclude.Priority = priority + 1
+		exclude.Table = routing.ExclusionTable(d.config.FwMark)
+		exclude.Mark = d.config.FwMark
+		exclude.Invert = true
+		rules = append(rules, exclude)
+	}
+	return rules, nil
+}
+
+// syncRules adds the policy rules that are missing. Rules are matched on
+// what they select, so rules added by hand or by an earlier run are kept.
+func (d *Device) syncRules() error {
+	desired, err := d.policyRules()
+	if err != nil {
+		return err
+	}
+	for _, rule := range desired {
+		exists, err := ruleExists(rule)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if err := netlink.RuleAdd(rule); err != nil {
+			return fmt.Errorf("failed to add %s: %w", rule, err)
+		}
+	}
+	d.rules = desired
+	return nil
+}
+
+// missingRules describes the policy rules that were removed behind our
+// back.
+func (d *Device) missingRules() ([]string, error) {
+	var missing []string
+	for _, rule := range d.rules {
+		exists, err := ruleExists(rule)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			missing = append(missing, fmt.Sprintf("policy rule %q is missing", rule.String()))
+		}
+	}
+	return missing, nil
+}
+
+// removeRules deletes the policy rules syncRules added.
+func (d *Device) removeRules() {
+	for _, rule := range d.rules {
+		if err := netlink.RuleDel(rule); err != nil {
+			slog.Warn("Failed to remove policy rule", "rule", rule.String(), "error", err.Error())
+		}
+	}
+	d.rules = nil
+}
+
+func ruleExists(rule *netlink.Rule) (bool, error) {
+	rules, err := netlink.RuleList(rule.Family)
+	if err != nil {
+		return false, fmt.Errorf("failed to list policy rules: %w", err)
+	}
+	for i := range rules {
+		if sameRule(&rules[i], rule) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func sameRule(a, b *netlink.Rule) bool {
+	return a.Priority == b.Priority && a.Table == b.Table && a.Mark == b.Mark &&
+		maskOf(a) == maskOf(b) && a.Invert == b.Invert &&
+		a.SuppressPrefixlen == b.SuppressPrefixlen &&
+		ipNetString(a.Src) == ipNetString(b.Src) && ipNetString(a.Dst) == ipNetString(b.Dst)
+}
+
+// maskOf is the mask the kernel compares the fwmark with, all bits when
+// none was given.
+func maskOf(rule *netlink.Rule) uint32 {
+	if rule.Mark == 0 {
+		return 0
+	}
+	if rule.Mask == nil {
+		return 0xffffffff
+	}
+	return *rule.Mask
+}
+
+func ipNetString(ipNet *net.IPNet) string {
+	if ipNet == nil {
+		return ""
+	}
+	return ipNet.String()
+}
+
+func familyOf(prefix netip.Prefix) int {
+	if prefix.Addr().Is4() {
+		return unix.AF_INET
+	}
+	return unix.AF_INET6
+}
+
+func ipNet(prefix netip.Prefix) *net.IPNet {
+	return &net.IPNet{
+		IP:   prefix.Addr().AsSlice(),
+		Mask: net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen()),
+	}
+}
@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package wireguard
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// userspace is a wireguard-go device behind a TUN interface. It serves the
+// same UAPI socket wgctrl looks for, so the device is configured the same
+// way as a kernel one.
+type userspace struct {
+	// name is the one the system gave the TUN interface, which on macOS
+	// may differ from the one asked for
+	name   string
+	device *device.Device
+	uapi   net.Listener
+}
+
+func startUserspace(name string, mtu int) (*userspace, error) {
+	tunDevice, err := tun.CreateTUN(name, mtu)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TUN interface %s: %w", name, err)
+	}
+	if name, err = tunDevice.Name(); err != nil {
+		tunDevice.Close()
+		return nil, fmt.Errorf("failed to get the name of the TUN interface: %w", err)
+	}
+	logger := &device.Logger{
+		Verbosef: func(format string, args ...any) {
+			slog.Debug(fmt.Sprintf(format, args...), "interface", name)
+		},
+		Errorf: func(format string, args ...any) {
+			slog.Warn(fmt.Sprintf(format, args...), "interface", name)
+		},
+	}
+	dev := device.NewDevice(tunDevice, conn.NewDefaultBind(), logger)
+
+	uapi, err := uapiListen(name)
+	if err != nil {
+		dev.Close()
+		return nil, err
+	}
+	go func() {
+		for {
+			c, err := uapi.Accept()
+			if err != nil {
+				return
+			}
+			go dev.IpcHandle(c)
+		}
+	}()
+
+	slog.Info("Created userspace WireGuard interface", "name", name, "mtu", mtu)
+	return &userspace{name: name, device: dev, uapi: uapi}, nil
+}
+
+// Close stops the device, which removes its TUN interface.
+func (u *userspace) Close() {
+	u.uapi.Close()
+	u.device.Close()
+}
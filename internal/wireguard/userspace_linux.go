@@ -0,0 +1,68 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package wireguard
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// kernelUnsupported tells whether creating a wireguard link failed because
+// the kernel has no WireGuard support.
+func kernelUnsupported(err error) bool {
+	return errors.Is(err, unix.EOPNOTSUPP)
+}
+
+// createLink creates the interface in the kernel, or in wireguard-go as the
+// userspace setting asks.
+func (d *Device) createLink(mtu int) (netlink.Link, error) {
+	if d.config.Userspace != config.UserspaceAlways {
+		attrs := netlink.NewLinkAttrs()
+		attrs.Name = d.name
+		attrs.MTU = mtu
+		link := &netlink.Wireguard{LinkAttrs: attrs}
+		err := netlink.LinkAdd(link)
+		if err == nil {
+			slog.Info("Created WireGuard interface", "name", d.name, "mtu", mtu)
+			return link, nil
+		}
+		if d.config.Userspace != config.UserspaceAuto || !kernelUnsupported(err) {
+			return nil, fmt.Errorf("failed to create link %s: %w", d.name, err)
+		}
+		slog.Warn("The kernel has no WireGuard support, falling back to userspace", "name", d.name)
+	}
+
+	u, err := startUserspace(d.name, mtu)
+	if err != nil {
+		return nil, err
+	}
+	link, err := netlink.LinkByName(d.name)
+	if err != nil {
+		u.Close()
+		return nil, fmt.Errorf("failed to get link %s: %w", d.name, err)
+	}
+	d.userspace = u
+	return link, nil
+}
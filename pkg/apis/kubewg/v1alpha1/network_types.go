@@ -0,0 +1,134 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type Topology string
+
+const (
+	// TopologyFullMesh peers every member with every other member
+	TopologyFullMesh Topology = "FullMesh"
+	// TopologyHubSpoke peers members only with the network's hub nodes
+	TopologyHubSpoke Topology = "HubSpoke"
+)
+
+// PortRange is an inclusive range of UDP ports.
+type PortRange struct {
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Min uint16 `json:"min"`
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Max uint16 `json:"max"`
+}
+
+// Contains reports whether port lies within the range.
+func (r *PortRange) Contains(port uint16) bool {
+	return port >= r.Min && port <= r.Max
+}
+
+// WireGuardNetworkSpec describes a mesh and the defaults its members
+// inherit.
+type WireGuardNetworkSpec struct {
+	// TunnelCIDR is the address range member interfaces and peers are
+	// addressed from.
+	TunnelCIDR string `json:"tunnelCIDR"`
+	// TunnelCIDRs makes the network dual-stack: it holds at most one IPv4
+	// and one IPv6 range, including TunnelCIDR, and members and peers get
+	// an address from each.
+	// +kubebuilder:validation:MaxItems=2
+	// +optional
+	TunnelCIDRs []string `json:"tunnelCIDRs,omitempty"`
+	// +kubebuilder:validation:Enum=FullMesh;HubSpoke
+	// +kubebuilder:default=FullMesh
+	// +optional
+	Topology Topology `json:"topology,omitempty"`
+	// HubSelector picks the hub nodes and peers of a HubSpoke network by
+	// their labels.
+	// +optional
+	HubSelector *metav1.LabelSelector `json:"hubSelector,omitempty"`
+	// ListenPortRange bounds the UDP ports member interfaces listen on.
+	// +optional
+	ListenPortRange *PortRange `json:"listenPortRange,omitempty"`
+	// +kubebuilder:validation:Minimum=1280
+	// +kubebuilder:validation:Maximum=9000
+	// +optional
+	MTU int `json:"mtu,omitempty"`
+	// PersistentKeepalive in seconds for peers that don't set their own.
+	// +optional
+	PersistentKeepalive uint32 `json:"persistentKeepalive,omitempty"`
+	// DNS servers handed to clients of the network.
+	// +optional
+	DNS []string `json:"dns,omitempty"`
+	// KeyRotation is the maximum age in seconds of member private keys.
+	// +optional
+	KeyRotation uint32 `json:"keyRotation,omitempty"`
+}
+
+// CIDRs returns TunnelCIDR followed by the other ranges of a dual-stack
+// network.
+func (s *WireGuardNetworkSpec) CIDRs() []string {
+	cidrs := []string{s.TunnelCIDR}
+	for _, cidr := range s.TunnelCIDRs {
+		if cidr != s.TunnelCIDR {
+			cidrs = append(cidrs, cidr)
+		}
+	}
+	return cidrs
+}
+
+// WireGuardNetworkStatus is the observed state of a WireGuardNetwork.
+type WireGuardNetworkStatus struct {
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// WireGuardNetwork is a WireGuard mesh. Several independent meshes can
+// exist in one cluster, every node joins the one it is configured for.
+//
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster,shortName=wgnet
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Tunnel CIDR",type=string,JSONPath=`.spec.tunnelCIDR`
+// +kubebuilder:printcolumn:name="Topology",type=string,JSONPath=`.spec.topology`
+// +kubebuilder:printcolumn:name="MTU",type=integer,JSONPath=`.spec.mtu`,priority=1
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+type WireGuardNetwork struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WireGuardNetworkSpec   `json:"spec"`
+	Status WireGuardNetworkStatus `json:"status,omitempty"`
+}
+
+// WireGuardNetworkList is a list of WireGuardNetworks.
+//
+// +kubebuilder:object:root=true
+type WireGuardNetworkList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []WireGuardNetwork `json:"items"`
+}
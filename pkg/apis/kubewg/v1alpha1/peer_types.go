@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WireGuardPeerSpec describes a peer outside the cluster, such as a home
+// lab box, a cloud VM or a laptop.
+type WireGuardPeerSpec struct {
+	// Network is the WireGuardNetwork the peer joins.
+	Network string `json:"network"`
+	// PublicKey is the peer's base64 WireGuard public key.
+	PublicKey string `json:"publicKey"`
+	// Endpoint is the static host:port of the peer, empty for roaming peers
+	// that always dial in. service:namespace/name:port names a Service whose
+	// cluster IP and port are used instead.
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+	// AllowedIPs are routed to the peer.
+	AllowedIPs []string `json:"allowedIPs"`
+	// ExcludedIPs are left out of the routes the peer gets when it uses a
+	// gateway as its exit node, such as its local LAN.
+	// +optional
+	ExcludedIPs []string `json:"excludedIPs,omitempty"`
+	// PersistentKeepalive in seconds, inherited from the network when unset.
+	// +optional
+	PersistentKeepalive uint32 `json:"persistentKeepalive,omitempty"`
+	// GatewaySelector picks the nodes the peer is configured on by their
+	// labels. When unset the peer is configured on the network's hubs, or
+	// every node of a full mesh.
+	// +optional
+	GatewaySelector *metav1.LabelSelector `json:"gatewaySelector,omitempty"`
+}
+
+// Condition types of a WireGuardPeer
+const (
+	// PeerReady is true when a gateway has the peer configured and a
+	// recent handshake with it.
+	PeerReady = "Ready"
+	// PeerHandshakeRecent is true when a gateway completed a handshake with
+	// the peer within WireGuard's reject-after-time.
+	PeerHandshakeRecent = "HandshakeRecent"
+	// PeerEndpointResolved is true when a gateway knows where to reach the
+	// peer, either from the spec or from the peer dialing in.
+	PeerEndpointResolved = "EndpointResolved"
+	// PeerAccepted is true when the operator found the spec valid and the
+	// network it joins exists.
+	PeerAccepted = "Accepted"
+)
+
+// WireGuardPeerGatewayStatus is what one gateway node sees of the peer.
+type WireGuardPeerGatewayStatus struct {
+	// Node is the gateway's node name.
+	Node string `json:"node"`
+	// Endpoint the gateway last reached the peer at.
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+	// LastHandshakeTime is when the gateway last completed a handshake.
+	// +optional
+	LastHandshakeTime *metav1.Time `json:"lastHandshakeTime,omitempty"`
+	// ReceiveBytes is how much the gateway received from the peer.
+	ReceiveBytes int64 `json:"receiveBytes"`
+	// TransmitBytes is how much the gateway sent to the peer.
+	TransmitBytes int64 `json:"transmitBytes"`
+	// UpdateTime is when the gateway last wrote this entry. Entries a
+	// gateway stopped updating are dropped.
+	UpdateTime metav1.Time `json:"updateTime"`
+}
+
+// WireGuardPeerStatus is the observed state of a WireGuardPeer.
+type WireGuardPeerStatus struct {
+	// ObservedGeneration is the generation the status was written for.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Endpoint of the peer as seen by the gateway with the latest handshake.
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+	// LastHandshakeTime is the latest handshake of any gateway.
+	// +optional
+	LastHandshakeTime *metav1.Time `json:"lastHandshakeTime,omitempty"`
+	// ReceiveBytes is the sum over the gateways.
+	// +optional
+	ReceiveBytes int64 `json:"receiveBytes,omitempty"`
+	// TransmitBytes is the sum over the gateways.
+	// +optional
+	TransmitBytes int64 `json:"transmitBytes,omitempty"`
+	// Gateways lists what each gateway node sees of the peer.
+	// +optional
+	Gateways []WireGuardPeerGatewayStatus `json:"gateways,omitempty"`
+}
+
+// WireGuardPeer is a peer outside the cluster that gateway nodes keep
+// configured.
+//
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster,shortName=wgp
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Network",type=string,JSONPath=`.spec.network`
+// +kubebuilder:printcolumn:name="Tunnel IP",type=string,JSONPath=`.spec.allowedIPs[0]`
+// +kubebuilder:printcolumn:name="Endpoint",type=string,JSONPath=`.status.endpoint`
+// +kubebuilder:printcolumn:name="Last Handshake",type=date,JSONPath=`.status.lastHandshakeTime`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+type WireGuardPeer struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WireGuardPeerSpec   `json:"spec"`
+	Status WireGuardPeerStatus `json:"status,omitempty"`
+}
+
+// WireGuardPeerList is a list of WireGuardPeers.
+//
+// +kubebuilder:object:root=true
+type WireGuardPeerList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []WireGuardPeer `json:"items"`
+}
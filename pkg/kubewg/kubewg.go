@@ -0,0 +1,218 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+
+// Package kubewg lets other Go programs embed the kubewg engine: the
+// WireGuard dataplane, the peer registry and the reconciler that keeps the
+// two in sync.
+//
+// The types below are aliases of the ones the container itself uses, so
+// values can be passed between this package and the engine freely.
+package kubewg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/kubewg-net/container/internal/config"
+	"github.com/kubewg-net/container/internal/events"
+	"github.com/kubewg-net/container/internal/keystore"
+	"github.com/kubewg-net/container/internal/peers"
+	"github.com/kubewg-net/container/internal/reconciler"
+	"github.com/kubewg-net/container/internal/resolver"
+	"github.com/kubewg-net/container/internal/wireguard"
+)
+
+type (
+	Config        = config.Config
+	WireGuard     = config.WireGuard
+	Peer          = config.WireGuardPeer
+	Dataplane     = wireguard.Dataplane
+	Registry      = peers.Registry
+	Reconciler    = reconciler.Reconciler
+	Summary       = reconciler.Summary
+	Drift         = reconciler.Drift
+	Event         = events.Event
+	EventType     = events.Type
+	EventSink     = events.Sink
+	KeyStore      = keystore.KeyStore
+	ServiceSource = resolver.ServiceSource
+)
+
+var (
+	ErrWireGuardDisabled = errors.New("wireguard is not enabled in the config")
+)
+
+// LoadConfig reads a YAML config file in the container's format and fills in
+// defaults.
+func LoadConfig(path string) (*Config, error) {
+	return config.LoadFile(path)
+}
+
+// Option customizes an Engine.
+type Option func(*Engine)
+
+// WithDataplane replaces the kernel WireGuard interface with dataplane.
+func WithDataplane(dataplane Dataplane) Option {
+	return func(e *Engine) {
+		e.dataplane = dataplane
+	}
+}
+
+// WithEventSink subscribes sink to the engine's peer lifecycle events.
+func WithEventSink(sink EventSink) Option {
+	return func(e *Engine) {
+		e.bus.Subscribe(sink)
+	}
+}
+
+// WithKeyStore keeps the private key of the kernel WireGuard interface in
+// store under name instead of the private key file.
+func WithKeyStore(store KeyStore, name string) Option {
+	return func(e *Engine) {
+		e.keyStore = store
+		e.keyName = name
+	}
+}
+
+// WithServiceSource resolves service:namespace/name:port peer endpoints
+// through source. Without it such endpoints fail to resolve.
+func WithServiceSource(source ServiceSource) Option {
+	return func(e *Engine) {
+		e.services = source
+	}
+}
+
+// Engine runs one WireGuard interface and reconciles its peers.
+type Engine struct {
+	config     *Config
+	dataplane  Dataplane
+	registry   *Registry
+	reconciler *Reconciler
+	bus        *events.Bus
+	keyStore   KeyStore
+	keyName    string
+	services   ServiceSource
+	started    bool
+}
+
+// New builds an engine from config. Nothing touches the system until Start.
+func New(config *Config, opts ...Option) (*Engine, error) {
+	if !config.WireGuard.Enabled {
+		return nil, ErrWireGuardDisabled
+	}
+
+	dnsResolver, err := resolver.NewResolver(&config.Resolver)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resolver: %w", err)
+	}
+
+	engine := &Engine{
+		config:   config,
+		registry: peers.NewRegistry(config.WireGuard.Peers),
+		bus:      events.NewBus(),
+	}
+	for _, opt := range opts {
+		opt(engine)
+	}
+	if engine.dataplane == nil {
+		device := wireguard.NewDevice(&config.WireGuard)
+		if engine.keyStore != nil {
+			device.UseKeyStore(engine.keyStore, engine.keyName)
+		}
+		engine.dataplane = device
+	}
+	if engine.services != nil {
+		dnsResolver.UseServices(engine.services)
+	}
+
+	engine.reconciler = reconciler.NewReconciler(&config.WireGuard, engine.dataplane, engine.registry, dnsResolver, engine.bus)
+	return engine, nil
+}
+
+// Start brings up the interface and starts the periodic reconcile loop in
+// the background. The loop runs until Stop is called or ctx is done.
+func (e *Engine) Start(ctx context.Context) error {
+	if err := e.dataplane.Up(ctx); err != nil {
+		return fmt.Errorf("failed to bring up WireGuard interface: %w", err)
+	}
+	go e.reconciler.Start(ctx)
+	e.started = true
+	return nil
+}
+
+// Apply brings up the interface and programs the peers once, leaving both
+// in place. Nothing keeps running afterwards and Stop is a no-op, which
+// suits a one-shot setup such as an init container.
+func (e *Engine) Apply(ctx context.Context) (*Summary, error) {
+	if err := e.dataplane.Up(ctx); err != nil {
+		return nil, fmt.Errorf("failed to bring up WireGuard interface: %w", err)
+	}
+	summary, err := e.reconciler.Reconcile(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure peers: %w", err)
+	}
+	return summary, nil
+}
+
+// Stop stops the reconcile loop and removes the interface. ctx bounds how
+// long to wait for a running reconcile.
+func (e *Engine) Stop(ctx context.Context) error {
+	if !e.started {
+		return nil
+	}
+	e.started = false
+
+	if err := e.reconciler.Stop(ctx); err != nil {
+		return fmt.Errorf("failed to stop reconciler: %w", err)
+	}
+	if err := e.dataplane.Down(); err != nil {
+		return fmt.Errorf("failed to remove WireGuard interface: %w", err)
+	}
+	return nil
+}
+
+// Subscribe adds a sink for peer lifecycle events.
+func (e *Engine) Subscribe(sink EventSink) {
+	e.bus.Subscribe(sink)
+}
+
+// Publish sends an event to the engine's sinks, for code that manages the
+// engine's peers from outside of it.
+func (e *Engine) Publish(eventType EventType, publicKey, message string) {
+	e.bus.Publish(eventType, publicKey, message)
+}
+
+func (e *Engine) Config() *Config {
+	return e.config
+}
+
+func (e *Engine) Dataplane() Dataplane {
+	return e.dataplane
+}
+
+// Registry returns the desired peer set. Peers added to it are programmed
+// on the next reconcile.
+func (e *Engine) Registry() *Registry {
+	return e.registry
+}
+
+func (e *Engine) Reconciler() *Reconciler {
+	return e.reconciler
+}
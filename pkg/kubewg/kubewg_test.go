@@ -0,0 +1,199 @@
+// SPDX-License-Identifier: AGPL-3.0-or-later
+// KubeWG - Wireguard in your Kubernetes cluster
+// Copyright (C) 2024 Jacob McSwain
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program. If not, see <https://www.gnu.org/licenses/>.
+//
+// The source code is available at <https://github.com/kubewg-net/container>.
+package kubewg_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kubewg-net/container/pkg/kubewg"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// dataplane keeps the programmed peers in memory.
+type dataplane struct {
+	mu    sync.Mutex
+	key   wgtypes.Key
+	peers []wgtypes.Peer
+	ups   int
+	downs int
+}
+
+func (d *dataplane) Name() string                                                { return "wg-embedded" }
+func (d *dataplane) Inspect() ([]string, error)                                  { return nil, nil }
+func (d *dataplane) PublicKey() wgtypes.Key                                      { return d.key }
+func (d *dataplane) MTU() int                                                    { return 1420 }
+func (d *dataplane) RotateKeyIfDue(_ context.Context, _ time.Time) (bool, error) { return false, nil }
+
+func (d *dataplane) Up(_ context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ups++
+	return nil
+}
+
+func (d *dataplane) Down() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.downs++
+	return nil
+}
+
+func (d *dataplane) Peers() ([]wgtypes.Peer, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]wgtypes.Peer(nil), d.peers...), nil
+}
+
+func (d *dataplane) ConfigurePeers(configs []wgtypes.PeerConfig) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.peers = d.peers[:0]
+	for _, peerConfig := range configs {
+		d.peers = append(d.peers, wgtypes.Peer{PublicKey: peerConfig.PublicKey, AllowedIPs: peerConfig.AllowedIPs})
+	}
+	return nil
+}
+
+type sink struct {
+	mu     sync.Mutex
+	events []kubewg.Event
+}
+
+func (s *sink) Publish(event kubewg.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+}
+
+func publicKey(t *testing.T) string {
+	t.Helper()
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return key.PublicKey().String()
+}
+
+func loadConfig(t *testing.T, configYAML string) *kubewg.Config {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(configYAML), 0o600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	config, err := kubewg.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return config
+}
+
+func TestNewNeedsWireGuard(t *testing.T) {
+	t.Parallel()
+
+	config := loadConfig(t, "wireguard:\n  enabled: false\n")
+	if _, err := kubewg.New(config); !errors.Is(err, kubewg.ErrWireGuardDisabled) {
+		t.Errorf("expected %v, got %v", kubewg.ErrWireGuardDisabled, err)
+	}
+}
+
+func TestApply(t *testing.T) {
+	t.Parallel()
+
+	configured := publicKey(t)
+	config := loadConfig(t, "wireguard:\n  enabled: true\n  addresses: ['10.0.0.1/24']\n  peers:\n"+
+		"    - public_key: '"+configured+"'\n      allowed_ips: ['10.0.0.2/32']\n")
+	device := &dataplane{}
+	events := &sink{}
+	engine, err := kubewg.New(config, kubewg.WithDataplane(device), kubewg.WithEventSink(events))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if engine.Dataplane() != device {
+		t.Fatal("expected the engine to use the given dataplane")
+	}
+
+	summary, err := engine.Apply(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(summary.Added) != 1 || summary.Added[0] != configured {
+		t.Errorf("expected %s to be added, got %v", configured, summary.Added)
+	}
+
+	added := publicKey(t)
+	if err := engine.Registry().Add(kubewg.Peer{PublicKey: added, AllowedIPs: []string{"10.0.0.3/32"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	summary, err = engine.Apply(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(summary.Added) != 1 || summary.Added[0] != added || summary.Unchanged != 1 {
+		t.Errorf("expected only %s to be added, got %+v", added, summary)
+	}
+
+	peers, _ := device.Peers()
+	if len(peers) != 2 || device.ups != 2 {
+		t.Errorf("expected 2 peers after 2 ups, got %d peers after %d ups", len(peers), device.ups)
+	}
+	events.mu.Lock()
+	defer events.mu.Unlock()
+	if len(events.events) != 2 || events.events[0].Type != "PeerAdded" {
+		t.Errorf("expected an event for each added peer, got %+v", events.events)
+	}
+
+	// Apply leaves the interface in place
+	if err := engine.Stop(context.Background()); err != nil || device.downs != 0 {
+		t.Errorf("expected Stop after Apply to leave the interface, got %d downs, %v", device.downs, err)
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	t.Parallel()
+
+	config := loadConfig(t, "wireguard:\n  enabled: true\n  addresses: ['10.0.0.1/24']\n")
+	device := &dataplane{}
+	engine, err := kubewg.New(config, kubewg.WithDataplane(device))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := engine.Start(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := engine.Stop(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	device.mu.Lock()
+	defer device.mu.Unlock()
+	if device.ups != 1 || device.downs != 1 {
+		t.Errorf("expected one up and one down, got %d and %d", device.ups, device.downs)
+	}
+}